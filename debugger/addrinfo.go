@@ -0,0 +1,297 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// The layout of the Go heap on amd64: arenas of heapArenaBytes, indexed
+// from arenaBaseOffset, whose pages map to the spans holding them.
+const (
+	arenaBaseOffset = 0xffff800000000000
+	heapPageSize    = 8192
+	// mallocHeaderMin is the smallest object of a small span that starts
+	// with a pointer to its type.
+	mallocHeaderMin = 512
+)
+
+// The states of a runtime.mspan that hold memory.
+const (
+	spanInUse  = 1
+	spanManual = 2
+)
+
+// addrNotes describes addr in the process stopped at pid, a line for each
+// thing it is part of: its mapping, then the function, package level
+// variable, heap object or goroutine stack it belongs to.
+func (d *Debugger) addrNotes(pid int, addr uint64) []string {
+	var notes []string
+	mapped := false
+	if ms, err := procMappings(d.Pid); err == nil {
+		for _, m := range ms {
+			if addr < m.start || addr >= m.end {
+				continue
+			}
+			what := "anonymous memory"
+			switch {
+			case m.inode != 0:
+				what = fmt.Sprintf("%s at offset %#x", m.path, m.off+addr-m.start)
+			case m.path != "":
+				what = m.path
+			}
+			notes = append(notes, fmt.Sprintf("mapping  %#x-%#x %s, %s", m.start, m.end, m.perms, what))
+			mapped = true
+		}
+	}
+	if !mapped {
+		return append(notes, "mapping  none; the address isn't mapped")
+	}
+
+	if fn := d.pcToFunc(addr); fn != nil {
+		l := d.LookupPC(addr)
+		notes = append(notes, fmt.Sprintf("function %s+%#x, line %d of %s", fn.Name, addr-fn.Entry, l.Line, l.File))
+		return notes
+	}
+	if s := d.globalAt(addr); s != "" {
+		notes = append(notes, "global   "+s)
+	}
+	if s := d.heapObjectAt(pid, addr); s != "" {
+		notes = append(notes, "heap     "+s)
+	}
+	if s := d.stackFrameAt(pid, addr); s != "" {
+		notes = append(notes, "stack    "+s)
+	}
+	return notes
+}
+
+// globalAt describes the package level variable, or failing a type for it
+// the symbol, that addr is in, or returns "". Only addresses before the
+// end of the executable's data, runtime.end, are looked up.
+func (d *Debugger) globalAt(addr uint64) string {
+	end, ok := d.Symbols["runtime.end"]
+	if !ok || addr >= end {
+		return ""
+	}
+	var name string
+	var start uint64
+	for n, a := range d.Symbols {
+		if a <= addr && a >= start && a != 0 {
+			// Of the names of an address, the first in order, so that the
+			// result doesn't depend on the map's.
+			if a == start && n > name {
+				continue
+			}
+			name, start = n, a
+		}
+	}
+	if name == "" {
+		return ""
+	}
+	_, typ, err := d.LookupGlobal(name)
+	if err != nil {
+		return fmt.Sprintf("%s+%#x", name, addr-start)
+	}
+	if addr-start >= uint64(typ.Size()) {
+		return ""
+	}
+	return fmt.Sprintf("%s+%#x, of type %s (%d bytes)", name, addr-start, typeName(typ), typ.Size())
+}
+
+// heapObjectAt describes the heap object addr is in, found through the
+// span runtime.mheap_ maps its page to, or returns "". The type of the
+// object is known when the runtime recorded it: in the span of a large
+// object, or in the header of a small one of more than mallocHeaderMin
+// bytes that holds pointers.
+func (d *Debugger) heapObjectAt(pid int, addr uint64) string {
+	span, spanType, err := d.spanOf(pid, addr)
+	if err != nil || span == 0 {
+		return ""
+	}
+	// A field of the span, as wide as it is; the state is a box around an
+	// atomic.Uint8.
+	field := func(name string) uint64 {
+		off, err1 := fieldOffset(spanType, []string{name})
+		typ, err2 := fieldType(spanType, name)
+		if err1 != nil || err2 != nil {
+			return 0
+		}
+		n, _ := d.readUint(pid, span+uint64(off), min(typ.Size(), 8))
+		return n
+	}
+	start, npages, elemsize := field("startAddr"), field("npages"), field("elemsize")
+	end := start + npages*heapPageSize
+	state := field("state")
+	if addr < start || addr >= end {
+		return ""
+	}
+	if state == spanManual {
+		return fmt.Sprintf("span %#x-%#x of stacks or other manually managed memory", start, end)
+	}
+	if state != spanInUse || elemsize == 0 {
+		return fmt.Sprintf("span %#x-%#x, free", start, end)
+	}
+
+	class := field("spanclass")
+	index := (addr - start) / elemsize
+	base := start + index*elemsize
+	if base+elemsize > end {
+		return fmt.Sprintf("span %#x-%#x, past its last object", start, end)
+	}
+	allocated := index < field("freeindex")
+	if bits := field("allocBits"); !allocated && bits != 0 {
+		b, err := d.readUint(pid, bits+index/8, 1)
+		allocated = err == nil && b&(1<<(index%8)) != 0
+	}
+
+	var typ dwarf.Type
+	noscan := class&1 != 0
+	size := elemsize
+	switch {
+	case noscan:
+	case class>>1 == 0:
+		if t := field("largeType"); t != 0 {
+			typ, _ = d.runtimeTypeOf(t)
+		}
+	case elemsize > mallocHeaderMin:
+		// The object follows its header.
+		if t, err := d.readUint(pid, base, 8); err == nil && t != 0 {
+			typ, _ = d.runtimeTypeOf(t)
+		}
+		base, size = base+8, size-8
+	}
+
+	var b strings.Builder
+	if addr < base {
+		fmt.Fprintf(&b, "header of object %#x-%#x (%d bytes)", base, base+size, size)
+	} else {
+		fmt.Fprintf(&b, "object %#x-%#x (%d bytes), at offset %#x", base, base+size, size, addr-base)
+	}
+	switch {
+	case typ != nil:
+		fmt.Fprintf(&b, ", of type %s", typeName(typ))
+	case noscan:
+		b.WriteString(", without pointers")
+	}
+	if !allocated {
+		b.WriteString(", free")
+	}
+	fmt.Fprintf(&b, "; span %#x-%#x of size class %d", start, end, class>>1)
+	return b.String()
+}
+
+// spanOf returns the address of the runtime.mspan whose pages hold addr,
+// and its type, or 0 for memory outside the heap. The arenas of the heap
+// are indexed in two levels, runtime.mheap_.arenas[l1][l2], each holding
+// the span of each of its pages.
+func (d *Debugger) spanOf(pid int, addr uint64) (uint64, dwarf.Type, error) {
+	heap, heapType, err := d.LookupGlobal("runtime.mheap_")
+	if err != nil {
+		return 0, nil, err
+	}
+	arenasOff, err := fieldOffset(heapType, []string{"arenas"})
+	if err != nil {
+		return 0, nil, err
+	}
+	l1Type, err := fieldType(heapType, "arenas")
+	if err != nil {
+		return 0, nil, err
+	}
+	l1, ok := stripTypedefs(l1Type).(*dwarf.ArrayType)
+	if !ok {
+		return 0, nil, fmt.Errorf("runtime.mheap_.arenas has unexpected type %s", l1Type)
+	}
+	l2Type, err := ptrPointee(l1.Type)
+	if err != nil {
+		return 0, nil, err
+	}
+	l2, ok := stripTypedefs(l2Type).(*dwarf.ArrayType)
+	if !ok {
+		return 0, nil, fmt.Errorf("runtime.mheap_.arenas has unexpected type %s", l1Type)
+	}
+	arenaType, err := ptrPointee(l2.Type)
+	if err != nil {
+		return 0, nil, err
+	}
+	spansOff, err := fieldOffset(arenaType, []string{"spans"})
+	if err != nil {
+		return 0, nil, err
+	}
+	spansType, err := fieldType(arenaType, "spans")
+	if err != nil {
+		return 0, nil, err
+	}
+	spans, ok := stripTypedefs(spansType).(*dwarf.ArrayType)
+	if !ok || spans.Count <= 0 || l2.Count <= 0 {
+		return 0, nil, fmt.Errorf("runtime.heapArena.spans has unexpected type %s", spansType)
+	}
+	spanType, err := ptrPointee(spans.Type)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	arenaBytes := uint64(spans.Count) * heapPageSize
+	ri := (addr - arenaBaseOffset) / arenaBytes
+	i1, i2 := ri/uint64(l2.Count), ri%uint64(l2.Count)
+	if i1 >= uint64(max(l1.Count, 0)) {
+		return 0, nil, nil
+	}
+	l2Addr, err := d.readUint(pid, heap+uint64(arenasOff)+i1*8, 8)
+	if err != nil || l2Addr == 0 {
+		return 0, nil, err
+	}
+	arena, err := d.readUint(pid, l2Addr+i2*8, 8)
+	if err != nil || arena == 0 {
+		return 0, nil, err
+	}
+	page := addr / heapPageSize % uint64(spans.Count)
+	span, err := d.readUint(pid, arena+uint64(spansOff)+page*8, 8)
+	return span, spanType, err
+}
+
+// stackFrameAt describes the goroutine stack addr is on and the frame it is
+// in, or returns "". A goroutine running on another thread can't be
+// unwound, so only its stack is named.
+func (d *Debugger) stackFrameAt(pid int, addr uint64) string {
+	gs, err := d.Goroutines(pid)
+	if err != nil {
+		return ""
+	}
+	i := slices.IndexFunc(gs, func(g Goroutine) bool { return g.stackLo <= addr && addr < g.stackHi })
+	if i < 0 {
+		return ""
+	}
+	g := gs[i]
+	where := fmt.Sprintf("goroutine %d, %#x-%#x", g.ID, g.stackLo, g.stackHi)
+	if g.Running {
+		return where + ", running on another thread"
+	}
+	if addr < g.SP {
+		return where + ", below its stack pointer, unused"
+	}
+	frames, _ := d.GoroutineStack(pid, g, maxGoroutineFrames)
+	for n := len(frames) - 1; n >= 0; n-- {
+		if frames[n].SP <= addr {
+			return fmt.Sprintf("%s, frame %d, %s, at sp+%#x", where, n, frames[n], addr-frames[n].SP)
+		}
+	}
+	return where
+}
+
+// infoAddrCommand handles "info addr <address>", which tells what the
+// address is: its mapping, and the function, global, heap object or
+// goroutine stack it is part of.
+func (d *Debugger) infoAddrCommand(pid int, expr string) {
+	addr, err := d.examineAddr(pid, expr)
+	if err != nil {
+		d.println(err)
+		return
+	}
+	d.printf("%#x:\n", addr)
+	for _, note := range d.addrNotes(pid, addr) {
+		d.printf("  %s\n", note)
+	}
+}
@@ -0,0 +1,101 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"errors"
+	"syscall"
+)
+
+// heldEvent is an event a thread reported while it was being stopped with
+// the others, handled by the event loop once the target resumes.
+type heldEvent struct {
+	tid int
+	ws  syscall.WaitStatus
+}
+
+// stopThreads stops every thread of the target and of the processes it
+// forked other than pid, which stopped at the prompt, so nothing changes
+// while the user looks and no thread runs over a trap lifted to step
+// pid over it. Threads stay stopped until resumeThreads. With NonStop,
+// they run on.
+func (d *Debugger) stopThreads(pid int) {
+	if d.NonStop {
+		return
+	}
+	var stopping []*tracee
+	for tid, t := range d.tracees {
+		// A thread yet to start is stopped until its first stop is
+		// handled, and one with an event kept until it is.
+		if tid == pid || t.held || t.starting || t.stopPending || t.proc == 0 {
+			continue
+		}
+		if syscall.Tgkill(t.proc, tid, syscall.SIGSTOP) == nil {
+			stopping = append(stopping, t)
+		}
+	}
+	for _, t := range stopping {
+		d.holdThread(t)
+	}
+}
+
+// holdThread waits for the SIGSTOP stopThreads sent t. A breakpoint it hits
+// first is rewound, to be hit again once resumed; any other event is kept
+// for the event loop, and the SIGSTOP, still to come, is then swallowed
+// by traceeStop.
+func (d *Debugger) holdThread(t *tracee) {
+	for {
+		var ws syscall.WaitStatus
+		if _, err := d.proc().Wait(t.tid, &ws, nil); err != nil {
+			// Gone, its exit reaped with another's.
+			return
+		}
+		if ws.Stopped() && ws.StopSignal() == syscall.SIGSTOP {
+			t.held = true
+			return
+		}
+		if ws.Stopped() && ws.StopSignal() == syscall.SIGTRAP && ws.TrapCause() == 0 && d.hitTrap(t.tid) {
+			var regs syscall.PtraceRegs
+			if d.proc().GetRegs(t.tid, &regs) == nil {
+				if addr := d.Arch.BreakpointAddr(regs.Rip); d.traps[addr] != nil {
+					regs.Rip = addr
+					if d.proc().SetRegs(t.tid, &regs) == nil && d.proc().Cont(t.tid, 0) == nil {
+						continue
+					}
+				}
+			}
+		}
+		d.heldEvents = append(d.heldEvents, heldEvent{t.tid, ws})
+		t.stopPending = ws.Stopped()
+		return
+	}
+}
+
+// resumeThreads continues the threads stopThreads stopped, after pid was
+// resumed, so a trap pid steps over is back in place before they run.
+// Those with an event kept for the event loop stay stopped until it is
+// handled.
+func (d *Debugger) resumeThreads() {
+	for tid, t := range d.tracees {
+		if !t.held {
+			continue
+		}
+		t.held = false
+		// Gone already if pid ended the process as it resumed.
+		if err := d.proc().Cont(tid, 0); err != nil && !errors.Is(err, syscall.ESRCH) {
+			d.println(wrapPtrace("continuing", tid, err))
+		}
+	}
+}
+
+// nextHeldEvent returns the oldest event kept by holdThread into d.Ws, if
+// there is one.
+func (d *Debugger) nextHeldEvent() (int, bool) {
+	if len(d.heldEvents) == 0 {
+		return 0, false
+	}
+	ev := d.heldEvents[0]
+	d.heldEvents = d.heldEvents[1:]
+	d.Ws = ev.ws
+	return ev.tid, true
+}
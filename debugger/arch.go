@@ -0,0 +1,127 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Arch describes how breakpoints work on a target architecture. Ports add a
+// description and return it from hostArch in an arch_<goarch>.go file.
+type Arch struct {
+	Name string
+	// TrapCode is the instruction written over a breakpoint location. It
+	// may be several bytes long; the bytes under it are saved and restored
+	// as a whole.
+	TrapCode []byte
+	// TrapPCOffset is how far past the breakpoint the PC is reported after
+	// the trap: 1 on x86, where INT3 has already executed, 0 on arm64,
+	// where the PC is left on the BRK.
+	TrapPCOffset uint64
+	// InstrAlign is the alignment of instruction addresses, 1 for variable
+	// length encodings.
+	InstrAlign uint64
+	// TrapSigCode is the si_code of the SIGTRAP a trap raises, which tells
+	// it from single steps.
+	TrapSigCode int32
+}
+
+// AMD64 is the x86-64 architecture.
+var AMD64 = &Arch{
+	Name:         "amd64",
+	TrapCode:     []byte{0xCC},
+	TrapPCOffset: 1,
+	InstrAlign:   1,
+	TrapSigCode:  0x80, // SI_KERNEL
+}
+
+// ARM64 is the 64-bit ARM architecture. Its trap is BRK #0, a whole
+// instruction, so traps never overlap.
+var ARM64 = &Arch{
+	Name:         "arm64",
+	TrapCode:     []byte{0x00, 0x00, 0x20, 0xd4},
+	TrapPCOffset: 0,
+	InstrAlign:   4,
+	TrapSigCode:  1, // TRAP_BRKPT
+}
+
+// BreakpointAddr returns the address of the breakpoint that reported a trap
+// at pc.
+func (a *Arch) BreakpointAddr(pc uint64) uint64 {
+	return pc - a.TrapPCOffset
+}
+
+// CheckTrapAddr reports whether a trap can be written at addr.
+func (a *Arch) CheckTrapAddr(addr uint64) error {
+	if addr%a.InstrAlign != 0 {
+		return fmt.Errorf("%#x is not a %s instruction address", addr, a.Name)
+	}
+	return nil
+}
+
+// trapOverlap returns the trap whose bytes a trap at addr would share, if
+// any. A trap longer than the shortest instruction, on a variable length
+// encoding, could be written over part of another, and restoring either
+// would then bring back the other's trap as original code.
+func (d *Debugger) trapOverlap(addr uint64) *trapSite {
+	n := uint64(len(d.Arch.TrapCode))
+	if n == 1 {
+		return nil
+	}
+	for _, site := range d.traps {
+		if site.addr != addr && site.users > 0 && site.addr < addr+n && addr < site.addr+n {
+			return site
+		}
+	}
+	return nil
+}
+
+// trapAddr returns the address of the breakpoint the current thread stopped
+// on, assuming it stopped on one.
+func (d *Debugger) trapAddr() uint64 {
+	return d.Arch.BreakpointAddr(d.Regs.Rip)
+}
+
+// hitTrap reports whether the SIGTRAP pid stopped with was raised by a trap
+// instruction.
+func (d *Debugger) hitTrap(pid int) bool {
+	code, ok := d.proc().SigCode(pid)
+	return ok && code == d.Arch.TrapSigCode
+}
+
+// sigCode returns the si_code of the signal pid is stopped with.
+func sigCode(pid int) (int32, bool) {
+	// siginfo_t starts with si_signo, si_errno and si_code.
+	var info [128]byte
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_GETSIGINFO, uintptr(pid), 0, uintptr(unsafe.Pointer(&info[0])), 0, 0)
+	if errno != 0 {
+		return 0, false
+	}
+	return int32(binary.LittleEndian.Uint32(info[8:])), true
+}
+
+// writeTrap writes a trap over orig at addr and reads it back. On failure
+// orig is restored.
+func (d *Debugger) writeTrap(pid int, addr uint64, orig []byte) error {
+	if err := d.writeCode(pid, addr, d.Arch.TrapCode); err != nil {
+		d.writeCode(pid, addr, orig)
+		return err
+	}
+	return nil
+}
+
+// rearmTrap re-arms the trap at d.rearmAt once pid has stepped off it.
+func (d *Debugger) rearmTrap(pid int) {
+	d.rearm = false
+	site := d.armedTrap(d.rearmAt)
+	if site == nil {
+		return
+	}
+	if err := d.writeTrap(pid, site.addr, site.orig); err != nil {
+		site.armed = false
+		d.printf("Can't re-arm the breakpoint at %#x: %v\n", site.addr, err)
+	}
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package debugger
+
+import "syscall"
+
+// sysProcessVMReadv is process_vm_readv(2), which the syscall package
+// doesn't name.
+const sysProcessVMReadv = 310
+
+// debugRegOffset is the offset of the debug registers, u_debugreg, in the
+// user area PTRACE_PEEKUSER and PTRACE_POKEUSER access.
+const debugRegOffset = 848
+
+func hostArch() *Arch {
+	return AMD64
+}
+
+// threadPointer returns the thread pointer of a thread stopped with regs,
+// FS, where its TLS block ends. PTRACE_GETREGS reads its base along with
+// the other registers, sparing an ARCH_GET_FS; it is 0 until the thread
+// has set it.
+func threadPointer(regs *syscall.PtraceRegs) uint64 {
+	return regs.Fs_base
+}
+
+// gRegister returns the register Go code with the register ABI keeps g in,
+// R14. The arm64 port returns R28, which the runtime dedicates to g; it
+// needs no TLS.
+func gRegister(regs *syscall.PtraceRegs) uint64 {
+	return regs.R14
+}
+
+// syscallInsn is the instruction that makes a system call, SYSCALL.
+var syscallInsn = []byte{0x0f, 0x05}
+
+// setSyscallRegs sets regs up to make the system call nr with args from the
+// instruction at pc: the number in RAX and up to six arguments in RDI, RSI,
+// RDX, R10, R8 and R9. ORIG_RAX is cleared, so the kernel doesn't restart
+// a call the thread was stopped in instead.
+func setSyscallRegs(regs *syscall.PtraceRegs, pc, nr uint64, args []uint64) {
+	regs.Rip, regs.Rax, regs.Orig_rax = pc, nr, ^uint64(0)
+	for i, r := range []*uint64{&regs.Rdi, &regs.Rsi, &regs.Rdx, &regs.R10, &regs.R8, &regs.R9} {
+		if i < len(args) {
+			*r = args[i]
+		}
+	}
+}
+
+// syscallResult returns the result of a system call made with regs, or the
+// errno it failed with.
+func syscallResult(regs *syscall.PtraceRegs) (uint64, error) {
+	if ret := int64(regs.Rax); ret < 0 && ret >= -4095 {
+		return 0, syscall.Errno(-ret)
+	}
+	return regs.Rax, nil
+}
@@ -0,0 +1,359 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArtifactStore keeps the files a session leaves behind, its recording,
+// saved session, snapshots, cores and transcripts, somewhere that outlives
+// the host the debugger runs on.
+type ArtifactStore interface {
+	// Put stores the file at path as name, which may hold slashes, and
+	// returns where it went.
+	Put(name, path string) (string, error)
+}
+
+// OpenArtifactStore returns the store dest names: "s3://bucket/prefix",
+// "gs://bucket/prefix", or a directory, given as "file:///dir" or a path.
+// The credentials of a bucket come from the environment, as the cloud's
+// own tools find them.
+func OpenArtifactStore(dest string) (ArtifactStore, error) {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme == "" || u.Scheme == "file" {
+		dir := dest
+		if err == nil && u.Scheme == "file" {
+			dir = u.Path
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("no directory in %q", dest)
+		}
+		return localStore{dir}, nil
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("no bucket in %q", dest)
+	}
+	client := &http.Client{Timeout: 10 * time.Minute}
+	switch u.Scheme {
+	case "s3":
+		s := &s3Store{
+			bucket:   u.Host,
+			prefix:   prefix,
+			region:   firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+			endpoint: firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+			keyID:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secret:   os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			token:    os.Getenv("AWS_SESSION_TOKEN"),
+			client:   client,
+		}
+		if s.keyID == "" || s.secret == "" {
+			return nil, fmt.Errorf("%s needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY in the environment", dest)
+		}
+		if s.region == "" {
+			s.region = "us-east-1"
+		}
+		return s, nil
+	case "gs":
+		g := &gcsStore{bucket: u.Host, prefix: prefix, endpoint: "https://storage.googleapis.com", client: client}
+		// The emulator takes requests without credentials.
+		if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+			g.endpoint, g.emulator = strings.TrimSuffix(host, "/"), true
+			if !strings.Contains(g.endpoint, "://") {
+				g.endpoint = "http://" + g.endpoint
+			}
+		}
+		return g, nil
+	}
+	return nil, fmt.Errorf("unknown artifact store %q, want a directory, s3://bucket/prefix or gs://bucket/prefix", dest)
+}
+
+// firstEnv returns the first of the environment variables that is set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// localStore copies artifacts into a directory, a mounted volume, say.
+type localStore struct {
+	dir string
+}
+
+func (s localStore) Put(name, path string) (string, error) {
+	dest := filepath.Join(s.dir, filepath.FromSlash(name))
+	if abs, err := filepath.Abs(path); err == nil && abs == dest {
+		return dest, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	f, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return dest, err
+}
+
+// s3Store puts artifacts in an S3 bucket, or one of a compatible service
+// at endpoint, addressed by path, with requests signed by Signature
+// Version 4.
+type s3Store struct {
+	bucket, prefix   string
+	region, endpoint string
+	keyID, secret    string
+	token            string // of temporary credentials
+	client           *http.Client
+}
+
+func (s *s3Store) Put(name, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	// The signature covers the hash of the body, read once to hash it and
+	// again to send it.
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	key := s.prefix + name
+	u := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, awsEscape(key))
+	if s.endpoint != "" {
+		u = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.endpoint, "/"), s.bucket, awsEscape(key))
+	}
+	req, err := http.NewRequest(http.MethodPut, u, f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = n
+	s.sign(req, hex.EncodeToString(h.Sum(nil)), time.Now())
+	if err := doStore(s.client, req); err != nil {
+		return "", err
+	}
+	return "s3://" + s.bucket + "/" + key, nil
+}
+
+// sign adds the headers of Signature Version 4 to req, whose body hashes to
+// payloadHash, signing all its headers.
+func (s *s3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", now.Format("20060102T150405Z"))
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("x-amz-security-token", s.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", req.Method, req.URL.EscapedPath(), req.URL.RawQuery)
+	for _, k := range names {
+		fmt.Fprintf(&canonical, "%s:%s\n", k, headers[k])
+	}
+	signed := strings.Join(names, ";")
+	fmt.Fprintf(&canonical, "\n%s\n%s", signed, payloadHash)
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + req.Header.Get("x-amz-date") + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + s.secret)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.keyID, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// awsEscape escapes the key of an object as Signature Version 4 wants it:
+// all but the unreserved characters, keeping the slashes.
+func awsEscape(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// gcsStore puts artifacts in a Google Cloud Storage bucket with the JSON
+// API's simple upload.
+type gcsStore struct {
+	bucket, prefix string
+	endpoint       string
+	emulator       bool
+	client         *http.Client
+}
+
+func (s *gcsStore) Put(name, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	key := s.prefix + name
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", s.endpoint, url.PathEscape(s.bucket), url.QueryEscape(key))
+	req, err := http.NewRequest(http.MethodPost, u, f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = fi.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if !s.emulator {
+		token, err := s.accessToken()
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if err := doStore(s.client, req); err != nil {
+		return "", err
+	}
+	return "gs://" + s.bucket + "/" + key, nil
+}
+
+// gcsMetadataToken is where a Google Cloud VM or pod gets a token for its
+// service account.
+const gcsMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// accessToken returns the OAuth token of GOOGLE_OAUTH_ACCESS_TOKEN, or else
+// that of the service account of the instance, from its metadata server.
+func (s *gcsStore) accessToken() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, gcsMetadataToken, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("no GOOGLE_OAUTH_ACCESS_TOKEN, and no metadata server: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", fmt.Errorf("metadata server: %s", resp.Status)
+	}
+	return body.AccessToken, nil
+}
+
+// doStore sends the upload req, turning a status other than success into
+// an error with the start of the service's explanation.
+func doStore(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// storeArtifact puts the file the session wrote at path in d.Artifacts, if
+// set, under a directory of the session, and tells where.
+func (d *Debugger) storeArtifact(path string) {
+	if d.Artifacts == nil {
+		return
+	}
+	where, err := d.putArtifact(path)
+	if err != nil {
+		d.printf("Can't store %s: %v\n", path, err)
+		return
+	}
+	d.printf("Stored %s at %s\n", path, where)
+}
+
+// putArtifact puts the file at path in d.Artifacts, under the directory of
+// the session, named on first use. It can be called off the event loop.
+func (d *Debugger) putArtifact(path string) (string, error) {
+	d.artifactMu.Lock()
+	if d.artifactDir == "" {
+		name := filepath.Base(d.target)
+		if d.target == "" {
+			name = fmt.Sprintf("pid%d", d.Pid)
+		}
+		start := d.stats.start
+		if start.IsZero() {
+			start = time.Now()
+		}
+		d.artifactDir = fmt.Sprintf("%s-%s-%d", name, start.UTC().Format("20060102T150405Z"), os.Getpid())
+	}
+	dir := d.artifactDir
+	d.artifactMu.Unlock()
+	return d.Artifacts.Put(dir+"/"+filepath.Base(path), path)
+}
+
+// storeRecording stores the -record file at the end of the session, or
+// of a run that is restarted.
+func (d *Debugger) storeRecording() {
+	if d.recordFile == nil {
+		return
+	}
+	d.recordFile.Sync()
+	d.storeArtifact(d.recordFile.Name())
+}
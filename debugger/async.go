@@ -0,0 +1,246 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// AsyncFrontend is a Frontend that also takes commands while the target
+// runs. The engine reads them on another goroutine; a read still waiting
+// when the target stops delivers the command for the stop.
+type AsyncFrontend interface {
+	Frontend
+	// Async reports whether commands can be read while the target runs.
+	Async() bool
+	// PromptRunning reads the next command while the target runs. It
+	// returns false at the end of input.
+	PromptRunning() (string, bool)
+}
+
+// runningPrompt is the prompt while the target runs: interrupt, or i, stops
+// it.
+const runningPrompt = "\n(running) > "
+
+// Async reports whether the prompt is on a terminal. A piped script has
+// its commands for the next stops read ahead, so it isn't asynchronous.
+func (f *LineFrontend) Async() bool {
+	return f.interactive
+}
+
+func (f *LineFrontend) PromptRunning() (string, bool) {
+	return f.readLine(runningPrompt, true)
+}
+
+// promptInput is a command read by a background prompt.
+type promptInput struct {
+	text string
+	ok   bool
+}
+
+// runningCommands are the word commands that run right away while the
+// target runs, because they don't touch it through ptrace. Other commands
+// are queued and applied at the next stop, which is forced with SIGSTOP.
+var runningCommands = map[string]bool{
+	"alias":       true,
+	"breakpoints": true,
+	"define":      true,
+	"files":       true,
+	"funcs":       true,
+	"help":        true,
+	"history":     true,
+	"info":        true,
+	"maps":        true,
+}
+
+// asyncUI returns the frontend if it takes commands while the target runs.
+func (d *Debugger) asyncUI() AsyncFrontend {
+	if ui, ok := d.UI.(AsyncFrontend); ok && ui.Async() {
+		return ui
+	}
+	return nil
+}
+
+// nextCommand returns the next command at a stop, which may have been
+// typed while the target was running.
+func (d *Debugger) nextCommand() (string, bool) {
+	if !d.reading {
+		return d.UI.PromptCommand("")
+	}
+	in := <-d.input
+	d.reading = false
+	return in.text, in.ok
+}
+
+// waitTarget waits for the next event of a thread in the process group pgid
+// into d.Ws. With an asynchronous frontend, commands typed meanwhile are
+// handled as they arrive, and with WaitTimeout the watchdog checks on stops
+// that are slow to come; the wait then runs on another thread, which the
+// kernel allows for threads of the tracer.
+func (d *Debugger) waitTarget(pid, pgid int, ru *syscall.Rusage) (int, error) {
+	ui := d.asyncUI()
+	if ui == nil && d.WaitTimeout <= 0 {
+		wpid, err := d.proc().Wait(-pgid, &d.Ws, ru)
+		d.stopArrived(wpid, d.Ws)
+		return wpid, err
+	}
+
+	type result struct {
+		wpid int
+		ws   syscall.WaitStatus
+		ru   syscall.Rusage
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		r.wpid, r.err = d.proc().Wait(-pgid, &r.ws, &r.ru)
+		done <- r
+	}()
+
+	var tick <-chan time.Time
+	if d.WaitTimeout > 0 {
+		t := time.NewTicker(min(d.WaitTimeout, time.Second))
+		defer t.Stop()
+		tick = t.C
+	}
+	var input chan promptInput
+	if ui != nil {
+		if d.input == nil {
+			d.input = make(chan promptInput, 1)
+		}
+		input = d.input
+	}
+	for {
+		if ui != nil && !d.reading {
+			d.reading = true
+			go func() {
+				text, ok := ui.PromptRunning()
+				d.input <- promptInput{text, ok}
+			}()
+		}
+		select {
+		case r := <-done:
+			d.Ws, *ru = r.ws, r.ru
+			d.stopArrived(r.wpid, r.ws)
+			return r.wpid, r.err
+		case in := <-input:
+			d.reading = false
+			d.runningCommand(pid, in)
+		case <-tick:
+			d.checkStall(pid, ui != nil)
+		}
+		if d.quitting {
+			return 0, ErrQuit
+		}
+	}
+}
+
+// runningCommand handles a command typed while the target runs.
+func (d *Debugger) runningCommand(pid int, in promptInput) {
+	if !in.ok {
+		d.quit(pid)
+		return
+	}
+	input := strings.TrimSpace(in.text)
+	if strings.EqualFold(input, "i") || strings.EqualFold(input, "interrupt") {
+		d.stopRequested = true
+		d.interrupt(pid)
+		return
+	}
+	fields := strings.Fields(d.expandAlias(input))
+	if len(fields) == 0 {
+		return
+	}
+	switch name := strings.ToLower(fields[0]); {
+	case d.stalled && name == "kill":
+		d.killStalled()
+	case d.stalled && name == "detach":
+		// The stop detach would wait for may never come.
+		d.detachCommand(pid, nil)
+	case name == "break" && len(fields) == 1:
+		if loc, ok := d.promptLocation(pid); ok {
+			d.queueCommand(pid, "break "+loc)
+		}
+	case name == "quit":
+		if d.UI.Confirm("The target is still running. Quit anyway?") {
+			d.quit(pid)
+		}
+	case resumeCommands[name] != nil:
+		d.println("The target is running, interrupt it first")
+	default:
+		if !d.isCommand(name) {
+			d.printf("Unexpected input %s\n", input)
+			return
+		}
+		if runningCommands[name] {
+			d.wordCommand(pid, input)
+			return
+		}
+		d.queueCommand(pid, input)
+	}
+}
+
+// queueCommand applies the word command input at the next stop and forces
+// one.
+func (d *Debugger) queueCommand(pid int, input string) {
+	d.pending = append(d.pending, input)
+	d.interrupt(pid)
+}
+
+// interrupt stops the target with SIGSTOP, which interruptStop picks up.
+func (d *Debugger) interrupt(pid int) {
+	if err := syscall.Kill(pid, syscall.SIGSTOP); err != nil {
+		d.printf("Can't interrupt the target: %v\n", err)
+		return
+	}
+	d.interrupts++
+	d.awaitStop(0)
+}
+
+// applyPending runs the commands queued while the target ran, now that
+// the thread pid is stopped.
+func (d *Debugger) applyPending(pid int) {
+	for _, input := range d.pending {
+		d.wordCommand(pid, input)
+	}
+	d.pending = nil
+}
+
+// interruptStop handles the SIGSTOPs sent by interrupt. Queued commands are
+// applied and the target resumed, unless the user asked to stop it.
+func (d *Debugger) interruptStop(pid int) (string, bool, error) {
+	if d.Ws.StopSignal() != syscall.SIGSTOP || d.interrupts == 0 {
+		return "", false, nil
+	}
+	d.interrupts--
+	if err := d.proc().GetRegs(pid, &d.Regs); err != nil {
+		return "", true, wrapPtrace("reading registers of", pid, err)
+	}
+	if d.stopRequested {
+		if d.SafePoints {
+			if err := d.rollToSafePoint(pid); err != nil {
+				return "", true, err
+			}
+		}
+		return "interrupt", true, nil
+	}
+	d.applyPending(pid)
+	return "", true, d.resume(pid, true)
+}
+
+// mapsCommand handles "maps", which prints the memory mappings of the
+// target. It works while the target runs.
+func (d *Debugger) mapsCommand(pid int, args []string) bool {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	d.UI.ShowOutput(string(b))
+	return false
+}
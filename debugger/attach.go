@@ -0,0 +1,357 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/buildinfo"
+	"debug/elf"
+	"fmt"
+	"go/version"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Attach debugs the running process pid, as RunTarget does a process it
+// starts. Every thread is attached with PTRACE_ATTACH and symbols are read
+// from /proc/<pid>/exe, or the binary of the systemd unit given with -unit.
+// The main thread stops at the prompt while the others run on. The session
+// lasts until the target exits or the user detaches.
+func (d *Debugger) Attach(pid int) error {
+	return d.Debug(&AttachedTarget{Pid: pid})
+}
+
+// Open attaches to the process and stops its main thread.
+func (t *AttachedTarget) Open(d *Debugger) (int, int, error) {
+	pid := t.Pid
+	target, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't find the executable of %d: %v", pid, err)
+	}
+	d.target = target
+	if d.SymTable, err = d.GetSymbolTable(d.attachExe(pid)); err != nil {
+		return 0, 0, err
+	}
+	if err := d.relocate(pid); err != nil {
+		return 0, 0, err
+	}
+	if fn := d.SymTable.LookupFunc("main.main"); fn != nil {
+		d.TargetFile, _, d.Fn = d.SymTable.PCToLine(fn.Entry)
+	}
+
+	start := time.Now()
+	tids, err := d.attachThreads(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+	d.Pid = pid
+	d.stats.start = time.Now()
+	d.handleSignals()
+
+	for _, tid := range tids {
+		if tid != pid {
+			if err := syscall.PtraceCont(tid, 0); err != nil {
+				return 0, 0, wrapPtrace("continuing", tid, err)
+			}
+		}
+	}
+	d.printf("Attached to %d (%s), %d threads stopped for %v\n", pid, target, len(tids), time.Since(start).Round(time.Microsecond))
+	if d.ReadOnly {
+		d.println("Read-only: memory and registers can be read while stopped, but no breakpoint or watchpoint set")
+	}
+
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+	return pid, pgid, nil
+}
+
+// attachThreads attaches to every thread of pid and waits for each to stop.
+// Threads started meanwhile are picked up by listing the threads again until
+// no new one shows up. It returns the attached threads, all stopped. Each
+// thread is a unit of progress, as stopping hundreds takes a while.
+func (d *Debugger) attachThreads(pid int) ([]int, error) {
+	attached := make(map[int]bool)
+	var tids []int
+	var p *progress
+	for {
+		list, err := threads(pid)
+		if err != nil {
+			return nil, err
+		}
+		var fresh []int
+		for _, tid := range list {
+			if !attached[tid] {
+				fresh = append(fresh, tid)
+			}
+		}
+		if len(fresh) == 0 {
+			if p != nil {
+				p.finish()
+			}
+			return tids, nil
+		}
+		// Threads seen earlier that exited before their attach are left
+		// out of the total.
+		if total := int64(len(tids) + len(fresh)); p == nil {
+			p = d.startProgress("Attaching", total)
+		} else {
+			p.done, p.total = int64(len(tids)), total
+		}
+		for _, tid := range fresh {
+			p.phase(fmt.Sprintf("%d of %d threads", len(tids), p.total), 1)
+			if err := syscall.PtraceAttach(tid); err != nil {
+				if tid == pid {
+					return nil, fmt.Errorf("can't attach to %d: %v", pid, err)
+				}
+				continue // exited meanwhile
+			}
+			var ws syscall.WaitStatus
+			if _, err := syscall.Wait4(tid, &ws, syscall.WALL, nil); err != nil {
+				return nil, err
+			}
+			if !ws.Stopped() {
+				continue
+			}
+			if err := syscall.PtraceSetOptions(tid, traceOptions); err != nil {
+				return nil, err
+			}
+			d.addTracee(tid, pid, pid)
+			attached[tid] = true
+			tids = append(tids, tid)
+		}
+	}
+}
+
+// threads lists the thread ids of pid.
+func threads(pid int) ([]int, error) {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+	var tids []int
+	for _, e := range entries {
+		if tid, err := strconv.Atoi(e.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
+
+// release takes every trap out of the target and lets it run on without the
+// debugger: the original code is written back, threads stopped on a trap
+// hit the debugger hasn't handled yet are moved back onto the instruction,
+// and the stopped threads are detached. Threads still running are detached
+// when the debugger exits, unless data watches were set: then they are
+// stopped to clear their debug registers, which would otherwise kill the
+// target at the next write.
+func (d *Debugger) release(pid int) error {
+	if _, err := d.restoreCode(d.Pid); err != nil {
+		return err
+	}
+	traps := d.traps
+	d.traps, d.dirtyTraps, d.rearm = nil, nil, false
+	d.Breakpoints, d.step, d.lineStep = nil, nil, nil
+
+	// Threads sent a SIGSTOP, which are detached once it arrives.
+	stopping := make(map[int]bool)
+	if d.debugRegsGen > 0 {
+		d.DataWatches = nil
+		d.debugRegsGen++
+		tids, _ := threads(d.Pid)
+		for _, tid := range tids {
+			if t := d.tracees[tid]; tid != pid && (t == nil || !t.held) {
+				if syscall.Tgkill(d.Pid, tid, syscall.SIGSTOP) == nil {
+					stopping[tid] = true
+				}
+			}
+		}
+	}
+	d.syncDebugRegs(pid)
+	// Threads held stopped with pid are let go as it is.
+	for tid, t := range d.tracees {
+		if t.held {
+			t.held = false
+			d.syncDebugRegs(tid)
+			syscall.PtraceDetach(tid)
+		}
+	}
+	// Fails if pid is running, as after a quit while it ran.
+	if syscall.PtraceDetach(pid) != nil && d.debugRegsGen > 0 {
+		if syscall.Tgkill(d.Pid, pid, syscall.SIGSTOP) == nil {
+			stopping[pid] = true
+		}
+	}
+
+	for {
+		flags := syscall.WALL
+		if len(stopping) == 0 {
+			flags |= syscall.WNOHANG
+		}
+		var ws syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &ws, flags, nil)
+		if err != nil || wpid <= 0 {
+			break
+		}
+		if !ws.Stopped() {
+			delete(stopping, wpid)
+			continue
+		}
+		if ws.StopSignal() == syscall.SIGTRAP && d.hitTrap(wpid) {
+			var regs syscall.PtraceRegs
+			if syscall.PtraceGetRegs(wpid, &regs) == nil && traps[d.Arch.BreakpointAddr(regs.Rip)] != nil {
+				regs.Rip = d.Arch.BreakpointAddr(regs.Rip)
+				syscall.PtraceSetRegs(wpid, &regs)
+			}
+		}
+		d.syncDebugRegs(wpid)
+		if stopping[wpid] && ws.StopSignal() != syscall.SIGSTOP {
+			// The SIGSTOP is still to come.
+			syscall.PtraceCont(wpid, 0)
+			continue
+		}
+		delete(stopping, wpid)
+		syscall.PtraceDetach(wpid)
+	}
+	return nil
+}
+
+// detachCommand handles "detach", which removes the breakpoints from the
+// target and ends the session, leaving the target running.
+func (d *Debugger) detachCommand(pid int, args []string) bool {
+	if d.quitting {
+		return false
+	}
+	d.quitting = true
+	cpu, err := processCPUTime(pid)
+	if err != nil {
+		d.printf("Can't read the target's CPU time: %v\n", err)
+	}
+	n := len(d.Breakpoints)
+	if err := d.release(pid); err != nil {
+		d.printf("Can't remove the breakpoints: %v\n", err)
+	} else {
+		d.printf("Removed %d breakpoints and detached from %d\n", n, d.Pid)
+	}
+	d.reportStats(cpu)
+	return false
+}
+
+// minGoVersion is the oldest Go release whose binaries the debugger reads:
+// it relies on the register ABI, which keeps the goroutine in R14.
+const minGoVersion = "go1.17"
+
+// attachCostPerThread estimates how long attaching a thread takes, while
+// the threads attached before it wait. It varies from a fraction of a
+// millisecond for idle threads to ten for ones busy on a CPU.
+const attachCostPerThread = 2 * time.Millisecond
+
+// AttachReport is what CheckAttach found out about a process.
+type AttachReport struct {
+	Pid       int
+	Exe       string
+	GoVersion string // "" if it isn't a Go binary
+	Threads   int
+	// Pause estimates how long the threads are stopped while attaching.
+	// The main thread then stays stopped at the prompt.
+	Pause time.Duration
+	// Problems are the reasons attaching is unsafe or won't work.
+	Problems []string
+}
+
+// CheckAttach inspects the running process pid, without stopping it, for
+// what attaching to it would need: a Go binary of a supported version and
+// architecture, with a line table and debug information, that isn't traced
+// already and that may be traced.
+func CheckAttach(pid int) (*AttachReport, error) {
+	r := &AttachReport{Pid: pid}
+	exe := fmt.Sprintf("/proc/%d/exe", pid)
+	var err error
+	if r.Exe, err = os.Readlink(exe); err != nil {
+		return nil, fmt.Errorf("can't find the executable of %d: %v", pid, err)
+	}
+	tids, err := threads(pid)
+	if err != nil {
+		return nil, err
+	}
+	r.Threads = len(tids)
+	r.Pause = time.Duration(r.Threads) * attachCostPerThread
+
+	if info, err := buildinfo.ReadFile(exe); err != nil {
+		r.Problems = append(r.Problems, "not a Go binary, or built without build information")
+	} else {
+		r.GoVersion = info.GoVersion
+		if version.IsValid(info.GoVersion) && version.Compare(info.GoVersion, minGoVersion) < 0 {
+			r.Problems = append(r.Problems, fmt.Sprintf("built with %s, older than %s", info.GoVersion, minGoVersion))
+		}
+		for _, s := range info.Settings {
+			if s.Key == "GOARCH" && s.Value != runtime.GOARCH {
+				r.Problems = append(r.Problems, fmt.Sprintf("built for %s, not %s", s.Value, runtime.GOARCH))
+			}
+		}
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	syms, _ := f.Symbols()
+	hasPclntab := f.Section(".gopclntab") != nil
+	for _, s := range syms {
+		hasPclntab = hasPclntab || s.Name == "runtime.pclntab"
+	}
+	hasDWARF := f.Section(".debug_info") != nil || f.Section(".zdebug_info") != nil
+	switch {
+	case !hasPclntab && !hasDWARF:
+		r.Problems = append(r.Problems, "no Go line table and no DWARF: lines and functions are unknown")
+	case !hasDWARF:
+		r.Problems = append(r.Problems, "stripped of DWARF (-ldflags=-w or strip): no variables, goroutines or watches")
+	}
+
+	if tracer := procStatusField(pid, "TracerPid"); tracer != "" && tracer != "0" {
+		r.Problems = append(r.Problems, fmt.Sprintf("already traced by process %s", tracer))
+	}
+	if scope, err := os.ReadFile("/proc/sys/kernel/yama/ptrace_scope"); err == nil && os.Geteuid() != 0 {
+		if s := strings.TrimSpace(string(scope)); s != "0" {
+			r.Problems = append(r.Problems, fmt.Sprintf("kernel.yama.ptrace_scope is %s: only root may attach", s))
+		}
+	}
+	return r, nil
+}
+
+// procStatusField returns the field name of /proc/<pid>/status, or "".
+func procStatusField(pid int, name string) string {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if v, ok := strings.CutPrefix(line, name+":"); ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// String describes the report, a line per fact and problem.
+func (r *AttachReport) String() string {
+	var b strings.Builder
+	goVersion := r.GoVersion
+	if goVersion == "" {
+		goVersion = "unknown Go version"
+	}
+	fmt.Fprintf(&b, "Process %d: %s, %s, %d threads\n", r.Pid, r.Exe, goVersion, r.Threads)
+	fmt.Fprintf(&b, "  attaching stops every thread for about %v; the main thread then stays stopped at the prompt\n", r.Pause.Round(time.Microsecond))
+	if r.GoVersion != "" {
+		b.WriteString("  a garbage collection started meanwhile waits for it, stalling all goroutines until it continues\n")
+	}
+	for _, p := range r.Problems {
+		fmt.Fprintf(&b, "  problem: %s\n", p)
+	}
+	return b.String()
+}
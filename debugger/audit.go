@@ -0,0 +1,132 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// AuditRecord is an entry of the audit log: a change to the target, who
+// asked for it, and whether it failed.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Client is who asked for the change: the user at the prompt, or a
+	// server's client, as "rest 10.0.0.7:51422".
+	Client string `json:"client"`
+	// Action is "breakpoint", "delete-breakpoint", "enable-breakpoint",
+	// "disable-breakpoint", "catchpoint", "group", "watchpoint",
+	// "delete-watchpoint", "write-memory", "write-register" or "signal".
+	Action string `json:"action"`
+	Pid    int    `json:"pid,omitempty"`
+	Detail string `json:"detail"`
+	Error  string `json:"error,omitempty"`
+}
+
+// OpenAudit appends a record of every change made to the target, by the
+// prompt or by the clients of a server, to the file at path as one JSON
+// object per line. The file is only ever appended to.
+func (d *Debugger) OpenAudit(path string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	d.closeAudit()
+	d.auditLog = f
+	return nil
+}
+
+func (d *Debugger) closeAudit() {
+	if d.auditLog != nil {
+		d.auditLog.Close()
+		d.auditLog = nil
+	}
+}
+
+// audit records the change action of pid, described by detail, made for
+// client, and err if it failed. An empty client is the user at the prompt,
+// or the client of the frontend if it has one. A record that can't be
+// written is reported, so the change doesn't go unnoticed.
+func (d *Debugger) audit(client, action string, pid int, detail string, err error) {
+	if d.auditLog == nil {
+		return
+	}
+	if client == "" {
+		client = d.promptClient()
+	}
+	rec := AuditRecord{Time: time.Now(), Client: client, Action: action, Pid: pid, Detail: detail}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if err := json.NewEncoder(d.auditLog).Encode(rec); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't write the audit log: %v\n", err)
+	}
+}
+
+// promptClient names whoever types the commands: the client of a frontend
+// serving a remote one, such as the DAP adapter, or else the local user.
+func (d *Debugger) promptClient() string {
+	if ui, ok := d.UI.(interface{ Client() string }); ok {
+		if c := ui.Client(); c != "" {
+			return c
+		}
+	}
+	return localClient()
+}
+
+// localClient names the user running the debugger, and the host an SSH
+// session of theirs comes from.
+func localClient() string {
+	name := "uid " + strconv.Itoa(os.Getuid())
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if ssh := os.Getenv("SSH_CLIENT"); ssh != "" {
+		host, _, _ := strings.Cut(ssh, " ")
+		name += " from " + host
+	}
+	return name
+}
+
+// connClient names the client of a server connection by protocol and
+// remote address. Unix sockets have no useful address, so the user and
+// process of the peer name it instead.
+func connClient(proto string, conn net.Conn) string {
+	if uc, ok := conn.(*net.UnixConn); ok {
+		if raw, err := uc.SyscallConn(); err == nil {
+			var cred *syscall.Ucred
+			raw.Control(func(fd uintptr) {
+				cred, _ = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+			})
+			if cred != nil {
+				return fmt.Sprintf("%s uid %d pid %d", proto, cred.Uid, cred.Pid)
+			}
+		}
+	}
+	return proto + " " + conn.RemoteAddr().String()
+}
+
+// clientKey is the context key of the client of an HTTP request.
+type clientKey struct{}
+
+// connContext returns an http.Server ConnContext that names the client of
+// every request of a connection for requestClient.
+func connContext(proto string) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, clientKey{}, connClient(proto, c))
+	}
+}
+
+// requestClient returns the client of a request served with connContext.
+func requestClient(ctx context.Context) string {
+	c, _ := ctx.Value(clientKey{}).(string)
+	return c
+}
@@ -0,0 +1,242 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/gosym"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// benchIter is a stop at the start of an iteration of the loop of a
+// benchmark function, set with "iteration". Every call of the function, a
+// run with its own b.N, counts its iterations from 0.
+type benchIter struct {
+	fn    *gosym.Func
+	file  string
+	line  int    // the first line of the loop body
+	body  uint64 // its code
+	n     int    // the iteration to stop at, from 1
+	count int    // the iterations of the current run so far
+	b     uint64 // the *testing.B of the current run
+}
+
+// DebugBench implements "bench <package> -bench <regexp> [-iteration n]
+// [test flags]": it debugs the benchmarks of the package like DebugTest
+// does its tests, without running the tests. A pattern that names one
+// benchmark function gets a breakpoint at it, or with -iteration a stop at
+// the start of its nth iteration.
+func DebugBench(args []string) int {
+	const usage = "usage: bench <package> -bench <regexp> [-iteration n] [test flags]"
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Println(usage)
+		return 2
+	}
+	pkg := args[0]
+	var iteration string
+	var rest []string
+	for i := 1; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-iteration" && i+1 < len(args):
+			i++
+			iteration = args[i]
+		case strings.HasPrefix(arg, "-iteration="):
+			iteration = strings.TrimPrefix(arg, "-iteration=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if iteration != "" {
+		if n, err := strconv.Atoi(iteration); err != nil || n < 1 {
+			fmt.Printf("bad iteration %q\n", iteration)
+			return 2
+		}
+	}
+	buildFlags, testArgs := testFlags(rest)
+	bench := flagValue(testArgs, "-test.bench")
+	if bench == "" {
+		fmt.Println(usage)
+		return 2
+	}
+	if flagValue(testArgs, "-test.run") == "" {
+		testArgs = append([]string{"-test.run=^$"}, testArgs...)
+	}
+
+	var ex []string
+	switch fn := singleFunc(bench); {
+	case fn != "" && iteration != "":
+		ex = append(ex, "iteration "+fn+" "+iteration)
+	case fn != "":
+		ex = append(ex, "break "+fn)
+	case iteration != "":
+		fmt.Printf("-iteration needs a -bench pattern that names one benchmark, not %q\n", bench)
+		return 2
+	}
+	return debugTestBinary(pkg, buildFlags, testArgs, ex)
+}
+
+// iterationCommand handles "iteration <benchmark> <n>", which stops at the
+// start of iteration n of the loop of a benchmark function, and "iteration
+// off".
+func (d *Debugger) iterationCommand(pid int, args []string) bool {
+	if len(args) == 1 && args[0] == "off" {
+		d.clearIteration()
+		return false
+	}
+	if len(args) != 2 {
+		d.println("usage: iteration <benchmark> <n> | iteration off")
+		return false
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 {
+		d.printf("bad iteration %q\n", args[1])
+		return false
+	}
+	if err := d.SetIteration(args[0], n); err != nil {
+		d.println(err)
+		return false
+	}
+	it := d.benchIter
+	d.printf("Stopping at iteration %d of %s, at %s:%d\n", n, it.fn.Name, it.file, it.line)
+	return false
+}
+
+// SetIteration stops the target at the start of iteration n of the loop of
+// the benchmark function name, the first statement of its body, in the
+// first run of the function with at least n iterations. The loop is the one
+// that runs b.N times or while b.Loop() holds. It replaces any iteration
+// stop set before.
+func (d *Debugger) SetIteration(name string, n int) error {
+	if d.ReadOnly {
+		return ErrReadOnly
+	}
+	fn, err := d.ResolveFunc(name)
+	if err != nil {
+		return err
+	}
+	file, line, err := d.benchLoopBody(fn)
+	if err != nil {
+		return err
+	}
+	body, _, err := d.lineToPC(file, line)
+	if err != nil {
+		return err
+	}
+	d.clearIteration()
+	if err := d.addTrap(fn.Entry); err != nil {
+		return err
+	}
+	if err := d.addTrap(body); err != nil {
+		d.removeTrap(fn.Entry)
+		return err
+	}
+	d.benchIter = &benchIter{fn: fn, file: file, line: line, body: body, n: n}
+	return nil
+}
+
+// clearIteration removes the iteration stop, if any.
+func (d *Debugger) clearIteration() {
+	if it := d.benchIter; it != nil {
+		d.removeTrap(it.fn.Entry)
+		d.removeTrap(it.body)
+		d.benchIter = nil
+	}
+}
+
+// countIteration counts the hit of the trap at addr, if it is one of the
+// iteration stop's, and reports whether it was, and whether the target
+// stops there. Like a traced call, it leaves resuming to the caller, in
+// case a breakpoint shares the address.
+func (d *Debugger) countIteration(pid int, addr uint64) (counted, stop bool) {
+	it := d.benchIter
+	if it == nil || addr != it.fn.Entry && addr != it.body {
+		return false, false
+	}
+	if addr == it.fn.Entry {
+		// A new run. The *testing.B is the first argument, in RAX.
+		it.count, it.b = 0, d.Regs.Rax
+		return true, false
+	}
+	it.count++
+	if it.count != it.n {
+		return true, false
+	}
+	msg := fmt.Sprintf("Iteration %d of %s", it.n, it.fn.Name)
+	if off, err := d.FieldOffset("testing.B", "N"); err == nil && it.b != 0 {
+		// Loops over b.Loop() leave b.N at 0 until they end.
+		if bn, err := d.readUint(pid, it.b+uint64(off), 8); err == nil && bn != 0 {
+			msg += fmt.Sprintf(", b.N = %d", int64(bn))
+		}
+	}
+	d.println(msg)
+	return true, true
+}
+
+// benchLoopBody finds the loop of the benchmark function fn in its source,
+// a for statement whose condition uses b.N or b.Loop(), or a range over
+// b.N, and returns the first line of its body.
+func (d *Debugger) benchLoopBody(fn *gosym.Func) (string, int, error) {
+	file, _, _ := d.pcToLine(fn.Entry)
+	lines, err := d.sourceLines(file)
+	if err != nil {
+		return "", 0, err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, strings.Join(lines, "\n"), 0)
+	if err != nil {
+		return "", 0, err
+	}
+	var decl *ast.FuncDecl
+	for _, dl := range f.Decls {
+		if fd, ok := dl.(*ast.FuncDecl); ok && fd.Recv == nil && fd.Name.Name == fn.BaseName() {
+			decl = fd
+		}
+	}
+	if decl == nil || decl.Body == nil {
+		return "", 0, fmt.Errorf("%s is not a function in %s", fn.Name, file)
+	}
+	var body *ast.BlockStmt
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		if body != nil {
+			return false
+		}
+		switch s := n.(type) {
+		case *ast.ForStmt:
+			if s.Cond != nil && usesBenchLoop(s.Cond) {
+				body = s.Body
+			}
+		case *ast.RangeStmt:
+			if usesBenchLoop(s.X) {
+				body = s.Body
+			}
+		}
+		return body == nil
+	})
+	if body == nil || len(body.List) == 0 {
+		return "", 0, fmt.Errorf("%s has no loop over b.N or b.Loop()", fn.Name)
+	}
+	return file, fset.Position(body.List[0].Pos()).Line, nil
+}
+
+// usesBenchLoop reports whether the expression reads the N field or calls
+// the Loop method of something, as a benchmark loop does with its
+// *testing.B.
+func usesBenchLoop(e ast.Expr) bool {
+	found := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			found = found || n.Sel.Name == "N"
+		case *ast.CallExpr:
+			if sel, ok := n.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Loop" {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
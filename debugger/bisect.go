@@ -0,0 +1,292 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// bisectReasons are the stops that make a run of "bisect" fail: those of
+// the breakpoints and catchpoints of its -stop commands, watches, signals,
+// races and fatal errors, but not interrupts.
+var bisectReasons = map[string]bool{
+	"breakpoint": true,
+	"catch":      true,
+	"watchpoint": true,
+	"watch":      true,
+	"fatal":      true,
+	"race":       true,
+	"signal":     true,
+}
+
+// bisectRun is a run of the target by "bisect": an input, or none, and the
+// variables set in its environment.
+type bisectRun struct {
+	input string
+	env   []string
+}
+
+func (r bisectRun) String() string {
+	var parts []string
+	if r.input != "" {
+		parts = append(parts, fmt.Sprintf("input %q", r.input))
+	}
+	return strings.Join(append(parts, r.env...), " ")
+}
+
+// bisectResult is the outcome of a run: the stop that failed it, if any,
+// or how the run ended, with the transcript of the session.
+type bisectResult struct {
+	stop       *Event
+	exit       string
+	transcript []byte
+}
+
+// bisectConfig is what "bisect" runs each time.
+type bisectConfig struct {
+	self    string
+	stops   []string
+	stdin   bool
+	timeout time.Duration
+	target  string
+	args    []string
+}
+
+// Bisect implements "bisect -inputs <file> [-env KEY=v1,v2]... [-stop
+// cmd]... [-stdin] [-binary] [-max n] [-timeout d] <target> [args...]". It
+// runs the target under the debugger once for each input, a line of the
+// file, and each combination of the values of the -env variables, until a
+// run stops at one of the -stop commands' breakpoints or catchpoints, a
+// panic by default, or at a fatal error or signal. The input replaces "{}"
+// among the target's arguments, or is appended to them, or with -stdin
+// names the file the target reads as its standard input. With -binary the
+// runs are taken to be ordered so that all the runs after a failing one
+// fail too, and the first that does is found in as many runs as halvings.
+// The exit code is 0 when a run failed, printed with its transcript, and 1
+// when none did.
+func Bisect(args []string) int {
+	const usage = "usage: bisect -inputs <file> [-env KEY=v1,v2]... [-stop cmd]... [-stdin] [-binary] [-max n] [-timeout d] <target> [args...]"
+	flags := flag.NewFlagSet("bisect", flag.ExitOnError)
+	inputs := flags.String("inputs", "", "run the target once for each line of `file`")
+	var envs [][]string
+	flags.Func("env", "run the target with each of the comma-separated values of `KEY=v1,v2` in its environment (repeatable)", func(kv string) error {
+		key, values, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("%q is not KEY=v1,v2", kv)
+		}
+		var vars []string
+		for _, v := range strings.Split(values, ",") {
+			vars = append(vars, key+"="+v)
+		}
+		envs = append(envs, vars)
+		return nil
+	})
+	var cfg bisectConfig
+	flags.Func("stop", "set a breakpoint or catchpoint with the prompt command `cmd` before each run (repeatable; default \"catch panic\")", func(cmd string) error {
+		cfg.stops = append(cfg.stops, cmd)
+		return nil
+	})
+	flags.BoolVar(&cfg.stdin, "stdin", false, "give the target each input, a file name, as its standard input")
+	binary := flags.Bool("binary", false, "find the first failing run by halving, the runs being ordered so that all after a failing one fail")
+	maxRuns := flags.Int("max", 0, "stop after `n` runs (0 for no limit)")
+	flags.DurationVar(&cfg.timeout, "timeout", time.Minute, "kill a run that takes longer than `duration`")
+	flags.Parse(args)
+	if flags.NArg() < 1 || *inputs == "" && len(envs) == 0 {
+		fmt.Println(usage)
+		return 2
+	}
+	if len(cfg.stops) == 0 {
+		cfg.stops = []string{"catch panic"}
+	}
+	cfg.target, cfg.args = flags.Arg(0), flags.Args()[1:]
+	var err error
+	if cfg.self, err = os.Executable(); err != nil {
+		fmt.Println(err)
+		return 2
+	}
+
+	// The inputs, each with every combination of the variables.
+	ins := []string{""}
+	if *inputs != "" {
+		b, err := os.ReadFile(*inputs)
+		if err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		ins = nil
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				ins = append(ins, line)
+			}
+		}
+		if len(ins) == 0 {
+			fmt.Printf("%s has no inputs\n", *inputs)
+			return 2
+		}
+	}
+	combos := [][]string{nil}
+	for _, vars := range envs {
+		var next [][]string
+		for _, c := range combos {
+			for _, v := range vars {
+				next = append(next, append(slices.Clip(c), v))
+			}
+		}
+		combos = next
+	}
+	var runs []bisectRun
+	for _, in := range ins {
+		for _, c := range combos {
+			runs = append(runs, bisectRun{input: in, env: c})
+		}
+	}
+
+	count := 0
+	try := func(i int) bisectResult {
+		count++
+		res := cfg.run(runs[i])
+		what := res.exit
+		if res.stop != nil {
+			what = "stopped at " + res.stop.where() + ", on " + res.stop.Reason
+			if res.stop.Detail != "" {
+				what += ": " + res.stop.Detail
+			}
+		}
+		fmt.Printf("run %d/%d, %s: %s\n", i+1, len(runs), runs[i], what)
+		return res
+	}
+	first := -1
+	var failed bisectResult
+	if *binary {
+		// The last run has to fail for there to be a first; then the
+		// first failing run is in (lo, first].
+		if res := try(len(runs) - 1); res.stop != nil {
+			first, failed = len(runs)-1, res
+			lo := -1
+			for first-lo > 1 && (*maxRuns == 0 || count < *maxRuns) {
+				mid := lo + (first-lo)/2
+				if res := try(mid); res.stop != nil {
+					first, failed = mid, res
+				} else {
+					lo = mid
+				}
+			}
+			if first-lo > 1 {
+				fmt.Printf("Stopped after %d runs; the first failing run may be earlier\n", count)
+			}
+		}
+	} else {
+		for i := range runs {
+			if *maxRuns != 0 && count >= *maxRuns {
+				fmt.Printf("Stopped after %d runs\n", count)
+				break
+			}
+			if res := try(i); res.stop != nil {
+				first, failed = i, res
+				break
+			}
+		}
+	}
+	if first < 0 {
+		fmt.Printf("No run stopped in %d runs\n", count)
+		return 1
+	}
+	fmt.Printf("\nFirst failing run: %d, %s, after %d runs. Its session:\n%s", first+1, runs[first], count, failed.transcript)
+	return 0
+}
+
+// run runs the debugger on the target once for r, with the stop commands
+// as its script, and reads the outcome from its event stream. A run that
+// outlasts the timeout is killed.
+func (cfg bisectConfig) run(r bisectRun) bisectResult {
+	args := []string{"-event-fd", "3"}
+	for _, kv := range r.env {
+		args = append(args, "-env", kv)
+	}
+	targetArgs := slices.Clone(cfg.args)
+	switch {
+	case cfg.stdin && r.input != "":
+		args = append(args, "-stdin", r.input)
+	default:
+		// The script is on the debugger's standard input, not the target's.
+		args = append(args, "-stdin", os.DevNull)
+		if r.input == "" || cfg.stdin {
+			break
+		}
+		replaced := false
+		for i, a := range targetArgs {
+			if strings.Contains(a, "{}") {
+				targetArgs[i], replaced = strings.ReplaceAll(a, "{}", r.input), true
+			}
+		}
+		if !replaced {
+			targetArgs = append(targetArgs, r.input)
+		}
+	}
+	args = append(append(args, cfg.target, "--"), targetArgs...)
+
+	events, w, err := os.Pipe()
+	if err != nil {
+		return bisectResult{exit: "failed: " + err.Error()}
+	}
+	defer events.Close()
+	script := strings.Join(cfg.stops, "\n") + "\nc\nbt\nq\n"
+	var out bytes.Buffer
+	cmd := exec.Command(cfg.self, args...)
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return bisectResult{exit: "failed: " + err.Error()}
+	}
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(cfg.timeout, func() {
+		timedOut.Store(true)
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+
+	res := bisectResult{exit: "ended without an exit event"}
+	s := bufio.NewScanner(events)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var ev Event
+		if json.Unmarshal(s.Bytes(), &ev) != nil {
+			continue
+		}
+		switch {
+		case ev.Type == "stop" && bisectReasons[ev.Reason] && res.stop == nil:
+			res.stop = &ev
+		case ev.Type == "exit" && ev.Status != nil:
+			res.exit = fmt.Sprintf("exited with status %d", *ev.Status)
+			if ev.Detail != "" {
+				res.exit = "killed by " + ev.Detail
+			}
+		}
+	}
+	// The stream ends with the debugger; a target it left running would
+	// hold its output open.
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if err := cmd.Wait(); err != nil {
+		fmt.Fprintf(&out, "debugger exited: %v\n", err)
+	}
+	timer.Stop()
+	if timedOut.Load() && res.stop == nil {
+		res.exit = fmt.Sprintf("killed after %v", cfg.timeout)
+	}
+	res.transcript = out.Bytes()
+	return res
+}
@@ -0,0 +1,121 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blameLine is who last changed a source line, as git blame tells.
+type blameLine struct {
+	commit  string // abbreviated; all zeros if not committed yet
+	author  string
+	time    time.Time
+	summary string
+}
+
+// blamed is a blamed line, or why it couldn't be.
+type blamed struct {
+	line blameLine
+	err  error
+}
+
+func (b blameLine) String() string {
+	if strings.Trim(b.commit, "0") == "" {
+		return "not committed yet"
+	}
+	return fmt.Sprintf("%s %s, %s: %s", b.commit, b.author, age(time.Since(b.time)), b.summary)
+}
+
+// blame returns who last changed line of the source file compiled at path,
+// from the git checkout the file is found in. Lines are blamed once a
+// session, failures included, so a loop stopping on a line doesn't run git
+// at every stop.
+func (d *Debugger) blame(path string, line int) (blameLine, error) {
+	key := fmt.Sprintf("%s:%d", path, line)
+	if b, ok := d.blames[key]; ok {
+		return b.line, b.err
+	}
+	var b blamed
+	p, err := d.sourcePath(path)
+	if err == nil {
+		b.line, err = gitBlame(p, line)
+	}
+	b.err = err
+	if d.blames == nil {
+		d.blames = make(map[string]blamed)
+	}
+	d.blames[key] = b
+	return b.line, b.err
+}
+
+// gitBlame runs git blame for line of the file at path.
+func gitBlame(path string, line int) (blameLine, error) {
+	cmd := exec.Command("git", "blame", "--porcelain", "-L", fmt.Sprintf("%d,%d", line, line), "--", filepath.Base(path))
+	cmd.Dir = filepath.Dir(path)
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			err = fmt.Errorf("git blame: %s", bytes.TrimSpace(ee.Stderr))
+		}
+		return blameLine{}, err
+	}
+
+	// The first line is "<commit> <orig line> <line> <lines>", then
+	// headers, then the line itself after a tab.
+	var b blameLine
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for first := true; s.Scan(); first = false {
+		text := s.Text()
+		if first {
+			commit, _, _ := strings.Cut(text, " ")
+			b.commit = commit[:min(len(commit), 8)]
+			continue
+		}
+		key, value, _ := strings.Cut(text, " ")
+		switch key {
+		case "author":
+			b.author = value
+		case "author-time":
+			sec, _ := strconv.ParseInt(value, 10, 64)
+			b.time = time.Unix(sec, 0)
+		case "summary":
+			b.summary = value
+		}
+	}
+	if b.commit == "" {
+		return blameLine{}, fmt.Errorf("git blame: no output for %s:%d", path, line)
+	}
+	return b, nil
+}
+
+// age describes a duration in the past roughly, as "3 days ago".
+func age(d time.Duration) string {
+	units := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"week", 7 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	for _, u := range units {
+		if n := int(d / u.d); n > 0 {
+			if n == 1 {
+				return fmt.Sprintf("1 %s ago", u.name)
+			}
+			return fmt.Sprintf("%d %ss ago", n, u.name)
+		}
+	}
+	return "just now"
+}
@@ -0,0 +1,135 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"fmt"
+	"sort"
+)
+
+// maxDecodedFunc bounds the code of a function decoded to find where its
+// instructions start. Traps in bigger ones aren't checked.
+const maxDecodedFunc = 1 << 20
+
+// checkInstruction reports an error if addr, where a trap is about to be
+// written, is inside an instruction rather than at its start: the trap would
+// corrupt it, and the target would run garbage when it gets there. Code
+// outside Go functions is taken on trust. In a function that can't be
+// decoded, without a disassembler, only the starts of lines in the line
+// table are.
+func (d *Debugger) checkInstruction(addr uint64) error {
+	starts, ok := d.instructionStarts(addr)
+	if !ok {
+		if d.lineStart(addr) {
+			return nil
+		}
+		return fmt.Errorf("can't decode the code at %#x to check it's the start of an instruction", addr)
+	}
+	i := sort.Search(len(starts), func(i int) bool { return starts[i] > addr })
+	if i == 0 || starts[i-1] == addr {
+		return nil
+	}
+	return fmt.Errorf("%#x is inside the instruction at %#x", addr, starts[i-1])
+}
+
+// alignInstruction returns addr, a location of the line table, moved on to
+// the start of the next instruction if it is inside one. Line tables point
+// at instructions, but one built from DWARF or belonging to another build of
+// the binary can be off.
+func (d *Debugger) alignInstruction(addr uint64) uint64 {
+	starts, _ := d.instructionStarts(addr)
+	i := sort.Search(len(starts), func(i int) bool { return starts[i] >= addr })
+	if i == 0 || i == len(starts) {
+		return addr
+	}
+	return starts[i]
+}
+
+// instructionStarts returns where the instructions of the function at addr
+// start, decoded once per function, and false when they can't be decoded.
+// It returns none to check against for fixed length instructions, whose
+// alignment CheckTrapAddr checks, code outside Go functions, and the entry
+// of a function.
+func (d *Debugger) instructionStarts(addr uint64) ([]uint64, bool) {
+	fn := d.pcToFunc(addr)
+	if d.Arch.InstrAlign > 1 || fn == nil || addr == fn.Entry {
+		return nil, true
+	}
+	if starts, ok := d.instStarts[fn.Entry]; ok {
+		return starts, starts != nil
+	}
+	if d.instStarts == nil {
+		d.instStarts = make(map[uint64][]uint64)
+	}
+	// Failures are kept too, so a missing disassembler isn't run for
+	// every trap.
+	var starts []uint64
+	if size := fn.End - fn.Entry; size > 0 && size <= maxDecodedFunc {
+		code := make([]byte, size)
+		if err := d.ReadMemory(d.Pid, fn.Entry, code); err == nil {
+			d.originalCode(fn.Entry, code)
+			if insts, err := d.disassembler().Disassemble(d.Arch, fn.Entry, code); err == nil {
+				for _, in := range insts {
+					starts = append(starts, in.Addr)
+				}
+			}
+		}
+	}
+	d.instStarts[fn.Entry] = starts
+	return starts, starts != nil
+}
+
+// lineStart reports whether the line table of the function at addr has a
+// line start at addr: where the compiler put the first instruction of a
+// line.
+func (d *Debugger) lineStart(addr uint64) bool {
+	file, line, fn := d.pcToLine(addr)
+	if fn == nil || addr == fn.Entry || addr == d.afterPrologue(fn) {
+		return fn != nil
+	}
+	prevFile, prevLine, _ := d.pcToLine(addr - 1)
+	return file != prevFile || line != prevLine
+}
+
+// afterPrologue returns where the prologue of fn ends, as the DWARF line
+// table marks it: past the check that the stack is big enough, which calls
+// runtime.morestack to grow it, or to preempt the goroutine, and then starts
+// fn over. A trap at the entry fires again for such a call, one at the end
+// of the prologue once. Functions without a mark, such as those without a
+// frame, or without debug information end it at their entry.
+func (d *Debugger) afterPrologue(fn *gosym.Func) uint64 {
+	if d.Dwarf == nil || d.SymTable == nil || d.SymTable.PCToFunc(fn.Entry) == nil {
+		return fn.Entry
+	}
+	if d.dwarfData.prologueEnds == nil {
+		ends := []uint64{}
+		r := d.Dwarf.Reader()
+		for {
+			cu, err := r.Next()
+			if err != nil || cu == nil {
+				break
+			}
+			r.SkipChildren()
+			lr, err := d.Dwarf.LineReader(cu)
+			if err != nil || lr == nil {
+				continue
+			}
+			var le dwarf.LineEntry
+			for lr.Next(&le) == nil {
+				if le.PrologueEnd {
+					ends = append(ends, le.Address)
+				}
+			}
+		}
+		sort.Slice(ends, func(i, j int) bool { return ends[i] < ends[j] })
+		d.dwarfData.prologueEnds = ends
+	}
+	ends, entry := d.dwarfData.prologueEnds, d.linkPC(fn.Entry)
+	i := sort.Search(len(ends), func(i int) bool { return ends[i] >= entry })
+	if i == len(ends) || ends[i] >= d.linkPC(fn.End) {
+		return fn.Entry
+	}
+	return d.loadAddr(ends[i])
+}
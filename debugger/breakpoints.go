@@ -0,0 +1,391 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Breakpoint is a breakpoint set with "break". Breakpoints are kept in
+// Debugger.Breakpoints by address and numbered in the order they were set.
+type Breakpoint struct {
+	ID      int
+	File    string
+	Line    int
+	Addr    uint64
+	Enabled bool
+	HitCond *HitCondition // nil stops at every hit
+	Hits    int
+	// Cond is a condition on values, such as "elapsed > 100", set with
+	// SetBreakCondition. Hits where it doesn't hold are passed over like
+	// those HitCond rejects.
+	Cond  string
+	cond  []sampleCond
+	tiers *condTiers // cond compiled at the last hit, see compileCond
+	// Ignore is the number of upcoming hits that don't stop, set with
+	// "ignore". They count as hits but aren't matched against HitCond.
+	Ignore int
+	// Sample, set with "sample", keeps one hit in Sample: the others are
+	// counted and resumed straight from the trap, before Ignore, HitCond or
+	// Cond are looked at. 0 and 1 keep every hit.
+	Sample int
+	// Mode is what a hit that passes HitCond does, set with "mode".
+	// Collect holds the expressions a ModeCollect hit records in Samples.
+	Mode    BreakMode
+	Collect []string
+	Samples []Sample
+	// Message is what a ModeTrace hit logs, a template whose {expr} are
+	// replaced by their values.
+	Message string
+	message []printerPart
+	// Returns logs the returns of the calls of the function the breakpoint
+	// is at the start of, set with "trace --ret" or TraceReturns.
+	Returns bool
+	results []*Variable // of the function, found by TraceReturns
+	// Commands run at every hit that stops, set with "on stop at". A final
+	// "continue" resumes the target after them.
+	Commands []string
+	// Temporary breakpoints, set with "tbreak" or "until", are deleted at
+	// their first hit that stops.
+	Temporary bool
+}
+
+func (b *Breakpoint) String() string {
+	return fmt.Sprintf("%s:%d", b.File, b.Line)
+}
+
+// AddBreakpoint sets a breakpoint at file:line on pid. It stops at the hits
+// cond matches, or at every hit if cond is nil. The trap is armed right away
+// rather than at the next resume, so that failures are reported here.
+func (d *Debugger) AddBreakpoint(pid int, file string, line int, cond *HitCondition) (*Breakpoint, error) {
+	addr, _, err := d.lineToPC(file, line)
+	if err != nil {
+		return nil, fmt.Errorf("no code at %s:%d", file, line)
+	}
+	if b := d.Breakpoints[addr]; b != nil {
+		return nil, fmt.Errorf("%s:%d already has breakpoint %d", file, line, b.ID)
+	}
+	if len(d.catchesAt(addr)) > 0 || d.findGroupTrap(addr) != nil {
+		return nil, fmt.Errorf("%s:%d already has a breakpoint", file, line)
+	}
+
+	if err := d.addTrap(addr); err != nil {
+		return nil, err
+	}
+	if err := d.flushTraps(pid); d.armedTrap(addr) == nil {
+		return nil, err
+	} else if err != nil {
+		d.println(err)
+	}
+	if d.Breakpoints == nil {
+		d.Breakpoints = make(map[uint64]*Breakpoint)
+	}
+	d.nextBreakID++
+	b := &Breakpoint{ID: d.nextBreakID, File: file, Line: line, Addr: addr, Enabled: true, HitCond: cond}
+	d.Breakpoints[addr] = b
+	return b, nil
+}
+
+// RemoveBreakpoint deletes breakpoint id. Its trap is removed when the
+// target resumes.
+func (d *Debugger) RemoveBreakpoint(id int) error {
+	b, err := d.breakpointByID(id)
+	if err != nil {
+		return err
+	}
+	if b.Enabled {
+		d.removeTrap(b.Addr)
+	}
+	delete(d.Breakpoints, b.Addr)
+	return nil
+}
+
+// EnableBreakpoint re-arms the disabled breakpoint id on pid.
+func (d *Debugger) EnableBreakpoint(pid int, id int) error {
+	b, err := d.breakpointByID(id)
+	if err != nil || b.Enabled {
+		return err
+	}
+	if err := d.addTrap(b.Addr); err != nil {
+		return err
+	}
+	if err := d.flushTraps(pid); d.armedTrap(b.Addr) == nil {
+		return err
+	} else if err != nil {
+		d.println(err)
+	}
+	b.Enabled = true
+	return nil
+}
+
+// DisableBreakpoint removes the trap of breakpoint id but keeps the
+// breakpoint, with its condition and hit count, for EnableBreakpoint.
+func (d *Debugger) DisableBreakpoint(id int) error {
+	b, err := d.breakpointByID(id)
+	if err != nil || !b.Enabled {
+		return err
+	}
+	d.removeTrap(b.Addr)
+	b.Enabled = false
+	return nil
+}
+
+// ListBreakpoints returns the breakpoints in the order they were set.
+func (d *Debugger) ListBreakpoints() []*Breakpoint {
+	list := make([]*Breakpoint, 0, len(d.Breakpoints))
+	for _, b := range d.Breakpoints {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func (d *Debugger) breakpointAt(file string, line int) *Breakpoint {
+	for _, b := range d.Breakpoints {
+		if b.File == file && b.Line == line {
+			return b
+		}
+	}
+	return nil
+}
+
+func (d *Debugger) breakpointByID(id int) (*Breakpoint, error) {
+	for _, b := range d.Breakpoints {
+		if b.ID == id {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d", ErrBreakpointNotFound, id)
+}
+
+// breakCommand handles "break <location> [count|-hitcount <cond>] [if
+// <cond>]", where the location is "[file:]line" or a function name. Line
+// numbers without a file refer to the current file. Without a location, it
+// is asked for. The condition after "if" is one of SetBreakCondition, e.g.
+// "calledfrom(main.worker)" to stop only at the calls of one caller.
+func (d *Debugger) breakCommand(pid int, args []string) bool {
+	if len(args) == 0 {
+		loc, ok := d.promptLocation(pid)
+		if !ok {
+			return false
+		}
+		args = []string{loc}
+	}
+	const usage = "usage: break [file:]line|function [count|-hitcount <cond>] [if <cond>]"
+	hitArgs, valueCond := args, ""
+	if i := slices.Index(args, "if"); i > 0 {
+		hitArgs, valueCond = args[:i], strings.Join(args[i+1:], " ")
+		if _, err := parseSampleConds(valueCond); err != nil {
+			d.println(err)
+			return false
+		}
+	}
+	if len(hitArgs) == 2 || len(hitArgs) > 2 && hitArgs[1] != "count" && hitArgs[1] != "-hitcount" {
+		d.println(usage)
+		return false
+	}
+	file, line, err := d.parseLocation(args[0])
+	if err != nil {
+		if !d.breakPending(args) {
+			d.println(err)
+		}
+		return false
+	}
+
+	var cond *HitCondition
+	if len(hitArgs) > 2 {
+		cond, err = ParseHitCondition(strings.Trim(strings.Join(hitArgs[2:], " "), `"'`))
+		if err != nil {
+			d.println(err)
+			return false
+		}
+	}
+
+	b := d.breakpointAt(file, line)
+	if b != nil {
+		// Already there, e.g. restored by -session and set again by the
+		// rc file.
+		b.HitCond = cond
+	} else {
+		b, err = d.AddBreakpoint(pid, file, line, cond)
+		d.audit("", "breakpoint", pid, fmt.Sprintf("%s:%d", file, line), err)
+		if err != nil {
+			d.printf("Can't set breakpoint at %s:%d: %v\n", file, line, err)
+			return false
+		}
+	}
+	if err := d.SetBreakCondition(b.ID, valueCond); err != nil {
+		d.println(err)
+		return false
+	}
+	d.printf("Breakpoint %d at %s (%#x)\n", b.ID, b, b.Addr)
+	return false
+}
+
+// promptLocation asks for the location of a breakpoint. At the end of
+// input it quits the session and returns false.
+func (d *Debugger) promptLocation(pid int) (string, bool) {
+	loc, ok := d.UI.PromptCommand("line in " + d.TargetFile + ", file:line or function")
+	if !ok {
+		d.quit(pid)
+	}
+	return loc, ok
+}
+
+// breakpointsCommand handles "breakpoints", which lists the breakpoints.
+func (d *Debugger) breakpointsCommand(pid int, args []string) bool {
+	list := d.ListBreakpoints()
+	if len(list) == 0 {
+		d.println("No breakpoints")
+		return false
+	}
+	for _, b := range list {
+		state := "enabled"
+		if !b.Enabled {
+			state = "disabled"
+		}
+		d.printf("  %d: %s (%#x) %s, %d hits", b.ID, b, b.Addr, state, b.Hits)
+		if b.HitCond != nil {
+			d.printf(", stops when %s", b.HitCond.Text)
+		}
+		if b.Cond != "" {
+			d.printf(", if %s", b.Cond)
+		}
+		if b.Ignore > 0 {
+			d.printf(", ignores the next %d", b.Ignore)
+		}
+		if b.Sample > 1 {
+			d.printf(", samples 1 in %d", b.Sample)
+		}
+		switch b.Mode {
+		case ModeCount:
+			d.printf(", counts only")
+		case ModeCollect:
+			d.printf(", collects %s (%d samples)", strings.Join(b.Collect, " "), len(b.Samples))
+		case ModeTrace:
+			d.printf(", logs its hits")
+			if b.Message != "" {
+				d.printf(" with %q", b.Message)
+			}
+		}
+		if b.Returns {
+			d.printf(", logs its returns")
+		}
+		if len(b.Commands) > 0 {
+			d.printf(", runs %s", strings.Join(b.Commands, "; "))
+		}
+		if b.Temporary {
+			d.printf(", temporary")
+		}
+		d.println()
+	}
+	return false
+}
+
+// breakpointIDsCommand returns a command that applies op to the breakpoints
+// numbered in its arguments, as in "delete 1 3".
+func breakpointIDsCommand(name string, op func(d *Debugger, pid, id int) error) func(d *Debugger, pid int, args []string) bool {
+	return func(d *Debugger, pid int, args []string) bool {
+		if len(args) == 0 {
+			d.printf("usage: %s <breakpoint>...\n", name)
+			return false
+		}
+		for _, a := range args {
+			id, err := strconv.Atoi(a)
+			if err != nil {
+				d.printf("Bad breakpoint number %s\n", a)
+				continue
+			}
+			err = op(d, pid, id)
+			d.audit("", name+"-breakpoint", pid, a, err)
+			if err != nil {
+				d.println(err)
+			}
+		}
+		return false
+	}
+}
+
+// IgnoreBreakpoint makes breakpoint id pass over its next n hits without
+// stopping; n == 0 stops ignoring.
+func (d *Debugger) IgnoreBreakpoint(id, n int) error {
+	if n < 0 {
+		return fmt.Errorf("bad ignore count %d", n)
+	}
+	b, err := d.breakpointByID(id)
+	if err != nil {
+		return err
+	}
+	b.Ignore = n
+	return nil
+}
+
+// ignoreCommand handles "ignore <breakpoint> <n>".
+func (d *Debugger) ignoreCommand(pid int, args []string) bool {
+	if len(args) != 2 {
+		d.println("usage: ignore <breakpoint> <n>")
+		return false
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		d.printf("bad breakpoint %q\n", args[0])
+		return false
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		d.printf("bad ignore count %q\n", args[1])
+		return false
+	}
+	if err := d.IgnoreBreakpoint(id, n); err != nil {
+		d.println(err)
+		return false
+	}
+	d.printf("Breakpoint %d ignores its next %d hits\n", id, n)
+	return false
+}
+
+// SampleBreakpoint makes breakpoint id keep only every nth hit, the ones
+// whose hit count is a multiple of n; n == 0 or 1 keeps them all.
+func (d *Debugger) SampleBreakpoint(id, n int) error {
+	if n < 0 {
+		return fmt.Errorf("bad sampling rate %d", n)
+	}
+	b, err := d.breakpointByID(id)
+	if err != nil {
+		return err
+	}
+	b.Sample = n
+	return nil
+}
+
+// sampleCommand handles "sample <breakpoint> <n>".
+func (d *Debugger) sampleCommand(pid int, args []string) bool {
+	if len(args) != 2 {
+		d.println("usage: sample <breakpoint> <n>")
+		return false
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		d.printf("bad breakpoint %q\n", args[0])
+		return false
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		d.printf("bad sampling rate %q\n", args[1])
+		return false
+	}
+	if err := d.SampleBreakpoint(id, n); err != nil {
+		d.println(err)
+		return false
+	}
+	if n <= 1 {
+		d.printf("Breakpoint %d takes every hit\n", id)
+	} else {
+		d.printf("Breakpoint %d takes 1 hit in %d\n", id, n)
+	}
+	return false
+}
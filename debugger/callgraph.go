@@ -0,0 +1,163 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// callGraph is the calls between functions seen while calls are traced,
+// with -trace-calls or "trace --ret": how many times each caller called
+// each callee, and for how long in all.
+type callGraph struct {
+	edges map[callPair]*callEdge
+}
+
+// callPair is an edge of the call graph, from a caller to a callee.
+type callPair struct {
+	caller, callee string
+}
+
+// callEdge is what the call graph knows of the calls along an edge. Only
+// the calls whose return was seen are timed; a call ended by a panic
+// isn't.
+type callEdge struct {
+	calls, timed int
+	time         time.Duration
+}
+
+// recordCall returns the edge of the call graph, if one is recorded, of
+// the call of the function trapped at addr, its entry or the end of its
+// prologue, that the thread pid, stopped at d.Regs, just made, or nil. The caller is the function of the return
+// address. planReturn counts the call and its return times it.
+func (d *Debugger) recordCall(pid int, addr uint64) *callEdge {
+	if d.callGraph == nil {
+		return nil
+	}
+	pair := callPair{"?", "?"}
+	if fn := d.SymTable.PCToFunc(addr); fn != nil {
+		pair.callee = fn.Name
+	}
+	if ret, _, err := d.callReturn(pid); err == nil {
+		if fn := d.SymTable.PCToFunc(ret - 1); fn != nil {
+			pair.caller = fn.Name
+		}
+	}
+	if d.callGraph.edges == nil {
+		d.callGraph.edges = make(map[callPair]*callEdge)
+	}
+	e := d.callGraph.edges[pair]
+	if e == nil {
+		e = &callEdge{}
+		d.callGraph.edges[pair] = e
+	}
+	return e
+}
+
+// sortedEdges returns the edges of the call graph, the most called first.
+func (g *callGraph) sortedEdges() []callPair {
+	pairs := make([]callPair, 0, len(g.edges))
+	for p := range g.edges {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := g.edges[pairs[i]], g.edges[pairs[j]]
+		if a.calls != b.calls {
+			return a.calls > b.calls
+		}
+		if pairs[i].caller != pairs[j].caller {
+			return pairs[i].caller < pairs[j].caller
+		}
+		return pairs[i].callee < pairs[j].callee
+	})
+	return pairs
+}
+
+// WriteCallGraph writes the call graph recorded so far to w in the DOT
+// language of Graphviz: an edge from each caller to each callee, labeled
+// with the number of calls and their cumulative time.
+func (d *Debugger) WriteCallGraph(w io.Writer) error {
+	if d.callGraph == nil {
+		return fmt.Errorf("no call graph is recorded")
+	}
+	if _, err := fmt.Fprintln(w, "digraph calls {\n\tnode [shape=box];"); err != nil {
+		return err
+	}
+	for _, p := range d.callGraph.sortedEdges() {
+		e := d.callGraph.edges[p]
+		label := fmt.Sprintf("%d calls", e.calls)
+		if e.timed > 0 {
+			label += "\n" + e.time.Round(time.Microsecond).String()
+		}
+		if _, err := fmt.Fprintf(w, "\t%q -> %q [label=%q];\n", p.caller, p.callee, label); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// saveCallGraph writes the call graph to the -call-graph file at the end
+// of the session, if one was given.
+func (d *Debugger) saveCallGraph() {
+	if d.callGraphFile == "" || d.callGraph == nil {
+		return
+	}
+	f, err := os.Create(d.callGraphFile)
+	if err == nil {
+		err = d.WriteCallGraph(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		d.printf("Can't write the call graph: %v\n", err)
+		return
+	}
+	d.printf("Call graph of %d edges written to %s\n", len(d.callGraph.edges), d.callGraphFile)
+	d.storeArtifact(d.callGraphFile)
+}
+
+// callgraphCommand handles "callgraph on|off", which starts and stops
+// recording the call graph of the traced calls, "callgraph <file>", which
+// writes it to file as DOT, and "callgraph", which lists its edges.
+func (d *Debugger) callgraphCommand(pid int, args []string) bool {
+	switch {
+	case len(args) > 1:
+		d.println("usage: callgraph [on|off|<file>]")
+	case len(args) == 1 && args[0] == "on":
+		if d.callGraph == nil {
+			d.callGraph = &callGraph{}
+		}
+		d.println("Recording the call graph of the traced calls")
+	case len(args) == 1 && args[0] == "off":
+		d.callGraph = nil
+	case len(args) == 1:
+		f, err := os.Create(args[0])
+		if err != nil {
+			d.println(err)
+			return false
+		}
+		err = d.WriteCallGraph(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			d.println(err)
+			return false
+		}
+		d.printf("Call graph written to %s\n", args[0])
+	case d.callGraph == nil || len(d.callGraph.edges) == 0:
+		d.println("No calls recorded; trace calls with -trace-calls or trace --ret, and record them with callgraph on or -call-graph")
+	default:
+		for _, p := range d.callGraph.sortedEdges() {
+			e := d.callGraph.edges[p]
+			d.printf("  %6d  %12v  %s -> %s\n", e.calls, e.time.Round(time.Microsecond), p.caller, p.callee)
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// callTracer logs the calls of the functions whose names match pattern, set
+// with -trace-calls: each is a line indented by its depth among the traced
+// calls of its goroutine, after which the target resumes.
+type callTracer struct {
+	pattern *regexp.Regexp
+	entries map[uint64]string // the traced functions by trapped address
+	// frames holds how deep in their goroutine's stack the traced calls in
+	// progress return to, by goroutine, which holds when the runtime moves
+	// the stack to grow it. A call that isn't deeper than the last one
+	// means the last one has returned.
+	frames map[uint64][]uint64
+}
+
+// planCallTraps sets traps at the starts of the functions -trace-calls
+// matches, at the end of their prologue. Functions whose start can't be
+// trapped are skipped.
+func (d *Debugger) planCallTraps(pid int) {
+	t := d.callTrace
+	if t == nil || t.pattern == nil {
+		return
+	}
+	if d.ReadOnly {
+		d.printf("Not tracing calls: %v\n", ErrReadOnly)
+		return
+	}
+	t.entries, t.frames = make(map[uint64]string), nil
+	for _, fn := range d.SymTable.Funcs {
+		if fn.Entry == 0 || !t.pattern.MatchString(fn.Name) {
+			continue
+		}
+		addr := d.afterPrologue(&fn)
+		if t.entries[addr] != "" {
+			continue
+		}
+		if err := d.addTrap(addr); err != nil {
+			continue
+		}
+		t.entries[addr] = fn.Name
+	}
+	if err := d.flushTraps(pid); err != nil {
+		d.println(err)
+	}
+	for addr := range t.entries {
+		if d.armedTrap(addr) == nil {
+			delete(t.entries, addr)
+		}
+	}
+	d.printf("Tracing calls of %d functions matching %s\n", len(t.entries), t.pattern)
+}
+
+// traceCall logs the call that the thread pid, stopped at d.Regs, makes if
+// addr is the start of a traced function, and reports whether it is.
+func (d *Debugger) traceCall(pid int, addr uint64) bool {
+	t := d.callTrace
+	if t == nil {
+		return false
+	}
+	name, ok := t.entries[addr]
+	if !ok {
+		return false
+	}
+	_, sp, err := d.callReturn(pid)
+	if err != nil {
+		sp = d.Regs.Rsp
+	}
+	goid, depth := d.goroutineDepth(pid, sp)
+	if t.frames == nil {
+		t.frames = make(map[uint64][]uint64)
+	}
+	frames := t.frames[goid]
+	for len(frames) > 0 && frames[len(frames)-1] >= depth {
+		frames = frames[:len(frames)-1]
+	}
+	d.printf("[%d] %s%s\n", goid, strings.Repeat("  ", len(frames)), name)
+	t.frames[goid] = append(frames, depth)
+	// A breakpoint tracing the returns of the function records the call
+	// itself.
+	if b := d.Breakpoints[addr]; b == nil || !b.Enabled || !b.Returns {
+		d.planReturn(pid, nil, d.recordCall(pid, addr))
+	}
+	return true
+}
+
+// setTraceCalls is the -trace-calls flag.
+func (d *Debugger) setTraceCalls(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("bad -trace-calls pattern: %v", err)
+	}
+	d.callTrace = &callTracer{pattern: re}
+	return nil
+}
@@ -0,0 +1,97 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// The states of the command run at the prompt, which ^C can cancel.
+const (
+	commandIdle int32 = iota
+	commandRunning
+	commandCanceled
+)
+
+// runCommand runs the word command input typed at the prompt, like
+// wordCommand, letting ^C cancel it.
+func (d *Debugger) runCommand(pid int, input string) (found, cont bool) {
+	d.cancelable(func() {
+		found, cont = d.wordCommand(pid, input)
+	})
+	return found, cont
+}
+
+// cancelable runs fn, a command or the output of a stop, with the target
+// stopped. While it runs, ^C cancels it rather than ending the session:
+// reads of the target's memory fail with ErrInterrupted from then on, so
+// deep backtraces, goroutine listings and big values give up at their next
+// read and the prompt comes back.
+func (d *Debugger) cancelable(fn func()) {
+	d.command.Store(commandRunning)
+	fn()
+	if d.command.Swap(commandIdle) != commandCanceled {
+		return
+	}
+	d.println("Interrupted")
+	// The target, in the terminal's process group, got the ^C too. Its
+	// SIGINT is held back until it resumes, and is then dropped, as it was
+	// meant for the debugger.
+	if pgid, err := syscall.Getpgid(d.Pid); err == nil && pgid == syscall.Getpgrp() {
+		d.dropInterrupt = true
+	}
+}
+
+// cancelCommand cancels the command running at the prompt, if any. It
+// reports whether there was one; a second ^C while a canceled command
+// winds down finds none, and ends the session as usual.
+func (d *Debugger) cancelCommand() bool {
+	return d.command.CompareAndSwap(commandRunning, commandCanceled)
+}
+
+// canceled returns ErrInterrupted once the command at the prompt has been
+// canceled, for the loops of long commands to check.
+func (d *Debugger) canceled() error {
+	if d.command.Load() == commandCanceled {
+		return ErrInterrupted
+	}
+	return nil
+}
+
+// cancelOnInterrupt makes ^C cancel the command running at the prompt of a
+// session without a live target, such as a core file, and otherwise exit.
+func (d *Debugger) cancelOnInterrupt() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		for range sigs {
+			if !d.cancelCommand() {
+				os.Exit(128 + int(syscall.SIGINT))
+			}
+		}
+	}()
+}
+
+// droppedInterrupt reports whether sig is the target's SIGINT of a ^C that
+// canceled a command, which is dropped rather than delivered.
+func (d *Debugger) droppedInterrupt(sig syscall.Signal) bool {
+	if !d.dropInterrupt || sig != syscall.SIGINT {
+		return false
+	}
+	d.dropInterrupt = false
+	return true
+}
+
+// canceledSignal handles the stop of the target's SIGINT of a canceling ^C,
+// resuming the thread without it.
+func (d *Debugger) canceledSignal(pid int) (string, bool, error) {
+	if !d.droppedInterrupt(d.Ws.StopSignal()) {
+		return "", false, nil
+	}
+	if err := d.proc().GetRegs(pid, &d.Regs); err != nil {
+		return "", true, wrapPtrace("reading registers of", pid, err)
+	}
+	return "", true, d.resume(pid, true)
+}
@@ -0,0 +1,283 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// attrGoRuntimeType is the Go specific DWARF attribute holding the location
+// of a type's runtime descriptor.
+const attrGoRuntimeType dwarf.Attr = 0x2904
+
+// Catch is a trap on runtime functions that only fires for events matching
+// a filter, such as allocations of one type, or for all of them, such as
+// panics. Several catches can share the traps.
+type Catch struct {
+	Kind     string
+	TypeName string
+	TypeAddr uint64
+	Addrs    []uint64 // of the traps, past the prologue of the functions
+	Log      bool
+	Hits     int
+}
+
+// catchCommand handles "catch alloc <type> [-log]", "catch panic [-log]"
+// and "catch mapaccess <expr>".
+func (d *Debugger) catchCommand(pid int, args []string) bool {
+	const usage = "usage: catch alloc <type> [-log] | catch panic [-log] | catch mapaccess <expr>"
+	if len(args) >= 1 && args[0] == "panic" {
+		if len(args) > 2 || len(args) == 2 && args[1] != "-log" {
+			d.println(usage)
+			return false
+		}
+		_, err := d.CatchPanic(pid, len(args) == 2)
+		d.audit("", "catchpoint", pid, "panic", err)
+		if err != nil {
+			d.println(err)
+			return false
+		}
+		d.println("Catching panics")
+		return false
+	}
+	if len(args) < 2 {
+		d.println(usage)
+		return false
+	}
+	switch args[0] {
+	case "alloc":
+	case "mapaccess":
+		w, err := d.CatchMapAccess(pid, strings.Join(args[1:], " "))
+		d.audit("", "watchpoint", pid, "mapaccess "+strings.Join(args[1:], " "), err)
+		if err != nil {
+			d.println(err)
+			return false
+		}
+		d.printf("Watchpoint %d: %s\n", w.ID, w)
+		return false
+	default:
+		d.println(usage)
+		return false
+	}
+
+	c, err := d.CatchAlloc(pid, args[1], len(args) > 2 && args[2] == "-log")
+	d.audit("", "catchpoint", pid, "alloc "+args[1], err)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	d.printf("Catching allocations of %s (type at %#x)\n", c.TypeName, c.TypeAddr)
+	return false
+}
+
+// sizedMallocPrefixes name the allocation functions specialized by size
+// class, which the compiler calls instead of runtime.mallocgc for small
+// objects since Go 1.26, and which mallocgc calls too.
+var sizedMallocPrefixes = []string{
+	"runtime.mallocgcSmallNoScanSC",
+	"runtime.mallocgcSmallScanNoHeaderSC",
+	"runtime.mallocgcTinySC",
+}
+
+// CatchAlloc traps runtime.mallocgc, and the functions specialized by size
+// the runtime has, and fires whenever an object of type typeName is
+// allocated. With log set the allocation is printed with its stack and the
+// target keeps running.
+func (d *Debugger) CatchAlloc(pid int, typeName string, log bool) (*Catch, error) {
+	fn := d.SymTable.LookupFunc("runtime.mallocgc")
+	if fn == nil {
+		return nil, fmt.Errorf("runtime.mallocgc not found")
+	}
+	typ, err := d.RuntimeType(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catch{
+		Kind:     "alloc",
+		TypeName: typeName,
+		TypeAddr: typ,
+		Addrs:    []uint64{d.afterPrologue(fn)},
+		Log:      log,
+	}
+	for i := range d.SymTable.Funcs {
+		sized := &d.SymTable.Funcs[i]
+		if slices.ContainsFunc(sizedMallocPrefixes, func(p string) bool { return strings.HasPrefix(sized.Name, p) }) {
+			c.Addrs = append(c.Addrs, d.afterPrologue(sized))
+		}
+	}
+	if err := d.armCatch(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CatchPanic traps runtime.gopanic and fires at every panic, recovered or
+// not, before any deferred call runs. With log set the panic is printed with
+// its stack and the target keeps running. The trap is past the prologue,
+// which the runtime runs again for a panic that grows the stack or is
+// preempted there.
+func (d *Debugger) CatchPanic(pid int, log bool) (*Catch, error) {
+	fn := d.SymTable.LookupFunc("runtime.gopanic")
+	if fn == nil {
+		return nil, fmt.Errorf("runtime.gopanic not found")
+	}
+	c := &Catch{Kind: "panic", Addrs: []uint64{d.afterPrologue(fn)}, Log: log}
+	if err := d.armCatch(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// armCatch asks for the traps of c and adds it. Addresses a breakpoint
+// has are refused, as AddBreakpoint refuses those of catches: one trap
+// can't stop for both.
+func (d *Debugger) armCatch(c *Catch) error {
+	for _, addr := range c.Addrs {
+		name := fmt.Sprintf("%#x", addr)
+		if fn := d.pcToFunc(addr); fn != nil {
+			name = fn.Name
+		}
+		if b := d.Breakpoints[addr]; b != nil {
+			return fmt.Errorf("breakpoint %d is where the catchpoint goes, in %s", b.ID, name)
+		}
+		if d.findGroupTrap(addr) != nil {
+			return fmt.Errorf("a group breakpoint is where the catchpoint goes, in %s", name)
+		}
+	}
+	for i, addr := range c.Addrs {
+		if err := d.addTrap(addr); err != nil {
+			for _, armed := range c.Addrs[:i] {
+				d.removeTrap(armed)
+			}
+			return fmt.Errorf("can't arm the catchpoint at %#x: %v", addr, err)
+		}
+	}
+	d.Catches = append(d.Catches, c)
+	return nil
+}
+
+// RuntimeType returns the address of the runtime type descriptor of the
+// named type, as recorded in DWARF.
+func (d *Debugger) RuntimeType(name string) (uint64, error) {
+	if d.Dwarf == nil {
+		return 0, ErrNoDWARF
+	}
+
+	r := d.Dwarf.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return 0, err
+		}
+		if entry == nil {
+			break
+		}
+		if n, _ := entry.Val(dwarf.AttrName).(string); n != name {
+			continue
+		}
+		off, ok := entry.Val(attrGoRuntimeType).(uint64)
+		if !ok {
+			continue
+		}
+
+		// Since Go 1.20 the attribute is an offset into the types section
+		// rather than an absolute address.
+		if types, ok := d.Symbols["runtime.types"]; ok && off < types {
+			off += types
+		}
+		return off, nil
+	}
+	return 0, fmt.Errorf("type %s not found", name)
+}
+
+// catchesAt returns the catches with a trap at addr.
+func (d *Debugger) catchesAt(addr uint64) []*Catch {
+	var cs []*Catch
+	for _, c := range d.Catches {
+		if slices.Contains(c.Addrs, addr) {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// matches reports whether the call the thread pid, stopped at d.Regs, made
+// to the function trapped at addr is an event c is interested in. The
+// allocation functions take the type in their second argument, which the
+// register ABI passes in RBX; those specialized by size count unless
+// runtime.mallocgc, trapped first, called them. Every panic is.
+func (c *Catch) matches(d *Debugger, pid int, addr uint64) bool {
+	if c.Kind == "panic" {
+		return true
+	}
+	if d.Regs.Rbx != c.TypeAddr {
+		return false
+	}
+	if addr == c.Addrs[0] {
+		return true
+	}
+	ret, _, err := d.callReturn(pid)
+	caller := d.pcToFunc(ret - 1)
+	return err != nil || caller == nil || caller.Name != "runtime.mallocgc"
+}
+
+// handleCatches processes a trap at addr, which the catches cs have. It
+// reports whether the target should stop at the prompt: for a catch that
+// matches and doesn't only log.
+func (d *Debugger) handleCatches(pid int, addr uint64, cs []*Catch) (bool, error) {
+	if err := d.rewindTrap(pid, addr); err != nil {
+		return false, err
+	}
+	stop := false
+	for _, c := range cs {
+		if !c.matches(d, pid, addr) {
+			continue
+		}
+		c.Hits++
+		detail := "alloc " + c.TypeName
+		if c.Kind == "panic" {
+			detail = "panic"
+		}
+		d.countHit("catch " + detail)
+		hit := d.locationEvent("hit", pid, addr)
+		hit.Hits, hit.Reason, hit.Detail = c.Hits, "catch", detail
+		d.Emit(hit)
+		if c.Kind == "panic" {
+			d.printf("Panic %d: %s\n", c.Hits, d.panicValue(pid))
+		} else {
+			d.printf("Allocation %d of %s (%d bytes)\n", c.Hits, c.TypeName, d.Regs.Rax)
+		}
+		if !c.Log {
+			stop = true
+		} else if err := d.OutputStack(pid, d.Regs.Rip, d.Regs.Rsp, d.Regs.Rbp); err != nil {
+			d.printf("  stack unreadable: %v\n\n", err)
+		}
+	}
+	return stop, nil
+}
+
+// panicValue renders the value runtime.gopanic was called with, an empty
+// interface the register ABI passes in RAX and RBX.
+func (d *Debugger) panicValue(pid int) string {
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint64(buf, d.Regs.Rax)
+	binary.LittleEndian.PutUint64(buf[8:], d.Regs.Rbx)
+	v, err := d.ifaceValue(pid, buf, true)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("<unreadable value: %v>", err)
+	case v.typ == nil:
+		return "nil"
+	}
+	// Structs are rendered with their type already.
+	name, text := typeName(v.typ), d.formatValue(pid, v.typ, v.buf, 0)
+	if strings.HasPrefix(text, name) {
+		return text
+	}
+	return fmt.Sprintf("%s(%s)", name, text)
+}
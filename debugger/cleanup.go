@@ -0,0 +1,104 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// handleSignals ends the session cleanly when the debugger is interrupted,
+// terminated or hung up on, if HandleSignals asks for it. Without this the
+// target would be left with traps in its code that kill it at the next hit.
+// SIGKILL can't be handled. A ^C while a command runs at the prompt only
+// cancels the command.
+func (d *Debugger) handleSignals() {
+	if !d.HandleSignals {
+		return
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	go func() {
+		for {
+			sig := (<-sigs).(syscall.Signal)
+			if sig == syscall.SIGINT && d.cancelCommand() {
+				continue
+			}
+			d.abort("got " + sig.String())
+			os.Exit(128 + int(sig))
+		}
+	}()
+}
+
+// abortStoreTimeout bounds how long abort waits for the artifacts of the
+// session to be stored: whoever sent the signal expects the debugger to end.
+const abortStoreTimeout = 30 * time.Second
+
+// abort restores the code under every trap so the target can run on without
+// the debugger, which detaches from it by exiting. With -save-on-exit the
+// session is saved and the target killed instead. Either way the saved
+// session and the recording are stored after, with the target left alone,
+// for at most abortStoreTimeout. abort may run on any thread, so it doesn't
+// use ptrace, and it reports on stderr rather than through the UI.
+func (d *Debugger) abort(why string) {
+	var store []string
+	if d.saveOnExit != "" {
+		if err := d.saveAndKill(d.Pid); err != nil {
+			fmt.Fprintf(os.Stderr, "Debugger %s, saving the session failed: %v\n", why, err)
+		} else {
+			store = append(store, d.saveOnExit)
+		}
+	} else if n, err := d.restoreCode(d.Pid); err != nil {
+		fmt.Fprintf(os.Stderr, "Debugger %s, restoring code of %d failed: %v\n", why, d.Pid, err)
+	} else {
+		fmt.Fprintf(os.Stderr, "Debugger %s, removed %d breakpoints and detaching from %d\n", why, n, d.Pid)
+	}
+	// The file is set before the signals are handled and written by the
+	// event loop, which *os.File allows.
+	if d.recordFile != nil {
+		d.recordFile.Sync()
+		store = append(store, d.recordFile.Name())
+	}
+	if d.Artifacts == nil || len(store) == 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, path := range store {
+			if where, err := d.putArtifact(path); err != nil {
+				fmt.Fprintf(os.Stderr, "Can't store %s: %v\n", path, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "Stored %s at %s\n", path, where)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(abortStoreTimeout):
+		fmt.Fprintf(os.Stderr, "Gave up storing the artifacts of the session after %v\n", abortStoreTimeout)
+	}
+}
+
+// restoreCode writes back the original code of every armed trap of pid, and
+// of the processes it forked, and returns how many it restored. A trap
+// awaiting re-arming is already restored.
+func (d *Debugger) restoreCode(pid int) (int, error) {
+	n := 0
+	for _, site := range d.traps {
+		if !site.armed || d.rearm && site.addr == d.rearmAt {
+			continue
+		}
+		if _, err := (procMemory{pid}).WriteMemory(site.addr, site.orig); err != nil {
+			return n, fmt.Errorf("breakpoint at %#x: %v", site.addr, err)
+		}
+		if err := d.mirrorCode(pid, site.addr, site.orig); err != nil {
+			return n, fmt.Errorf("breakpoint at %#x: %v", site.addr, err)
+		}
+		n++
+	}
+	return n, nil
+}
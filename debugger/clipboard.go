@@ -0,0 +1,38 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+)
+
+// maxClipboardLen bounds what is copied to the clipboard; terminals drop
+// longer OSC 52 sequences, some silently.
+const maxClipboardLen = 100 << 10
+
+// copyToClipboard sets the clipboard of the terminal the debugger runs in
+// to text with an OSC 52 escape sequence, which works over ssh as well.
+// Inside tmux the sequence is passed through to the outer terminal, which
+// needs tmux's set-clipboard option.
+func (d *Debugger) copyToClipboard(text string) error {
+	switch ui := d.UI.(type) {
+	case *LineFrontend:
+		if !ui.interactive {
+			return errors.New("copying to the clipboard needs a terminal")
+		}
+	case *KeyFrontend:
+	default:
+		return errors.New("copying to the clipboard needs a terminal")
+	}
+	if len(text) > maxClipboardLen {
+		return errors.New("too large for the clipboard; write it to a file with -o")
+	}
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if os.Getenv("TMUX") != "" {
+		seq = "\x1bPtmux;\x1b" + seq + "\x1b\\"
+	}
+	d.UI.ShowOutput(seq)
+	return nil
+}
@@ -0,0 +1,380 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// BreakMode is what a breakpoint does when it is hit.
+type BreakMode string
+
+const (
+	// ModeStop stops at the prompt, the default.
+	ModeStop BreakMode = ""
+	// ModeCollect records the registers and the breakpoint's expressions
+	// and resumes the target.
+	ModeCollect BreakMode = "collect"
+	// ModeCount only counts the hit and resumes the target.
+	ModeCount BreakMode = "count"
+	// ModeTrace logs a line with the time, the location and the
+	// breakpoint's message, and resumes the target.
+	ModeTrace BreakMode = "trace"
+)
+
+// Sample is what a collecting breakpoint recorded at a hit.
+type Sample struct {
+	Hit       int // the breakpoint's hit count at the time
+	Time      time.Time
+	Tid       int
+	Goroutine uint64 // 0 if it couldn't be read
+	Depth     int    // frames on the stack, 0 unless a condition counted them
+	Regs      syscall.PtraceRegs
+	Values    map[string]string // the Collect expressions, or their errors
+}
+
+// maxSamples bounds the samples kept per breakpoint; older ones are dropped
+// first.
+const maxSamples = 10000
+
+// SetBreakMode sets what breakpoint id does when hit. exprs are the
+// expressions ModeCollect records, or the words of the message ModeTrace
+// logs; other modes take none.
+func (d *Debugger) SetBreakMode(id int, mode BreakMode, exprs []string) error {
+	b, err := d.breakpointByID(id)
+	if err != nil {
+		return err
+	}
+	var message []printerPart
+	switch mode {
+	case ModeStop, ModeCount:
+		if len(exprs) > 0 {
+			return fmt.Errorf("mode %s takes no expressions", mode)
+		}
+	case ModeCollect:
+	case ModeTrace:
+		if message, err = parseTemplate(strings.Join(exprs, " ")); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown breakpoint mode %q", mode)
+	}
+	b.Mode, b.Collect, b.Message, b.message = mode, exprs, "", message
+	if mode == ModeTrace {
+		b.Collect, b.Message = nil, strings.Join(exprs, " ")
+	}
+	return nil
+}
+
+// collect records a sample of breakpoint b, which the thread pid stopped at
+// d.Regs just hit, and returns it.
+func (d *Debugger) collect(pid int, b *Breakpoint) Sample {
+	s := Sample{Hit: b.Hits, Time: time.Now(), Tid: pid, Regs: d.Regs, Goroutine: d.currentGoid(pid), Values: make(map[string]string, len(b.Collect))}
+	for _, expr := range b.Collect {
+		v, err := d.Eval(pid, expr)
+		if err != nil {
+			s.Values[expr] = "<" + err.Error() + ">"
+			continue
+		}
+		s.Values[expr] = fmt.Sprint(v)
+	}
+	if len(b.Samples) == maxSamples {
+		b.Samples = b.Samples[1:]
+	}
+	b.Samples = append(b.Samples, s)
+	return s
+}
+
+// currentGoid returns the id of the goroutine the thread pid, stopped at
+// d.Regs, runs, or 0 if it can't be read.
+func (d *Debugger) currentGoid(pid int) uint64 {
+	g := d.currentG(pid, &d.Regs)
+	if g == 0 {
+		return 0
+	}
+	off, err := d.FieldOffset("runtime.g", "goid")
+	if err != nil {
+		return 0
+	}
+	goid, _ := d.readUint(pid, g+uint64(off), 8)
+	return goid
+}
+
+// SetBreakCondition makes breakpoint id act only at the hits where cond
+// holds: comparisons of an expression, "hit", "thread", "goroutine",
+// "depth" (of the stack) or a register with a number, a string or nil, as
+// in the queries of "hits", and calledfrom(<function>) tests of the caller,
+// joined with &&. An empty
+// cond removes the condition.
+func (d *Debugger) SetBreakCondition(id int, cond string) error {
+	b, err := d.breakpointByID(id)
+	if err != nil {
+		return err
+	}
+	var conds []sampleCond
+	if cond = strings.TrimSpace(cond); cond != "" {
+		if conds, err = parseSampleConds(cond); err != nil {
+			return err
+		}
+	}
+	b.Cond, b.cond, b.tiers = cond, conds, nil
+	return nil
+}
+
+// condHolds reports whether the condition of b holds at the hit the thread
+// pid, stopped at d.Regs, just made. The terms compiled to quick checks of
+// a word are made first, so that a hot breakpoint whose condition rarely
+// holds costs little more than the trap; the others are evaluated as
+// collect does, and one that can't be evaluated fails its comparison.
+func (d *Debugger) condHolds(pid int, b *Breakpoint) bool {
+	if len(b.cond) == 0 {
+		return true
+	}
+	t := d.compileCond(pid, b)
+	for _, q := range t.quick {
+		if !d.quickHolds(pid, b, q) {
+			return false
+		}
+	}
+	if len(t.slow) == 0 {
+		return true
+	}
+	s := Sample{Hit: b.Hits, Tid: pid, Regs: d.Regs, Values: make(map[string]string, len(t.slow))}
+	for _, c := range t.slow {
+		if c.op == calledFromOp {
+			if !d.calledFrom(pid, c.lit) {
+				return false
+			}
+			continue
+		}
+		if c.key == "goroutine" {
+			s.Goroutine = d.currentGoid(pid)
+		}
+		if c.key == "depth" {
+			// The stack's, even where a variable has the name; "(depth)"
+			// is the variable.
+			if s.Depth == 0 {
+				s.Depth = d.stackDepth(pid, &d.Regs)
+			}
+		} else if v, err := d.Eval(pid, c.key); err == nil {
+			s.Values[c.key] = fmt.Sprint(v)
+		}
+		if !c.match(b, &s) {
+			return false
+		}
+	}
+	return true
+}
+
+// modeCommand handles "mode <breakpoint> stop|count|collect [<expr>...]"
+// and "mode <breakpoint> trace [<message>]". A counting breakpoint only
+// counts its hits; a collecting one records the registers and the
+// expressions at every hit, and a tracing one logs the message. They all
+// resume the target right away, turning the breakpoint into a counter or a
+// tracepoint.
+func (d *Debugger) modeCommand(pid int, args []string) bool {
+	if len(args) < 2 {
+		d.println("usage: mode <breakpoint> stop|count|collect [<expr>...] | trace [<message>]")
+		return false
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		d.printf("bad breakpoint %q\n", args[0])
+		return false
+	}
+	mode := BreakMode(args[1])
+	if mode == "stop" {
+		mode = ModeStop
+	}
+	if err := d.SetBreakMode(id, mode, args[2:]); err != nil {
+		d.println(err)
+		return false
+	}
+	switch mode {
+	case ModeStop:
+		d.printf("Breakpoint %d stops\n", id)
+	case ModeCount:
+		d.printf("Breakpoint %d counts its hits\n", id)
+	case ModeCollect:
+		d.printf("Breakpoint %d collects the registers", id)
+		if len(args) > 2 {
+			d.printf(" and %s", strings.Join(args[2:], ", "))
+		}
+		d.println()
+	case ModeTrace:
+		d.printf("Breakpoint %d logs its hits\n", id)
+	}
+	return false
+}
+
+// hitsPage is how many samples "hits" lists at a time.
+const hitsPage = 20
+
+// hitsCommand handles "hits", which browses what collecting breakpoints
+// recorded:
+//
+//	hits                          the collecting breakpoints and their samples
+//	hits <bp> [<from>]            a page of samples, from hit number from on
+//	hits <bp> show <hit>          one sample with its registers
+//	hits [<bp>] where <cond>      the samples that match, see querySamples
+//	hits [<bp>] count by <expr>   the samples counted by value
+//	hits [<bp>] export <file>     the samples to .csv or .parquet
+//
+// Queries and exports without a breakpoint cover the samples of all of them.
+func (d *Debugger) hitsCommand(pid int, args []string) bool {
+	const usage = "usage: hits [<breakpoint> [<from> | show <hit>]] | hits [<breakpoint>] where <cond> | count by <expr> | export <file>"
+	if len(args) == 0 {
+		found := false
+		for _, b := range d.ListBreakpoints() {
+			if b.Mode == ModeCollect || len(b.Samples) > 0 {
+				d.printf("  %d: %s collects %s, %d samples\n", b.ID, b, strings.Join(b.Collect, " "), len(b.Samples))
+				found = true
+			}
+		}
+		if !found {
+			d.println("No collecting breakpoints")
+		}
+		return false
+	}
+	if len(args) == 2 && args[0] == "export" {
+		d.exportSamples(args[1])
+		return false
+	}
+	if args[0] == "where" || args[0] == "count" {
+		var bps []*Breakpoint
+		for _, b := range d.ListBreakpoints() {
+			if len(b.Samples) > 0 {
+				bps = append(bps, b)
+			}
+		}
+		d.querySamples(bps, strings.Join(args, " "))
+		return false
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		d.println(usage)
+		return false
+	}
+	b, err := d.breakpointByID(id)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	if len(args) == 3 && args[1] == "export" {
+		d.exportSamples(args[2], id)
+		return false
+	}
+	if len(args) > 1 && (args[1] == "where" || args[1] == "count") {
+		d.querySamples([]*Breakpoint{b}, strings.Join(args[1:], " "))
+		return false
+	}
+	if len(args) > 3 {
+		d.println(usage)
+		return false
+	}
+
+	switch {
+	case len(args) == 3 && args[1] == "show":
+		hit, err := strconv.Atoi(args[2])
+		if err != nil {
+			d.println(usage)
+			return false
+		}
+		s := b.sample(hit)
+		if s == nil {
+			d.printf("Breakpoint %d has no sample of hit %d\n", b.ID, hit)
+			return false
+		}
+		d.showSample(b, s)
+	case len(args) <= 2:
+		from := 1
+		if len(args) == 2 {
+			if from, err = strconv.Atoi(args[1]); err != nil {
+				d.println(usage)
+				return false
+			}
+		}
+		d.listSamples(b, from)
+	default:
+		d.println(usage)
+	}
+	return false
+}
+
+// exportSamples exports the samples of the breakpoints ids, or of all, to
+// path and reports how many.
+func (d *Debugger) exportSamples(path string, ids ...int) {
+	n, err := d.ExportSamples(path, ids...)
+	if err != nil {
+		d.println(err)
+		return
+	}
+	d.printf("Wrote %d samples to %s\n", n, path)
+}
+
+// sample returns the sample of b taken at its hit-th hit, or nil.
+func (b *Breakpoint) sample(hit int) *Sample {
+	i, ok := slices.BinarySearchFunc(b.Samples, hit, func(s Sample, hit int) int { return s.Hit - hit })
+	if !ok {
+		return nil
+	}
+	return &b.Samples[i]
+}
+
+// listSamples prints a page of the samples of b, starting at the first
+// taken at hit from or later, one line each.
+func (d *Debugger) listSamples(b *Breakpoint, from int) {
+	i, _ := slices.BinarySearchFunc(b.Samples, from, func(s Sample, hit int) int { return s.Hit - hit })
+	if i == len(b.Samples) {
+		d.printf("Breakpoint %d has no samples from hit %d on\n", b.ID, from)
+		return
+	}
+	var out strings.Builder
+	page := b.Samples[i:min(i+hitsPage, len(b.Samples))]
+	for j := range page {
+		d.writeSampleLine(&out, b, &page[j], false)
+	}
+	if rest := len(b.Samples) - i - len(page); rest > 0 {
+		fmt.Fprintf(&out, "  %d more: hits %d %d\n", rest, b.ID, b.Samples[i+len(page)].Hit)
+	}
+	d.UI.ShowOutput(out.String())
+}
+
+// writeSampleLine writes the line of s, a sample of b, that lists of
+// samples show, starting with the breakpoint if withBP.
+func (d *Debugger) writeSampleLine(out *strings.Builder, b *Breakpoint, s *Sample, withBP bool) {
+	out.WriteString(" ")
+	if withBP {
+		fmt.Fprintf(out, " %d", b.ID)
+	}
+	fmt.Fprintf(out, " #%-6d %s  thread %d  goroutine %d ", s.Hit, d.sampleTime(*s), s.Tid, s.Goroutine)
+	for _, expr := range b.Collect {
+		fmt.Fprintf(out, " %s=%s", expr, s.Values[expr])
+	}
+	out.WriteByte('\n')
+}
+
+// showSample prints everything s, a sample of b, recorded.
+func (d *Debugger) showSample(b *Breakpoint, s *Sample) {
+	var out strings.Builder
+	fmt.Fprintf(&out, "Hit %d of breakpoint %d at %s\n", s.Hit, b.ID, b)
+	fmt.Fprintf(&out, "  time       %s (%s)\n", d.sampleTime(*s), s.Time.Format("15:04:05.000"))
+	fmt.Fprintf(&out, "  thread     %d\n", s.Tid)
+	fmt.Fprintf(&out, "  goroutine  %d\n", s.Goroutine)
+	for _, expr := range b.Collect {
+		fmt.Fprintf(&out, "  %s = %s\n", expr, s.Values[expr])
+	}
+	d.writeRegs(&out, &s.Regs)
+	d.UI.ShowOutput(out.String())
+}
+
+// sampleTime returns when s was taken, relative to the start of the
+// session.
+func (d *Debugger) sampleTime(s Sample) string {
+	return "+" + s.Time.Sub(d.stats.start).Round(time.Millisecond).String()
+}
@@ -0,0 +1,105 @@
+//go:build linux && amd64
+
+package debugger
+
+import "strings"
+
+// wordCommands are the prompt commands other than those that resume the
+// target, keyed by their lower-case name. A command reports whether the
+// target should be continued.
+var wordCommands map[string]func(d *Debugger, pid int, args []string) bool
+
+func init() {
+	wordCommands = map[string]func(d *Debugger, pid int, args []string) bool{
+		"alias":       (*Debugger).aliasCommand,
+		"backtrace":   (*Debugger).backtraceCommand,
+		"break":       (*Debugger).breakCommand,
+		"breakpoints": (*Debugger).breakpointsCommand,
+		"callgraph":   (*Debugger).callgraphCommand,
+		"catch":       (*Debugger).catchCommand,
+		"ctx":         (*Debugger).ctxCommand,
+		"define":      (*Debugger).defineCommand,
+		"delete": breakpointIDsCommand("delete", func(d *Debugger, pid, id int) error {
+			return d.RemoveBreakpoint(id)
+		}),
+		"detach": (*Debugger).detachCommand,
+		"diff":   (*Debugger).diffCommand,
+		"disable": breakpointIDsCommand("disable", func(d *Debugger, pid, id int) error {
+			return d.DisableBreakpoint(id)
+		}),
+		"disasm":       (*Debugger).disasmCommand,
+		"display":      (*Debugger).displayCommand,
+		"edit":         (*Debugger).editCommand,
+		"enable":       breakpointIDsCommand("enable", (*Debugger).EnableBreakpoint),
+		"files":        (*Debugger).filesCommand,
+		"funcs":        (*Debugger).funcsCommand,
+		"goroutine":    (*Debugger).goroutineCommand,
+		"goroutines":   (*Debugger).goroutinesCommand,
+		"group":        (*Debugger).groupCommand,
+		"hangs":        (*Debugger).hangsCommand,
+		"help":         (*Debugger).helpCommand,
+		"history":      (*Debugger).historyCommand,
+		"hits":         (*Debugger).hitsCommand,
+		"ignore":       (*Debugger).ignoreCommand,
+		"import":       (*Debugger).importCommand,
+		"info":         (*Debugger).infoCommand,
+		"iteration":    (*Debugger).iterationCommand,
+		"list":         (*Debugger).listCommand,
+		"locals":       (*Debugger).localsCommand,
+		"log":          (*Debugger).logCommand,
+		"maps":         (*Debugger).mapsCommand,
+		"mutex":        (*Debugger).mutexCommand,
+		"mode":         (*Debugger).modeCommand,
+		"notify":       (*Debugger).notifyCommand,
+		"on":           (*Debugger).onCommand,
+		"poke":         (*Debugger).pokeCommand,
+		"print":        (*Debugger).printCommand,
+		"printer":      (*Debugger).printerCommand,
+		"regs":         (*Debugger).regsCommand,
+		"restart":      (*Debugger).restartCommand,
+		"sample":       (*Debugger).sampleCommand,
+		"scrollback":   (*Debugger).scrollbackCommand,
+		"session":      (*Debugger).sessionCommand,
+		"set":          (*Debugger).setCommand,
+		"shell":        (*Debugger).shellCommand,
+		"snap":         (*Debugger).snapCommand,
+		"signal":       (*Debugger).signalCommand,
+		"stackdump":    (*Debugger).stackdumpCommand,
+		"tbreak":       (*Debugger).tbreakCommand,
+		"undisplay":    (*Debugger).undisplayCommand,
+		"threads":      (*Debugger).threadsCommand,
+		"trace":        (*Debugger).traceCommand,
+		"unwatch":      (*Debugger).unwatchCommand,
+		"watch":        (*Debugger).watchCommand,
+		"watch-global": (*Debugger).watchGlobalCommand,
+		"x":            (*Debugger).examineCommand,
+	}
+}
+
+// wordCommand runs input if it names a word command or a macro, or if it
+// is a pipeline of one, as in "goroutines | grep chan | count". It reports
+// whether it did and whether the target should be continued.
+func (d *Debugger) wordCommand(pid int, input string) (found, cont bool) {
+	input = d.expandAlias(input)
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return false, false
+	}
+	name := strings.ToLower(fields[0])
+	if stages := splitPipeline(input); len(stages) > 1 && !unpipedCommands[name] {
+		return true, d.runPipeline(pid, stages)
+	}
+	args := fields[1:]
+	// A format follows the name after a slash, as in "x/16xb", and is
+	// passed as the first argument.
+	if i := strings.IndexByte(name, '/'); i > 0 {
+		name, args = name[:i], append([]string{fields[0][i:]}, args...)
+	}
+	if cmd, ok := wordCommands[name]; ok {
+		return true, cmd(d, pid, args)
+	}
+	if m := d.macros[name]; m != nil {
+		return true, d.runMacro(pid, m, fields[1:])
+	}
+	return false, false
+}
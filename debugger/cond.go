@@ -0,0 +1,112 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// hitTerm is a single comparison against a breakpoint's hit count, such as
+// ">=100" or "%1000 == 0".
+type hitTerm struct {
+	Mod int
+	Op  string
+	N   int
+}
+
+// HitCondition decides on which hits a breakpoint stops. All terms have to
+// match, so ">=100 && %10 == 0" stops on the 100th hit and every 10th after.
+type HitCondition struct {
+	Terms []hitTerm
+	Text  string
+}
+
+var hitOps = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+// ParseHitCondition parses a hit count condition.
+func ParseHitCondition(s string) (*HitCondition, error) {
+	cond := &HitCondition{Text: s}
+	for _, part := range strings.Split(s, "&&") {
+		term, err := parseHitTerm(strings.ReplaceAll(part, " ", ""))
+		if err != nil {
+			return nil, err
+		}
+		cond.Terms = append(cond.Terms, term)
+	}
+	return cond, nil
+}
+
+func parseHitTerm(s string) (hitTerm, error) {
+	var term hitTerm
+	if s == "" {
+		return term, fmt.Errorf("empty hit count condition")
+	}
+
+	if s[0] == '%' {
+		i := strings.IndexFunc(s[1:], func(r rune) bool { return r < '0' || r > '9' }) + 1
+		if i == 0 {
+			i = len(s)
+		}
+		mod, err := strconv.Atoi(s[1:i])
+		if err != nil || mod <= 0 {
+			return term, fmt.Errorf("bad modulus in %q", s)
+		}
+		term.Mod = mod
+		s = s[i:]
+		if s == "" {
+			// "%1000" is shorthand for "%1000 == 0".
+			term.Op = "=="
+			return term, nil
+		}
+	}
+
+	for _, op := range hitOps {
+		if strings.HasPrefix(s, op) {
+			n, err := strconv.Atoi(s[len(op):])
+			if err != nil {
+				return term, fmt.Errorf("bad count in %q", s)
+			}
+			term.Op, term.N = op, n
+			return term, nil
+		}
+	}
+
+	// A bare number stops on exactly that hit.
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return term, fmt.Errorf("bad hit count condition %q", s)
+	}
+	term.Op, term.N = "==", n
+	return term, nil
+}
+
+// Match reports whether the breakpoint should stop on its hits-th hit.
+func (c *HitCondition) Match(hits int) bool {
+	for _, t := range c.Terms {
+		v := hits
+		if t.Mod > 0 {
+			v = hits % t.Mod
+		}
+		var ok bool
+		switch t.Op {
+		case "==":
+			ok = v == t.N
+		case "!=":
+			ok = v != t.N
+		case ">=":
+			ok = v >= t.N
+		case "<=":
+			ok = v <= t.N
+		case ">":
+			ok = v > t.N
+		case "<":
+			ok = v < t.N
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,463 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The notes of a Linux core file the debugger reads.
+const (
+	ntPrstatus = 1          // the signal, ids and registers of a thread
+	ntPrpsinfo = 3          // the pid and command line of the process
+	ntAuxv     = 6          // the auxiliary vector, with the entry point
+	ntFile     = 0x46494c45 // the files mapped, "FILE"
+
+	atEntry = 9 // the auxiliary vector's entry point
+
+	prstatusRegs = 112 // the offset of the registers in NT_PRSTATUS
+)
+
+// corePrompt is the prompt of a post-mortem session.
+const corePrompt = "\n(core) > "
+
+// CoreProcess is a ProcessController over an ELF core file: the memory and
+// threads of a process as it was when it dumped core. Memory the core
+// leaves out, such as the text of the executable and of libraries, is read
+// from the files that were mapped there. The process is dead: writing its
+// memory or registers fails with ErrReadOnly, and resuming it with
+// ErrCoreFile.
+type CoreProcess struct {
+	Pid     int
+	Command string         // the command line, as far as the core keeps it
+	Signal  syscall.Signal // the signal the process died of
+	// Threads are the threads of the process, the one that took Signal
+	// first.
+	Threads []int
+	Entry   uint64 // the entry point of the executable, where it was loaded
+
+	core     *os.File
+	segments []coreSegment
+	files    []coreFile
+	opened   map[string]*os.File // nil for files that can't be opened
+	regs     map[int]*syscall.PtraceRegs
+	sigCodes map[int]int32
+}
+
+// coreSegment is memory the core holds: size bytes at addr, of which the
+// first filesz are at offset off of the core and the rest left out.
+type coreSegment struct {
+	addr, size, off, filesz uint64
+}
+
+// coreFile is a file mapped at [start, end) from offset off.
+type coreFile struct {
+	start, end, off uint64
+	path            string
+}
+
+// OpenCore opens the core file at path.
+func OpenCore(path string) (*CoreProcess, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if ef.Type != elf.ET_CORE || ef.Machine != elf.EM_X86_64 {
+		f.Close()
+		return nil, fmt.Errorf("%s isn't a core file of an amd64 process", path)
+	}
+	c := &CoreProcess{
+		core:     f,
+		opened:   make(map[string]*os.File),
+		regs:     make(map[int]*syscall.PtraceRegs),
+		sigCodes: make(map[int]int32),
+	}
+	for _, p := range ef.Progs {
+		switch p.Type {
+		case elf.PT_LOAD:
+			c.segments = append(c.segments, coreSegment{p.Vaddr, p.Memsz, p.Off, p.Filesz})
+		case elf.PT_NOTE:
+			notes, err := io.ReadAll(p.Open())
+			if err == nil {
+				err = c.readNotes(notes)
+			}
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s: reading the notes: %w", path, err)
+			}
+		}
+	}
+	if len(c.Threads) == 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s has no threads", path)
+	}
+	if c.Pid == 0 {
+		c.Pid = c.Threads[0]
+	}
+	return c, nil
+}
+
+// readNotes reads the threads, the process and the mapped files from the
+// notes of a PT_NOTE segment.
+func (c *CoreProcess) readNotes(b []byte) error {
+	le := binary.LittleEndian
+	align := func(n uint32) int { return int(n+3) &^ 3 }
+	for len(b) >= 12 {
+		namesz, descsz, typ := le.Uint32(b), le.Uint32(b[4:]), le.Uint32(b[8:])
+		start := 12 + align(namesz)
+		if start+int(descsz) > len(b) {
+			return fmt.Errorf("truncated note of type %#x", typ)
+		}
+		desc := b[start : start+int(descsz)]
+		b = b[min(len(b), start+align(descsz)):]
+
+		switch typ {
+		case ntPrstatus:
+			if len(desc) < prstatusRegs+27*8 {
+				return fmt.Errorf("short NT_PRSTATUS")
+			}
+			tid := int(int32(le.Uint32(desc[32:])))
+			var regs syscall.PtraceRegs
+			binary.Read(bytes.NewReader(desc[prstatusRegs:]), le, &regs)
+			if len(c.Threads) == 0 {
+				c.Signal = syscall.Signal(le.Uint16(desc[12:]))
+			}
+			c.Threads = append(c.Threads, tid)
+			c.regs[tid] = &regs
+			c.sigCodes[tid] = int32(le.Uint32(desc[4:]))
+		case ntPrpsinfo:
+			if len(desc) >= 136 {
+				c.Pid = int(int32(le.Uint32(desc[24:])))
+				args, _, _ := bytes.Cut(desc[56:136], []byte{0})
+				c.Command = strings.TrimSpace(string(args))
+			}
+		case ntAuxv:
+			for i := 0; i+16 <= len(desc); i += 16 {
+				if le.Uint64(desc[i:]) == atEntry {
+					c.Entry = le.Uint64(desc[i+8:])
+				}
+			}
+		case ntFile:
+			if len(desc) < 16 {
+				return fmt.Errorf("short NT_FILE")
+			}
+			count, pageSize := le.Uint64(desc), le.Uint64(desc[8:])
+			if 16+count*24 > uint64(len(desc)) {
+				return fmt.Errorf("short NT_FILE")
+			}
+			names := strings.Split(string(desc[16+count*24:]), "\x00")
+			for i := range count {
+				e := desc[16+i*24:]
+				f := coreFile{start: le.Uint64(e), end: le.Uint64(e[8:]), off: le.Uint64(e[16:]) * pageSize}
+				if int(i) < len(names) {
+					f.path = names[i]
+				}
+				c.files = append(c.files, f)
+			}
+		}
+	}
+	return nil
+}
+
+// SetExecutable reads the executable's text and data the core left out
+// from path, in place of the file it was run from, which may have moved or
+// been rebuilt since. The executable is the file mapped at Entry.
+func (c *CoreProcess) SetExecutable(path string) {
+	exe := ""
+	for _, f := range c.files {
+		if f.start <= c.Entry && c.Entry < f.end {
+			exe = f.path
+		}
+	}
+	for i := range c.files {
+		if exe != "" && c.files[i].path == exe {
+			c.files[i].path = path
+		}
+	}
+}
+
+// Close closes the core file and the mapped files read from.
+func (c *CoreProcess) Close() error {
+	for _, f := range c.opened {
+		if f != nil {
+			f.Close()
+		}
+	}
+	return c.core.Close()
+}
+
+// ReadMemory reads memory from the core, and what it left out from the
+// mapped files. Memory in neither is unmapped.
+func (c *CoreProcess) ReadMemory(tid int, addr uint64, buf []byte) (int, error) {
+	done := 0
+	for done < len(buf) {
+		a := addr + uint64(done)
+		n, err := c.readAt(a, buf[done:])
+		done += n
+		if err != nil {
+			return done, err
+		}
+		if n == 0 {
+			return done, memoryError("read", a, done, syscall.EFAULT)
+		}
+	}
+	return done, nil
+}
+
+// readAt reads what it can of buf at addr from a single segment or file.
+func (c *CoreProcess) readAt(addr uint64, buf []byte) (int, error) {
+	for _, s := range c.segments {
+		if s.addr <= addr && addr < s.addr+s.filesz {
+			n := min(uint64(len(buf)), s.addr+s.filesz-addr)
+			return c.core.ReadAt(buf[:n], int64(s.off+addr-s.addr))
+		}
+	}
+	for _, m := range c.files {
+		if addr < m.start || addr >= m.end {
+			continue
+		}
+		f, ok := c.opened[m.path]
+		if !ok {
+			f, _ = os.Open(m.path)
+			c.opened[m.path] = f
+		}
+		if f == nil {
+			return 0, nil
+		}
+		n := min(uint64(len(buf)), m.end-addr)
+		read, err := f.ReadAt(buf[:n], int64(m.off+addr-m.start))
+		if err == io.EOF {
+			err = nil
+		}
+		return read, err
+	}
+	return 0, nil
+}
+
+func (c *CoreProcess) WriteMemory(tid int, addr uint64, data []byte) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (c *CoreProcess) GetRegs(tid int, regs *syscall.PtraceRegs) error {
+	r, ok := c.regs[tid]
+	if !ok {
+		return fmt.Errorf("no thread %d in the core", tid)
+	}
+	*regs = *r
+	return nil
+}
+
+func (c *CoreProcess) SetRegs(tid int, regs *syscall.PtraceRegs) error {
+	return ErrReadOnly
+}
+
+func (c *CoreProcess) Cont(tid, sig int) error { return ErrCoreFile }
+
+func (c *CoreProcess) Step(tid int) error { return ErrCoreFile }
+
+func (c *CoreProcess) Wait(pid int, ws *syscall.WaitStatus, ru *syscall.Rusage) (int, error) {
+	return 0, ErrCoreFile
+}
+
+func (c *CoreProcess) SigCode(tid int) (int32, bool) {
+	code, ok := c.sigCodes[tid]
+	return code, ok
+}
+
+// coreCommands are the word commands of a post-mortem session: those that
+// only read the target.
+var coreCommands = map[string]bool{
+	"alias":      true,
+	"backtrace":  true,
+	"ctx":        true,
+	"define":     true,
+	"disasm":     true,
+	"edit":       true,
+	"files":      true,
+	"funcs":      true,
+	"goroutine":  true,
+	"goroutines": true,
+	"help":       true,
+	"info":       true,
+	"list":       true,
+	"locals":     true,
+	"print":      true,
+	"printer":    true,
+	"regs":       true,
+	"stackdump":  true,
+	"threads":    true,
+	"x":          true,
+}
+
+// Core implements "core <binary> <corefile>", which debugs the process a
+// core file was dumped from, post mortem: the stack, registers, memory and
+// variables of its threads as they were, without ptrace. It returns the
+// exit code.
+func Core(args []string) int {
+	if len(args) != 2 {
+		fmt.Println("usage: core <binary> <corefile>")
+		return 2
+	}
+	d := NewDebugger()
+	if ui, ok := d.UI.(*LineFrontend); ok && ui.editor != nil {
+		ui.editor.complete = d.Complete
+	}
+	d.cancelOnInterrupt()
+	if err := d.Debug(&CoreTarget{Binary: args[0], Core: args[1]}); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// OpenCoreFile sets the debugger up on the core file core of the program
+// binary, read-only, and returns the thread that took the fatal signal,
+// whose registers are in Regs.
+func (d *Debugger) OpenCoreFile(binary, core string) (int, error) {
+	c, err := OpenCore(core)
+	if err != nil {
+		return 0, err
+	}
+	exe, err := elf.Open(binary)
+	if err != nil {
+		c.Close()
+		return 0, err
+	}
+	entry := exe.Entry
+	exe.Close()
+	if d.SymTable, err = d.GetSymbolTable(binary); err != nil {
+		c.Close()
+		return 0, err
+	}
+	if c.Entry != 0 {
+		if err := d.relocateTo(d.load.linkBase + c.Entry - entry); err != nil {
+			c.Close()
+			return 0, err
+		}
+	}
+	c.SetExecutable(binary)
+	d.Proc, d.Pid, d.ReadOnly, d.target = c, c.Pid, true, binary
+	for _, tid := range c.Threads {
+		d.addTracee(tid, c.Pid, c.Pid)
+	}
+	tid := c.Threads[0]
+	if err := d.switchThread(tid); err != nil {
+		c.Close()
+		return 0, err
+	}
+	d.printf("Core of process %d (%s), %d threads, killed by %s\n", c.Pid, c.Command, len(c.Threads), signalName(c.Signal))
+	return tid, nil
+}
+
+// switchThread makes tid the thread commands look at.
+func (d *Debugger) switchThread(tid int) error {
+	if err := d.proc().GetRegs(tid, &d.Regs); err != nil {
+		return err
+	}
+	_, _, d.Fn = d.pcToLine(d.Regs.Rip)
+	return nil
+}
+
+// postMortem runs the commands of a post-mortem session on the threads of
+// a core file, starting with tid, until quit or the end of the input.
+// "thread <tid>" switches threads, and "frame <n>" makes print and locals
+// look at frame n of the thread's stack, as backtrace numbers them.
+func (d *Debugger) postMortem(tid int) {
+	d.showThread(tid)
+	for {
+		input, ok := d.coreCommand()
+		if !ok {
+			return
+		}
+		fields := strings.Fields(d.expandAlias(input))
+		if len(fields) == 0 {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.ToLower(fields[0]), "/")
+		switch {
+		case name == "quit":
+			return
+		case name == "thread":
+			if len(fields) != 2 {
+				d.println("usage: thread <tid>")
+				continue
+			}
+			t, err := strconv.Atoi(fields[1])
+			if err == nil {
+				err = d.switchThread(t)
+			}
+			if err != nil {
+				d.println(err)
+				continue
+			}
+			tid = t
+			d.showThread(tid)
+		case name == "frame":
+			d.selectFrame(tid, fields[1:])
+		case coreCommands[name]:
+			d.runCommand(tid, input)
+		case wordCommands[name] != nil || resumeCommands[name] != nil:
+			d.printf("%s doesn't apply to the dead process of a core file\n", name)
+		default:
+			d.printf("Unexpected input %s\n", input)
+		}
+	}
+}
+
+// selectFrame handles "frame <n>" on thread tid of a core file.
+func (d *Debugger) selectFrame(tid int, args []string) {
+	n, err := 0, error(nil)
+	if len(args) == 1 {
+		n, err = strconv.Atoi(args[0])
+	}
+	if len(args) != 1 || err != nil || n < 0 {
+		d.println("usage: frame <n>")
+		return
+	}
+	var regs syscall.PtraceRegs
+	if err := d.proc().GetRegs(tid, &regs); err != nil {
+		d.println(err)
+		return
+	}
+	frames, frameRegs, err := d.walkFrames(tid, &regs, n+1)
+	if len(frames) <= n {
+		if err == nil {
+			err = fmt.Errorf("the stack has %d frames", len(frames))
+		}
+		d.printf("No frame %d: %v\n", n, err)
+		return
+	}
+	d.Regs, d.Fn = frameRegs[n], d.pcToFunc(frameRegs[n].Rip)
+	f := frames[n]
+	d.printf("  #%d %s\n", n, f)
+	d.showContext(tid)
+}
+
+// showThread shows where thread tid of a core file was, and its stack.
+func (d *Debugger) showThread(tid int) {
+	stop := d.locationEvent("stop", tid, d.Regs.Rip)
+	d.printf("Thread %d at %s\n", tid, stop.where())
+	d.showContext(tid)
+	d.backtraceCommand(tid, nil)
+}
+
+// coreCommand reads a command at the prompt of a post-mortem session.
+func (d *Debugger) coreCommand() (string, bool) {
+	if ui, ok := d.UI.(*LineFrontend); ok && len(ui.queued) == 0 {
+		return ui.readLine(corePrompt, true)
+	}
+	return d.UI.PromptCommand("")
+}
@@ -0,0 +1,218 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The layout of the notes of a core file written by WriteCore, as the
+// kernel writes them on amd64.
+const (
+	prstatusSize = 336
+	prpsinfoSize = 136
+	corePageSize = 4096
+)
+
+// procMapping is a mapping of /proc/<pid>/maps.
+type procMapping struct {
+	start, end, off uint64
+	perms, path     string
+	inode           uint64
+}
+
+// procMappings returns the mappings of the process pid.
+func procMappings(pid int) ([]procMapping, error) {
+	maps, err := os.ReadFile(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	var ms []procMapping
+	// start-end perms offset dev inode path
+	s := bufio.NewScanner(bytes.NewReader(maps))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		var m procMapping
+		lo, hi, _ := strings.Cut(fields[0], "-")
+		m.start, _ = strconv.ParseUint(lo, 16, 64)
+		m.end, _ = strconv.ParseUint(hi, 16, 64)
+		m.off, _ = strconv.ParseUint(fields[2], 16, 64)
+		m.inode, _ = strconv.ParseUint(fields[4], 10, 64)
+		m.perms = fields[1]
+		if len(fields) > 5 {
+			m.path = strings.Join(fields[5:], " ")
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// coreMappings returns the mappings of the process pid a core records, the
+// readable ones. [vvar] and [vsyscall] can't be read through its memory.
+func coreMappings(pid int) ([]procMapping, error) {
+	all, err := procMappings(pid)
+	if err != nil {
+		return nil, err
+	}
+	var ms []procMapping
+	for _, m := range all {
+		if m.perms[0] == 'r' && m.path != "[vvar]" && m.path != "[vsyscall]" {
+			ms = append(ms, m)
+		}
+	}
+	return ms, nil
+}
+
+// dumped reports whether a core holds the memory of m rather than leaving
+// it to be read from the file mapped there: the memory of a file that the
+// process can't write is the file's, but for the traps in its text.
+func (m procMapping) dumped() bool {
+	return m.inode == 0 || m.perms[1] == 'w'
+}
+
+// coreNote appends a note of type typ, named CORE, to b.
+func coreNote(b *bytes.Buffer, typ uint32, desc []byte) {
+	le := binary.LittleEndian
+	binary.Write(b, le, [3]uint32{5, uint32(len(desc)), typ})
+	b.WriteString("CORE\x00\x00\x00\x00")
+	b.Write(desc)
+	b.Write(make([]byte, (4-len(desc)%4)%4))
+}
+
+// WriteCore writes a core file of the process of the thread pid, stopped
+// with the others it holds, to path, which "core" and OpenCore read as a
+// kernel's: the registers of the stopped threads, pid first, and the
+// memory of the process but for the text and other read-only file
+// mappings, which are read from their files, free of the debugger's traps.
+// sig is recorded as the signal the process died of.
+func (d *Debugger) WriteCore(pid int, path string, sig syscall.Signal) error {
+	mappings, err := coreMappings(d.Pid)
+	if err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+
+	var notes bytes.Buffer
+	tids := []int{pid}
+	for tid, t := range d.tracees {
+		if tid != pid && t.proc == d.Pid && t.held {
+			tids = append(tids, tid)
+		}
+	}
+	for i, tid := range tids {
+		var regs syscall.PtraceRegs
+		if err := d.proc().GetRegs(tid, &regs); err != nil {
+			return wrapPtrace("reading registers of", tid, err)
+		}
+		desc := make([]byte, prstatusSize)
+		if i == 0 {
+			le.PutUint32(desc, uint32(sig))
+			le.PutUint16(desc[12:], uint16(sig))
+		}
+		le.PutUint32(desc[32:], uint32(tid))
+		var r bytes.Buffer
+		binary.Write(&r, le, &regs)
+		copy(desc[prstatusRegs:], r.Bytes())
+		coreNote(&notes, ntPrstatus, desc)
+	}
+
+	info := make([]byte, prpsinfoSize)
+	le.PutUint32(info[24:], uint32(d.Pid))
+	if comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", d.Pid)); err == nil {
+		copy(info[40:55], strings.TrimSpace(string(comm)))
+	}
+	if cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", d.Pid)); err == nil {
+		copy(info[56:prpsinfoSize-1], strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " ")))
+	}
+	coreNote(&notes, ntPrpsinfo, info)
+	if auxv, err := os.ReadFile(fmt.Sprintf("/proc/%d/auxv", d.Pid)); err == nil {
+		coreNote(&notes, ntAuxv, auxv)
+	}
+	var files, names bytes.Buffer
+	var nfiles uint64
+	for _, m := range mappings {
+		if m.inode == 0 || m.path == "" {
+			continue
+		}
+		binary.Write(&files, le, [3]uint64{m.start, m.end, m.off / corePageSize})
+		names.WriteString(m.path + "\x00")
+		nfiles++
+	}
+	fileNote := le.AppendUint64(le.AppendUint64(nil, nfiles), corePageSize)
+	fileNote = append(append(fileNote, files.Bytes()...), names.Bytes()...)
+	coreNote(&notes, ntFile, fileNote)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	phoff := uint64(binary.Size(elf.Header64{}))
+	phsize := uint64(binary.Size(elf.Prog64{}))
+	noteOff := phoff + phsize*uint64(len(mappings)+1)
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_CORE),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     phoff,
+		Ehsize:    uint16(phoff),
+		Phentsize: uint16(phsize),
+		Phnum:     uint16(len(mappings) + 1),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(w, le, &hdr)
+	binary.Write(w, le, &elf.Prog64{Type: uint32(elf.PT_NOTE), Off: noteOff, Filesz: uint64(notes.Len())})
+	off := (noteOff + uint64(notes.Len()) + corePageSize - 1) &^ (corePageSize - 1)
+	for _, m := range mappings {
+		p := elf.Prog64{Type: uint32(elf.PT_LOAD), Off: off, Vaddr: m.start, Memsz: m.end - m.start, Align: corePageSize}
+		for i, flag := range []elf.ProgFlag{elf.PF_R, elf.PF_W, elf.PF_X} {
+			if m.perms[i] != '-' {
+				p.Flags |= uint32(flag)
+			}
+		}
+		if m.dumped() {
+			p.Filesz = p.Memsz
+			off += p.Filesz
+		}
+		binary.Write(w, le, &p)
+	}
+	w.Write(notes.Bytes())
+	w.Write(make([]byte, (corePageSize-(noteOff+uint64(notes.Len()))%corePageSize)%corePageSize))
+
+	// Memory that can't be read, such as a guard page, is left zero.
+	buf := make([]byte, 1<<20)
+	for _, m := range mappings {
+		if !m.dumped() {
+			continue
+		}
+		for addr := m.start; addr < m.end; addr += uint64(len(buf)) {
+			chunk := buf[:min(uint64(len(buf)), m.end-addr)]
+			if d.readRunning(d.Pid, addr, chunk) != nil {
+				clear(chunk)
+			}
+			if _, err := w.Write(chunk); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,238 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxContextDepth bounds the walk up a chain of contexts.
+const maxContextDepth = 64
+
+// ctxCommand handles "ctx <variable>[.field...]", which walks the chain of
+// a context.Context from it up to its root and prints each context with
+// what it adds: a deadline, whether and why it was canceled, or a value.
+// The context nearest the root that was canceled is named as the one the
+// cancellation came from.
+func (d *Debugger) ctxCommand(pid int, args []string) bool {
+	if len(args) != 1 {
+		d.println("usage: ctx <variable>[.field...]")
+		return false
+	}
+	expr := args[0]
+	val, err := d.evalValue(pid, expr)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	if typeName(val.typ) != "context.Context" {
+		d.printf("%s is a %s, not a context.Context\n", expr, typeName(val.typ))
+		return false
+	}
+
+	var b strings.Builder
+	canceled, origin := -1, ""
+	buf := val.buf
+	for i := 0; ; i++ {
+		if i == maxContextDepth {
+			fmt.Fprintf(&b, "  ... more than %d contexts\n", maxContextDepth)
+			break
+		}
+		dyn, err := d.ifaceValue(pid, buf, false)
+		if err != nil {
+			fmt.Fprintf(&b, "  #%d <%v>\n", i, err)
+			break
+		}
+		if dyn.typ == nil {
+			fmt.Fprintf(&b, "  #%d nil\n", i)
+			break
+		}
+		desc, parent, err := d.describeContext(pid, dyn)
+		if err != nil {
+			fmt.Fprintf(&b, "  #%d %s <%v>\n", i, typeName(dyn.typ), err)
+			break
+		}
+		fmt.Fprintf(&b, "  #%d %s", i, typeName(dyn.typ))
+		if desc.text != "" {
+			fmt.Fprintf(&b, " %s", desc.text)
+		}
+		b.WriteByte('\n')
+		if desc.err != "" {
+			canceled, origin = i, fmt.Sprintf("#%d %s: %s", i, typeName(dyn.typ), desc.err)
+		}
+		if parent == nil {
+			break
+		}
+		buf = parent
+	}
+	if canceled >= 0 {
+		fmt.Fprintf(&b, "Canceled by %s\n", origin)
+	}
+	d.UI.ShowOutput(fmt.Sprintf("%s:\n%s", expr, b.String()))
+	return false
+}
+
+// contextDesc is what a context adds to its parent: text describes it, and
+// err is why it was canceled, if it was.
+type contextDesc struct {
+	text, err string
+}
+
+// describeContext describes the context dyn, the dynamic value of a
+// context.Context, and returns its parent context, nil for a root. The
+// contexts of package context are known by their fields; others end the
+// walk.
+func (d *Debugger) describeContext(pid int, dyn value) (contextDesc, []byte, error) {
+	name := typeName(dyn.typ)
+	switch name {
+	case "context.backgroundCtx":
+		return contextDesc{text: "(context.Background)"}, nil, nil
+	case "context.todoCtx":
+		return contextDesc{text: "(context.TODO)"}, nil, nil
+	}
+	if !strings.HasPrefix(strings.TrimPrefix(name, "*"), "context.") {
+		return contextDesc{text: "(not of package context, the chain ends here)"}, nil, nil
+	}
+
+	val := dyn
+	if p, ok := dyn.typ.(*dwarf.PtrType); ok {
+		addr := bufUint(dyn.buf, 8)
+		if addr == 0 {
+			return contextDesc{text: "nil"}, nil, nil
+		}
+		var err error
+		if val, err = d.readValueAt(pid, addr, p.Type); err != nil {
+			return contextDesc{}, nil, err
+		}
+	}
+	var desc contextDesc
+	var parts []string
+	field := func(path ...string) ([]byte, dwarf.Type, bool) {
+		off, err := fieldOffset(val.typ, path)
+		if err != nil {
+			return nil, nil, false
+		}
+		typ, err := fieldType(val.typ, path...)
+		if err != nil || off+typ.Size() > int64(len(val.buf)) {
+			return nil, nil, false
+		}
+		return val.buf[off : off+typ.Size()], typ, true
+	}
+
+	if buf, _, ok := field("key"); ok {
+		key := d.formatAny(pid, buf)
+		v, _, _ := field("val")
+		parts = append(parts, fmt.Sprintf("%s = %s", key, d.formatAny(pid, v)))
+	}
+	if buf, typ, ok := field("deadline"); ok {
+		if t, ok := decodeTime(typ, buf); ok {
+			parts = append(parts, fmt.Sprintf("deadline %s (%s)", t.Format(time.RFC3339Nano), untilNow(t)))
+		}
+	}
+	cancel := []string{}
+	if _, _, ok := field("cancelCtx"); ok {
+		cancel = []string{"cancelCtx"}
+	}
+	if errBuf, _, ok := field(append(cancel, "err", "v")...); ok {
+		if err := d.formatAny(pid, errBuf); err != "nil" {
+			desc.err = err
+			if cause, _, ok := field(append(cancel, "cause")...); ok {
+				if c := d.formatError(pid, cause); c != "nil" && c != err {
+					desc.err += ", cause " + c
+				}
+			}
+			parts = append(parts, "canceled: "+desc.err)
+		} else {
+			parts = append(parts, "not canceled")
+		}
+	}
+	if _, _, ok := field("f"); ok && strings.HasSuffix(name, "afterFuncCtx") {
+		parts = append(parts, "runs a function after it's done")
+	}
+	desc.text = strings.Join(parts, ", ")
+
+	for _, path := range [][]string{{"Context"}, {"cancelCtx", "Context"}, {"c"}} {
+		if parent, _, ok := field(path...); ok {
+			return desc, parent, nil
+		}
+	}
+	return desc, nil, nil
+}
+
+// formatAny formats the dynamic value of the empty interface in buf. The
+// errors of the context package, stored in one, are shown by their text.
+func (d *Debugger) formatAny(pid int, buf []byte) string {
+	dyn, err := d.ifaceValue(pid, buf, true)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	if dyn.typ == nil {
+		return "nil"
+	}
+	if s, ok := d.errorText(pid, dyn); ok {
+		return s
+	}
+	if t, ok := dyn.typ.(*dwarf.StructType); ok && len(t.Field) == 2 && t.Field[0].Name == "str" && t.Field[1].Name == "len" {
+		// A string type of its own, such as a key type.
+		if s, err := d.stringFromHeader(pid, dyn.buf); err == nil {
+			return fmt.Sprintf("%q (%s)", s, t.StructName)
+		}
+	}
+	return fmt.Sprintf("%s (%s)", d.formatValue(pid, dyn.typ, dyn.buf, 0), typeName(dyn.typ))
+}
+
+// formatError formats the dynamic value of the error interface in buf.
+func (d *Debugger) formatError(pid int, buf []byte) string {
+	dyn, err := d.ifaceValue(pid, buf, false)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	if dyn.typ == nil {
+		return "nil"
+	}
+	if s, ok := d.errorText(pid, dyn); ok {
+		return s
+	}
+	return fmt.Sprintf("%s (%s)", d.formatValue(pid, dyn.typ, dyn.buf, 0), typeName(dyn.typ))
+}
+
+// errorText returns the text of the errors whose Error methods are known:
+// those of errors.New and fmt.Errorf, and context.DeadlineExceeded.
+func (d *Debugger) errorText(pid int, dyn value) (string, bool) {
+	switch typeName(dyn.typ) {
+	case "context.deadlineExceededError":
+		return `"context deadline exceeded"`, true
+	case "*errors.errorString", "*fmt.wrapError", "*fmt.wrapErrors":
+		p := dyn.typ.(*dwarf.PtrType)
+		val, err := d.readValueAt(pid, bufUint(dyn.buf, 8), p.Type)
+		if err != nil {
+			return "", false
+		}
+		field := "s"
+		if typeName(dyn.typ) != "*errors.errorString" {
+			field = "msg"
+		}
+		off, err := fieldOffset(p.Type, []string{field})
+		if err != nil {
+			return "", false
+		}
+		s, err := d.stringFromHeader(pid, val.buf[off:])
+		if err != nil {
+			return "", false
+		}
+		return fmt.Sprintf("%q", s), true
+	}
+	return "", false
+}
+
+// untilNow describes how far t is from now: "in 4.2s" or "3s ago".
+func untilNow(t time.Time) string {
+	left := time.Until(t).Round(time.Millisecond)
+	if left < 0 {
+		return fmt.Sprintf("%v ago", -left)
+	}
+	return fmt.Sprintf("in %v", left)
+}
@@ -0,0 +1,293 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// Daemon hosts any number of independent sessions, each debugging its own
+// target, behind one REST listener, so that an ops box can run one
+// long-lived debugger rather than one per investigation:
+//
+//	POST   /sessions                     {"path": "./server", "args": [...]}
+//	GET    /sessions
+//	DELETE /sessions/{id}
+//	POST   /sessions/{id}/breakpoints    as RESTServer's /breakpoints
+//	POST   /sessions/{id}/continue       as /continue
+//	GET    /sessions/{id}/stacktrace     as /stacktrace
+//
+// Token and Tokens authenticate clients as RESTServer's do. Listing the
+// sessions needs RoleRead, and creating and deleting them RoleFull. The
+// targets of new sessions are programs under Root, and paths are taken
+// relative to it; without a Root no session can be created. When Audit is
+// set, the changes the clients make to the targets are logged to that file.
+type Daemon struct {
+	Token  string
+	Tokens Tokens
+	Root   string
+	Audit  string
+
+	mu       sync.Mutex
+	sessions map[string]*daemonSession
+	next     int
+}
+
+// daemonSession is a session of the daemon with what it was launched with.
+type daemonSession struct {
+	*Session
+	id   string
+	path string
+	args []string
+}
+
+// restSession is the JSON form of a daemon session.
+type restSession struct {
+	ID     string   `json:"id"`
+	Path   string   `json:"path"`
+	Args   []string `json:"args,omitempty"`
+	Pid    int      `json:"pid"`
+	Exited bool     `json:"exited"`
+}
+
+// ServeDaemon implements "daemon -rest addr -root dir [-token [role:]t]...
+// [-audit file]". Without a token it only serves on a loopback address,
+// since its clients run programs. It serves until SIGINT or SIGTERM, which
+// kill the targets of the sessions left, and returns the exit code.
+func ServeDaemon(args []string) int {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	restAddr := flags.String("rest", "", "serve the REST API for the sessions on `addr`")
+	tokens := Tokens{}
+	flags.Var(tokens, "token", tokenUsage)
+	root := flags.String("root", "", "launch only the programs under `dir`, where the paths of new sessions are relative to")
+	audit := flags.String("audit", "", "append a record of every change the clients make to the targets, and who made it, to `file`")
+	flags.Parse(args)
+	if *restAddr == "" || *root == "" || flags.NArg() > 0 {
+		fmt.Println("usage: daemon -rest addr -root dir [-token [role:]t]... [-audit file]")
+		flags.PrintDefaults()
+		return 2
+	}
+	if fi, err := os.Stat(*root); err != nil || !fi.IsDir() {
+		fmt.Printf("%s is not a directory\n", *root)
+		return 2
+	}
+	l, err := net.Listen("tcp", *restAddr)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := checkExposure(l.Addr(), tokens); err != nil {
+		l.Close()
+		fmt.Println(err)
+		return 2
+	}
+	dm := &Daemon{Tokens: tokens, Root: *root, Audit: *audit}
+	defer dm.Close()
+	fmt.Printf("Serving sessions on %s\n", l.Addr())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	var stopped atomic.Bool
+	go func() {
+		<-sigs
+		stopped.Store(true)
+		l.Close()
+	}()
+	if err := dm.Serve(l); err != nil && !stopped.Load() {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// Serve accepts connections on l until it is closed.
+func (dm *Daemon) Serve(l net.Listener) error {
+	r := &RESTServer{Token: dm.Token, Tokens: dm.Tokens}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sessions", r.handle(RoleFull, dm.create))
+	mux.HandleFunc("GET /sessions", r.handle(RoleRead, dm.list))
+	mux.HandleFunc("DELETE /sessions/{id}", r.handle(RoleFull, dm.remove))
+	mux.HandleFunc("POST /sessions/{id}/breakpoints", r.handle(RoleBreak, dm.session((*RESTServer).setBreakpoint)))
+	mux.HandleFunc("GET /sessions/{id}/breakpoints", r.handle(RoleRead, dm.session((*RESTServer).listBreakpoints)))
+	mux.HandleFunc("DELETE /sessions/{id}/breakpoints/{bp}", r.handle(RoleBreak, dm.session((*RESTServer).deleteBreakpoint)))
+	mux.HandleFunc("POST /sessions/{id}/continue", r.handle(RoleBreak, dm.session((*RESTServer).cont)))
+	mux.HandleFunc("GET /sessions/{id}/stacktrace", r.handle(RoleRead, dm.session((*RESTServer).stacktrace)))
+	return (&http.Server{Handler: mux, ConnContext: connContext("rest")}).Serve(l)
+}
+
+// Close closes every session, killing their targets.
+func (dm *Daemon) Close() {
+	dm.mu.Lock()
+	sessions := dm.sessions
+	dm.sessions = nil
+	dm.mu.Unlock()
+	for _, s := range sessions {
+		s.kill()
+	}
+}
+
+// session adapts an API function of RESTServer to the session the request
+// names.
+func (dm *Daemon) session(fn func(*RESTServer, *http.Request) (int, any, error)) func(*http.Request) (int, any, error) {
+	return func(req *http.Request) (int, any, error) {
+		s, err := dm.lookup(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		return fn(&RESTServer{Session: s.Session}, req)
+	}
+}
+
+func (dm *Daemon) lookup(req *http.Request) (*daemonSession, error) {
+	id := req.PathValue("id")
+	dm.mu.Lock()
+	s := dm.sessions[id]
+	dm.mu.Unlock()
+	if s == nil {
+		return nil, &restError{http.StatusNotFound, "no session " + strconv.Quote(id)}
+	}
+	return s, nil
+}
+
+// create launches the target of a new session, stopped at its first
+// instruction.
+func (dm *Daemon) create(req *http.Request) (int, any, error) {
+	var body struct {
+		Path string   `json:"path"`
+		Args []string `json:"args"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return 0, nil, &restError{http.StatusBadRequest, "bad request body: " + err.Error()}
+	}
+	if body.Path == "" {
+		return 0, nil, &restError{http.StatusBadRequest, "path is required"}
+	}
+	path, err := dm.targetPath(body.Path)
+	if err != nil {
+		return 0, nil, err
+	}
+	d := NewDebugger()
+	if dm.Audit != "" {
+		if err := d.OpenAudit(dm.Audit); err != nil {
+			return 0, nil, err
+		}
+	}
+	sess, err := LaunchDebugger(d, path, body.Args...)
+	if err != nil {
+		return 0, nil, &restError{http.StatusUnprocessableEntity, fmt.Sprintf("can't launch %s: %v", body.Path, err)}
+	}
+
+	dm.mu.Lock()
+	if dm.sessions == nil {
+		dm.sessions = make(map[string]*daemonSession)
+	}
+	dm.next++
+	s := &daemonSession{Session: sess, id: strconv.Itoa(dm.next), path: body.Path, args: body.Args}
+	dm.sessions[s.id] = s
+	dm.mu.Unlock()
+	return http.StatusCreated, s.info(), nil
+}
+
+// targetPath resolves path, relative to dm.Root, to the program it names,
+// which has to be under dm.Root once symbolic links are followed.
+func (dm *Daemon) targetPath(path string) (string, error) {
+	if dm.Root == "" {
+		return "", &restError{http.StatusForbidden, "the daemon has no root directory to launch programs from"}
+	}
+	root, err := filepath.Abs(dm.Root)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", &restError{http.StatusUnprocessableEntity, err.Error()}
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", &restError{http.StatusForbidden, fmt.Sprintf("%s is not under %s", path, dm.Root)}
+	}
+	return resolved, nil
+}
+
+// isLoopback reports whether a listener at addr can only be reached from
+// this host: on a loopback address, or a Unix socket.
+func isLoopback(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.IsLoopback()
+	case *net.UnixAddr:
+		return true
+	}
+	return false
+}
+
+// checkExposure refuses a server listening at addr off this host without a
+// token: any client may drive the target, and with DAP, run programs.
+func checkExposure(addr net.Addr, tokens Tokens) error {
+	if len(tokens) == 0 && !isLoopback(addr) {
+		return fmt.Errorf("not serving on %s without -token: anyone who can reach it could run programs on this host", addr)
+	}
+	return nil
+}
+
+func (dm *Daemon) list(req *http.Request) (int, any, error) {
+	dm.mu.Lock()
+	out := make([]restSession, 0, len(dm.sessions))
+	for _, s := range dm.sessions {
+		out = append(out, s.info())
+	}
+	dm.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		a, _ := strconv.Atoi(out[i].ID)
+		b, _ := strconv.Atoi(out[j].ID)
+		return a < b
+	})
+	return http.StatusOK, map[string]any{"sessions": out}, nil
+}
+
+// remove closes a session, killing its target if it still runs.
+func (dm *Daemon) remove(req *http.Request) (int, any, error) {
+	id := req.PathValue("id")
+	dm.mu.Lock()
+	s := dm.sessions[id]
+	delete(dm.sessions, id)
+	dm.mu.Unlock()
+	if s == nil {
+		return 0, nil, &restError{http.StatusNotFound, "no session " + strconv.Quote(id)}
+	}
+	s.kill()
+	return http.StatusOK, s.info(), nil
+}
+
+// kill kills the target and closes the session. The target is killed first,
+// so that an operation running it, a continue waiting for a stop, returns.
+func (s *daemonSession) kill() {
+	if exited, _ := s.Exited(); !exited {
+		syscall.Kill(-s.pid, syscall.SIGKILL)
+	}
+	s.Close()
+	s.d.closeAudit()
+}
+
+func (s *daemonSession) info() restSession {
+	exited, _ := s.Exited()
+	return restSession{ID: s.id, Path: s.path, Args: s.args, Pid: s.pid, Exited: exited}
+}
@@ -0,0 +1,1225 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// dapRequest is a request of the Debug Adapter Protocol.
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// dapAdapter is the frontend of a session driven by a Debug Adapter
+// Protocol client such as VS Code. Requests are read on the serving
+// goroutine and handed to the engine, which handles them at its prompts:
+// PromptCommand at a stop and PromptRunning while the target runs. Stops
+// become stopped events and printed text output events.
+type dapAdapter struct {
+	d    *Debugger
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+
+	wmu sync.Mutex // serializes messages
+	seq int
+
+	requests chan *dapRequest
+	wake     chan struct{} // ends a PromptRunning outstanding at a stop
+	done     chan struct{} // closed when the session ends
+
+	// program and args are launched if the launch request names no
+	// program.
+	program string
+	args    []string
+	// reconnect is what becomes of the target when the client's connection
+	// drops, "stop" or "continue", the session lasting for another client;
+	// with none, the session ends.
+	reconnect string
+	// disconnected is set once the client asked to disconnect, so its
+	// connection closing doesn't keep the session.
+	disconnected bool
+	// tokens authenticate the clients, which send one as the "token" of
+	// their launch or attach request; role is that of the connected
+	// client's, 0 until it sends it. With no tokens every client has full
+	// control.
+	tokens Tokens
+	role   Role
+	authed atomic.Bool // role is set, so events may be sent
+
+	mu      sync.Mutex
+	running bool
+	// deferred are requests read by PromptRunning that are handled at the
+	// next stop, forced if silent is set.
+	deferred []*dapRequest
+	silent   bool
+	threads  []map[string]any
+	rejoined bool   // the client attached to the session another one began
+	client   string // the connected client, as the audit log names it
+
+	// The rest is owned by the engine.
+	start       *dapRequest // the launch or attach request
+	started     bool
+	launched    bool
+	stopOnEntry bool
+	resumed     bool // a command resumed the target, which hasn't stopped since
+	stepping    bool // the command was a step
+	resume      bool // resume after the deferred requests of a silent stop
+	atStop      bool // between a stop and its prompt, whose output is dropped
+	capture     *strings.Builder
+	pid         int
+	frames      []syscall.PtraceRegs // by frame id - 1, reset at each stop
+	refs        []dapRef             // by variables reference - 1
+	fileBPs     map[string][]int
+	funcBPs     []int
+}
+
+// dapRef is what a variables reference expands to: the locals or registers
+// of a frame, or the fields or elements of a value.
+type dapRef struct {
+	regs      syscall.PtraceRegs
+	registers bool
+	val       *value
+}
+
+// dapRoles are the roles the requests need once the client has
+// authenticated. Starting a session, with launch or attach, terminating it
+// and running commands in the debug console need RoleFull, as do requests
+// missing here.
+var dapRoles = map[string]Role{
+	"disconnect":              0,
+	"configurationDone":       RoleRead,
+	"threads":                 RoleRead,
+	"stackTrace":              RoleRead,
+	"scopes":                  RoleRead,
+	"variables":               RoleRead,
+	"evaluate":                RoleRead,
+	"continue":                RoleBreak,
+	"next":                    RoleBreak,
+	"stepIn":                  RoleBreak,
+	"stepOut":                 RoleBreak,
+	"pause":                   RoleBreak,
+	"setBreakpoints":          RoleBreak,
+	"setFunctionBreakpoints":  RoleBreak,
+	"setExceptionBreakpoints": RoleBreak,
+}
+
+// ServeDAP implements "serve -dap addr [-reconnect policy] [-token
+// [role:]t]... [-audit file] [target [args...]]". It serves the Debug
+// Adapter Protocol to the first client to connect, debugging the target
+// given, or else the one its launch request names, or the process it
+// attaches to. With a reconnect policy, a client whose connection drops
+// leaves the session to the next one to connect. With tokens, each client
+// names one in its launch or attach request; without, only clients on this
+// host are served. The changes the clients make are logged
+// to the audit file, if any. It returns the exit code.
+func ServeDAP(addr, reconnect, audit string, tokens Tokens, args []string) int {
+	if reconnect != "" && reconnect != "stop" && reconnect != "continue" {
+		fmt.Printf("bad reconnect policy %q: stop or continue\n", reconnect)
+		return 2
+	}
+	network := "tcp"
+	if strings.Contains(addr, "/") {
+		network = "unix"
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer l.Close()
+	if err := checkExposure(l.Addr(), tokens); err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	fmt.Printf("Serving DAP on %s\n", l.Addr())
+
+	d := NewDebugger()
+	d.HandleSignals = true
+	d.ContextLines = 0
+	if audit != "" {
+		if err := d.OpenAudit(audit); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	a := &dapAdapter{
+		d:         d,
+		requests:  make(chan *dapRequest),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		fileBPs:   make(map[string][]int),
+		reconnect: reconnect,
+		tokens:    tokens,
+	}
+	if len(args) > 0 {
+		a.program, a.args = args[0], args[1:]
+	}
+	d.UI = a
+	// A session that ends between clients stops the wait for the next.
+	go func() {
+		<-a.done
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-a.done:
+				return 0
+			default:
+			}
+			fmt.Println(err)
+			return 1
+		}
+		if reconnect == "" {
+			l.Close()
+		}
+		if !a.serve(conn) {
+			return 0
+		}
+		fmt.Printf("DAP client gone, the session waits for another on %s\n", l.Addr())
+	}
+}
+
+// serve reads requests from conn until the client disconnects. Those about
+// the session itself are answered here, the others by the engine. It
+// reports whether the session lasts for another client, as the connection
+// dropped while it ran with a reconnect policy.
+func (a *dapAdapter) serve(conn io.ReadWriteCloser) bool {
+	a.wmu.Lock()
+	a.conn, a.r = conn, bufio.NewReader(conn)
+	a.wmu.Unlock()
+	if c, ok := conn.(net.Conn); ok {
+		a.mu.Lock()
+		a.client = connClient("dap", c)
+		a.mu.Unlock()
+	}
+	defer func() {
+		a.wmu.Lock()
+		a.conn = nil
+		a.wmu.Unlock()
+		conn.Close()
+	}()
+	a.role = 0
+	a.authed.Store(false)
+	if len(a.tokens) == 0 {
+		a.role = RoleFull
+		a.authed.Store(true)
+	}
+	rejoining := a.start != nil
+	for {
+		req, err := a.read()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				fmt.Println(err)
+			}
+			if a.reconnect != "" && a.start != nil && !a.disconnected && !a.ended() {
+				a.keep()
+				return true
+			}
+			a.kill()
+			return false
+		}
+		if req.Type != "request" {
+			continue
+		}
+		switch req.Command {
+		case "initialize":
+			a.respond(req, map[string]any{
+				"supportsConfigurationDoneRequest":  true,
+				"supportsFunctionBreakpoints":       true,
+				"supportsHitConditionalBreakpoints": true,
+				"supportsEvaluateForHovers":         true,
+				"supportsTerminateRequest":          true,
+			})
+		case "launch", "attach":
+			var auth struct {
+				Token string `json:"token"`
+			}
+			a.arguments(req, &auth)
+			role, ok := a.tokens.authorize("Bearer " + auth.Token)
+			if !ok {
+				a.role = 0
+				a.authed.Store(false)
+				a.fail(req, errors.New("missing or bad token"))
+				continue
+			}
+			a.role = role
+			a.authed.Store(true)
+			if rejoining {
+				// The session goes on with this client, told how it stands
+				// when it is done configuring.
+				rejoining = false
+				a.mu.Lock()
+				a.rejoined = true
+				a.mu.Unlock()
+				a.respond(req, nil)
+				a.event("initialized", nil)
+				continue
+			}
+			if a.start != nil {
+				a.fail(req, errors.New("a session is already running"))
+				continue
+			}
+			if err := a.permit(req); err != nil {
+				a.fail(req, err)
+				continue
+			}
+			if err := a.startEngine(req); err != nil {
+				a.fail(req, err)
+			}
+		case "terminate":
+			if err := a.permit(req); err != nil {
+				a.fail(req, err)
+				continue
+			}
+			a.kill()
+			a.respond(req, nil)
+		default:
+			if a.start == nil {
+				if req.Command == "disconnect" {
+					a.respond(req, nil)
+					return false
+				}
+				a.fail(req, errors.New("no target: send launch or attach first"))
+				continue
+			}
+			if err := a.permit(req); err != nil {
+				a.fail(req, err)
+				continue
+			}
+			if req.Command == "disconnect" {
+				a.disconnected = true
+			}
+			select {
+			case a.requests <- req:
+			case <-a.done:
+				if req.Command == "disconnect" {
+					a.respond(req, nil)
+					return false
+				}
+				a.fail(req, errors.New("the target has exited"))
+			}
+		}
+	}
+}
+
+// permit fails unless the client's role allows req, as dapRoles says.
+func (a *dapAdapter) permit(req *dapRequest) error {
+	needs, ok := dapRoles[req.Command]
+	if !ok {
+		needs = RoleFull
+	}
+	if req.Command == "evaluate" {
+		var args struct {
+			Context string `json:"context"`
+		}
+		a.arguments(req, &args)
+		if args.Context == "repl" {
+			needs = RoleFull
+		}
+	}
+	switch {
+	case a.role >= needs:
+		return nil
+	case a.role == 0:
+		return errors.New("not authenticated: send launch or attach with a token first")
+	}
+	return fmt.Errorf("the token's role, %s, doesn't allow %s; it needs %s", a.role, req.Command, needs)
+}
+
+// ended reports whether the session ended, the target having exited or
+// been detached.
+func (a *dapAdapter) ended() bool {
+	select {
+	case <-a.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// keep applies the reconnect policy to the target of a session whose client
+// is gone: it is stopped, or let run on. The requests doing it are answered
+// to no one.
+func (a *dapAdapter) keep() {
+	a.mu.Lock()
+	running := a.running
+	a.mu.Unlock()
+	var req *dapRequest
+	switch {
+	case a.reconnect == "stop" && running:
+		req = &dapRequest{Type: "request", Command: "pause"}
+	case a.reconnect == "continue" && !running:
+		req = &dapRequest{Type: "request", Command: "continue"}
+	default:
+		return
+	}
+	select {
+	case a.requests <- req:
+	case <-a.done:
+	}
+}
+
+// startEngine runs the session a launch or attach request asks for. The
+// request is answered at the first prompt, or with the error that ended
+// the session before it.
+func (a *dapAdapter) startEngine(req *dapRequest) error {
+	d := a.d
+	var run func() error
+	if req.Command == "launch" {
+		var args struct {
+			Program     string            `json:"program"`
+			Args        []string          `json:"args"`
+			Env         map[string]string `json:"env"`
+			Cwd         string            `json:"cwd"`
+			StopOnEntry bool              `json:"stopOnEntry"`
+		}
+		if err := a.arguments(req, &args); err != nil {
+			return err
+		}
+		// A program given on the command line is the only one the
+		// clients may run.
+		if a.program != "" {
+			args.Program, args.Args = a.program, a.args
+		}
+		if args.Program == "" {
+			return errors.New("no program to launch")
+		}
+		if _, err := os.Stat(args.Program); err != nil {
+			return err
+		}
+		cfg := LaunchConfig{Path: args.Program, Args: args.Args, Dir: args.Cwd}
+		for k, v := range args.Env {
+			cfg.Env = append(cfg.Env, k+"="+v)
+		}
+		a.launched, a.stopOnEntry = true, args.StopOnEntry
+		run = func() error { return d.Launch(cfg) }
+	} else {
+		var args struct {
+			ProcessID   int  `json:"processId"`
+			Force       bool `json:"force"`
+			StopOnEntry bool `json:"stopOnEntry"`
+		}
+		if err := a.arguments(req, &args); err != nil {
+			return err
+		}
+		report, err := CheckAttach(args.ProcessID)
+		if err != nil {
+			return err
+		}
+		if len(report.Problems) > 0 && !args.Force {
+			return fmt.Errorf("%snot attaching; set force to attach anyway", report)
+		}
+		a.stopOnEntry = args.StopOnEntry
+		run = func() error { return d.Attach(args.ProcessID) }
+	}
+
+	a.start = req
+	go func() {
+		err := run()
+		if !a.started {
+			if err == nil {
+				err = errors.New("the target exited before it could be debugged")
+			}
+			a.fail(a.start, err)
+		} else if err != nil && !errors.Is(err, ErrQuit) {
+			a.output(err.Error() + "\n")
+		}
+		if d.Ws.Exited() {
+			a.event("exited", map[string]any{"exitCode": d.Ws.ExitStatus()})
+		} else if d.Ws.Signaled() {
+			a.event("exited", map[string]any{"exitCode": 128 + int(d.Ws.Signal())})
+		}
+		a.event("terminated", nil)
+		close(a.done)
+	}()
+	return nil
+}
+
+// kill kills a launched target, which shouldn't outlive its client.
+func (a *dapAdapter) kill() {
+	if a.launched && a.d.Pid > 0 {
+		syscall.Kill(a.d.Pid, syscall.SIGKILL)
+	}
+}
+
+func (a *dapAdapter) PromptCommand(arg string) (string, bool) {
+	if arg != "" {
+		// Commands asking for arguments get none: the client sends them
+		// whole.
+		return "", true
+	}
+	a.atStop = false
+	a.setRunning(false)
+	if !a.started {
+		a.started = true
+		a.pid = a.d.Pid
+		a.listThreads()
+		a.respond(a.start, nil)
+		a.event("initialized", nil)
+	} else if a.resumed {
+		// The command that was to resume the target failed, as a next
+		// from a function without line information: it is still stopped.
+		a.resumed = false
+		a.stopped("pause")
+	}
+
+	a.mu.Lock()
+	deferred := a.deferred
+	a.deferred, a.silent = nil, false
+	a.mu.Unlock()
+	for _, req := range deferred {
+		if cmd, ok := a.handle(req); ok {
+			return a.resumeWith(cmd)
+		} else if a.d.quitting {
+			// Disconnected.
+			return "", false
+		}
+	}
+	if a.resume {
+		a.resume = false
+		return a.resumeWith("C")
+	}
+	for req := range a.requests {
+		if cmd, ok := a.handle(req); ok {
+			return a.resumeWith(cmd)
+		} else if a.d.quitting {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func (a *dapAdapter) resumeWith(cmd string) (string, bool) {
+	a.resumed, a.stepping = true, cmd != "C"
+	a.setRunning(true)
+	return cmd, true
+}
+
+func (a *dapAdapter) setRunning(running bool) {
+	a.mu.Lock()
+	a.running = running
+	a.mu.Unlock()
+}
+
+// handle handles req at a stop. It returns the command resuming the target
+// if req does.
+func (a *dapAdapter) handle(req *dapRequest) (string, bool) {
+	d := a.d
+	switch req.Command {
+	case "configurationDone":
+		a.respond(req, nil)
+		a.mu.Lock()
+		rejoined := a.rejoined
+		a.rejoined = false
+		a.mu.Unlock()
+		if rejoined {
+			// A client joining the session is shown where it stopped.
+			a.stopped("pause")
+			return "", false
+		}
+		if a.stopOnEntry {
+			a.stopped("entry")
+			return "", false
+		}
+		return "C", true
+	case "continue", "next", "stepIn", "stepOut":
+		a.respond(req, map[string]any{"allThreadsContinued": true})
+		return map[string]string{"continue": "C", "next": "N", "stepIn": "S", "stepOut": "F"}[req.Command], true
+	case "pause":
+		a.respond(req, nil)
+		a.stopped("pause")
+	case "threads":
+		threads, _ := a.listThreads()
+		a.respond(req, map[string]any{"threads": threads})
+	case "stackTrace":
+		a.stackTrace(req)
+	case "scopes":
+		a.scopes(req)
+	case "variables":
+		a.variables(req)
+	case "evaluate":
+		return a.evaluate(req)
+	case "setBreakpoints":
+		a.setBreakpoints(req)
+	case "setFunctionBreakpoints":
+		a.setFunctionBreakpoints(req)
+	case "setExceptionBreakpoints":
+		a.respond(req, map[string]any{"breakpoints": []any{}})
+	case "disconnect":
+		var args struct {
+			TerminateDebuggee *bool `json:"terminateDebuggee"`
+		}
+		a.arguments(req, &args)
+		a.respond(req, nil)
+		if args.TerminateDebuggee != nil && *args.TerminateDebuggee || args.TerminateDebuggee == nil && a.launched {
+			syscall.Kill(d.Pid, syscall.SIGKILL)
+			d.quitting = true
+			return "", false
+		}
+		d.detachCommand(a.pid, nil)
+	default:
+		a.fail(req, fmt.Errorf("%s isn't supported", req.Command))
+	}
+	return "", false
+}
+
+// Client names the connected client for the audit log.
+func (a *dapAdapter) Client() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.client
+}
+
+// Async reports true: requests are taken while the target runs.
+func (a *dapAdapter) Async() bool {
+	return true
+}
+
+// PromptRunning handles the requests that arrive while the target runs.
+// Those that need it stopped are deferred to the next stop, which is
+// forced without the client seeing it.
+func (a *dapAdapter) PromptRunning() (string, bool) {
+	for {
+		var req *dapRequest
+		select {
+		case <-a.wake:
+			return "", true
+		case req = <-a.requests:
+		}
+
+		a.mu.Lock()
+		if !a.running {
+			// The target stopped meanwhile.
+			a.deferred = append(a.deferred, req)
+			a.mu.Unlock()
+			return "", true
+		}
+		switch req.Command {
+		case "setBreakpoints", "setFunctionBreakpoints", "stackTrace", "scopes", "variables", "evaluate":
+			a.deferred = append(a.deferred, req)
+			a.silent = true
+			a.mu.Unlock()
+			return "I", true
+		case "threads":
+			a.respond(req, map[string]any{"threads": a.threads})
+			a.mu.Unlock()
+			continue
+		case "configurationDone":
+			// A client joining the session while the target runs.
+			a.rejoined = false
+			a.respond(req, nil)
+			a.mu.Unlock()
+			continue
+		}
+		a.mu.Unlock()
+
+		switch req.Command {
+		case "pause":
+			a.respond(req, nil)
+			return "I", true
+		case "continue":
+			a.respond(req, map[string]any{"allThreadsContinued": true})
+		case "disconnect":
+			var args struct {
+				TerminateDebuggee *bool `json:"terminateDebuggee"`
+			}
+			a.arguments(req, &args)
+			a.respond(req, nil)
+			if args.TerminateDebuggee != nil && *args.TerminateDebuggee || args.TerminateDebuggee == nil && a.launched {
+				syscall.Kill(a.d.Pid, syscall.SIGKILL)
+				return "", true
+			}
+			return "Q", true
+		default:
+			a.fail(req, errors.New("the target is running"))
+		}
+	}
+}
+
+// ShowStop sends a stopped event, except for the stops forced to handle
+// deferred requests and the first one, which the launch response stands
+// for.
+func (a *dapAdapter) ShowStop(stop Event) {
+	a.pid = stop.Pid
+	a.resumed = false
+	a.frames, a.refs = nil, nil
+	a.atStop = true
+	a.setRunning(false)
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+	if !a.started {
+		return
+	}
+	a.mu.Lock()
+	silent := a.silent && stop.Reason == "interrupt"
+	a.mu.Unlock()
+	if silent {
+		a.resume = true
+		return
+	}
+	reason := stop.Reason
+	switch {
+	case reason == "interrupt":
+		reason = "pause"
+	case reason == "watchpoint" || reason == "watch":
+		reason = "data breakpoint"
+	case reason == "catch" || reason == "signal" || reason == "fatal" || reason == "race":
+		reason = "exception"
+	case reason == "next" || reason == "finish" || a.stepping && a.d.breakpointAt(stop.File, stop.Line) == nil:
+		// Next and finish end at temporary breakpoints.
+		reason = "step"
+	}
+	a.stopped(reason)
+}
+
+func (a *dapAdapter) stopped(reason string) {
+	_, current := a.listThreads()
+	a.event("stopped", map[string]any{"reason": reason, "threadId": current, "allThreadsStopped": true})
+}
+
+// ShowOutput sends text as console output, except the stack printed at
+// each stop, which the client shows its own way.
+func (a *dapAdapter) ShowOutput(text string) {
+	switch {
+	case a.capture != nil:
+		a.capture.WriteString(text)
+	case !a.atStop:
+		a.output(text)
+	}
+}
+
+func (a *dapAdapter) output(text string) {
+	a.event("output", map[string]any{"category": "console", "output": text})
+}
+
+// Confirm says yes: the client asks its user before sending a request.
+func (a *dapAdapter) Confirm(question string) bool {
+	return true
+}
+
+// listThreads lists the goroutines as threads, or the stopped thread if
+// they can't be read, and remembers them for the threads requests that
+// arrive while the target runs. It also returns the id of the stopped one.
+func (a *dapAdapter) listThreads() ([]map[string]any, int) {
+	var threads []map[string]any
+	current := 1
+	gs, err := a.d.Goroutines(a.pid)
+	for _, g := range gs {
+		threads = append(threads, map[string]any{"id": int(g.ID), "name": fmt.Sprintf("Goroutine %d", g.ID)})
+		if g.Current {
+			current = int(g.ID)
+		}
+	}
+	if err != nil || len(threads) == 0 {
+		threads, current = []map[string]any{{"id": 1, "name": fmt.Sprintf("Thread %d", a.pid)}}, 1
+	}
+	a.mu.Lock()
+	a.threads = threads
+	a.mu.Unlock()
+	return threads, current
+}
+
+// threadRegs returns the registers a goroutine stopped with, as far as
+// they are known.
+func (a *dapAdapter) threadRegs(id int) (syscall.PtraceRegs, error) {
+	gs, err := a.d.Goroutines(a.pid)
+	if err != nil || len(gs) == 0 {
+		return a.d.Regs, nil
+	}
+	for _, g := range gs {
+		if int(g.ID) != id {
+			continue
+		}
+		switch {
+		case g.Current:
+			return a.d.Regs, nil
+		case g.Running:
+			return syscall.PtraceRegs{}, fmt.Errorf("goroutine %d is running on another thread", id)
+		}
+		return syscall.PtraceRegs{Rip: g.PC, Rsp: g.SP}, nil
+	}
+	return syscall.PtraceRegs{}, fmt.Errorf("no goroutine %d", id)
+}
+
+func (a *dapAdapter) stackTrace(req *dapRequest) {
+	var args struct {
+		ThreadID   int `json:"threadId"`
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}
+	if err := a.arguments(req, &args); err != nil {
+		a.fail(req, err)
+		return
+	}
+	regs, err := a.threadRegs(args.ThreadID)
+	if err != nil {
+		a.fail(req, err)
+		return
+	}
+	frames, fregs, err := a.d.unwind(a.pid, regs, maxGoroutineFrames)
+	if len(frames) == 0 {
+		if err == nil {
+			err = errors.New("no frames")
+		}
+		a.fail(req, err)
+		return
+	}
+	end := len(frames)
+	if args.Levels > 0 {
+		end = min(end, args.StartFrame+args.Levels)
+	}
+	stackFrames := []map[string]any{}
+	for i := args.StartFrame; i < end; i++ {
+		f := frames[i]
+		a.frames = append(a.frames, fregs[i])
+		stackFrames = append(stackFrames, map[string]any{
+			"id":                          len(a.frames),
+			"name":                        f.Func,
+			"line":                        f.Line,
+			"column":                      1,
+			"source":                      map[string]any{"name": filepath.Base(f.File), "path": f.File},
+			"instructionPointerReference": fmt.Sprintf("%#x", f.PC),
+		})
+	}
+	a.respond(req, map[string]any{"stackFrames": stackFrames, "totalFrames": len(frames)})
+}
+
+// frame returns the registers of the frame id of a stack trace, or those of
+// the stopped thread for id 0.
+func (a *dapAdapter) frame(id int) (syscall.PtraceRegs, error) {
+	if id == 0 {
+		return a.d.Regs, nil
+	}
+	if id < 0 || id > len(a.frames) {
+		return syscall.PtraceRegs{}, fmt.Errorf("no frame %d", id)
+	}
+	return a.frames[id-1], nil
+}
+
+// ref returns a new variables reference to r.
+func (a *dapAdapter) ref(r dapRef) int {
+	a.refs = append(a.refs, r)
+	return len(a.refs)
+}
+
+func (a *dapAdapter) scopes(req *dapRequest) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := a.arguments(req, &args); err != nil {
+		a.fail(req, err)
+		return
+	}
+	regs, err := a.frame(args.FrameID)
+	if err != nil {
+		a.fail(req, err)
+		return
+	}
+	scopes := []map[string]any{{"name": "Locals", "variablesReference": a.ref(dapRef{regs: regs}), "expensive": false}}
+	// Only the innermost frame of the stopped thread has all its registers.
+	if regs == a.d.Regs {
+		scopes = append(scopes, map[string]any{"name": "Registers", "variablesReference": a.ref(dapRef{regs: regs, registers: true}), "expensive": false})
+	}
+	a.respond(req, map[string]any{"scopes": scopes})
+}
+
+func (a *dapAdapter) variables(req *dapRequest) {
+	var args struct {
+		Ref int `json:"variablesReference"`
+	}
+	if err := a.arguments(req, &args); err != nil {
+		a.fail(req, err)
+		return
+	}
+	if args.Ref < 1 || args.Ref > len(a.refs) {
+		a.fail(req, fmt.Errorf("no variables reference %d", args.Ref))
+		return
+	}
+	r := a.refs[args.Ref-1]
+	vars := []map[string]any{}
+	switch {
+	case r.registers:
+		for _, name := range registerNames {
+			vars = append(vars, map[string]any{"name": name, "value": fmt.Sprintf("%#x", *register(&r.regs, name)), "variablesReference": 0})
+		}
+	case r.val != nil:
+		for _, c := range a.children(*r.val) {
+			vars = append(vars, a.variable(c.name, c.val))
+		}
+	default:
+		locals, err := a.d.Variables(r.regs.Rip)
+		if err != nil {
+			a.fail(req, err)
+			return
+		}
+		for _, v := range locals {
+			if strings.HasPrefix(v.Name, "~") {
+				continue
+			}
+			val, err := a.d.variableValue(a.pid, &r.regs, v)
+			if err != nil {
+				vars = append(vars, map[string]any{"name": v.Name, "value": fmt.Sprintf("<%v>", err), "variablesReference": 0})
+				continue
+			}
+			vars = append(vars, a.variable(v.Name, val))
+		}
+	}
+	a.respond(req, map[string]any{"variables": vars})
+}
+
+// variable describes val as a DAP variable, with a reference to its fields
+// or elements if it has any.
+func (a *dapAdapter) variable(name string, val value) map[string]any {
+	v := map[string]any{
+		"name":               name,
+		"value":              a.d.formatValue(a.pid, val.typ, val.buf, 0),
+		"type":               val.typ.String(),
+		"variablesReference": 0,
+	}
+	if hasChildren(val) {
+		v["variablesReference"] = a.ref(dapRef{val: &val})
+	}
+	return v
+}
+
+// hasChildren reports whether val has fields or elements to expand, or is
+// a pointer to follow.
+func hasChildren(val value) bool {
+	typ := val.typ
+	for {
+		t, ok := typ.(*dwarf.TypedefType)
+		if !ok {
+			break
+		}
+		typ = t.Type
+	}
+	switch t := typ.(type) {
+	case *dwarf.StructType:
+		return t.StructName != "string" && len(t.Field) > 0
+	case *dwarf.ArrayType:
+		return t.Count > 0
+	case *dwarf.PtrType:
+		_, void := t.Type.(*dwarf.VoidType)
+		return t.Type != nil && !void && len(val.buf) >= 8 && bufUint(val.buf, 8) != 0
+	}
+	return false
+}
+
+type namedValue struct {
+	name string
+	val  value
+}
+
+// children returns the fields of a struct, the elements of an array or a
+// slice up to maxPrintElems, or what a pointer points to: the fields of a
+// struct, as Go selects them through it, or else the value itself.
+func (a *dapAdapter) children(val value) []namedValue {
+	typ := val.typ
+	for {
+		t, ok := typ.(*dwarf.TypedefType)
+		if !ok {
+			break
+		}
+		typ = t.Type
+	}
+	at := func(i int64, elem dwarf.Type, buf []byte, addr uint64) namedValue {
+		size := elem.Size()
+		v := value{elem, buf[i*size : (i+1)*size], 0}
+		if addr != 0 {
+			v.addr = addr + uint64(i*size)
+		}
+		return namedValue{fmt.Sprintf("[%d]", i), v}
+	}
+
+	var children []namedValue
+	switch t := typ.(type) {
+	case *dwarf.StructType:
+		if strings.HasPrefix(t.StructName, "[]") {
+			var array, n uint64
+			var elem dwarf.Type
+			for _, f := range t.Field {
+				v := bufUint(val.buf[f.ByteOffset:], 8)
+				switch f.Name {
+				case "array":
+					array = v
+					if p, ok := f.Type.(*dwarf.PtrType); ok {
+						elem = p.Type
+					}
+				case "len":
+					n = v
+				}
+			}
+			size := int64(0)
+			if elem != nil {
+				size = elem.Size()
+			}
+			if array == 0 || size <= 0 {
+				return nil
+			}
+			shown := int64(min(n, maxPrintElems))
+			data := make([]byte, min(shown*size, maxValueSize))
+			if err := a.d.ReadMemory(a.pid, array, data); err != nil {
+				return nil
+			}
+			for i := int64(0); i < int64(len(data))/size; i++ {
+				children = append(children, at(i, elem, data, array))
+			}
+			return children
+		}
+		for _, f := range t.Field {
+			end := f.ByteOffset + f.Type.Size()
+			if f.ByteOffset < 0 || end > int64(len(val.buf)) {
+				continue
+			}
+			v := value{f.Type, val.buf[f.ByteOffset:end], 0}
+			if val.addr != 0 {
+				v.addr = val.addr + uint64(f.ByteOffset)
+			}
+			children = append(children, namedValue{f.Name, v})
+		}
+	case *dwarf.ArrayType:
+		size := t.Type.Size()
+		if size <= 0 {
+			return nil
+		}
+		for i := int64(0); i < min(t.Count, maxPrintElems) && (i+1)*size <= int64(len(val.buf)); i++ {
+			children = append(children, at(i, t.Type, val.buf, val.addr))
+		}
+	case *dwarf.PtrType:
+		target, err := a.d.readValueAt(a.pid, bufUint(val.buf, 8), t.Type)
+		if err != nil {
+			return nil
+		}
+		if s, ok := target.typ.(*dwarf.StructType); ok && s.StructName != "string" && !strings.HasPrefix(s.StructName, "[]") {
+			return a.children(target)
+		}
+		children = append(children, namedValue{"*", target})
+	}
+	return children
+}
+
+// evaluate evaluates an expression in a frame. In the debug console the
+// debugger's word commands run too, their output being the result.
+func (a *dapAdapter) evaluate(req *dapRequest) (string, bool) {
+	d := a.d
+	var args struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+		Context    string `json:"context"`
+	}
+	if err := a.arguments(req, &args); err != nil {
+		a.fail(req, err)
+		return "", false
+	}
+	expr := strings.TrimSpace(args.Expression)
+	if fields := strings.Fields(expr); args.Context == "repl" && len(fields) > 0 && d.isCommand(strings.ToLower(fields[0])) {
+		a.capture = new(strings.Builder)
+		_, cont := d.wordCommand(a.pid, expr)
+		result := a.capture.String()
+		a.capture = nil
+		a.respond(req, map[string]any{"result": strings.TrimRight(result, "\n"), "variablesReference": 0})
+		if cont {
+			return "C", true
+		}
+		return "", false
+	}
+
+	regs, err := a.frame(args.FrameID)
+	if err != nil {
+		a.fail(req, err)
+		return "", false
+	}
+	saved := d.Regs
+	d.Regs = regs
+	val, err := d.evalValue(a.pid, expr)
+	d.Regs = saved
+	if err != nil {
+		a.fail(req, err)
+		return "", false
+	}
+	v := a.variable(expr, val)
+	a.respond(req, map[string]any{"result": v["value"], "type": v["type"], "variablesReference": v["variablesReference"]})
+	return "", false
+}
+
+// dapBreakpoint is the breakpoint of a set*Breakpoints response for a
+// breakpoint set, or the error that prevented it.
+func (a *dapAdapter) dapBreakpoint(file string, line int, condition, hitCondition string, err error) (map[string]any, int) {
+	bp := map[string]any{"verified": false, "line": line}
+	if err == nil && condition != "" {
+		err = errors.New("conditions aren't supported; use a hit count")
+	}
+	var cond *HitCondition
+	if err == nil && hitCondition != "" {
+		cond, err = ParseHitCondition(hitCondition)
+	}
+	var b *Breakpoint
+	if err == nil {
+		b, err = a.d.AddBreakpoint(a.pid, file, line, cond)
+		a.d.audit("", "breakpoint", a.pid, fmt.Sprintf("%s:%d", file, line), err)
+	}
+	if err != nil {
+		bp["message"] = err.Error()
+		return bp, 0
+	}
+	bp["verified"], bp["id"], bp["line"] = true, b.ID, b.Line
+	bp["source"] = map[string]any{"name": filepath.Base(b.File), "path": b.File}
+	return bp, b.ID
+}
+
+func (a *dapAdapter) setBreakpoints(req *dapRequest) {
+	var args struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line         int    `json:"line"`
+			Condition    string `json:"condition"`
+			HitCondition string `json:"hitCondition"`
+		} `json:"breakpoints"`
+	}
+	if err := a.arguments(req, &args); err != nil {
+		a.fail(req, err)
+		return
+	}
+	path := args.Source.Path
+	for _, id := range a.fileBPs[path] {
+		a.d.audit("", "delete-breakpoint", a.pid, strconv.Itoa(id), a.d.RemoveBreakpoint(id))
+	}
+	delete(a.fileBPs, path)
+	file, err := a.d.ResolveFile(path)
+	bps := []map[string]any{}
+	for _, sb := range args.Breakpoints {
+		bp, id := a.dapBreakpoint(file, sb.Line, sb.Condition, sb.HitCondition, err)
+		if id != 0 {
+			a.fileBPs[path] = append(a.fileBPs[path], id)
+		}
+		bps = append(bps, bp)
+	}
+	a.respond(req, map[string]any{"breakpoints": bps})
+}
+
+func (a *dapAdapter) setFunctionBreakpoints(req *dapRequest) {
+	var args struct {
+		Breakpoints []struct {
+			Name         string `json:"name"`
+			Condition    string `json:"condition"`
+			HitCondition string `json:"hitCondition"`
+		} `json:"breakpoints"`
+	}
+	if err := a.arguments(req, &args); err != nil {
+		a.fail(req, err)
+		return
+	}
+	for _, id := range a.funcBPs {
+		a.d.audit("", "delete-breakpoint", a.pid, strconv.Itoa(id), a.d.RemoveBreakpoint(id))
+	}
+	a.funcBPs = nil
+	bps := []map[string]any{}
+	for _, fb := range args.Breakpoints {
+		file, line, err := a.d.parseLocation(fb.Name)
+		bp, id := a.dapBreakpoint(file, line, fb.Condition, fb.HitCondition, err)
+		if id != 0 {
+			a.funcBPs = append(a.funcBPs, id)
+		}
+		bps = append(bps, bp)
+	}
+	a.respond(req, map[string]any{"breakpoints": bps})
+}
+
+// read reads a message: headers, of which Content-Length counts, a blank
+// line and a JSON body.
+func (a *dapAdapter) read() (*dapRequest, error) {
+	n := -1
+	for {
+		line, err := a.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if n >= 0 {
+				break
+			}
+			continue
+		}
+		if v, ok := strings.CutPrefix(line, "Content-Length:"); ok {
+			if n, err = strconv.Atoi(strings.TrimSpace(v)); err != nil || n < 0 {
+				return nil, fmt.Errorf("bad DAP header %q", line)
+			}
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(a.r, body); err != nil {
+		return nil, err
+	}
+	var req dapRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("bad DAP message: %v", err)
+	}
+	return &req, nil
+}
+
+func (a *dapAdapter) arguments(req *dapRequest, v any) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	return json.Unmarshal(req.Arguments, v)
+}
+
+func (a *dapAdapter) send(msg map[string]any) {
+	a.wmu.Lock()
+	defer a.wmu.Unlock()
+	a.seq++
+	msg["seq"] = a.seq
+	b, err := json.Marshal(msg)
+	if err != nil || a.conn == nil {
+		// Between clients, messages are lost.
+		return
+	}
+	fmt.Fprintf(a.conn, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+func (a *dapAdapter) respond(req *dapRequest, body any) {
+	msg := map[string]any{"type": "response", "request_seq": req.Seq, "command": req.Command, "success": true}
+	if body != nil {
+		msg["body"] = body
+	}
+	a.send(msg)
+}
+
+func (a *dapAdapter) fail(req *dapRequest, err error) {
+	a.send(map[string]any{"type": "response", "request_seq": req.Seq, "command": req.Command, "success": false, "message": err.Error()})
+}
+
+func (a *dapAdapter) event(name string, body any) {
+	if !a.authed.Load() {
+		return
+	}
+	msg := map[string]any{"type": "event", "event": name}
+	if body != nil {
+		msg["body"] = body
+	}
+	a.send(msg)
+}
@@ -0,0 +1,587 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// DataWatch stops the target when it writes a variable, struct field or
+// address, or with Reads when it reads it as well. It is implemented with
+// the CPU's debug registers, so the target runs at full speed until the
+// access.
+type DataWatch struct {
+	ID    int
+	Expr  string
+	Addr  uint64
+	Size  int64
+	Reads bool
+	// Stacks shows the stack of the goroutine that changed the memory at
+	// each stop, as "watch-global" does.
+	Stacks bool
+
+	typ   dwarf.Type // nil for a watch of an address
+	old   []byte
+	slots []int // the debug registers it uses
+	// stack locates memory on a goroutine stack by its distance from the
+	// top, which copying the stack keeps; its g is 0 for other memory.
+	stack frameID
+	// scope is the frame of the local variable Expr starts with, which
+	// returns to ret. Its g is 0 for package level variables.
+	scope frameID
+	ret   uint64
+	// access is set for the writing flag of a map caught with "catch
+	// mapaccess", which Expr evaluates to the map of.
+	access *mapAccess
+}
+
+// x86 has four debug address registers, DR0 to DR3. DR6 reports which of
+// them triggered and DR7 enables them, with the kind and length of access.
+const (
+	numDebugRegs = 4
+	dr6          = 6
+	dr7          = 7
+)
+
+// watchChunk is an aligned piece of a watched range that one debug register
+// covers.
+type watchChunk struct {
+	addr uint64
+	size int64
+}
+
+// watchChunks splits [addr, addr+size) into the aligned 1, 2, 4 and 8 byte
+// pieces debug registers can watch.
+func watchChunks(addr uint64, size int64) []watchChunk {
+	var chunks []watchChunk
+	for size > 0 {
+		n := int64(8)
+		for n > size || addr%uint64(n) != 0 {
+			n /= 2
+		}
+		chunks = append(chunks, watchChunk{addr, n})
+		addr += uint64(n)
+		size -= n
+	}
+	return chunks
+}
+
+// dataWatchCommand handles "watch [-rw] <expr>" for a variable or field
+// path and "watch [-rw] <address> [size]": the target stops whenever it
+// writes there, or with -rw accesses it at all.
+func (d *Debugger) dataWatchCommand(pid int, args []string) {
+	const usage = "usage: watch [-rw] <expr> | watch [-rw] <address> [size]"
+	reads := len(args) > 0 && args[0] == "-rw"
+	if reads {
+		args = args[1:]
+	}
+	var w *DataWatch
+	var err error
+	switch len(args) {
+	case 1:
+		w, err = d.addDataWatch(pid, args[0], 0, reads)
+	case 2:
+		size, perr := strconv.ParseInt(args[1], 0, 64)
+		if perr != nil || size <= 0 {
+			d.println(usage)
+			return
+		}
+		w, err = d.addDataWatch(pid, args[0], size, reads)
+	default:
+		d.println(usage)
+		return
+	}
+	d.audit("", "watchpoint", pid, strings.Join(args, " "), err)
+	if err != nil {
+		d.println(err)
+		return
+	}
+	d.printf("Watchpoint %d: %s\n", w.ID, w)
+}
+
+// String describes w as "counter (8 bytes at 0xc000012345)", noting a watch
+// of reads as well.
+func (w *DataWatch) String() string {
+	s := fmt.Sprintf("%s (%d bytes at %#x", w.Expr, w.Size, w.Addr)
+	if w.Reads {
+		s += ", reads and writes"
+	}
+	if w.access != nil {
+		s += ", concurrent writes"
+	}
+	if w.Stacks {
+		s += ", with the writer's stack"
+	}
+	return s + ")"
+}
+
+// AddDataWatch watches the memory of expr, a variable or field path as
+// print takes it, in the target stopped at pid. The address follows the
+// variable when its goroutine's stack is copied and when a pointer on the
+// path changes; a watch of a local variable ends when its function returns.
+func (d *Debugger) AddDataWatch(pid int, expr string) (*DataWatch, error) {
+	return d.addDataWatch(pid, expr, 0, false)
+}
+
+// AddAccessWatch watches expr like AddDataWatch, but the target stops at
+// reads of the memory as well as at writes. An address, as x takes it,
+// watches size bytes there; size 0 watches 8.
+func (d *Debugger) AddAccessWatch(pid int, expr string, size int64) (*DataWatch, error) {
+	return d.addDataWatch(pid, expr, size, true)
+}
+
+// addDataWatch watches the memory of expr, a variable or field path or an
+// address, for writes, or with reads for any access. A size is only taken
+// for an address, whose memory isn't followed.
+func (d *Debugger) addDataWatch(pid int, expr string, size int64, reads bool) (*DataWatch, error) {
+	if d.ReadOnly {
+		// The debug registers are written like memory.
+		return nil, ErrReadOnly
+	}
+	var val value
+	if addr, err := strconv.ParseUint(expr, 0, 64); err == nil {
+		if size == 0 {
+			size = 8
+		}
+		val = value{buf: make([]byte, size), addr: addr}
+		if err := d.ReadMemory(pid, addr, val.buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if size != 0 {
+			return nil, fmt.Errorf("a size is only taken for an address")
+		}
+		if val, err = d.evalValue(pid, expr); err != nil {
+			return nil, err
+		}
+		if val.addr == 0 {
+			return nil, fmt.Errorf("%s is not in memory", expr)
+		}
+		size = val.typ.Size()
+	}
+	w := &DataWatch{Expr: expr, Addr: val.addr, Size: size, Reads: reads, typ: val.typ, old: val.buf}
+	if root, _, _ := strings.Cut(expr, "."); val.typ != nil && d.isLocal(root) {
+		p, err := d.newStepPlan(pid, "watch")
+		if err != nil {
+			return nil, err
+		}
+		w.scope, w.ret = p.frame, p.ret
+	}
+	if err := d.armDataWatch(pid, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// armDataWatch gives w its debug registers and traps and adds it to the
+// data watches.
+func (d *Debugger) armDataWatch(pid int, w *DataWatch) error {
+	slots, err := d.watchSlots(w.Expr, w.Addr, w.Size, nil)
+	if err != nil {
+		return err
+	}
+	w.slots = slots
+	w.stack = d.stackSlot(pid, w.Addr)
+	if err := d.addWatchTraps(w); err != nil {
+		return err
+	}
+	d.nextWatchID++
+	w.ID = d.nextWatchID
+	d.DataWatches = append(d.DataWatches, w)
+	d.updateDebugRegs(pid)
+	return nil
+}
+
+// watchSlots picks the debug registers for size bytes at addr, which the
+// watches other than w don't use.
+func (d *Debugger) watchSlots(expr string, addr uint64, size int64, w *DataWatch) ([]int, error) {
+	chunks := watchChunks(addr, size)
+	used := make([]bool, numDebugRegs)
+	for _, o := range d.DataWatches {
+		for _, s := range o.slots {
+			used[s] = o != w
+		}
+	}
+	var slots []int
+	for s := range used {
+		if !used[s] && len(slots) < len(chunks) {
+			slots = append(slots, s)
+		}
+	}
+	if size <= 0 || len(slots) < len(chunks) {
+		return nil, fmt.Errorf("%s (%d bytes) needs %d debug registers, %d are free", expr, size, len(chunks), len(slots))
+	}
+	return slots, nil
+}
+
+// isLocal reports whether name is an argument or local variable at the
+// current stop.
+func (d *Debugger) isLocal(name string) bool {
+	_, err := d.LookupVariable(d.Regs.Rip, name)
+	return err == nil
+}
+
+// stackSlot locates addr on the stack of the goroutine of the thread pid,
+// which starts with its bounds, or returns the zero frameID.
+func (d *Debugger) stackSlot(pid int, addr uint64) frameID {
+	g := d.currentG(pid, &d.Regs)
+	lo, err1 := d.readUint(pid, g, 8)
+	hi, err2 := d.readUint(pid, g+8, 8)
+	if err1 != nil || err2 != nil || addr < lo || addr >= hi {
+		return frameID{}
+	}
+	return frameID{g, hi - addr}
+}
+
+// addWatchTraps arms the traps w needs: the return of its scope, and
+// runtime.stackfree, which the runtime calls right after copying a stack,
+// for memory on a stack.
+func (d *Debugger) addWatchTraps(w *DataWatch) error {
+	if w.stack.g != 0 && d.stackFree == 0 {
+		fn := d.SymTable.LookupFunc("runtime.stackfree")
+		if fn == nil {
+			return fmt.Errorf("can't follow %s if its stack moves: no runtime.stackfree", w.Expr)
+		}
+		d.stackFree = fn.Entry
+	}
+	if w.stack.g != 0 {
+		if err := d.addTrap(d.stackFree); err != nil {
+			return err
+		}
+	}
+	if w.scope.g != 0 {
+		if err := d.addReturnTrap(w.ret); err != nil {
+			d.removeWatchTraps(w)
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Debugger) removeWatchTraps(w *DataWatch) {
+	if w.stack.g != 0 {
+		d.removeTrap(d.stackFree)
+	}
+	if w.scope.g != 0 {
+		d.removeTrap(w.ret)
+	}
+}
+
+// RemoveDataWatch removes the watch with the given id.
+func (d *Debugger) RemoveDataWatch(pid, id int) error {
+	for _, w := range d.DataWatches {
+		if w.ID == id {
+			d.dropDataWatch(w)
+			d.updateDebugRegs(pid)
+			return nil
+		}
+	}
+	return fmt.Errorf("no watchpoint %d", id)
+}
+
+// dropDataWatch removes w and its traps; the debug registers are left to
+// the caller to update.
+func (d *Debugger) dropDataWatch(w *DataWatch) {
+	d.removeWatchTraps(w)
+	d.DataWatches = slices.DeleteFunc(d.DataWatches, func(o *DataWatch) bool { return o == w })
+}
+
+// checkDataWatches follows the watched memory to where it is now, seen from
+// the thread pid stopped at d.Regs: to the new stack when the goroutine's
+// stack was copied, or to the new object when a pointer on the path was
+// changed, as far as it can be evaluated here. Watches of variables that
+// went out of scope, or whose goroutine exited, are deleted. It returns the
+// number deleted.
+func (d *Debugger) checkDataWatches(pid int) int {
+	if len(d.DataWatches) == 0 {
+		return 0
+	}
+	here := d.frameIDAt(pid, &d.Regs, d.Regs.Rsp)
+	var cur frameID // the current frame, if a scope needs it
+	var changed, deleted int
+	for _, w := range slices.Clone(d.DataWatches) {
+		gone := ""
+		addr := w.Addr
+		if w.stack.g != 0 {
+			if hi, err := d.readUint(pid, w.stack.g+8, 8); err != nil || hi < w.stack.depth {
+				// Exited goroutines have their stack freed and zeroed.
+				gone = "its goroutine exited"
+			} else {
+				addr = hi - w.stack.depth
+			}
+		}
+		// The stack pointer is back above the frame once it has returned.
+		if w.scope.g != 0 && here.g == w.scope.g && here.depth <= w.scope.depth {
+			gone = "it went out of scope"
+		}
+		if gone != "" {
+			d.printf("Watchpoint %d: %s deleted, %s\n", w.ID, w.Expr, gone)
+			d.dropDataWatch(w)
+			changed++
+			deleted++
+			continue
+		}
+
+		evaluated, inScope := false, w.scope.g == 0
+		if !inScope {
+			if cur.g == 0 {
+				if cfa, err := d.cfa(d.Regs.Rip, &d.Regs); err == nil {
+					cur = d.frameIDAt(pid, &d.Regs, cfa)
+				}
+			}
+			inScope = cur == w.scope
+		}
+		if inScope && w.access == nil {
+			if val, err := d.evalValue(pid, w.Expr); err == nil && val.addr != 0 {
+				addr, evaluated = val.addr, true
+			}
+		}
+		if addr == w.Addr {
+			continue
+		}
+		if err := d.moveDataWatch(pid, w, addr, evaluated); err != nil {
+			d.printf("Watchpoint %d: %s deleted, %v\n", w.ID, w.Expr, err)
+			d.dropDataWatch(w)
+			deleted++
+		}
+		changed++
+	}
+	if changed > 0 {
+		d.updateDebugRegs(pid)
+	}
+	return deleted
+}
+
+// moveDataWatch re-arms w at addr, where its memory is now. An address
+// evaluated by the thread pid may have moved it to or from its stack.
+func (d *Debugger) moveDataWatch(pid int, w *DataWatch, addr uint64, evaluated bool) error {
+	slots, err := d.watchSlots(w.Expr, addr, w.Size, w)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, w.Size)
+	if err := d.ReadMemory(pid, addr, buf); err != nil {
+		return err
+	}
+	d.printf("Watchpoint %d: %s moved from %#x to %#x\n", w.ID, w.Expr, w.Addr, addr)
+	d.removeWatchTraps(w)
+	w.Addr, w.old, w.slots = addr, buf, slots
+	if evaluated {
+		w.stack = d.stackSlot(pid, addr)
+	}
+	return d.addWatchTraps(w)
+}
+
+// watchTrapStop handles hits of the traps of the data watches: the runtime
+// freeing a stack, after copying it or when a goroutine exits, and the
+// return of a watched local's function. Watches that end stop the target.
+func (d *Debugger) watchTrapStop(pid int, addr uint64) (string, bool, error) {
+	if addr != d.stackFree || d.stackFree == 0 {
+		if !slices.ContainsFunc(d.DataWatches, func(w *DataWatch) bool { return w.scope.g != 0 && w.ret == addr }) {
+			return "", false, nil
+		}
+	}
+	if err := d.rewindTrap(pid, addr); err != nil {
+		return "", true, err
+	}
+	if d.checkDataWatches(pid) > 0 {
+		return "watchpoint", true, nil
+	}
+	return "", true, d.resume(pid, true)
+}
+
+// debugRegs returns the values of DR0 to DR3 and DR7 for the data watches.
+func (d *Debugger) debugRegs() (addrs [numDebugRegs]uint64, ctl uint64) {
+	for _, w := range d.DataWatches {
+		for i, c := range watchChunks(w.Addr, w.Size) {
+			s := w.slots[i]
+			addrs[s] = c.addr
+			// Local enable, break on writes (01) or on reads and
+			// writes (11), length 1, 2, 8 or 4 bytes (00, 01, 10, 11).
+			length := map[int64]uint64{1: 0, 2: 1, 4: 3, 8: 2}[c.size]
+			access := uint64(1)
+			if w.Reads {
+				access = 3
+			}
+			ctl |= 1<<(2*s) | access<<(16+4*s) | length<<(18+4*s)
+		}
+	}
+	return addrs, ctl
+}
+
+// updateDebugRegs brings the debug registers of the thread pid, stopped at
+// the prompt, up to date with the data watches, and those of the threads
+// held stopped with it. The other threads are sent a SIGSTOP, at which the
+// event loop updates theirs; threads yet to start get them at their first
+// stop.
+func (d *Debugger) updateDebugRegs(pid int) {
+	d.debugRegsGen++
+	if err := d.syncDebugRegs(pid); err != nil {
+		d.println(err)
+	}
+	tids, _ := threads(d.Pid)
+	for _, tid := range tids {
+		switch {
+		case tid == pid:
+		case d.tracees[tid] != nil && d.tracees[tid].held:
+			if err := d.syncDebugRegs(tid); err != nil {
+				d.println(err)
+			}
+		default:
+			syscall.Tgkill(d.Pid, tid, syscall.SIGSTOP)
+		}
+	}
+}
+
+// syncDebugRegs writes the debug registers of the stopped thread tid unless
+// they are up to date.
+func (d *Debugger) syncDebugRegs(tid int) error {
+	if d.debugRegsGen == 0 || d.debugRegsSynced[tid] == d.debugRegsGen {
+		return nil
+	}
+	addrs, ctl := d.debugRegs()
+	// Addresses can only change while their register is disabled.
+	if err := pokeDebugReg(tid, dr7, 0); err != nil {
+		return err
+	}
+	for i, a := range addrs {
+		if err := pokeDebugReg(tid, i, a); err != nil {
+			return err
+		}
+	}
+	if err := pokeDebugReg(tid, dr7, ctl); err != nil {
+		return err
+	}
+	if d.debugRegsSynced == nil {
+		d.debugRegsSynced = make(map[int]int)
+	}
+	d.debugRegsSynced[tid] = d.debugRegsGen
+	return nil
+}
+
+func pokeDebugReg(tid, n int, v uint64) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_POKEUSR, uintptr(tid), debugRegOffset+uintptr(n)*8, uintptr(v), 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("can't set debug register %d of thread %d: %v", n, tid, errno)
+	}
+	return nil
+}
+
+func peekDebugReg(tid, n int) (uint64, error) {
+	// The raw request stores the word at its data argument.
+	var v uint64
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_PEEKUSR, uintptr(tid), debugRegOffset+uintptr(n)*8, uintptr(unsafe.Pointer(&v)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return v, nil
+}
+
+// trapHWBreakpoint is the si_code of SIGTRAPs raised by debug registers.
+const trapHWBreakpoint = 4
+
+// dataWatchHit returns the watch whose debug register raised the SIGTRAP
+// the thread pid stopped with, and clears the report. DR6 isn't trusted
+// alone: the CPU leaves its bits set after other traps.
+func (d *Debugger) dataWatchHit(pid int) *DataWatch {
+	if len(d.DataWatches) == 0 {
+		return nil
+	}
+	if code, ok := d.proc().SigCode(pid); !ok || code != trapHWBreakpoint {
+		return nil
+	}
+	status, err := peekDebugReg(pid, dr6)
+	if err != nil || status&(1<<numDebugRegs-1) == 0 {
+		return nil
+	}
+	pokeDebugReg(pid, dr6, 0)
+	for _, w := range d.DataWatches {
+		for _, s := range w.slots {
+			if status&(1<<s) != 0 {
+				return w
+			}
+		}
+	}
+	return nil
+}
+
+// dataWatchStop handles an access to the memory of w by the thread pid,
+// which stopped after the accessing instruction. Writes that leave the
+// value as it was don't stop unless w watches reads, which the CPU doesn't
+// tell from such writes.
+func (d *Debugger) dataWatchStop(pid int, w *DataWatch) (string, bool, error) {
+	if w.access != nil {
+		return d.mapAccessStop(pid, w)
+	}
+	addr := w.Addr
+	deleted := d.checkDataWatches(pid)
+	if !slices.Contains(d.DataWatches, w) || w.Addr != addr {
+		// The write was to memory w no longer watches, such as a stack
+		// that has been copied and freed.
+		if deleted > 0 {
+			return "watchpoint", true, nil
+		}
+		return "", true, d.resume(pid, true)
+	}
+	buf := make([]byte, len(w.old))
+	if err := d.ReadMemory(pid, w.Addr, buf); err != nil {
+		d.println(err)
+		return "watchpoint", true, nil
+	}
+	if bytes.Equal(buf, w.old) {
+		if !w.Reads {
+			return "", true, d.resume(pid, true)
+		}
+		d.printf("Watchpoint %d: %s read\n  value = %s\n", w.ID, w.Expr, d.formatWatched(pid, w, buf))
+		return "watchpoint", true, nil
+	}
+	d.printf("Watchpoint %d: %s\n  old = %s\n  new = %s\n", w.ID, w.Expr,
+		d.formatWatched(pid, w, w.old), d.formatWatched(pid, w, buf))
+	w.old = buf
+	if w.Stacks {
+		frames, err := d.Stacktrace(pid, &d.Regs, maxGoroutineFrames)
+		d.showGoroutineFrames(d.currentGoid(pid), frames, err)
+	}
+	return "watchpoint", true, nil
+}
+
+// formatWatched formats buf, the memory w watches, as its type, or as
+// bytes for a watch of an address.
+func (d *Debugger) formatWatched(pid int, w *DataWatch, buf []byte) string {
+	if w.typ == nil {
+		return fmt.Sprintf("% x", buf)
+	}
+	return d.formatValue(pid, w.typ, buf, 0)
+}
+
+// listDataWatches prints the data watches for "watch".
+func (d *Debugger) listDataWatches() {
+	for _, w := range d.DataWatches {
+		d.printf("  watchpoint %d: %s\n", w.ID, w)
+	}
+}
+
+// unwatchCommand handles "unwatch <watchpoint>", which removes a data
+// watch.
+func (d *Debugger) unwatchCommand(pid int, args []string) bool {
+	if len(args) != 1 {
+		d.println("usage: unwatch <watchpoint>")
+		return false
+	}
+	id, err := strconv.Atoi(args[0])
+	if err == nil {
+		err = d.RemoveDataWatch(pid, id)
+		d.audit("", "delete-watchpoint", pid, args[0], err)
+	}
+	if err != nil {
+		d.println(err)
+	}
+	return false
+}
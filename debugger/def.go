@@ -1,32 +1,236 @@
+//go:build linux && amd64
+
 package debugger
 
 import (
+	"debug/dwarf"
 	"debug/gosym"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 // Debugger holds the state of the debugger.
 type Debugger struct {
-	TargetFile    string
-	Line          int
-	PC            uint64
-	Fn            *gosym.Func
-	SymTable      *gosym.Table
-	Regs          syscall.PtraceRegs
-	Ws            syscall.WaitStatus
-	OriginalCode  []byte
-	BreakpointSet bool
-	InterruptCode []byte
+	TargetFile  string
+	Pid         int
+	Fn          *gosym.Func
+	SymTable    *gosym.Table
+	Plugins     []*Plugin // loaded by the target with plugin.Open
+	Symbols     map[string]uint64
+	Dwarf       *dwarf.Data
+	Regs        syscall.PtraceRegs
+	Ws          syscall.WaitStatus
+	Arch        *Arch
+	Breakpoints map[uint64]*Breakpoint
+	Sched       SchedConfig
+	SchedTrace  SchedTraceConfig
+	Watches     []*Watch
+	DataWatches []*DataWatch
+	Catches     []*Catch
+	Groups      []*BreakGroup
+	RawKeys     bool
+	UI          Frontend
+	// Proc controls the target's threads once it runs; nil means ptrace.
+	Proc ProcessController
+	// Signals is what is done with the signals the target receives.
+	Signals SignalPolicy
+	// Logger, if set, takes the lines of tracepoints and logpoints as
+	// records, instead of the console or OpenLogOutput's output.
+	Logger *slog.Logger
+	// ReadOnly forbids changing the target: its memory, registers and
+	// scheduling can only be read while it is stopped.
+	ReadOnly bool
+	// SafePoints steps a thread stopped by an interrupt on to a safe point
+	// of the runtime, refusing writes to the target where it can't.
+	SafePoints bool
+	// NonStop leaves the other threads running while one is stopped at
+	// the prompt, instead of stopping them all until it resumes.
+	NonStop bool
+	// WaitTimeout is how long the target may take to stop when asked,
+	// by an interrupt or a single step, before it is reported
+	// unresponsive; 0 waits quietly forever.
+	WaitTimeout time.Duration
+	// SourceRoot is where source files are looked for when they aren't at
+	// their compile-time paths. ContextLines is how many lines around the
+	// current one a stop shows, 0 for none.
+	SourceRoot   string
+	ContextLines int
+	// Editor is what "edit" opens source in: a command, or a URL template
+	// such as "vscode://file/{file}:{line}". Empty means $VISUAL or
+	// $EDITOR.
+	Editor string
+	// Blame shows who last changed the line of every stop, from git blame
+	// of the checkout its source is found in.
+	Blame bool
+	// InlineValues shows, beside the lines of the stopped function that
+	// the stop and list show, the values of the variables in scope they
+	// name.
+	InlineValues bool
+	// Health are health checks of the target the debugger answers while
+	// it is stopped at the prompt, so that an orchestrator doesn't kill it.
+	Health []HealthEndpoint
+	// Notify are webhooks posted a JSON payload, or a message for Slack,
+	// for every stop of a breakpoint, catchpoint, watch or fatal error.
+	Notify []string
+	// HistorySize is how many values of each displayed or watched
+	// expression "history" keeps, 0 for none.
+	HistorySize int
+	// TimelineSize is how many of the last stops the timeline keeps, for
+	// the summary of a scheduling trace, 0 for none.
+	TimelineSize int
+	// SymbolIndex is the directory the symbols of binaries loaded are
+	// indexed in by build ID, for later sessions to skip parsing them.
+	// Empty means none.
+	SymbolIndex string
+	// Disassembler decodes the code disasm shows; nil means GoDisassembler.
+	Disassembler Disassembler
+	// Artifacts, when set, keeps a copy of the recording, saved sessions,
+	// cores, transcripts and statistics the session writes.
+	Artifacts ArtifactStore
+	// HandleSignals makes a session end cleanly, taking its traps out of
+	// the target, when the process gets SIGINT, SIGTERM, SIGHUP or
+	// SIGQUIT, and then exit. It is for the dedebugger command; programs
+	// embedding the debugger handle their signals themselves.
+	HandleSignals bool
+
+	target         string
+	current        Target // what Debug is debugging
+	launch         LaunchConfig
+	saved          *SavedSession
+	rcFile         string
+	statsFile      string
+	saveOnExit     string
+	stats          sessionCounters
+	snapshotExprs  []string
+	events         io.Writer
+	logOut         io.WriteCloser // set with OpenLogOutput
+	auditLog       *os.File       // set with OpenAudit
+	subMu          sync.Mutex
+	subscribers    map[chan Event]struct{}
+	schedTraceDone chan schedTrace
+	timeline       []TimelineEvent // a ring of the last stops, from timelineNext
+	timelineNext   int
+	nextBreakID    int
+	traps          map[uint64]*trapSite
+	dirtyTraps     map[uint64]bool
+	rearm          bool
+	rearmAt        uint64
+	watchHits      chan watchHit
+	input          chan promptInput
+	reading        bool
+	pending        []string
+	interrupts     int
+	stopRequested  bool
+	restarting     bool   // a restart was asked for
+	quitting       bool   // the session was quit, with the target released
+	restarted      bool   // the target was launched again
+	exitPolicy     string // the -exit-policy command
+	healthAnswers  *healthAnswers
+	policyRestarts int    // the restarts the exit policy asked for
+	supervised     bool   // restarted by the exit policy, to run on
+	mainTrap       uint64 // the trap at main.main of a restart, 0 if none
+	step           *stepPlan
+	lineStep       *lineStep
+	until          int // the breakpoint of "until", 0 if none
+	macros         map[string]*Macro
+	printers       map[string]*Printer
+	diffs          map[string][]valueLeaf // the values of "diff <expr>"
+	snaps          map[string]*valueSnap  // taken with "snap"
+	aliases        map[string]string      // set with "alias"
+	lastCommand    string                 // repeated by an empty line
+	displays       []string               // set with "display"
+	history        map[string][]historyEntry
+	historyStops   int // the stops history has seen
+	macroDepth     int
+	cacheMu        sync.Mutex
+	fieldOffsets   map[string]int64
+	globals        map[string]globalVar
+	waitReasons    []string // runtime.waitReasonStrings, read once
+	runtimeTypes   map[uint64]dwarf.Offset
+	typeNames      map[string]dwarf.Offset // indexed by LookupType
+	hangs          hangWatch
+	callTrace      *callTracer                // set with -trace-calls
+	returns        map[uint64][]pendingReturn // traced calls by return address
+	callGraph      *callGraph                 // recorded with -call-graph or "callgraph on"
+	callGraphFile  string                     // set with -call-graph
+	runtimeUp      bool                       // whether the runtime has created a goroutine
+	pluginTrap     uint64                     // where plugin.Open loads plugins, trapped
+	fatalTraps     map[uint64]bool            // where the runtime raises fatal errors, trapped
+	fatalMsg       string                     // the message of the last fatal error
+	raceTrap       uint64                     // where the race detector reports a race, trapped
+	raceTid        int                        // the thread stepped back to Go from a race report
+	raceSteps      int
+	benchIter      *benchIter // set with "iteration"
+	unitExe        string     // the binary of the systemd unit given with -unit
+	recordFile     *os.File   // set with OpenRecord, before the session starts
+	artifactDir    string     // the session's directory in Artifacts
+	artifactMu     sync.Mutex // guards artifactDir, which abort uses too
+	pluginFiles    map[string]bool
+	pendingBreaks  [][]string // break commands to run once a plugin has them
+	mod            *goModule  // the target's module, see module
+	modSearched    bool
+	tracees        map[int]*tracee
+	heldEvents     []heldEvent // stops reported while stopping all threads
+	nextWatchID    int
+	// debugRegsGen counts changes of the data watches; debugRegsSynced
+	// holds the count each thread's debug registers were last written at.
+	debugRegsGen    int
+	debugRegsSynced map[int]int
+	// stackFree is the entry of runtime.stackfree, trapped while data
+	// watches are on a goroutine stack.
+	stackFree uint64
+	dwarfData dwarfSections
+	// load describes how the executable is laid out; loadBias is how far a
+	// position independent one was moved from its link addresses.
+	load     loadInfo
+	loadBias uint64
+	// sources caches the source files read for display; listFile and
+	// listLine are where the last list or stop context ended.
+	sources  map[string]sourceFile
+	blames   map[string]blamed // by file:line
+	listFile string
+	listLine int
+	// stopSig is the signal of a signal stop, delivered on continuing.
+	stopSig syscall.Signal
+	// pages caches the memory read at a stop.
+	pages pageCache
+	// command is commandRunning while ^C would cancel the command at the
+	// prompt; dropInterrupt is set while the target's own SIGINT of a
+	// canceling ^C is pending.
+	command       atomic.Int32
+	dropInterrupt bool
+	// instStarts caches where instructions start, by the entry of their
+	// function, to check the addresses of traps.
+	instStarts map[uint64][]uint64
+	// gOffset is where the g of a thread is from its thread pointer.
+	gOffset int64
+	// pcln reads the function data of the line table, once needed;
+	// unsafeStop is why the target isn't at a safe point, while SafePoints
+	// refuses writes to it.
+	pcln       *pclnFuncs
+	unsafeStop string
+	// stallSince is when a stop of stallTid, or of any thread for 0, was
+	// asked for and is still awaited; stallReport is when the watchdog last
+	// reported the wait, and stalled is set once it has.
+	stallSince  time.Time
+	stallTid    int
+	stallReport time.Time
+	stalled     bool
 
 	DebuggerInterface
 }
 
 type DebuggerInterface interface {
 	InputOrContinue(pid int) bool
-	SetBreak(pid int) (bool, []byte)
-	ReplaceCode(pid int, address uint64, code []byte) []byte
-	GetSymbolTable(prog string) *gosym.Table
-	OutputStack(pid int, ip uint64, sp uint64, bp uint64)
-	RunTarget(target string)
+	AddBreakpoint(pid int, file string, line int, cond *HitCondition) (*Breakpoint, error)
+	ReplaceCode(pid int, address uint64, code []byte) ([]byte, error)
+	GetSymbolTable(prog string) (*gosym.Table, error)
+	OutputStack(pid int, ip uint64, sp uint64, bp uint64) error
+	RunTarget(target string) error
 	Run()
 }
@@ -0,0 +1,181 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// valueLeaf is a scalar in a value, at a path of fields, map keys and
+// indices from the value, such as Owner.Name or Tags[2].
+type valueLeaf struct {
+	path, value string
+}
+
+// diffCommand handles "diff <expr1> <expr2>", which shows how the second
+// value differs from the first, and "diff <expr>", which shows how the
+// value changed since the last "diff <expr>", at an earlier stop. Values
+// are compared as print -json renders them, leaf by leaf, so the limits of
+// print apply.
+func (d *Debugger) diffCommand(pid int, args []string) bool {
+	switch len(args) {
+	case 1:
+		leaves, err := d.valueLeaves(pid, args[0])
+		if err != nil {
+			d.println(err)
+			return false
+		}
+		old, ok := d.diffs[args[0]]
+		if d.diffs == nil {
+			d.diffs = make(map[string][]valueLeaf)
+		}
+		d.diffs[args[0]] = leaves
+		if !ok {
+			d.printf("Remembered %s; diff it again at a later stop to see what changed\n", args[0])
+			return false
+		}
+		d.showDiff(old, leaves)
+	case 2:
+		a, err := d.valueLeaves(pid, args[0])
+		if err != nil {
+			d.println(err)
+			return false
+		}
+		b, err := d.valueLeaves(pid, args[1])
+		if err != nil {
+			d.println(err)
+			return false
+		}
+		d.showDiff(a, b)
+	default:
+		d.println("usage: diff <expr> [<expr>]")
+	}
+	return false
+}
+
+// showDiff prints the leaves of b that differ from those of a: changed
+// ones with ~, added ones with + and removed ones with -, in the order of
+// b with the removed ones after.
+func (d *Debugger) showDiff(a, b []valueLeaf) {
+	old := make(map[string]string, len(a))
+	for _, l := range a {
+		old[l.path] = l.value
+	}
+	seen := make(map[string]bool, len(b))
+	n := 0
+	for _, l := range b {
+		seen[l.path] = true
+		v, ok := old[l.path]
+		switch {
+		case !ok:
+			d.printf("+ %s: %s\n", leafName(l.path), l.value)
+		case v != l.value:
+			d.printf("~ %s: %s -> %s\n", leafName(l.path), v, l.value)
+		default:
+			continue
+		}
+		n++
+	}
+	for _, l := range a {
+		if !seen[l.path] {
+			d.printf("- %s: %s\n", leafName(l.path), l.value)
+			n++
+		}
+	}
+	if n == 0 {
+		d.println("No differences")
+	}
+}
+
+func leafName(path string) string {
+	if path == "" {
+		return "value"
+	}
+	return strings.TrimPrefix(path, ".")
+}
+
+// valueLeaves evaluates expr and returns the leaves of its JSON rendering.
+func (d *Debugger) valueLeaves(pid int, expr string) ([]valueLeaf, error) {
+	var text string
+	if v, ok := registerValue(&d.Regs, expr); ok {
+		text = fmt.Sprint(v)
+	} else {
+		val, err := d.evalValue(pid, expr)
+		if err != nil {
+			return nil, err
+		}
+		if text, err = d.jsonValue(pid, val.typ, val.buf); err != nil {
+			return nil, err
+		}
+	}
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var leaves []valueLeaf
+	if err := jsonLeaves(dec, "", &leaves); err != nil {
+		return nil, fmt.Errorf("diffing %s: %v", expr, err)
+	}
+	return leaves, nil
+}
+
+// jsonLeaves appends the leaves of the next JSON value of dec, at path, to
+// leaves. Empty objects and arrays are leaves of their own.
+func jsonLeaves(dec *json.Decoder, path string, leaves *[]valueLeaf) error {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		v, _ := json.Marshal(tok)
+		*leaves = append(*leaves, valueLeaf{path, string(v)})
+		return nil
+	}
+	n := 0
+	for ; dec.More(); n++ {
+		var sub string
+		if delim == '{' {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			sub = path + keyPath(tok.(string))
+		} else {
+			sub = fmt.Sprintf("%s[%d]", path, n)
+		}
+		if err := jsonLeaves(dec, sub, leaves); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if n == 0 {
+		empty := "[]"
+		if delim == '{' {
+			empty = "{}"
+		}
+		*leaves = append(*leaves, valueLeaf{path, empty})
+	}
+	return nil
+}
+
+// keyPath returns the path step of the object key k: .k for field names,
+// and a quoted index for other map keys.
+func keyPath(k string) string {
+	for i, r := range k {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return fmt.Sprintf("[%q]", k)
+		}
+	}
+	if k == "" {
+		return `[""]`
+	}
+	return "." + k
+}
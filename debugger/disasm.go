@@ -0,0 +1,141 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxInstLen is the longest an x86-64 instruction can be.
+const maxInstLen = 15
+
+// defaultDisasmCount is how many instructions disasm shows by default.
+const defaultDisasmCount = 16
+
+// disasmCommand handles "disasm [address] [count]", which disassembles
+// count instructions of the target from address, taken as x takes it or as
+// a function name, or from the current PC. Each source line the code comes
+// from is shown before its instructions, the PC is marked with "=>" and
+// breakpoints with their number; the code under the debugger's traps is
+// shown as it was before them.
+func (d *Debugger) disasmCommand(pid int, args []string) bool {
+	const usage = "usage: disasm [address|function] [count]"
+	if len(args) > 2 {
+		d.println(usage)
+		return false
+	}
+	addr, count := d.Regs.Rip, defaultDisasmCount
+	if len(args) > 0 {
+		if fn := d.SymTable.LookupFunc(args[0]); fn != nil {
+			addr = fn.Entry
+		} else {
+			var err error
+			if addr, err = d.examineAddr(pid, args[0]); err != nil {
+				d.println(err)
+				return false
+			}
+		}
+	}
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			d.println(usage)
+			return false
+		}
+		count = n
+	}
+
+	insts, err := d.disassemble(pid, addr, count)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	var b strings.Builder
+	lastFile, lastLine := "", 0
+	for _, in := range insts {
+		file, line, fn := d.pcToLine(in.Addr)
+		if fn != nil && file != "" && (file != lastFile || line != lastLine) {
+			fmt.Fprintf(&b, "%s at %s:%d\n", fn.Name, file, line)
+			lastFile, lastLine = file, line
+		}
+		mark := "  "
+		if in.Addr == d.Regs.Rip {
+			mark = "=>"
+		}
+		bp := ""
+		if bpt := d.Breakpoints[in.Addr]; bpt != nil {
+			bp = fmt.Sprintf("B%d", bpt.ID)
+		}
+		fmt.Fprintf(&b, "%s %-4s %#x  %-24s %s%s\n", mark, bp, in.Addr, fmt.Sprintf("% x", in.Bytes), in.Text, d.targetName(in.Text))
+	}
+	d.UI.ShowOutput(b.String())
+	return false
+}
+
+// disassemble decodes count instructions of the target stopped at pid from
+// addr, with the code the debugger's traps replaced put back. The decoding
+// is done by the Disassembler.
+func (d *Debugger) disassemble(pid int, addr uint64, count int) ([]Instruction, error) {
+	code := make([]byte, count*maxInstLen)
+	// Code near the end of the mapping can't be read in full.
+	for len(code) > 0 {
+		if err := d.ReadMemory(pid, addr, code); err == nil {
+			break
+		}
+		code = code[:len(code)/2]
+	}
+	if len(code) == 0 {
+		return nil, fmt.Errorf("can't read code at %#x", addr)
+	}
+	d.originalCode(addr, code)
+
+	insts, err := d.disassembler().Disassemble(d.Arch, addr, code)
+	if err != nil {
+		return nil, err
+	}
+	// The last instructions read may be cut short.
+	if len(insts) > count {
+		insts = insts[:count]
+	}
+	return insts, nil
+}
+
+// originalCode puts back in code, read from the target at addr, what the
+// debugger's armed traps replaced.
+func (d *Debugger) originalCode(addr uint64, code []byte) {
+	for _, site := range d.traps {
+		if !site.armed {
+			continue
+		}
+		for i, c := range site.orig {
+			if a := site.addr + uint64(i); a >= addr && a < addr+uint64(len(code)) {
+				code[a-addr] = c
+			}
+		}
+	}
+}
+
+// targetName names the function a call or jump of text goes to, as in
+// " <main.work>", if its operand is an address in one.
+func (d *Debugger) targetName(text string) string {
+	op, arg, ok := strings.Cut(text, " ")
+	branch := op == "call" || strings.HasPrefix(op, "j") || // x86-64
+		op == "bl" || op == "b" || strings.HasPrefix(op, "b.") // arm64
+	if !ok || !branch {
+		return ""
+	}
+	target, err := strconv.ParseUint(strings.TrimPrefix(arg, "#"), 0, 64)
+	if err != nil {
+		return ""
+	}
+	fn := d.pcToFunc(target)
+	if fn == nil {
+		return ""
+	}
+	if target == fn.Entry {
+		return fmt.Sprintf(" <%s>", fn.Name)
+	}
+	return fmt.Sprintf(" <%s+%#x>", fn.Name, target-fn.Entry)
+}
@@ -0,0 +1,228 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/arch/arm64/arm64asm"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// Instruction is an instruction of the target, as a Disassembler decodes it.
+type Instruction struct {
+	Addr  uint64
+	Bytes []byte
+	// Text is the instruction in the backend's syntax, with its operands
+	// after a single space, such as "cmp rsp,QWORD PTR [r14+0x10]".
+	Text string
+}
+
+// Disassembler decodes machine code. Programs embedding the debugger set
+// Debugger.Disassembler to decode instruction sets, or in a syntax, the
+// built-in backends don't.
+type Disassembler interface {
+	// Disassemble decodes the instructions of code, read at addr, for arch.
+	// The last bytes may be the start of an instruction cut short, which
+	// is left out or decoded as best it can be.
+	Disassemble(arch *Arch, addr uint64, code []byte) ([]Instruction, error)
+}
+
+// Disassemblers are the built-in backends by the names -disassembler takes.
+var Disassemblers = map[string]Disassembler{
+	"go":       GoDisassembler{},
+	"objdump":  ObjdumpDisassembler{},
+	"capstone": CapstoneDisassembler{},
+}
+
+// disassembler returns the backend disassemble uses: Disassembler, or the
+// in-process decoder.
+func (d *Debugger) disassembler() Disassembler {
+	if d.Disassembler != nil {
+		return d.Disassembler
+	}
+	return GoDisassembler{}
+}
+
+// setDisassembler sets the backend by its name in Disassemblers, for the
+// -disassembler flag.
+func (d *Debugger) setDisassembler(name string) error {
+	dis, ok := Disassemblers[name]
+	if !ok {
+		return fmt.Errorf("unknown disassembler %q", name)
+	}
+	d.Disassembler = dis
+	return nil
+}
+
+// GoDisassembler decodes x86-64 and arm64 code in process with the decoders
+// of golang.org/x/arch, x86-64 in Intel syntax and arm64 in GNU's. It is the
+// default, and needs no tools installed.
+type GoDisassembler struct{}
+
+func (GoDisassembler) Disassemble(arch *Arch, addr uint64, code []byte) ([]Instruction, error) {
+	var insts []Instruction
+	switch arch.Name {
+	case "amd64":
+		for len(code) > 0 {
+			inst, err := x86asm.Decode(code, 64)
+			text := x86asm.IntelSyntax(inst, addr, nil)
+			switch {
+			case err == x86asm.ErrTruncated:
+				// The last instruction is cut short.
+				return insts, nil
+			case err != nil:
+				// Like objdump, a byte that starts no instruction is
+				// shown on its own.
+				inst.Len, text = 1, "(bad)"
+			}
+			insts = append(insts, Instruction{Addr: addr, Bytes: code[:inst.Len], Text: text})
+			addr += uint64(inst.Len)
+			code = code[inst.Len:]
+		}
+	case "arm64":
+		for ; len(code) >= 4; addr, code = addr+4, code[4:] {
+			text := "(bad)"
+			if inst, err := arm64asm.Decode(code); err == nil {
+				text = arm64asm.GNUSyntax(inst)
+			}
+			insts = append(insts, Instruction{Addr: addr, Bytes: code[:4], Text: text})
+		}
+	default:
+		return nil, fmt.Errorf("can't disassemble %s code", arch.Name)
+	}
+	return insts, nil
+}
+
+// ObjdumpDisassembler decodes x86-64 and arm64 code with objdump of GNU
+// binutils, x86-64 in Intel syntax.
+type ObjdumpDisassembler struct{}
+
+// objdumpMachines are objdump's -m and -M options for each Arch it decodes.
+var objdumpMachines = map[string][]string{
+	"amd64": {"-m", "i386:x86-64", "-M", "intel"},
+	"arm64": {"-m", "aarch64"},
+}
+
+func (ObjdumpDisassembler) Disassemble(arch *Arch, addr uint64, code []byte) ([]Instruction, error) {
+	machine, ok := objdumpMachines[arch.Name]
+	if !ok {
+		return nil, fmt.Errorf("objdump can't disassemble %s code", arch.Name)
+	}
+	f, err := os.CreateTemp("", "dedebugger-code")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(code)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"-D", "-b", "binary"}, machine...)
+	args = append(args, "--insn-width=15", fmt.Sprintf("--adjust-vma=%#x", addr), f.Name())
+	out, err := exec.Command("objdump", args...).Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("disassembling needs objdump from GNU binutils")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("objdump: %v", err)
+	}
+	return parseObjdump(out), nil
+}
+
+// parseObjdump parses the instructions of objdump's output, lines like
+// "  4b6380:\t49 3b 66 10   \tcmp    rsp,QWORD PTR [r14+0x10]". arm64 code
+// is shown as words, "d10043ff", which are little-endian in memory.
+func parseObjdump(out []byte) []Instruction {
+	var insts []Instruction
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		addr, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(fields[0]), ":"), 16, 64)
+		if err != nil {
+			continue
+		}
+		var code []byte
+		for _, w := range strings.Fields(fields[1]) {
+			b, err := hex.DecodeString(w)
+			if err != nil {
+				break
+			}
+			for i := len(b) - 1; i >= 0; i-- {
+				code = append(code, b[i])
+			}
+		}
+		text := strings.Join(strings.Fields(fields[2]), " ")
+		insts = append(insts, Instruction{Addr: addr, Bytes: code, Text: text})
+	}
+	return insts
+}
+
+// CapstoneDisassembler decodes code with cstool, the command line tool of
+// the Capstone engine, which knows more instruction sets and extensions
+// than objdump, in LLVM's syntax.
+type CapstoneDisassembler struct{}
+
+// capstoneModes are cstool's arch+mode names for each Arch it decodes.
+var capstoneModes = map[string]string{
+	"amd64": "x64",
+	"arm64": "arm64",
+}
+
+func (CapstoneDisassembler) Disassemble(arch *Arch, addr uint64, code []byte) ([]Instruction, error) {
+	mode, ok := capstoneModes[arch.Name]
+	if !ok {
+		return nil, fmt.Errorf("capstone can't disassemble %s code", arch.Name)
+	}
+	out, err := exec.Command("cstool", mode, hex.EncodeToString(code), strconv.FormatUint(addr, 16)).Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("the capstone disassembler needs cstool from Capstone")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cstool: %v", err)
+	}
+	return parseCstool(out), nil
+}
+
+// parseCstool parses the instructions of cstool's output, lines like
+// " 4b6380  49 3b 66 10    cmp\trsp, qword ptr [r14 + 0x10]": the address,
+// the bytes and the mnemonic, then its operands after a tab.
+func parseCstool(out []byte) []Instruction {
+	var insts []Instruction
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		head, ops, _ := strings.Cut(sc.Text(), "\t")
+		fields := strings.Fields(head)
+		if len(fields) < 3 {
+			continue
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+		code, err := hex.DecodeString(strings.Join(fields[1:len(fields)-1], ""))
+		if err != nil {
+			continue
+		}
+		text := fields[len(fields)-1]
+		if ops = strings.TrimSpace(ops); ops != "" {
+			text += " " + ops
+		}
+		insts = append(insts, Instruction{Addr: addr, Bytes: code, Text: text})
+	}
+	return insts
+}
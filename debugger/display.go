@@ -0,0 +1,161 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// defaultHistorySize is how many values of an expression history keeps,
+// unless set otherwise with "set history".
+const defaultHistorySize = 20
+
+// historyEntry is a value of an expression at a stop.
+type historyEntry struct {
+	stop  int // the number of the stop in the session, from 1
+	value string
+	fn    string
+	file  string
+	line  int
+}
+
+// valueText returns the value of expr as print shows it.
+func (d *Debugger) valueText(pid int, expr string) (string, error) {
+	if v, ok := registerValue(&d.Regs, expr); ok {
+		return fmt.Sprintf("%#x", v), nil
+	}
+	val, err := d.evalValue(pid, expr)
+	if err != nil {
+		return "", err
+	}
+	return d.formatValue(pid, val.typ, val.buf, 0), nil
+}
+
+// displayCommand handles "display <expr>", which prints the value of expr
+// at every stop, and "display", which prints them all now.
+func (d *Debugger) displayCommand(pid int, args []string) bool {
+	switch len(args) {
+	case 0:
+		if len(d.displays) == 0 {
+			d.println("No displays")
+		}
+		for i, expr := range d.displays {
+			d.showDisplay(pid, i, expr)
+		}
+	case 1:
+		d.displays = append(d.displays, args[0])
+		d.showDisplay(pid, len(d.displays)-1, args[0])
+	default:
+		d.println("usage: display [<expr>]")
+	}
+	return false
+}
+
+// undisplayCommand handles "undisplay <n>...", which removes displays by
+// the numbers display lists them with.
+func (d *Debugger) undisplayCommand(pid int, args []string) bool {
+	if len(args) == 0 {
+		d.println("usage: undisplay <n>...")
+		return false
+	}
+	drop := make(map[int]bool)
+	for _, a := range args {
+		n, err := strconv.Atoi(a)
+		if err != nil || n < 1 || n > len(d.displays) {
+			d.printf("No display %s\n", a)
+			continue
+		}
+		drop[n-1] = true
+	}
+	var kept []string
+	for i, expr := range d.displays {
+		if !drop[i] {
+			kept = append(kept, expr)
+		}
+	}
+	d.displays = kept
+	return false
+}
+
+func (d *Debugger) showDisplay(pid, i int, expr string) (string, error) {
+	text, err := d.valueText(pid, expr)
+	if err != nil {
+		d.printf("  %d: %s: %v\n", i+1, expr, err)
+		return "", err
+	}
+	d.printf("  %d: %s = %s\n", i+1, expr, text)
+	return text, nil
+}
+
+// recordHistory shows the displays at stop and records their values, and
+// those of the expressions of data watches, in the history. Values that
+// can't be read, such as locals out of scope, are left out.
+func (d *Debugger) recordHistory(pid int, stop Event) {
+	d.historyStops++
+	for i, expr := range d.displays {
+		if text, err := d.showDisplay(pid, i, expr); err == nil {
+			d.addHistory(expr, text, stop)
+		}
+	}
+	for _, w := range d.DataWatches {
+		if w.typ == nil || slices.Contains(d.displays, w.Expr) {
+			continue
+		}
+		if text, err := d.valueText(pid, w.Expr); err == nil {
+			d.addHistory(w.Expr, text, stop)
+		}
+	}
+}
+
+// addHistory records text as the value of expr at stop, dropping the
+// oldest beyond HistorySize.
+func (d *Debugger) addHistory(expr, text string, stop Event) {
+	if d.HistorySize <= 0 {
+		return
+	}
+	if d.history == nil {
+		d.history = make(map[string][]historyEntry)
+	}
+	h := append(d.history[expr], historyEntry{d.historyStops, text, stop.Func, stop.File, stop.Line})
+	if len(h) > d.HistorySize {
+		h = h[len(h)-d.HistorySize:]
+	}
+	d.history[expr] = h
+}
+
+// historyCommand handles "history <expr>", which lists the values of a
+// displayed or watched expression at the last stops, oldest first, with
+// where each stop was, and "history", which lists the expressions with a
+// history.
+func (d *Debugger) historyCommand(pid int, args []string) bool {
+	switch len(args) {
+	case 0:
+		if len(d.history) == 0 {
+			d.println("No history; display or watch an expression to record its values")
+			return false
+		}
+		exprs := make([]string, 0, len(d.history))
+		for expr := range d.history {
+			exprs = append(exprs, expr)
+		}
+		sort.Strings(exprs)
+		for _, expr := range exprs {
+			d.printf("  %s: %d values\n", expr, len(d.history[expr]))
+		}
+	case 1:
+		h := d.history[args[0]]
+		if len(h) == 0 {
+			d.printf("No history of %s; display or watch it to record its values\n", args[0])
+			return false
+		}
+		for _, e := range h {
+			d.printf("  stop %d: %s at %s:%d in %s\n", e.stop, e.value, e.file, e.line, e.fn)
+		}
+	default:
+		d.println("usage: history [<expr>]")
+	}
+	return false
+}
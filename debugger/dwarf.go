@@ -0,0 +1,748 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// dwarfSections are the DWARF sections debug/dwarf doesn't interpret: call
+// frame information, location lists (.debug_loc up to DWARF 4,
+// .debug_loclists from 5) and the address table DWARF 5 lists index.
+type dwarfSections struct {
+	frame, loc, locLists, addr []byte
+
+	fdes []fde // parsed from frame on first use
+
+	prologueEnds []uint64 // sorted, read from .debug_line on first use
+}
+
+// dwarfSectionsOf returns the sections needed for variables out of secs,
+// the DWARF sections by name without .debug_. Missing sections are left
+// empty.
+func dwarfSectionsOf(secs map[string][]byte) dwarfSections {
+	return dwarfSections{
+		frame:    secs["frame"],
+		loc:      secs["loc"],
+		locLists: secs["loclists"],
+		addr:     secs["addr"],
+	}
+}
+
+// Variable is an argument or local variable in scope at a stop.
+type Variable struct {
+	Name string
+	Arg  bool
+	// Result is set for the results of the function, which are arguments
+	// too in the debug information.
+	Result bool
+	Type   dwarf.Type
+	Line   int // where it is declared
+
+	// heap is set for a variable that escaped to the heap, which the
+	// debug information names "&name" and locates a pointer to.
+	heap  bool
+	entry *dwarf.Entry
+	fn    *dwarf.Entry // the function, for its frame base
+	cu    *dwarf.Entry // the compile unit, for location lists
+}
+
+// Variables returns the arguments and local variables in scope at pc, in
+// the order they are declared in the debug information.
+func (d *Debugger) Variables(pc uint64) ([]*Variable, error) {
+	if d.Dwarf == nil {
+		return nil, ErrNoDWARF
+	}
+	pc = d.linkPC(pc)
+	r := d.Dwarf.Reader()
+	cu, err := r.SeekPC(pc)
+	if err != nil {
+		return nil, fmt.Errorf("no debug information for %#x", d.loadAddr(pc))
+	}
+
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil || e.Tag == 0 {
+			return nil, fmt.Errorf("no function at %#x in the debug information", d.loadAddr(pc))
+		}
+		if e.Tag == dwarf.TagSubprogram && d.containsPC(e, pc) {
+			var vars []*Variable
+			err := d.scopeVariables(r, pc, func(v *dwarf.Entry) error {
+				name, _ := v.Val(dwarf.AttrName).(string)
+				off, _ := v.Val(dwarf.AttrType).(dwarf.Offset)
+				typ, err := d.Dwarf.Type(off)
+				if err != nil {
+					return fmt.Errorf("type of %s: %v", name, err)
+				}
+				line, _ := v.Val(dwarf.AttrDeclLine).(int64)
+				result, _ := v.Val(dwarf.AttrVarParam).(bool)
+				heap := false
+				if p, ok := typ.(*dwarf.PtrType); ok && strings.HasPrefix(name, "&") && p.Type != nil {
+					name, typ, heap = name[1:], p.Type, true
+				}
+				vars = append(vars, &Variable{
+					Name:   name,
+					Arg:    v.Tag == dwarf.TagFormalParameter,
+					Result: result,
+					Type:   typ,
+					Line:   int(line),
+					heap:   heap,
+					entry:  v,
+					fn:     e,
+					cu:     cu,
+				})
+				return nil
+			})
+			return vars, err
+		}
+		if e.Children {
+			r.SkipChildren()
+		}
+	}
+}
+
+// scopeVariables calls add for the variables among the children of the
+// entry r just returned, descending into the lexical blocks containing pc.
+func (d *Debugger) scopeVariables(r *dwarf.Reader, pc uint64, add func(*dwarf.Entry) error) error {
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil || e.Tag == 0 {
+			return nil
+		}
+		switch e.Tag {
+		case dwarf.TagFormalParameter, dwarf.TagVariable:
+			if err := add(e); err != nil {
+				return err
+			}
+		case dwarf.TagLexDwarfBlock:
+			if e.Children && d.containsPC(e, pc) {
+				if err := d.scopeVariables(r, pc, add); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if e.Children {
+			r.SkipChildren()
+		}
+	}
+}
+
+func (d *Debugger) containsPC(e *dwarf.Entry, pc uint64) bool {
+	ranges, err := d.Dwarf.Ranges(e)
+	if err != nil {
+		return false
+	}
+	for _, r := range ranges {
+		if r[0] <= pc && pc < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupVariable returns the argument or local variable name in scope at pc.
+// An inner variable shadows outer ones of the same name.
+func (d *Debugger) LookupVariable(pc uint64, name string) (*Variable, error) {
+	vars, err := d.Variables(pc)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(vars) - 1; i >= 0; i-- {
+		if vars[i].Name == name {
+			return vars[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no variable %s in scope", name)
+}
+
+// value is the contents of a variable, or a part of one, read from the
+// target. Values that live in registers have no address.
+type value struct {
+	typ  dwarf.Type
+	buf  []byte
+	addr uint64
+}
+
+// maxValueSize bounds how much of a single value is read from the target.
+const maxValueSize = 1 << 16
+
+// readValueAt reads the value of type typ stored at addr.
+func (d *Debugger) readValueAt(pid int, addr uint64, typ dwarf.Type) (value, error) {
+	buf := make([]byte, min(max(typ.Size(), 0), maxValueSize))
+	if err := d.ReadMemory(pid, addr, buf); err != nil {
+		return value{}, err
+	}
+	return value{typ, buf, addr}, nil
+}
+
+// variableValue reads v in the frame of the stopped thread pid with
+// registers regs.
+func (d *Debugger) variableValue(pid int, regs *syscall.PtraceRegs, v *Variable) (value, error) {
+	expr, err := d.locationExpr(v, regs.Rip)
+	if err != nil {
+		return value{}, err
+	}
+	frame := &exprFrame{d: d, pid: pid, regs: regs, fn: v.fn}
+	pieces, err := frame.eval(expr)
+	if err != nil {
+		return value{}, fmt.Errorf("%s: %v", v.Name, err)
+	}
+	if v.heap {
+		return d.heapValue(pid, regs, v, pieces)
+	}
+
+	size := min(max(v.Type.Size(), 0), maxValueSize)
+	if len(pieces) == 1 && pieces[0].inMemory() {
+		return d.readValueAt(pid, pieces[0].addr, v.Type)
+	}
+	buf := make([]byte, 0, size)
+	for _, p := range pieces {
+		b, err := p.read(d, pid, regs)
+		if err != nil {
+			return value{}, fmt.Errorf("%s: %v", v.Name, err)
+		}
+		buf = append(buf, b...)
+	}
+	if int64(len(buf)) < size {
+		return value{}, fmt.Errorf("%s: location covers %d of %d bytes", v.Name, len(buf), size)
+	}
+	return value{v.Type, buf[:size], 0}, nil
+}
+
+// heapValue reads the variable v that escaped to the heap, through the
+// pointer to it that pieces locate.
+func (d *Debugger) heapValue(pid int, regs *syscall.PtraceRegs, v *Variable, pieces []locPiece) (value, error) {
+	var ptr []byte
+	for _, p := range pieces {
+		if p.size == 0 {
+			p.size = 8
+		}
+		b, err := p.read(d, pid, regs)
+		if err != nil {
+			return value{}, fmt.Errorf("%s: %v", v.Name, err)
+		}
+		ptr = append(ptr, b...)
+	}
+	if len(ptr) < 8 {
+		return value{}, fmt.Errorf("%s: location covers %d of 8 bytes", v.Name, len(ptr))
+	}
+	addr := bufUint(ptr, 8)
+	if addr == 0 {
+		return value{}, fmt.Errorf("%s isn't allocated yet", v.Name)
+	}
+	return d.readValueAt(pid, addr, v.Type)
+}
+
+// locationExpr returns the DWARF expression locating v at pc. Variables
+// whose location changes have a location list, of which the entry for pc
+// is picked.
+func (d *Debugger) locationExpr(v *Variable, pc uint64) ([]byte, error) {
+	pc = d.linkPC(pc)
+	field := v.entry.AttrField(dwarf.AttrLocation)
+	if field == nil {
+		return nil, fmt.Errorf("%s has been optimized away", v.Name)
+	}
+	switch field.Class {
+	case dwarf.ClassExprLoc, dwarf.ClassBlock:
+		return field.Val.([]byte), nil
+	case dwarf.ClassLocListPtr:
+		// An offset into .debug_loc, or into .debug_loclists for DWARF 5
+		// units, which debug/dwarf doesn't tell apart. A binary has one or
+		// the other.
+		if len(d.dwarfData.loc) == 0 {
+			return d.dwarfData.locListsEntry(v.cu, field.Val.(int64), pc, v.Name)
+		}
+		return d.dwarfData.locEntry(v.cu, field.Val.(int64), pc, v.Name)
+	}
+	return nil, fmt.Errorf("%s has an unsupported location (%v)", v.Name, field.Class)
+}
+
+// locEntry finds the expression for pc in the DWARF 4 location list at off
+// in .debug_loc.
+func (s *dwarfSections) locEntry(cu *dwarf.Entry, off int64, pc uint64, name string) ([]byte, error) {
+	base, _ := cu.Val(dwarf.AttrLowpc).(uint64)
+	b := s.loc
+	if off < 0 || off > int64(len(b)) {
+		return nil, fmt.Errorf("%s: location list at %#x is outside .debug_loc", name, off)
+	}
+	for b = b[off:]; len(b) >= 16; {
+		lo, hi := binary.LittleEndian.Uint64(b), binary.LittleEndian.Uint64(b[8:])
+		b = b[16:]
+		switch {
+		case lo == 0 && hi == 0:
+			return nil, fmt.Errorf("%s isn't available here", name)
+		case lo == ^uint64(0):
+			base = hi
+			continue
+		}
+		if len(b) < 2 {
+			break
+		}
+		n := int(binary.LittleEndian.Uint16(b))
+		if len(b) < 2+n {
+			break
+		}
+		expr := b[2 : 2+n]
+		b = b[2+n:]
+		if base+lo <= pc && pc < base+hi {
+			return expr, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: truncated location list at %#x", name, off)
+}
+
+// DWARF 5 location list entry kinds (DW_LLE_*).
+const (
+	lleEndOfList       = 0x00
+	lleBaseAddressx    = 0x01
+	lleStartxEndx      = 0x02
+	lleStartxLength    = 0x03
+	lleOffsetPair      = 0x04
+	lleDefaultLocation = 0x05
+	lleBaseAddress     = 0x06
+	lleStartEnd        = 0x07
+	lleStartLength     = 0x08
+)
+
+// locListsEntry finds the expression for pc in the DWARF 5 location list at
+// off in .debug_loclists.
+func (s *dwarfSections) locListsEntry(cu *dwarf.Entry, off int64, pc uint64, name string) ([]byte, error) {
+	base, _ := cu.Val(dwarf.AttrLowpc).(uint64)
+	addrBase, _ := cu.Val(dwarf.AttrAddrBase).(int64)
+	b := s.locLists
+	if off < 0 || off > int64(len(b)) {
+		return nil, fmt.Errorf("%s: location list at %#x is outside .debug_loclists", name, off)
+	}
+
+	var bad error
+	addrx := func(i uint64) uint64 {
+		at := uint64(addrBase) + 8*i
+		if at+8 > uint64(len(s.addr)) {
+			bad = fmt.Errorf("%s: address index %d is outside .debug_addr", name, i)
+			return 0
+		}
+		return binary.LittleEndian.Uint64(s.addr[at:])
+	}
+	addr := func() uint64 {
+		if len(b) < 8 {
+			bad = fmt.Errorf("%s: truncated location list at %#x", name, off)
+			return 0
+		}
+		a := binary.LittleEndian.Uint64(b)
+		b = b[8:]
+		return a
+	}
+
+	var deflt []byte
+	for b = b[off:]; len(b) > 0 && bad == nil; {
+		kind := b[0]
+		b = b[1:]
+		var lo, hi, n uint64
+		switch kind {
+		case lleEndOfList:
+			if deflt != nil {
+				return deflt, nil
+			}
+			return nil, fmt.Errorf("%s isn't available here", name)
+		case lleBaseAddressx:
+			n, b = uleb128(b)
+			base = addrx(n)
+			continue
+		case lleBaseAddress:
+			base = addr()
+			continue
+		case lleStartxEndx:
+			n, b = uleb128(b)
+			lo = addrx(n)
+			n, b = uleb128(b)
+			hi = addrx(n)
+		case lleStartxLength:
+			n, b = uleb128(b)
+			lo = addrx(n)
+			n, b = uleb128(b)
+			hi = lo + n
+		case lleOffsetPair:
+			lo, b = uleb128(b)
+			hi, b = uleb128(b)
+			lo, hi = base+lo, base+hi
+		case lleDefaultLocation:
+		case lleStartEnd:
+			lo = addr()
+			hi = addr()
+		case lleStartLength:
+			lo = addr()
+			n, b = uleb128(b)
+			hi = lo + n
+		default:
+			return nil, fmt.Errorf("%s: unknown location list entry %#x", name, kind)
+		}
+
+		n, b = uleb128(b)
+		if n > uint64(len(b)) {
+			break
+		}
+		expr := b[:n]
+		b = b[n:]
+		if kind == lleDefaultLocation {
+			deflt = expr
+		} else if lo <= pc && pc < hi {
+			return expr, nil
+		}
+	}
+	if bad != nil {
+		return nil, bad
+	}
+	return nil, fmt.Errorf("%s: truncated location list at %#x", name, off)
+}
+
+// locPiece is where a piece of a variable lives: in memory, in a register
+// or, for computed values, nowhere but the expression.
+type locPiece struct {
+	addr  uint64
+	reg   uint64
+	size  int64 // 0 for the whole variable
+	kind  int
+	value uint64
+}
+
+const (
+	pieceMemory = iota
+	pieceRegister
+	pieceValue
+)
+
+func (p locPiece) inMemory() bool {
+	return p.kind == pieceMemory && p.size == 0
+}
+
+// read returns the bytes of p. Pieces of registers and computed values are
+// taken from the low end of the register or value.
+func (p locPiece) read(d *Debugger, pid int, regs *syscall.PtraceRegs) ([]byte, error) {
+	var word uint64
+	switch p.kind {
+	case pieceMemory:
+		buf := make([]byte, p.size)
+		err := d.ReadMemory(pid, p.addr, buf)
+		return buf, err
+	case pieceRegister:
+		v, err := dwarfRegister(regs, p.reg)
+		if err != nil {
+			return nil, err
+		}
+		word = v
+	case pieceValue:
+		word = p.value
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, word)
+	if p.size > 8 {
+		return nil, fmt.Errorf("%d byte piece of a register", p.size)
+	}
+	return buf[:p.size], nil
+}
+
+// exprFrame evaluates DWARF location expressions in one frame.
+type exprFrame struct {
+	d    *Debugger
+	pid  int
+	regs *syscall.PtraceRegs
+	fn   *dwarf.Entry
+}
+
+// DWARF expression operations (DW_OP_*) the Go compiler emits, plus the
+// common arithmetic.
+const (
+	opAddr         = 0x03
+	opDeref        = 0x06
+	opConst1u      = 0x08
+	opConst1s      = 0x09
+	opConst2u      = 0x0a
+	opConst2s      = 0x0b
+	opConst4u      = 0x0c
+	opConst4s      = 0x0d
+	opConst8u      = 0x0e
+	opConst8s      = 0x0f
+	opConstu       = 0x10
+	opConsts       = 0x11
+	opDup          = 0x12
+	opDrop         = 0x13
+	opMinus        = 0x1c
+	opPlus         = 0x22
+	opPlusUconst   = 0x23
+	opLit0         = 0x30
+	opLit31        = 0x4f
+	opReg0         = 0x50
+	opReg31        = 0x6f
+	opBreg0        = 0x70
+	opBreg31       = 0x8f
+	opRegx         = 0x90
+	opFbreg        = 0x91
+	opBregx        = 0x92
+	opPiece        = 0x93
+	opCallFrameCFA = 0x9c
+	opStackValue   = 0x9f
+)
+
+// eval evaluates a location expression into the pieces of the variable. A
+// single piece without a size covers the whole variable.
+func (f *exprFrame) eval(expr []byte) ([]locPiece, error) {
+	var stack []uint64
+	var pieces []locPiece
+	var pending *locPiece // a register or value waiting for DW_OP_piece
+
+	pop := func() (uint64, error) {
+		if len(stack) == 0 {
+			return 0, fmt.Errorf("DWARF expression stack underflow")
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+	fixed := func(size int) (uint64, error) {
+		if len(expr) < size {
+			return 0, fmt.Errorf("truncated DWARF expression")
+		}
+		var buf [8]byte
+		copy(buf[:], expr[:size])
+		expr = expr[size:]
+		return binary.LittleEndian.Uint64(buf[:]), nil
+	}
+
+	for len(expr) > 0 {
+		op := expr[0]
+		expr = expr[1:]
+		var n uint64
+		var off int64
+		var err error
+		switch {
+		case op >= opLit0 && op <= opLit31:
+			stack = append(stack, uint64(op-opLit0))
+		case op >= opReg0 && op <= opReg31:
+			pending = &locPiece{kind: pieceRegister, reg: uint64(op - opReg0)}
+		case op >= opBreg0 && op <= opBreg31:
+			off, expr = sleb128(expr)
+			n, err = dwarfRegister(f.regs, uint64(op-opBreg0))
+			stack = append(stack, uint64(int64(n)+off))
+		case op == opRegx:
+			n, expr = uleb128(expr)
+			pending = &locPiece{kind: pieceRegister, reg: n}
+		case op == opBregx:
+			n, expr = uleb128(expr)
+			off, expr = sleb128(expr)
+			n, err = dwarfRegister(f.regs, n)
+			stack = append(stack, uint64(int64(n)+off))
+		case op == opAddr:
+			n, err = fixed(8)
+			stack = append(stack, f.d.loadAddr(n))
+		case op == opConst1u, op == opConst2u, op == opConst4u, op == opConst8u:
+			n, err = fixed(1 << ((op - opConst1u) / 2))
+			stack = append(stack, n)
+		case op == opConst1s, op == opConst2s, op == opConst4s, op == opConst8s:
+			size := 1 << ((op - opConst1s) / 2)
+			n, err = fixed(size)
+			shift := 64 - 8*uint(size)
+			stack = append(stack, uint64(int64(n<<shift)>>shift))
+		case op == opConstu:
+			n, expr = uleb128(expr)
+			stack = append(stack, n)
+		case op == opConsts:
+			off, expr = sleb128(expr)
+			stack = append(stack, uint64(off))
+		case op == opDup:
+			n, err = pop()
+			stack = append(stack, n, n)
+		case op == opDrop:
+			_, err = pop()
+		case op == opPlus, op == opMinus:
+			var a, b uint64
+			if b, err = pop(); err == nil {
+				a, err = pop()
+			}
+			if op == opPlus {
+				stack = append(stack, a+b)
+			} else {
+				stack = append(stack, a-b)
+			}
+		case op == opPlusUconst:
+			n, expr = uleb128(expr)
+			var a uint64
+			a, err = pop()
+			stack = append(stack, a+n)
+		case op == opDeref:
+			var a uint64
+			if a, err = pop(); err == nil {
+				n, err = f.d.readUint(f.pid, a, 8)
+			}
+			stack = append(stack, n)
+		case op == opCallFrameCFA:
+			n, err = f.d.cfa(f.regs.Rip, f.regs)
+			stack = append(stack, n)
+		case op == opFbreg:
+			off, expr = sleb128(expr)
+			n, err = f.frameBase()
+			stack = append(stack, uint64(int64(n)+off))
+		case op == opStackValue:
+			n, err = pop()
+			pending = &locPiece{kind: pieceValue, value: n}
+		case op == opPiece:
+			n, expr = uleb128(expr)
+			p := locPiece{kind: pieceMemory}
+			if pending != nil {
+				p, pending = *pending, nil
+			} else {
+				p.addr, err = pop()
+			}
+			p.size = int64(n)
+			pieces = append(pieces, p)
+		default:
+			return nil, fmt.Errorf("unsupported DWARF operation %#x", op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case pieces != nil:
+		return pieces, nil
+	case pending != nil:
+		if pending.kind == pieceRegister {
+			pending.size = 8
+		}
+		return []locPiece{*pending}, nil
+	case len(stack) > 0:
+		return []locPiece{{kind: pieceMemory, addr: stack[len(stack)-1]}}, nil
+	}
+	return nil, fmt.Errorf("empty DWARF location")
+}
+
+// frameBase evaluates the frame base of the function, DW_OP_call_frame_cfa
+// for Go.
+func (f *exprFrame) frameBase() (uint64, error) {
+	expr, _ := f.fn.Val(dwarf.AttrFrameBase).([]byte)
+	if expr == nil {
+		return 0, fmt.Errorf("function has no frame base")
+	}
+	pieces, err := (&exprFrame{d: f.d, pid: f.pid, regs: f.regs}).eval(expr)
+	if err != nil {
+		return 0, err
+	}
+	if len(pieces) != 1 || !pieces[0].inMemory() {
+		return 0, fmt.Errorf("unsupported frame base")
+	}
+	return pieces[0].addr, nil
+}
+
+// printCommand handles "print <expr>", which prints the value of a
+// variable, register or struct field at the stop. -json prints it as
+// JSON, -o <file> writes it to the file instead, as JSON if the file name
+// ends in .json, and -clip copies it to the terminal's clipboard.
+func (d *Debugger) printCommand(pid int, args []string) bool {
+	var asJSON, clip bool
+	var out string
+	for len(args) > 1 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-json":
+			asJSON = true
+		case "-clip":
+			clip = true
+		case "-o":
+			out, args = args[1], args[1:]
+			asJSON = asJSON || strings.HasSuffix(out, ".json")
+		default:
+			d.printf("unknown option %s\n", args[0])
+			return false
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		d.println("usage: print [-json] [-o <file>] [-clip] <expr>")
+		return false
+	}
+	expr := strings.Join(args, " ")
+	var text string
+	if v, ok := registerValue(&d.Regs, expr); ok {
+		text = fmt.Sprintf("%#x", v)
+		if asJSON {
+			text = fmt.Sprint(v)
+		}
+	} else {
+		val, err := d.evalValue(pid, expr)
+		if err != nil {
+			d.println(err)
+			return false
+		}
+		if asJSON {
+			if text, err = d.jsonValue(pid, val.typ, val.buf); err != nil {
+				d.println(err)
+				return false
+			}
+		} else {
+			text = d.formatValue(pid, val.typ, val.buf, 0)
+		}
+	}
+
+	switch {
+	case out != "" || clip:
+		if out != "" {
+			if err := os.WriteFile(out, []byte(text+"\n"), 0o644); err != nil {
+				d.println(err)
+				return false
+			}
+			d.printf("Wrote %s to %s (%d bytes)\n", expr, out, len(text)+1)
+		}
+		if clip {
+			if err := d.copyToClipboard(text); err != nil {
+				d.println(err)
+				return false
+			}
+			d.printf("Copied %s to the clipboard (%d bytes)\n", expr, len(text))
+		}
+	case asJSON:
+		d.println(text)
+	default:
+		d.printf("%s = %s\n", expr, text)
+	}
+	return false
+}
+
+// localsCommand handles "locals", which prints the arguments and local
+// variables in scope at the stop.
+func (d *Debugger) localsCommand(pid int, args []string) bool {
+	vars, err := d.Variables(d.Regs.Rip)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	for _, arg := range []bool{true, false} {
+		if arg {
+			d.println("Arguments:")
+		} else {
+			d.println("Locals:")
+		}
+		for _, v := range vars {
+			if v.Arg != arg || strings.HasPrefix(v.Name, "~") {
+				continue
+			}
+			val, err := d.variableValue(pid, &d.Regs, v)
+			if err != nil {
+				d.printf("  %s = <%v>\n", v.Name, err)
+				continue
+			}
+			d.printf("  %s = %s\n", v.Name, d.formatValue(pid, val.typ, val.buf, 0))
+		}
+	}
+	return false
+}
@@ -0,0 +1,324 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"syscall"
+)
+
+// fde is a frame description entry of .debug_frame: how to find the
+// canonical frame address (CFA) anywhere in [start, end).
+type fde struct {
+	start, end uint64
+	cie        *cie
+	instr      []byte
+}
+
+// cie is a common information entry, shared by the FDEs of a unit.
+type cie struct {
+	codeAlign uint64
+	dataAlign int64
+	instr     []byte
+}
+
+// cfaRule is the CFA at a PC: the value of a register plus an offset.
+type cfaRule struct {
+	reg    uint64
+	offset int64
+}
+
+// parseFrames reads the FDEs of a .debug_frame section, sorted by address.
+func parseFrames(data []byte) ([]fde, error) {
+	cies := make(map[uint64]*cie)
+	var fdes []fde
+	for off := uint64(0); off+4 <= uint64(len(data)); {
+		length := uint64(binary.LittleEndian.Uint32(data[off:]))
+		if length == 0xffffffff {
+			return nil, fmt.Errorf("64-bit .debug_frame isn't supported")
+		}
+		start, end := off+4, off+4+length
+		if end > uint64(len(data)) || length < 4 {
+			return nil, fmt.Errorf("truncated .debug_frame entry at %#x", off)
+		}
+		entry := data[start:end]
+		id := uint64(binary.LittleEndian.Uint32(entry))
+
+		if id == 0xffffffff {
+			c, err := parseCIE(entry[4:])
+			if err != nil {
+				return nil, fmt.Errorf(".debug_frame CIE at %#x: %v", off, err)
+			}
+			cies[off] = c
+		} else {
+			c, ok := cies[id]
+			if !ok || len(entry) < 20 {
+				return nil, fmt.Errorf(".debug_frame FDE at %#x has no CIE", off)
+			}
+			pc := binary.LittleEndian.Uint64(entry[4:])
+			size := binary.LittleEndian.Uint64(entry[12:])
+			fdes = append(fdes, fde{start: pc, end: pc + size, cie: c, instr: entry[20:]})
+		}
+		off = end
+	}
+	sort.Slice(fdes, func(i, j int) bool { return fdes[i].start < fdes[j].start })
+	return fdes, nil
+}
+
+func parseCIE(b []byte) (*cie, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("truncated")
+	}
+	version := b[0]
+	b = b[1:]
+	// Go writes no augmentation, which anything else would need.
+	if b[0] != 0 {
+		return nil, fmt.Errorf("augmentation %q isn't supported", b)
+	}
+	b = b[1:]
+	if version == 4 {
+		// Address and segment selector sizes.
+		b = b[2:]
+	}
+	c := &cie{}
+	c.codeAlign, b = uleb128(b)
+	c.dataAlign, b = sleb128(b)
+	if version == 1 {
+		b = b[1:]
+	} else {
+		_, b = uleb128(b)
+	}
+	c.instr = b
+	return c, nil
+}
+
+// frameDescs returns the frame descriptions of .debug_frame, parsed on first
+// use. Once they are, cfa can be called from several goroutines at once.
+func (d *Debugger) frameDescs() ([]fde, error) {
+	if d.dwarfData.fdes == nil {
+		if len(d.dwarfData.frame) == 0 {
+			return nil, fmt.Errorf("no .debug_frame section")
+		}
+		fdes, err := parseFrames(d.dwarfData.frame)
+		if err != nil {
+			return nil, err
+		}
+		d.dwarfData.fdes = fdes
+	}
+	return d.dwarfData.fdes, nil
+}
+
+// cfa returns the canonical frame address of the frame executing at pc, as
+// described by .debug_frame: the stack pointer before the call that entered
+// it. Go functions have their arguments' spill slots and their frame base
+// there.
+func (d *Debugger) cfa(pc uint64, regs *syscall.PtraceRegs) (uint64, error) {
+	fdes, err := d.frameDescs()
+	if err != nil {
+		return 0, err
+	}
+	pc = d.linkPC(pc)
+	i := sort.Search(len(fdes), func(i int) bool { return fdes[i].end > pc })
+	if i == len(fdes) || fdes[i].start > pc {
+		return 0, fmt.Errorf("no frame information for %#x", d.loadAddr(pc))
+	}
+	f := fdes[i]
+
+	rule, err := runCFA(f.cie, f.cie.instr, f.start, pc, cfaRule{})
+	if err == nil {
+		rule, err = runCFA(f.cie, f.instr, f.start, pc, rule)
+	}
+	if err != nil {
+		return 0, err
+	}
+	base, err := dwarfRegister(regs, rule.reg)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(int64(base) + rule.offset), nil
+}
+
+// DWARF call frame instructions. The first three carry an operand in their
+// low six bits.
+const (
+	cfaAdvanceLoc       = 0x40
+	cfaOffset           = 0x80
+	cfaRestore          = 0xc0
+	cfaNop              = 0x00
+	cfaSetLoc           = 0x01
+	cfaAdvanceLoc1      = 0x02
+	cfaAdvanceLoc2      = 0x03
+	cfaAdvanceLoc4      = 0x04
+	cfaOffsetExtended   = 0x05
+	cfaRestoreExtended  = 0x06
+	cfaUndefined        = 0x07
+	cfaSameValue        = 0x08
+	cfaRegister         = 0x09
+	cfaRememberState    = 0x0a
+	cfaRestoreState     = 0x0b
+	cfaDefCFA           = 0x0c
+	cfaDefCFARegister   = 0x0d
+	cfaDefCFAOffset     = 0x0e
+	cfaDefCFAExpression = 0x0f
+	cfaExpression       = 0x10
+	cfaOffsetExtendedSf = 0x11
+	cfaDefCFASf         = 0x12
+	cfaDefCFAOffsetSf   = 0x13
+	cfaValOffset        = 0x14
+	cfaValOffsetSf      = 0x15
+	cfaValExpression    = 0x16
+)
+
+// runCFA runs the call frame instructions in b for code starting at loc
+// until they describe an address past pc, and returns the CFA rule then.
+// Only the CFA is tracked; rules for other registers are skipped.
+func runCFA(c *cie, b []byte, loc, pc uint64, rule cfaRule) (cfaRule, error) {
+	var saved []cfaRule
+	for len(b) > 0 && loc <= pc {
+		op := b[0]
+		b = b[1:]
+		switch op & 0xc0 {
+		case cfaAdvanceLoc:
+			loc += uint64(op&0x3f) * c.codeAlign
+			continue
+		case cfaOffset:
+			_, b = uleb128(b)
+			continue
+		case cfaRestore:
+			continue
+		}
+
+		var n uint64
+		var off int64
+		switch op {
+		case cfaNop:
+		case cfaSetLoc:
+			if len(b) < 8 {
+				return rule, fmt.Errorf("truncated DW_CFA_set_loc")
+			}
+			loc, b = binary.LittleEndian.Uint64(b), b[8:]
+		case cfaAdvanceLoc1, cfaAdvanceLoc2, cfaAdvanceLoc4:
+			size := 1 << (op - cfaAdvanceLoc1)
+			if len(b) < size {
+				return rule, fmt.Errorf("truncated DW_CFA_advance_loc")
+			}
+			var buf [8]byte
+			copy(buf[:], b[:size])
+			loc += binary.LittleEndian.Uint64(buf[:]) * c.codeAlign
+			b = b[size:]
+		case cfaRememberState:
+			saved = append(saved, rule)
+		case cfaRestoreState:
+			if len(saved) == 0 {
+				return rule, fmt.Errorf("DW_CFA_restore_state without saved state")
+			}
+			rule, saved = saved[len(saved)-1], saved[:len(saved)-1]
+		case cfaDefCFA:
+			rule.reg, b = uleb128(b)
+			n, b = uleb128(b)
+			rule.offset = int64(n)
+		case cfaDefCFASf:
+			rule.reg, b = uleb128(b)
+			off, b = sleb128(b)
+			rule.offset = off * c.dataAlign
+		case cfaDefCFARegister:
+			rule.reg, b = uleb128(b)
+		case cfaDefCFAOffset:
+			n, b = uleb128(b)
+			rule.offset = int64(n)
+		case cfaDefCFAOffsetSf:
+			off, b = sleb128(b)
+			rule.offset = off * c.dataAlign
+		case cfaOffsetExtended, cfaRegister, cfaValOffset, cfaOffsetExtendedSf, cfaValOffsetSf:
+			_, b = uleb128(b)
+			_, b = uleb128(b)
+		case cfaRestoreExtended, cfaUndefined, cfaSameValue:
+			_, b = uleb128(b)
+		case cfaExpression, cfaValExpression:
+			_, b = uleb128(b)
+			n, b = uleb128(b)
+			if n > uint64(len(b)) {
+				return rule, fmt.Errorf("truncated DWARF expression")
+			}
+			b = b[n:]
+		case cfaDefCFAExpression:
+			return rule, fmt.Errorf("DW_CFA_def_cfa_expression isn't supported")
+		default:
+			return rule, fmt.Errorf("unknown call frame instruction %#x", op)
+		}
+	}
+	return rule, nil
+}
+
+// dwarfRegister returns the register numbered n in the DWARF numbering of
+// amd64.
+func dwarfRegister(regs *syscall.PtraceRegs, n uint64) (uint64, error) {
+	switch n {
+	case 0:
+		return regs.Rax, nil
+	case 1:
+		return regs.Rdx, nil
+	case 2:
+		return regs.Rcx, nil
+	case 3:
+		return regs.Rbx, nil
+	case 4:
+		return regs.Rsi, nil
+	case 5:
+		return regs.Rdi, nil
+	case 6:
+		return regs.Rbp, nil
+	case 7:
+		return regs.Rsp, nil
+	case 8:
+		return regs.R8, nil
+	case 9:
+		return regs.R9, nil
+	case 10:
+		return regs.R10, nil
+	case 11:
+		return regs.R11, nil
+	case 12:
+		return regs.R12, nil
+	case 13:
+		return regs.R13, nil
+	case 14:
+		return regs.R14, nil
+	case 15:
+		return regs.R15, nil
+	case 16:
+		return regs.Rip, nil
+	}
+	return 0, fmt.Errorf("DWARF register %d isn't supported", n)
+}
+
+func uleb128(b []byte) (uint64, []byte) {
+	var v uint64
+	var shift uint
+	for i, c := range b {
+		v |= uint64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			return v, b[i+1:]
+		}
+	}
+	return v, nil
+}
+
+func sleb128(b []byte) (int64, []byte) {
+	var v int64
+	var shift uint
+	for i, c := range b {
+		v |= int64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 64 && c&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v, b[i+1:]
+		}
+	}
+	return v, nil
+}
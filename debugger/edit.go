@@ -0,0 +1,74 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// editCommand handles "edit [<location>]", which opens the source of the
+// location, given as to break, or of the stop in the editor.
+func (d *Debugger) editCommand(pid int, args []string) bool {
+	var file string
+	var line int
+	switch len(args) {
+	case 0:
+		file, line, _ = d.pcToLine(d.Regs.Rip)
+		if file == "" {
+			d.println("No source for the current location")
+			return false
+		}
+	case 1:
+		var err error
+		if file, line, err = d.parseLocation(args[0]); err != nil {
+			d.println(err)
+			return false
+		}
+	default:
+		d.println("usage: edit [[file:]line|function]")
+		return false
+	}
+	path, err := d.sourcePath(file)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	if err := d.openEditor(path, line); err != nil {
+		d.printf("Can't open %s:%d: %v\n", path, line, err)
+	}
+	return false
+}
+
+// openEditor opens line of the file at path with Editor, or else $VISUAL or
+// $EDITOR. An editor with "://" is a URL template, as
+// "vscode://file/{file}:{line}", opened with xdg-open. Otherwise it is a
+// command, run in the terminal until it exits: {file} and {line} in it are
+// replaced, and without them "+<line> <file>" is appended, as vi, emacs and
+// nano take.
+func (d *Debugger) openEditor(path string, line int) error {
+	editor := strings.TrimSpace(cmp.Or(d.Editor, os.Getenv("VISUAL"), os.Getenv("EDITOR")))
+	if editor == "" {
+		return errors.New("no editor: set one with -editor, set editor or $EDITOR")
+	}
+	expand := strings.NewReplacer("{file}", path, "{line}", strconv.Itoa(line)).Replace
+
+	if strings.Contains(editor, "://") {
+		return exec.Command("xdg-open", expand(editor)).Run()
+	}
+	fields := strings.Fields(editor)
+	if !strings.Contains(editor, "{file}") {
+		fields = append(fields, fmt.Sprintf("+%d", line), path)
+	}
+	for i, f := range fields {
+		fields[i] = expand(f)
+	}
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,69 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// Errors the API returns, possibly wrapped; test for them with errors.Is.
+var (
+	// ErrNoSymbolTable is returned for executables without a Go line table,
+	// such as C programs.
+	ErrNoSymbolTable = errors.New("no Go symbol table")
+	// ErrNoDWARF is returned by lookups that need the DWARF information the
+	// target was built without.
+	ErrNoDWARF = errors.New("no DWARF information")
+	// ErrBreakpointNotFound is returned for breakpoint ids that aren't set.
+	ErrBreakpointNotFound = errors.New("no such breakpoint")
+	// ErrReadOnly is returned for changes to the target in a read-only
+	// session: writes of memory or registers, breakpoints and watchpoints.
+	ErrReadOnly = errors.New("the session is read-only")
+	// ErrCoreFile is returned for resuming the process of a core file,
+	// which is dead.
+	ErrCoreFile = errors.New("the process of a core file can't run")
+	// ErrRuntimeNotReady is returned for lookups of goroutines in a target
+	// stopped before its runtime set up the first one, such as at the
+	// entry point or in the dynamic loader.
+	ErrRuntimeNotReady = errors.New("runtime not yet initialized")
+	// ErrInterrupted is returned by reads of the target's memory once ^C
+	// has canceled the command at the prompt.
+	ErrInterrupted = errors.New("interrupted")
+	// ErrUnsafePoint is returned for writes to a target interrupted, with
+	// SafePoints, where it couldn't be brought to a safe point.
+	ErrUnsafePoint = errors.New("not at a safe point")
+	// ErrQuit is returned by Launch, Attach and Debug for a session that
+	// ended before the target did: the user quit or detached, or the
+	// commands ran out. The target is left running without breakpoints,
+	// or killed to save the session on exit.
+	ErrQuit = errors.New("the session was quit")
+)
+
+// PtraceError describes a failed ptrace request on a thread of the target.
+type PtraceError struct {
+	Op  string // what the request was for, e.g. "continuing"
+	Tid int
+	Err error
+}
+
+func (e *PtraceError) Error() string {
+	return fmt.Sprintf("%s thread %d: %v", e.Op, e.Tid, e.Err)
+}
+
+func (e *PtraceError) Unwrap() error {
+	return e.Err
+}
+
+// wrapPtrace wraps the error of a ptrace request on tid, if any. ESRCH,
+// for a thread that is gone or not stopped, reads as ErrNotStopped.
+func wrapPtrace(op string, tid int, err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == syscall.ESRCH {
+		err = ErrNotStopped
+	}
+	return &PtraceError{Op: op, Tid: tid, Err: err}
+}
@@ -0,0 +1,127 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Event is a debugger event written to the event stream as one JSON object
+// per line.
+type Event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Pid    int       `json:"pid,omitempty"`
+	PC     string    `json:"pc,omitempty"`
+	Func   string    `json:"func,omitempty"`
+	File   string    `json:"file,omitempty"`
+	Line   int       `json:"line,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Hits   int       `json:"hits,omitempty"`
+	Status *int      `json:"status,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	Done   int64     `json:"done,omitempty"`
+	Total  int64     `json:"total,omitempty"`
+	// Values holds the snapshot expressions of a recording at a stop or
+	// hit.
+	Values map[string]string `json:"values,omitempty"`
+}
+
+// OpenEventFD streams events to the already open file descriptor fd, which
+// the target doesn't inherit.
+func (d *Debugger) OpenEventFD(fd int) error {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("event-fd-%d", fd))
+	if f == nil {
+		return fmt.Errorf("bad file descriptor %d", fd)
+	}
+	syscall.CloseOnExec(fd)
+	d.events = f
+	return nil
+}
+
+// OpenEventPipe streams events to the named pipe at path, creating it if it
+// doesn't exist. Opening blocks until a reader has opened the pipe.
+func (d *Debugger) OpenEventPipe(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := syscall.Mkfifo(path, 0o600); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	d.events = f
+	return nil
+}
+
+// Subscribe returns a channel that receives every emitted event, and a
+// function ending the subscription. Slow subscribers miss events rather
+// than blocking the debugger.
+func (d *Debugger) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	d.subMu.Lock()
+	if d.subscribers == nil {
+		d.subscribers = make(map[chan Event]struct{})
+	}
+	d.subscribers[ch] = struct{}{}
+	d.subMu.Unlock()
+
+	return ch, func() {
+		d.subMu.Lock()
+		delete(d.subscribers, ch)
+		d.subMu.Unlock()
+	}
+}
+
+// Emit writes ev to the event stream, if one is configured, and hands it to
+// all subscribers. A reader that went away disables the stream rather than
+// the session.
+func (d *Debugger) Emit(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	d.snapshot(&ev)
+
+	d.subMu.Lock()
+	for ch := range d.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	d.subMu.Unlock()
+
+	if d.events == nil {
+		return
+	}
+	if err := json.NewEncoder(d.events).Encode(ev); err != nil {
+		d.events = nil
+	}
+}
+
+// locationEvent returns an event of type typ describing pc in pid.
+func (d *Debugger) locationEvent(typ string, pid int, pc uint64) Event {
+	l := d.LookupPC(pc)
+	return Event{Type: typ, Pid: pid, PC: fmt.Sprintf("%#x", pc), Func: l.Func, File: l.File, Line: l.Line}
+}
+
+// exitEvent describes the exit of the target with status ws.
+func exitEvent(pid int, ws syscall.WaitStatus) Event {
+	ev := Event{Type: "exit", Pid: pid}
+	status := ws.ExitStatus()
+	if ws.Signaled() {
+		ev.Detail = ws.Signal().String()
+	}
+	ev.Status = &status
+	return ev
+}
+
+// emitExit reports the exit of the target.
+func (d *Debugger) emitExit(pid int, ws syscall.WaitStatus) {
+	d.Emit(exitEvent(pid, ws))
+}
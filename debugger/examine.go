@@ -0,0 +1,311 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// bytesPerRow is how much memory a row of "x" output shows.
+const bytesPerRow = 16
+
+// examineCommand handles "x/<count><format><size> <address>", which dumps
+// memory of the target. The format is x (hex), d (signed), u (unsigned) or
+// c (characters) and the size b, h, w or g for 1, 2, 4 or 8 byte units;
+// count is the number of units. Hex bytes are laid out as a hexdump, 16 to
+// a row with their ASCII beside them:
+//
+//	x/128xb buf
+//
+// Without a format, x dumps 64 hex bytes. "x <address> <length> [hex|ascii|u64]"
+// is the same with the length in bytes and the format by name. The address
+// is a number, a register, &<variable> or a variable: pointers are dumped
+// where they point, strings and slices at their data and other values where
+// they are stored.
+func (d *Debugger) examineCommand(pid int, args []string) bool {
+	const usage = "usage: x[/<count><x|d|u|c><b|h|w|g>] <address> | x <address> <length> [hex|ascii|u64]"
+	format := ""
+	if len(args) > 0 && strings.HasPrefix(args[0], "/") {
+		format, args = args[0][1:], args[1:]
+	} else if len(args) == 2 || len(args) == 3 {
+		var err error
+		if format, err = namedExamineFormat(args[1:]); err != nil {
+			d.printf("%v\n%s\n", err, usage)
+			return false
+		}
+		args = args[:1]
+	}
+	if len(args) != 1 {
+		d.println(usage)
+		return false
+	}
+	count, verb, size, err := parseExamineFormat(format)
+	if err != nil {
+		d.printf("%v\n%s\n", err, usage)
+		return false
+	}
+	addr, err := d.examineAddr(pid, args[0])
+	if err != nil {
+		d.println(err)
+		return false
+	}
+
+	buf := make([]byte, count*size)
+	if err := d.ReadMemory(pid, addr, buf); err != nil {
+		d.println(err)
+		return false
+	}
+	var b strings.Builder
+	for off := 0; off < len(buf); off += bytesPerRow {
+		row := buf[off:min(off+bytesPerRow, len(buf))]
+		fmt.Fprintf(&b, "%#016x:", addr+uint64(off))
+		if verb == 'x' && size == 1 {
+			writeHexRow(&b, row)
+		} else if verb == 'c' {
+			b.WriteString(" |")
+			writeASCII(&b, row)
+			b.WriteByte('|')
+		} else {
+			for i := 0; i < len(row); i += size {
+				u := bufUint(row[i:], int64(size))
+				switch verb {
+				case 'x':
+					fmt.Fprintf(&b, " 0x%0*x", 2*size, u)
+				case 'd':
+					// Shift the unit's sign bit to the top to extend it.
+					shift := 64 - 8*size
+					fmt.Fprintf(&b, " %d", int64(u<<shift)>>shift)
+				case 'u':
+					fmt.Fprintf(&b, " %d", u)
+				}
+			}
+		}
+		b.WriteByte('\n')
+	}
+	d.UI.ShowOutput(b.String())
+	return false
+}
+
+// writeHexRow writes row as hexdump -C does: the bytes in hex, in two
+// groups of 8, then the printable ones as ASCII between bars.
+func writeHexRow(b *strings.Builder, row []byte) {
+	for i := 0; i < bytesPerRow; i++ {
+		if i == bytesPerRow/2 {
+			b.WriteByte(' ')
+		}
+		if i < len(row) {
+			fmt.Fprintf(b, " %02x", row[i])
+		} else {
+			b.WriteString("   ")
+		}
+	}
+	b.WriteString("  |")
+	writeASCII(b, row)
+	b.WriteByte('|')
+}
+
+// writeASCII writes the printable bytes of row, and dots for the others.
+func writeASCII(b *strings.Builder, row []byte) {
+	for _, c := range row {
+		if c < ' ' || c > '~' {
+			c = '.'
+		}
+		b.WriteByte(c)
+	}
+}
+
+// namedExamineFormat turns the length and format name of "x <address>
+// <length> [hex|ascii|u64]" into the format of "x/". A length that isn't a
+// whole number of units is rounded up.
+func namedExamineFormat(args []string) (string, error) {
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return "", fmt.Errorf("bad length %q", args[0])
+	}
+	name := "hex"
+	if len(args) > 1 {
+		name = args[1]
+	}
+	switch name {
+	case "hex":
+		return fmt.Sprintf("%dxb", n), nil
+	case "ascii":
+		return fmt.Sprintf("%dcb", n), nil
+	case "u64":
+		return fmt.Sprintf("%dug", (n+7)/8), nil
+	}
+	return "", fmt.Errorf("unknown format %q", name)
+}
+
+// parseExamineFormat parses the format of an x command, such as "128xb".
+// Parts left out default to 64, x and b.
+func parseExamineFormat(format string) (count int, verb byte, size int, err error) {
+	count, verb, size = 64, 'x', 1
+	digits := len(format) - len(strings.TrimLeft(format, "0123456789"))
+	if digits > 0 {
+		if count, err = strconv.Atoi(format[:digits]); err != nil || count <= 0 {
+			return 0, 0, 0, fmt.Errorf("bad count %q", format[:digits])
+		}
+	}
+	for _, c := range []byte(format[digits:]) {
+		switch c {
+		case 'x', 'd', 'u', 'c':
+			verb = c
+		case 'b':
+			size = 1
+		case 'h':
+			size = 2
+		case 'w':
+			size = 4
+		case 'g':
+			size = 8
+		default:
+			return 0, 0, 0, fmt.Errorf("unknown format letter %q", c)
+		}
+	}
+	if count*size > maxValueSize {
+		return 0, 0, 0, fmt.Errorf("can't examine more than %d bytes", maxValueSize)
+	}
+	if verb == 'c' {
+		// Characters are bytes, whatever the size.
+		count, size = count*size, 1
+	}
+	return count, verb, size, nil
+}
+
+// examineAddr returns the address an x command dumps for expr.
+func (d *Debugger) examineAddr(pid int, expr string) (uint64, error) {
+	if addr, err := strconv.ParseUint(expr, 0, 64); err == nil {
+		return addr, nil
+	}
+	if v, ok := registerValue(&d.Regs, expr); ok {
+		return v, nil
+	}
+	// A register with an offset, as "$rsp+16".
+	if i := strings.LastIndexAny(expr, "+-"); i > 0 {
+		v, ok := registerValue(&d.Regs, strings.TrimSpace(expr[:i]))
+		off, err := strconv.ParseInt(strings.TrimSpace(expr[i+1:]), 0, 64)
+		if ok && err == nil {
+			if expr[i] == '-' {
+				off = -off
+			}
+			return v + uint64(off), nil
+		}
+	}
+	if name, ok := strings.CutPrefix(expr, "&"); ok {
+		val, err := d.evalValue(pid, name)
+		if err != nil {
+			return 0, err
+		}
+		if val.addr == 0 {
+			return 0, fmt.Errorf("%s is not in memory", name)
+		}
+		return val.addr, nil
+	}
+
+	val, err := d.evalValue(pid, expr)
+	if err != nil {
+		return 0, err
+	}
+	typ := val.typ
+	for {
+		t, ok := typ.(*dwarf.TypedefType)
+		if !ok {
+			break
+		}
+		typ = t.Type
+	}
+	switch t := typ.(type) {
+	case *dwarf.PtrType:
+		return bufUint(val.buf, 8), nil
+	case *dwarf.StructType:
+		if t.StructName == "string" || strings.HasPrefix(t.StructName, "[]") {
+			return bufUint(val.buf, 8), nil
+		}
+	}
+	if val.addr == 0 {
+		return 0, fmt.Errorf("%s is not in memory", expr)
+	}
+	return val.addr, nil
+}
+
+// pokeCommand handles "poke <address> <bytes>...", which writes memory of
+// the target. The bytes are given in hex, together or apart ("deadbeef" or
+// "de ad be ef"), or as a quoted string. The address is taken as x takes
+// it.
+func (d *Debugger) pokeCommand(pid int, args []string) bool {
+	const usage = `usage: poke <address> <hex bytes>... | poke <address> "<string>"`
+	if len(args) < 2 {
+		d.println(usage)
+		return false
+	}
+	data, err := parsePokeData(strings.Join(args[1:], " "))
+	if err != nil {
+		d.printf("%v\n%s\n", err, usage)
+		return false
+	}
+	addr, err := d.examineAddr(pid, args[0])
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	err = d.PokeMemory(pid, addr, data)
+	d.audit("", "write-memory", pid, fmt.Sprintf("%#x % x", addr, data), err)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	d.printf("Wrote %d bytes at %#x\n", len(data), addr)
+	return false
+}
+
+// parsePokeData parses the bytes of a poke command.
+func parsePokeData(s string) ([]byte, error) {
+	if strings.HasPrefix(s, `"`) {
+		str, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad string %s", s)
+		}
+		return []byte(str), nil
+	}
+	digits := strings.ReplaceAll(strings.TrimPrefix(s, "0x"), " ", "")
+	data, err := hex.DecodeString(digits)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("bad hex bytes %q", s)
+	}
+	return data, nil
+}
+
+// PokeMemory writes data at addr to the stopped thread pid, like
+// WriteMemory, but leaves the breakpoints in the range armed: the bytes under
+// them become the code they restore.
+func (d *Debugger) PokeMemory(pid int, addr uint64, data []byte) error {
+	if err := d.checkSafePoint(); err != nil {
+		return err
+	}
+	data = slices.Clone(data)
+	end := addr + uint64(len(data))
+	for _, site := range d.traps {
+		if !site.armed || site.addr+uint64(len(site.orig)) <= addr || site.addr >= end {
+			continue
+		}
+		// A rewound trap is out of memory until it is re-armed.
+		rewound := d.rearm && d.rearmAt == site.addr
+		for i := range site.orig {
+			a := site.addr + uint64(i)
+			if a < addr || a >= end {
+				continue
+			}
+			site.orig[i] = data[a-addr]
+			if !rewound {
+				data[a-addr] = d.Arch.TrapCode[i]
+			}
+		}
+	}
+	return d.WriteMemory(pid, addr, data)
+}
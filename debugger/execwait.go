@@ -0,0 +1,191 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// prSetPtracer is the prctl that lets a process be traced by another one
+// than its ancestors under the Yama security module, and
+// prSetPtracerAny the tracer that lets any.
+const (
+	prSetPtracer    = 0x59616d61
+	prSetPtracerAny = ^uintptr(0)
+)
+
+// execWaitPoll is how often a process started by exec-wait checks whether
+// a debugger attached.
+const execWaitPoll = 20 * time.Millisecond
+
+// tokenFile returns the file where exec-wait leaves the pid of the process
+// waiting with token.
+func tokenFile(token string) string {
+	return filepath.Join(os.TempDir(), "dedebugger-wait-"+token)
+}
+
+// ExecWait implements "exec-wait <program> [args...]": it stands in for
+// the program where it can't be started by the debugger, as a systemd
+// service, in a container or from a test harness, and waits for a debugger
+// to attach with the token it prints, with "connect-token". It then execs
+// the program, which keeps the pid of the process started, and the
+// debugger stops it before it runs any code.
+func ExecWait(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("usage: exec-wait <program> [args...]")
+		return 2
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	file := tokenFile(token)
+	if err := os.WriteFile(file, []byte(strconv.Itoa(os.Getpid())), 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.Remove(file)
+	// A debugger that isn't an ancestor may attach.
+	syscall.RawSyscall(syscall.SYS_PRCTL, prSetPtracer, prSetPtracerAny, 0)
+	// Standard output is the program's; the token goes with the errors.
+	fmt.Fprintf(os.Stderr, "Waiting for a debugger: dedebugger connect-token %s\n", token)
+	for !traced() {
+		time.Sleep(execWaitPoll)
+	}
+	os.Remove(file)
+	err = syscall.Exec(path, args, os.Environ())
+	fmt.Fprintf(os.Stderr, "can't run %s: %v\n", path, err)
+	return 1
+}
+
+// traced reports whether the process is traced.
+func traced() bool {
+	b, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if v, ok := strings.CutPrefix(line, "TracerPid:"); ok {
+			return strings.TrimSpace(v) != "0"
+		}
+	}
+	return false
+}
+
+// ConnectToken implements "connect-token <token>".
+func ConnectToken(args []string) int {
+	if len(args) != 1 {
+		fmt.Println("usage: connect-token <token>")
+		return 2
+	}
+	d := NewDebugger()
+	d.HandleSignals = true
+	if ui, ok := d.UI.(*LineFrontend); ok && ui.editor != nil {
+		ui.editor.complete = d.Complete
+	}
+	if err := d.Connect(args[0]); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// Connect debugs the program a process started by exec-wait with token
+// runs. The process is attached and let go to exec the program, which
+// stops at its start, as a target the debugger launches does. The session
+// lasts until the target exits or the user detaches.
+func (d *Debugger) Connect(token string) error {
+	b, err := os.ReadFile(tokenFile(token))
+	if err != nil {
+		return fmt.Errorf("no process waits with token %s", token)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("bad token file %s", tokenFile(token))
+	}
+
+	// As in RunTarget, ptrace requests have to come from one OS thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	tids, err := d.attachThreads(pid)
+	if err != nil {
+		return err
+	}
+	for _, tid := range tids {
+		if err := syscall.PtraceCont(tid, 0); err != nil {
+			return wrapPtrace("continuing", tid, err)
+		}
+	}
+	// The other threads of the waiting process end with the exec, which
+	// stops it. Signals it gets meanwhile are passed on.
+	for {
+		var ws syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &ws, syscall.WALL, nil)
+		if err != nil {
+			return fmt.Errorf("waiting for %d to exec: %w", pid, err)
+		}
+		if wpid == pid && (ws.Exited() || ws.Signaled()) {
+			return fmt.Errorf("%d ended before it ran the program: %v", pid, ws)
+		}
+		if !ws.Stopped() {
+			continue
+		}
+		if wpid == pid && ws.StopSignal() == syscall.SIGTRAP && ws.TrapCause() == syscall.PTRACE_EVENT_EXEC {
+			break
+		}
+		sig := 0
+		if ws.StopSignal() != syscall.SIGTRAP && ws.StopSignal() != syscall.SIGSTOP {
+			sig = int(ws.StopSignal())
+		}
+		syscall.PtraceCont(wpid, sig)
+	}
+	d.tracees = nil
+	d.addTracee(pid, pid, pid)
+
+	exe := fmt.Sprintf("/proc/%d/exe", pid)
+	target, err := os.Readlink(exe)
+	if err != nil {
+		return fmt.Errorf("can't find the executable of %d: %v", pid, err)
+	}
+	d.target = target
+	if d.SymTable, err = d.GetSymbolTable(exe); err != nil {
+		return err
+	}
+	if err := d.relocate(pid); err != nil {
+		return err
+	}
+	if fn := d.SymTable.LookupFunc("main.main"); fn != nil {
+		d.TargetFile, _, d.Fn = d.SymTable.PCToLine(fn.Entry)
+	}
+	d.Pid = pid
+	d.stats.start = time.Now()
+	d.handleSignals()
+	defer func() {
+		if r := recover(); r != nil {
+			d.abort(fmt.Sprint("crashed: ", r))
+			panic(r)
+		}
+	}()
+	d.printf("Connected to %d (%s), stopped at its start\n", pid, target)
+
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		return err
+	}
+	return d.debugTarget(pid, pgid)
+}
@@ -0,0 +1,144 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// policyReport is what the exit policy's "notify" posts: the event the
+// policy was run for and the files it had written so far.
+type policyReport struct {
+	Event      Event  `json:"event"`
+	Target     string `json:"target"`
+	Restarts   int    `json:"restarts"`
+	Core       string `json:"core,omitempty"`
+	Transcript string `json:"transcript,omitempty"`
+}
+
+// runExitPolicy runs the -exit-policy command for ev, a fatal error the
+// target stopped at, while it is still alive, or its exit. The command
+// reads ev as JSON on its standard input, and the DDB_ variables of its
+// environment describe it too. It prints the actions to take, one per line,
+// carried out in order:
+//
+//	core <file>         write a core file of the target, at a fatal error
+//	transcript <file>   write the output of the session so far
+//	notify <url>        post a JSON report of the event and the files
+//	restart             start the target again and leave it running
+//
+// runExitPolicy reports whether the target is to be restarted.
+func (d *Debugger) runExitPolicy(pid int, ev Event) bool {
+	if d.exitPolicy == "" {
+		return false
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	in, err := json.Marshal(ev)
+	if err != nil {
+		d.printf("exit policy: %v\n", err)
+		return false
+	}
+	kind := ev.Type
+	if ev.Reason == "fatal" {
+		kind = "fatal"
+	}
+	cmd := exec.Command(cmp.Or(os.Getenv("SHELL"), "/bin/sh"), "-c", d.exitPolicy)
+	cmd.Stdin = bytes.NewReader(append(in, '\n'))
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"DDB_EVENT="+kind,
+		"DDB_PID="+strconv.Itoa(d.Pid),
+		"DDB_TARGET="+d.target,
+		"DDB_RESTARTS="+strconv.Itoa(d.policyRestarts))
+	switch {
+	case ev.Status != nil:
+		cmd.Env = append(cmd.Env, "DDB_STATUS="+strconv.Itoa(*ev.Status), "DDB_SIGNAL="+ev.Detail)
+	case kind == "fatal":
+		cmd.Env = append(cmd.Env, "DDB_FATAL="+ev.Detail)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		d.printf("exit policy: %v\n", err)
+		return false
+	}
+
+	report := policyReport{Event: ev, Target: d.target, Restarts: d.policyRestarts}
+	restart := false
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		action, arg := fields[0], strings.Join(fields[1:], " ")
+		if (arg == "") != (action == "restart") {
+			d.printf("exit policy: bad action %q\n", s.Text())
+			continue
+		}
+		switch action {
+		case "core":
+			if kind != "fatal" {
+				d.println("exit policy: the target has exited; no core to write")
+				continue
+			}
+			if err := d.WriteCore(pid, arg, syscall.SIGABRT); err != nil {
+				d.printf("exit policy: can't write a core: %v\n", err)
+				continue
+			}
+			report.Core = arg
+			d.printf("Core written to %s\n", arg)
+			d.storeArtifact(arg)
+		case "transcript":
+			if err := d.writeTranscript(arg); err != nil {
+				d.printf("exit policy: can't write the transcript: %v\n", err)
+				continue
+			}
+			report.Transcript = arg
+			d.printf("Transcript written to %s\n", arg)
+			d.storeArtifact(arg)
+		case "notify":
+			if err := postJSON(arg, report); err != nil {
+				d.printf("exit policy: can't notify %s: %v\n", arg, err)
+			}
+		case "restart":
+			if _, ok := d.current.(RestartableTarget); !ok || d.ReadOnly {
+				d.println("exit policy: only a target the debugger started can be restarted")
+				continue
+			}
+			restart = true
+		default:
+			d.printf("exit policy: unknown action %q\n", action)
+		}
+	}
+	if restart {
+		d.policyRestarts++
+		d.supervised = true
+	}
+	return restart
+}
+
+// writeTranscript writes the output the session has shown to path.
+func (d *Debugger) writeTranscript(path string) error {
+	ui, ok := d.frontend().(ScrollbackFrontend)
+	if !ok {
+		return fmt.Errorf("this frontend keeps no scrollback")
+	}
+	var b strings.Builder
+	for _, line := range ui.Scrollback() {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
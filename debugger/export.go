@@ -0,0 +1,147 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// sampleTable is the samples of some breakpoints as a table: a row per
+// sample, with a column per collected expression after the fixed ones.
+type sampleTable struct {
+	exprs []string
+	rows  []sampleRow
+}
+
+type sampleRow struct {
+	b *Breakpoint
+	s *Sample
+}
+
+// sampleColumns are the columns every exported sample has.
+var sampleColumns = []string{"breakpoint", "location", "hit", "time", "thread", "goroutine"}
+
+func newSampleTable(bps []*Breakpoint) *sampleTable {
+	t := &sampleTable{}
+	for _, b := range bps {
+		for _, expr := range b.Collect {
+			if !slices.Contains(t.exprs, expr) {
+				t.exprs = append(t.exprs, expr)
+			}
+		}
+		for i := range b.Samples {
+			t.rows = append(t.rows, sampleRow{b, &b.Samples[i]})
+		}
+	}
+	// Samples of different breakpoints interleave in time.
+	slices.SortStableFunc(t.rows, func(x, y sampleRow) int { return x.s.Time.Compare(y.s.Time) })
+	return t
+}
+
+// fixed returns the fixed columns of r, with the time as text in layout or,
+// if layout is empty, in microseconds since the epoch.
+func (r sampleRow) fixed(layout string) []string {
+	t := strconv.FormatInt(r.s.Time.UnixMicro(), 10)
+	if layout != "" {
+		t = r.s.Time.Format(layout)
+	}
+	return []string{
+		strconv.Itoa(r.b.ID), r.b.String(), strconv.Itoa(r.s.Hit), t,
+		strconv.Itoa(r.s.Tid), strconv.FormatUint(r.s.Goroutine, 10),
+	}
+}
+
+// ExportSamples writes the samples of the breakpoints ids, or of all
+// breakpoints if there are none, to path: as CSV if it ends in .csv, as
+// Parquet if it ends in .parquet. Each sample is a row, with the
+// breakpoint, hit, time, thread, goroutine and collected expressions as
+// columns; an expression a breakpoint doesn't collect is empty, or null.
+// It returns the number of rows.
+func (d *Debugger) ExportSamples(path string, ids ...int) (int, error) {
+	var bps []*Breakpoint
+	if len(ids) == 0 {
+		bps = d.ListBreakpoints()
+	}
+	for _, id := range ids {
+		b, err := d.breakpointByID(id)
+		if err != nil {
+			return 0, err
+		}
+		bps = append(bps, b)
+	}
+	t := newSampleTable(bps)
+
+	var write func(*os.File) error
+	switch filepath.Ext(path) {
+	case ".csv":
+		write = t.writeCSV
+	case ".parquet":
+		write = t.writeParquet
+	default:
+		return 0, fmt.Errorf("can't export to %s: the file must end in .csv or .parquet", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return len(t.rows), f.Close()
+}
+
+func (t *sampleTable) writeCSV(f *os.File) error {
+	w := csv.NewWriter(f)
+	w.Write(append(slices.Clone(sampleColumns), t.exprs...))
+	for _, r := range t.rows {
+		record := r.fixed(time.RFC3339Nano)
+		for _, expr := range t.exprs {
+			record = append(record, r.s.Values[expr])
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// writeParquet writes the table with the fixed columns typed and each
+// expression column as numbers if all its values are, otherwise as text.
+func (t *sampleTable) writeParquet(f *os.File) error {
+	columns := make([]pqColumn, len(sampleColumns), len(sampleColumns)+len(t.exprs))
+	for i, name := range sampleColumns {
+		columns[i] = pqColumn{name: name, typ: pqInt64, conv: -1}
+	}
+	columns[1].typ, columns[1].conv = pqByteArray, pqUTF8
+	columns[3].conv = pqTimestampMicros
+	for _, r := range t.rows {
+		for i, v := range r.fixed("") {
+			columns[i].values = append(columns[i].values, v)
+		}
+	}
+
+	for _, expr := range t.exprs {
+		c := pqColumn{name: expr, typ: pqInt64, conv: -1, optional: true}
+		for _, r := range t.rows {
+			v, ok := r.s.Values[expr]
+			c.values, c.present = append(c.values, v), append(c.present, ok)
+			if !ok {
+				continue
+			}
+			if _, err := strconv.ParseInt(v, 10, 64); err != nil && c.typ == pqInt64 {
+				c.typ = pqDouble
+			}
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				c.typ, c.conv = pqByteArray, pqUTF8
+			}
+		}
+		columns = append(columns, c)
+	}
+	return writeParquet(f, columns, len(t.rows))
+}
@@ -0,0 +1,492 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The expressions of print, display and the other commands that evaluate
+// one, a small part of Go's:
+//
+//	expr    = "*" expr | "&" expr | postfix
+//	postfix = operand { "." name | "[" expr "]" }
+//	operand = name { "." name } | number | string | "(" expr ")" |
+//	          ("len" | "cap") "(" expr ")" | "(" type ")" "(" expr ")"
+//	type    = "*" { "*" } name { "." name }
+//
+// A name is an argument, local or package level variable; a path of names
+// is a variable followed by struct fields, as in "main.cfg.Timeout".
+// Selecting a field and indexing follow pointers, as Go does. Arrays,
+// slices and strings are indexed by number and maps by a number or a
+// string, whose key has to be among the first maxMapIndexEntries. A
+// conversion to a pointer type, "(*wire.Header)(0xc000123456)", reads
+// whatever is at an address, or behind another pointer, as the type.
+
+// maxMapIndexEntries bounds the entries of a map searched for a key.
+const maxMapIndexEntries = 1 << 16
+
+// The types of the values expressions make up, such as len's.
+var (
+	exprIntType  = &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "int"}}}
+	exprByteType = &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "uint8"}}}
+)
+
+// exprOperand is the result of a part of an expression: a value of the
+// target, or a constant written in the expression.
+type exprOperand struct {
+	val   value
+	konst any // int64 or string, nil for a value
+}
+
+// exprParser evaluates an expression as it parses it.
+type exprParser struct {
+	d    *Debugger
+	pid  int
+	toks []string
+	pos  int
+}
+
+// evalValue evaluates expr at the stop of pid. Globals of package main can
+// be named without "main.".
+func (d *Debugger) evalValue(pid int, expr string) (value, error) {
+	toks, err := exprTokens(expr)
+	if err != nil {
+		return value{}, err
+	}
+	if len(toks) == 0 {
+		return value{}, fmt.Errorf("empty expression")
+	}
+	p := &exprParser{d: d, pid: pid, toks: toks}
+	op, err := p.expr()
+	if err != nil {
+		return value{}, err
+	}
+	if p.pos < len(p.toks) {
+		return value{}, fmt.Errorf("unexpected %s in %s", p.toks[p.pos], expr)
+	}
+	return p.toValue(op)
+}
+
+// exprTokens splits expr into names, numbers, quoted strings and
+// punctuation. Names may hold the slashes of import paths.
+func exprTokens(expr string) ([]string, error) {
+	var toks []string
+	isName := func(r rune) bool {
+		return r == '_' || r == '/' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.IndexByte("*&.[]()", c) >= 0:
+			toks = append(toks, expr[i:i+1])
+			i++
+		case c == '"' || c == '`':
+			s, err := strconv.QuotedPrefix(expr[i:])
+			if err != nil {
+				return nil, fmt.Errorf("bad string in %s", expr)
+			}
+			toks = append(toks, s)
+			i += len(s)
+		default:
+			j := i
+			for j < len(expr) {
+				r := rune(expr[j])
+				if r >= 0x80 {
+					r = []rune(expr[j:])[0]
+				}
+				if !isName(r) {
+					break
+				}
+				j += len(string(r))
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected %q in %s", c, expr)
+			}
+			toks = append(toks, expr[i:j])
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	if t != "" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) expect(tok string) error {
+	if t := p.next(); t != tok {
+		if t == "" {
+			t = "the end"
+		}
+		return fmt.Errorf("expected %s, found %s", tok, t)
+	}
+	return nil
+}
+
+func (p *exprParser) expr() (exprOperand, error) {
+	switch p.peek() {
+	case "*":
+		p.next()
+		op, err := p.expr()
+		if err != nil {
+			return op, err
+		}
+		v, err := p.toValue(op)
+		if err != nil {
+			return op, err
+		}
+		v, err = p.d.deref(p.pid, v)
+		return exprOperand{val: v}, err
+	case "&":
+		p.next()
+		op, err := p.expr()
+		if err != nil {
+			return op, err
+		}
+		v, err := p.toValue(op)
+		if err != nil {
+			return op, err
+		}
+		if v.addr == 0 {
+			return op, fmt.Errorf("can't take the address of a value in registers")
+		}
+		ptr := &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "*" + typeName(v.typ)}, Type: v.typ}
+		buf := binary.LittleEndian.AppendUint64(nil, v.addr)
+		return exprOperand{val: value{typ: ptr, buf: buf}}, nil
+	}
+	return p.postfix()
+}
+
+func (p *exprParser) postfix() (exprOperand, error) {
+	op, err := p.operand()
+	if err != nil {
+		return op, err
+	}
+	for {
+		switch p.peek() {
+		case ".":
+			p.next()
+			name := p.next()
+			if !isExprName(name) {
+				return op, fmt.Errorf("expected a field name after .")
+			}
+			v, err := p.toValue(op)
+			if err != nil {
+				return op, err
+			}
+			if v, err = p.d.selectPath(p.pid, v, []string{name}); err != nil {
+				return op, err
+			}
+			op = exprOperand{val: v}
+		case "[":
+			p.next()
+			index, err := p.expr()
+			if err != nil {
+				return op, err
+			}
+			if err := p.expect("]"); err != nil {
+				return op, err
+			}
+			v, err := p.toValue(op)
+			if err != nil {
+				return op, err
+			}
+			if v, err = p.d.indexValue(p.pid, v, index); err != nil {
+				return op, err
+			}
+			op = exprOperand{val: v}
+		default:
+			return op, nil
+		}
+	}
+}
+
+func (p *exprParser) operand() (exprOperand, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return exprOperand{}, fmt.Errorf("incomplete expression")
+	case tok == "(":
+		if typ, ok, err := p.castType(); err != nil || ok {
+			if err != nil {
+				return exprOperand{}, err
+			}
+			op, err := p.operand()
+			if err != nil {
+				return op, err
+			}
+			v, err := p.toValue(op)
+			if err != nil {
+				return op, err
+			}
+			v, err = p.d.convert(v, typ)
+			return exprOperand{val: v}, err
+		}
+		op, err := p.expr()
+		if err != nil {
+			return op, err
+		}
+		return op, p.expect(")")
+	case tok[0] == '"' || tok[0] == '`':
+		s, err := strconv.Unquote(tok)
+		return exprOperand{konst: s}, err
+	case tok[0] >= '0' && tok[0] <= '9':
+		n, err := strconv.ParseInt(tok, 0, 64)
+		if err != nil {
+			return exprOperand{}, fmt.Errorf("bad number %s", tok)
+		}
+		return exprOperand{konst: n}, nil
+	case (tok == "len" || tok == "cap") && p.peek() == "(":
+		p.next()
+		op, err := p.expr()
+		if err != nil {
+			return op, err
+		}
+		if err := p.expect(")"); err != nil {
+			return op, err
+		}
+		v, err := p.toValue(op)
+		if err != nil {
+			return op, err
+		}
+		n, err := p.d.lenValue(p.pid, v, tok == "cap")
+		return exprOperand{konst: n}, err
+	case !isExprName(tok):
+		return exprOperand{}, fmt.Errorf("unexpected %s", tok)
+	}
+	// The longest run of names a variable may be named with; what doesn't
+	// name the variable selects fields.
+	parts := []string{tok}
+	for p.peek() == "." && p.pos+1 < len(p.toks) && isExprName(p.toks[p.pos+1]) {
+		parts = append(parts, p.toks[p.pos+1])
+		p.pos += 2
+	}
+	v, err := p.d.pathValue(p.pid, parts)
+	return exprOperand{val: v}, err
+}
+
+func isExprName(tok string) bool {
+	return tok != "" && strings.IndexByte("*&.[]()\"`0123456789", tok[0]) < 0
+}
+
+// toValue returns the value of op, making one of a number.
+func (p *exprParser) toValue(op exprOperand) (value, error) {
+	switch k := op.konst.(type) {
+	case nil:
+		return op.val, nil
+	case int64:
+		return value{typ: exprIntType, buf: binary.LittleEndian.AppendUint64(nil, uint64(k))}, nil
+	}
+	return value{}, fmt.Errorf("a string can only index a map")
+}
+
+// pathValue evaluates a path of struct fields ("cfg.Timeout") starting at an
+// argument or local variable in scope at the stop of pid, or at a package
+// level variable.
+func (d *Debugger) pathValue(pid int, parts []string) (value, error) {
+	v, err := d.LookupVariable(d.Regs.Rip, parts[0])
+	if err == nil {
+		val, err := d.variableValue(pid, &d.Regs, v)
+		if err != nil {
+			return value{}, err
+		}
+		return d.selectPath(pid, val, parts[1:])
+	}
+
+	for i := len(parts); i > 0; i-- {
+		name := strings.Join(parts[:i], ".")
+		addr, typ, err := d.LookupGlobal(name)
+		if err != nil && i == 1 {
+			addr, typ, err = d.LookupGlobal("main." + name)
+		}
+		if err != nil {
+			continue
+		}
+		val, err := d.readValueAt(pid, addr, typ)
+		if err != nil {
+			return value{}, err
+		}
+		return d.selectPath(pid, val, parts[i:])
+	}
+	return value{}, fmt.Errorf("can't evaluate %s", strings.Join(parts, "."))
+}
+
+// stripTypedefs returns the type typ names.
+func stripTypedefs(typ dwarf.Type) dwarf.Type {
+	for {
+		t, ok := typ.(*dwarf.TypedefType)
+		if !ok {
+			return typ
+		}
+		typ = t.Type
+	}
+}
+
+// deref reads the value the pointer v points to.
+func (d *Debugger) deref(pid int, v value) (value, error) {
+	p, ok := stripTypedefs(v.typ).(*dwarf.PtrType)
+	if !ok || p.Type == nil || strings.HasPrefix(typeName(p.Type), "map<") {
+		return value{}, fmt.Errorf("can't dereference %s", typeName(v.typ))
+	}
+	addr := bufUint(v.buf, 8)
+	if addr == 0 {
+		return value{}, fmt.Errorf("nil pointer dereference")
+	}
+	return d.readValueAt(pid, addr, p.Type)
+}
+
+// indexValue returns element index of the array, slice, string or map v.
+func (d *Debugger) indexValue(pid int, v value, index exprOperand) (value, error) {
+	typ := stripTypedefs(v.typ)
+	if p, ok := typ.(*dwarf.PtrType); ok {
+		if strings.HasPrefix(typeName(p.Type), "map<") {
+			return d.mapIndex(pid, v, index)
+		}
+		// Pointers to arrays are indexed like the arrays.
+		if _, ok := stripTypedefs(p.Type).(*dwarf.ArrayType); ok {
+			var err error
+			if v, err = d.deref(pid, v); err != nil {
+				return value{}, err
+			}
+			typ = stripTypedefs(v.typ)
+		}
+	}
+
+	i, ok := index.konst.(int64)
+	if index.konst == nil {
+		n, err := d.goValue(pid, index.val)
+		if err != nil {
+			return value{}, err
+		}
+		switch n := n.(type) {
+		case int64:
+			i, ok = n, true
+		case uint64:
+			i, ok = int64(n), true
+		}
+	}
+	if !ok {
+		return value{}, fmt.Errorf("index of %s isn't an integer", typeName(v.typ))
+	}
+
+	var elem dwarf.Type
+	var data, n uint64
+	switch t := typ.(type) {
+	case *dwarf.ArrayType:
+		if i < 0 || i >= t.Count {
+			return value{}, fmt.Errorf("index %d out of range [0:%d]", i, t.Count)
+		}
+		size := t.Type.Size()
+		start, end := i*size, (i+1)*size
+		if end <= int64(len(v.buf)) {
+			sel := value{typ: t.Type, buf: v.buf[start:end]}
+			if v.addr != 0 {
+				sel.addr = v.addr + uint64(start)
+			}
+			return sel, nil
+		}
+		if v.addr == 0 {
+			return value{}, fmt.Errorf("element %d is outside the value read", i)
+		}
+		return d.readValueAt(pid, v.addr+uint64(start), t.Type)
+	case *dwarf.StructType:
+		switch {
+		case t.StructName == "string":
+			elem, data, n = exprByteType, bufUint(v.buf, 8), bufUint(v.buf[8:], 8)
+		case strings.HasPrefix(t.StructName, "[]"):
+			for _, f := range t.Field {
+				switch f.Name {
+				case "array":
+					data = bufUint(v.buf[f.ByteOffset:], 8)
+					if p, ok := f.Type.(*dwarf.PtrType); ok {
+						elem = p.Type
+					}
+				case "len":
+					n = bufUint(v.buf[f.ByteOffset:], 8)
+				}
+			}
+		}
+	}
+	if elem == nil {
+		return value{}, fmt.Errorf("can't index %s", typeName(v.typ))
+	}
+	if i < 0 || uint64(i) >= n {
+		return value{}, fmt.Errorf("index %d out of range [0:%d]", i, n)
+	}
+	return d.readValueAt(pid, data+uint64(i)*uint64(elem.Size()), elem)
+}
+
+// mapIndex returns the element of the map m under key.
+func (d *Debugger) mapIndex(pid int, m value, key exprOperand) (value, error) {
+	want := key.konst
+	if want == nil {
+		var err error
+		if want, err = d.goValue(pid, key.val); err != nil {
+			return value{}, err
+		}
+	}
+	entries, _, err := d.mapEntries(pid, m.typ, m.buf, maxMapIndexEntries)
+	if err != nil {
+		return value{}, err
+	}
+	for _, e := range entries {
+		k, err := d.goValue(pid, e.key)
+		if err == nil && fmt.Sprint(k) == fmt.Sprint(want) {
+			return e.elem, nil
+		}
+	}
+	if s, ok := want.(string); ok {
+		want = strconv.Quote(s)
+	}
+	return value{}, fmt.Errorf("no key %v in the map", want)
+}
+
+// lenValue returns the length of the string, slice, array or map v, or the
+// capacity of the slice or array.
+func (d *Debugger) lenValue(pid int, v value, capacity bool) (int64, error) {
+	switch t := stripTypedefs(v.typ).(type) {
+	case *dwarf.ArrayType:
+		return t.Count, nil
+	case *dwarf.PtrType:
+		if !capacity && strings.HasPrefix(typeName(t.Type), "map<") {
+			_, n, err := d.mapEntries(pid, v.typ, v.buf, 0)
+			return int64(n), err
+		}
+	case *dwarf.StructType:
+		field := "len"
+		if capacity {
+			field = "cap"
+		}
+		if t.StructName == "string" && !capacity || strings.HasPrefix(t.StructName, "[]") {
+			for _, f := range t.Field {
+				if f.Name == field {
+					return int64(bufUint(v.buf[f.ByteOffset:], 8)), nil
+				}
+			}
+		}
+	}
+	name := "len"
+	if capacity {
+		name = "cap"
+	}
+	return 0, fmt.Errorf("invalid argument %s for %s", typeName(v.typ), name)
+}
@@ -0,0 +1,68 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// fatalFuncs are where the runtime raises its fatal errors, with their
+// message as argument: throw for its own errors, fatal for those of the
+// program, such as concurrent map writes or a deadlock. Both end in
+// runtime.fatalthrow, which has lost the message.
+var fatalFuncs = []string{"runtime.throw", "runtime.fatal"}
+
+// planFatalTraps sets traps where the runtime raises fatal errors, so the
+// target stops before it prints the error and exits.
+func (d *Debugger) planFatalTraps(pid int) {
+	d.fatalTraps = nil
+	for _, name := range fatalFuncs {
+		fn := d.SymTable.LookupFunc(name)
+		if fn == nil {
+			continue
+		}
+		if err := d.addTrap(fn.Entry); err != nil {
+			d.printf("Can't watch for fatal errors in %s: %v\n", name, err)
+			continue
+		}
+		if d.fatalTraps == nil {
+			d.fatalTraps = make(map[uint64]bool)
+		}
+		d.fatalTraps[fn.Entry] = true
+	}
+}
+
+// fatalStop handles a hit of a trap where the runtime raises a fatal
+// error. The message is decoded and the target stops, still alive, with
+// the stacks of all goroutines to look at. A stack overflow is diagnosed.
+func (d *Debugger) fatalStop(pid int, addr uint64) (string, bool, error) {
+	if !d.fatalTraps[addr] {
+		return "", false, nil
+	}
+	if err := d.rewindTrap(pid, addr); err != nil {
+		return "", true, err
+	}
+	// The message is the string argument, in RAX and RBX.
+	hdr := make([]byte, 16)
+	binary.LittleEndian.PutUint64(hdr, d.Regs.Rax)
+	binary.LittleEndian.PutUint64(hdr[8:], d.Regs.Rbx)
+	msg, err := d.stringFromHeader(pid, hdr)
+	if err != nil {
+		msg = "<unreadable message>"
+	}
+	d.fatalMsg = msg
+	d.printf("Fatal error: %s\n", msg)
+	if strings.HasPrefix(msg, "concurrent map") {
+		// The goroutine that found the map being written is stopped;
+		// the writer is held where it writes.
+		d.stopThreads(pid)
+		d.showMapWriters(pid)
+	}
+	if msg == stackOverflowMsg {
+		if err := d.diagnoseOverflow(pid); err != nil {
+			d.printf("Can't diagnose the stack overflow: %v\n", err)
+		}
+	}
+	return "fatal", true, nil
+}
@@ -0,0 +1,146 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Frontend is the user interface of an interactive session. The engine asks
+// it for commands whenever the target stops and sends it everything it has to
+// show, so a new UI only needs to implement these methods.
+type Frontend interface {
+	// PromptCommand reads the next command at a stop or, if arg is not
+	// empty, the value of the argument arg of the command being run. It
+	// returns false at the end of input.
+	PromptCommand(arg string) (string, bool)
+	// ShowStop shows where the target stopped.
+	ShowStop(stop Event)
+	// ShowOutput shows text the engine prints, such as stacks and command
+	// results.
+	ShowOutput(text string)
+	// Confirm asks a yes or no question.
+	Confirm(question string) bool
+}
+
+// commandPrompt is the prompt of a stopped target. help lists the
+// commands it takes.
+const commandPrompt = "\n(ddb) > "
+
+// LineFrontend is the line-oriented prompt: commands are typed and ended
+// with Enter.
+type LineFrontend struct {
+	in          *bufio.Scanner
+	out         io.Writer
+	interactive bool
+	echo        bool
+	queued      []string
+	// editor reads the lines typed at a terminal, with editing and history.
+	editor *lineEditor
+	// scrollback is the output shown, at most maxScrollback lines, and
+	// partial the start of a line not yet ended.
+	scrollback []string
+	partial    string
+}
+
+// NewLineFrontend returns a line prompt reading from in and writing to out.
+// When in isn't a terminal, e.g. a piped script, questions are answered yes.
+func NewLineFrontend(in *os.File, out io.Writer) *LineFrontend {
+	f := &LineFrontend{
+		in:          bufio.NewScanner(in),
+		out:         out,
+		interactive: IsTerminal(int(in.Fd())),
+	}
+	if f.interactive {
+		f.editor = newLineEditor(in, out)
+	}
+	return f
+}
+
+// NewScriptFrontend returns a line prompt reading the commands of a script,
+// such as a command file, from in. Each command is echoed after its prompt,
+// so the output reads like a typed session, and questions are answered yes.
+func NewScriptFrontend(in io.Reader, out io.Writer) *LineFrontend {
+	return &LineFrontend{in: bufio.NewScanner(in), out: out, echo: true}
+}
+
+// Queue makes cmds the next commands, answered before any are read. They
+// are echoed like a script's.
+func (f *LineFrontend) Queue(cmds ...string) {
+	f.queued = append(f.queued, cmds...)
+}
+
+func (f *LineFrontend) PromptCommand(arg string) (string, bool) {
+	prompt := commandPrompt
+	if arg != "" {
+		prompt = fmt.Sprintf("  Enter %s: > ", arg)
+	}
+	if len(f.queued) > 0 {
+		cmd := f.queued[0]
+		f.queued = f.queued[1:]
+		fmt.Fprint(f.out, prompt)
+		fmt.Fprintln(f.out, cmd)
+		return cmd, true
+	}
+	return f.readLine(prompt, arg == "")
+}
+
+// readLine shows prompt and reads a line: through the editor on a
+// terminal, where commands are kept in the history, and echoed after the
+// prompt for a script.
+func (f *LineFrontend) readLine(prompt string, command bool) (string, bool) {
+	if f.editor != nil {
+		return f.editor.readLine(prompt, command)
+	}
+	fmt.Fprint(f.out, prompt)
+	if !f.in.Scan() {
+		return "", false
+	}
+	if f.echo {
+		fmt.Fprintln(f.out, f.in.Text())
+	}
+	return f.in.Text(), true
+}
+
+func (f *LineFrontend) ShowStop(stop Event) {
+	text := fmt.Sprintf("Stopped at %s\n", stop.where())
+	f.record(text)
+	io.WriteString(f.out, text)
+}
+
+func (f *LineFrontend) ShowOutput(text string) {
+	f.record(text)
+	io.WriteString(f.out, text)
+}
+
+func (f *LineFrontend) Confirm(question string) bool {
+	if !f.interactive {
+		return true
+	}
+	for {
+		answer, ok := f.readLine(question+" (y or n) ", false)
+		if !ok {
+			return true
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+	}
+}
+
+// printf shows formatted output through the frontend.
+func (d *Debugger) printf(format string, args ...any) {
+	d.UI.ShowOutput(fmt.Sprintf(format, args...))
+}
+
+// println shows a line of output through the frontend.
+func (d *Debugger) println(args ...any) {
+	d.UI.ShowOutput(fmt.Sprintln(args...))
+}
@@ -0,0 +1,221 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// The pc-value tables and function data of the Go line table, by their
+// index in a function's entry (runtime/internal/abi).
+const (
+	pcdataUnsafePoint      = 0
+	funcdataLocalsPointers = 1
+)
+
+// The values of the pcdataUnsafePoint table: safe, unsafe, and the
+// restartable sequences the runtime backs out of, which are unsafe where
+// they are.
+const (
+	unsafePointSafe   = -1
+	unsafePointUnsafe = -2
+)
+
+// funcFlagAsm marks the functions written in assembly, from Go 1.21.
+const funcFlagAsm = 1 << 2
+
+// pclnFuncs reads what the Go line table says of each function beyond its
+// name and lines, which debug/gosym leaves out: its flags, pc-value tables
+// and function data. It knows the layouts of Go 1.16 (0xfffffffa), 1.18
+// (0xfffffff0) and 1.20 on (0xfffffff1); tables built from DWARF have none
+// of it.
+type pclnFuncs struct {
+	tab     []byte
+	version uint32
+	minLC   uint64 // the instruction size quantum of pc deltas
+	ptrSize int
+	nfunc   int
+	text    uint64 // the address entry offsets count from, from 1.18
+	pctab   []byte
+	funcs   []byte // the function table, followed by the entries
+}
+
+// pclnFunc is the entry of a function in the line table.
+type pclnFunc struct {
+	entry     uint64
+	flag      uint8
+	pcdata    []byte // the offsets of the pc-value tables in pctab
+	nfuncdata int
+	funcdata  []byte // the offsets of the data, or pointers before 1.18
+}
+
+// newPclnFuncs reads the header of the line table tab, whose entries from
+// 1.18 count from text, or returns nil for a layout it doesn't know. The
+// start of the text in the header may be left unrelocated, as debug/gosym
+// notes.
+func newPclnFuncs(tab []byte, text uint64) *pclnFuncs {
+	if len(tab) < 8 {
+		return nil
+	}
+	p := &pclnFuncs{
+		tab:     tab,
+		version: binary.LittleEndian.Uint32(tab),
+		minLC:   uint64(tab[6]),
+		ptrSize: int(tab[7]),
+	}
+	if p.ptrSize != 4 && p.ptrSize != 8 || p.minLC == 0 {
+		return nil
+	}
+	word := func(i int) uint64 {
+		off := 8 + i*p.ptrSize
+		if off+p.ptrSize > len(tab) {
+			return 0
+		}
+		if p.ptrSize == 4 {
+			return uint64(binary.LittleEndian.Uint32(tab[off:]))
+		}
+		return binary.LittleEndian.Uint64(tab[off:])
+	}
+	var pctab, funcs uint64
+	switch p.version {
+	case 0xfffffffa:
+		pctab, funcs = word(5), word(6)
+	case 0xfffffff0, 0xfffffff1:
+		p.text, pctab, funcs = text, word(6), word(7)
+	default:
+		return nil
+	}
+	p.nfunc = int(word(0))
+	if pctab > uint64(len(tab)) || funcs > uint64(len(tab)) {
+		return nil
+	}
+	p.pctab, p.funcs = tab[pctab:], tab[funcs:]
+	return p
+}
+
+// functabEntry returns the entry and the offset of the i-th function of the
+// function table.
+func (p *pclnFuncs) functabEntry(i int) (entry, off uint64, ok bool) {
+	if p.version == 0xfffffffa {
+		at := 2 * i * p.ptrSize
+		if at+2*p.ptrSize > len(p.funcs) {
+			return 0, 0, false
+		}
+		if p.ptrSize == 4 {
+			return uint64(binary.LittleEndian.Uint32(p.funcs[at:])), uint64(binary.LittleEndian.Uint32(p.funcs[at+4:])), true
+		}
+		return binary.LittleEndian.Uint64(p.funcs[at:]), binary.LittleEndian.Uint64(p.funcs[at+8:]), true
+	}
+	at := 8 * i
+	if at+8 > len(p.funcs) {
+		return 0, 0, false
+	}
+	return p.text + uint64(binary.LittleEndian.Uint32(p.funcs[at:])), uint64(binary.LittleEndian.Uint32(p.funcs[at+4:])), true
+}
+
+// find returns the entry of the function at pc, an address the binary is
+// linked at.
+func (p *pclnFuncs) find(pc uint64) (pclnFunc, bool) {
+	i := sort.Search(p.nfunc, func(i int) bool {
+		entry, _, ok := p.functabEntry(i)
+		return !ok || entry > pc
+	})
+	if i == 0 {
+		return pclnFunc{}, false
+	}
+	_, off, ok := p.functabEntry(i - 1)
+	if !ok || off >= uint64(len(p.funcs)) {
+		return pclnFunc{}, false
+	}
+	b := p.funcs[off:]
+
+	// The entry is followed by 4-byte fields, then funcID, flag, a pad
+	// byte and nfuncdata.
+	var f pclnFunc
+	fixed := 4
+	if p.version == 0xfffffffa {
+		fixed = p.ptrSize
+	}
+	size := fixed + 8*4 // up to cuOffset
+	if p.version == 0xfffffff1 {
+		size += 4 // startLine
+	}
+	if len(b) < size+4 {
+		return pclnFunc{}, false
+	}
+	if p.version == 0xfffffffa {
+		if f.entry = binary.LittleEndian.Uint64(b); p.ptrSize == 4 {
+			f.entry = uint64(binary.LittleEndian.Uint32(b))
+		}
+	} else {
+		f.entry = p.text + uint64(binary.LittleEndian.Uint32(b))
+	}
+	npcdata := int(binary.LittleEndian.Uint32(b[fixed+6*4:]))
+	f.flag, f.nfuncdata = b[size+1], int(b[size+3])
+	b = b[size+4:]
+	if len(b) < 4*npcdata {
+		return pclnFunc{}, false
+	}
+	f.pcdata, f.funcdata = b[:4*npcdata], b[4*npcdata:]
+	// Before 1.18 the function data are pointers, aligned.
+	if p.version == 0xfffffffa && (size+4+4*npcdata)%p.ptrSize != 0 && len(f.funcdata) >= 4 {
+		f.funcdata = f.funcdata[4:]
+	}
+	return f, true
+}
+
+// pcdata returns the value of the pc-value table table of f at pc, -1 when
+// f has no such table, as the runtime takes it.
+func (p *pclnFuncs) pcdata(f pclnFunc, table int, pc uint64) int32 {
+	if table >= len(f.pcdata)/4 {
+		return -1
+	}
+	off := binary.LittleEndian.Uint32(f.pcdata[4*table:])
+	if off == 0 || uint64(off) >= uint64(len(p.pctab)) {
+		return -1
+	}
+	b := p.pctab[off:]
+	val, at := int32(-1), f.entry
+	for first := true; ; first = false {
+		uv, n := binary.Uvarint(b)
+		if n <= 0 || uv == 0 && !first {
+			return -1
+		}
+		b = b[n:]
+		if uv&1 != 0 {
+			val += int32(-(uv >> 1) - 1)
+		} else {
+			val += int32(uv >> 1)
+		}
+		delta, n := binary.Uvarint(b)
+		if n <= 0 {
+			return -1
+		}
+		b = b[n:]
+		at += delta * p.minLC
+		if pc < at {
+			return val
+		}
+	}
+}
+
+// hasFuncdata reports whether f has the function data index.
+func (p *pclnFuncs) hasFuncdata(f pclnFunc, index int) bool {
+	if index >= f.nfuncdata {
+		return false
+	}
+	if p.version == 0xfffffffa {
+		b := f.funcdata
+		at := index * p.ptrSize
+		if at+p.ptrSize > len(b) {
+			return false
+		}
+		if p.ptrSize == 4 {
+			return binary.LittleEndian.Uint32(b[at:]) != 0
+		}
+		return binary.LittleEndian.Uint64(b[at:]) != 0
+	}
+	at := 4 * index
+	return at+4 <= len(f.funcdata) && binary.LittleEndian.Uint32(f.funcdata[at:]) != ^uint32(0)
+}
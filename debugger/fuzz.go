@@ -0,0 +1,46 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DebugFuzz implements "fuzz <package> -run <fuzz test>/<entry> [test
+// flags]": it runs one input of the seed corpus of a fuzz test, such as a
+// crasher the fuzzer wrote to testdata/fuzz, under the debugger, with a
+// catchpoint on panics. The entries added with f.Add are named seed#0,
+// seed#1 and so on.
+func DebugFuzz(args []string) int {
+	const usage = "usage: fuzz <package> -run <fuzz test>/<entry> [test flags]"
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Println(usage)
+		return 2
+	}
+	pkg := args[0]
+	buildFlags, testArgs := testFlags(args[1:])
+	fuzzTest, entry, ok := strings.Cut(flagValue(testArgs, "-test.run"), "/")
+	fuzzTest = strings.TrimPrefix(fuzzTest, "^")
+	entry = strings.TrimSuffix(entry, "$")
+	if !ok || fuzzTest == "" || entry == "" {
+		fmt.Println(usage)
+		return 2
+	}
+	if !strings.HasPrefix(entry, "seed#") {
+		dir, err := packageDir(pkg, buildFlags)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		// Only the corpus in testdata runs without -fuzz.
+		file := filepath.Join(dir, "testdata", "fuzz", fuzzTest, entry)
+		if _, err := os.Stat(file); err != nil {
+			fmt.Printf("No corpus entry %s\n", file)
+			return 1
+		}
+	}
+	return debugTestBinary(pkg, buildFlags, testArgs, []string{"catch panic"})
+}
@@ -0,0 +1,66 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WatchGlobal watches the package level variable name, such as
+// "main.debugMode" or "example.com/app/config.Verbose", for writes, and
+// shows the stack of the goroutine that changes it at each stop. The
+// address comes from the symbol table, and the type from the DWARF data
+// when there is some; without it 8 bytes are watched and shown as bytes.
+// A name without a package is looked up in main.
+func (d *Debugger) WatchGlobal(pid int, name string) (*DataWatch, error) {
+	if d.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if !strings.Contains(name, ".") {
+		name = "main." + name
+	}
+	addr, ok := d.Symbols[name]
+	gaddr, typ, err := d.LookupGlobal(name)
+	switch {
+	case !ok && err != nil:
+		return nil, fmt.Errorf("no global %s in the symbol table", name)
+	case !ok:
+		addr = gaddr
+	case err == nil && gaddr != addr:
+		// The symbol is what the linker placed; a type for another address
+		// would misread it.
+		typ = nil
+	}
+
+	size := int64(8)
+	if typ != nil {
+		size = typ.Size()
+	}
+	w := &DataWatch{Expr: name, Addr: addr, Size: size, Stacks: true, typ: typ, old: make([]byte, size)}
+	if err := d.ReadMemory(pid, addr, w.old); err != nil {
+		return nil, err
+	}
+	if err := d.armDataWatch(pid, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// watchGlobalCommand handles "watch-global <pkg.Var>", which stops the
+// target whenever it changes a package level variable, such as a feature
+// flag, and shows who changed it.
+func (d *Debugger) watchGlobalCommand(pid int, args []string) bool {
+	if len(args) != 1 {
+		d.println("usage: watch-global <pkg.Var>")
+		return false
+	}
+	w, err := d.WatchGlobal(pid, args[0])
+	d.audit("", "watchpoint", pid, args[0], err)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	d.printf("Watchpoint %d: %s\n", w.ID, w)
+	return false
+}
@@ -0,0 +1,463 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Goroutine is a goroutine of the target, read from the runtime's list of
+// all goroutines.
+type Goroutine struct {
+	ID     uint64
+	Status string
+	// PC and SP are where the goroutine runs or was switched out. For a
+	// goroutine running on a thread other than the stopped one they are
+	// stale and Running is set.
+	PC, SP  uint64
+	Running bool
+	// Current is set for the goroutine of the stopped thread.
+	Current bool
+	// WaitReason is what a waiting goroutine waits for, as in the
+	// runtime's tracebacks: "chan receive", "sync.Mutex.Lock", ...
+	WaitReason string
+	// stackLo and stackHi bound the goroutine's stack.
+	stackLo, stackHi uint64
+}
+
+// goroutineStatuses names the runtime's goroutine states, _Gidle to
+// _Gpreempted.
+var goroutineStatuses = []string{"idle", "runnable", "running", "syscall", "waiting", "moribund", "dead", "enqueue", "copystack", "preempted"}
+
+const (
+	gRunning = 2
+	gWaiting = 4
+	gDead    = 6
+	// gScan is set in the status while the garbage collector scans the
+	// goroutine's stack.
+	gScan = 0x1000
+)
+
+// Goroutines lists the goroutines of the target, stopped at pid, that
+// haven't exited. They are found through runtime.allgs, whose runtime.g
+// fields are located with the target's DWARF information. Those running on
+// the threads stopped with pid, held or with an event to handle, are
+// located by their registers.
+func (d *Debugger) Goroutines(pid int) ([]Goroutine, error) {
+	stopped := map[uint64]*syscall.PtraceRegs{d.currentG(pid, &d.Regs): &d.Regs}
+	if own := d.tracees[pid]; own != nil {
+		for tid, t := range d.tracees {
+			if !t.held && !t.stopPending || t.proc != own.proc {
+				continue
+			}
+			regs := new(syscall.PtraceRegs)
+			if d.proc().GetRegs(tid, regs) != nil {
+				continue
+			}
+			if g := d.currentG(tid, regs); stopped[g] == nil {
+				stopped[g] = regs
+			}
+		}
+	}
+	return d.goroutines(pid, stopped)
+}
+
+// goroutines lists the goroutines like Goroutines. Those running on the
+// stopped threads whose registers are in stopped, keyed by their g,
+// are located by them; the one in d.Regs is the current one.
+func (d *Debugger) goroutines(pid int, stopped map[uint64]*syscall.PtraceRegs) ([]Goroutine, error) {
+	if err := d.runtimeReady(pid); err != nil {
+		return nil, err
+	}
+	addr, typ, err := d.LookupGlobal("runtime.allgs")
+	if err != nil {
+		return nil, fmt.Errorf("can't find the goroutines: %v", err)
+	}
+	allgs, err := d.readValueAt(pid, addr, typ)
+	if err != nil {
+		return nil, err
+	}
+	gType, err := allgsElem(typ)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := goroutineLayout(gType)
+	if err != nil {
+		return nil, err
+	}
+
+	array, n := bufUint(allgs.buf, 8), bufUint(allgs.buf[8:], 8)
+	ptrs := make([]byte, min(n, maxGoroutines)*8)
+	if err := d.ReadMemory(pid, array, ptrs); err != nil {
+		return nil, err
+	}
+	var gs []Goroutine
+	for i := 0; i < len(ptrs); i += 8 {
+		gaddr := bufUint(ptrs[i:], 8)
+		val, err := d.readValueAt(pid, gaddr, gType)
+		if err != nil {
+			return nil, err
+		}
+		status := bufUint(val.buf[layout.status:], 4) &^ gScan
+		if status == gDead {
+			continue
+		}
+		regs := stopped[gaddr]
+		g := Goroutine{
+			ID:      bufUint(val.buf[layout.goid:], 8),
+			Status:  strconv.FormatUint(status, 10),
+			PC:      bufUint(val.buf[layout.pc:], 8),
+			SP:      bufUint(val.buf[layout.sp:], 8),
+			Current: regs == &d.Regs,
+			stackLo: bufUint(val.buf[layout.stackLo:], 8),
+			stackHi: bufUint(val.buf[layout.stackHi:], 8),
+		}
+		if status < uint64(len(goroutineStatuses)) {
+			g.Status = goroutineStatuses[status]
+		}
+		if status == gWaiting && layout.waitreason >= 0 {
+			g.WaitReason = d.waitReason(pid, val.buf[layout.waitreason])
+		}
+		if regs != nil {
+			g.PC, g.SP = regs.Rip, regs.Rsp
+		} else {
+			g.Running = status == gRunning
+		}
+		gs = append(gs, g)
+	}
+	return gs, nil
+}
+
+// waitReason names the runtime's wait reason n from the target's
+// runtime.waitReasonStrings, which are read once.
+func (d *Debugger) waitReason(pid int, n byte) string {
+	if d.waitReasons == nil {
+		d.waitReasons = []string{}
+		if addr, typ, err := d.LookupGlobal("runtime.waitReasonStrings"); err == nil {
+			if t, ok := typ.(*dwarf.ArrayType); ok && t.Type.Size() == 16 {
+				if val, err := d.readValueAt(pid, addr, typ); err == nil {
+					for i := 0; i+16 <= len(val.buf); i += 16 {
+						s, _ := d.stringFromHeader(pid, val.buf[i:])
+						d.waitReasons = append(d.waitReasons, s)
+					}
+				}
+			}
+		}
+	}
+	if int(n) < len(d.waitReasons) && d.waitReasons[n] != "" {
+		return d.waitReasons[n]
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("wait reason %d", n)
+}
+
+// runtimeReady returns ErrRuntimeNotReady until the runtime of the target
+// has created its first goroutine: before, there are none to list and R14
+// doesn't hold one. Once it has, it stays so for the process. A target
+// whose runtime.allglen can't be found is taken as ready, and lookups fail
+// on their own.
+func (d *Debugger) runtimeReady(pid int) error {
+	if d.runtimeUp {
+		return nil
+	}
+	addr, _, err := d.LookupGlobal("runtime.allglen")
+	if err != nil {
+		return nil
+	}
+	if n, err := d.readUint(pid, addr, 8); err != nil || n == 0 {
+		return ErrRuntimeNotReady
+	}
+	d.runtimeUp = true
+	return nil
+}
+
+// allgsElem returns the type runtime.g from the type of runtime.allgs,
+// []*runtime.g.
+func allgsElem(typ dwarf.Type) (dwarf.Type, error) {
+	if t, ok := typ.(*dwarf.StructType); ok {
+		for _, f := range t.Field {
+			if f.Name != "array" {
+				continue
+			}
+			if p, ok := f.Type.(*dwarf.PtrType); ok {
+				if p, ok := p.Type.(*dwarf.PtrType); ok {
+					return p.Type, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("runtime.allgs has unexpected type %s", typ)
+}
+
+// gLayout holds the offsets of the fields of runtime.g that are read.
+// waitreason is -1 if the runtime has no such field.
+type gLayout struct {
+	goid, status, pc, sp, stackLo, stackHi, waitreason int64
+}
+
+func goroutineLayout(g dwarf.Type) (gLayout, error) {
+	var l gLayout
+	for _, f := range []struct {
+		off  *int64
+		path []string
+	}{
+		{&l.goid, []string{"goid"}},
+		{&l.pc, []string{"sched", "pc"}},
+		{&l.sp, []string{"sched", "sp"}},
+		{&l.stackLo, []string{"stack", "lo"}},
+		{&l.stackHi, []string{"stack", "hi"}},
+	} {
+		off, err := fieldOffset(g, f.path)
+		if err != nil {
+			return l, err
+		}
+		*f.off = off
+	}
+	var err error
+	if l.waitreason, err = fieldOffset(g, []string{"waitreason"}); err != nil {
+		l.waitreason = -1
+	}
+	// The status became an atomic.Uint32 in Go 1.20.
+	if l.status, err = fieldOffset(g, []string{"atomicstatus", "value"}); err != nil {
+		l.status, err = fieldOffset(g, []string{"atomicstatus"})
+	}
+	return l, err
+}
+
+// fieldOffset returns the offset of the nested struct field path in typ.
+func fieldOffset(typ dwarf.Type, path []string) (int64, error) {
+	var off int64
+	for _, name := range path {
+		for {
+			t, ok := typ.(*dwarf.TypedefType)
+			if !ok {
+				break
+			}
+			typ = t.Type
+		}
+		t, ok := typ.(*dwarf.StructType)
+		if !ok {
+			return 0, fmt.Errorf("%s is not a struct", typ)
+		}
+		var found *dwarf.StructField
+		for _, f := range t.Field {
+			if f.Name == name {
+				found = f
+				break
+			}
+		}
+		if found == nil {
+			return 0, fmt.Errorf("%s has no field %s", t.StructName, name)
+		}
+		off += found.ByteOffset
+		typ = found.Type
+	}
+	return off, nil
+}
+
+// GoroutineStack returns at most depth frames of g, innermost first. The
+// stack is unwound with the frame descriptions of .debug_frame, which,
+// unlike the frame pointer chain, are right at every instruction.
+func (d *Debugger) GoroutineStack(pid int, g Goroutine, depth int) ([]Frame, error) {
+	if g.Running {
+		return nil, fmt.Errorf("goroutine %d is running on another thread", g.ID)
+	}
+	frames, _, err := d.unwind(pid, syscall.PtraceRegs{Rip: g.PC, Rsp: g.SP}, depth)
+	for i := range frames {
+		frames[i].Goroutine = g.ID
+	}
+	return frames, err
+}
+
+// unwind returns at most depth frames of the stack whose innermost frame
+// runs with regs, with the registers of each frame: all of regs for the
+// innermost, the PC and stack pointer for its callers, which is enough to
+// locate their variables on the stack.
+func (d *Debugger) unwind(pid int, regs syscall.PtraceRegs, depth int) ([]Frame, []syscall.PtraceRegs, error) {
+	read := func(addr uint64) (uint64, error) {
+		return d.readUint(pid, addr, 8)
+	}
+	return d.unwindWith(regs, depth, read, d.frameAt)
+}
+
+// unwindWith is unwind reading return addresses with read and describing
+// the frames with frame.
+func (d *Debugger) unwindWith(regs syscall.PtraceRegs, depth int, read func(addr uint64) (uint64, error), frame func(pc uint64, ret bool) Frame) ([]Frame, []syscall.PtraceRegs, error) {
+	var frames []Frame
+	var frameRegs []syscall.PtraceRegs
+	for len(frames) < depth {
+		frames = append(frames, frame(regs.Rip, len(frames) > 0))
+		frames[len(frames)-1].SP = regs.Rsp
+		frameRegs = append(frameRegs, regs)
+		fn := d.pcToFunc(regs.Rip)
+		if fn == nil || fn.Name == "runtime.goexit" {
+			break
+		}
+		cfa, err := d.cfa(regs.Rip, &regs)
+		if err != nil {
+			return frames, frameRegs, err
+		}
+		// A frame with more than the return address keeps its caller's
+		// frame pointer under it, where its own points.
+		if cfa-regs.Rsp > 8 {
+			frames[len(frames)-1].FP = cfa - 16
+		}
+		ret, err := read(cfa - 8)
+		if err != nil {
+			return frames, frameRegs, err
+		}
+		if ret == 0 {
+			break
+		}
+		regs = syscall.PtraceRegs{Rip: ret, Rsp: cfa}
+	}
+	return frames, frameRegs, nil
+}
+
+// maxGoroutines bounds the goroutines listed, far above what servers run.
+const maxGoroutines = 1 << 20
+
+// maxGoroutineFrames bounds the backtraces of the goroutine command.
+const maxGoroutineFrames = 64
+
+// state is the status of g with what it waits for, if known.
+func (g Goroutine) state() string {
+	if g.WaitReason == "" {
+		return g.Status
+	}
+	return fmt.Sprintf("%s (%s)", g.Status, g.WaitReason)
+}
+
+// userFrame returns the innermost frame of g outside the runtime, which
+// tells more of a parked goroutine than the runtime function parking it.
+func (d *Debugger) userFrame(pid int, g Goroutine) Frame {
+	frames, _ := d.GoroutineStack(pid, g, maxGoroutineFrames)
+	return d.userFrameOf(g, frames)
+}
+
+// userFrameOf is userFrame with the stack of g already walked.
+func (d *Debugger) userFrameOf(g Goroutine, frames []Frame) Frame {
+	for _, f := range frames {
+		if !isRuntimeFunc(f.Func) {
+			return f
+		}
+	}
+	return d.frameAt(g.PC, false)
+}
+
+// goroutinesCommand handles "goroutines", which lists the goroutines of the
+// target with their status and where they are in code outside the runtime,
+// marking the stopped one, and "goroutines -folded", which prints their
+// stacks as folded stacks instead.
+func (d *Debugger) goroutinesCommand(pid int, args []string) bool {
+	folded := len(args) == 1 && args[0] == "-folded"
+	if len(args) > 0 && !folded {
+		d.println("usage: goroutines [-folded]")
+		return false
+	}
+	gs, err := d.Goroutines(pid)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	if folded {
+		d.UI.ShowOutput(d.foldedGoroutines(pid, gs))
+		return false
+	}
+	stacks, _ := d.GoroutineStacks(pid, gs, maxGoroutineFrames)
+	for i, g := range gs {
+		mark := " "
+		if g.Current {
+			mark = "*"
+		}
+		if g.Running {
+			d.printf("%s Goroutine %d %s on another thread\n", mark, g.ID, g.Status)
+			continue
+		}
+		f := d.userFrameOf(g, stacks[i])
+		d.printf("%s Goroutine %d %s at %s\n", mark, g.ID, g.state(), f)
+	}
+	return false
+}
+
+// foldedGoroutines renders the stacks of gs in the folded format of flame
+// graph tools: a line per distinct stack, with the number of goroutines
+// that have it, most common first. The stacks of goroutines running on
+// other threads can't be walked, so they are counted as "[running]".
+func (d *Debugger) foldedGoroutines(pid int, gs []Goroutine) string {
+	counts := make(map[string]int)
+	walked, _ := d.GoroutineStacks(pid, gs, maxGoroutineFrames)
+	for i, g := range gs {
+		var frames []Frame
+		switch {
+		case g.Current:
+			frames, _ = d.Stacktrace(pid, &d.Regs, maxGoroutineFrames)
+		case !g.Running:
+			frames = walked[i]
+		}
+		stack := "[running]"
+		if len(frames) > 0 {
+			stack = foldedStack(frames)
+		}
+		counts[stack]++
+	}
+	stacks := make([]string, 0, len(counts))
+	for s := range counts {
+		stacks = append(stacks, s)
+	}
+	sort.Slice(stacks, func(i, j int) bool {
+		if counts[stacks[i]] != counts[stacks[j]] {
+			return counts[stacks[i]] > counts[stacks[j]]
+		}
+		return stacks[i] < stacks[j]
+	})
+	var b strings.Builder
+	for _, s := range stacks {
+		fmt.Fprintf(&b, "%s %d\n", s, counts[s])
+	}
+	return b.String()
+}
+
+// goroutineCommand handles "goroutine <id>", which prints the backtrace of
+// a goroutine.
+func (d *Debugger) goroutineCommand(pid int, args []string) bool {
+	if len(args) != 1 {
+		d.println("usage: goroutine <id>")
+		return false
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		d.printf("bad goroutine id %q\n", args[0])
+		return false
+	}
+	gs, err := d.Goroutines(pid)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	for _, g := range gs {
+		if g.ID != id {
+			continue
+		}
+		frames, err := d.GoroutineStack(pid, g, maxGoroutineFrames)
+		var b strings.Builder
+		fmt.Fprintf(&b, "Goroutine %d %s:\n", g.ID, g.Status)
+		for i, f := range frames {
+			fmt.Fprintf(&b, "  #%d %s\n", i, f)
+		}
+		d.UI.ShowOutput(b.String())
+		if err != nil {
+			d.println(" ", err)
+		}
+		return false
+	}
+	d.printf("No goroutine %d\n", id)
+	return false
+}
@@ -0,0 +1,178 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DebugTest implements "test <package> [test flags]": it builds the test
+// binary of the package without optimizations, and debugs it in the
+// package's directory, as go test runs it, with the flags as go test would
+// pass them. A -run pattern that names one test function gets a breakpoint
+// at it.
+func DebugTest(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Println("usage: test <package> [test flags]")
+		return 2
+	}
+	buildFlags, testArgs := testFlags(args[1:])
+	var ex []string
+	if fn := singleFunc(flagValue(testArgs, "-test.run")); fn != "" {
+		ex = append(ex, "break "+fn)
+	}
+	return debugTestBinary(args[0], buildFlags, testArgs, ex)
+}
+
+// debugTestBinary builds the test binary of pkg with the go build flags
+// buildFlags and debugs it in the package's directory with the flags
+// testArgs, running the prompt commands ex first.
+func debugTestBinary(pkg string, buildFlags, testArgs, ex []string) int {
+	pkgDir, err := packageDir(pkg, buildFlags)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	dir, err := os.MkdirTemp("", "dedebugger-test")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, filepath.Base(pkgDir)+".test")
+	if err := goTestBuild(pkg, bin, buildFlags); err != nil {
+		fmt.Print(err)
+		return 1
+	}
+	if _, err := os.Stat(bin); err != nil {
+		// go test -c writes nothing for a package without tests.
+		fmt.Printf("%s has no tests\n", pkg)
+		return 1
+	}
+
+	cmdArgs := []string{"-dir", pkgDir}
+	for _, cmd := range ex {
+		cmdArgs = append(cmdArgs, "-ex", cmd)
+	}
+	cmdArgs = append(cmdArgs, bin, "--")
+	child := exec.Command(self, append(cmdArgs, testArgs...)...)
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// Ctrl-C is for the debugger, which stops the target with it.
+	signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+	if err := child.Run(); err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			return exit.ExitCode()
+		}
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// packageDir returns the directory of the sources of pkg, built with the
+// go build flags buildFlags.
+func packageDir(pkg string, buildFlags []string) (string, error) {
+	args := append([]string{"list", "-f", "{{.Dir}}"}, buildFlags...)
+	out, err := exec.Command("go", append(args, pkg)...).Output()
+	if err != nil {
+		return "", commandError(err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// goTestBuild builds the test binary of pkg to out without optimizations or
+// inlining, as goBuild does, with the go build flags flags.
+func goTestBuild(pkg, out string, flags []string) error {
+	args := append([]string{"test", "-c", "-gcflags=all=-N -l", "-o", out}, flags...)
+	b, err := exec.Command("go", append(args, pkg)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s", b)
+	}
+	return nil
+}
+
+// commandError renders the error of a command run for its output, with
+// what it printed on its standard error.
+func commandError(err error) error {
+	if exit, ok := err.(*exec.ExitError); ok && len(exit.Stderr) > 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(string(exit.Stderr)))
+	}
+	return err
+}
+
+// goBuildFlags are the flags go test passes on to the build, each with
+// whether it takes a value.
+var goBuildFlags = map[string]bool{
+	"a": false, "asan": false, "buildvcs": false, "cover": false, "linkshared": false,
+	"msan": false, "race": false, "trimpath": false, "work": false, "x": false,
+	"asmflags": true, "buildmode": true, "compiler": true,
+	"covermode": true, "coverpkg": true, "gccgoflags": true, "gcflags": true,
+	"installsuffix": true, "ldflags": true, "mod": true, "modfile": true,
+	"overlay": true, "p": true, "pgo": true, "tags": true, "toolexec": true,
+}
+
+// testFlags splits go test flags into those of the build, such as "-race"
+// or "-tags integration", passed to go test -c, and those of the test
+// binary: "-run TestFoo" or "-v" become "-test.run TestFoo" or "-test.v".
+// Values and the arguments after -args are passed unchanged.
+func testFlags(args []string) (build, test []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-args" || arg == "--args" {
+			return build, append(test, args[i+1:]...)
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg || strings.HasPrefix(name, "test.") {
+			test = append(test, arg)
+			continue
+		}
+		flagName, _, hasValue := strings.Cut(name, "=")
+		if takesValue, ok := goBuildFlags[flagName]; ok {
+			build = append(build, arg)
+			if takesValue && !hasValue && i+1 < len(args) {
+				i++
+				build = append(build, args[i])
+			}
+			continue
+		}
+		test = append(test, "-test."+name)
+	}
+	return build, test
+}
+
+// flagValue returns the value of the flag name among the test binary's
+// flags, given as "name=value" or "name value".
+func flagValue(flags []string, name string) string {
+	var v string
+	for i, f := range flags {
+		if val, ok := strings.CutPrefix(f, name+"="); ok {
+			v = val
+		} else if f == name && i+1 < len(flags) {
+			v = flags[i+1]
+		}
+	}
+	return v
+}
+
+// singleFunc returns the test or benchmark function the -test.run or
+// -test.bench pattern names, or "" if it doesn't name a single one.
+// Subtests break at their parent.
+func singleFunc(pattern string) string {
+	pattern, _, _ = strings.Cut(pattern, "/")
+	pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "^"), "$")
+	if pattern == "" || regexp.QuoteMeta(pattern) != pattern {
+		return ""
+	}
+	return pattern
+}
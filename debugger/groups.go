@@ -0,0 +1,202 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BreakGroup is a named set of breakpoint locations, e.g. "auth-path", that
+// is enabled and disabled together.
+type BreakGroup struct {
+	Name      string
+	Locations []string
+	Enabled   bool
+
+	traps []*groupTrap
+}
+
+// groupTrap is an armed breakpoint of an enabled group.
+type groupTrap struct {
+	group *BreakGroup
+	file  string
+	line  int
+	addr  uint64
+}
+
+// groupCommand handles:
+//
+//	group                            list groups
+//	group <name> add <location>      add a [file:]line or function
+//	group <name> enable|disable      arm or disarm all locations
+//	group <name> delete              disarm and forget the group
+func (d *Debugger) groupCommand(pid int, args []string) bool {
+	if len(args) == 0 {
+		for _, g := range d.Groups {
+			state := "disabled"
+			if g.Enabled {
+				state = "enabled"
+			}
+			d.printf("  %s (%s): %s\n", g.Name, state, strings.Join(g.Locations, ", "))
+		}
+		return false
+	}
+	if len(args) < 2 {
+		d.println("usage: group <name> add <location> | enable | disable | delete")
+		return false
+	}
+
+	name, op := args[0], args[1]
+	g := d.findGroup(name)
+	switch {
+	case op == "add" && len(args) == 3:
+		if g == nil {
+			g = &BreakGroup{Name: name}
+			d.Groups = append(d.Groups, g)
+		}
+		err := d.addToGroup(pid, g, args[2])
+		d.audit("", "group", pid, g.Name+" add "+args[2], err)
+		if err != nil {
+			d.println(err)
+		}
+	case g == nil:
+		d.printf("No group %s\n", name)
+	case op == "enable":
+		d.EnableGroup(pid, g)
+		d.audit("", "group", pid, g.Name+" enable", nil)
+		d.printf("Group %s enabled (%d breakpoints)\n", g.Name, len(g.traps))
+	case op == "disable":
+		d.DisableGroup(pid, g)
+		d.audit("", "group", pid, g.Name+" disable", nil)
+		d.printf("Group %s disabled\n", g.Name)
+	case op == "delete":
+		d.DisableGroup(pid, g)
+		d.audit("", "group", pid, g.Name+" delete", nil)
+		for i := range d.Groups {
+			if d.Groups[i] == g {
+				d.Groups = append(d.Groups[:i], d.Groups[i+1:]...)
+				break
+			}
+		}
+	default:
+		d.println("usage: group <name> add <location> | enable | disable | delete")
+	}
+	return false
+}
+
+func (d *Debugger) findGroup(name string) *BreakGroup {
+	for _, g := range d.Groups {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// addToGroup adds the location loc to g, arming it if g is enabled. Line
+// numbers without a file refer to the current file.
+func (d *Debugger) addToGroup(pid int, g *BreakGroup, loc string) error {
+	file, line, err := d.parseLocation(loc)
+	if err != nil {
+		return err
+	}
+	loc = fmt.Sprintf("%s:%d", file, line)
+	for _, l := range g.Locations {
+		if l == loc {
+			// Already there, e.g. restored by -session and added again
+			// by the rc file.
+			return nil
+		}
+	}
+	g.Locations = append(g.Locations, loc)
+	if g.Enabled {
+		return d.armGroupTrap(pid, g, file, line)
+	}
+	return nil
+}
+
+// parseLocation resolves "[file:]line" or a function name, which stands for
+// the line of its entry, where lineToPC puts the end of its prologue.
+func (d *Debugger) parseLocation(loc string) (string, int, error) {
+	if _, err := strconv.Atoi(loc); err != nil && !strings.Contains(loc, ":") {
+		fn, err := d.ResolveFunc(loc)
+		if err != nil {
+			return "", 0, err
+		}
+		file, line, _ := d.pcToLine(fn.Entry)
+		return file, line, nil
+	}
+
+	file := d.TargetFile
+	lineText := loc
+	if i := strings.LastIndex(loc, ":"); i >= 0 {
+		path, err := d.ResolveFile(loc[:i])
+		if err != nil {
+			return "", 0, err
+		}
+		file, lineText = path, loc[i+1:]
+	}
+	line, err := strconv.Atoi(lineText)
+	if err != nil {
+		return "", 0, fmt.Errorf("bad line number in %s", loc)
+	}
+	return file, line, nil
+}
+
+// EnableGroup arms all breakpoints of g. Locations without code are
+// reported and skipped.
+func (d *Debugger) EnableGroup(pid int, g *BreakGroup) {
+	if g.Enabled {
+		return
+	}
+	g.Enabled = true
+	for _, loc := range g.Locations {
+		file, line, err := d.parseLocation(loc)
+		if err == nil {
+			err = d.armGroupTrap(pid, g, file, line)
+		}
+		if err != nil {
+			d.printf("  %s: %v\n", loc, err)
+		}
+	}
+}
+
+func (d *Debugger) armGroupTrap(pid int, g *BreakGroup, file string, line int) error {
+	addr, _, err := d.lineToPC(file, line)
+	if err != nil {
+		return fmt.Errorf("no code at %s:%d", file, line)
+	}
+	if d.Breakpoints[addr] != nil || len(d.catchesAt(addr)) > 0 || d.findGroupTrap(addr) != nil {
+		return fmt.Errorf("%s:%d already has a breakpoint", file, line)
+	}
+
+	if err := d.addTrap(addr); err != nil {
+		return err
+	}
+	t := &groupTrap{group: g, file: file, line: line, addr: addr}
+	g.traps = append(g.traps, t)
+	return nil
+}
+
+// DisableGroup removes all breakpoints of g.
+func (d *Debugger) DisableGroup(pid int, g *BreakGroup) {
+	for _, t := range g.traps {
+		d.removeTrap(t.addr)
+	}
+	g.traps = nil
+	g.Enabled = false
+}
+
+// findGroupTrap returns the group breakpoint at addr.
+func (d *Debugger) findGroupTrap(addr uint64) *groupTrap {
+	for _, g := range d.Groups {
+		for _, t := range g.traps {
+			if t.addr == addr {
+				return t
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,487 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// grpcService is the path prefix of the Debugger service defined in
+// rpc/dedebugger.proto.
+const grpcService = "/dedebugger.v1.Debugger/"
+
+// gRPC status codes used by the server.
+const (
+	grpcCanceled           = 1
+	grpcUnknown            = 2
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcUnauthenticated    = 16
+)
+
+// grpcError is an error with a gRPC status code.
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string {
+	return e.msg
+}
+
+// GRPCServer serves the Debugger service of rpc/dedebugger.proto for one
+// session, speaking gRPC over cleartext HTTP/2. When Token or Tokens are
+// set, clients have to send one as "authorization: Bearer <token>"
+// metadata. Token allows everything; with one of Tokens, SetBreakpoint,
+// Continue and Step, as calls or Control commands, need RoleBreak, and the
+// rest RoleRead.
+type GRPCServer struct {
+	Session *Session
+	Token   string
+	Tokens  Tokens
+}
+
+// grpcRoles are the roles the methods need, and controlRoles those of the
+// commands of the Control stream, by field number.
+var (
+	grpcRoles = map[string]Role{
+		"SetBreakpoint": RoleBreak,
+		"Continue":      RoleBreak,
+		"Step":          RoleBreak,
+		"Stacktrace":    RoleRead,
+		"Eval":          RoleRead,
+		"Events":        RoleRead,
+		"Control":       RoleRead,
+	}
+	controlRoles = map[int]Role{1: RoleBreak, 2: RoleBreak, 3: RoleBreak, 4: RoleRead, 5: RoleRead}
+)
+
+// ListenAndServe serves the session on addr until it fails.
+func (g *GRPCServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(l)
+}
+
+// Serve accepts gRPC connections on l until it is closed.
+func (g *GRPCServer) Serve(l net.Listener) error {
+	srv := &http.Server{Handler: g, ConnContext: connContext("grpc")}
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	return srv.Serve(l)
+}
+
+// ServeHTTP handles one gRPC call.
+func (g *GRPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	ctx := r.Context()
+	if t := r.Header.Get("Grpc-Timeout"); t != "" {
+		timeout, err := parseGRPCTimeout(t)
+		if err != nil {
+			grpcFinish(w, &grpcError{grpcInvalidArgument, err.Error()})
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	role, ok := serverTokens(g.Token, g.Tokens).authorize(r.Header.Get("Authorization"))
+	if !ok {
+		grpcFinish(w, &grpcError{grpcUnauthenticated, "missing or bad token"})
+		return
+	}
+
+	method, ok := strings.CutPrefix(r.URL.Path, grpcService)
+	if !ok {
+		grpcFinish(w, &grpcError{grpcUnimplemented, "unknown service"})
+		return
+	}
+	if err := permit(role, grpcRoles[method]); err != nil {
+		grpcFinish(w, err)
+		return
+	}
+	grpcFinish(w, g.dispatch(ctx, role, method, r.Body, w))
+}
+
+// permit fails with PERMISSION_DENIED unless role allows what needs.
+func permit(role, needs Role) error {
+	if role < needs {
+		return &grpcError{grpcPermissionDenied, fmt.Sprintf("the token's role, %s, doesn't allow this; it needs %s", role, needs)}
+	}
+	return nil
+}
+
+func (g *GRPCServer) dispatch(ctx context.Context, role Role, method string, body io.Reader, w http.ResponseWriter) error {
+	switch method {
+	case "Events":
+		if _, err := grpcRead(body); err != nil {
+			return err
+		}
+		return g.streamEvents(ctx, w, nil)
+	case "Control":
+		return g.control(ctx, role, body, w)
+	}
+
+	req, err := grpcRead(body)
+	if err != nil {
+		return err
+	}
+	var resp pbWriter
+	switch method {
+	case "SetBreakpoint":
+		_, err = g.setBreakpoint(ctx, req, &resp)
+	case "Continue":
+		err = g.run(ctx, g.Session.Continue)
+		resp.buf = encodeEvent(g.Session.LastEvent(), nil)
+	case "Step":
+		err = g.run(ctx, g.Session.Step)
+		resp.buf = encodeEvent(g.Session.LastEvent(), nil)
+	case "Stacktrace":
+		var st Stacktrace
+		st, err = g.stacktrace(req)
+		for _, f := range st.Frames {
+			resp.bytes(1, encodeFrame(f))
+		}
+		resp.uint(2, st.Goroutine)
+		resp.int(3, int64(st.Thread))
+	case "Eval":
+		var v string
+		v, err = g.eval(req)
+		resp.string(1, v)
+	default:
+		return &grpcError{grpcUnimplemented, "unknown method " + method}
+	}
+	if err != nil {
+		return err
+	}
+	return grpcWrite(w, resp.buf)
+}
+
+// run executes a resuming operation, interrupting the target when the
+// call's deadline expires or the client goes away.
+func (g *GRPCServer) run(ctx context.Context, op func() error) error {
+	err := g.Session.runContext(ctx, op)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &grpcError{grpcDeadlineExceeded, "deadline exceeded, target interrupted"}
+	case errors.Is(err, context.Canceled):
+		return &grpcError{grpcCanceled, "call canceled, target interrupted"}
+	}
+	return asGRPCError(err)
+}
+
+func (g *GRPCServer) setBreakpoint(ctx context.Context, req []byte, resp *pbWriter) (*Breakpoint, error) {
+	fields, err := pbParse(req)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	var file string
+	var line int
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			file = string(f.data)
+		case 2:
+			line = int(int32(f.value))
+		}
+	}
+	b, err := g.Session.breakFor(requestClient(ctx), file, line)
+	if err != nil {
+		return nil, asGRPCError(err)
+	}
+
+	resp.string(1, b.File)
+	resp.int(2, int64(b.Line))
+	resp.uint(3, b.Addr)
+	return b, nil
+}
+
+func (g *GRPCServer) stacktrace(req []byte) (Stacktrace, error) {
+	fields, err := pbParse(req)
+	if err != nil {
+		return Stacktrace{}, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	depth := 50
+	for _, f := range fields {
+		if f.num == 1 && f.value > 0 {
+			depth = int(int32(f.value))
+		}
+	}
+	st, err := g.Session.Stacktrace(depth)
+	return st, asGRPCError(err)
+}
+
+func (g *GRPCServer) eval(req []byte) (string, error) {
+	fields, err := pbParse(req)
+	if err != nil {
+		return "", &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	var expr string
+	for _, f := range fields {
+		if f.num == 1 {
+			expr = string(f.data)
+		}
+	}
+	v, err := g.Session.Eval(expr)
+	if err != nil {
+		return "", asGRPCError(err)
+	}
+	return fmt.Sprint(v), nil
+}
+
+// streamEvents writes every session event to w until the target exits or
+// ctx ends. Writes are serialized with mu when it is not nil.
+func (g *GRPCServer) streamEvents(ctx context.Context, w http.ResponseWriter, mu *sync.Mutex) error {
+	events, cancel := g.Session.Debugger().Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-events:
+			if mu != nil {
+				mu.Lock()
+			}
+			err := grpcWrite(w, encodeEvent(ev, nil))
+			if mu != nil {
+				mu.Unlock()
+			}
+			if err != nil || ev.Type == "exit" {
+				return err
+			}
+		}
+	}
+}
+
+// control serves the bidirectional Control stream: every event of the
+// session is forwarded, and each command is answered by a "result" event.
+func (g *GRPCServer) control(ctx context.Context, role Role, body io.Reader, w http.ResponseWriter) error {
+	// Send the headers now so the client can start streaming commands.
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	go g.streamEvents(ctx, w, &mu)
+
+	for {
+		msg, err := grpcRead(body)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		result := g.command(ctx, role, msg)
+		mu.Lock()
+		err = grpcWrite(w, result)
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// command executes one Control command, if role allows it, and returns the
+// encoded result.
+func (g *GRPCServer) command(ctx context.Context, role Role, msg []byte) []byte {
+	result := Event{Type: "result", Time: time.Now()}
+	var frames []Frame
+
+	fields, err := pbParse(msg)
+	if err == nil && len(fields) != 1 {
+		err = fmt.Errorf("expected exactly one command")
+	}
+	if err == nil {
+		err = permit(role, controlRoles[fields[0].num])
+	}
+	if err == nil {
+		f := fields[0]
+		switch f.num {
+		case 1:
+			var resp pbWriter
+			var b *Breakpoint
+			b, err = g.setBreakpoint(ctx, f.data, &resp)
+			result.Reason = "set_breakpoint"
+			if err == nil {
+				result.File, result.Line, result.PC = b.File, b.Line, fmt.Sprintf("%#x", b.Addr)
+			}
+		case 2:
+			result.Reason = "continue"
+			err = g.run(ctx, g.Session.Continue)
+		case 3:
+			result.Reason = "step"
+			err = g.run(ctx, g.Session.Step)
+		case 4:
+			result.Reason = "stacktrace"
+			var st Stacktrace
+			st, err = g.stacktrace(f.data)
+			frames = st.Frames
+		case 5:
+			result.Reason = "eval"
+			result.Detail, err = g.eval(f.data)
+		default:
+			err = fmt.Errorf("unknown command %d", f.num)
+		}
+	}
+
+	var w pbWriter
+	w.buf = encodeEvent(result, frames)
+	if err != nil {
+		w.string(12, err.Error())
+	}
+	return w.buf
+}
+
+// encodeEvent encodes ev as a dedebugger.v1.Event message.
+func encodeEvent(ev Event, frames []Frame) []byte {
+	var w pbWriter
+	w.string(1, ev.Type)
+	if !ev.Time.IsZero() {
+		w.int(2, ev.Time.UnixNano())
+	}
+	w.int(3, int64(ev.Pid))
+	pc, _ := strconv.ParseUint(strings.TrimPrefix(ev.PC, "0x"), 16, 64)
+	w.uint(4, pc)
+	w.string(5, ev.Func)
+	w.string(6, ev.File)
+	w.int(7, int64(ev.Line))
+	w.string(8, ev.Reason)
+	w.int(9, int64(ev.Hits))
+	w.optionalInt(10, ev.Status)
+	w.string(11, ev.Detail)
+	for _, f := range frames {
+		w.bytes(13, encodeFrame(f))
+	}
+	w.int(14, ev.Done)
+	w.int(15, ev.Total)
+	return w.buf
+}
+
+// encodeFrame encodes f as a dedebugger.v1.Frame message.
+func encodeFrame(f Frame) []byte {
+	var w pbWriter
+	w.uint(1, f.PC)
+	w.string(2, f.Func)
+	w.string(3, f.File)
+	w.int(4, int64(f.Line))
+	w.uint(5, f.SP)
+	w.uint(6, f.FP)
+	w.uint(7, f.Goroutine)
+	return w.buf
+}
+
+// grpcMaxMessage bounds the messages grpcRead accepts, as gRPC servers do by
+// default: the length comes from the client, authenticated or not.
+const grpcMaxMessage = 4 << 20
+
+// grpcRead reads one length-prefixed gRPC message.
+func grpcRead(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, &grpcError{grpcInvalidArgument, "truncated message"}
+		}
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > grpcMaxMessage {
+		return nil, &grpcError{grpcResourceExhausted, fmt.Sprintf("message of %d bytes, over the limit of %d", n, grpcMaxMessage)}
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "truncated message"}
+	}
+	return msg, nil
+}
+
+// grpcWrite writes one length-prefixed gRPC message and flushes it.
+func grpcWrite(w http.ResponseWriter, msg []byte) error {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+	if _, err := w.Write(append(hdr[:], msg...)); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+// grpcFinish sends the status of the call as trailers.
+func grpcFinish(w http.ResponseWriter, err error) {
+	code, msg := 0, ""
+	if err != nil {
+		var gerr *grpcError
+		if !errors.As(err, &gerr) {
+			gerr = &grpcError{grpcUnknown, err.Error()}
+		}
+		code, msg = gerr.code, gerr.msg
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", msg)
+	}
+}
+
+// asGRPCError maps session errors to gRPC statuses.
+func asGRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var gerr *grpcError
+	if errors.As(err, &gerr) {
+		return err
+	}
+	return &grpcError{grpcFailedPrecondition, err.Error()}
+}
+
+// parseGRPCTimeout parses the grpc-timeout header, e.g. "100m" or "5S".
+func parseGRPCTimeout(s string) (time.Duration, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("bad grpc-timeout %q", s)
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad grpc-timeout %q", s)
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, fmt.Errorf("bad grpc-timeout unit in %q", s)
+	}
+	return time.Duration(n) * unit, nil
+}
@@ -0,0 +1,142 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"sort"
+	"time"
+)
+
+// hangWatch follows the goroutines from stop to stop to find likely hangs:
+// goroutines that stay at the same PC, waiting for the same thing, while
+// the target runs for longer than after.
+type hangWatch struct {
+	after   time.Duration // 0 turns the watch off
+	ran     time.Duration // how long the target ran, over all resumes
+	resumed time.Time
+	seen    map[uint64]*parkedGoroutine
+}
+
+// parkedGoroutine is where a goroutine has been at consecutive stops, and
+// since when in running time of the target.
+type parkedGoroutine struct {
+	pc       uint64
+	reason   string
+	since    time.Duration
+	stops    int
+	reported bool
+}
+
+// markResumed notes that the target runs again, for the running time.
+func (h *hangWatch) markResumed() {
+	h.resumed = time.Now()
+}
+
+// checkHangs compares the goroutines at a stop with those at the previous
+// ones and reports, once each, those that haven't moved for longer than
+// the threshold. Time the target spends stopped doesn't count, and neither
+// do the stopped goroutine and the runtime's own goroutines, which idle in
+// the same place all the time.
+func (d *Debugger) checkHangs(pid int) {
+	h := &d.hangs
+	if h.after == 0 {
+		return
+	}
+	if !h.resumed.IsZero() {
+		h.ran += time.Since(h.resumed)
+		h.resumed = time.Time{}
+	}
+	gs, err := d.Goroutines(pid)
+	if err != nil {
+		return
+	}
+	seen := make(map[uint64]*parkedGoroutine, len(gs))
+	for _, g := range gs {
+		if g.Running || g.Current {
+			continue
+		}
+		p := h.seen[g.ID]
+		if p == nil || p.pc != g.PC || p.reason != g.WaitReason {
+			p = &parkedGoroutine{pc: g.PC, reason: g.WaitReason, since: h.ran}
+		}
+		p.stops++
+		seen[g.ID] = p
+		if p.reported || h.ran-p.since < h.after {
+			continue
+		}
+		p.reported = true
+		if f := d.userFrame(pid, g); !isRuntimeFunc(f.Func) {
+			d.printf("Likely hang: goroutine %d %s at %s for %v of running time, %d stops\n",
+				g.ID, g.state(), f, (h.ran - p.since).Round(time.Millisecond), p.stops)
+		}
+	}
+	h.seen = seen
+}
+
+// hangsCommand handles "hangs [duration|off]". With a duration, goroutines
+// that stay in the same place across stops for that long of running time
+// are reported at the stop that finds them; "off" stops watching. Without
+// an argument, the goroutines that haven't moved since the previous stop
+// are listed, the longest parked first.
+func (d *Debugger) hangsCommand(pid int, args []string) bool {
+	h := &d.hangs
+	switch {
+	case len(args) == 1 && args[0] == "off":
+		*h = hangWatch{}
+		d.println("Not watching for hangs")
+		return false
+	case len(args) == 1:
+		after, err := time.ParseDuration(args[0])
+		if err != nil || after <= 0 {
+			d.println("usage: hangs [duration|off]")
+			return false
+		}
+		if h.after == 0 {
+			// Start following the goroutines from here.
+			h.seen = nil
+			d.checkHangs(pid)
+		}
+		h.after = after
+		d.printf("Reporting goroutines that stay in the same place for %v of running time\n", after)
+		return false
+	case len(args) > 1:
+		d.println("usage: hangs [duration|off]")
+		return false
+	}
+
+	if h.after == 0 {
+		d.println("Not watching for hangs; hangs <duration> starts")
+		return false
+	}
+	gs, err := d.Goroutines(pid)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	sort.SliceStable(gs, func(i, j int) bool {
+		pi, pj := h.seen[gs[i].ID], h.seen[gs[j].ID]
+		return pi != nil && (pj == nil || pi.since < pj.since)
+	})
+	listed := 0
+	for _, g := range gs {
+		p := h.seen[g.ID]
+		if p == nil || p.stops < 2 {
+			continue
+		}
+		f := d.userFrame(pid, g)
+		if isRuntimeFunc(f.Func) {
+			continue
+		}
+		mark := " "
+		if h.ran-p.since >= h.after {
+			mark = "!"
+		}
+		d.printf("%s Goroutine %d %s at %s for %v, %d stops\n",
+			mark, g.ID, g.state(), f, (h.ran - p.since).Round(time.Millisecond), p.stops)
+		listed++
+	}
+	if listed == 0 {
+		d.println("No goroutine has stayed in the same place since the previous stop")
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// HealthEndpoint is a health check of the target, such as the liveness
+// probe of an orchestrator, that the debugger answers while the target is
+// stopped, so that it isn't killed mid-investigation for not answering.
+type HealthEndpoint struct {
+	Port int
+	// HTTP answers requests for Path, or any path if it is empty, with 200
+	// OK, and others with 503. Otherwise connections are accepted and
+	// closed, which passes a TCP check.
+	HTTP bool
+	Path string
+}
+
+// ParseHealthEndpoint parses an endpoint given as "http://:8080/healthz",
+// "http://:8080" or "tcp://:8081".
+func ParseHealthEndpoint(s string) (HealthEndpoint, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return HealthEndpoint{}, err
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil || port <= 0 || port > 65535 || u.Scheme != "http" && u.Scheme != "tcp" || u.Scheme == "tcp" && u.Path != "" {
+		return HealthEndpoint{}, fmt.Errorf("bad health endpoint %q, want http://:<port>[/path] or tcp://:<port>", s)
+	}
+	return HealthEndpoint{Port: port, HTTP: u.Scheme == "http", Path: u.Path}, nil
+}
+
+func (e HealthEndpoint) String() string {
+	if e.HTTP {
+		return fmt.Sprintf("http://:%d%s", e.Port, e.Path)
+	}
+	return fmt.Sprintf("tcp://:%d", e.Port)
+}
+
+// healthAnswers are the listening sockets of the target the debugger
+// accepts health checks on while it is stopped.
+type healthAnswers struct {
+	listeners []net.Listener
+	wg        sync.WaitGroup
+}
+
+// The system calls that copy a file descriptor of another process.
+const (
+	sysPidfdOpen  = 434
+	sysPidfdGetfd = 438
+)
+
+// targetListener returns a copy of the file descriptor of the socket the
+// target listens on at port, found by its inode in the target's TCP tables
+// and file descriptors. It shares the queue of connections with the
+// target's.
+func targetListener(pid, port int) (*os.File, error) {
+	var inodes []string
+	for _, table := range []string{"tcp", "tcp6"} {
+		b, err := os.ReadFile(fmt.Sprintf("/proc/%d/net/%s", pid, table))
+		if err != nil {
+			continue
+		}
+		// sl local_address rem_address st ... inode
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) < 10 || fields[3] != "0A" {
+				continue
+			}
+			_, p, _ := strings.Cut(fields[1], ":")
+			if n, err := strconv.ParseUint(p, 16, 16); err == nil && int(n) == port {
+				inodes = append(inodes, "socket:["+fields[9]+"]")
+			}
+		}
+	}
+	if len(inodes) == 0 {
+		return nil, fmt.Errorf("the target doesn't listen on port %d", port)
+	}
+	fds, err := filepath.Glob(fmt.Sprintf("/proc/%d/fd/*", pid))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range fds {
+		link, err := os.Readlink(path)
+		if err != nil || !strings.HasPrefix(link, "socket:[") || !slices.Contains(inodes, link) {
+			continue
+		}
+		fd, _ := strconv.Atoi(filepath.Base(path))
+		pidfd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+		if errno != 0 {
+			return nil, fmt.Errorf("pidfd_open: %v", errno)
+		}
+		copied, _, errno := syscall.Syscall(sysPidfdGetfd, pidfd, uintptr(fd), 0)
+		syscall.Close(int(pidfd))
+		if errno != 0 {
+			return nil, fmt.Errorf("pidfd_getfd: %v", errno)
+		}
+		return os.NewFile(copied, fmt.Sprintf("port %d", port)), nil
+	}
+	return nil, fmt.Errorf("no file descriptor of the target holds its socket on port %d", port)
+}
+
+// answerHealth starts answering the health checks of d.Health for the
+// target, which has just stopped. Endpoints the target doesn't listen on
+// yet are skipped until a later stop.
+func (d *Debugger) answerHealth() {
+	if len(d.Health) == 0 || d.NonStop || d.healthAnswers != nil {
+		return
+	}
+	h := &healthAnswers{}
+	for _, e := range d.Health {
+		f, err := targetListener(d.Pid, e.Port)
+		if err != nil {
+			continue
+		}
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			d.printf("Can't answer the health checks of %s: %v\n", e, err)
+			continue
+		}
+		h.listeners = append(h.listeners, l)
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go answerHealthCheck(c, e)
+			}
+		}()
+	}
+	d.healthAnswers = h
+}
+
+// endHealth stops answering health checks before the target resumes, and
+// leaves the connections still to come to it.
+func (d *Debugger) endHealth() {
+	h := d.healthAnswers
+	if h == nil {
+		return
+	}
+	for _, l := range h.listeners {
+		l.Close()
+	}
+	h.wg.Wait()
+	d.healthAnswers = nil
+}
+
+// answerHealthCheck answers the health check on c for e.
+func answerHealthCheck(c net.Conn, e HealthEndpoint) {
+	defer c.Close()
+	if !e.HTTP {
+		return
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	req, err := http.ReadRequest(bufio.NewReader(c))
+	if err != nil {
+		return
+	}
+	status, body := http.StatusOK, "ok\n"
+	if e.Path != "" && req.URL.Path != e.Path {
+		status, body = http.StatusServiceUnavailable, "stopped in the debugger\n"
+	}
+	fmt.Fprintf(c, "HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		status, http.StatusText(status), len(body), body)
+}
@@ -1,15 +1,19 @@
+//go:build linux && amd64
+
 package debugger
 
+import "os"
+
 // NewDebugger initializes a new Debugger instance.
 func NewDebugger() *Debugger {
 	return &Debugger{
-		BreakpointSet: false,
-		InterruptCode: []byte{0xCC},
-	}
-}
-
-func must(err error) {
-	if err != nil {
-		panic(err)
+		Arch:         hostArch(),
+		Sched:        SchedConfig{CPU: -1},
+		UI:           NewLineFrontend(os.Stdin, os.Stdout),
+		ContextLines: 3,
+		HistorySize:  defaultHistorySize,
+		TimelineSize: defaultTimelineSize,
+		SymbolIndex:  defaultSymbolIndexDir(),
+		WaitTimeout:  defaultWaitTimeout,
 	}
 }
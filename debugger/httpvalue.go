@@ -0,0 +1,214 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// formatHTTP formats the net/http Requests and Responses as what was sent:
+// a request's method, URL and protocol, a response's protocol and status,
+// then their headers and the other fields that are set. ok is false for
+// other types and for layouts it doesn't know.
+func (d *Debugger) formatHTTP(pid int, t *dwarf.StructType, buf []byte, depth int) (s string, ok bool) {
+	str := func(name string) string {
+		off, err := fieldOffset(t, []string{name})
+		if err != nil {
+			return ""
+		}
+		s, _ := d.stringFromHeader(pid, buf[off:])
+		return s
+	}
+	num := func(name string) int64 {
+		off, err := fieldOffset(t, []string{name})
+		if err != nil {
+			return 0
+		}
+		return int64(bufUint(buf[off:], 8))
+	}
+
+	var parts []string
+	switch t.StructName {
+	case "net/http.Request":
+		url, err := d.formatURL(pid, t, buf)
+		if err != nil {
+			return "", false
+		}
+		method := str("Method")
+		if method == "" {
+			method = "GET"
+		}
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("%s %s %s", method, url, str("Proto"))))
+		if host := str("Host"); host != "" && !strings.Contains(url, "//"+host) {
+			parts = append(parts, fmt.Sprintf("Host: %q", host))
+		}
+		if addr := str("RemoteAddr"); addr != "" {
+			parts = append(parts, fmt.Sprintf("RemoteAddr: %q", addr))
+		}
+	case "net/http.Response":
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("%s %s", str("Proto"), str("Status"))))
+	default:
+		return "", false
+	}
+	if h, err := d.formatHeader(pid, t, buf); err != nil {
+		parts = append(parts, fmt.Sprintf("Header: <%v>", err))
+	} else if h != "" {
+		parts = append(parts, "Header: "+h)
+	}
+	if n := num("ContentLength"); n != 0 {
+		parts = append(parts, fmt.Sprintf("ContentLength: %d", n))
+	}
+	if t.StructName == "net/http.Response" {
+		if off, err := fieldOffset(t, []string{"Request"}); err == nil && bufUint(buf[off:], 8) != 0 {
+			req, _ := fieldType(t, "Request")
+			parts = append(parts, "Request: "+d.formatValue(pid, req, buf[off:], depth+1))
+		}
+	}
+	return fmt.Sprintf("%s {%s}", t.StructName, strings.Join(parts, ", ")), true
+}
+
+// formatHTTPPointer formats a pointer p to a net/http Request or Response
+// with what it points to.
+func (d *Debugger) formatHTTPPointer(pid int, t *dwarf.PtrType, p uint64, depth int) (string, bool) {
+	pointee := t.Type
+	for {
+		td, ok := pointee.(*dwarf.TypedefType)
+		if !ok {
+			break
+		}
+		pointee = td.Type
+	}
+	st, ok := pointee.(*dwarf.StructType)
+	if !ok || st.StructName != "net/http.Request" && st.StructName != "net/http.Response" || depth >= maxPrintDepth {
+		return "", false
+	}
+	val, err := d.readValueAt(pid, p, st)
+	if err != nil {
+		return "", false
+	}
+	s, ok := d.formatHTTP(pid, st, val.buf, depth)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("(%s)(%#x) %s", t, p, s), true
+}
+
+// formatURL formats the *url.URL field URL of the request of type t in
+// buf as URL.String does, without the user info.
+func (d *Debugger) formatURL(pid int, t *dwarf.StructType, buf []byte) (string, error) {
+	off, err := fieldOffset(t, []string{"URL"})
+	if err != nil {
+		return "", err
+	}
+	typ, err := fieldPointee(t, "URL")
+	if err != nil {
+		return "", err
+	}
+	addr := bufUint(buf[off:], 8)
+	if addr == 0 {
+		return "", nil
+	}
+	u, err := d.readValueAt(pid, addr, typ)
+	if err != nil {
+		return "", err
+	}
+	str := func(name string) string {
+		off, err := fieldOffset(typ, []string{name})
+		if err != nil {
+			return ""
+		}
+		s, _ := d.stringFromHeader(pid, u.buf[off:])
+		return s
+	}
+
+	var b strings.Builder
+	if scheme := str("Scheme"); scheme != "" {
+		b.WriteString(scheme + ":")
+	}
+	if opaque := str("Opaque"); opaque != "" {
+		b.WriteString(opaque)
+	} else {
+		if host := str("Host"); host != "" {
+			b.WriteString("//" + host)
+		}
+		path := str("RawPath")
+		if path == "" {
+			path = str("Path")
+		}
+		b.WriteString(path)
+	}
+	if q := str("RawQuery"); q != "" {
+		b.WriteString("?" + q)
+	}
+	if f := str("Fragment"); f != "" {
+		b.WriteString("#" + f)
+	}
+	return b.String(), nil
+}
+
+// formatHeader formats the http.Header field Header of the value of type t
+// in buf, a map from names to lists of values, sorted by name. It is empty
+// when there are none.
+func (d *Debugger) formatHeader(pid int, t *dwarf.StructType, buf []byte) (string, error) {
+	off, err := fieldOffset(t, []string{"Header"})
+	if err != nil {
+		return "", err
+	}
+	typ, err := fieldType(t, "Header")
+	if err != nil {
+		return "", err
+	}
+	entries, n, err := d.mapEntries(pid, typ, buf[off:], maxPrintElems)
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", nil
+	}
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		name, err := d.stringFromHeader(pid, e.key.buf)
+		if err != nil {
+			return "", err
+		}
+		vals, err := d.stringSlice(pid, e.elem.buf)
+		if err != nil {
+			return "", err
+		}
+		quoted := make([]string, len(vals))
+		for i, v := range vals {
+			quoted[i] = fmt.Sprintf("%q", v)
+		}
+		lines = append(lines, fmt.Sprintf("%q: [%s]", name, strings.Join(quoted, ", ")))
+	}
+	sort.Strings(lines)
+	if n > len(entries) {
+		lines = append(lines, fmt.Sprintf("... %d more", n-len(entries)))
+	}
+	return "{" + strings.Join(lines, ", ") + "}", nil
+}
+
+// stringSlice reads the first maxPrintElems strings of the []string whose
+// header is in buf.
+func (d *Debugger) stringSlice(pid int, buf []byte) ([]string, error) {
+	array, n := bufUint(buf, 8), min(bufUint(buf[8:], 8), maxPrintElems)
+	if n == 0 {
+		return nil, nil
+	}
+	hdrs := make([]byte, n*16)
+	if err := d.ReadMemory(pid, array, hdrs); err != nil {
+		return nil, err
+	}
+	ss := make([]string, n)
+	for i := range ss {
+		s, err := d.stringFromHeader(pid, hdrs[i*16:])
+		if err != nil {
+			return nil, err
+		}
+		ss[i] = s
+	}
+	return ss, nil
+}
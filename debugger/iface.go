@@ -0,0 +1,117 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"fmt"
+)
+
+// runtimeTypeOf returns the DWARF type of the runtime type descriptor at
+// addr, the dynamic type of an interface. The descriptors are mapped to
+// their types once, from the attribute that RuntimeType reads.
+func (d *Debugger) runtimeTypeOf(addr uint64) (dwarf.Type, error) {
+	if d.Dwarf == nil {
+		return nil, ErrNoDWARF
+	}
+	if d.runtimeTypes == nil {
+		d.runtimeTypes = make(map[uint64]dwarf.Offset)
+		types, relative := d.Symbols["runtime.types"]
+		r := d.Dwarf.Reader()
+		for {
+			entry, err := r.Next()
+			if err != nil || entry == nil {
+				break
+			}
+			off, ok := entry.Val(attrGoRuntimeType).(uint64)
+			if !ok {
+				continue
+			}
+			if relative && off < types {
+				off += types
+			}
+			d.runtimeTypes[off] = entry.Offset
+		}
+	}
+	off, ok := d.runtimeTypes[addr]
+	if !ok {
+		return nil, fmt.Errorf("no type with runtime type %#x", addr)
+	}
+	return d.Dwarf.Type(off)
+}
+
+// ifaceValue returns the dynamic value of the interface in buf, an empty
+// interface (runtime.eface) or one with methods (runtime.iface), whose
+// first word points to the type or to an itab holding it. The zero value
+// is returned for a nil interface.
+func (d *Debugger) ifaceValue(pid int, buf []byte, empty bool) (value, error) {
+	if len(buf) < 16 {
+		return value{}, fmt.Errorf("short interface")
+	}
+	tab, data := bufUint(buf, 8), bufUint(buf[8:], 8)
+	if tab == 0 {
+		return value{}, nil
+	}
+	typeAddr := tab
+	if !empty {
+		// The itab's type follows its interface type.
+		off, err := d.FieldOffset("internal/abi.ITab", "Type")
+		if err != nil {
+			if off, err = d.FieldOffset("runtime.itab", "_type"); err != nil {
+				off = 8
+			}
+		}
+		var rerr error
+		if typeAddr, rerr = d.readUint(pid, tab+uint64(off), 8); rerr != nil {
+			return value{}, rerr
+		}
+	}
+	typ, err := d.runtimeTypeOf(typeAddr)
+	if err != nil {
+		return value{}, err
+	}
+	if pointerShaped(typ) {
+		// The data word is the value itself.
+		return value{typ: typ, buf: buf[8:16]}, nil
+	}
+	return d.readValueAt(pid, data, typ)
+}
+
+// pointerShaped reports whether values of typ are stored in an interface's
+// data word rather than pointed to by it: pointers, maps, channels and
+// functions, and structs and arrays holding just one of those.
+func pointerShaped(typ dwarf.Type) bool {
+	for {
+		switch t := typ.(type) {
+		case *dwarf.TypedefType:
+			typ = t.Type
+			continue
+		case *dwarf.PtrType, *dwarf.FuncType:
+			return true
+		case *dwarf.StructType:
+			if len(t.Field) != 1 || t.StructName == "string" {
+				return false
+			}
+			typ = t.Field[0].Type
+			continue
+		case *dwarf.ArrayType:
+			if t.Count != 1 {
+				return false
+			}
+			typ = t.Type
+			continue
+		}
+		return false
+	}
+}
+
+// typeName returns the Go name of typ, as in "*context.cancelCtx".
+func typeName(typ dwarf.Type) string {
+	if t, ok := typ.(*dwarf.StructType); ok && t.StructName != "" {
+		return t.StructName
+	}
+	if name := typ.Common().Name; name != "" {
+		return name
+	}
+	return typ.String()
+}
@@ -1,207 +1,784 @@
+//go:build linux && amd64
+
 package debugger
 
 import (
-	"bufio"
 	"debug/elf"
 	"debug/gosym"
-	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"strconv"
+	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 )
 
-// InputOrContinue gets user input to determine whether to continue, step, set a breakpoint, or quit.
+// InputOrContinue asks the frontend for commands until one resumes the
+// target. It reports whether to continue (rather than step) the target.
+// It also returns once the session is quit, which the caller ends with
+// ErrQuit.
 func (d *Debugger) InputOrContinue(pid int) bool {
-	sub := false
-	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Printf("\n(C)ontinue, (S)tep, set (B)reakpoint or (Q)uit? > ")
-	for {
-		scanner.Scan()
-		input := scanner.Text()
-		switch strings.ToUpper(input) {
-		case "C":
-			return true
-		case "S":
-			return false
-		case "B":
-			fmt.Printf("  Enter line number in %s: > ", d.TargetFile)
-			sub = true
-		case "Q":
-			os.Exit(0)
-		default:
-			if sub {
-				d.Line, _ = strconv.Atoi(input)
-				d.BreakpointSet, d.OriginalCode = d.SetBreak(pid)
+	for !d.quitting {
+		input, ok := d.nextCommand()
+		if !ok {
+			// End of input, e.g. the end of a piped script.
+			d.quit(pid)
+			break
+		}
+		input = d.repeatCommand(input)
+		cmd := d.expandAlias(input)
+		fields := strings.Fields(cmd)
+		if len(fields) == 0 {
+			continue
+		}
+		if resume, ok := resumeCommands[strings.ToLower(fields[0])]; ok {
+			if ok, cont := resume(d, pid, fields[1:]); ok {
+				return cont
+			}
+			continue
+		}
+		if found, cont := d.runCommand(pid, cmd); found {
+			if cont {
 				return true
 			}
-			fmt.Printf("Unexpected input %s\n", input)
-			fmt.Printf("\n(C)ontinue, (S)tep, set (B)reakpoint or (Q)uit? > ")
+			continue
 		}
+		d.printf("Unexpected input %s\n", input)
 	}
+	return false
 }
 
-// SetBreak sets a breakpoint at the specified line.
-func (d *Debugger) SetBreak(pid int) (bool, []byte) {
-	var err error
-	d.PC, _, err = d.SymTable.LineToPC(d.TargetFile, d.Line)
-	if err != nil {
-		fmt.Printf("Can't find breakpoint for %s, %d\n", d.TargetFile, d.Line)
-		return false, []byte{}
+// liftTrapAtPC restores the code under an armed trap at the PC of pid, such
+// as a breakpoint just set on the current line. Resuming would hit it again
+// without moving; instead it is stepped over and re-armed like a rewound one.
+func (d *Debugger) liftTrapAtPC(pid int) error {
+	if d.rearm {
+		return nil
 	}
+	if site := d.armedTrap(d.Regs.Rip); site != nil {
+		if _, err := d.ReplaceCode(pid, site.addr, site.orig); err != nil {
+			return err
+		}
+		d.rearm, d.rearmAt = true, site.addr
+	}
+	return nil
+}
 
-	return true, d.ReplaceCode(pid, d.PC, d.InterruptCode)
+// rewindTrap moves pid back onto the trap at addr it just hit and restores
+// the original instruction. The trap is re-armed by resume once the
+// instruction has been executed.
+func (d *Debugger) rewindTrap(pid int, addr uint64) error {
+	d.Regs.Rip = addr
+	if err := d.proc().SetRegs(pid, &d.Regs); err != nil {
+		return fmt.Errorf("rewinding thread %d: %w", pid, err)
+	}
+	if _, err := d.ReplaceCode(pid, addr, d.traps[addr].orig); err != nil {
+		return err
+	}
+	d.rearm, d.rearmAt = true, addr
+	return nil
+}
+
+// ResumeFromBreakpoint continues pid, stopped just past a trap it hit,
+// leaving the trap in place: the PC is set back onto the trap, the original
+// instruction restored and single-stepped, the trap written back, and the
+// thread continued. Without the rewind the thread would resume in the middle
+// of the instruction, and without re-arming the trap would fire only once.
+func (d *Debugger) ResumeFromBreakpoint(pid int) error {
+	addr := d.trapAddr()
+	if d.armedTrap(addr) == nil {
+		return fmt.Errorf("no breakpoint at %#x to resume from", addr)
+	}
+	if err := d.rewindTrap(pid, addr); err != nil {
+		return err
+	}
+	return d.resume(pid, true)
+}
+
+// resume continues or single-steps pid. Traps set up since the last stop are
+// written first. A rewound breakpoint, or one armed at the PC, is stepped
+// over and re-armed before continuing.
+func (d *Debugger) resume(pid int, cont bool) error {
+	d.unsafeStop = ""
+	if err := d.flushTraps(pid); err != nil {
+		d.println(err)
+	}
+	if err := d.liftTrapAtPC(pid); err != nil {
+		return err
+	}
+	// The signal of a signal stop goes with the next continue; a step
+	// leaves the thread to fault again.
+	held := d.stopSig
+	d.stopSig = 0
+	if !cont {
+		d.awaitStop(pid)
+		return wrapPtrace("single-stepping", pid, d.proc().Step(pid))
+	}
+	sig := 0
+	if held != 0 && d.Signals.Action(held) != SignalIgnore {
+		sig = int(held)
+	}
+	if d.rearm {
+		// A signal (usually the runtime's SIGURG preemption) can stop the
+		// thread before the instruction was stepped. Hold it back until the
+		// trap is re-armed, otherwise the thread would trap on the same
+		// instruction again.
+		for {
+			if err := d.proc().Step(pid); err != nil {
+				return wrapPtrace("single-stepping", pid, err)
+			}
+			if _, err := d.proc().Wait(pid, &d.Ws, nil); err != nil {
+				return fmt.Errorf("waiting for thread %d: %w", pid, err)
+			}
+			if !d.Ws.Stopped() {
+				d.rearm = false
+				return nil
+			}
+			if d.Ws.StopSignal() == syscall.SIGTRAP {
+				break
+			}
+			if s := d.Ws.StopSignal(); !d.droppedInterrupt(s) {
+				sig = int(s)
+			}
+		}
+		d.rearmTrap(pid)
+	}
+	return wrapPtrace("continuing", pid, d.proc().Cont(pid, sig))
 }
 
-// ReplaceCode replaces the code at the specified address with new code.
-func (d *Debugger) ReplaceCode(pid int, address uint64, code []byte) []byte {
+// ReplaceCode writes code at address and returns the code it replaced. The
+// code can be any length, such as a trap sequence of several bytes; what is
+// returned is the target's own code, without the debugger's armed traps.
+func (d *Debugger) ReplaceCode(pid int, address uint64, code []byte) ([]byte, error) {
 	original := make([]byte, len(code))
-	syscall.PtracePeekData(pid, uintptr(address), original)
-	syscall.PtracePokeData(pid, uintptr(address), code)
-	return original
+	if err := d.ReadMemory(pid, address, original); err != nil {
+		return nil, err
+	}
+	d.originalCode(address, original)
+	if err := d.writeCode(pid, address, code); err != nil {
+		return nil, err
+	}
+	return original, nil
 }
 
 // GetSymbolTable retrieves the symbol table from the specified executable.
-func (d *Debugger) GetSymbolTable(prog string) *gosym.Table {
+// It also loads the DWARF information and ELF symbols, when present.
+func (d *Debugger) GetSymbolTable(prog string) (*gosym.Table, error) {
 	exe, err := elf.Open(prog)
-	must(err)
+	if err != nil {
+		return nil, err
+	}
 	defer exe.Close()
 
-	addr := exe.Section(".text").Addr
+	text, pclntab := exe.Section(".text"), exe.Section(".gopclntab")
+	if text == nil {
+		return nil, fmt.Errorf("%s: %w", prog, ErrNoSymbolTable)
+	}
+	addr := text.Addr
 
-	lineTableData, err := exe.Section(".gopclntab").Data()
-	must(err)
+	// A session against the same build before left what is parsed below
+	// in the symbol index.
+	id := buildID(exe)
+	indexPath := d.symbolIndexPath(id)
+	idx, indexed := loadSymbolIndex(indexPath, id)
 
+	// Progress is measured in bytes of the sections each phase parses;
+	// building the table walks the line table a second time.
+	var dwarfSize, symSize int64
+	for _, s := range exe.Sections {
+		switch {
+		case strings.HasPrefix(s.Name, ".debug_"), strings.HasPrefix(s.Name, ".zdebug_"):
+			dwarfSize += int64(s.Size)
+		case s.Name == ".symtab" || s.Name == ".strtab":
+			symSize += int64(s.Size)
+		}
+	}
+	var lineSize int64
+	if pclntab != nil {
+		lineSize = int64(pclntab.Size)
+	}
+	if indexed {
+		dwarfSize, symSize = 0, 0
+	}
+	p := d.startProgress("Loading symbols", dwarfSize+symSize+2*lineSize)
+
+	if !indexed {
+		idx = &symbolIndex{Version: symbolIndexVersion, BuildID: id}
+		p.phase("reading DWARF", dwarfSize)
+		if idx.Dwarf, err = dwarfSectionData(exe); err != nil {
+			idx.Dwarf, indexPath = nil, ""
+		}
+
+		p.phase("reading ELF symbols", symSize)
+		idx.Symbols = make(map[string]uint64)
+		if syms, err := exe.Symbols(); err == nil {
+			for _, sym := range syms {
+				idx.Symbols[sym.Name] = sym.Value
+			}
+		}
+	}
+	d.Dwarf, _ = dwarfFromSections(idx.Dwarf)
+	d.dwarfData = dwarfSectionsOf(idx.Dwarf)
+	d.Symbols = idx.Symbols
+
+	p.phase("reading line table", lineSize)
+	lineTableData, fromDWARF := idx.LineTable, idx.FromDWARF
+	if lineTableData == nil {
+		if lineTableData, fromDWARF, err = d.lineTableData(exe, pclntab); err != nil {
+			return nil, fmt.Errorf("%s: %w", prog, err)
+		}
+	}
+	// External linking, as with cgo, puts C code ahead of the Go code in
+	// .text, and the line table counts from where the Go code starts.
+	if start := d.Symbols["runtime.text"]; start != 0 {
+		addr = start
+	}
 	lineTable := gosym.NewLineTable(lineTableData, addr)
-	must(err)
 
-	symTableData, err := exe.Section(".gosymtab").Data()
-	must(err)
+	// Since Go 1.3 the symbols are in the line table and linkers may leave
+	// .gosymtab out.
+	var symTableData []byte
+	if s := exe.Section(".gosymtab"); s != nil {
+		if symTableData, err = s.Data(); err != nil {
+			return nil, err
+		}
+	}
 
+	p.phase("building symbol table", lineSize)
 	symTable, err := gosym.NewTable(symTableData, lineTable)
-	must(err)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", prog, ErrNoSymbolTable, err)
+	}
+	p.finish()
+	if !indexed {
+		// .gopclntab is read faster from the binary than from the index.
+		if pclntab == nil {
+			idx.LineTable, idx.FromDWARF = lineTableData, fromDWARF
+		}
+		idx.save(indexPath)
+	}
 
-	return symTable
+	// Position independent executables are relocated once loaded.
+	d.load, d.loadBias = newLoadInfo(exe, addr, lineTableData, symTableData, fromDWARF), 0
+	d.gOffset = tlsGOffset(exe, d.Symbols["runtime.tlsg"])
+	d.globals, d.waitReasons, d.runtimeTypes, d.typeNames, d.instStarts, d.pcln = nil, nil, nil, nil, nil, nil
+	return symTable, nil
 }
 
-// OutputStack outputs the call stack information.
-func (d *Debugger) OutputStack(pid int, ip uint64, sp uint64, bp uint64) {
-	_, _, d.Fn = d.SymTable.PCToLine(ip)
-
-	var i uint64
-	var nextbp uint64
+// lineTableData returns the Go line table of exe: the .gopclntab section,
+// the table wherever the runtime's symbols say it is, or failing both one
+// built from the DWARF line information, which fromDWARF reports.
+func (d *Debugger) lineTableData(exe *elf.File, pclntab *elf.Section) (data []byte, fromDWARF bool, err error) {
+	if pclntab != nil {
+		data, err = pclntab.Data()
+		return data, false, err
+	}
+	if data, err := pclntabFromSymbols(exe, d.Symbols); err == nil {
+		return data, false, nil
+	}
+	if data, err = pclntabFromDWARF(d.Dwarf, 0); err != nil {
+		return nil, false, fmt.Errorf("%w: no .gopclntab, and none from DWARF: %v", ErrNoSymbolTable, err)
+	}
+	return data, true, nil
+}
 
-	for {
-		i = 0
-		frameSize := bp - sp + 8
+// OutputStack outputs the callers of the code at ip, whose stack and frame
+// pointers are sp and bp, as backtrace finds them, down to main.main or the
+// bottom of the stack. It fails if the stack can't be read.
+func (d *Debugger) OutputStack(pid int, ip uint64, sp uint64, bp uint64) error {
+	_, _, d.Fn = d.pcToLine(ip)
+	if d.Fn == nil {
+		// Code without Go symbols, such as the dynamic loader, needn't
+		// keep frame pointers; backtrace unwinds what it can.
+		d.println()
+		return nil
+	}
 
-		// If we look at bp / sp while they are being updated we can
-		// get some odd results
-		if frameSize > 1000 || bp == 0 {
-			fmt.Printf("Strange frame size: SP: %X | BP : %X \n", sp, bp)
-			frameSize = 32
-			bp = sp + frameSize - 8
+	regs := d.Regs
+	regs.Rip, regs.Rsp, regs.Rbp = ip, sp, bp
+	frames, err := d.Stacktrace(pid, &regs, maxGoroutineFrames)
+	for _, f := range frames[1:] {
+		if f.Func == "" {
+			continue
 		}
+		d.printf("  called by %s line %d\n", f.Func, f.Line)
+		// runtime.mstart is at the bottom of a thread's system stack and
+		// runtime.goexit at that of a goroutine's.
+		if f.Func == "main.main" || f.Func == "runtime.main" || f.Func == "runtime.mstart" || f.Func == "runtime.goexit" {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	d.println()
+	return nil
+}
+
+// RunTarget starts the target executable and handles the debugging session
+// until the target exits. Symbols are loaded first unless they already are.
+func (d *Debugger) RunTarget(target string) error {
+	return d.Launch(LaunchConfig{Path: target})
+}
+
+// LaunchConfig is how Launch starts the target.
+type LaunchConfig struct {
+	Path string
+	Args []string
+	// Env holds KEY=value entries added to the debugger's environment,
+	// replacing variables of the same name.
+	Env []string
+	// Dir is the working directory of the target, the debugger's if empty.
+	Dir string
+	// Stdin, Stdout and Stderr are those of the debugger if nil.
+	Stdin          io.Reader
+	Stdout, Stderr io.Writer
+}
 
-		// Read the next stack frame
-		b := make([]byte, frameSize)
-		_, err := syscall.PtracePeekData(pid, uintptr(sp), b)
+// Launch starts the target as cfg says and handles the debugging session
+// until it exits, as RunTarget does. A restart launches it again.
+func (d *Debugger) Launch(cfg LaunchConfig) error {
+	return d.Debug(&LaunchedTarget{Config: cfg})
+}
+
+// Open starts the target, stopped at its first instruction.
+func (t *LaunchedTarget) Open(d *Debugger) (int, int, error) {
+	cfg := t.Config
+	target := cfg.Path
+
+	if d.SymTable == nil {
+		table, err := d.GetSymbolTable(target)
 		if err != nil {
-			panic(err)
+			return 0, 0, err
 		}
+		d.SymTable = table
+	}
+	if fn := d.SymTable.LookupFunc("main.main"); fn != nil {
+		d.TargetFile, _, d.Fn = d.SymTable.PCToLine(fn.Entry)
+	}
 
-		// The address to return to is at the top of the frame
-		content := binary.LittleEndian.Uint64(b[i : i+8])
-		_, lineno, nextfn := d.SymTable.PCToLine(content)
-		if nextfn != nil {
-			d.Fn = nextfn
-			fmt.Printf("  called by %s line %d\n", d.Fn.Name, lineno)
-		}
+	d.target, d.launch = target, cfg
+	d.stats.start = time.Now()
+	// The path is relative to the debugger's directory, not to Dir.
+	path, err := filepath.Abs(target)
+	if err != nil {
+		return 0, 0, err
+	}
+	cmd := exec.Command(path, cfg.Args...)
+	cmd.Dir = cfg.Dir
+	// Later entries win over earlier ones of the same name.
+	cmd.Env = append(os.Environ(), cfg.Env...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if cfg.Stdin != nil {
+		cmd.Stdin = cfg.Stdin
+	}
+	if cfg.Stdout != nil {
+		cmd.Stdout = cfg.Stdout
+	}
+	if cfg.Stderr != nil {
+		cmd.Stderr = cfg.Stderr
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Ptrace: true,
+	}
+	d.ApplySchedEnv(cmd)
 
-		for i = 8; sp+i <= bp; i += 8 {
-			content := binary.LittleEndian.Uint64(b[i : i+8])
-			if sp+i == bp {
-				nextbp = content
-			}
-		}
+	if err := cmd.Start(); err != nil {
+		return 0, 0, fmt.Errorf("can't start %s: %w", target, err)
+	}
+	pid := cmd.Process.Pid
+	d.Pid = pid
+	if !d.restarted {
+		d.handleSignals()
+	}
 
-		if d.Fn.Name == "main.main" || d.Fn.Name == "runtime.main" {
-			break
+	// The target stops with a SIGTRAP once it has exec'd; nothing can be
+	// done to it before that.
+	if _, err := syscall.Wait4(pid, &d.Ws, syscall.WALL, nil); err != nil {
+		return 0, 0, fmt.Errorf("waiting for %s to start: %w", target, err)
+	}
+	if !d.Ws.Stopped() || d.Ws.StopSignal() != syscall.SIGTRAP {
+		return 0, 0, fmt.Errorf("%s didn't stop at entry: %v", target, d.Ws)
+	}
+	if err := d.relocate(pid); err != nil {
+		return 0, 0, err
+	}
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := syscall.PtraceSetOptions(pid, traceOptions); err != nil {
+		return 0, 0, wrapPtrace("setting options of", pid, err)
+	}
+	d.tracees = nil
+	d.addTracee(pid, pid, pid)
+	return pid, pgid, nil
+}
+
+// debugTarget runs the session of the stopped thread pid of a target in
+// process group pgid: it applies the saved session and the rc file, prompts
+// for the first command and handles the target's events until it exits.
+// Should the target be lost to an error, its code is restored first.
+func (d *Debugger) debugTarget(pid, pgid int) error {
+	if err := d.PinToCPU(pid); err != nil {
+		d.printf("Can't pin target to cpu %d: %v\n", d.Sched.CPU, err)
+	}
+	// Breakpoints the session and rc file set in plugins wait for them.
+	d.planPluginTrap(pid)
+	d.planFatalTraps(pid)
+	d.planRaceTrap(pid)
+	if d.saved != nil {
+		d.ApplySession(pid, d.saved)
+	}
+	// A restart carries over what the rc file set up in the session.
+	if d.rcFile != "" && !d.restarted {
+		if err := d.RunRC(pid, d.rcPath()); err != nil && !os.IsNotExist(err) {
+			d.println(err)
 		}
+	}
+	d.planCallTraps(pid)
+	if err := d.proc().GetRegs(pid, &d.Regs); err != nil {
+		return wrapPtrace("reading registers of", pid, err)
+	}
+	cont := true
+	switch {
+	case d.supervised:
+		// The exit policy restarted it, to run on unattended.
+		d.supervised = false
+	case d.restarted:
+		d.runToMain()
+	default:
+		cont = d.InputOrContinue(pid)
+	}
+	if d.quitting {
+		return ErrQuit
+	}
+	if d.restarting {
+		d.killTarget(pgid, false)
+		return errRestart
+	}
+	err := d.resume(pid, cont)
+	switch {
+	case err == nil:
+		d.StartSchedTrace()
+		err = d.eventLoop(pid, pgid)
+	case d.lostTarget(pid, pgid, err):
+		err = d.targetExited(pid, pgid)
+	}
+	if err != nil && !errors.Is(err, errRestart) && !errors.Is(err, ErrQuit) {
+		d.restoreCode(d.Pid)
+	}
+	return err
+}
 
-		// Move to the next frame
-		sp = sp + i
-		bp = nextbp
+// stopAndPrompt reports the stop of pid at d.Regs and resumes it as the
+// user asks.
+func (d *Debugger) stopAndPrompt(pid int, reason string) error {
+	// Data watches are followed to where their memory is now, in case a
+	// move went unnoticed.
+	d.checkDataWatches(pid)
+	stop := d.locationEvent("stop", pid, d.Regs.Rip)
+	stop.Reason = reason
+	switch reason {
+	case "signal":
+		stop.Detail = signalName(d.stopSig)
+	case "fatal":
+		stop.Detail = d.fatalMsg
+	}
+	d.stopThreads(pid)
+	d.answerHealth()
+	defer d.endHealth()
+	d.cachePages()
+	defer d.dropPages()
+	d.UI.ShowStop(stop)
+	d.showContext(pid)
+	d.recordHistory(pid, stop)
+	d.RecordStop(pid, d.Regs.Rip)
+	d.Emit(stop)
+	d.notifyStop(pid, stop)
+	d.countStop(reason)
+	d.CheckSchedTrace(false)
+	d.cancelable(func() {
+		if err := d.OutputStack(pid, d.Regs.Rip, d.Regs.Rsp, d.Regs.Rbp); err != nil {
+			d.printf("  stack unreadable: %v\n\n", err)
+		}
+	})
+	d.checkHangs(pid)
+	if reason == "fatal" && d.runExitPolicy(pid, stop) {
+		d.restarting = true
 	}
+	// Commands typed while the target ran apply now that it stopped
+	// anyway; a pending interrupt just resumes it.
+	d.applyPending(pid)
+	// Any stop ends a next or finish, even one in another frame, an until
+	// and the steps back from a race report.
+	d.endStep()
+	d.endUntil()
+	d.raceTid = 0
+	d.stopRequested = false
 
-	fmt.Println()
+	if d.restarting {
+		// Restarted by a command typed while the target ran, or by the
+		// exit policy.
+		return errRestart
+	}
+	cont := d.InputOrContinue(pid)
+	if d.quitting {
+		return ErrQuit
+	}
+	if d.restarting {
+		return errRestart
+	}
+	d.hangs.markResumed()
+	d.endHealth()
+	err := d.resume(pid, cont)
+	// A stepi moves just the thread. A step may run on through calls,
+	// which can wait for the others.
+	if cont || d.lineStep != nil {
+		d.resumeThreads()
+	}
+	return err
 }
 
-// RunTarget starts the target executable and handles the debugging session.
-func (d *Debugger) RunTarget(target string) {
-	cmd := exec.Command(target)
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Ptrace: true,
+// Run is the command line interface: it parses os.Args and runs the
+// session they ask for. Errors are printed and end the process.
+func (d *Debugger) Run() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(Verify(os.Args[2:]))
+		case "serve":
+			os.Exit(Serve(os.Args[2:]))
+		case "compare":
+			os.Exit(Compare(os.Args[2:]))
+		case "bisect":
+			os.Exit(Bisect(os.Args[2:]))
+		case "symdiff":
+			os.Exit(SymDiff(os.Args[2:]))
+		case "watch":
+			os.Exit(WatchBuild(os.Args[2:]))
+		case "snapshot":
+			os.Exit(Snapshot(os.Args[2:]))
+		case "core":
+			os.Exit(Core(os.Args[2:]))
+		case "test":
+			os.Exit(DebugTest(os.Args[2:]))
+		case "bench":
+			os.Exit(DebugBench(os.Args[2:]))
+		case "fuzz":
+			os.Exit(DebugFuzz(os.Args[2:]))
+		case "exec-wait":
+			os.Exit(ExecWait(os.Args[2:]))
+		case "connect-token":
+			os.Exit(ConnectToken(os.Args[2:]))
+		case "k8s":
+			os.Exit(K8s(os.Args[2:]))
+		case "daemon":
+			os.Exit(ServeDaemon(os.Args[2:]))
+		}
 	}
 
-	cmd.Start()
-	err := cmd.Wait()
-	if err != nil {
-		fmt.Printf("Wait returned: %v\n\n", err)
+	d.HandleSignals = true
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.IntVar(&d.Sched.CPU, "cpu", d.Sched.CPU, "pin the target to this CPU (-1 to disable)")
+	flags.IntVar(&d.Sched.GoMaxProcs, "gomaxprocs", d.Sched.GoMaxProcs, "set GOMAXPROCS for the target")
+	flags.BoolVar(&d.Sched.Serialize, "serialize", d.Sched.Serialize, "disable async preemption and run the target on a single P")
+	flags.StringVar(&d.SchedTrace.Addr, "sched-trace", "", "capture a runtime execution trace from the target's pprof server at `addr`")
+	flags.DurationVar(&d.SchedTrace.Window, "sched-trace-window", 5*time.Second, "length of the captured execution trace")
+	flags.StringVar(&d.SchedTrace.Out, "sched-trace-out", "", "file to write the execution trace to")
+	eventFD := flags.Int("event-fd", -1, "write NDJSON events for every stop, hit and exit to file descriptor `N`")
+	eventPipe := flags.String("event-pipe", "", "write NDJSON events to the named pipe at `path`")
+	flags.BoolVar(&d.RawKeys, "raw", false, "act on single key presses at the prompt, without Enter")
+	flags.StringVar(&d.SourceRoot, "source-root", "", "look for source files missing at their compile-time paths under `dir`")
+	flags.StringVar(&d.Editor, "editor", "", "open source with \"edit\" in `editor`, a command or a URL template such as vscode://file/{file}:{line} (default $VISUAL or $EDITOR)")
+	flags.BoolVar(&d.InlineValues, "inline-values", false, "show the values of the variables a source line names beside it, at stops and in list")
+	flags.BoolVar(&d.Blame, "blame", false, "show who last changed the line of every stop, with git blame")
+	flags.IntVar(&d.ContextLines, "context", d.ContextLines, "show this many source lines around the current one at every stop (0 to disable)")
+	logOutput := flags.String("log-output", "", "send the lines of tracepoints and logpoints to `dest`: a file, unix:<path>, syslog or json:<file>")
+	audit := flags.String("audit", "", "append a record of every breakpoint, memory or register write and signal action set, and who did it, to `file`")
+	record := flags.String("record", "", "record the run's stops, hits and exit to `file` (.ddbrec) for compare")
+	flags.Func("snapshot", "evaluate `expr` at every stop and hit of a recording (repeatable)", func(expr string) error {
+		d.snapshotExprs = append(d.snapshotExprs, expr)
+		return nil
+	})
+	sessionFile := flags.String("session", "", "restore the launch settings, breakpoints, watches and catchpoints saved in `file`")
+	flags.Func("disassembler", "decode code with `backend`: go (the default, in process), objdump or capstone", d.setDisassembler)
+	flags.StringVar(&d.SymbolIndex, "symbol-index", d.SymbolIndex, "keep indexes of the symbols of binaries in `dir`, for later sessions to load faster (\"\" to disable)")
+	flags.StringVar(&d.rcFile, "rc", ".dedebuggerrc", "run the prompt commands in `file`, looked for at the root of the target's module too, before the first prompt (\"\" to disable)")
+	flags.StringVar(&d.saveOnExit, "save-on-exit", "", "save the session to `file` and kill the target when the debugger exits")
+	artifacts := flags.String("artifacts", "", "keep a copy of the recording, saved sessions, cores, transcripts and statistics in `store`: a directory, s3://bucket/prefix or gs://bucket/prefix")
+	flags.StringVar(&d.statsFile, "stats-json", "", "write the end-of-session statistics to `file` as JSON")
+	attach := flags.Int("attach", 0, "debug the running process `pid` instead of starting a target")
+	force := flags.Bool("force", false, "attach even if the process fails the safety checks")
+	unit := flags.String("unit", "", "debug the main process of the systemd `unit` instead of starting a target")
+	flags.BoolVar(&d.ReadOnly, "read-only", false, "never change the target: no breakpoints, watchpoints or writes of memory and registers")
+	flags.BoolVar(&d.SafePoints, "safe-points", false, "after an interrupt, step the target to a GC safe point before allowing writes to it")
+	flags.DurationVar(&d.WaitTimeout, "wait-timeout", d.WaitTimeout, "report the target unresponsive when it takes longer than `duration` to stop when asked; 0 never does")
+	flags.BoolVar(&d.NonStop, "non-stop", false, "leave the other threads running while one is stopped at the prompt")
+	flags.Func("signal", "stop at, pass or ignore a signal the target receives, as `SIG=action` (repeatable)", func(s string) error {
+		sig, action, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("%q is not SIG=stop|pass|ignore", s)
+		}
+		if d.Signals == nil {
+			d.Signals = make(SignalPolicy)
+		}
+		return d.Signals.Set(sig, SignalAction(strings.ToLower(action)))
+	})
+	interpreter := flags.String("interpreter", "", "speak the GDB machine interface (\"mi\") on stdin and stdout")
+	script := flags.String("script", "", "read the prompt commands from `file` instead of standard input, and quit at its end")
+	var exCommands []string
+	flags.Func("ex", "run the prompt command `cmd` before reading any (repeatable)", func(cmd string) error {
+		exCommands = append(exCommands, cmd)
+		return nil
+	})
+	var launch LaunchConfig
+	flags.Func("env", "set `KEY=value` in the environment of the target (repeatable)", func(kv string) error {
+		if !strings.Contains(kv, "=") {
+			return fmt.Errorf("%q is not KEY=value", kv)
+		}
+		launch.Env = append(launch.Env, kv)
+		return nil
+	})
+	flags.StringVar(&launch.Dir, "dir", "", "run the target in `directory`")
+	flags.DurationVar(&d.hangs.after, "hang-after", 0, "report goroutines that stay in the same place across stops for `duration` of running time")
+	flags.Func("trace-calls", "log every call of the functions matching `regexp`, indented by call depth", d.setTraceCalls)
+	flags.Func("call-graph", "record the call graph of the traced calls and write it to `file` as DOT at the end", func(file string) error {
+		d.callGraphFile, d.callGraph = file, &callGraph{}
+		return nil
+	})
+	flags.Func("health", "answer the health checks of the target at `endpoint`, http://:<port>[/path] or tcp://:<port>, while it is stopped (repeatable)", func(s string) error {
+		e, err := ParseHealthEndpoint(s)
+		if err == nil {
+			d.Health = append(d.Health, e)
+		}
+		return err
+	})
+	flags.Func("notify", "post the stops of breakpoints, catchpoints, watches and fatal errors to the webhook at `url` (repeatable)", func(url string) error {
+		d.Notify = append(d.Notify, url)
+		return nil
+	})
+	flags.StringVar(&d.exitPolicy, "exit-policy", "", "run the shell `command` when the target stops at a fatal error or exits, and take the actions it prints: core, transcript, notify or restart")
+	stdin := flags.String("stdin", "", "read the target's standard input from `file`, leaving the terminal to the prompt")
+	flags.Parse(os.Args[1:])
+	target := flags.Arg(0)
+	// Arguments of the target follow it, after an optional "--".
+	launch.Args = flags.Args()[min(1, flags.NArg()):]
+	if len(launch.Args) > 0 && launch.Args[0] == "--" {
+		launch.Args = launch.Args[1:]
+	}
+	if *sessionFile != "" {
+		saved, err := LoadSession(*sessionFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		d.Sched, d.SchedTrace, d.saved = saved.Sched, saved.SchedTrace, saved
+		if target == "" {
+			target, launch.Args = saved.Target, saved.Args
+		}
+		// Flags given on the command line win over the saved settings.
+		// Repeatable ones are collected again.
+		d.snapshotExprs, exCommands, d.Notify, d.Health = nil, nil, nil, nil
+		launch.Env, launch.Dir = saved.Env, saved.Dir
+		flags.Parse(os.Args[1:])
+	}
+	if *unit != "" {
+		pid, exe, err := UnitProcess(*unit)
+		exitOnError(err)
+		fmt.Printf("%s runs as process %d\n", *unit, pid)
+		*attach, d.unitExe = pid, exe
+	}
+	if target == "" && *attach == 0 {
+		fmt.Printf("usage: %s [flags] <target> [--] [args...]\n       %s [flags] -attach <pid> | -unit <unit>\n       %s verify <script> [-golden file] [-update] <target>\n       %s serve [-grpc addr] [-rest addr] [-nvim addr] [-token [role:]t]... [-audit file] <target> [args...]\n       %s compare <run1.ddbrec> <run2.ddbrec>\n       %s bisect -inputs <file> [-env KEY=v1,v2]... [-stop cmd]... [-stdin] [-binary] [-max n] [-timeout d] <target> [args...]\n       %s symdiff [-session file] <old binary> <new binary>\n       %s watch [-interval d] <package>\n       %s snapshot [-o file] [-global expr]... <pid> | <file>\n       %s core <binary> <corefile>\n       %s test <package> [test flags]\n       %s bench <package> -bench <regexp> [-iteration n] [test flags]\n       %s fuzz <package> -run <fuzz test>/<entry> [test flags]\n       %s exec-wait <program> [args...]\n       %s connect-token <token>\n       %s k8s attach <pod> [-c container] [-n namespace] [-image image]\n       %s daemon -rest addr -root dir [-token [role:]t]... [-audit file]\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(2)
 	}
 
-	pid := cmd.Process.Pid
-	pgid, _ := syscall.Getpgid(pid)
+	switch *interpreter {
+	case "":
+	case "mi", "mi2", "mi3":
+		os.Exit(ServeMI(os.Stdin, os.Stdout, target, flags.Args()[1:]))
+	default:
+		fmt.Printf("Unknown interpreter %q\n", *interpreter)
+		os.Exit(2)
+	}
 
-	must(syscall.PtraceSetOptions(pid, syscall.PTRACE_O_TRACECLONE))
+	if d.RawKeys && (*script != "" || len(exCommands) > 0) {
+		fmt.Println("Running commands from -script or -ex, ignoring -raw")
+		d.RawKeys = false
+	}
+	if *script != "" {
+		f, err := os.Open(*script)
+		exitOnError(err)
+		defer f.Close()
+		d.UI = NewScriptFrontend(f, os.Stdout)
+	}
+	if ui, ok := d.UI.(*LineFrontend); ok {
+		ui.Queue(exCommands...)
+		if ui.editor != nil {
+			ui.editor.complete = d.Complete
+		}
+	}
+	if d.RawKeys && !IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Println("Standard input is not a terminal, ignoring -raw")
+		d.RawKeys = false
+	}
+	if d.RawKeys {
+		d.UI = NewKeyFrontend(os.Stdin, os.Stdout)
+	}
 
-	if d.InputOrContinue(pid) {
-		must(syscall.PtraceCont(pid, 0))
-	} else {
-		must(syscall.PtraceSingleStep(pid))
+	if *artifacts != "" {
+		var err error
+		d.Artifacts, err = OpenArtifactStore(*artifacts)
+		exitOnError(err)
+	}
+	if *eventFD >= 0 {
+		exitOnError(d.OpenEventFD(*eventFD))
+	}
+	if *eventPipe != "" {
+		exitOnError(d.OpenEventPipe(*eventPipe))
+	}
+	if *record != "" {
+		exitOnError(d.OpenRecord(*record))
+	}
+	if *logOutput != "" {
+		exitOnError(d.OpenLogOutput(*logOutput))
+	}
+	if *audit != "" {
+		exitOnError(d.OpenAudit(*audit))
 	}
 
-	for {
-		wpid, err := syscall.Wait4(-1*pgid, &d.Ws, 0, nil)
-		must(err)
-		if d.Ws.Exited() {
-			if wpid == pid {
-				break
-			}
-		} else {
-			if d.Ws.StopSignal() == syscall.SIGTRAP && d.Ws.TrapCause() != syscall.PTRACE_EVENT_CLONE {
-				must(syscall.PtraceGetRegs(wpid, &d.Regs))
-				filename, line, fn := d.SymTable.PCToLine(d.Regs.Rip)
-				fmt.Printf("Stopped at %s at %d in %s\n", fn.Name, line, filename)
-				d.OutputStack(wpid, d.Regs.Rip, d.Regs.Rsp, d.Regs.Rbp)
-
-				if d.BreakpointSet {
-					d.ReplaceCode(wpid, d.PC, d.OriginalCode)
-					d.BreakpointSet = false
-				}
-
-				if d.InputOrContinue(wpid) {
-					must(syscall.PtraceCont(wpid, 0))
-				} else {
-					must(syscall.PtraceSingleStep(wpid))
-				}
-			} else {
-				must(syscall.PtraceCont(wpid, 0))
-			}
+	if *attach != 0 {
+		report, err := CheckAttach(*attach)
+		exitOnError(err)
+		fmt.Print(report)
+		if len(report.Problems) > 0 && !*force {
+			fmt.Println("Not attaching; -force attaches anyway")
+			os.Exit(1)
 		}
+		exitOnError(d.Attach(*attach))
+		return
+	}
+	if *stdin != "" {
+		f, err := os.Open(*stdin)
+		exitOnError(err)
+		defer f.Close()
+		launch.Stdin = f
 	}
+	launch.Path = target
+	exitOnError(d.Launch(launch))
 }
 
-// Run starts the debugging session.
-func (d *Debugger) Run() {
-	target := os.Args[1]
-	d.SymTable = d.GetSymbolTable(target)
-	d.Fn = d.SymTable.LookupFunc("main.main")
-	d.TargetFile, d.Line, d.Fn = d.SymTable.PCToLine(d.Fn.Entry)
-	d.RunTarget(target)
+// exitOnError prints err, if any, and exits. ErrQuit, a session the user
+// ended, isn't one.
+func exitOnError(err error) {
+	if err != nil && !errors.Is(err, ErrQuit) {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
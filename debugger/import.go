@@ -0,0 +1,273 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// importedBreakpoint is a breakpoint read from another tool's file, before
+// its location is resolved in the target.
+type importedBreakpoint struct {
+	loc      string // [file:]line or function, as break takes it
+	name     string // Delve's name for it, which its conditions may use
+	cond     string
+	hitCount string
+	message  string // a VS Code logpoint's message, set as a tracepoint's
+	trace    bool
+	disabled bool
+}
+
+// importCommand handles "import <file>", which sets the breakpoints of a
+// VS Code breakpoint export or a Delve init file. Breakpoints whose
+// location or condition can't be taken are reported and skipped.
+func (d *Debugger) importCommand(pid int, args []string) bool {
+	if len(args) != 1 {
+		d.println("usage: import <file>")
+		return false
+	}
+	bps, problems, err := readBreakpoints(args[0])
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	for _, p := range problems {
+		d.printf("  %s\n", p)
+	}
+	set := 0
+	for _, ib := range bps {
+		if err := d.importBreakpoint(pid, ib); err != nil {
+			d.printf("  %s: %v\n", ib.loc, err)
+			continue
+		}
+		set++
+	}
+	d.printf("Imported %d of %d breakpoints from %s\n", set, len(bps), args[0])
+	return false
+}
+
+// importBreakpoint sets ib on pid as a saved breakpoint would be.
+func (d *Debugger) importBreakpoint(pid int, ib importedBreakpoint) error {
+	file, line, err := d.parseLocation(ib.loc)
+	if err != nil {
+		return err
+	}
+	if b := d.breakpointAt(file, line); b != nil {
+		return fmt.Errorf("breakpoint %d is already at %s", b.ID, b)
+	}
+	// Check the condition first, so that a breakpoint isn't left set
+	// without the condition it was meant to have.
+	if ib.cond != "" {
+		if _, err := parseSampleConds(ib.cond); err != nil {
+			return fmt.Errorf("condition %q: %v", ib.cond, err)
+		}
+	}
+	sb := SavedBreakpoint{File: file, Line: line, HitCount: ib.hitCount, Cond: ib.cond, Disabled: ib.disabled}
+	if ib.trace || ib.message != "" {
+		sb.Mode, sb.Message = string(ModeTrace), ib.message
+	}
+	return d.applyBreakpoint(pid, sb)
+}
+
+// readBreakpoints reads the breakpoints of the file at path: a JSON VS Code
+// export, or else a Delve init file. It also returns the problems with the
+// lines of an init file that were left out.
+func readBreakpoints(path string) ([]importedBreakpoint, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		bps, err := vscodeBreakpoints(trimmed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return bps, nil, nil
+	}
+	bps, problems := delveBreakpoints(data)
+	return bps, problems, nil
+}
+
+// vscodeBreakpoint is a breakpoint of a VS Code export. Exports come in
+// the shape of DAP's source breakpoints, with a source path, or of VS
+// Code's own storage, with a uri and a lineNumber; function breakpoints
+// have a name instead.
+type vscodeBreakpoint struct {
+	Source struct {
+		Path string `json:"path"`
+	} `json:"source"`
+	Path         string          `json:"path"`
+	File         string          `json:"file"`
+	URI          json.RawMessage `json:"uri"`
+	Line         int             `json:"line"`
+	LineNumber   int             `json:"lineNumber"`
+	Name         string          `json:"name"`
+	Enabled      *bool           `json:"enabled"`
+	Condition    string          `json:"condition"`
+	HitCondition string          `json:"hitCondition"`
+	LogMessage   string          `json:"logMessage"`
+}
+
+// vscodeBreakpoints reads a list of breakpoints, or an object with them
+// under "breakpoints" and the function ones under "functionBreakpoints".
+func vscodeBreakpoints(data []byte) ([]importedBreakpoint, error) {
+	var list []vscodeBreakpoint
+	if data[0] == '[' {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+	} else {
+		var export struct {
+			Breakpoints         []vscodeBreakpoint `json:"breakpoints"`
+			FunctionBreakpoints []vscodeBreakpoint `json:"functionBreakpoints"`
+		}
+		if err := json.Unmarshal(data, &export); err != nil {
+			return nil, err
+		}
+		list = append(export.Breakpoints, export.FunctionBreakpoints...)
+	}
+
+	var bps []importedBreakpoint
+	for i, vb := range list {
+		ib := importedBreakpoint{
+			cond:     vb.Condition,
+			hitCount: vb.HitCondition,
+			message:  vb.LogMessage,
+			disabled: vb.Enabled != nil && !*vb.Enabled,
+		}
+		file := vscodeFile(vb)
+		line := max(vb.Line, vb.LineNumber)
+		switch {
+		case vb.Name != "":
+			ib.loc = vb.Name
+		case file != "" && line > 0:
+			ib.loc = fmt.Sprintf("%s:%d", file, line)
+		default:
+			return nil, fmt.Errorf("breakpoint %d has no location", i+1)
+		}
+		bps = append(bps, ib)
+	}
+	return bps, nil
+}
+
+// vscodeFile returns the path of the file of vb, however the export gives
+// it. A uri is a string or an object with an fsPath.
+func vscodeFile(vb vscodeBreakpoint) string {
+	for _, p := range []string{vb.Source.Path, vb.Path, vb.File} {
+		if p != "" {
+			return p
+		}
+	}
+	var uri string
+	if json.Unmarshal(vb.URI, &uri) != nil {
+		var obj struct {
+			FSPath string `json:"fsPath"`
+			Path   string `json:"path"`
+		}
+		json.Unmarshal(vb.URI, &obj)
+		if obj.FSPath != "" {
+			return obj.FSPath
+		}
+		return obj.Path
+	}
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return uri
+}
+
+// delveBreakpoints reads the breakpoints of a Delve init file, as set by
+// its break, trace and condition commands:
+//
+//	break [name] <location> [if <cond>]
+//	trace [name] <location> [if <cond>]
+//	condition <breakpoint> <cond>
+//	condition -hitcount <breakpoint> <op> <n>
+//
+// Breakpoints are named by their name or their number, counted from 1 in
+// the order they are set. Lines that can't be taken are left out, and
+// their problems returned; other commands are left out without one.
+func delveBreakpoints(data []byte) ([]importedBreakpoint, []string) {
+	var bps []importedBreakpoint
+	var problems []string
+	find := func(ref string) *importedBreakpoint {
+		if n, err := strconv.Atoi(ref); err == nil && n >= 1 && n <= len(bps) {
+			return &bps[n-1]
+		}
+		for i := range bps {
+			if bps[i].name == ref {
+				return &bps[i]
+			}
+		}
+		return nil
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "break", "b", "trace", "t":
+			ib, err := delveBreak(fields[1:])
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("line %d: %v", n, err))
+				continue
+			}
+			ib.trace = fields[0] == "trace" || fields[0] == "t"
+			bps = append(bps, ib)
+		case "condition", "cond":
+			args := fields[1:]
+			hitCount := len(args) > 0 && args[0] == "-hitcount"
+			if hitCount {
+				args = args[1:]
+			}
+			if len(args) < 2 {
+				problems = append(problems, fmt.Sprintf("line %d: usage: condition [-hitcount] <breakpoint> <cond>", n))
+				continue
+			}
+			ib := find(args[0])
+			if ib == nil {
+				problems = append(problems, fmt.Sprintf("line %d: no breakpoint %s", n, args[0]))
+				continue
+			}
+			if hitCount {
+				ib.hitCount = strings.Join(args[1:], "")
+			} else {
+				ib.cond = strings.Join(args[1:], " ")
+			}
+		}
+	}
+	return bps, problems
+}
+
+// delveBreak parses the arguments of a Delve break or trace command.
+func delveBreak(args []string) (importedBreakpoint, error) {
+	var ib importedBreakpoint
+	if i := slices.Index(args, "if"); i >= 0 {
+		ib.cond = strings.Join(args[i+1:], " ")
+		args = args[:i]
+	}
+	switch len(args) {
+	case 1:
+		ib.loc = args[0]
+	case 2:
+		ib.name, ib.loc = args[0], args[1]
+	default:
+		return ib, fmt.Errorf("usage: break [name] <location> [if <cond>]")
+	}
+	if strings.ContainsAny(ib.loc[:1], "*+-/") {
+		return ib, fmt.Errorf("location %s isn't [file:]line or a function", ib.loc)
+	}
+	return ib, nil
+}
@@ -0,0 +1,106 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// maxInlineValue bounds the length of a value shown beside a source line.
+const maxInlineValue = 40
+
+// inlineValues returns what InlineValues shows beside the lines of file
+// for the stop of the thread pid at d.Regs: for each line of the stopped
+// function up to the stop, the variables in scope that the line names,
+// with their current values, as `n = 3, name = "gopher"`. The scope is the
+// debug information's, so variables of blocks the stop is outside of, and
+// lines before a variable's declaration, get none. It returns nil when the
+// stop isn't in file.
+func (d *Debugger) inlineValues(pid int, file string) map[int]string {
+	if !d.InlineValues {
+		return nil
+	}
+	stopFile, stopLine, fn := d.pcToLine(d.Regs.Rip)
+	if fn == nil || stopFile != file {
+		return nil
+	}
+	_, first, _ := d.pcToLine(fn.Entry)
+	lines, err := d.sourceLines(file)
+	if err != nil || first <= 0 || first > stopLine {
+		return nil
+	}
+	vars, err := d.Variables(d.Regs.Rip)
+	if err != nil {
+		return nil
+	}
+	values := make(map[*Variable]string)
+	notes := make(map[int]string)
+	for n := first; n <= min(stopLine, len(lines)); n++ {
+		var shown []string
+		seen := make(map[string]bool)
+		for _, name := range lineIdents(lines[n-1]) {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			// The innermost variable declared by this line, as name
+			// resolves there.
+			var v *Variable
+			for i := len(vars) - 1; i >= 0 && v == nil; i-- {
+				if vars[i].Name == name && vars[i].Line <= n {
+					v = vars[i]
+				}
+			}
+			if v == nil {
+				continue
+			}
+			text, ok := values[v]
+			if !ok {
+				text = d.inlineValue(pid, v)
+				values[v] = text
+			}
+			shown = append(shown, name+" = "+text)
+		}
+		if len(shown) > 0 {
+			notes[n] = strings.Join(shown, ", ")
+		}
+	}
+	return notes
+}
+
+// inlineValue formats the value of v at the stop on one line, shortened to
+// maxInlineValue.
+func (d *Debugger) inlineValue(pid int, v *Variable) string {
+	val, err := d.variableValue(pid, &d.Regs, v)
+	if err != nil {
+		return "?"
+	}
+	text := strings.Join(strings.Fields(d.formatValue(pid, val.typ, val.buf, 0)), " ")
+	if r := []rune(text); len(r) > maxInlineValue {
+		text = string(r[:maxInlineValue-1]) + "…"
+	}
+	return text
+}
+
+// lineIdents returns the identifiers a line of Go names, in order, leaving
+// out those in strings and comments and the fields and methods after a
+// dot.
+func lineIdents(line string) []string {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", -1, len(line)), []byte(line), nil, 0)
+	var idents []string
+	prev := token.ILLEGAL
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return idents
+		}
+		if tok == token.IDENT && prev != token.PERIOD {
+			idents = append(idents, lit)
+		}
+		prev = tok
+	}
+}
@@ -0,0 +1,288 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// jsonValue renders the value of type typ held in buf as JSON, the way
+// encoding/json would marshal it: structs as objects of their fields,
+// slices and arrays as arrays, maps as objects keyed by the key's text,
+// pointers and interfaces as what they hold, and time.Time, strings and
+// the errors errorText knows as strings. Types print has a form of its own for, such as those with a
+// printer or of package sync, are strings of that form. The limits of
+// print apply: values nested deeper than maxPrintDepth are the string
+// "...", and the elements past maxPrintElems are summed up by a last one
+// such as "... 12 more".
+func (d *Debugger) jsonValue(pid int, typ dwarf.Type, buf []byte) (string, error) {
+	var b bytes.Buffer
+	d.writeJSON(&b, pid, typ, buf, 0)
+	var out bytes.Buffer
+	if err := json.Indent(&out, b.Bytes(), "", "  "); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func (d *Debugger) writeJSON(b *bytes.Buffer, pid int, typ dwarf.Type, buf []byte, depth int) {
+	if typ.Size() > int64(len(buf)) {
+		writeJSONString(b, fmt.Sprintf("<%s: only %d of %d bytes read>", typ, len(buf), typ.Size()))
+		return
+	}
+	switch t := typ.(type) {
+	case *dwarf.TypedefType:
+		d.writeJSON(b, pid, t.Type, buf, depth)
+		return
+	case *dwarf.IntType:
+		fmt.Fprint(b, bufInt(buf, t.ByteSize))
+		return
+	case *dwarf.UintType, *dwarf.CharType, *dwarf.UcharType:
+		fmt.Fprint(b, bufUint(buf, typ.Size()))
+		return
+	case *dwarf.BoolType:
+		fmt.Fprint(b, bufUint(buf, 1) != 0)
+		return
+	case *dwarf.FloatType:
+		f := bufFloat(buf, t.ByteSize)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			// JSON has no numbers for these.
+			writeJSONString(b, fmt.Sprint(f))
+			return
+		}
+		v, _ := json.Marshal(f)
+		b.Write(v)
+		return
+	case *dwarf.PtrType:
+		d.writeJSONPointer(b, pid, t, bufUint(buf, 8), buf, depth)
+		return
+	case *dwarf.ArrayType:
+		if t.Count < 0 {
+			break
+		}
+		d.writeJSONElems(b, pid, t.Type, buf, t.Count, t.Count, depth)
+		return
+	case *dwarf.StructType:
+		d.writeJSONStruct(b, pid, t, buf, depth)
+		return
+	}
+	writeJSONString(b, d.formatValue(pid, typ, buf, depth))
+}
+
+func (d *Debugger) writeJSONStruct(b *bytes.Buffer, pid int, t *dwarf.StructType, buf []byte, depth int) {
+	switch {
+	case d.printers[t.StructName] != nil, t.StructName == "time.Time",
+		strings.HasPrefix(t.StructName, "sync."), strings.HasPrefix(t.StructName, "net/http."):
+		writeJSONString(b, d.formatValue(pid, t, buf, depth))
+		return
+	case t.StructName == "string":
+		s, err := d.stringFromHeader(pid, buf)
+		if err != nil {
+			writeJSONString(b, fmt.Sprintf("<string: %v>", err))
+			return
+		}
+		writeJSONString(b, s)
+		return
+	case strings.HasPrefix(t.StructName, "[]"):
+		d.writeJSONSlice(b, pid, t, buf, depth)
+		return
+	case len(t.Field) == 2 && t.Field[1].Name == "data" && (t.Field[0].Name == "_type" || t.Field[0].Name == "tab"):
+		// An interface: its dynamic value, or the text of an error.
+		dyn, err := d.ifaceValue(pid, buf, t.Field[0].Name == "_type")
+		if err == nil && dyn.typ != nil {
+			if s, ok := d.errorText(pid, dyn); ok {
+				if text, err := strconv.Unquote(s); err == nil {
+					writeJSONString(b, text)
+					return
+				}
+			}
+		}
+		switch {
+		case err != nil:
+			writeJSONString(b, fmt.Sprintf("<%v>", err))
+		case dyn.typ == nil:
+			b.WriteString("null")
+		default:
+			d.writeJSON(b, pid, dyn.typ, dyn.buf, depth)
+		}
+		return
+	}
+	if depth >= maxPrintDepth {
+		writeJSONString(b, "...")
+		return
+	}
+	b.WriteString("{")
+	for i, f := range t.Field {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		writeJSONString(b, f.Name)
+		b.WriteString(":")
+		end := f.ByteOffset + f.Type.Size()
+		if f.ByteOffset < 0 || end > int64(len(buf)) {
+			writeJSONString(b, "<outside the struct>")
+			continue
+		}
+		d.writeJSON(b, pid, f.Type, buf[f.ByteOffset:end], depth+1)
+	}
+	b.WriteString("}")
+}
+
+// writeJSONPointer writes what the pointer p of type t in buf points to. A
+// map, itself a pointer, is an object of its entries. Pointers to what
+// can't be read, such as unsafe.Pointers, channels and functions, are
+// strings of their address.
+func (d *Debugger) writeJSONPointer(b *bytes.Buffer, pid int, t *dwarf.PtrType, p uint64, buf []byte, depth int) {
+	if p == 0 {
+		b.WriteString("null")
+		return
+	}
+	if strings.HasPrefix(typeName(t.Type), "map<") {
+		d.writeJSONMap(b, pid, t, buf, depth)
+		return
+	}
+	pointee := t.Type
+	for {
+		td, ok := pointee.(*dwarf.TypedefType)
+		if !ok {
+			break
+		}
+		pointee = td.Type
+	}
+	var readable bool
+	switch pt := pointee.(type) {
+	case *dwarf.StructType:
+		readable = !strings.HasPrefix(pt.StructName, "hchan<")
+	case *dwarf.IntType, *dwarf.UintType, *dwarf.CharType, *dwarf.UcharType,
+		*dwarf.BoolType, *dwarf.FloatType, *dwarf.ArrayType, *dwarf.PtrType:
+		readable = true
+	}
+	if readable && pointee.Size() > 0 {
+		if _, ok := pointee.(*dwarf.PtrType); ok {
+			// Chains of pointers count towards the depth, which ends
+			// those that loop.
+			if depth >= maxPrintDepth {
+				writeJSONString(b, "...")
+				return
+			}
+			depth++
+		}
+		val, err := d.readValueAt(pid, p, t.Type)
+		if err != nil {
+			writeJSONString(b, fmt.Sprintf("<%v>", err))
+			return
+		}
+		d.writeJSON(b, pid, val.typ, val.buf, depth)
+		return
+	}
+	writeJSONString(b, fmt.Sprintf("%#x", p))
+}
+
+// writeJSONMap writes the map of type t in buf as an object. Keys that
+// aren't strings are keyed by their JSON text, as encoding/json keys
+// integers.
+func (d *Debugger) writeJSONMap(b *bytes.Buffer, pid int, t *dwarf.PtrType, buf []byte, depth int) {
+	if depth >= maxPrintDepth {
+		writeJSONString(b, "...")
+		return
+	}
+	entries, n, err := d.mapEntries(pid, t, buf, maxPrintElems)
+	if err != nil {
+		writeJSONString(b, fmt.Sprintf("<%v>", err))
+		return
+	}
+	b.WriteString("{")
+	for i, e := range entries {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		var key bytes.Buffer
+		d.writeJSON(&key, pid, e.key.typ, e.key.buf, depth+1)
+		if k := key.String(); strings.HasPrefix(k, `"`) {
+			b.WriteString(k)
+		} else {
+			writeJSONString(b, k)
+		}
+		b.WriteString(":")
+		d.writeJSON(b, pid, e.elem.typ, e.elem.buf, depth+1)
+	}
+	if n > len(entries) {
+		if len(entries) > 0 {
+			b.WriteString(",")
+		}
+		writeJSONString(b, "...")
+		fmt.Fprintf(b, `:"%d more"`, n-len(entries))
+	}
+	b.WriteString("}")
+}
+
+// writeJSONSlice writes the slice header of type t in buf as an array of
+// its elements, null for a nil slice.
+func (d *Debugger) writeJSONSlice(b *bytes.Buffer, pid int, t *dwarf.StructType, buf []byte, depth int) {
+	var array, n uint64
+	var elem dwarf.Type
+	for _, f := range t.Field {
+		v := bufUint(buf[f.ByteOffset:], 8)
+		switch f.Name {
+		case "array":
+			array = v
+			if p, ok := f.Type.(*dwarf.PtrType); ok {
+				elem = p.Type
+			}
+		case "len":
+			n = v
+		}
+	}
+	switch {
+	case elem == nil:
+		writeJSONString(b, fmt.Sprintf("<%s % x>", t, buf))
+		return
+	case array == 0:
+		b.WriteString("null")
+		return
+	}
+	shown := min(n, maxPrintElems)
+	size := max(elem.Size(), 0)
+	data := make([]byte, min(int64(shown)*size, maxValueSize))
+	if err := d.ReadMemory(pid, array, data); err != nil {
+		writeJSONString(b, fmt.Sprintf("<%v>", err))
+		return
+	}
+	d.writeJSONElems(b, pid, elem, data, int64(n), int64(len(data))/max(size, 1), depth)
+}
+
+// writeJSONElems writes the first shown of n elements of type elem in buf
+// as an array.
+func (d *Debugger) writeJSONElems(b *bytes.Buffer, pid int, elem dwarf.Type, buf []byte, n, shown int64, depth int) {
+	if depth >= maxPrintDepth && n > 0 {
+		writeJSONString(b, "...")
+		return
+	}
+	size := elem.Size()
+	shown = min(shown, maxPrintElems)
+	b.WriteString("[")
+	for i := int64(0); i < shown; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		d.writeJSON(b, pid, elem, buf[i*size:(i+1)*size], depth+1)
+	}
+	if n > shown {
+		if shown > 0 {
+			b.WriteString(",")
+		}
+		writeJSONString(b, fmt.Sprintf("... %d more", n-shown))
+	}
+	b.WriteString("]")
+}
+
+func writeJSONString(b *bytes.Buffer, s string) {
+	v, _ := json.Marshal(s)
+	b.Write(v)
+}
@@ -0,0 +1,183 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// k8sDebuggerPath is where "k8s attach" copies the debugger into the
+// target's container.
+const k8sDebuggerPath = "/tmp/dedebugger"
+
+// k8sStartTimeout bounds the wait for an ephemeral container to run.
+const k8sStartTimeout = 2 * time.Minute
+
+// K8s implements "k8s attach <pod> [-c container] [flags]": it runs a DAP
+// server next to the target in a Kubernetes pod and forwards a local port
+// to it, for an editor to attach to the target through. The server runs in
+// an ephemeral container of an image with the debugger, sharing the
+// container's processes, or with -image unset in the container itself,
+// copied in with kubectl cp. Either way it reads the symbols from the
+// target's /proc/<pid>/exe, the binary of the container image.
+func K8s(args []string) int {
+	const usage = "usage: k8s attach <pod> [-c container] [-n namespace] [-image image] [-port port] [-local-port port] [-pid pid]"
+	if len(args) < 2 || args[0] != "attach" {
+		fmt.Println(usage)
+		return 2
+	}
+	flags := flag.NewFlagSet("k8s attach", flag.ExitOnError)
+	container := flags.String("c", "", "the `container` of the target, the pod's first if empty")
+	namespace := flags.String("n", "", "the `namespace` of the pod")
+	image := flags.String("image", "", "run the debugger in an ephemeral container of `image`, which has dedebugger in its PATH, rather than in the target's container")
+	port := flags.Int("port", 4711, "the `port` the DAP server listens on in the pod")
+	localPort := flags.Int("local-port", 0, "the local `port` forwarded to the server, -port if 0")
+	pid := flags.Int("pid", 1, "the `pid` of the target in its container")
+	// The pod comes first, as kubectl takes it, or after the flags.
+	pod := ""
+	if rest := args[1:]; !strings.HasPrefix(rest[0], "-") {
+		pod = rest[0]
+		flags.Parse(rest[1:])
+	} else {
+		flags.Parse(rest)
+		pod = flags.Arg(0)
+	}
+	if pod == "" {
+		fmt.Println(usage)
+		flags.PrintDefaults()
+		return 2
+	}
+	if *localPort == 0 {
+		*localPort = *port
+	}
+	kubectl := func(args ...string) *exec.Cmd {
+		if *namespace != "" {
+			args = append([]string{"-n", *namespace}, args...)
+		}
+		return exec.Command("kubectl", args...)
+	}
+	if *container == "" {
+		out, err := kubectl("get", "pod", pod, "-o", "jsonpath={.spec.containers[0].name}").Output()
+		if err != nil {
+			fmt.Println(commandError(err))
+			return 1
+		}
+		*container = strings.TrimSpace(string(out))
+	}
+
+	// The server only listens on the pod's loopback, which the
+	// port-forward reaches and other workloads don't, and takes only
+	// clients with the token.
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	token := hex.EncodeToString(secret)
+	serve := []string{"serve", "-dap", "127.0.0.1:" + strconv.Itoa(*port), "-token", token}
+	var children []*exec.Cmd
+	exited := make(chan *exec.Cmd, 2)
+	start := func(c *exec.Cmd) error {
+		c.Stdout, c.Stderr = os.Stdout, os.Stderr
+		if err := c.Start(); err != nil {
+			return err
+		}
+		children = append(children, c)
+		go func() {
+			c.Wait()
+			exited <- c
+		}()
+		return nil
+	}
+	// The server and the port-forward end with the helper.
+	defer func() {
+		for _, c := range children {
+			c.Process.Signal(syscall.SIGTERM)
+		}
+		for range children {
+			<-exited
+		}
+	}()
+	if *image != "" {
+		b := make([]byte, 4)
+		rand.Read(b)
+		name := "dedebugger-" + hex.EncodeToString(b)
+		// The general profile grants the ptrace capability.
+		debug := kubectl(append([]string{"debug", pod, "--target=" + *container, "--image=" + *image,
+			"--container=" + name, "--profile=general", "--", "dedebugger"}, serve...)...)
+		if out, err := debug.CombinedOutput(); err != nil {
+			fmt.Printf("Can't start the ephemeral container: %s\n", strings.TrimSpace(string(out)))
+			return 1
+		}
+		fmt.Printf("Started ephemeral container %s in %s, next to %s\n", name, pod, *container)
+		if err := waitEphemeral(kubectl, pod, name); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	} else {
+		self, err := os.Executable()
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if out, err := kubectl("cp", "-c", *container, self, pod+":"+k8sDebuggerPath).CombinedOutput(); err != nil {
+			fmt.Printf("Can't copy the debugger into %s: %s\n", *container, strings.TrimSpace(string(out)))
+			return 1
+		}
+		server := kubectl(append([]string{"exec", pod, "-c", *container, "--", k8sDebuggerPath}, serve...)...)
+		if err := start(server); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		fmt.Printf("Started the debugger in %s of %s\n", *container, pod)
+	}
+
+	forward := kubectl("port-forward", "pod/"+pod, fmt.Sprintf("%d:%d", *localPort, *port))
+	if err := start(forward); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("Attach a DAP client to 127.0.0.1:%d with {\"request\": \"attach\", \"processId\": %d, \"token\": %q}; Ctrl-C to stop\n", *localPort, *pid, token)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-sigs:
+	case c := <-exited:
+		fmt.Printf("%s ended\n", strings.Join(c.Args[:min(len(c.Args), 4)], " "))
+		// Put back for the cleanup, which waits for every child.
+		exited <- c
+	}
+	return 0
+}
+
+// waitEphemeral waits for the ephemeral container name of pod to run.
+func waitEphemeral(kubectl func(...string) *exec.Cmd, pod, name string) error {
+	query := fmt.Sprintf(`jsonpath={.status.ephemeralContainerStatuses[?(@.name=="%s")].state}`, name)
+	deadline := time.Now().Add(k8sStartTimeout)
+	for time.Now().Before(deadline) {
+		out, err := kubectl("get", "pod", pod, "-o", query).Output()
+		if err != nil {
+			return commandError(err)
+		}
+		state := string(out)
+		switch {
+		case strings.Contains(state, `"running"`):
+			return nil
+		case strings.Contains(state, `"terminated"`):
+			return fmt.Errorf("the ephemeral container %s ended: %s", name, state)
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("the ephemeral container %s isn't running after %v", name, k8sStartTimeout)
+}
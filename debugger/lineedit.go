@@ -0,0 +1,397 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// maxHistory bounds the commands the line editor remembers.
+const maxHistory = 1000
+
+// lineEditor reads lines from a terminal with editing, history and
+// completion: the arrow keys move in the line and through the history, ^R
+// searches the history, Tab completes the word before the cursor, and the
+// usual Emacs keys work: ^A, ^E, ^B, ^F, ^K, ^U, ^W and ^D. The history is kept in histFile
+// across sessions.
+type lineEditor struct {
+	in       *os.File
+	out      io.Writer
+	history  []string
+	histFile string
+	loaded   bool
+	// complete returns the completions of the last word of a line.
+	complete func(line string) []string
+}
+
+// newLineEditor returns an editor for the terminal in, with the history in
+// ~/.dedebugger_history.
+func newLineEditor(in *os.File, out io.Writer) *lineEditor {
+	e := &lineEditor{in: in, out: out}
+	if home, err := os.UserHomeDir(); err == nil {
+		e.histFile = filepath.Join(home, ".dedebugger_history")
+	}
+	return e
+}
+
+// loadHistory reads the history of earlier sessions, once.
+func (e *lineEditor) loadHistory() {
+	if e.loaded || e.histFile == "" {
+		return
+	}
+	e.loaded = true
+	f, err := os.Open(e.histFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := s.Text(); line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// remember adds line to the history, unless it repeats the last one.
+func (e *lineEditor) remember(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+	if e.histFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.histFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, line)
+	f.Close()
+}
+
+// expand replaces a history reference at the start of line: "!!" is the
+// last command and "!prefix" the last one starting with prefix. Whatever
+// follows the reference is appended, so "!bt 5" reruns the last bt
+// with a depth. Other lines are returned as they are.
+func (e *lineEditor) expand(line string) (string, error) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "!") {
+		return line, nil
+	}
+	ref, rest, _ := strings.Cut(trimmed[1:], " ")
+	if ref == "" {
+		return "", fmt.Errorf("usage: !! or !<prefix>")
+	}
+	for i := len(e.history) - 1; i >= 0; i-- {
+		if ref == "!" || strings.HasPrefix(e.history[i], ref) {
+			return strings.TrimSpace(e.history[i] + " " + rest), nil
+		}
+	}
+	if ref == "!" {
+		return "", fmt.Errorf("!!: no command in the history")
+	}
+	return "", fmt.Errorf("!%s: no command starts with %s", ref, ref)
+}
+
+// readLine shows prompt and reads a line. If keep is set, a history
+// reference starting it is expanded and the line is added to the history.
+// It returns false at the end of input: ^D on an empty line.
+func (e *lineEditor) readLine(prompt string, keep bool) (string, bool) {
+	e.loadHistory()
+	fd := int(e.in.Fd())
+	old, err := getTermios(fd)
+	if err != nil {
+		return "", false
+	}
+	raw := *old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN], raw.Cc[syscall.VTIME] = 1, 0
+	if err := setTermios(fd, &raw); err != nil {
+		return "", false
+	}
+	defer setTermios(fd, old)
+
+	fmt.Fprint(e.out, prompt)
+	// Redrawing only rewrites the last line of the prompt.
+	if i := strings.LastIndexByte(prompt, '\n'); i >= 0 {
+		prompt = prompt[i+1:]
+	}
+	var line []rune
+	pos := 0
+	hist := len(e.history)
+	var edited string // the line being typed while browsing the history
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	insert := func(s string) {
+		r := []rune(s)
+		line = append(line[:pos], append(r, line[pos:]...)...)
+		pos += len(r)
+	}
+
+	for {
+		c, err := e.readRune()
+		if err != nil {
+			fmt.Fprintln(e.out)
+			return string(line), len(line) > 0
+		}
+		switch c {
+		case '\r', '\n':
+			fmt.Fprintln(e.out)
+			text := string(line)
+			if keep {
+				expanded, err := e.expand(text)
+				if err != nil {
+					fmt.Fprintln(e.out, err)
+					line, pos = nil, 0
+					break
+				}
+				if expanded != text {
+					fmt.Fprintln(e.out, expanded)
+				}
+				text = expanded
+				e.remember(text)
+			}
+			return text, true
+		case 4: // ^D
+			if len(line) == 0 {
+				fmt.Fprintln(e.out)
+				return "", false
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 1: // ^A
+			pos = 0
+		case 5: // ^E
+			pos = len(line)
+		case 2: // ^B
+			pos = max(pos-1, 0)
+		case 6: // ^F
+			pos = min(pos+1, len(line))
+		case 11: // ^K
+			line = line[:pos]
+		case 21: // ^U
+			line, pos = line[pos:], 0
+		case 23: // ^W
+			start := pos
+			for start > 0 && line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && line[start-1] != ' ' {
+				start--
+			}
+			line, pos = append(line[:start], line[pos:]...), start
+		case 127, 8: // Backspace
+			if pos > 0 {
+				line, pos = append(line[:pos-1], line[pos:]...), pos-1
+			}
+		case '\t':
+			e.completeWord(&line, &pos)
+		case 18: // ^R
+			var run bool
+			line, run = e.searchHistory(prompt, line)
+			pos = len(line)
+			if run {
+				fmt.Fprintf(e.out, "\r%s%s\x1b[K\n", prompt, string(line))
+				text := string(line)
+				if keep {
+					e.remember(text)
+				}
+				return text, true
+			}
+		case 27: // an escape sequence: arrows, Home, End and Delete
+			seq := e.readEscape()
+			switch seq {
+			case "[A", "OA", "[B", "OB":
+				if hist == len(e.history) {
+					edited = string(line)
+				}
+				if seq[1] == 'A' && hist > 0 {
+					hist--
+				} else if seq[1] == 'B' && hist < len(e.history) {
+					hist++
+				}
+				if hist == len(e.history) {
+					line = []rune(edited)
+				} else {
+					line = []rune(e.history[hist])
+				}
+				pos = len(line)
+			case "[C", "OC":
+				pos = min(pos+1, len(line))
+			case "[D", "OD":
+				pos = max(pos-1, 0)
+			case "[H", "OH", "[1~", "[7~":
+				pos = 0
+			case "[F", "OF", "[4~", "[8~":
+				pos = len(line)
+			case "[3~":
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if c >= ' ' && c != utf8.RuneError {
+				insert(string(c))
+			}
+		}
+		redraw()
+	}
+}
+
+// searchHistory searches the history backwards as the search is typed,
+// from ^R at prompt while line was being typed. ^R again goes on to an
+// older match and Backspace shortens the search. Enter takes the match to
+// be run, ^G gives up, returning line, and any other key takes the match
+// to be edited. It reports whether the line is to be run.
+func (e *lineEditor) searchHistory(prompt string, line []rune) ([]rune, bool) {
+	var search []rune
+	at := len(e.history) // the index of the match
+	match := string(line)
+	failed := false
+	find := func(from int) {
+		for i := min(from, len(e.history)-1); i >= 0; i-- {
+			if strings.Contains(e.history[i], string(search)) {
+				at, match, failed = i, e.history[i], false
+				return
+			}
+		}
+		failed = true
+	}
+	for {
+		label := "reverse-i-search"
+		if failed {
+			label = "failing " + label
+		}
+		fmt.Fprintf(e.out, "\r(%s)`%s': %s\x1b[K", label, string(search), match)
+		c, err := e.readRune()
+		if err != nil {
+			return []rune(match), false
+		}
+		switch {
+		case c == 18: // ^R
+			find(at - 1)
+		case c == 7: // ^G
+			fmt.Fprintf(e.out, "\r%s\x1b[K", prompt)
+			return line, false
+		case c == '\r' || c == '\n':
+			return []rune(match), true
+		case c == 127 || c == 8: // Backspace
+			if len(search) > 0 {
+				search = search[:len(search)-1]
+				find(len(e.history) - 1)
+			}
+		case c >= ' ' && c != utf8.RuneError:
+			search = append(search, c)
+			find(at)
+		default:
+			if c == 27 {
+				e.readEscape()
+			}
+			return []rune(match), false
+		}
+	}
+}
+
+// readByte reads a byte from the terminal. Nothing is buffered, so what
+// follows a line is left for whoever reads next.
+func (e *lineEditor) readByte() (byte, error) {
+	var b [1]byte
+	if _, err := e.in.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readRune reads a character typed in UTF-8.
+func (e *lineEditor) readRune() (rune, error) {
+	b, err := e.readByte()
+	if err != nil || b < utf8.RuneSelf {
+		return rune(b), err
+	}
+	buf := []byte{b}
+	for !utf8.FullRune(buf) {
+		if b, err = e.readByte(); err != nil {
+			return 0, err
+		}
+		buf = append(buf, b)
+	}
+	r, _ := utf8.DecodeRune(buf)
+	return r, nil
+}
+
+// readEscape reads the rest of an escape sequence after ESC: a letter after
+// O, or parameters and a final byte after [.
+func (e *lineEditor) readEscape() string {
+	b, err := e.readByte()
+	if err != nil || b != '[' && b != 'O' {
+		return ""
+	}
+	seq := []byte{b}
+	for {
+		c, err := e.readByte()
+		if err != nil {
+			return string(seq)
+		}
+		seq = append(seq, c)
+		if b == 'O' || c >= 0x40 && c <= 0x7e {
+			return string(seq)
+		}
+	}
+}
+
+// completeWord completes the word before the cursor: a single completion
+// is inserted, with a space after it, several are completed to what they
+// have in common, and listed when that adds nothing.
+func (e *lineEditor) completeWord(line *[]rune, pos *int) {
+	if e.complete == nil {
+		return
+	}
+	before := string((*line)[:*pos])
+	matches := e.complete(before)
+	if len(matches) == 0 {
+		return
+	}
+	word := before[strings.LastIndexByte(before, ' ')+1:]
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	add := strings.TrimPrefix(common, word)
+	if len(matches) == 1 {
+		add += " "
+	}
+	if add != "" {
+		r := []rune(add)
+		*line = append((*line)[:*pos], append(r, (*line)[*pos:]...)...)
+		*pos += len(r)
+		return
+	}
+	const shown = 50
+	fmt.Fprintln(e.out)
+	fmt.Fprintln(e.out, strings.Join(matches[:min(len(matches), shown)], "  "))
+	if len(matches) > shown {
+		fmt.Fprintf(e.out, "... %d more\n", len(matches)-shown)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/gosym"
+	"fmt"
+)
+
+// Location is where a PC is in the source of the target. PCs outside the
+// Go code of the target and its plugins, such as in the dynamic loader, a C
+// library or the vdso, have no function, file or line.
+type Location struct {
+	PC   uint64
+	Func string
+	File string
+	Line int
+}
+
+// LookupPC looks up pc in the symbol tables. It never fails: an unknown PC
+// gives a Location with just the PC.
+func (d *Debugger) LookupPC(pc uint64) Location {
+	l := Location{PC: pc}
+	var fn *gosym.Func
+	l.File, l.Line, fn = d.pcToLine(pc)
+	if fn == nil {
+		return Location{PC: pc}
+	}
+	l.Func = fn.Name
+	return l
+}
+
+// Known reports whether l is in Go code.
+func (l Location) Known() bool {
+	return l.Func != ""
+}
+
+// String renders l as "main.run line 12 in /src/main.go", or as
+// "0x7f12c4a0 <unknown>" for a PC without function.
+func (l Location) String() string {
+	if !l.Known() {
+		return unknownPC(l.PC)
+	}
+	return fmt.Sprintf("%s line %d in %s", l.Func, l.Line, l.File)
+}
+
+// unknownPC renders a PC without function.
+func unknownPC(pc uint64) string {
+	return fmt.Sprintf("%#x <unknown>", pc)
+}
+
+// String renders f as its Location.
+func (f Frame) String() string {
+	return f.Location.String()
+}
+
+// where renders the location of ev as the stop banners show it: "main.run
+// at 12 in /src/main.go", or "0x7f12c4a0 <unknown>".
+func (ev Event) where() string {
+	if ev.Func == "" {
+		return ev.PC + " <unknown>"
+	}
+	return fmt.Sprintf("%s at %d in %s", ev.Func, ev.Line, ev.File)
+}
@@ -0,0 +1,90 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// OpenLogOutput sends the lines of tracepoints and logpoints to dest
+// instead of the console:
+//
+//	<file>         appended to the file
+//	unix:<path>    written to a Unix socket, stream or datagram
+//	syslog         the local syslog, tagged dedebugger
+//	json:<file>    JSON records appended to the file, through slog
+//	console        back to the console
+//
+// The previous output is closed.
+func (d *Debugger) OpenLogOutput(dest string) error {
+	var w io.WriteCloser
+	var logger *slog.Logger
+	switch kind, path, _ := strings.Cut(dest, ":"); {
+	case dest == "" || dest == "console":
+	case dest == "syslog":
+		sw, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "dedebugger")
+		if err != nil {
+			return fmt.Errorf("opening syslog: %w", err)
+		}
+		w = sw
+	case kind == "unix":
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			var err2 error
+			if conn, err2 = net.Dial("unixgram", path); err2 != nil {
+				return fmt.Errorf("connecting to %s: %w", path, err)
+			}
+		}
+		w = conn
+	case kind == "json":
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		w, logger = f, slog.New(slog.NewJSONHandler(f, nil))
+	default:
+		f, err := os.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	d.closeLogOutput()
+	d.logOut, d.Logger = w, logger
+	return nil
+}
+
+func (d *Debugger) closeLogOutput() {
+	if d.logOut != nil {
+		d.logOut.Close()
+	}
+	d.logOut, d.Logger = nil, nil
+}
+
+// logHit logs the hit of the tracepoint b: to Logger as a record with the
+// location, the breakpoint and the hit number as attributes, to the log
+// output as a line, or else to the console. An output that fails is closed,
+// and the console takes over.
+func (d *Debugger) logHit(b *Breakpoint, hit Event) {
+	if d.Logger != nil {
+		d.Logger.Info(hit.Detail, "func", hit.Func, "file", b.File, "line", b.Line, "breakpoint", b.ID, "hit", b.Hits)
+		return
+	}
+	line := strings.TrimSpace(fmt.Sprintf("%s %s %s:%d %s", time.Now().Format("15:04:05.000"), hit.Func, b.File, b.Line, hit.Detail))
+	if d.logOut != nil {
+		_, err := io.WriteString(d.logOut, line+"\n")
+		if err == nil {
+			return
+		}
+		d.printf("Logging to the console from now on: %v\n", err)
+		d.closeLogOutput()
+	}
+	d.println(line)
+}
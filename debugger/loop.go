@@ -0,0 +1,305 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"syscall"
+)
+
+// runState is the state of the target in the event loop.
+//
+//	Running --stop handler reports a reason--> Stopped --prompt--> Running
+//	Running --main thread exits--> Exited
+//	Running, Stopped --target killed or lost--> Exited
+//
+// Stops no handler reports, such as new threads or signals, are resumed
+// without leaving Running.
+type runState int
+
+const (
+	// stateRunning waits for the next event of any thread.
+	stateRunning runState = iota
+	// stateStopped reports a stop to the user and resumes the target as
+	// they ask.
+	stateStopped
+	// stateExited reports the exit of the target and ends the loop.
+	stateExited
+)
+
+// stopHandler looks at a ptrace-stop of thread pid. If the stop is its
+// kind it reports handled, along with the reason to stop at the prompt, or
+// an empty reason after resuming the thread itself. An error ends the
+// session.
+type stopHandler func(d *Debugger, pid int) (reason string, handled bool, err error)
+
+// stopHandlers are consulted in order for every ptrace-stop. New kinds of
+// stops are added here rather than to the loop.
+var stopHandlers []stopHandler
+
+func init() {
+	stopHandlers = []stopHandler{
+		(*Debugger).traceeStop,
+		(*Debugger).trapStop,
+		(*Debugger).watchStop,
+		(*Debugger).interruptStop,
+		(*Debugger).canceledSignal,
+		(*Debugger).signalStop,
+		(*Debugger).lineStepSignal,
+		(*Debugger).raceStepSignal,
+	}
+}
+
+// eventLoop runs the state machine for the target pid, whose threads are in
+// the process group pgid, until it exits or a ptrace request fails. A
+// request failing because the target is gone ends it as an exit.
+func (d *Debugger) eventLoop(pid, pgid int) error {
+	state, wpid, reason := stateRunning, 0, ""
+	var err error
+	for {
+		switch state {
+		case stateRunning:
+			state, wpid, reason, err = d.waitEvent(pid, pgid)
+		case stateStopped:
+			err = d.stopAndPrompt(wpid, reason)
+			state = stateRunning
+			if errors.Is(err, errRestart) {
+				d.killTarget(pgid, false)
+			}
+		case stateExited:
+			return d.targetExited(wpid, pgid)
+		}
+		if err != nil && !errors.Is(err, errRestart) && d.lostTarget(pid, pgid, err) {
+			state, wpid, err = stateExited, pid, nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// targetExited ends the session of the target pid, in process group pgid,
+// which exited, or restarts it as the exit policy or the user asks.
+func (d *Debugger) targetExited(pid, pgid int) error {
+	d.endUntil()
+	d.emitExit(pid, d.Ws)
+	d.CheckSchedTrace(true)
+	d.reportStats(d.stats.exitCPU)
+	if d.saveOnExit != "" {
+		if err := d.SaveSession(d.saveOnExit); err != nil {
+			d.println(err)
+		} else {
+			d.storeArtifact(d.saveOnExit)
+		}
+	}
+	if d.runExitPolicy(pid, exitEvent(pid, d.Ws)) || d.restartAfterExit() {
+		d.killTarget(pgid, true)
+		return errRestart
+	}
+	return nil
+}
+
+// waitEvent waits for the next event of the target and returns the state
+// it leads to, with the thread and reason of a stop.
+func (d *Debugger) waitEvent(pid, pgid int) (runState, int, string, error) {
+	var ru syscall.Rusage
+	wpid, held := d.nextHeldEvent()
+	if !held {
+		var err error
+		if wpid, err = d.waitTarget(pid, pgid, &ru); err != nil {
+			return stateRunning, 0, "", fmt.Errorf("waiting for the target: %w", err)
+		}
+	}
+
+	switch {
+	case d.Ws.Exited() || d.Ws.Signaled():
+		if wpid == pid {
+			d.stats.exitCPU = rusageCPUTime(&ru)
+			return stateExited, wpid, "", nil
+		}
+		// Thread ids are reused.
+		delete(d.debugRegsSynced, wpid)
+		d.exitTracee(wpid)
+	case d.Ws.Stopped():
+		if err := d.syncDebugRegs(wpid); err != nil {
+			d.println(err)
+		}
+		for _, h := range stopHandlers {
+			reason, ok, err := h(d, wpid)
+			switch {
+			case err != nil:
+				return stateRunning, wpid, "", err
+			case !ok:
+				continue
+			case reason == "":
+				return stateRunning, wpid, "", nil
+			}
+			return stateStopped, wpid, reason, nil
+		}
+		if err := d.passSignal(wpid); err != nil {
+			return stateRunning, wpid, "", err
+		}
+	}
+	return stateRunning, wpid, "", nil
+}
+
+// trapStop handles SIGTRAPs: single steps, breakpoints, group breakpoints,
+// catchpoints, data watch hits, traced calls and the traps of next, finish
+// and data watches.
+func (d *Debugger) trapStop(pid int) (string, bool, error) {
+	if d.Ws.StopSignal() != syscall.SIGTRAP || d.Ws.TrapCause() != 0 {
+		return "", false, nil
+	}
+
+	if err := d.proc().GetRegs(pid, &d.Regs); err != nil {
+		return "", true, wrapPtrace("reading registers of", pid, err)
+	}
+	if d.rearm {
+		// A single step moved us off a rewound trap.
+		d.rearmTrap(pid)
+	}
+
+	if w := d.dataWatchHit(pid); w != nil {
+		return d.dataWatchStop(pid, w)
+	}
+	if !d.hitTrap(pid) {
+		// A single step, which leaves the PC on the next instruction.
+		if reason, ok, err := d.raceStepStop(pid); ok {
+			return reason, true, err
+		}
+		if reason, ok, err := d.lineStepStop(pid); ok {
+			return reason, true, err
+		}
+		return "step", true, nil
+	}
+
+	// The PC is past the trap. Every hit is rewound onto the trap, which
+	// resume steps over and re-arms, so breakpoints stay armed.
+	addr := d.trapAddr()
+	// A traced call is logged first, in case a breakpoint or another trap
+	// shares the entry.
+	traced := d.traceCall(pid, addr)
+	// And so is the return of a call whose returns are traced.
+	returned := d.traceReturn(pid, addr)
+	// So are the iterations of a benchmark.
+	counted, atIter := d.countIteration(pid, addr)
+	if atIter {
+		if err := d.rewindTrap(pid, addr); err != nil {
+			return "", true, err
+		}
+		return "breakpoint", true, nil
+	}
+	if cs := d.catchesAt(addr); len(cs) > 0 {
+		stop, err := d.handleCatches(pid, addr, cs)
+		if err != nil {
+			return "", true, err
+		}
+		if !stop {
+			return "", true, d.resume(pid, true)
+		}
+		return "catch", true, nil
+	}
+	if t := d.findGroupTrap(addr); t != nil {
+		if err := d.rewindTrap(pid, t.addr); err != nil {
+			return "", true, err
+		}
+		d.countHit(fmt.Sprintf("%s:%d", t.file, t.line))
+		d.printf("Breakpoint of group %s at %s:%d\n", t.group.Name, t.file, t.line)
+		return "breakpoint", true, nil
+	}
+	if b := d.Breakpoints[addr]; b != nil && b.Enabled {
+		if err := d.rewindTrap(pid, addr); err != nil {
+			return "", true, err
+		}
+		if !d.countBreakpointHit(pid, b) {
+			return "", true, d.resume(pid, true)
+		}
+		kind := "Breakpoint"
+		if b.Temporary {
+			kind = "Temporary breakpoint"
+			d.RemoveBreakpoint(b.ID)
+		}
+		switch {
+		case b.ID == d.until:
+			// Reached the location of "until", which is all it says.
+		case b.HitCond != nil:
+			d.printf("%s %d hit %d (%s)\n", kind, b.ID, b.Hits, b.HitCond.Text)
+		default:
+			d.printf("%s %d hit %d\n", kind, b.ID, b.Hits)
+		}
+		if len(b.Commands) > 0 && d.runRule(pid, b) {
+			return "", true, d.resume(pid, true)
+		}
+		return "breakpoint", true, nil
+	}
+	if reason, ok, err := d.stepStop(pid, addr); ok {
+		return reason, true, err
+	}
+	if reason, ok, err := d.watchTrapStop(pid, addr); ok {
+		return reason, true, err
+	}
+	if reason, ok, err := d.mainStop(pid, addr); ok {
+		return reason, true, err
+	}
+	if reason, ok, err := d.pluginStop(pid, addr); ok {
+		return reason, true, err
+	}
+	if reason, ok, err := d.fatalStop(pid, addr); ok {
+		return reason, true, err
+	}
+	if reason, ok, err := d.raceStop(pid, addr); ok {
+		return reason, true, err
+	}
+	if traced || counted || returned {
+		return "", true, d.ResumeFromBreakpoint(pid)
+	}
+	// A trap compiled into the target, such as runtime.Breakpoint.
+	return "step", true, nil
+}
+
+// countBreakpointHit counts a hit of the enabled breakpoint b, its trap
+// rewound, and reports whether the target stops for it. Hits left out by
+// sampling go on at once, nothing being read or reported for them. Ignored
+// hits and those the conditions reject are passed over, and counting,
+// tracing and collecting breakpoints never stop; the others are emitted as
+// hit events.
+func (d *Debugger) countBreakpointHit(pid int, b *Breakpoint) bool {
+	d.countHit(b.String())
+	b.Hits++
+	if b.Sample > 1 && b.Hits%b.Sample != 0 {
+		return false
+	}
+	pass := b.Ignore > 0 || b.HitCond != nil && !b.HitCond.Match(b.Hits) || !d.condHolds(pid, b)
+	if b.Ignore > 0 {
+		b.Ignore--
+	}
+	if pass || b.Mode != ModeStop || b.HitCond != nil || b.Cond != "" || len(b.Commands) > 0 || b.Returns {
+		hit := d.locationEvent("hit", pid, b.Addr)
+		hit.Hits = b.Hits
+		if !pass && b.Mode == ModeCollect {
+			hit.Values = maps.Clone(d.collect(pid, b).Values)
+		}
+		if !pass && b.Mode == ModeTrace {
+			hit.Detail = d.traceMessage(pid, b)
+			d.logHit(b, hit)
+		}
+		if !pass && b.Returns {
+			d.planReturn(pid, b, d.recordCall(pid, b.Addr))
+		}
+		d.Emit(hit)
+	}
+	return !pass && b.Mode == ModeStop
+}
+
+// watchStop handles the SIGSTOPs watch pollers send when a condition holds.
+func (d *Debugger) watchStop(pid int) (string, bool, error) {
+	if d.Ws.StopSignal() != syscall.SIGSTOP || !d.checkWatches() {
+		return "", false, nil
+	}
+	if err := d.proc().GetRegs(pid, &d.Regs); err != nil {
+		return "", true, wrapPtrace("reading registers of", pid, err)
+	}
+	return "watch", true, nil
+}
@@ -0,0 +1,77 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// goneGrace is how long a target that a ptrace request found missing is
+// given to finish dying: a SIGKILL takes a thread out of its ptrace-stop
+// at once, but it takes a moment to exit.
+const goneGrace = 100 * time.Millisecond
+
+// processGone reports whether the process pid has ended, leaving at most
+// its zombie for the tracer to reap.
+func processGone(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	// The command name may contain spaces; the state follows it.
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] == "Z" || fields[0] == "X"
+}
+
+// lostTarget handles err, which ended the handling of an event of the
+// target pid, if it failed because the target is gone: killed from outside
+// while stopped, or lost to the debugger, so that ptrace requests fail with
+// ESRCH and waits with ECHILD. The threads and processes left are reaped,
+// so no zombies stay behind, and the exit is left in d.Ws for the loop to
+// end the session as for any exit. It reports false for errors of a target
+// that still runs.
+func (d *Debugger) lostTarget(pid, pgid int, err error) bool {
+	if !errors.Is(err, ErrNotStopped) && !errors.Is(err, syscall.ESRCH) && !errors.Is(err, syscall.ECHILD) {
+		return false
+	}
+	for start := time.Now(); !processGone(pid); time.Sleep(goneGrace / 10) {
+		if time.Since(start) > goneGrace {
+			return false
+		}
+	}
+	for {
+		var ws syscall.WaitStatus
+		wpid, werr := syscall.Wait4(-pgid, &ws, syscall.WALL, nil)
+		if werr != nil {
+			// Another tracer or parent reaped it: the exit status is
+			// lost with it.
+			d.printf("Lost the target: %v; its exit status is unknown\n", err)
+			d.tracees = nil
+			return true
+		}
+		if !ws.Exited() && !ws.Signaled() {
+			// A thread stopping on its way out, or a process it forked,
+			// which goes on without the debugger.
+			syscall.PtraceDetach(wpid)
+			continue
+		}
+		d.Ws = ws
+		if wpid == pid {
+			break
+		}
+		delete(d.debugRegsSynced, wpid)
+		d.exitTracee(wpid)
+	}
+	if d.Ws.Signaled() {
+		d.printf("The target was killed by signal %d, %v\n", d.Ws.Signal(), d.Ws.Signal())
+	} else {
+		d.printf("The target exited with status %d\n", d.Ws.ExitStatus())
+	}
+	d.tracees = nil
+	return true
+}
@@ -0,0 +1,153 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Macro is a command defined with "define": a list of commands run in turn
+// when it is called by name. In the body, $1 to $9 stand for the arguments
+// of the call and $* for all of them.
+type Macro struct {
+	Name string
+	Body []string
+}
+
+// maxMacroDepth bounds macros calling macros, which catches recursion.
+const maxMacroDepth = 16
+
+// defineCommand handles "define <name>", which reads the body of the macro
+// from the prompt up to a line "end". "define" alone lists the macros.
+func (d *Debugger) defineCommand(pid int, args []string) bool {
+	if len(args) == 0 {
+		d.listMacros()
+		return false
+	}
+	err := d.defineMacro(args, func() (string, bool) {
+		return d.UI.PromptCommand("a command of " + args[0] + ", or end")
+	})
+	if err != nil {
+		d.println(err)
+	}
+	return false
+}
+
+// defineMacro defines the macro named in args with the lines next returns,
+// up to "end". Blank lines and lines starting with # are skipped.
+func (d *Debugger) defineMacro(args []string, next func() (string, bool)) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: define <name>, then the commands and end")
+	}
+	name := strings.ToLower(args[0])
+	_, word := wordCommands[name]
+	_, resume := resumeCommands[name]
+	_, alias := commandAliases[name]
+	if word || resume || alias || len(name) == 1 {
+		return fmt.Errorf("can't redefine the command %s", name)
+	}
+
+	var body []string
+	for {
+		line, ok := next()
+		if !ok {
+			return fmt.Errorf("define %s: missing end", name)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "end":
+			if d.macros == nil {
+				d.macros = make(map[string]*Macro)
+			}
+			d.macros[name] = &Macro{Name: name, Body: body}
+			return nil
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			body = append(body, line)
+		}
+	}
+}
+
+func (d *Debugger) listMacros() {
+	if len(d.macros) == 0 {
+		d.println("No macros")
+		return
+	}
+	names := make([]string, 0, len(d.macros))
+	for name := range d.macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d.printf("  %s: %s\n", name, strings.Join(d.macros[name].Body, "; "))
+	}
+}
+
+// isCommand reports whether name, in lower case, is a word command or a
+// macro. A format after a slash is ignored.
+func (d *Debugger) isCommand(name string) bool {
+	name, _, _ = strings.Cut(strings.Fields(d.expandAlias(name))[0], "/")
+	_, ok := wordCommands[name]
+	return ok || d.macros[name] != nil
+}
+
+// runMacro runs the body of m with args on the stopped thread pid. A line
+// "continue" ends it early. It reports whether the target should be
+// continued.
+func (d *Debugger) runMacro(pid int, m *Macro, args []string) bool {
+	if d.macroDepth >= maxMacroDepth {
+		d.printf("%s: macros nested more than %d deep\n", m.Name, maxMacroDepth)
+		return false
+	}
+	d.macroDepth++
+	defer func() { d.macroDepth-- }()
+
+	for _, line := range m.Body {
+		line, err := expandMacroArgs(line, args)
+		if err != nil {
+			d.printf("%s: %v\n", m.Name, err)
+			return false
+		}
+		if isContinue(line) {
+			return true
+		}
+		found, cont := d.wordCommand(pid, line)
+		if !found {
+			d.printf("%s: unknown command %q\n", m.Name, line)
+			return false
+		}
+		if cont {
+			return true
+		}
+	}
+	return false
+}
+
+// expandMacroArgs replaces $1 to $9 and $* in line with args.
+func expandMacroArgs(line string, args []string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] != '$' || i+1 == len(line) {
+			b.WriteByte(line[i])
+			continue
+		}
+		switch c := line[i+1]; {
+		case c == '*':
+			b.WriteString(strings.Join(args, " "))
+		case c >= '1' && c <= '9':
+			n, _ := strconv.Atoi(string(c))
+			if n > len(args) {
+				return "", fmt.Errorf("$%d used but %d arguments given", n, len(args))
+			}
+			b.WriteString(args[n-1])
+		default:
+			b.WriteByte('$')
+			continue
+		}
+		i++
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,179 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// mapAccess follows the writes of a map caught with "catch mapaccess". A
+// write flips the writing flag of the map header when it starts and again
+// when it ends; writer is the goroutine between the two, 0 if none, and
+// writerTid the thread it started on. last is the goroutine of the last
+// write. skipTid is a thread whose pending hit, the end of a write, was
+// accounted for before it was handled.
+type mapAccess struct {
+	writer, last uint64
+	writerTid    int
+	skipTid      int
+}
+
+// CatchMapAccess watches the writing flag of the map expr evaluates to, in
+// the target stopped at pid, with a debug register. The target stops when
+// a goroutine starts or ends a write while another one writes, before the
+// runtime finds out and throws "concurrent map writes". Remove it like a
+// data watch.
+func (d *Debugger) CatchMapAccess(pid int, expr string) (*DataWatch, error) {
+	if d.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	val, err := d.evalValue(pid, expr)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := stripTypedefs(val.typ).(*dwarf.PtrType)
+	if !ok || !strings.HasPrefix(typeName(p.Type), "map<") {
+		return nil, fmt.Errorf("%s is not a map", expr)
+	}
+	hdr := bufUint(val.buf, 8)
+	if hdr == 0 {
+		return nil, fmt.Errorf("%s is a nil map", expr)
+	}
+	off, err := fieldOffset(p.Type, []string{"writing"})
+	if err != nil {
+		return nil, fmt.Errorf("unknown map layout: %v", err)
+	}
+	addr := hdr + uint64(off)
+	buf := make([]byte, 1)
+	if err := d.ReadMemory(pid, addr, buf); err != nil {
+		return nil, err
+	}
+
+	// The header is followed if it is on a stack that is copied, but not
+	// if expr is later set to another map.
+	w := &DataWatch{Expr: expr, Addr: addr, Size: 1, old: buf, access: &mapAccess{}}
+	if err := d.armDataWatch(pid, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// mapAccessStop handles a flip of the writing flag watched by w by the
+// thread pid. The flips of one writer at a time resume the target; one by
+// another goroutine stops it, with the stacks of both.
+func (d *Debugger) mapAccessStop(pid int, w *DataWatch) (string, bool, error) {
+	a := w.access
+	if pid == a.skipTid {
+		a.skipTid = 0
+		return "", true, d.resume(pid, true)
+	}
+	g := d.currentGoid(pid)
+	switch {
+	case a.writer == 0:
+		a.writer, a.writerTid, a.last = g, pid, g
+		return "", true, d.resume(pid, true)
+	case a.writer == g:
+		a.writer = 0
+		return "", true, d.resume(pid, true)
+	}
+
+	// The hits of two threads are handled in either order: the writer's
+	// thread may have ended its write already, its hit still to come.
+	// The writer is held where it is to find out.
+	if d.writeEnded(a.writerTid) {
+		a.writer, a.writerTid, a.last, a.skipTid = g, pid, g, a.writerTid
+		return "", true, d.resume(pid, true)
+	}
+	d.stopThreads(pid)
+	d.printf("Watchpoint %d: concurrent writes of %s by goroutines %d and %d\n", w.ID, w.Expr, g, a.writer)
+	frames, err := d.Stacktrace(pid, &d.Regs, maxGoroutineFrames)
+	d.showGoroutineFrames(g, frames, err)
+	d.showWriter(pid, a.writer)
+	// The flag no longer tells the writers apart; it is followed afresh.
+	a.writer = 0
+	return "watchpoint", true, nil
+}
+
+// writeEnded reports whether the thread tid has a data watch hit yet to be
+// handled. It is stopped to find out: kept stopped with its hit for the
+// event loop, or continued again.
+func (d *Debugger) writeEnded(tid int) bool {
+	t := d.tracees[tid]
+	if t == nil || t.held || t.starting {
+		return false
+	}
+	if !t.stopPending {
+		if syscall.Tgkill(t.proc, tid, syscall.SIGSTOP) != nil {
+			return false
+		}
+		d.holdThread(t)
+		if t.held {
+			t.held = false
+			if err := d.proc().Cont(tid, 0); err != nil {
+				d.println(wrapPtrace("continuing", tid, err))
+			}
+			return false
+		}
+	}
+	for _, ev := range d.heldEvents {
+		if ev.tid == tid && ev.ws.Stopped() && ev.ws.StopSignal() == syscall.SIGTRAP {
+			code, ok := d.proc().SigCode(tid)
+			return ok && code == trapHWBreakpoint
+		}
+	}
+	return false
+}
+
+// showMapWriters prints the stacks of the goroutines writing the maps
+// caught with "catch mapaccess", for a fatal error the runtime raised when
+// the goroutine of the thread pid accessed one of them meanwhile. The
+// writer may have ended its write before the error was handled; the last
+// one is shown then.
+func (d *Debugger) showMapWriters(pid int) {
+	for _, w := range d.DataWatches {
+		switch a := w.access; {
+		case a == nil || a.last == 0:
+			continue
+		case a.writer != 0:
+			d.printf("Watchpoint %d: %s is being written by goroutine %d\n", w.ID, w.Expr, a.writer)
+		default:
+			d.printf("Watchpoint %d: %s was last written by goroutine %d\n", w.ID, w.Expr, a.last)
+		}
+		d.showWriter(pid, w.access.last)
+	}
+}
+
+// showWriter prints the stack of goroutine id, a writer of a map, found by
+// the registers of its thread if it is stopped.
+func (d *Debugger) showWriter(pid int, id uint64) {
+	gs, err := d.Goroutines(pid)
+	if err != nil {
+		d.println(err)
+		return
+	}
+	for _, g := range gs {
+		if g.ID != id {
+			continue
+		}
+		frames, err := d.GoroutineStack(pid, g, maxGoroutineFrames)
+		d.showGoroutineFrames(g.ID, frames, err)
+		return
+	}
+	d.printf("Goroutine %d is gone\n", id)
+}
+
+// showGoroutineFrames prints the backtrace of goroutine id.
+func (d *Debugger) showGoroutineFrames(id uint64, frames []Frame, err error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Goroutine %d:\n", id)
+	for i, f := range frames {
+		fmt.Fprintf(&b, "  #%d %s\n", i, f)
+	}
+	d.UI.ShowOutput(b.String())
+	if err != nil {
+		d.println(" ", err)
+	}
+}
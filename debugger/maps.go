@@ -0,0 +1,165 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+)
+
+// Constants of the Swiss table maps of Go 1.24 and later: a group holds
+// mapGroupSlots slots under a control word with a byte for each, whose
+// high bit is clear when the slot is full.
+const (
+	mapGroupSlots = 8
+	mapCtrlEmpty  = 0x80
+)
+
+// mapEntry is a key of a map and its element.
+type mapEntry struct {
+	key, elem value
+}
+
+// mapEntries reads at most max entries of the map of type typ whose
+// pointer is in buf, and returns them with the length of the map. A map is
+// a directory of tables, each an array of groups, or a single group while
+// it is small; the directory repeats tables that split less often than it
+// grew.
+func (d *Debugger) mapEntries(pid int, typ dwarf.Type, buf []byte, max int) ([]mapEntry, int, error) {
+	for {
+		t, ok := typ.(*dwarf.TypedefType)
+		if !ok {
+			break
+		}
+		typ = t.Type
+	}
+	p, ok := typ.(*dwarf.PtrType)
+	if !ok || !strings.HasPrefix(typeName(p.Type), "map<") {
+		return nil, 0, fmt.Errorf("unknown map layout %s", typ)
+	}
+	addr := bufUint(buf, 8)
+	if addr == 0 {
+		return nil, 0, nil
+	}
+	hdr, err := d.readValueAt(pid, addr, p.Type)
+	if err != nil {
+		return nil, 0, err
+	}
+	field := func(name string) uint64 {
+		off, err := fieldOffset(hdr.typ, []string{name})
+		if err != nil {
+			return 0
+		}
+		return bufUint(hdr.buf[off:], 8)
+	}
+	used, dir, dirLen := field("used"), field("dirPtr"), field("dirLen")
+
+	table, err := fieldPointee(p.Type, "dirPtr")
+	if err == nil {
+		table, err = ptrPointee(table)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	group, err := fieldPointee(table, "groups", "data")
+	if err != nil {
+		return nil, 0, err
+	}
+	slots, err := fieldType(group, "slots")
+	if err != nil {
+		return nil, 0, err
+	}
+	for {
+		t, ok := slots.(*dwarf.TypedefType)
+		if !ok {
+			break
+		}
+		slots = t.Type
+	}
+	arr, ok := slots.(*dwarf.ArrayType)
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown map group layout %s", group)
+	}
+	slotsOff, err1 := fieldOffset(group, []string{"slots"})
+	keyOff, err2 := fieldOffset(arr.Type, []string{"key"})
+	elemOff, err3 := fieldOffset(arr.Type, []string{"elem"})
+	keyType, err4 := fieldType(arr.Type, "key")
+	elemType, err5 := fieldType(arr.Type, "elem")
+	for _, err := range []error{err1, err2, err3, err4, err5} {
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	slotSize := arr.Type.Size()
+
+	var entries []mapEntry
+	readGroup := func(addr uint64) error {
+		g, err := d.readValueAt(pid, addr, group)
+		if err != nil {
+			return err
+		}
+		ctrl := bufUint(g.buf, 8)
+		for i := int64(0); i < mapGroupSlots && len(entries) < max; i++ {
+			if byte(ctrl>>(8*i))&mapCtrlEmpty != 0 {
+				continue
+			}
+			slot := slotsOff + i*slotSize
+			k, e := slot+keyOff, slot+elemOff
+			entries = append(entries, mapEntry{
+				key:  value{keyType, g.buf[k : k+keyType.Size()], addr + uint64(k)},
+				elem: value{elemType, g.buf[e : e+elemType.Size()], addr + uint64(e)},
+			})
+		}
+		return nil
+	}
+
+	if dirLen == 0 {
+		// A small map: dirPtr points to its only group.
+		return entries, int(used), readGroup(dir)
+	}
+	ptrs := make([]byte, min(dirLen*8, maxValueSize))
+	if err := d.ReadMemory(pid, dir, ptrs); err != nil {
+		return nil, 0, err
+	}
+	groupsOff, err1 := fieldOffset(table, []string{"groups", "data"})
+	maskOff, err2 := fieldOffset(table, []string{"groups", "lengthMask"})
+	if err1 != nil || err2 != nil {
+		return nil, 0, fmt.Errorf("unknown map table layout %s", table)
+	}
+	seen := make(map[uint64]bool)
+	for i := 0; i < len(ptrs) && len(entries) < max; i += 8 {
+		ta := bufUint(ptrs[i:], 8)
+		if ta == 0 || seen[ta] {
+			continue
+		}
+		seen[ta] = true
+		t, err := d.readValueAt(pid, ta, table)
+		if err != nil {
+			return nil, 0, err
+		}
+		groups, mask := bufUint(t.buf[groupsOff:], 8), bufUint(t.buf[maskOff:], 8)
+		for g := uint64(0); g <= mask && len(entries) < max; g++ {
+			if err := readGroup(groups + g*uint64(group.Size())); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return entries, int(used), nil
+}
+
+// ptrPointee returns the type typ points to.
+func ptrPointee(typ dwarf.Type) (dwarf.Type, error) {
+	for {
+		t, ok := typ.(*dwarf.TypedefType)
+		if !ok {
+			break
+		}
+		typ = t.Type
+	}
+	p, ok := typ.(*dwarf.PtrType)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a pointer", typ)
+	}
+	return p.Type, nil
+}
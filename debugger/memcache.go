@@ -0,0 +1,117 @@
+//go:build linux && amd64
+
+package debugger
+
+import "syscall"
+
+// maxCachedPages bounds the memory the page cache holds, 16 MiB with 4 KiB
+// pages; it starts over when full.
+const maxCachedPages = 4096
+
+// pageCache keeps the pages of target memory read at a stop, so printing
+// the same values again, backtraces over the same stack and scripts don't
+// repeat the system calls. It only fills while every thread of the target
+// is held stopped, and is emptied when memory is written and switched off
+// as soon as a thread runs.
+type pageCache struct {
+	on    bool
+	pages map[pageKey][]byte
+	hits  int64 // reads answered from the cache alone
+}
+
+// pageKey is a page of the address space of a thread. Threads of a process
+// share pages, but forked children don't, and a thread id is cheaper to
+// key by than its process.
+type pageKey struct {
+	tid  int
+	addr uint64
+}
+
+// cachePages starts caching the pages read until the target runs again.
+// Threads left running with NonStop change memory at any time, so it is
+// only done when they are all stopped.
+func (d *Debugger) cachePages() {
+	if d.NonStop {
+		return
+	}
+	d.pages.on = true
+}
+
+// dropPages stops caching and forgets the pages read.
+func (d *Debugger) dropPages() {
+	d.pages.on = false
+	d.pages.pages = nil
+}
+
+// cachingController is the ProcessController of the debugger while pages
+// are cached: reads are answered from the cache, filling it a page at a
+// time, writes empty it and resuming a thread switches it off.
+type cachingController struct {
+	ProcessController
+	cache *pageCache
+}
+
+func (c cachingController) ReadMemory(tid int, addr uint64, buf []byte) (int, error) {
+	// Without process_vm_readv a page costs hundreds of ptrace peeks, far
+	// more than the words most reads want.
+	if vmReadvFailed.Load() || len(buf) > maxCachedPages/4*int(pageSize) {
+		return c.ProcessController.ReadMemory(tid, addr, buf)
+	}
+	done, missed := 0, false
+	for done < len(buf) {
+		a := addr + uint64(done)
+		page := a &^ (pageSize - 1)
+		if p, ok := c.cache.pages[pageKey{tid, page}]; ok {
+			done += copy(buf[done:], p[a-page:])
+			continue
+		}
+		// Read the pages from here to the end of buf at once. Should one
+		// fail, the rest is read as asked, for the error to tell where.
+		missed = true
+		end := (addr + uint64(len(buf)) + pageSize - 1) &^ (pageSize - 1)
+		pages := make([]byte, end-page)
+		n, err := c.ProcessController.ReadMemory(tid, page, pages)
+		c.store(tid, page, pages[:uint64(n)&^(pageSize-1)])
+		if err != nil || n < len(pages) {
+			m, err := c.ProcessController.ReadMemory(tid, a, buf[done:])
+			if me, ok := err.(*MemoryError); ok {
+				me.N += done
+			}
+			return done + m, err
+		}
+	}
+	if !missed {
+		c.cache.hits++
+	}
+	return done, nil
+}
+
+// store caches the whole pages in data, read at addr.
+func (c cachingController) store(tid int, addr uint64, data []byte) {
+	if c.cache.pages == nil || len(c.cache.pages)+len(data)/int(pageSize) > maxCachedPages {
+		c.cache.pages = make(map[pageKey][]byte)
+	}
+	for off := uint64(0); off < uint64(len(data)); off += pageSize {
+		c.cache.pages[pageKey{tid, addr + off}] = data[off : off+pageSize : off+pageSize]
+	}
+}
+
+func (c cachingController) WriteMemory(tid int, addr uint64, data []byte) (int, error) {
+	c.cache.pages = nil
+	return c.ProcessController.WriteMemory(tid, addr, data)
+}
+
+func (c cachingController) Cont(tid, sig int) error {
+	c.cache.on, c.cache.pages = false, nil
+	return c.ProcessController.Cont(tid, sig)
+}
+
+func (c cachingController) Step(tid int) error {
+	c.cache.on, c.cache.pages = false, nil
+	return c.ProcessController.Step(tid)
+}
+
+func (c cachingController) Wait(pid int, ws *syscall.WaitStatus, ru *syscall.Rusage) (int, error) {
+	c.cache.on, c.cache.pages = false, nil
+	return c.ProcessController.Wait(pid, ws, ru)
+}
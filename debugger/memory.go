@@ -0,0 +1,248 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// MemoryReader reads target memory. Like io.ReaderAt, it returns the number
+// of bytes read and, if that is short, why.
+type MemoryReader interface {
+	ReadMemory(addr uint64, buf []byte) (int, error)
+}
+
+// MemoryWriter writes target memory, returning the number of bytes written
+// and, if that is short, why.
+type MemoryWriter interface {
+	WriteMemory(addr uint64, data []byte) (int, error)
+}
+
+// Errors a MemoryError wraps.
+var (
+	// ErrUnmapped is returned for addresses outside the target's mappings,
+	// or mappings that don't allow the access.
+	ErrUnmapped = errors.New("address not mapped")
+	// ErrNotStopped is returned by ptrace accesses to a thread that isn't
+	// stopped, or no longer exists.
+	ErrNotStopped = errors.New("thread not stopped")
+)
+
+// MemoryError describes an access that failed part way.
+type MemoryError struct {
+	Op   string // "read" or "write"
+	Addr uint64 // the first address that couldn't be accessed
+	N    int    // the bytes transferred before it
+	Err  error
+}
+
+func (e *MemoryError) Error() string {
+	return fmt.Sprintf("%s at %#x after %d bytes: %v", e.Op, e.Addr, e.N, e.Err)
+}
+
+func (e *MemoryError) Unwrap() error {
+	return e.Err
+}
+
+// memoryError wraps the errno of a failed access.
+func memoryError(op string, addr uint64, n int, err error) error {
+	switch err {
+	case syscall.EIO, syscall.EFAULT:
+		err = ErrUnmapped
+	case syscall.ESRCH:
+		err = ErrNotStopped
+	}
+	return &MemoryError{Op: op, Addr: addr, N: n, Err: err}
+}
+
+var pageSize = uint64(os.Getpagesize())
+
+// chunks calls access for the page-sized pieces of [addr, addr+len(buf)), so
+// a failure is pinned to the first page that can't be accessed. It stops at
+// the first short access.
+func chunks(addr uint64, buf []byte, access func(addr uint64, b []byte) (int, error)) (int, error) {
+	done := 0
+	for done < len(buf) {
+		a := addr + uint64(done)
+		end := min(uint64(len(buf)-done), pageSize-a%pageSize)
+		n, err := access(a, buf[done:done+int(end)])
+		done += n
+		if err != nil || n < int(end) {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// ptraceMemory accesses the memory of a stopped thread with ptrace peeks and
+// pokes, a word at a time.
+type ptraceMemory struct {
+	pid int
+}
+
+func (m ptraceMemory) ReadMemory(addr uint64, buf []byte) (int, error) {
+	return chunks(addr, buf, func(a uint64, b []byte) (int, error) {
+		n, err := syscall.PtracePeekData(m.pid, uintptr(a), b)
+		if err != nil {
+			return n, memoryError("read", a+uint64(n), int(a-addr)+n, err)
+		}
+		return n, nil
+	})
+}
+
+func (m ptraceMemory) WriteMemory(addr uint64, data []byte) (int, error) {
+	return chunks(addr, data, func(a uint64, b []byte) (int, error) {
+		n, err := syscall.PtracePokeData(m.pid, uintptr(a), b)
+		if err != nil {
+			return n, memoryError("write", a+uint64(n), int(a-addr)+n, err)
+		}
+		return n, nil
+	})
+}
+
+// procMemory accesses the memory of a process through /proc/<pid>/mem,
+// which works while it runs and from any thread of the tracer. Writes are
+// forced through like ptrace pokes, so text can be patched.
+type procMemory struct {
+	pid int
+}
+
+func (m procMemory) ReadMemory(addr uint64, buf []byte) (int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mem", m.pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return chunks(addr, buf, func(a uint64, b []byte) (int, error) {
+		n, err := f.ReadAt(b, int64(a))
+		if err != nil {
+			var pe *os.PathError
+			if errors.As(err, &pe) {
+				err = pe.Err
+			}
+			return n, memoryError("read", a+uint64(n), int(a-addr)+n, err)
+		}
+		return n, nil
+	})
+}
+
+func (m procMemory) WriteMemory(addr uint64, data []byte) (int, error) {
+	f, err := os.OpenFile(fmt.Sprintf("/proc/%d/mem", m.pid), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return chunks(addr, data, func(a uint64, b []byte) (int, error) {
+		n, err := f.WriteAt(b, int64(a))
+		if err != nil {
+			var pe *os.PathError
+			if errors.As(err, &pe) {
+				err = pe.Err
+			}
+			return n, memoryError("write", a+uint64(n), int(a-addr)+n, err)
+		}
+		return n, nil
+	})
+}
+
+// vmMemory reads process memory with process_vm_readv, one system call for
+// up to maxIovecs pages where ptrace needs one per word. It works on running
+// processes too. Reads fall back to another reader where the system call is
+// unavailable or not permitted.
+type vmMemory struct {
+	pid      int
+	fallback MemoryReader
+}
+
+// maxIovecs is the most iovecs a system call accepts (IOV_MAX).
+const maxIovecs = 1024
+
+// vmReadvFailed is set once process_vm_readv turned out to be unusable.
+var vmReadvFailed atomic.Bool
+
+// remoteIovec is an iovec in the target's address space.
+type remoteIovec struct {
+	base, len uint64
+}
+
+func (m vmMemory) ReadMemory(addr uint64, buf []byte) (int, error) {
+	if vmReadvFailed.Load() {
+		return m.fallback.ReadMemory(addr, buf)
+	}
+
+	done := 0
+	remote := make([]remoteIovec, 0, maxIovecs)
+	for done < len(buf) {
+		// Each remote iovec is a page at most, so a short read stops at
+		// the page that couldn't be read.
+		remote = remote[:0]
+		want := 0
+		for a := addr + uint64(done); done+want < len(buf) && len(remote) < maxIovecs; {
+			l := min(uint64(len(buf)-done-want), pageSize-a%pageSize)
+			remote = append(remote, remoteIovec{a, l})
+			a += l
+			want += int(l)
+		}
+		var local syscall.Iovec
+		local.Base = &buf[done]
+		local.SetLen(want)
+
+		n, _, errno := syscall.Syscall6(sysProcessVMReadv, uintptr(m.pid),
+			uintptr(unsafe.Pointer(&local)), 1,
+			uintptr(unsafe.Pointer(&remote[0])), uintptr(len(remote)), 0)
+		switch {
+		case errno == syscall.ENOSYS || errno == syscall.EPERM:
+			vmReadvFailed.Store(true)
+			n, err := m.fallback.ReadMemory(addr+uint64(done), buf[done:])
+			return done + n, err
+		case errno != 0:
+			return done, memoryError("read", addr+uint64(done), done, errno)
+		}
+		done += int(n)
+		if int(n) < want {
+			return done, memoryError("read", addr+uint64(done), done, syscall.EFAULT)
+		}
+	}
+	return done, nil
+}
+
+// Memory returns the memory of the stopped thread pid.
+func (d *Debugger) Memory(pid int) interface {
+	MemoryReader
+	MemoryWriter
+} {
+	return controllerMemory{d.proc(), pid}
+}
+
+// ReadMemory reads len(buf) bytes at addr from the stopped thread pid. A
+// short read fails with a *MemoryError.
+func (d *Debugger) ReadMemory(pid int, addr uint64, buf []byte) error {
+	if err := d.canceled(); err != nil {
+		return err
+	}
+	n, err := d.Memory(pid).ReadMemory(addr, buf)
+	d.countRead(n)
+	return err
+}
+
+// WriteMemory writes data at addr to the stopped thread pid. A short write
+// fails with a *MemoryError.
+func (d *Debugger) WriteMemory(pid int, addr uint64, data []byte) error {
+	if d.ReadOnly {
+		return ErrReadOnly
+	}
+	_, err := d.Memory(pid).WriteMemory(addr, data)
+	return err
+}
+
+// readRunning reads memory of pid without stopping it.
+func (d *Debugger) readRunning(pid int, addr uint64, buf []byte) error {
+	n, err := vmMemory{pid, procMemory{pid}}.ReadMemory(addr, buf)
+	d.countRead(n)
+	return err
+}
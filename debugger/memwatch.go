@@ -0,0 +1,126 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	watchMetrics["rss"] = rssBytes
+	watchMetrics["heapalloc"] = heapAlloc
+}
+
+// rssBytes reads the resident set size of pid from /proc/<pid>/status.
+func rssBytes(d *Debugger, pid int) (uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "VmRSS:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			return kb << 10, err
+		}
+	}
+	return 0, fmt.Errorf("VmRSS not found for %d", pid)
+}
+
+// heapAlloc reads runtime.gcController.heapLive, the runtime's running count
+// of allocated heap bytes that backs MemStats.HeapAlloc. The field offset is
+// taken from DWARF because the layout changes between Go releases.
+func heapAlloc(d *Debugger, pid int) (uint64, error) {
+	base, ok := d.Symbols["runtime.gcController"]
+	if !ok {
+		return 0, fmt.Errorf("runtime.gcController not found")
+	}
+	off, err := d.FieldOffset("runtime.gcControllerState", "heapLive")
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 8)
+	if err := d.readRunning(pid, base+uint64(off), buf); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// FieldOffset returns the byte offset of field within the struct type
+// typeName using the target's DWARF information.
+func (d *Debugger) FieldOffset(typeName, field string) (int64, error) {
+	key := typeName + "." + field
+	d.cacheMu.Lock()
+	off, ok := d.fieldOffsets[key]
+	d.cacheMu.Unlock()
+	if ok {
+		return off, nil
+	}
+
+	off, err := d.lookupFieldOffset(typeName, field)
+	if err == nil {
+		d.cacheMu.Lock()
+		if d.fieldOffsets == nil {
+			d.fieldOffsets = make(map[string]int64)
+		}
+		d.fieldOffsets[key] = off
+		d.cacheMu.Unlock()
+	}
+	return off, err
+}
+
+func (d *Debugger) lookupFieldOffset(typeName, field string) (int64, error) {
+	if d.Dwarf == nil {
+		return 0, ErrNoDWARF
+	}
+
+	r := d.Dwarf.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return 0, err
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Tag == dwarf.TagCompileUnit {
+			continue
+		}
+		if entry.Tag != dwarf.TagStructType {
+			if entry.Children {
+				r.SkipChildren()
+			}
+			continue
+		}
+		if name, _ := entry.Val(dwarf.AttrName).(string); name != typeName {
+			r.SkipChildren()
+			continue
+		}
+
+		typ, err := d.Dwarf.Type(entry.Offset)
+		if err != nil {
+			return 0, err
+		}
+		st, ok := typ.(*dwarf.StructType)
+		if !ok {
+			break
+		}
+		for _, f := range st.Field {
+			if f.Name == field {
+				return f.ByteOffset, nil
+			}
+		}
+		return 0, fmt.Errorf("%s has no field %s", typeName, field)
+	}
+	return 0, fmt.Errorf("type %s not found", typeName)
+}
@@ -0,0 +1,417 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MI speaks a subset of the GDB machine interface on a Session, so that MI
+// frontends such as Emacs GUD or Eclipse CDT can drive the debugger. Commands
+// are read one per line and answered with result, async and stream records,
+// each batch terminated by the "(gdb)" prompt.
+type MI struct {
+	Session *Session
+
+	out     io.Writer
+	mu      sync.Mutex // serializes writes to out
+	running bool
+	// temps are the temporary breakpoints inserted, by address, which are
+	// gone from the session by the time their stop is reported.
+	temps map[uint64]int
+}
+
+// ServeMI launches target with args and runs the MI interpreter on stdin and
+// stdout until "-gdb-exit" or the end of input, returning the exit code.
+func ServeMI(in io.Reader, out io.Writer, target string, args []string) int {
+	m := &MI{out: out}
+	// What the debugger prints, such as the hits of breakpoints, goes out
+	// as console stream records.
+	d := NewDebugger()
+	d.UI = NewScriptFrontend(strings.NewReader(""), miConsole{m})
+	sess, err := LaunchDebugger(d, target, args...)
+	if err != nil {
+		fmt.Fprintf(out, "^error,msg=%s\n(gdb)\n", miString(err.Error()))
+		return 1
+	}
+	defer sess.Close()
+
+	m.Session = sess
+	m.write(`=thread-group-added,id="i1"`, "(gdb)")
+	m.Serve(in)
+	return 0
+}
+
+// Serve reads MI commands from in until "-gdb-exit" or EOF.
+func (m *MI) Serve(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			m.write("(gdb)")
+			continue
+		}
+		if !m.command(line) {
+			return
+		}
+	}
+}
+
+// write prints records to the frontend.
+func (m *MI) write(records ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range records {
+		fmt.Fprintln(m.out, r)
+	}
+}
+
+// miConsole writes output as console stream records.
+type miConsole struct{ m *MI }
+
+func (c miConsole) Write(p []byte) (int, error) {
+	c.m.write("~" + miString(string(p)))
+	return len(p), nil
+}
+
+// command executes one input line and reports whether to keep reading.
+func (m *MI) command(line string) bool {
+	// An optional numeric token is echoed back on the result record.
+	i := 0
+	for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	token, line := line[:i], line[i:]
+
+	if !strings.HasPrefix(line, "-") {
+		m.write(token+`^error,msg="CLI commands are not supported"`, "(gdb)")
+		return true
+	}
+	args, err := miArgs(line[1:])
+	if err != nil {
+		m.write(token+"^error,msg="+miString(err.Error()), "(gdb)")
+		return true
+	}
+
+	m.mu.Lock()
+	running := m.running
+	m.mu.Unlock()
+	if running && !miRunningCommands[args[0]] {
+		m.write(token+`^error,msg="Cannot execute this command while the target is running."`, "(gdb)")
+		return true
+	}
+
+	var result string
+	switch args[0] {
+	case "gdb-exit":
+		m.write(token + "^exit")
+		return false
+	case "exec-run", "exec-continue":
+		m.resume(token, m.Session.Continue)
+		return true
+	case "exec-step-instruction", "exec-next-instruction":
+		m.resume(token, m.Session.Step)
+		return true
+	case "exec-next":
+		m.resume(token, m.Session.Next)
+		return true
+	case "exec-step":
+		m.resume(token, m.Session.StepLine)
+		return true
+	case "exec-finish":
+		m.resume(token, m.Session.StepOut)
+		return true
+	case "exec-interrupt":
+		if !running {
+			err = fmt.Errorf("The program is not being run.")
+			break
+		}
+		err = m.Session.Interrupt()
+	case "break-insert":
+		result, err = m.breakInsert(args[1:])
+	case "break-delete":
+		err = m.breakDelete(args[1:])
+	case "stack-list-frames":
+		result, err = m.listFrames()
+	case "data-evaluate-expression":
+		result, err = m.evaluate(args[1:])
+	case "list-features":
+		result = "features=[]"
+	case "gdb-set", "gdb-show", "environment-cd", "inferior-tty-set",
+		"enable-pretty-printing", "file-exec-and-symbols":
+		// Settings frontends send on startup; accepted and ignored.
+	default:
+		err = fmt.Errorf("Undefined MI command: %s", args[0])
+	}
+
+	if err != nil {
+		m.write(token+"^error,msg="+miString(err.Error()), "(gdb)")
+		return true
+	}
+	if result != "" {
+		result = "," + result
+	}
+	m.write(token+"^done"+result, "(gdb)")
+	return true
+}
+
+// miRunningCommands are the MI commands accepted while the target runs.
+// Breakpoint changes interrupt the target briefly to apply them.
+var miRunningCommands = map[string]bool{
+	"exec-interrupt": true,
+	"gdb-exit":       true,
+	"break-insert":   true,
+	"break-delete":   true,
+}
+
+// resume runs op in the background, reporting the stop asynchronously as
+// GDB does, so that "-exec-interrupt" can still be read.
+func (m *MI) resume(token string, op func() error) {
+	if exited, _ := m.Session.Exited(); exited {
+		m.write(token+`^error,msg="The program is not being run."`, "(gdb)")
+		return
+	}
+
+	m.mu.Lock()
+	m.running = true
+	m.mu.Unlock()
+	m.write(token+"^running", `*running,thread-id="all"`, "(gdb)")
+
+	go func() {
+		err := op()
+		m.mu.Lock()
+		m.running = false
+		m.mu.Unlock()
+		if err != nil {
+			m.write("^error,msg="+miString(err.Error()), "(gdb)")
+			return
+		}
+		m.write(m.stopped(m.Session.LastEvent()), "(gdb)")
+	}()
+}
+
+// stopped formats the *stopped record for a stop or exit event.
+func (m *MI) stopped(ev Event) string {
+	if ev.Type == "exit" {
+		switch {
+		case ev.Detail != "":
+			return `*stopped,reason="exited-signalled",signal-meaning=` + miString(ev.Detail)
+		case *ev.Status == 0:
+			return `*stopped,reason="exited-normally"`
+		default:
+			// GDB reports exit codes in octal.
+			return fmt.Sprintf(`*stopped,reason="exited",exit-code="%02o"`, *ev.Status)
+		}
+	}
+
+	var reason string
+	switch ev.Reason {
+	case "breakpoint":
+		pc, _ := strconv.ParseUint(ev.PC, 0, 64)
+		m.mu.Lock()
+		id, temp := m.temps[pc]
+		delete(m.temps, pc)
+		m.mu.Unlock()
+		if temp {
+			reason = fmt.Sprintf(`reason="breakpoint-hit",disp="del",bkptno="%d",`, id)
+			break
+		}
+		reason = `reason="breakpoint-hit",disp="keep",`
+		for _, b := range m.Session.Breakpoints() {
+			if b.Addr == pc {
+				reason += fmt.Sprintf(`bkptno="%d",`, b.ID)
+			}
+		}
+	case "finish":
+		reason = `reason="function-finished",`
+	case "interrupt":
+		reason = `reason="signal-received",signal-name="SIGINT",signal-meaning="Interrupt",`
+	case "signal":
+		reason = `reason="signal-received",signal-name=` + miString(ev.Detail) + ","
+	default:
+		reason = `reason="end-stepping-range",`
+	}
+	pc, _ := strconv.ParseUint(ev.PC, 0, 64)
+	frame := miFrame(Frame{Location: Location{PC: pc, Func: ev.Func, File: ev.File, Line: ev.Line}})
+	return fmt.Sprintf(`*stopped,%sframe={%s},thread-id="1",stopped-threads="all"`, reason, frame)
+}
+
+// breakInsert handles "-break-insert [-t] [-f] [-c cond] location", where
+// location is file:line or a function. The breakpoint is numbered with its
+// ID in the session.
+func (m *MI) breakInsert(args []string) (string, error) {
+	var spec BreakSpec
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; a {
+		case "-t":
+			spec.Temporary = true
+		case "-f":
+			// Pending breakpoints: the target's symbols are all loaded.
+		case "-c":
+			if i++; i == len(args) {
+				return "", fmt.Errorf("-break-insert: -c needs a condition")
+			}
+			spec.Cond = args[i]
+		default:
+			if strings.HasPrefix(a, "-") {
+				return "", fmt.Errorf("-break-insert: option %s is not supported", a)
+			}
+			spec.Location = a
+		}
+	}
+	if spec.Location == "" {
+		return "", fmt.Errorf("-break-insert: missing location")
+	}
+	b, err := m.Session.BreakAt(spec)
+	if err != nil {
+		return "", err
+	}
+	disp := "keep"
+	if spec.Temporary {
+		disp = "del"
+		m.mu.Lock()
+		if m.temps == nil {
+			m.temps = make(map[uint64]int)
+		}
+		m.temps[b.Addr] = b.ID
+		m.mu.Unlock()
+	}
+	var cond string
+	if spec.Cond != "" {
+		cond = "cond=" + miString(spec.Cond) + ","
+	}
+
+	f := Location{PC: b.Addr, Func: m.Session.Debugger().LookupPC(b.Addr).Func, File: b.File, Line: b.Line}
+	return fmt.Sprintf(`bkpt={number="%d",type="breakpoint",disp="%s",enabled="y",addr="%#x",func=%s,file=%s,fullname=%s,line="%d",%stimes="0"}`,
+		b.ID, disp, f.PC, miString(f.Func), miString(filepath.Base(f.File)), miString(f.File), f.Line, cond), nil
+}
+
+func (m *MI) breakDelete(args []string) error {
+	for _, a := range args {
+		n, err := strconv.Atoi(a)
+		if err != nil || m.Session.ClearBreak(n) != nil {
+			return fmt.Errorf("Bad breakpoint number '%s'", a)
+		}
+		m.mu.Lock()
+		for addr, id := range m.temps {
+			if id == n {
+				delete(m.temps, addr)
+			}
+		}
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+func (m *MI) listFrames() (string, error) {
+	st, err := m.Session.Stacktrace(50)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("stack=[")
+	for i, f := range st.Frames {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `frame={level="%d",%s}`, i, miFrame(f))
+	}
+	b.WriteString("]")
+	return b.String(), nil
+}
+
+func (m *MI) evaluate(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("-data-evaluate-expression: Usage: -data-evaluate-expression expression")
+	}
+	v, err := m.Session.Eval(strings.Join(args, " "))
+	if err != nil {
+		return "", err
+	}
+	return "value=" + miString(fmt.Sprint(v)), nil
+}
+
+// miFrame formats the fields of a frame tuple.
+func miFrame(f Frame) string {
+	return fmt.Sprintf(`addr="%#x",func=%s,args=[],file=%s,fullname=%s,line="%d",arch="i386:x86-64"`,
+		f.PC, miString(f.Func), miString(filepath.Base(f.File)), miString(f.File), f.Line)
+}
+
+// miString quotes s as an MI c-string.
+func miString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < ' ' || c == 0x7f {
+				fmt.Fprintf(&b, `\%03o`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// miArgs splits an MI command into its operation and parameters. Parameters
+// may be c-strings, which are unquoted.
+func miArgs(s string) ([]string, error) {
+	var args []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			break
+		}
+		if s[0] != '"' {
+			i := strings.IndexAny(s, " \t")
+			if i < 0 {
+				i = len(s)
+			}
+			args = append(args, s[:i])
+			s = s[i:]
+			continue
+		}
+
+		var b strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(s[i])
+				}
+				continue
+			}
+			b.WriteByte(s[i])
+		}
+		if i == len(s) {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		args = append(args, b.String())
+		s = s[i+1:]
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
@@ -0,0 +1,209 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+// MockProcess is a ProcessController with no process behind it, to run the
+// engine in tests: its memory is a sparse set of pages mapped with Map,
+// each thread has the registers set with SetThreadRegs, and Wait returns
+// the events scripted with Queue, in order. Cont and Step don't execute
+// anything; they are recorded in Calls.
+type MockProcess struct {
+	mu       sync.Mutex
+	pages    map[uint64][]byte
+	regs     map[int]*syscall.PtraceRegs
+	sigCodes map[int]int32
+	events   []MockEvent
+	// Calls are the Cont and Step calls made, oldest first.
+	Calls []MockCall
+}
+
+// MockEvent is an event Wait returns: thread Tid changed to Status. A stop
+// can set the thread's registers, as the instruction it stopped at, and the
+// si_code of its signal.
+type MockEvent struct {
+	Tid     int
+	Status  syscall.WaitStatus
+	Regs    *syscall.PtraceRegs
+	SigCode int32
+}
+
+// MockCall is a call that resumed a thread: Op is "cont", with the signal
+// delivered in Sig, or "step".
+type MockCall struct {
+	Op  string
+	Tid int
+	Sig int
+}
+
+// MockStopped is the status of a thread stopped by sig, and MockExited
+// that of one that exited with code.
+func MockStopped(sig syscall.Signal) syscall.WaitStatus {
+	return syscall.WaitStatus(int(sig)<<8 | 0x7f)
+}
+
+func MockExited(code int) syscall.WaitStatus {
+	return syscall.WaitStatus(code << 8)
+}
+
+// NewMockProcess returns a MockProcess with no memory, threads or events.
+func NewMockProcess() *MockProcess {
+	return &MockProcess{
+		pages:    make(map[uint64][]byte),
+		regs:     make(map[int]*syscall.PtraceRegs),
+		sigCodes: make(map[int]int32),
+	}
+}
+
+// Map maps the pages holding [addr, addr+len(data)), zeroed, and copies
+// data there.
+func (m *MockProcess) Map(addr uint64, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for p := addr &^ (pageSize - 1); p < addr+uint64(len(data)); p += pageSize {
+		if m.pages[p] == nil {
+			m.pages[p] = make([]byte, pageSize)
+		}
+	}
+	m.access(addr, data, true)
+}
+
+// SetThreadRegs sets the registers of thread tid, creating it.
+func (m *MockProcess) SetThreadRegs(tid int, regs syscall.PtraceRegs) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.regs[tid] = &regs
+}
+
+// Queue appends events for Wait to return.
+func (m *MockProcess) Queue(events ...MockEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, events...)
+}
+
+// access copies between buf and memory at addr, up to the first unmapped
+// page.
+func (m *MockProcess) access(addr uint64, buf []byte, write bool) (int, error) {
+	return chunks(addr, buf, func(a uint64, b []byte) (int, error) {
+		page := m.pages[a&^(pageSize-1)]
+		if page == nil {
+			op := "read"
+			if write {
+				op = "write"
+			}
+			return 0, &MemoryError{Op: op, Addr: a, N: int(a - addr), Err: ErrUnmapped}
+		}
+		if write {
+			return copy(page[a%pageSize:], b), nil
+		}
+		return copy(b, page[a%pageSize:]), nil
+	})
+}
+
+// thread returns the registers of tid, failing like ptrace for threads
+// that don't exist.
+func (m *MockProcess) thread(tid int) (*syscall.PtraceRegs, error) {
+	regs := m.regs[tid]
+	if regs == nil {
+		return nil, syscall.ESRCH
+	}
+	return regs, nil
+}
+
+func (m *MockProcess) ReadMemory(tid int, addr uint64, buf []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.thread(tid); err != nil {
+		return 0, memoryError("read", addr, 0, err)
+	}
+	return m.access(addr, buf, false)
+}
+
+func (m *MockProcess) WriteMemory(tid int, addr uint64, data []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.thread(tid); err != nil {
+		return 0, memoryError("write", addr, 0, err)
+	}
+	return m.access(addr, data, true)
+}
+
+func (m *MockProcess) GetRegs(tid int, regs *syscall.PtraceRegs) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.thread(tid)
+	if err != nil {
+		return err
+	}
+	*regs = *r
+	return nil
+}
+
+func (m *MockProcess) SetRegs(tid int, regs *syscall.PtraceRegs) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.thread(tid)
+	if err != nil {
+		return err
+	}
+	*r = *regs
+	return nil
+}
+
+func (m *MockProcess) Cont(tid, sig int) error {
+	return m.resume(MockCall{Op: "cont", Tid: tid, Sig: sig})
+}
+
+func (m *MockProcess) Step(tid int) error {
+	return m.resume(MockCall{Op: "step", Tid: tid})
+}
+
+func (m *MockProcess) resume(call MockCall) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.thread(call.Tid); err != nil {
+		return err
+	}
+	m.Calls = append(m.Calls, call)
+	return nil
+}
+
+// Wait returns the first queued event of thread pid, or of any thread for
+// a negative pid. With none queued it fails with ECHILD, as if the target
+// were gone, rather than block. A thread that exits is removed.
+func (m *MockProcess) Wait(pid int, ws *syscall.WaitStatus, ru *syscall.Rusage) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, ev := range m.events {
+		if pid > 0 && ev.Tid != pid {
+			continue
+		}
+		m.events = append(m.events[:i], m.events[i+1:]...)
+		if ws != nil {
+			*ws = ev.Status
+		}
+		switch {
+		case ev.Status.Exited() || ev.Status.Signaled():
+			delete(m.regs, ev.Tid)
+		case ev.Regs != nil:
+			regs := *ev.Regs
+			m.regs[ev.Tid] = &regs
+		}
+		m.sigCodes[ev.Tid] = ev.SigCode
+		return ev.Tid, nil
+	}
+	return -1, fmt.Errorf("mock process: no event queued: %w", syscall.ECHILD)
+}
+
+func (m *MockProcess) SigCode(tid int) (int32, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.sigCodes[tid]
+	return code, ok
+}
@@ -0,0 +1,481 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+const (
+	mockTid  = 100
+	mockText = 0x401000
+	mockAddr = mockText + 0x10
+)
+
+// mockCode is the text of the mock target: nops, so that every byte is
+// the start of an instruction.
+var mockCode = bytes.Repeat([]byte{0x90}, 0x40)
+
+// newMockDebugger returns a debugger driving a MockProcess with mockCode
+// mapped at mockText and one thread, stopped at its start.
+func newMockDebugger(t *testing.T) (*Debugger, *MockProcess) {
+	t.Helper()
+	m := NewMockProcess()
+	m.Map(mockText, mockCode)
+	m.SetThreadRegs(mockTid, syscall.PtraceRegs{Rip: mockText})
+	d := NewDebugger()
+	d.Arch = AMD64
+	d.UI = NewScriptFrontend(strings.NewReader(""), io.Discard)
+	d.Proc = m
+	d.Pid = mockTid
+	if err := d.proc().GetRegs(mockTid, &d.Regs); err != nil {
+		t.Fatal(err)
+	}
+	return d, m
+}
+
+// code returns the n bytes of the mock target's memory at addr.
+func code(t *testing.T, m *MockProcess, addr uint64, n int) []byte {
+	t.Helper()
+	buf := make([]byte, n)
+	if _, err := m.ReadMemory(mockTid, addr, buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// addBreakpoint plants a breakpoint at addr the way AddBreakpoint does,
+// without the line table it would look addr up in.
+func addBreakpoint(t *testing.T, d *Debugger, addr uint64) *Breakpoint {
+	t.Helper()
+	if err := d.addTrap(addr); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.flushTraps(mockTid); err != nil {
+		t.Fatal(err)
+	}
+	if d.Breakpoints == nil {
+		d.Breakpoints = make(map[uint64]*Breakpoint)
+	}
+	d.nextBreakID++
+	b := &Breakpoint{ID: d.nextBreakID, Addr: addr, Enabled: true}
+	d.Breakpoints[addr] = b
+	return b
+}
+
+// hit makes the thread report the trap at addr, as the kernel does: the PC
+// just past it and a SIGTRAP from the kernel, and hands the stop to
+// trapStop. The events after are queued for trapStop to wait for.
+func hit(t *testing.T, d *Debugger, m *MockProcess, addr uint64, after ...MockEvent) string {
+	t.Helper()
+	m.Queue(MockEvent{
+		Tid:     mockTid,
+		Status:  MockStopped(syscall.SIGTRAP),
+		Regs:    &syscall.PtraceRegs{Rip: addr + uint64(len(d.Arch.TrapCode))},
+		SigCode: d.Arch.TrapSigCode,
+	})
+	m.Queue(after...)
+	if _, err := d.proc().Wait(mockTid, &d.Ws, nil); err != nil {
+		t.Fatal(err)
+	}
+	reason, ok, err := d.trapStop(mockTid)
+	if !ok || err != nil {
+		t.Fatalf("trapStop = %q, %v, %v", reason, ok, err)
+	}
+	return reason
+}
+
+// buildProgram builds the main package src into a temporary directory and
+// returns the executable. Test binaries lack the DWARF of a normal build.
+func buildProgram(t *testing.T, src string) string {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command to build a program with")
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "prog")
+	if out, err := exec.Command("go", "build", "-o", exe, file).CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return exe
+}
+
+// stepDone is the SIGTRAP of the single step resume makes off a rewound
+// trap, to pc.
+func stepDone(pc uint64) MockEvent {
+	return MockEvent{Tid: mockTid, Status: MockStopped(syscall.SIGTRAP), Regs: &syscall.PtraceRegs{Rip: pc}}
+}
+
+func TestSetBreak(t *testing.T) {
+	d, m := newMockDebugger(t)
+	addBreakpoint(t, d, mockAddr)
+	if got := code(t, m, mockAddr, 1); !bytes.Equal(got, d.Arch.TrapCode) {
+		t.Fatalf("code at the breakpoint = % x, want the trap", got)
+	}
+	if site := d.armedTrap(mockAddr); site == nil || !bytes.Equal(site.orig, mockCode[:1]) {
+		t.Fatalf("armed trap = %+v, want one saving % x", site, mockCode[:1])
+	}
+	// Reading the code through the debugger shows the target's own.
+	got, err := d.ReplaceCode(mockTid, mockAddr, d.Arch.TrapCode)
+	if err != nil || !bytes.Equal(got, mockCode[:1]) {
+		t.Fatalf("ReplaceCode = % x, %v, want % x", got, err, mockCode[:1])
+	}
+
+	if err := d.RemoveBreakpoint(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.flushTraps(mockTid); err != nil {
+		t.Fatal(err)
+	}
+	if got := code(t, m, mockAddr, 1); !bytes.Equal(got, mockCode[:1]) {
+		t.Fatalf("code after removing the breakpoint = % x, want % x", got, mockCode[:1])
+	}
+}
+
+func TestSetBreakUnmapped(t *testing.T) {
+	d, _ := newMockDebugger(t)
+	if err := d.addTrap(0x10); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.flushTraps(mockTid); err == nil {
+		t.Fatal("arming a trap in unmapped memory succeeded")
+	}
+	if d.armedTrap(0x10) != nil || len(d.traps) != 0 {
+		t.Fatalf("traps after a failure = %v, want none", d.traps)
+	}
+}
+
+func TestTrapRewindRearm(t *testing.T) {
+	d, m := newMockDebugger(t)
+	addBreakpoint(t, d, mockAddr)
+
+	if reason := hit(t, d, m, mockAddr); reason != "breakpoint" {
+		t.Fatalf("stop reason = %q, want breakpoint", reason)
+	}
+	var regs syscall.PtraceRegs
+	m.GetRegs(mockTid, &regs)
+	if regs.Rip != mockAddr {
+		t.Fatalf("PC after the hit = %#x, want it rewound to %#x", regs.Rip, mockAddr)
+	}
+	if got := code(t, m, mockAddr, 1); !bytes.Equal(got, mockCode[:1]) {
+		t.Fatalf("code under the rewound trap = % x, want % x", got, mockCode[:1])
+	}
+	if !d.rearm || d.rearmAt != mockAddr {
+		t.Fatalf("rearm = %v at %#x, want the trap to be re-armed", d.rearm, d.rearmAt)
+	}
+
+	// Continuing steps the original instruction, writes the trap back and
+	// only then continues.
+	m.Queue(stepDone(mockAddr + 1))
+	if err := d.resume(mockTid, true); err != nil {
+		t.Fatal(err)
+	}
+	want := []MockCall{{Op: "step", Tid: mockTid}, {Op: "cont", Tid: mockTid}}
+	if len(m.Calls) != len(want) || m.Calls[0] != want[0] || m.Calls[1] != want[1] {
+		t.Fatalf("calls = %+v, want %+v", m.Calls, want)
+	}
+	if got := code(t, m, mockAddr, 1); !bytes.Equal(got, d.Arch.TrapCode) {
+		t.Fatalf("code after continuing = % x, want the trap re-armed", got)
+	}
+	if d.rearm {
+		t.Fatal("rearm still pending after continuing")
+	}
+
+	// The trap fires again.
+	if reason := hit(t, d, m, mockAddr); reason != "breakpoint" {
+		t.Fatalf("second stop reason = %q, want breakpoint", reason)
+	}
+	if b := d.Breakpoints[mockAddr]; b.Hits != 2 {
+		t.Fatalf("hits = %d, want 2", b.Hits)
+	}
+}
+
+func TestCachingControllerInvalidation(t *testing.T) {
+	d, m := newMockDebugger(t)
+	read := func() byte {
+		t.Helper()
+		buf := make([]byte, 1)
+		if err := d.ReadMemory(mockTid, mockText, buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf[0]
+	}
+
+	d.cachePages()
+	if got := read(); got != 0x90 {
+		t.Fatalf("read %#x, want 0x90", got)
+	}
+	// Changed behind the debugger's back, as while no thread runs it
+	// can't be: the cached page is still what's read.
+	m.Map(mockText, []byte{0x01})
+	if got := read(); got != 0x90 {
+		t.Fatalf("read %#x while cached, want the cached 0x90", got)
+	}
+	if d.pages.hits == 0 {
+		t.Fatal("no read was answered from the cache")
+	}
+
+	// A write through the debugger empties the cache.
+	if err := d.WriteMemory(mockTid, mockText, []byte{0x02}); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != 0x02 {
+		t.Fatalf("read %#x after a write, want 0x02", got)
+	}
+
+	// Resuming a thread switches the cache off.
+	m.Map(mockText, []byte{0x03})
+	if err := d.proc().Cont(mockTid, 0); err != nil {
+		t.Fatal(err)
+	}
+	if d.pages.on || d.pages.pages != nil {
+		t.Fatal("cache still on after continuing")
+	}
+	m.Map(mockText, []byte{0x04})
+	if got := read(); got != 0x04 {
+		t.Fatalf("read %#x after continuing, want 0x04", got)
+	}
+
+	// So does waiting for a thread.
+	d.cachePages()
+	read()
+	m.Queue(MockEvent{Tid: mockTid, Status: MockStopped(syscall.SIGSTOP)})
+	if _, err := d.proc().Wait(mockTid, &d.Ws, nil); err != nil {
+		t.Fatal(err)
+	}
+	if d.pages.on || d.pages.pages != nil {
+		t.Fatal("cache still on after waiting")
+	}
+}
+
+// hits runs n hits of the breakpoint b and returns those the target
+// stopped at. A hit that doesn't stop resumes the target, stepping off the
+// trap.
+func hits(t *testing.T, d *Debugger, m *MockProcess, b *Breakpoint, n int) []int {
+	t.Helper()
+	var stops []int
+	for i := 1; i <= n; i++ {
+		calls := len(m.Calls)
+		reason := hit(t, d, m, b.Addr, stepDone(b.Addr+1))
+		switch {
+		case reason == "breakpoint":
+			stops = append(stops, i)
+			// Continue as the prompt would, taking the step queued.
+			if err := d.resume(mockTid, true); err != nil {
+				t.Fatal(err)
+			}
+		case reason != "" || len(m.Calls) == calls:
+			t.Fatalf("hit %d: stop reason %q, and the target wasn't resumed", i, reason)
+		}
+	}
+	return stops
+}
+
+func TestTrapStopIgnore(t *testing.T) {
+	d, m := newMockDebugger(t)
+	b := addBreakpoint(t, d, mockAddr)
+	b.Ignore = 2
+	if got := hits(t, d, m, b, 4); len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Fatalf("stopped at hits %v, want [3 4]", got)
+	}
+	if b.Ignore != 0 || b.Hits != 4 {
+		t.Fatalf("ignore = %d, hits = %d, want 0 and 4", b.Ignore, b.Hits)
+	}
+}
+
+func TestTrapStopHitCond(t *testing.T) {
+	d, m := newMockDebugger(t)
+	b := addBreakpoint(t, d, mockAddr)
+	cond, err := ParseHitCondition("%3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.HitCond = cond
+	if got := hits(t, d, m, b, 7); len(got) != 2 || got[0] != 3 || got[1] != 6 {
+		t.Fatalf("stopped at hits %v, want [3 6]", got)
+	}
+}
+
+func TestTrapStopSample(t *testing.T) {
+	d, m := newMockDebugger(t)
+	b := addBreakpoint(t, d, mockAddr)
+	b.Sample = 2
+	if got := hits(t, d, m, b, 5); len(got) != 2 || got[0] != 2 || got[1] != 4 {
+		t.Fatalf("stopped at hits %v, want [2 4]", got)
+	}
+	// Every hit counts, sampled or not.
+	if b.Hits != 5 {
+		t.Fatalf("hits = %d, want 5", b.Hits)
+	}
+}
+
+func TestEndOfInput(t *testing.T) {
+	d, _ := newMockDebugger(t)
+	// The script is empty: the session is quit, and the test goes on.
+	if d.InputOrContinue(mockTid) {
+		t.Fatal("InputOrContinue continued the target at the end of input")
+	}
+	if !d.quitting {
+		t.Fatal("the session wasn't quit at the end of input")
+	}
+	if d.stopAndPrompt(mockTid, "breakpoint") != ErrQuit {
+		t.Fatal("a stop after quitting didn't end the session with ErrQuit")
+	}
+}
+
+// TestCatchPanicOnce checks that a panic is caught once: the trap on
+// runtime.gopanic is past its prologue, which the runtime starts over when
+// the panic grows the stack or is preempted there. The code is that of a
+// program built for the test, whose DWARF marks the prologue.
+func TestCatchPanicOnce(t *testing.T) {
+	d, m := newMockDebugger(t)
+	exe := buildProgram(t, "package main\n\nfunc main() { panic(1) }\n")
+	var err error
+	if d.SymTable, err = d.GetSymbolTable(exe); err != nil {
+		t.Fatal(err)
+	}
+	fn := d.SymTable.LookupFunc("runtime.gopanic")
+	if fn == nil {
+		t.Fatal("no runtime.gopanic")
+	}
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	text := f.Section(".text")
+	body := make([]byte, fn.End-fn.Entry)
+	if _, err := text.ReadAt(body, int64(fn.Entry-text.Addr)); err != nil {
+		t.Fatal(err)
+	}
+	m.Map(fn.Entry, body)
+
+	c, err := d.CatchPanic(mockTid, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.flushTraps(mockTid); err != nil {
+		t.Fatal(err)
+	}
+	if got := code(t, m, fn.Entry, len(d.Arch.TrapCode)); bytes.Equal(got, d.Arch.TrapCode) {
+		t.Fatal("a trap is on the entry of runtime.gopanic, which a panic can run twice")
+	}
+	if reason := hit(t, d, m, c.Addrs[0]); reason != "catch" || c.Hits != 1 {
+		t.Fatalf("stop reason %q after %d hits, want catch after 1", reason, c.Hits)
+	}
+}
+
+func TestWaitEvent(t *testing.T) {
+	d, m := newMockDebugger(t)
+	addBreakpoint(t, d, mockAddr)
+	m.Queue(MockEvent{
+		Tid:     mockTid,
+		Status:  MockStopped(syscall.SIGTRAP),
+		Regs:    &syscall.PtraceRegs{Rip: mockAddr + uint64(len(d.Arch.TrapCode))},
+		SigCode: d.Arch.TrapSigCode,
+	})
+	state, wpid, reason, err := d.waitEvent(mockTid, mockTid)
+	if err != nil || state != stateStopped || wpid != mockTid || reason != "breakpoint" {
+		t.Fatalf("waitEvent = %v, %d, %q, %v, want a breakpoint stop of %d", state, wpid, reason, err, mockTid)
+	}
+
+	m.Queue(MockEvent{Tid: mockTid, Status: MockExited(0)})
+	state, wpid, _, err = d.waitEvent(mockTid, mockTid)
+	if err != nil || state != stateExited || wpid != mockTid {
+		t.Fatalf("waitEvent = %v, %d, %v, want the exit of %d", state, wpid, err, mockTid)
+	}
+}
+
+// eventLoop stops at a breakpoint, prompts, and continues as the script
+// says until the target exits.
+func TestEventLoop(t *testing.T) {
+	d, m := newMockDebugger(t)
+	var out bytes.Buffer
+	d.UI = NewScriptFrontend(strings.NewReader("continue\n"), &out)
+	addBreakpoint(t, d, mockAddr)
+	m.Queue(
+		MockEvent{
+			Tid:     mockTid,
+			Status:  MockStopped(syscall.SIGTRAP),
+			Regs:    &syscall.PtraceRegs{Rip: mockAddr + uint64(len(d.Arch.TrapCode))},
+			SigCode: d.Arch.TrapSigCode,
+		},
+		stepDone(mockAddr+1),
+		MockEvent{Tid: mockTid, Status: MockExited(3)},
+	)
+	if err := d.eventLoop(mockTid, mockTid); err != nil {
+		t.Fatal(err)
+	}
+	if d.Breakpoints[mockAddr].Hits != 1 {
+		t.Fatalf("breakpoint hit %d times, want 1", d.Breakpoints[mockAddr].Hits)
+	}
+	if !d.Ws.Exited() || d.Ws.ExitStatus() != 3 {
+		t.Fatalf("wait status %v, want an exit with 3", d.Ws)
+	}
+	if !strings.Contains(out.String(), "continue") {
+		t.Fatalf("the script was not prompted for at the stop:\n%s", out.String())
+	}
+}
+
+// OutputStack names the callers on the frame pointer chain, up to main.main.
+func TestOutputStack(t *testing.T) {
+	d, m := newMockDebugger(t)
+	exe := buildProgram(t, `package main
+
+//go:noinline
+func inner() { println() }
+
+//go:noinline
+func outer() { inner() }
+
+func main() { outer() }
+`)
+	var err error
+	if d.SymTable, err = d.GetSymbolTable(exe); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	d.UI = NewScriptFrontend(strings.NewReader(""), &out)
+
+	// The stack of inner, called by outer, called by main.main, called by
+	// runtime.main: each frame holds the caller's frame pointer and the
+	// return address into the caller.
+	const stack = 0xc000100000
+	callers := []string{"main.outer", "main.main", "runtime.main"}
+	frames := make([]byte, 16*(len(callers)+1))
+	for i, name := range callers {
+		fn := d.SymTable.LookupFunc(name)
+		if fn == nil {
+			t.Fatalf("no %s", name)
+		}
+		binary.LittleEndian.PutUint64(frames[16*i:], stack+16*uint64(i+1))
+		binary.LittleEndian.PutUint64(frames[16*i+8:], fn.Entry+1)
+	}
+	m.Map(stack, frames)
+
+	inner := d.SymTable.LookupFunc("main.inner")
+	if err := d.OutputStack(mockTid, inner.Entry+1, stack-8, stack); err != nil {
+		t.Fatal(err)
+	}
+	want := "  called by main.outer line "
+	got := out.String()
+	if !strings.Contains(got, want) || !strings.Contains(got, "  called by main.main line ") {
+		t.Fatalf("OutputStack printed\n%s\nwant main.outer and main.main as callers", got)
+	}
+	if strings.Contains(got, "runtime.main") {
+		t.Fatalf("OutputStack printed\n%s\nwant it to stop at main.main", got)
+	}
+}
@@ -0,0 +1,153 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"debug/buildinfo"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// goModule is the Go module of the target's main package, as found from
+// its go.mod.
+type goModule struct {
+	path string // the module path, such as "github.com/user/app"
+	dir  string // the directory of its go.mod here
+	// built is its directory where the target was built, the start of
+	// the compile-time paths of its files, or the module path for a
+	// binary built with -trimpath. Empty if none of its files are known.
+	built string
+}
+
+// module returns the module of the target, found once a session: the
+// nearest go.mod above the main package's source, the current directory or
+// the executable whose module path is the one the binary was built from.
+// It returns nil if there is none.
+func (d *Debugger) module() *goModule {
+	if d.modSearched {
+		return d.mod
+	}
+	d.modSearched = true
+	want := ""
+	if info, err := buildinfo.ReadFile(d.target); err == nil {
+		want = info.Main.Path
+	}
+	var starts []string
+	if d.TargetFile != "" {
+		starts = append(starts, filepath.Dir(d.TargetFile))
+	}
+	if wd, err := os.Getwd(); err == nil {
+		starts = append(starts, wd)
+	}
+	if d.target != "" {
+		if exe, err := filepath.Abs(d.target); err == nil {
+			starts = append(starts, filepath.Dir(exe))
+		}
+	}
+	for _, start := range starts {
+		for dir := start; ; dir = filepath.Dir(dir) {
+			if p, err := modulePath(filepath.Join(dir, "go.mod")); err == nil && (want == "" || p == want) {
+				d.mod = &goModule{path: p, dir: dir}
+				d.mod.built = d.moduleBuiltDir(d.mod)
+				return d.mod
+			}
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// modulePath returns the path the go.mod file at name declares.
+func modulePath(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line, _, _ := strings.Cut(s.Text(), "//")
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			if p, err := strconv.Unquote(fields[1]); err == nil {
+				return p, nil
+			}
+			return fields[1], nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", os.ErrNotExist
+}
+
+// moduleBuiltDir finds where m was when the target was built, from the
+// compile-time paths of its functions: the directory of a function of the
+// package m/server, say, ends in /server below it. A module with only
+// package main is matched by the longest trailing part of the main
+// package's path that is a file of m here.
+func (d *Debugger) moduleBuiltDir(m *goModule) string {
+	for i := 0; d.SymTable != nil && i < len(d.SymTable.Funcs); i++ {
+		fn := &d.SymTable.Funcs[i]
+		if !strings.HasPrefix(fn.Name, m.path+"/") && !strings.HasPrefix(fn.Name, m.path+".") {
+			continue
+		}
+		file, _, _ := d.SymTable.PCToLine(fn.Entry)
+		rel := strings.TrimPrefix(fn.PackageName(), m.path)
+		if dir := path.Dir(file); file != "" && strings.HasSuffix(dir, rel) {
+			return strings.TrimSuffix(dir, rel)
+		}
+	}
+	if d.TargetFile == "" {
+		return ""
+	}
+	elems := strings.Split(d.TargetFile, "/")
+	for i := 1; i < len(elems); i++ {
+		if _, err := os.Stat(filepath.Join(m.dir, filepath.Join(elems[i:]...))); err == nil {
+			return strings.Join(elems[:i], "/")
+		}
+	}
+	return ""
+}
+
+// moduleFile returns the compile-time path of the file name of the
+// module, given as it is found here: relative to the current directory or
+// absolute, in a checkout anywhere. Other names are returned as they are.
+func (d *Debugger) moduleFile(name string) string {
+	m := d.module()
+	if m == nil || m.built == "" {
+		return name
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return name
+	}
+	rel, err := filepath.Rel(m.dir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return name
+	}
+	return path.Join(m.built, filepath.ToSlash(rel))
+}
+
+// moduleSource returns where the file of the module compiled at path is
+// here, or "" if it isn't one.
+func (d *Debugger) moduleSource(path string) string {
+	m := d.module()
+	if m == nil || m.built == "" {
+		return ""
+	}
+	rel, ok := strings.CutPrefix(path, m.built+"/")
+	if !ok {
+		return ""
+	}
+	return filepath.Join(m.dir, filepath.FromSlash(rel))
+}
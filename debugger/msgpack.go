@@ -0,0 +1,262 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// mpExt is a msgpack extension value, such as a Neovim buffer handle.
+type mpExt struct {
+	Type int8
+	Data []byte
+}
+
+// mpAppend appends the msgpack encoding of v to b. It handles the types the
+// Neovim RPC protocol needs: nil, bools, integers, floats, strings, byte
+// slices, []any and map[string]any.
+func mpAppend(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int:
+		return mpAppendInt(b, int64(v)), nil
+	case int64:
+		return mpAppendInt(b, v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return binary.BigEndian.AppendUint64(append(b, 0xcf), v), nil
+		}
+		return mpAppendInt(b, int64(v)), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v)), nil
+	case string:
+		b = mpAppendHeader(b, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		return append(b, v...), nil
+	case []byte:
+		b = mpAppendHeader(b, len(v), 0, -1, 0xc4, 0xc5, 0xc6)
+		return append(b, v...), nil
+	case []any:
+		b = mpAppendHeader(b, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			var err error
+			if b, err = mpAppend(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]any:
+		// Sorted keys keep the encoding deterministic.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = mpAppendHeader(b, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			b, _ = mpAppend(b, k)
+			var err error
+			if b, err = mpAppend(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: can't encode %T", v)
+}
+
+func mpAppendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= 0x7f, v < 0 && v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+// mpAppendHeader appends the header of a string, binary, array or map of n
+// elements: the fix format fix|n when n <= fixMax, otherwise the 8, 16 or 32
+// bit length form. Formats without an 8 bit form pass 0 for c8.
+func mpAppendHeader(b []byte, n int, fix byte, fixMax int, c8, c16, c32 byte) []byte {
+	switch {
+	case n <= fixMax:
+		return append(b, fix|byte(n))
+	case c8 != 0 && n <= math.MaxUint8:
+		return append(b, c8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, c16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, c32), uint32(n))
+}
+
+// mpMaxLen bounds the length of the strings, binaries and extensions, and
+// the number of elements of the arrays and maps, mpReader accepts: it comes
+// from the peer, before anything checks who it is.
+const mpMaxLen = 16 << 20
+
+// mpReader decodes msgpack values from a stream.
+type mpReader struct {
+	r *bufio.Reader
+}
+
+func (r *mpReader) n(size int) (uint64, error) {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range buf {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (r *mpReader) bytes(n uint64) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r.r, buf)
+	return buf, err
+}
+
+// read decodes the next value. Integers decode to int64 (uint64 above
+// MaxInt64), strings to string, arrays to []any and maps to map[string]any.
+func (r *mpReader) read() (any, error) {
+	c, err := r.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		b, err := r.bytes(uint64(c & 0x1f))
+		return string(b), err
+	case c&0xf0 == 0x90:
+		return r.array(uint64(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return r.mapOf(uint64(c & 0x0f))
+	}
+
+	// Sizes of the length prefix of str, bin, array, map and ext formats.
+	lens := map[byte]int{
+		0xd9: 1, 0xda: 2, 0xdb: 4, // str
+		0xc4: 1, 0xc5: 2, 0xc6: 4, // bin
+		0xdc: 2, 0xdd: 4, // array
+		0xde: 2, 0xdf: 4, // map
+		0xc7: 1, 0xc8: 2, 0xc9: 4, // ext
+	}
+	fixExt := map[byte]uint64{0xd4: 1, 0xd5: 2, 0xd6: 4, 0xd7: 8, 0xd8: 16}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		v, err := r.n(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := r.n(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := r.n(1 << (c - 0xcc))
+		if v > math.MaxInt64 {
+			return v, err
+		}
+		return int64(v), err
+	case 0xd0:
+		v, err := r.n(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := r.n(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := r.n(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := r.n(8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return r.ext(fixExt[c])
+	}
+
+	size, ok := lens[c]
+	if !ok {
+		return nil, fmt.Errorf("msgpack: bad type byte %#x", c)
+	}
+	n, err := r.n(size)
+	if err != nil {
+		return nil, err
+	}
+	if n > mpMaxLen {
+		return nil, fmt.Errorf("msgpack: length %d over the limit of %d", n, mpMaxLen)
+	}
+	switch {
+	case c >= 0xd9 && c <= 0xdb:
+		b, err := r.bytes(n)
+		return string(b), err
+	case c >= 0xc4 && c <= 0xc6:
+		return r.bytes(n)
+	case c == 0xdc || c == 0xdd:
+		return r.array(n)
+	case c == 0xde || c == 0xdf:
+		return r.mapOf(n)
+	}
+	return r.ext(n)
+}
+
+func (r *mpReader) array(n uint64) ([]any, error) {
+	a := make([]any, 0, min(n, 1024))
+	for i := uint64(0); i < n; i++ {
+		v, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func (r *mpReader) mapOf(n uint64) (map[string]any, error) {
+	m := make(map[string]any)
+	for i := uint64(0); i < n; i++ {
+		k, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		m[fmt.Sprint(k)] = v
+	}
+	return m, nil
+}
+
+func (r *mpReader) ext(n uint64) (mpExt, error) {
+	t, err := r.r.ReadByte()
+	if err != nil {
+		return mpExt{}, err
+	}
+	data, err := r.bytes(n)
+	return mpExt{int8(t), data}, err
+}
@@ -0,0 +1,422 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+)
+
+// The bits of the state word of a sync.Mutex, as in internal/sync.
+const (
+	mutexLocked = 1 << iota
+	mutexWoken
+	mutexStarving
+	mutexWaiterShift = iota
+)
+
+// rwmutexMaxReaders is what a writer takes off the reader count of a
+// sync.RWMutex to hold back new readers.
+const rwmutexMaxReaders = 1 << 30
+
+// maxSemaWaiters bounds the walk of a semaphore's waiters, whose list
+// another thread may be changing.
+const maxSemaWaiters = 1 << 12
+
+// mutexCommand handles "mutex <variable>[.field...]", which decodes a
+// sync.Mutex or sync.RWMutex: whether it's held and by how many readers,
+// the goroutines waiting for it, found in the runtime's semaphore table,
+// and the goroutines likely holding it.
+func (d *Debugger) mutexCommand(pid int, args []string) bool {
+	if len(args) != 1 {
+		d.println("usage: mutex <variable>[.field...]")
+		return false
+	}
+	expr := args[0]
+	val, err := d.evalValue(pid, expr)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	typ := val.typ
+	for {
+		if t, ok := typ.(*dwarf.TypedefType); ok {
+			typ = t.Type
+			continue
+		}
+		p, ok := typ.(*dwarf.PtrType)
+		if !ok {
+			break
+		}
+		addr := bufUint(val.buf, 8)
+		if addr == 0 {
+			d.printf("%s is nil\n", expr)
+			return false
+		}
+		if val, err = d.readValueAt(pid, addr, p.Type); err != nil {
+			d.println(err)
+			return false
+		}
+		typ = p.Type
+	}
+	t, ok := typ.(*dwarf.StructType)
+	if !ok || t.StructName != "sync.Mutex" && t.StructName != "sync.RWMutex" {
+		d.printf("%s is a %s, not a sync.Mutex or sync.RWMutex\n", expr, typ)
+		return false
+	}
+	if val.addr == 0 {
+		d.printf("%s is not in memory\n", expr)
+		return false
+	}
+
+	var b strings.Builder
+	if t.StructName == "sync.Mutex" {
+		err = d.describeMutex(&b, pid, expr, t, val)
+	} else {
+		err = d.describeRWMutex(&b, pid, expr, t, val)
+	}
+	d.UI.ShowOutput(b.String())
+	if err != nil {
+		d.println(err)
+	}
+	return false
+}
+
+// mutexState is a sync.Mutex decoded.
+type mutexState struct {
+	state int32
+	// sema is the address of the semaphore its waiters sleep on.
+	sema uint64
+}
+
+func (m mutexState) locked() bool { return m.state&mutexLocked != 0 }
+
+// String describes the state word: "locked, starving, 2 waiters".
+func (m mutexState) String() string {
+	s := "unlocked"
+	if m.locked() {
+		s = "locked"
+	}
+	if m.state&mutexWoken != 0 {
+		s += ", a waiter woken"
+	}
+	if m.state&mutexStarving != 0 {
+		s += ", starving"
+	}
+	if n := m.state >> mutexWaiterShift; n > 0 {
+		s += fmt.Sprintf(", %d waiters", n)
+	}
+	return s
+}
+
+// decodeMutex decodes the sync.Mutex of type typ at the start of buf, read
+// from addr. Since Go 1.24 its fields are those of an internal/sync.Mutex
+// in its mu field.
+func decodeMutex(typ dwarf.Type, buf []byte, addr uint64) (mutexState, error) {
+	state, err := fieldOffset(typ, []string{"mu", "state"})
+	if err != nil {
+		if state, err = fieldOffset(typ, []string{"state"}); err != nil {
+			return mutexState{}, err
+		}
+	}
+	sema, err := fieldOffset(typ, []string{"mu", "sema"})
+	if err != nil {
+		if sema, err = fieldOffset(typ, []string{"sema"}); err != nil {
+			return mutexState{}, err
+		}
+	}
+	if state+4 > int64(len(buf)) {
+		return mutexState{}, fmt.Errorf("short sync.Mutex")
+	}
+	return mutexState{state: int32(bufUint(buf[state:], 4)), sema: addr + uint64(sema)}, nil
+}
+
+// describeMutex writes the state of the sync.Mutex val of type t to b.
+func (d *Debugger) describeMutex(b *strings.Builder, pid int, expr string, t *dwarf.StructType, val value) error {
+	m, err := decodeMutex(t, val.buf, val.addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(b, "%s (sync.Mutex at %#x): %v\n", expr, val.addr, m)
+	gs, err := d.Goroutines(pid)
+	if err != nil {
+		return err
+	}
+	waiting, err := d.semaWaiters(pid, m.sema)
+	if err != nil {
+		return err
+	}
+	d.writeWaiters(b, "Waiting for it", waiting)
+	if m.locked() {
+		d.writeHolders(b, pid, gs, val.addr, t.Size(), waiting)
+	}
+	return nil
+}
+
+// describeRWMutex writes the state of the sync.RWMutex val of type t to b:
+// its writers' lock, its readers and the three semaphores they wait on.
+func (d *Debugger) describeRWMutex(b *strings.Builder, pid int, expr string, t *dwarf.StructType, val value) error {
+	off := func(path ...string) (int64, error) {
+		// The reader counts became atomic.Int32s in Go 1.20.
+		if o, err := fieldOffset(t, append(path, "v")); err == nil {
+			return o, nil
+		}
+		return fieldOffset(t, path)
+	}
+	w, err := fieldOffset(t, []string{"w"})
+	if err != nil {
+		return err
+	}
+	var offs [4]int64
+	for i, name := range []string{"writerSem", "readerSem", "readerCount", "readerWait"} {
+		if offs[i], err = off(name); err != nil {
+			return err
+		}
+	}
+	wt, err := fieldType(t, "w")
+	if err != nil {
+		return err
+	}
+	m, err := decodeMutex(wt, val.buf[w:], val.addr+uint64(w))
+	if err != nil {
+		return err
+	}
+	readers := int32(bufUint(val.buf[offs[2]:], 4))
+	departing := int32(bufUint(val.buf[offs[3]:], 4))
+	writer := readers < 0
+	if writer {
+		readers += rwmutexMaxReaders
+	}
+
+	var state string
+	switch {
+	case writer && readers == 0:
+		state = "held by a writer"
+	case writer:
+		state = fmt.Sprintf("held by %d readers, a writer waiting for %d of them", readers, departing)
+	case readers > 0:
+		state = fmt.Sprintf("held by %d readers", readers)
+	case m.locked():
+		state = "being taken by a writer"
+	default:
+		state = "unlocked"
+	}
+	fmt.Fprintf(b, "%s (sync.RWMutex at %#x): %s; writers' lock %v\n", expr, val.addr, state, m)
+
+	gs, err := d.Goroutines(pid)
+	if err != nil {
+		return err
+	}
+	var all []uint64
+	for _, sem := range []struct {
+		addr uint64
+		what string
+	}{
+		{m.sema, "Writers waiting for the writers' lock"},
+		{val.addr + uint64(offs[0]), "Writers waiting for the readers"},
+		{val.addr + uint64(offs[1]), "Readers waiting for the writers"},
+	} {
+		waiting, err := d.semaWaiters(pid, sem.addr)
+		if err != nil {
+			return err
+		}
+		d.writeWaiters(b, sem.what, waiting)
+		all = append(all, waiting...)
+	}
+	if writer || readers > 0 {
+		d.writeHolders(b, pid, gs, val.addr, t.Size(), all)
+	}
+	return nil
+}
+
+// semaWaiters returns the IDs of the goroutines sleeping on the runtime
+// semaphore at addr, in the order they queued. The runtime keeps them in
+// runtime.semtable, a hash table of treaps of sudogs keyed by the address,
+// each the head of a list of the waiters for it.
+func (d *Debugger) semaWaiters(pid int, addr uint64) ([]uint64, error) {
+	tab, typ, err := d.LookupGlobal("runtime.semtable")
+	if err != nil {
+		return nil, fmt.Errorf("can't find the semaphores: %v", err)
+	}
+	for {
+		t, ok := typ.(*dwarf.TypedefType)
+		if !ok {
+			break
+		}
+		typ = t.Type
+	}
+	arr, ok := typ.(*dwarf.ArrayType)
+	if !ok || arr.Count <= 0 {
+		return nil, fmt.Errorf("runtime.semtable has unexpected type %s", typ)
+	}
+	treap, err := fieldOffset(arr.Type, []string{"root", "treap"})
+	if err != nil {
+		return nil, err
+	}
+	sudog, err := fieldPointee(arr.Type, "root", "treap")
+	if err != nil {
+		return nil, err
+	}
+	var offs [5]int64
+	for i, name := range []string{"g", "prev", "next", "waitlink", "elem"} {
+		if offs[i], err = fieldOffset(sudog, []string{name}); err != nil {
+			return nil, err
+		}
+	}
+	// Newer runtimes keep the address in the vu of a maybeTraceablePtr.
+	if o, err := fieldOffset(sudog, []string{"elem", "vu"}); err == nil {
+		offs[4] = o
+	}
+	gType, err := fieldPointee(sudog, "g")
+	if err != nil {
+		return nil, err
+	}
+	goid, err := fieldOffset(gType, []string{"goid"})
+	if err != nil {
+		return nil, err
+	}
+
+	root := tab + uint64((addr>>3)%uint64(arr.Count))*uint64(arr.Type.Size()) + uint64(treap)
+	s, err := d.readUint(pid, root, 8)
+	if err != nil {
+		return nil, err
+	}
+	field := func(s uint64, i int) (uint64, error) {
+		return d.readUint(pid, s+uint64(offs[i]), 8)
+	}
+	for n := 0; s != 0; n++ {
+		if n == maxSemaWaiters {
+			return nil, fmt.Errorf("the semaphore table changes while read")
+		}
+		elem, err := field(s, 4)
+		if err != nil {
+			return nil, err
+		}
+		if elem == addr {
+			break
+		}
+		next := 2
+		if addr < elem {
+			next = 1
+		}
+		if s, err = field(s, next); err != nil {
+			return nil, err
+		}
+	}
+	var ids []uint64
+	for ; s != 0 && len(ids) < maxSemaWaiters; s, err = field(s, 3) {
+		if err != nil {
+			return ids, err
+		}
+		g, err := field(s, 0)
+		if err != nil {
+			return ids, err
+		}
+		id, err := d.readUint(pid, g+uint64(goid), 8)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, err
+}
+
+// fieldPointee returns the type the pointer field path of typ points to.
+func fieldPointee(typ dwarf.Type, path ...string) (dwarf.Type, error) {
+	typ, err := fieldType(typ, path...)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := typ.(*dwarf.PtrType)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a pointer", typ)
+	}
+	return p.Type, nil
+}
+
+// fieldType returns the type of the nested struct field path of typ.
+func fieldType(typ dwarf.Type, path ...string) (dwarf.Type, error) {
+	for _, name := range path {
+		for {
+			t, ok := typ.(*dwarf.TypedefType)
+			if !ok {
+				break
+			}
+			typ = t.Type
+		}
+		t, ok := typ.(*dwarf.StructType)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a struct", typ)
+		}
+		var found *dwarf.StructField
+		for _, f := range t.Field {
+			if f.Name == name {
+				found = f
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("%s has no field %s", t.StructName, name)
+		}
+		typ = found.Type
+	}
+	return typ, nil
+}
+
+// writeWaiters writes the line listing the goroutines ids waiting for what,
+// if there are any.
+func (d *Debugger) writeWaiters(b *strings.Builder, what string, ids []uint64) {
+	if len(ids) == 0 {
+		return
+	}
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = fmt.Sprint(id)
+	}
+	noun := "goroutines"
+	if len(ids) == 1 {
+		noun = "goroutine"
+	}
+	fmt.Fprintf(b, "  %s: %s %s\n", what, noun, strings.Join(list, ", "))
+}
+
+// writeHolders writes the goroutines likely holding the lock of size bytes
+// at addr, with their stacks. A lock doesn't record its holder, so the
+// goroutines other than those waiting for it that have a pointer into it
+// on their stack, as a deferred Unlock keeps one, are taken for holders.
+func (d *Debugger) writeHolders(b *strings.Builder, pid int, gs []Goroutine, addr uint64, size int64, waiting []uint64) {
+	skip := make(map[uint64]bool, len(waiting))
+	for _, id := range waiting {
+		skip[id] = true
+	}
+	found := 0
+	for _, g := range gs {
+		if skip[g.ID] || g.Running || g.SP == 0 || g.stackHi <= g.SP {
+			continue
+		}
+		stack := make([]byte, min(g.stackHi-g.SP, maxValueSize))
+		if err := d.ReadMemory(pid, g.SP, stack); err != nil {
+			continue
+		}
+		holds := false
+		for i := 0; i+8 <= len(stack); i += 8 {
+			if p := bufUint(stack[i:], 8); p >= addr && p < addr+uint64(size) {
+				holds = true
+				break
+			}
+		}
+		if !holds {
+			continue
+		}
+		found++
+		fmt.Fprintf(b, "  Likely held by goroutine %d %s, which has a pointer to it on its stack:\n", g.ID, g.state())
+		frames, _ := d.GoroutineStack(pid, g, maxGoroutineFrames)
+		for i, f := range frames {
+			fmt.Fprintf(b, "    #%d %s\n", i, f)
+		}
+	}
+	if found == 0 {
+		b.WriteString("  No goroutine that could hold it has a pointer to it on its stack\n")
+	}
+}
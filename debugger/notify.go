@@ -0,0 +1,131 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notifyReasons are the stops posted to the webhooks of Debugger.Notify:
+// those of breakpoints, catchpoints, watches and fatal errors, which fire
+// on their own, unlike steps and interrupts.
+var notifyReasons = map[string]bool{
+	"breakpoint": true,
+	"catch":      true,
+	"watchpoint": true,
+	"watch":      true,
+	"fatal":      true,
+}
+
+// notifyFrames is the number of frames of the stopped thread a
+// notification carries.
+const notifyFrames = 8
+
+// stopNotice is the JSON payload posted for a stop: its event, with the
+// breakpoint hit, if any, and the top frames of the stopped thread.
+type stopNotice struct {
+	Event
+	Target     string   `json:"target"`
+	Breakpoint int      `json:"breakpoint,omitempty"`
+	Frames     []string `json:"frames,omitempty"`
+}
+
+// notifyStop posts stop, of the thread pid stopped at d.Regs, to the
+// webhooks if its reason is one of notifyReasons. A webhook of Slack is
+// posted a message instead of the payload.
+func (d *Debugger) notifyStop(pid int, stop Event) {
+	if len(d.Notify) == 0 || !notifyReasons[stop.Reason] {
+		return
+	}
+	if stop.Time.IsZero() {
+		stop.Time = time.Now()
+	}
+	n := stopNotice{Event: stop, Target: d.target}
+	if b := d.Breakpoints[d.Regs.Rip]; b != nil && stop.Reason == "breakpoint" {
+		n.Breakpoint = b.ID
+		n.Hits = b.Hits
+	}
+	frames, _ := d.Stacktrace(pid, &d.Regs, notifyFrames)
+	for _, f := range frames {
+		n.Frames = append(n.Frames, f.String())
+	}
+	for _, url := range d.Notify {
+		var err error
+		if strings.HasPrefix(url, "https://hooks.slack.com/") {
+			err = postJSON(url, map[string]string{"text": n.slackText()})
+		} else {
+			err = postJSON(url, n)
+		}
+		if err != nil {
+			d.printf("Can't notify %s: %v\n", url, err)
+		}
+	}
+}
+
+// slackText returns the notice as the text of a Slack message, the frames
+// in a code block.
+func (n stopNotice) slackText() string {
+	var b strings.Builder
+	what := n.Reason
+	if n.Breakpoint != 0 {
+		what = fmt.Sprintf("breakpoint %d (hit %d)", n.Breakpoint, n.Hits)
+	}
+	fmt.Fprintf(&b, "%s stopped at %s, %s:%d, on %s", n.Target, n.Func, n.File, n.Line, what)
+	if n.Detail != "" {
+		fmt.Fprintf(&b, ": %s", n.Detail)
+	}
+	if len(n.Frames) > 0 {
+		b.WriteString("\n```\n" + strings.Join(n.Frames, "\n") + "\n```")
+	}
+	return b.String()
+}
+
+// postJSON posts v as JSON to url, which must answer with a 2xx status
+// within 10 seconds.
+func postJSON(url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// notifyCommand handles "notify <url>...", which posts the stops of
+// breakpoints, catchpoints, watches and fatal errors to the webhooks at the
+// urls, "notify off", which stops it, and "notify", which lists them.
+func (d *Debugger) notifyCommand(pid int, args []string) bool {
+	switch {
+	case len(args) == 0 && len(d.Notify) == 0:
+		d.println("No webhooks are notified of stops")
+	case len(args) == 0:
+		for _, url := range d.Notify {
+			d.printf("  %s\n", url)
+		}
+	case len(args) == 1 && args[0] == "off":
+		d.Notify = nil
+	default:
+		for _, url := range args {
+			if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+				d.printf("%s isn't an http or https URL\n", url)
+				return false
+			}
+		}
+		d.Notify = append(d.Notify, args...)
+		d.printf("Notifying %d webhooks of stops\n", len(d.Notify))
+	}
+	return false
+}
@@ -0,0 +1,380 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// nvimHandler is the Lua function every stop is pushed to. Notifications a
+// client sends to Neovim are executed as API calls, so events are delivered
+// through nvim_exec_lua.
+const nvimHandler = "return require('dedebugger').on_event(...)"
+
+// NvimServer serves a Session over msgpack-RPC for a Neovim plugin, which
+// connects with sockconnect(..., {rpc = true}). The plugin calls:
+//
+//	toggle_breakpoint(file, line)  set or clear the breakpoint at file:line
+//	continue(), step()             resume the target; answered with the stop
+//	stacktrace([depth])            frames of the stopped thread
+//	eval(expr)                     value of expr as a string
+//	annotate(expr), unannotate(expr)
+//	                               add or remove expressions shown as
+//	                               virtual text on the stop line
+//	state()                        the latest stop, as pushed
+//
+// Every stop and exit is pushed to the plugin's on_event with the signs to
+// place and the virtual-text annotations. continue and step can be sent as
+// notifications so the editor doesn't block while the target runs.
+//
+// When Tokens are set, the plugin first calls authenticate(token), and
+// until it does nothing is answered or pushed to it. toggle_breakpoint,
+// continue and step then need RoleBreak, and the rest RoleRead.
+type NvimServer struct {
+	Session *Session
+	Tokens  Tokens
+
+	mu      sync.Mutex
+	clients map[*nvimConn]struct{}
+	exprs   []string
+}
+
+// nvimConn is one connected editor.
+type nvimConn struct {
+	conn   net.Conn
+	client string     // as the audit log names it
+	mu     sync.Mutex // serializes writes
+	role   Role       // of its token, 0 until it authenticates; guarded by mu
+}
+
+// nvimRoles are the roles the methods need.
+var nvimRoles = map[string]Role{
+	"toggle_breakpoint": RoleBreak,
+	"continue":          RoleBreak,
+	"step":              RoleBreak,
+	"stacktrace":        RoleRead,
+	"eval":              RoleRead,
+	"annotate":          RoleRead,
+	"unannotate":        RoleRead,
+	"state":             RoleRead,
+}
+
+// msgpack-RPC message types.
+const (
+	nvimRequest      = 0
+	nvimResponse     = 1
+	nvimNotification = 2
+)
+
+// ListenAndServe serves the session on addr, a unix socket path if it
+// contains a slash and a TCP address otherwise.
+func (n *NvimServer) ListenAndServe(addr string) error {
+	network := "tcp"
+	if strings.Contains(addr, "/") {
+		network = "unix"
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return n.Serve(l)
+}
+
+// Serve accepts editor connections on l until it is closed.
+func (n *NvimServer) Serve(l net.Listener) error {
+	events, cancel := n.Session.Debugger().Subscribe()
+	defer cancel()
+	go n.push(events)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		c := &nvimConn{conn: conn, client: connClient("nvim", conn)}
+		if len(n.Tokens) == 0 {
+			c.role = RoleFull
+		}
+		n.mu.Lock()
+		if n.clients == nil {
+			n.clients = make(map[*nvimConn]struct{})
+		}
+		n.clients[c] = struct{}{}
+		n.mu.Unlock()
+		go n.serveConn(c)
+	}
+}
+
+// push sends every stop and exit to all connected editors.
+func (n *NvimServer) push(events <-chan Event) {
+	for ev := range events {
+		if ev.Type != "stop" && ev.Type != "exit" {
+			continue
+		}
+		msg := []any{nvimNotification, "nvim_exec_lua", []any{nvimHandler, []any{n.state(ev)}}}
+
+		n.mu.Lock()
+		for c := range n.clients {
+			if c.authorized(RoleRead) == nil {
+				c.write(msg)
+			}
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (n *NvimServer) serveConn(c *nvimConn) {
+	defer func() {
+		n.mu.Lock()
+		delete(n.clients, c)
+		n.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	r := &mpReader{bufio.NewReader(c.conn)}
+	for {
+		v, err := r.read()
+		if err != nil {
+			return
+		}
+		msg, ok := v.([]any)
+		if !ok || len(msg) < 3 {
+			return
+		}
+
+		switch msg[0] {
+		case int64(nvimRequest):
+			if len(msg) < 4 {
+				return
+			}
+			id, method, params := msg[1], fmt.Sprint(msg[2]), nvimParams(msg[3])
+			go func() {
+				result, err := n.call(c, method, params)
+				var e any
+				if err != nil {
+					e, result = err.Error(), nil
+				}
+				c.write([]any{nvimResponse, id, e, result})
+			}()
+		case int64(nvimNotification):
+			go n.call(c, fmt.Sprint(msg[1]), nvimParams(msg[2]))
+		}
+	}
+}
+
+func (c *nvimConn) write(msg []any) {
+	b, err := mpAppend(nil, msg)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Write(b)
+}
+
+func nvimParams(v any) []any {
+	params, _ := v.([]any)
+	return params
+}
+
+// authorized fails unless the editor's token allows what needs.
+func (c *nvimConn) authorized(needs Role) error {
+	c.mu.Lock()
+	role := c.role
+	c.mu.Unlock()
+	switch {
+	case role == 0:
+		return fmt.Errorf("not authenticated: call authenticate(token) first")
+	case role < needs:
+		return fmt.Errorf("the token's role, %s, doesn't allow this; it needs %s", role, needs)
+	}
+	return nil
+}
+
+// call runs one RPC method for the editor c.
+func (n *NvimServer) call(c *nvimConn, method string, params []any) (any, error) {
+	if method == "authenticate" {
+		token, _ := nvimArg(params, 0).(string)
+		role, ok := n.Tokens.authorize("Bearer " + token)
+		if !ok {
+			return nil, fmt.Errorf("bad token")
+		}
+		c.mu.Lock()
+		c.role = role
+		c.mu.Unlock()
+		return role.String(), nil
+	}
+	needs, ok := nvimRoles[method]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %q", method)
+	}
+	if err := c.authorized(needs); err != nil {
+		return nil, err
+	}
+
+	s := n.Session
+	switch method {
+	case "toggle_breakpoint":
+		file, _ := nvimArg(params, 0).(string)
+		line, _ := nvimArg(params, 1).(int64)
+		return n.toggleBreakpoint(c.client, file, int(line))
+	case "continue", "step":
+		op := s.Continue
+		if method == "step" {
+			op = s.Step
+		}
+		if err := op(); err != nil {
+			return nil, err
+		}
+		return n.state(s.LastEvent()), nil
+	case "stacktrace":
+		depth, ok := nvimArg(params, 0).(int64)
+		if !ok || depth <= 0 {
+			depth = 50
+		}
+		st, err := s.Stacktrace(int(depth))
+		if err != nil {
+			return nil, err
+		}
+		out := make([]any, len(st.Frames))
+		for i, f := range st.Frames {
+			out[i] = map[string]any{"pc": fmt.Sprintf("%#x", f.PC), "func": f.Func, "file": f.File, "line": f.Line, "goroutine": f.Goroutine}
+		}
+		return out, nil
+	case "eval":
+		expr, _ := nvimArg(params, 0).(string)
+		v, err := s.Eval(expr)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprint(v), nil
+	case "annotate", "unannotate":
+		expr, _ := nvimArg(params, 0).(string)
+		if expr == "" {
+			return nil, fmt.Errorf("%s: expression required", method)
+		}
+		return n.setAnnotation(expr, method == "annotate"), nil
+	case "state":
+		return n.state(s.LastEvent()), nil
+	}
+	return nil, fmt.Errorf("unknown method %q", method)
+}
+
+func nvimArg(params []any, i int) any {
+	if i < len(params) {
+		return params[i]
+	}
+	return nil
+}
+
+// toggleBreakpoint sets a breakpoint at file:line, or clears the one
+// already there, leaving the others alone.
+func (n *NvimServer) toggleBreakpoint(client, file string, line int) (any, error) {
+	s := n.Session
+	d := s.Debugger()
+	path, err := d.ResolveFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := true
+	for _, b := range s.Breakpoints() {
+		if b.File == path && b.Line == line {
+			enabled = false
+			err = s.clearBreakFor(client, b.ID)
+		}
+	}
+	if enabled {
+		_, err = s.breakFor(client, path, line)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"enabled": enabled, "signs": n.signs(s.LastEvent())}, nil
+}
+
+// setAnnotation adds or removes expr and returns the annotated expressions.
+func (n *NvimServer) setAnnotation(expr string, add bool) []any {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	exprs := n.exprs[:0:0]
+	for _, e := range n.exprs {
+		if e != expr {
+			exprs = append(exprs, e)
+		}
+	}
+	if add {
+		exprs = append(exprs, expr)
+	}
+	n.exprs = exprs
+
+	out := make([]any, len(exprs))
+	for i, e := range exprs {
+		out[i] = e
+	}
+	return out
+}
+
+// state describes the target after ev for the plugin: the event, the signs
+// to place and the annotations for the stop line.
+func (n *NvimServer) state(ev Event) map[string]any {
+	event := map[string]any{"type": ev.Type, "pid": ev.Pid}
+	for k, v := range map[string]string{"pc": ev.PC, "func": ev.Func, "file": ev.File, "reason": ev.Reason, "detail": ev.Detail} {
+		if v != "" {
+			event[k] = v
+		}
+	}
+	if ev.Line != 0 {
+		event["line"] = ev.Line
+	}
+	if ev.Status != nil {
+		event["status"] = *ev.Status
+	}
+
+	return map[string]any{
+		"event":       event,
+		"signs":       n.signs(ev),
+		"annotations": n.annotations(ev),
+	}
+}
+
+// signs lists the sign placements: the breakpoints, and the current line
+// while the target is stopped.
+func (n *NvimServer) signs(ev Event) []any {
+	signs := []any{}
+	for _, b := range n.Session.Breakpoints() {
+		signs = append(signs, map[string]any{"name": "DedebuggerBreakpoint", "file": b.File, "line": b.Line})
+	}
+	if ev.Type == "stop" && ev.File != "" {
+		signs = append(signs, map[string]any{"name": "DedebuggerPC", "file": ev.File, "line": ev.Line})
+	}
+	return signs
+}
+
+// annotations evaluates the annotated expressions for virtual text on the
+// stop line.
+func (n *NvimServer) annotations(ev Event) []any {
+	annotations := []any{}
+	if ev.Type != "stop" || ev.File == "" {
+		return annotations
+	}
+
+	n.mu.Lock()
+	exprs := n.exprs
+	n.mu.Unlock()
+	for _, expr := range exprs {
+		text := expr + " = "
+		if v, err := n.Session.Eval(expr); err != nil {
+			text += "<" + err.Error() + ">"
+		} else {
+			text += fmt.Sprint(v)
+		}
+		annotations = append(annotations, map[string]any{"file": ev.File, "line": ev.Line, "text": text})
+	}
+	return annotations
+}
@@ -0,0 +1,232 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Thrift compact protocol types, used by the Parquet metadata.
+const (
+	tcI32    = 5
+	tcI64    = 6
+	tcBinary = 8
+	tcList   = 9
+	tcStruct = 12
+)
+
+// tcWriter encodes Thrift structs with the compact protocol. Field ids are
+// written as deltas from the previous field of the same struct.
+type tcWriter struct {
+	buf  []byte
+	last []int // the last field id of each open struct
+}
+
+func (w *tcWriter) field(id, typ int) {
+	last := &w.last[len(w.last)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		w.buf = append(w.buf, byte(d<<4|typ))
+	} else {
+		w.buf = append(w.buf, byte(typ))
+		w.buf = binary.AppendVarint(w.buf, int64(id))
+	}
+	*last = id
+}
+
+func (w *tcWriter) i32(id int, v int32) {
+	w.field(id, tcI32)
+	w.buf = binary.AppendVarint(w.buf, int64(v))
+}
+
+func (w *tcWriter) i64(id int, v int64) {
+	w.field(id, tcI64)
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *tcWriter) string(id int, s string) {
+	w.field(id, tcBinary)
+	w.appendString(s)
+}
+
+func (w *tcWriter) appendString(s string) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// list starts field id, a list of n elements of type typ, which follow.
+func (w *tcWriter) list(id, typ, n int) {
+	w.field(id, tcList)
+	if n < 15 {
+		w.buf = append(w.buf, byte(n<<4|typ))
+	} else {
+		w.buf = append(w.buf, byte(0xf0|typ))
+		w.buf = binary.AppendUvarint(w.buf, uint64(n))
+	}
+}
+
+// begin starts a struct: field id or, with id 0, a list element or the
+// top-level struct.
+func (w *tcWriter) begin(id int) {
+	if id != 0 {
+		w.field(id, tcStruct)
+	}
+	w.last = append(w.last, 0)
+}
+
+func (w *tcWriter) end() {
+	w.buf = append(w.buf, 0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+// Parquet physical types, repetitions and converted types.
+const (
+	pqInt64     = 2
+	pqDouble    = 5
+	pqByteArray = 6
+
+	pqRequired = 0
+	pqOptional = 1
+
+	pqUTF8            = 0
+	pqTimestampMicros = 10
+)
+
+// pqColumn is a column of a Parquet file. Values are given as text and
+// encoded as the column's type; optional columns have nulls where present
+// is false.
+type pqColumn struct {
+	name     string
+	typ      int
+	conv     int // converted type, -1 for none
+	optional bool
+	values   []string
+	present  []bool
+}
+
+// writeParquet writes a Parquet file of one row group holding columns,
+// which have rows values each. Pages are plain encoded and uncompressed,
+// which every reader accepts.
+func writeParquet(out io.Writer, columns []pqColumn, rows int) error {
+	file := []byte("PAR1")
+	offsets := make([]int64, len(columns))
+	sizes := make([]int64, len(columns))
+	for i, c := range columns {
+		page := c.encode()
+		var h tcWriter
+		h.begin(0)
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(page)))
+		h.i32(3, int32(len(page)))
+		h.begin(5)
+		h.i32(1, int32(rows))
+		h.i32(2, 0) // PLAIN
+		h.i32(3, 3) // RLE definition levels
+		h.i32(4, 3) // RLE repetition levels
+		h.end()
+		h.end()
+
+		offsets[i] = int64(len(file))
+		sizes[i] = int64(len(h.buf) + len(page))
+		file = append(append(file, h.buf...), page...)
+	}
+
+	var m tcWriter
+	m.begin(0)
+	m.i32(1, 1) // version
+	m.list(2, tcStruct, len(columns)+1)
+	m.begin(0)
+	m.string(4, "schema")
+	m.i32(5, int32(len(columns)))
+	m.end()
+	for _, c := range columns {
+		m.begin(0)
+		m.i32(1, int32(c.typ))
+		if c.optional {
+			m.i32(3, pqOptional)
+		} else {
+			m.i32(3, pqRequired)
+		}
+		m.string(4, c.name)
+		if c.conv >= 0 {
+			m.i32(6, int32(c.conv))
+		}
+		m.end()
+	}
+	m.i64(3, int64(rows))
+	var total int64
+	for _, s := range sizes {
+		total += s
+	}
+	m.list(4, tcStruct, 1)
+	m.begin(0)
+	m.list(1, tcStruct, len(columns))
+	for i, c := range columns {
+		m.begin(0)
+		m.i64(2, offsets[i])
+		m.begin(3)
+		m.i32(1, int32(c.typ))
+		m.list(2, tcI32, 2)
+		m.buf = binary.AppendVarint(m.buf, 0) // PLAIN
+		m.buf = binary.AppendVarint(m.buf, 3) // RLE
+		m.list(3, tcBinary, 1)
+		m.appendString(c.name)
+		m.i32(4, 0) // UNCOMPRESSED
+		m.i64(5, int64(rows))
+		m.i64(6, sizes[i])
+		m.i64(7, sizes[i])
+		m.i64(9, offsets[i])
+		m.end()
+		m.end()
+	}
+	m.i64(2, total)
+	m.i64(3, int64(rows))
+	m.end()
+	m.string(6, "dedebugger")
+	m.end()
+
+	file = append(file, m.buf...)
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(m.buf)))
+	file = append(file, "PAR1"...)
+	_, err := out.Write(file)
+	return err
+}
+
+// encode returns the data of the page of c: the definition levels of an
+// optional column, then its non-null values.
+func (c *pqColumn) encode() []byte {
+	var page []byte
+	if c.optional {
+		// One bit-packed run of 1-bit levels, in groups of eight.
+		groups := (len(c.present) + 7) / 8
+		levels := binary.AppendUvarint(nil, uint64(groups<<1|1))
+		bits := make([]byte, groups)
+		for i, p := range c.present {
+			if p {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		levels = append(levels, bits...)
+		page = binary.LittleEndian.AppendUint32(page, uint32(len(levels)))
+		page = append(page, levels...)
+	}
+	for i, v := range c.values {
+		if c.optional && !c.present[i] {
+			continue
+		}
+		switch c.typ {
+		case pqInt64:
+			n, _ := strconv.ParseInt(v, 10, 64)
+			page = binary.LittleEndian.AppendUint64(page, uint64(n))
+		case pqDouble:
+			f, _ := strconv.ParseFloat(v, 64)
+			page = binary.LittleEndian.AppendUint64(page, math.Float64bits(f))
+		default:
+			page = binary.LittleEndian.AppendUint32(page, uint32(len(v)))
+			page = append(page, v...)
+		}
+	}
+	return page
+}
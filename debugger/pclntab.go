@@ -0,0 +1,272 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// pclntabFromSymbols returns the Go line table of exe when the linker left
+// it in another section, as external linkers may: runtime.pclntab and
+// runtime.epclntab bound it wherever it is.
+func pclntabFromSymbols(exe *elf.File, symbols map[string]uint64) ([]byte, error) {
+	start, end := symbols["runtime.pclntab"], symbols["runtime.epclntab"]
+	if start == 0 || end <= start {
+		return nil, ErrNoSymbolTable
+	}
+	for _, s := range exe.Sections {
+		if s.Type == elf.SHT_NOBITS || start < s.Addr || end > s.Addr+s.Size {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return nil, err
+		}
+		return data[start-s.Addr : end-s.Addr], nil
+	}
+	return nil, ErrNoSymbolTable
+}
+
+// pclntabFromDWARF builds a Go 1.2 line table, which debug/gosym reads like
+// the one the linker writes, from the functions in .debug_info and the
+// lines in .debug_line. Binaries whose Go line table can't be found then
+// still map addresses to lines and functions. Addresses are moved by bias,
+// the load bias of a position independent executable.
+func pclntabFromDWARF(data *dwarf.Data, bias uint64) ([]byte, error) {
+	if data == nil {
+		return nil, ErrNoDWARF
+	}
+	funcs, rows, err := dwarfFuncsAndLines(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(funcs) == 0 {
+		return nil, fmt.Errorf("%w: no functions in DWARF", ErrNoSymbolTable)
+	}
+
+	// Layout: header, function table, offset of the file table, the _func
+	// records with their pc-value tables, the names and the file table.
+	var w pclnWriter
+	n := len(funcs)
+	w.buf = make([]byte, 8+8+(2*n+1)*8+4)
+	binary.LittleEndian.PutUint32(w.buf, 0xfffffffb) // Go 1.2 magic
+	w.buf[6], w.buf[7] = 1, 8                        // pc quantum, pointer size
+	binary.LittleEndian.PutUint64(w.buf[8:], uint64(n))
+	const functab = 16
+
+	files := map[string]int32{}
+	var fileNames []string
+	fileNum := func(name string) int32 {
+		if name == "" {
+			return 0
+		}
+		if i, ok := files[name]; ok {
+			return i
+		}
+		fileNames = append(fileNames, name)
+		files[name] = int32(len(fileNames)) // 0 means no file
+		return files[name]
+	}
+
+	for i, fn := range funcs {
+		var pcfile, pcln pcValueTable
+		// The rows of fn are those from the last at or before its entry up
+		// to its end.
+		j := sort.Search(len(rows), func(j int) bool { return rows[j].addr > fn.entry }) - 1
+		for pc := fn.entry; pc < fn.end; j++ {
+			next, file, line := fn.end, int32(0), int32(0)
+			if j >= 0 && j < len(rows) {
+				file, line = fileNum(rows[j].file), int32(rows[j].line)
+			}
+			if j+1 < len(rows) {
+				next = min(max(rows[j+1].addr, pc), fn.end)
+			}
+			if next > pc {
+				pcfile.add(file, next-pc)
+				pcln.add(line, next-pc)
+			}
+			pc = next
+		}
+
+		binary.LittleEndian.PutUint64(w.buf[functab+16*i:], fn.entry+bias)
+		binary.LittleEndian.PutUint64(w.buf[functab+16*i+8:], uint64(len(w.buf)))
+		rec := w.reserve(8 + 8*4)
+		binary.LittleEndian.PutUint64(w.buf[rec:], fn.entry+bias)
+		field := func(k int, v uint32) { binary.LittleEndian.PutUint32(w.buf[rec+8+4*(k-1):], v) }
+		field(1, w.str(fn.name))
+		field(5, w.bytes(pcfile.end()))
+		field(6, w.bytes(pcln.end()))
+	}
+	binary.LittleEndian.PutUint64(w.buf[functab+16*n:], funcs[n-1].end+bias)
+
+	// The file table starts with its length, the index of the first file.
+	fileOffs := make([]uint32, len(fileNames))
+	for i, name := range fileNames {
+		fileOffs[i] = w.str(name)
+	}
+	filetab := w.reserve(4 * (len(fileNames) + 1))
+	binary.LittleEndian.PutUint32(w.buf[filetab:], uint32(len(fileNames)+1))
+	for i, off := range fileOffs {
+		binary.LittleEndian.PutUint32(w.buf[filetab+4*(i+1):], off)
+	}
+	binary.LittleEndian.PutUint32(w.buf[functab+(2*n+1)*8:], uint32(filetab))
+	return w.buf, nil
+}
+
+// dwarfFunc is a function described in .debug_info.
+type dwarfFunc struct {
+	name       string
+	entry, end uint64
+}
+
+// lineRow is a row of .debug_line: the code from addr to the next row is
+// at file:line.
+type lineRow struct {
+	addr uint64
+	file string
+	line int
+}
+
+// dwarfFuncsAndLines returns the functions with code, sorted by entry and
+// without overlaps, and the line rows of all compilation units sorted by
+// address. The end of a sequence is a row at line 0.
+func dwarfFuncsAndLines(data *dwarf.Data) ([]dwarfFunc, []lineRow, error) {
+	var funcs []dwarfFunc
+	var rows []lineRow
+	r := data.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if e == nil {
+			break
+		}
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			lr, err := data.LineReader(e)
+			if err != nil || lr == nil {
+				continue
+			}
+			var le dwarf.LineEntry
+			for lr.Next(&le) == nil {
+				row := lineRow{addr: le.Address, line: le.Line}
+				if le.File != nil && !le.EndSequence {
+					row.file = le.File.Name
+				} else {
+					row.line = 0
+				}
+				rows = append(rows, row)
+			}
+		case dwarf.TagSubprogram:
+			name, _ := e.Val(dwarf.AttrName).(string)
+			ranges, err := data.Ranges(e)
+			if name == "" || err != nil || len(ranges) == 0 {
+				break
+			}
+			funcs = append(funcs, dwarfFunc{name: name, entry: ranges[0][0], end: ranges[0][1]})
+		}
+		if e.Tag != dwarf.TagCompileUnit && e.Children {
+			r.SkipChildren()
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].addr < rows[j].addr })
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].entry < funcs[j].entry })
+	// The function table has no gaps or overlaps: each function ends where
+	// the next starts.
+	kept := funcs[:0]
+	for _, fn := range funcs {
+		if fn.end <= fn.entry {
+			continue
+		}
+		if len(kept) > 0 && kept[len(kept)-1].end > fn.entry {
+			if kept[len(kept)-1].entry == fn.entry {
+				continue
+			}
+			kept[len(kept)-1].end = fn.entry
+		}
+		kept = append(kept, fn)
+	}
+	return kept, rows, nil
+}
+
+// pclnWriter appends the parts of a line table to buf.
+type pclnWriter struct {
+	buf   []byte
+	names map[string]uint32
+}
+
+// reserve appends n zero bytes and returns their offset.
+func (w *pclnWriter) reserve(n int) int {
+	off := len(w.buf)
+	w.buf = append(w.buf, make([]byte, n)...)
+	return off
+}
+
+// bytes appends b and returns its offset.
+func (w *pclnWriter) bytes(b []byte) uint32 {
+	off := uint32(len(w.buf))
+	w.buf = append(w.buf, b...)
+	return off
+}
+
+// str appends s as a C string, once, and returns its offset.
+func (w *pclnWriter) str(s string) uint32 {
+	if off, ok := w.names[s]; ok {
+		return off
+	}
+	if w.names == nil {
+		w.names = make(map[string]uint32)
+	}
+	off := w.bytes(append([]byte(s), 0))
+	w.names[s] = off
+	return off
+}
+
+// pcValueTable encodes a pc-value table: for each run of code with the same
+// value, the change of the value, zig-zag encoded, and the run's length,
+// both as varints.
+type pcValueTable struct {
+	buf       []byte
+	prev, val int32
+	size      uint64 // of the pending run
+}
+
+func (t *pcValueTable) add(val int32, size uint64) {
+	if t.size > 0 && val == t.val {
+		t.size += size
+		return
+	}
+	if t.size > 0 {
+		t.flush()
+	}
+	t.val, t.size = val, size
+}
+
+// flush writes the pending run. The first changes the value from -1.
+func (t *pcValueTable) flush() {
+	if len(t.buf) == 0 {
+		t.prev = -1
+	}
+	delta := t.val - t.prev
+	uv := uint32(delta) << 1
+	if delta < 0 {
+		uv = uint32(^delta)<<1 | 1
+	}
+	t.buf = binary.AppendUvarint(t.buf, uint64(uv))
+	t.buf = binary.AppendUvarint(t.buf, t.size)
+	t.prev, t.size = t.val, 0
+}
+
+// end returns the encoded table, ended by a zero change.
+func (t *pcValueTable) end() []byte {
+	if t.size > 0 {
+		t.flush()
+	}
+	return append(t.buf, 0)
+}
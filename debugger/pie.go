@@ -0,0 +1,127 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadInfo is what relocating the symbols of a position independent
+// executable takes once it is loaded at an address of the kernel's choosing.
+type loadInfo struct {
+	pie      bool
+	linkBase uint64 // the address the start of the file is linked at
+	text     uint64 // the address .text is linked at
+	lines    []byte // the line table, nil if built from DWARF
+	gosymtab []byte
+}
+
+// newLoadInfo records how exe is laid out.
+func newLoadInfo(exe *elf.File, text uint64, lines, gosymtab []byte, fromDWARF bool) loadInfo {
+	l := loadInfo{pie: exe.Type == elf.ET_DYN, text: text, gosymtab: gosymtab}
+	if !fromDWARF {
+		l.lines = lines
+	}
+	for _, p := range exe.Progs {
+		if p.Type == elf.PT_LOAD && p.Off == 0 {
+			l.linkBase = p.Vaddr
+			break
+		}
+	}
+	return l
+}
+
+// relocate finds where the target pid, stopped or running, was loaded and,
+// for a position independent executable, moves the symbol table, the ELF
+// symbols and the DWARF lookups to there. Addresses in the symbol table are
+// then those of the process; DWARF keeps its own, which linkPC and loadAddr
+// translate.
+func (d *Debugger) relocate(pid int) error {
+	if !d.load.pie {
+		return nil
+	}
+	base, err := loadBase(pid)
+	if err != nil {
+		return fmt.Errorf("can't find where %d is loaded: %v", pid, err)
+	}
+	return d.relocateTo(base)
+}
+
+// relocateTo moves the symbols of a position independent executable to
+// base, the address the start of the file is loaded at.
+func (d *Debugger) relocateTo(base uint64) error {
+	if !d.load.pie {
+		return nil
+	}
+	bias := base - d.load.linkBase
+	if bias == d.loadBias {
+		return nil
+	}
+
+	var lines []byte
+	var err error
+	if d.load.lines != nil {
+		lines = d.load.lines
+	} else if lines, err = pclntabFromDWARF(d.Dwarf, bias); err != nil {
+		return err
+	}
+	// From Go 1.18 on the line table holds offsets from the start of
+	// .text, so relocating that relocates every function.
+	table, err := gosym.NewTable(d.load.gosymtab, gosym.NewLineTable(lines, d.load.text+bias))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoSymbolTable, err)
+	}
+	for name, v := range d.Symbols {
+		if v != 0 {
+			d.Symbols[name] = v - d.loadBias + bias
+		}
+	}
+	d.SymTable, d.loadBias = table, bias
+	d.instStarts = nil
+	if fn := d.SymTable.LookupFunc("main.main"); fn != nil {
+		d.TargetFile, _, d.Fn = d.SymTable.PCToLine(fn.Entry)
+	}
+	return nil
+}
+
+// loadBase returns the address the executable of pid is mapped at: the
+// start of the mapping of its first page.
+func loadBase(pid int) (uint64, error) {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return 0, err
+	}
+	maps, err := os.ReadFile(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return 0, err
+	}
+	// start-end perms offset dev inode path
+	s := bufio.NewScanner(bytes.NewReader(maps))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 || fields[5] != exe || strings.Trim(fields[2], "0") != "" {
+			continue
+		}
+		start, _, _ := strings.Cut(fields[0], "-")
+		return strconv.ParseUint(start, 16, 64)
+	}
+	return 0, fmt.Errorf("%s isn't mapped", exe)
+}
+
+// linkPC returns the address pc of the process had in the executable, as
+// DWARF knows it.
+func (d *Debugger) linkPC(pc uint64) uint64 {
+	return pc - d.loadBias
+}
+
+// loadAddr returns the address in the process of addr in the executable.
+func (d *Debugger) loadAddr(addr uint64) uint64 {
+	return addr + d.loadBias
+}
@@ -0,0 +1,272 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// pipeFrontend is the frontend of the first command of a pipeline, which
+// keeps its output for the stages after it. Stops are still shown.
+type pipeFrontend struct {
+	Frontend
+	out strings.Builder
+}
+
+func (f *pipeFrontend) ShowOutput(text string) {
+	f.out.WriteString(text)
+}
+
+// frontend returns the frontend the session shows its output in, even
+// while a pipeline keeps the output of its first command.
+func (d *Debugger) frontend() Frontend {
+	if p, ok := d.UI.(*pipeFrontend); ok {
+		return p.Frontend
+	}
+	return d.UI
+}
+
+// unpipedCommands are the commands whose arguments may hold a "|" of their
+// own: a shell command line, and the command of an alias, which may be a
+// pipeline.
+var unpipedCommands = map[string]bool{
+	"alias": true,
+	"shell": true,
+}
+
+// pipeFilters are the built-in stages of a pipeline. Each takes the lines
+// of the output before it and returns those it passes on.
+var pipeFilters = map[string]func(args, lines []string) ([]string, error){
+	"grep":  grepFilter,
+	"count": countFilter,
+	"head":  headFilter,
+	"tail":  tailFilter,
+	"sort":  sortFilter,
+	"uniq":  uniqFilter,
+}
+
+// splitPipeline splits input into the stages of a pipeline at each "|"
+// outside double quotes. "||", as in a condition, doesn't split it.
+func splitPipeline(input string) []string {
+	var stages []string
+	quoted, start := false, 0
+	for i := 0; i < len(input); i++ {
+		switch c := input[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '|' && !quoted:
+			if i+1 < len(input) && input[i+1] == '|' {
+				i++
+				continue
+			}
+			stages = append(stages, strings.TrimSpace(input[start:i]))
+			start = i + 1
+		}
+	}
+	return append(stages, strings.TrimSpace(input[start:]))
+}
+
+// runPipeline runs the command of the first of stages with its output kept,
+// then passes the output through the other stages, the built-in filters or
+// external commands run by the shell, and shows what comes out of the last.
+// It reports whether the command continues the target.
+func (d *Debugger) runPipeline(pid int, stages []string) bool {
+	for _, s := range stages {
+		if s == "" {
+			d.println("usage: <command> | <filter> [| <filter>...]")
+			return false
+		}
+	}
+	ui := d.UI
+	capture := &pipeFrontend{Frontend: ui}
+	d.UI = capture
+	found, cont := d.wordCommand(pid, stages[0])
+	d.UI = ui
+	if !found {
+		d.printf("Unexpected input %s\n", stages[0])
+		return false
+	}
+	if d.canceled() != nil {
+		return cont
+	}
+
+	lines := strings.Split(strings.TrimSuffix(capture.out.String(), "\n"), "\n")
+	if capture.out.Len() == 0 {
+		lines = nil
+	}
+	for i, stage := range stages[1:] {
+		fields := strings.Fields(stage)
+		if filter, ok := pipeFilters[fields[0]]; ok {
+			var err error
+			if lines, err = filter(fields[1:], lines); err != nil {
+				d.printf("%s: %v\n", fields[0], err)
+				return cont
+			}
+			continue
+		}
+		last := i == len(stages)-2
+		out, err := d.pipeCommand(stage, lines, last)
+		if err != nil {
+			d.println(err)
+			return cont
+		}
+		if last && out == nil {
+			// It wrote to the terminal itself, as a pager does.
+			return cont
+		}
+		lines = out
+	}
+	if len(lines) > 0 {
+		d.UI.ShowOutput(strings.Join(lines, "\n") + "\n")
+	}
+	return cont
+}
+
+// pipeCommand runs the external command of a stage of a pipeline through
+// the shell, with lines as its input. The last stage at a terminal has the
+// terminal to itself, so that a pager like less works, and returns nil;
+// the others return the lines of its output.
+func (d *Debugger) pipeCommand(command string, lines []string, last bool) ([]string, error) {
+	var in bytes.Buffer
+	for _, l := range lines {
+		in.WriteString(l)
+		in.WriteByte('\n')
+	}
+	cmd := exec.Command(cmp.Or(os.Getenv("SHELL"), "/bin/sh"), "-c", command)
+	cmd.Stdin = &in
+	if ui, ok := d.UI.(*LineFrontend); ok && last && ui.editor != nil {
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := runInForeground(cmd); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return []string{}, nil
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"), nil
+}
+
+// grepFilter handles "grep [-v] [-i] <regexp>", which passes on the lines
+// that match regexp, or with -v those that don't. The regexp is the rest of
+// the stage, spaces included, as in "grep chan receive".
+func grepFilter(args, lines []string) ([]string, error) {
+	invert, fold := false, false
+	for len(args) > 0 && (args[0] == "-v" || args[0] == "-i") {
+		invert = invert || args[0] == "-v"
+		fold = fold || args[0] == "-i"
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("usage: grep [-v] [-i] <regexp>")
+	}
+	expr := strings.Join(args, " ")
+	if fold {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, l := range lines {
+		if re.MatchString(l) != invert {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+// countFilter handles "count", which passes on the number of lines.
+func countFilter(args, lines []string) ([]string, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("usage: count")
+	}
+	return []string{strconv.Itoa(len(lines))}, nil
+}
+
+// filterLines returns the number of lines a head or tail stage takes, 10
+// unless args says.
+func filterLines(name string, args []string) (int, error) {
+	switch {
+	case len(args) == 0:
+		return 10, nil
+	case len(args) == 1:
+		if n, err := strconv.Atoi(strings.TrimPrefix(args[0], "-")); err == nil && n >= 0 {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("usage: %s [<lines>]", name)
+}
+
+// headFilter handles "head [<lines>]", which passes on the first lines.
+func headFilter(args, lines []string) ([]string, error) {
+	n, err := filterLines("head", args)
+	if err != nil {
+		return nil, err
+	}
+	return lines[:min(n, len(lines))], nil
+}
+
+// tailFilter handles "tail [<lines>]", which passes on the last lines.
+func tailFilter(args, lines []string) ([]string, error) {
+	n, err := filterLines("tail", args)
+	if err != nil {
+		return nil, err
+	}
+	return lines[len(lines)-min(n, len(lines)):], nil
+}
+
+// sortFilter handles "sort [-r]", which sorts the lines, or with -r sorts
+// them in reverse.
+func sortFilter(args, lines []string) ([]string, error) {
+	if len(args) > 1 || len(args) == 1 && args[0] != "-r" {
+		return nil, fmt.Errorf("usage: sort [-r]")
+	}
+	out := slices.Clone(lines)
+	slices.Sort(out)
+	if len(args) == 1 {
+		slices.Reverse(out)
+	}
+	return out, nil
+}
+
+// uniqFilter handles "uniq [-c]", which drops the lines that repeat the one
+// before them, or with -c puts before each line how many times it came.
+func uniqFilter(args, lines []string) ([]string, error) {
+	if len(args) > 1 || len(args) == 1 && args[0] != "-c" {
+		return nil, fmt.Errorf("usage: uniq [-c]")
+	}
+	var out []string
+	var counts []int
+	for i, l := range lines {
+		if i > 0 && l == lines[i-1] {
+			counts[len(counts)-1]++
+			continue
+		}
+		out = append(out, l)
+		counts = append(counts, 1)
+	}
+	if len(args) == 1 {
+		for i := range out {
+			out[i] = fmt.Sprintf("%7d %s", counts[i], out[i])
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,233 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Plugin is a Go plugin the target opened with plugin.Open. Its symbol
+// table is moved to where the plugin is loaded, so breakpoints and stops in
+// its code work like those in the executable.
+type Plugin struct {
+	Path  string
+	Base  uint64 // the address its first page is mapped at
+	Table *gosym.Table
+}
+
+// pluginLoadFunc is called by plugin.Open once the plugin is mapped and
+// before its init functions run: the moment to read its symbols.
+const pluginLoadFunc = "plugin.lastmoduleinit"
+
+// planPluginTrap sets a trap where plugins are loaded, if the target can
+// open any, and reads the symbols of those it already has, as when
+// attaching.
+func (d *Debugger) planPluginTrap(pid int) {
+	d.Plugins, d.pluginFiles = nil, nil
+	fn := d.SymTable.LookupFunc(pluginLoadFunc)
+	if fn == nil {
+		return
+	}
+	d.loadPlugins(pid)
+	if err := d.addTrap(fn.Entry); err != nil {
+		d.printf("Can't watch for plugins: %v\n", err)
+		return
+	}
+	if err := d.flushTraps(pid); d.armedTrap(fn.Entry) == nil {
+		d.printf("Can't watch for plugins: %v\n", err)
+		return
+	}
+	d.pluginTrap = fn.Entry
+}
+
+// pluginStop handles a hit of the trap where plugins are loaded: the new
+// plugin's symbols are read, pending breakpoints set, and the target
+// resumed.
+func (d *Debugger) pluginStop(pid int, addr uint64) (string, bool, error) {
+	if d.pluginTrap == 0 || addr != d.pluginTrap {
+		return "", false, nil
+	}
+	if d.loadPlugins(pid) {
+		d.setPendingBreaks(pid)
+	}
+	return "", true, d.ResumeFromBreakpoint(pid)
+}
+
+// loadPlugins reads the symbols of the Go plugins mapped into pid that
+// aren't loaded yet, and reports whether there were any. Shared libraries
+// without a Go line table, such as libc, are remembered and skipped.
+func (d *Debugger) loadPlugins(pid int) bool {
+	exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	maps, err := os.ReadFile(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		d.printf("Can't look for plugins: %v\n", err)
+		return false
+	}
+	if d.pluginFiles == nil {
+		d.pluginFiles = make(map[string]bool)
+	}
+	loaded := false
+	// start-end perms offset dev inode path
+	s := bufio.NewScanner(bytes.NewReader(maps))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 || strings.Trim(fields[2], "0") != "" {
+			continue
+		}
+		path := fields[5]
+		if !strings.HasPrefix(path, "/") || path == exe || d.pluginFiles[path] {
+			continue
+		}
+		d.pluginFiles[path] = true
+		start, _, _ := strings.Cut(fields[0], "-")
+		base, err := strconv.ParseUint(start, 16, 64)
+		if err != nil {
+			continue
+		}
+		p, err := openPlugin(path, base)
+		switch {
+		case err != nil:
+			d.printf("Can't read the symbols of %s: %v\n", path, err)
+		case p != nil:
+			d.Plugins = append(d.Plugins, p)
+			d.printf("Loaded plugin %s at %#x (%d functions)\n", path, base, len(p.Table.Funcs))
+			loaded = true
+		}
+	}
+	return loaded
+}
+
+// openPlugin reads the symbol table of the shared object at path, mapped at
+// base. Objects that aren't Go plugins give nil.
+func openPlugin(path string, base uint64) (*Plugin, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+	// Plugins are linked externally, so the line table counts from
+	// runtime.text rather than from the start of .text.
+	pclntab, text := f.Section(".gopclntab"), f.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil, nil
+	}
+	lines, err := pclntab.Data()
+	if err != nil {
+		return nil, err
+	}
+	var symtab []byte
+	if s := f.Section(".gosymtab"); s != nil {
+		if symtab, err = s.Data(); err != nil {
+			return nil, err
+		}
+	}
+	var linkBase uint64
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD && p.Off == 0 {
+			linkBase = p.Vaddr
+			break
+		}
+	}
+	start := text.Addr
+	if syms, err := f.Symbols(); err == nil {
+		for _, s := range syms {
+			if s.Name == "runtime.text" {
+				start = s.Value
+				break
+			}
+		}
+	}
+	bias := base - linkBase
+	table, err := gosym.NewTable(symtab, gosym.NewLineTable(lines, start+bias))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoSymbolTable, err)
+	}
+	return &Plugin{Path: path, Base: base, Table: table}, nil
+}
+
+// symTables returns the symbol tables of the target: the executable's,
+// then those of its plugins in the order they were loaded.
+func (d *Debugger) symTables() []*gosym.Table {
+	var tables []*gosym.Table
+	// A MockProcess may run without any.
+	if d.SymTable != nil {
+		tables = append(tables, d.SymTable)
+	}
+	for _, p := range d.Plugins {
+		tables = append(tables, p.Table)
+	}
+	return tables
+}
+
+// pcToLine is SymTable.PCToLine over the executable and its plugins.
+func (d *Debugger) pcToLine(pc uint64) (string, int, *gosym.Func) {
+	for _, t := range d.symTables() {
+		if file, line, fn := t.PCToLine(pc); fn != nil {
+			return file, line, fn
+		}
+	}
+	return "", 0, nil
+}
+
+// pcToFunc is SymTable.PCToFunc over the executable and its plugins.
+func (d *Debugger) pcToFunc(pc uint64) *gosym.Func {
+	for _, t := range d.symTables() {
+		if fn := t.PCToFunc(pc); fn != nil {
+			return fn
+		}
+	}
+	return nil
+}
+
+// lineToPC is SymTable.LineToPC over the executable and its plugins. The
+// line a function starts on is at the end of its prologue, which the
+// function runs once per call.
+func (d *Debugger) lineToPC(file string, line int) (uint64, *gosym.Func, error) {
+	var first error
+	for _, t := range d.symTables() {
+		pc, fn, err := t.LineToPC(file, line)
+		if err == nil && fn != nil && pc == fn.Entry {
+			return d.afterPrologue(fn), fn, nil
+		}
+		if err == nil {
+			return d.alignInstruction(pc), fn, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return 0, nil, first
+}
+
+// breakPending keeps the arguments of a break command whose location no
+// symbol table has yet, in a target that can open plugins, to run it again
+// when a plugin is loaded. It reports whether they were kept.
+func (d *Debugger) breakPending(args []string) bool {
+	if d.pluginTrap == 0 {
+		return false
+	}
+	d.pendingBreaks = append(d.pendingBreaks, args)
+	d.printf("Breakpoint pending on %s, set when a plugin that has it is loaded\n", args[0])
+	return true
+}
+
+// setPendingBreaks sets the pending breakpoints the plugins now loaded
+// have, and keeps the others pending.
+func (d *Debugger) setPendingBreaks(pid int) {
+	var still [][]string
+	for _, args := range d.pendingBreaks {
+		if _, _, err := d.parseLocation(args[0]); err != nil {
+			still = append(still, args)
+			continue
+		}
+		d.breakCommand(pid, args)
+	}
+	d.pendingBreaks = still
+}
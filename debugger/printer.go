@@ -0,0 +1,169 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Printer formats the values of a struct type from a template over its
+// fields, as registered with "printer". In the template, {path} stands for
+// the field path of the value, e.g. {Name} or {Owner.Email}, with pointers
+// followed on the way; strings are inserted as they are and other values
+// as print shows them. {{ and }} stand for braces.
+type Printer struct {
+	Type     string
+	Template string
+	parts    []printerPart
+}
+
+// printerPart is a piece of a template: text, or a field path if path is
+// set.
+type printerPart struct {
+	text string
+	path []string
+}
+
+// printerCommand handles "printer <type> <template>", which makes values
+// of the struct type print through the template, "printer -d <type>",
+// which removes it, and "printer" alone, which lists them. Types of
+// package main can be named without "main.". Put in the rc file, printers
+// apply to every session of a project.
+func (d *Debugger) printerCommand(pid int, args []string) bool {
+	switch {
+	case len(args) == 0:
+		d.listPrinters()
+	case args[0] == "-d":
+		if len(args) != 2 {
+			d.println("usage: printer -d <type>")
+			return false
+		}
+		name := printerTypeName(args[1])
+		if d.printers[name] == nil {
+			d.printf("No printer for %s\n", name)
+			return false
+		}
+		delete(d.printers, name)
+	case len(args) < 2:
+		d.println("usage: printer <type> <template> | printer -d <type>")
+	default:
+		if err := d.AddPrinter(args[0], strings.Join(args[1:], " ")); err != nil {
+			d.println(err)
+		}
+	}
+	return false
+}
+
+// AddPrinter makes values of the struct type typeName print through
+// template, as described for Printer, replacing any printer it had.
+func (d *Debugger) AddPrinter(typeName, template string) error {
+	parts, err := parseTemplate(template)
+	if err != nil {
+		return err
+	}
+	name := printerTypeName(typeName)
+	if d.printers == nil {
+		d.printers = make(map[string]*Printer)
+	}
+	d.printers[name] = &Printer{Type: name, Template: template, parts: parts}
+	return nil
+}
+
+// printerTypeName qualifies a type name without a package with "main.".
+func printerTypeName(name string) string {
+	if !strings.Contains(name, ".") {
+		return "main." + name
+	}
+	return name
+}
+
+func (d *Debugger) listPrinters() {
+	if len(d.printers) == 0 {
+		d.println("No printers")
+		return
+	}
+	names := make([]string, 0, len(d.printers))
+	for name := range d.printers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d.printf("  %s: %s\n", name, d.printers[name].Template)
+	}
+}
+
+// parseTemplate splits a printer template into text and field paths.
+func parseTemplate(tmpl string) ([]printerPart, error) {
+	var parts []printerPart
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			parts = append(parts, printerPart{text: text.String()})
+			text.Reset()
+		}
+	}
+	for i := 0; i < len(tmpl); i++ {
+		switch c := tmpl[i]; {
+		case strings.HasPrefix(tmpl[i:], "{{"), strings.HasPrefix(tmpl[i:], "}}"):
+			text.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(tmpl[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed { in %q", tmpl)
+			}
+			field := strings.TrimSpace(tmpl[i+1 : i+end])
+			if field == "" {
+				return nil, fmt.Errorf("empty field in %q", tmpl)
+			}
+			flush()
+			parts = append(parts, printerPart{path: strings.Split(field, ".")})
+			i += end
+		case c == '}':
+			return nil, fmt.Errorf("unmatched } in %q", tmpl)
+		default:
+			text.WriteByte(c)
+		}
+	}
+	flush()
+	return parts, nil
+}
+
+// formatPrinter formats the value of type t in buf through p. Fields that
+// can't be read are shown as the error.
+func (d *Debugger) formatPrinter(pid int, p *Printer, t *dwarf.StructType, buf []byte, depth int) string {
+	var b strings.Builder
+	for _, part := range p.parts {
+		if part.path == nil {
+			b.WriteString(part.text)
+			continue
+		}
+		val, err := d.selectPath(pid, value{typ: t, buf: buf}, part.path)
+		if err != nil {
+			fmt.Fprintf(&b, "<%v>", err)
+			continue
+		}
+		typ := val.typ
+		for {
+			td, ok := typ.(*dwarf.TypedefType)
+			if !ok {
+				break
+			}
+			typ = td.Type
+		}
+		if st, ok := typ.(*dwarf.StructType); ok && st.StructName == "string" {
+			s, err := d.stringFromHeader(pid, val.buf)
+			if err != nil {
+				fmt.Fprintf(&b, "<%v>", err)
+				continue
+			}
+			b.WriteString(s)
+			continue
+		}
+		b.WriteString(d.formatValue(pid, val.typ, val.buf, depth+1))
+	}
+	return b.String()
+}
@@ -0,0 +1,97 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"syscall"
+)
+
+// ProcessController is how the debugger controls the threads of the target
+// once it runs: their memory and registers, resuming them and waiting for
+// their next event. PtraceController drives a Linux process with ptrace;
+// set Debugger.Proc to drive another backend, or MockProcess, instead.
+// Launching and attaching, which set the process up, use ptrace directly.
+type ProcessController interface {
+	// ReadMemory and WriteMemory access the memory of the stopped thread
+	// tid. Like MemoryReader and MemoryWriter they return the number of
+	// bytes transferred and, if that is short, why.
+	ReadMemory(tid int, addr uint64, buf []byte) (int, error)
+	WriteMemory(tid int, addr uint64, data []byte) (int, error)
+	GetRegs(tid int, regs *syscall.PtraceRegs) error
+	SetRegs(tid int, regs *syscall.PtraceRegs) error
+	// Cont continues tid, delivering signal sig unless it is 0. Step
+	// executes one instruction of tid.
+	Cont(tid, sig int) error
+	Step(tid int) error
+	// Wait waits for the next event of thread pid, or of any thread in the
+	// process group -pid for a negative pid, as wait4 with __WALL does.
+	Wait(pid int, ws *syscall.WaitStatus, ru *syscall.Rusage) (int, error)
+	// SigCode returns the si_code of the signal tid is stopped with,
+	// which tells traps from other SIGTRAPs.
+	SigCode(tid int) (int32, bool)
+}
+
+// PtraceController is the ProcessController of a Linux process traced
+// with ptrace.
+type PtraceController struct{}
+
+func (PtraceController) ReadMemory(tid int, addr uint64, buf []byte) (int, error) {
+	return vmMemory{tid, ptraceMemory{tid}}.ReadMemory(addr, buf)
+}
+
+// WriteMemory writes with ptrace: text pages are read-only, which
+// process_vm_writev respects, and breakpoints go there.
+func (PtraceController) WriteMemory(tid int, addr uint64, data []byte) (int, error) {
+	return ptraceMemory{tid}.WriteMemory(addr, data)
+}
+
+func (PtraceController) GetRegs(tid int, regs *syscall.PtraceRegs) error {
+	return syscall.PtraceGetRegs(tid, regs)
+}
+
+func (PtraceController) SetRegs(tid int, regs *syscall.PtraceRegs) error {
+	return syscall.PtraceSetRegs(tid, regs)
+}
+
+func (PtraceController) Cont(tid, sig int) error {
+	return syscall.PtraceCont(tid, sig)
+}
+
+func (PtraceController) Step(tid int) error {
+	return syscall.PtraceSingleStep(tid)
+}
+
+func (PtraceController) Wait(pid int, ws *syscall.WaitStatus, ru *syscall.Rusage) (int, error) {
+	return syscall.Wait4(pid, ws, syscall.WALL, ru)
+}
+
+func (PtraceController) SigCode(tid int) (int32, bool) {
+	return sigCode(tid)
+}
+
+// proc returns the controller of the target: d.Proc, or ptrace.
+func (d *Debugger) proc() ProcessController {
+	var c ProcessController = PtraceController{}
+	if d.Proc != nil {
+		c = d.Proc
+	}
+	if d.pages.on {
+		return cachingController{c, &d.pages}
+	}
+	return c
+}
+
+// controllerMemory is the memory of thread tid as a MemoryReader and
+// MemoryWriter.
+type controllerMemory struct {
+	c   ProcessController
+	tid int
+}
+
+func (m controllerMemory) ReadMemory(addr uint64, buf []byte) (int, error) {
+	return m.c.ReadMemory(m.tid, addr, buf)
+}
+
+func (m controllerMemory) WriteMemory(addr uint64, data []byte) (int, error) {
+	return m.c.WriteMemory(m.tid, addr, data)
+}
@@ -0,0 +1,58 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// progress reports the progress of a slow task, such as loading the symbols
+// of a big binary, so the debugger doesn't appear hung. It draws a bar on
+// stderr when that is a terminal and emits "progress" events.
+type progress struct {
+	d     *Debugger
+	task  string
+	done  int64
+	total int64
+	tty   bool
+}
+
+// startProgress starts reporting a task made of total units of work.
+func (d *Debugger) startProgress(task string, total int64) *progress {
+	return &progress{
+		d:     d,
+		task:  task,
+		total: max(total, 1),
+		tty:   IsTerminal(int(os.Stderr.Fd())),
+	}
+}
+
+// phase reports that the next n units of work, described by name, are
+// starting.
+func (p *progress) phase(name string, n int64) {
+	p.report(name)
+	p.done = min(p.done+n, p.total)
+}
+
+// finish reports the task as complete.
+func (p *progress) finish() {
+	p.done = p.total
+	p.report("done")
+	if p.tty {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func (p *progress) report(phase string) {
+	p.d.Emit(Event{Type: "progress", Detail: p.task + ": " + phase, Done: p.done, Total: p.total})
+	if !p.tty {
+		return
+	}
+
+	const width = 30
+	filled := int(p.done * width / p.total)
+	fmt.Fprintf(os.Stderr, "\r%s [%s%s] %3d%% %-24s", p.task,
+		strings.Repeat("#", filled), strings.Repeat(".", width-filled), p.done*100/p.total, phase)
+}
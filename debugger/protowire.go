@@ -0,0 +1,108 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Protocol buffer wire types.
+const (
+	pbVarint = 0
+	pbBytes  = 2
+)
+
+// pbWriter encodes the protocol buffer messages of rpc/dedebugger.proto.
+// Zero values are omitted, as proto3 does for fields without presence.
+type pbWriter struct {
+	buf []byte
+}
+
+func (w *pbWriter) tag(field, wire int) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field)<<3|uint64(wire))
+}
+
+func (w *pbWriter) uint(field int, v uint64) {
+	if v != 0 {
+		w.tag(field, pbVarint)
+		w.buf = binary.AppendUvarint(w.buf, v)
+	}
+}
+
+// int encodes an int32 or int64 field; negative values take ten bytes.
+func (w *pbWriter) int(field int, v int64) {
+	w.uint(field, uint64(v))
+}
+
+// optionalInt encodes a field with explicit presence, even when zero.
+func (w *pbWriter) optionalInt(field int, v *int) {
+	if v != nil {
+		w.tag(field, pbVarint)
+		w.buf = binary.AppendUvarint(w.buf, uint64(int64(*v)))
+	}
+}
+
+func (w *pbWriter) bytes(field int, b []byte) {
+	w.tag(field, pbBytes)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *pbWriter) string(field int, s string) {
+	if s != "" {
+		w.bytes(field, []byte(s))
+	}
+}
+
+// pbField is one decoded field of a message.
+type pbField struct {
+	num   int
+	wire  int
+	value uint64
+	data  []byte
+}
+
+// pbParse splits a message into its fields. Only varint and length
+// delimited fields are used by the protocol; fixed width fields are skipped.
+func pbParse(b []byte) ([]pbField, error) {
+	var fields []pbField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("bad field tag")
+		}
+		b = b[n:]
+		f := pbField{num: int(key >> 3), wire: int(key & 7)}
+
+		switch f.wire {
+		case pbVarint:
+			f.value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("bad varint in field %d", f.num)
+			}
+			b = b[n:]
+		case pbBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, fmt.Errorf("bad length in field %d", f.num)
+			}
+			f.data = b[n : n+int(l)]
+			b = b[n+int(l):]
+		case 1:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("short fixed64 field %d", f.num)
+			}
+			b = b[8:]
+		case 5:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("short fixed32 field %d", f.num)
+			}
+			b = b[4:]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", f.wire)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
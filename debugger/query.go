@@ -0,0 +1,243 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// sampleCond is a comparison of a field of a sample with a literal, as in
+// "user.ID == 42", or a calledfrom test of the caller at a hit, as in
+// "calledfrom(main.worker)", whose op is calledFromOp.
+type sampleCond struct {
+	key, op, lit string
+}
+
+// calledFromOp is the op of calledfrom conditions. Samples don't keep the
+// stack, so these only work at hits, as breakpoint conditions.
+const calledFromOp = "calledfrom"
+
+// sampleOps are the comparisons of a query; longer ones are tried first so
+// ">=" isn't read as ">".
+var sampleOps = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+// parseSampleConds parses conditions joined with &&.
+func parseSampleConds(s string) ([]sampleCond, error) {
+	var conds []sampleCond
+	for _, part := range strings.Split(s, "&&") {
+		c, ok := parseSampleCond(part)
+		if !ok {
+			return nil, fmt.Errorf("bad condition %q: want <expr> <op> <value>", strings.TrimSpace(part))
+		}
+		conds = append(conds, c)
+	}
+	return conds, nil
+}
+
+func parseSampleCond(s string) (sampleCond, bool) {
+	if fn, ok := strings.CutPrefix(strings.TrimSpace(s), calledFromOp+"("); ok {
+		fn, ok = strings.CutSuffix(fn, ")")
+		fn = strings.TrimSpace(fn)
+		return sampleCond{op: calledFromOp, lit: fn}, ok && fn != ""
+	}
+	i, op := -1, ""
+	for _, o := range sampleOps {
+		if j := strings.Index(s, o); j >= 0 && (i < 0 || j < i) {
+			i, op = j, o
+		}
+	}
+	if i < 0 {
+		return sampleCond{}, false
+	}
+	c := sampleCond{key: strings.TrimSpace(s[:i]), op: op, lit: strings.TrimSpace(s[i+len(op):])}
+	return c, c.key != "" && c.lit != ""
+}
+
+// sampleField returns the field key of s: a collected expression, "hit",
+// "thread", "goroutine", "depth" if it was counted, or a register.
+func sampleField(b *Breakpoint, s *Sample, key string) (string, bool) {
+	if v, ok := s.Values[key]; ok {
+		return v, true
+	}
+	switch key {
+	case "hit":
+		return strconv.Itoa(s.Hit), true
+	case "thread":
+		return strconv.Itoa(s.Tid), true
+	case "goroutine":
+		return strconv.FormatUint(s.Goroutine, 10), true
+	case "depth":
+		if s.Depth > 0 {
+			return strconv.Itoa(s.Depth), true
+		}
+	}
+	if v, ok := registerValue(&s.Regs, key); ok {
+		return strconv.FormatUint(v, 10), true
+	}
+	return "", false
+}
+
+// match reports whether s, a sample of b, satisfies c. Samples without the
+// field don't.
+func (c sampleCond) match(b *Breakpoint, s *Sample) bool {
+	v, ok := sampleField(b, s, c.key)
+	if !ok {
+		return false
+	}
+	lit := c.lit
+	var order int
+	switch x, y, numeric := sampleNumbers(v, lit); {
+	case lit == "nil":
+		if c.op == "==" {
+			return isNilSample(v)
+		}
+		return c.op == "!=" && !isNilSample(v)
+	case numeric:
+		order = cmp.Compare(x, y)
+	default:
+		if u, err := strconv.Unquote(lit); err == nil {
+			lit = u
+		}
+		order = strings.Compare(v, lit)
+	}
+	switch c.op {
+	case "==":
+		return order == 0
+	case "!=":
+		return order != 0
+	case ">=":
+		return order >= 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case "<":
+		return order < 0
+	}
+	return false
+}
+
+// isNilSample reports whether a collected value is nil: pointers are
+// collected as addresses, and interfaces and other headers formatted with
+// their fields, as in "runtime.iface {tab: nil, data: nil}".
+func isNilSample(v string) bool {
+	if v == "nil" || v == "0" {
+		return true
+	}
+	_, fields, ok := strings.Cut(v, "{")
+	if fields, ok = strings.CutSuffix(fields, "}"); !ok {
+		return false
+	}
+	for _, f := range strings.Split(fields, ",") {
+		_, fv, _ := strings.Cut(f, ":")
+		if fv = strings.TrimSpace(fv); fv != "nil" && fv != "0" {
+			return false
+		}
+	}
+	return true
+}
+
+// sampleNumbers parses a field and a literal that are both numbers.
+func sampleNumbers(v, lit string) (float64, float64, bool) {
+	x, err1 := parseSampleNumber(v)
+	y, err2 := parseSampleNumber(lit)
+	return x, y, err1 == nil && err2 == nil
+}
+
+func parseSampleNumber(s string) (float64, error) {
+	if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return float64(n), nil
+	}
+	if n, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return float64(n), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// maxQueryRows bounds the samples "hits where" lists.
+const maxQueryRows = 100
+
+// querySamples handles the queries of "hits" over the samples of bps:
+//
+//	where <expr> <op> <value> [&& ...]   the samples that match
+//	count by <expr>                      how many samples have each value
+//	count by <expr> <op> <value>         how many match and how many don't
+//
+// Samples that didn't collect the expression are left out.
+func (d *Debugger) querySamples(bps []*Breakpoint, query string) {
+	if cond, ok := strings.CutPrefix(query, "where "); ok {
+		conds, err := parseSampleConds(cond)
+		if err != nil {
+			d.println(err)
+			return
+		}
+		if slices.ContainsFunc(conds, func(c sampleCond) bool { return c.op == calledFromOp }) {
+			d.println("calledfrom only applies to breakpoint conditions")
+			return
+		}
+		var out strings.Builder
+		matched, total := 0, 0
+		for _, b := range bps {
+			for i := range b.Samples {
+				s := &b.Samples[i]
+				total++
+				if !slices.ContainsFunc(conds, func(c sampleCond) bool { return !c.match(b, s) }) {
+					if matched++; matched <= maxQueryRows {
+						d.writeSampleLine(&out, b, s, len(bps) > 1)
+					}
+				}
+			}
+		}
+		if matched > maxQueryRows {
+			fmt.Fprintf(&out, "  ... %d more\n", matched-maxQueryRows)
+		}
+		fmt.Fprintf(&out, "%d of %d samples match\n", matched, total)
+		d.UI.ShowOutput(out.String())
+		return
+	}
+
+	key, ok := strings.CutPrefix(query, "count by ")
+	if !ok {
+		d.println("usage: hits [<breakpoint>] where <expr> <op> <value> [&& ...] | count by <expr>[ <op> <value>]")
+		return
+	}
+	key = strings.TrimSpace(key)
+	cond, isCond := parseSampleCond(key)
+	if isCond {
+		key = cond.key
+	}
+	counts := make(map[string]int)
+	for _, b := range bps {
+		for i := range b.Samples {
+			s := &b.Samples[i]
+			v, ok := sampleField(b, s, key)
+			if !ok {
+				continue
+			}
+			if isCond {
+				v = strconv.FormatBool(cond.match(b, s))
+			}
+			counts[v]++
+		}
+	}
+	if len(counts) == 0 {
+		d.println("No samples")
+		return
+	}
+	values := make([]string, 0, len(counts))
+	for v := range counts {
+		values = append(values, v)
+	}
+	slices.SortFunc(values, func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), strings.Compare(a, b))
+	})
+	var out strings.Builder
+	for _, v := range values {
+		fmt.Fprintf(&out, "  %8d  %s\n", counts[v], v)
+	}
+	d.UI.ShowOutput(out.String())
+}
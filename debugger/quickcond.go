@@ -0,0 +1,183 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"cmp"
+	"debug/dwarf"
+	"strconv"
+	"strings"
+)
+
+// condTiers is the condition of a breakpoint compiled for the address of
+// its hits: the terms that are quick checks of a word, made first, and the
+// rest, evaluated as expressions only when the quick ones hold.
+type condTiers struct {
+	pc    uint64
+	quick []quickCond
+	slow  []sampleCond
+}
+
+// quickCond is a comparison of a word with a constant, done at a hit
+// without evaluating an expression or formatting a value: the hit count,
+// the thread, a register, or a variable of an integer, bool or pointer
+// type held in a register or at a fixed place, as in "rax == 3",
+// "n > 100" or "err != nil".
+type quickCond struct {
+	key    string
+	op     string
+	reg    string    // a register, or
+	v      *Variable // a local variable or argument, or
+	addr   uint64    // the address of a global, if any
+	size   int64
+	signed bool
+	n      int64 // the constant, as a uint64 when not signed
+}
+
+// compileCond splits the condition of b into its quick and slow terms at
+// the address of the hit of the thread pid, stopped at d.Regs. Terms are
+// quick where they name what Eval would read in the same place: a
+// register, then a variable in scope, then a global, then the hit count or
+// the thread.
+// A breakpoint hit at another address, as one of several for an inlined
+// line, compiles its condition again.
+func (d *Debugger) compileCond(pid int, b *Breakpoint) *condTiers {
+	if b.tiers != nil && b.tiers.pc == d.Regs.Rip {
+		return b.tiers
+	}
+	t := &condTiers{pc: d.Regs.Rip}
+	for _, c := range b.cond {
+		if q, ok := d.quickTerm(c); ok {
+			t.quick = append(t.quick, q)
+		} else {
+			t.slow = append(t.slow, c)
+		}
+	}
+	b.tiers = t
+	return t
+}
+
+// quickTerm compiles c, if it can be a quick check.
+func (d *Debugger) quickTerm(c sampleCond) (quickCond, bool) {
+	q := quickCond{key: c.key, op: c.op}
+	switch c.op {
+	case "==", "!=", ">=", "<=", ">", "<":
+	default:
+		return q, false
+	}
+	if c.key == "depth" || c.key == "goroutine" || !isExprName(c.key) {
+		return q, false
+	}
+
+	var typ dwarf.Type
+	first, _, dotted := strings.Cut(c.key, ".")
+	if _, ok := registerValue(&d.Regs, c.key); ok {
+		q.reg = c.key
+	} else if v, err := d.LookupVariable(d.Regs.Rip, first); err == nil {
+		if dotted {
+			// A field, which takes an expression to reach.
+			return q, false
+		}
+		q.v, typ = v, v.Type
+	} else if addr, t, err := d.LookupGlobal(c.key); err == nil {
+		q.addr, typ = addr, t
+	} else if addr, t, err := d.LookupGlobal("main." + c.key); err == nil && !dotted {
+		q.addr, typ = addr, t
+	}
+
+	lit := c.lit
+	switch {
+	case q.reg != "":
+	case typ != nil:
+		q.size = typ.Size()
+		switch t := stripTypedefs(typ).(type) {
+		case *dwarf.IntType:
+			q.signed = true
+		case *dwarf.UintType:
+		case *dwarf.BoolType:
+			if lit != "true" && lit != "false" || c.op != "==" && c.op != "!=" {
+				return q, false
+			}
+			lit = "0"
+			if c.lit == "true" {
+				lit = "1"
+			}
+		case *dwarf.PtrType:
+			// Maps are pointers that are printed as maps.
+			if lit != "nil" || c.op != "==" && c.op != "!=" || strings.HasPrefix(typeName(t), "map<") {
+				return q, false
+			}
+			lit = "0"
+		default:
+			return q, false
+		}
+		if q.size != 1 && q.size != 2 && q.size != 4 && q.size != 8 {
+			return q, false
+		}
+	case c.key == "hit" || c.key == "thread":
+		q.signed = true
+	default:
+		return q, false
+	}
+
+	if n, err := strconv.ParseInt(lit, 0, 64); err == nil && (q.signed || n >= 0) {
+		q.n = n
+	} else if u, err := strconv.ParseUint(lit, 0, 64); err == nil && !q.signed {
+		q.n = int64(u)
+	} else {
+		return q, false
+	}
+	return q, true
+}
+
+// quickHolds reports whether q holds at the hit of b the thread pid,
+// stopped at d.Regs, just made. A variable that can't be read fails it,
+// as it fails the comparison of an expression.
+func (d *Debugger) quickHolds(pid int, b *Breakpoint, q quickCond) bool {
+	var word uint64
+	switch {
+	case q.v != nil:
+		val, err := d.variableValue(pid, &d.Regs, q.v)
+		if err != nil || int64(len(val.buf)) < q.size {
+			return false
+		}
+		word = bufUint(val.buf, q.size)
+	case q.addr != 0:
+		buf := make([]byte, q.size)
+		if err := d.ReadMemory(pid, q.addr, buf); err != nil {
+			return false
+		}
+		word = bufUint(buf, q.size)
+	case q.reg != "":
+		word, _ = registerValue(&d.Regs, q.reg)
+	case q.key == "hit":
+		word = uint64(b.Hits)
+	case q.key == "thread":
+		word = uint64(pid)
+	}
+
+	var order int
+	if q.signed {
+		// Sign-extend a variable narrower than 64 bits.
+		var shift uint
+		if q.size > 0 {
+			shift = 64 - 8*uint(q.size)
+		}
+		order = cmp.Compare(int64(word<<shift)>>shift, q.n)
+	} else {
+		order = cmp.Compare(word, uint64(q.n))
+	}
+	switch q.op {
+	case "==":
+		return order == 0
+	case "!=":
+		return order != 0
+	case ">=":
+		return order >= 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order < 0
+}
@@ -0,0 +1,85 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"strings"
+	"syscall"
+)
+
+// raceReportFunc is the hook the race detector calls once it has printed a
+// report, in the thread that made the racing access.
+const raceReportFunc = "__tsan_on_report"
+
+// maxRaceSteps bounds the instructions stepped from a race report back to
+// Go code.
+const maxRaceSteps = 1 << 20
+
+// planRaceTrap sets a trap where the race detector reports a race, in a
+// target built with -race.
+func (d *Debugger) planRaceTrap(pid int) {
+	d.raceTrap, d.raceTid = 0, 0
+	addr, ok := d.Symbols[raceReportFunc]
+	if !ok {
+		return
+	}
+	if err := d.addTrap(addr); err != nil {
+		d.printf("Can't watch for data races: %v\n", err)
+		return
+	}
+	d.raceTrap = addr
+}
+
+// raceStop handles a hit of the trap where the race detector reports a
+// race. The thread is in the detector's C code, on its system stack; it is
+// single-stepped back to the Go code that made the access, whose stack can
+// be read, before the target stops.
+func (d *Debugger) raceStop(pid int, addr uint64) (string, bool, error) {
+	if d.raceTrap == 0 || addr != d.raceTrap {
+		return "", false, nil
+	}
+	if err := d.rewindTrap(pid, addr); err != nil {
+		return "", true, err
+	}
+	d.raceTid, d.raceSteps = pid, 0
+	return "", true, d.resume(pid, false)
+}
+
+// raceStepStop handles a single step of the thread stepped back from a race
+// report. It stops at the first instruction of Go code outside the race
+// runtime: just past the access that raced.
+func (d *Debugger) raceStepStop(pid int) (string, bool, error) {
+	if d.raceTid == 0 || pid != d.raceTid {
+		return "", false, nil
+	}
+	d.raceSteps++
+	// The detector's functions are in the symbol table, without lines.
+	file, _, fn := d.pcToLine(d.Regs.Rip)
+	if fn == nil || file == "" || isRaceFunc(fn.Name) {
+		if d.raceSteps < maxRaceSteps {
+			return "", true, d.resume(pid, false)
+		}
+		d.println("The race report didn't return to Go code")
+	}
+	d.raceTid = 0
+	// The report numbers goroutines its own way, not by their ID.
+	d.printf("Data race: goroutine %d is just past the access reported above\n", d.currentGoid(pid))
+	// The other goroutine may still run what raced; it is held where it is.
+	d.stopThreads(pid)
+	return "race", true, nil
+}
+
+// raceStepSignal handles signals that stop the thread stepped back from a
+// race report between single steps. They are dropped, as for line steps.
+func (d *Debugger) raceStepSignal(pid int) (string, bool, error) {
+	if d.raceTid == 0 || pid != d.raceTid || d.Ws.StopSignal() == syscall.SIGTRAP {
+		return "", false, nil
+	}
+	return "", true, wrapPtrace("single-stepping", pid, d.proc().Step(pid))
+}
+
+// isRaceFunc reports whether the function name is of the runtime's calls
+// into the race detector.
+func isRaceFunc(name string) bool {
+	return strings.HasPrefix(name, "runtime.race") || strings.HasPrefix(name, "racecall")
+}
@@ -0,0 +1,141 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// KeyFrontend is the raw mode prompt: keys act as soon as they are
+// pressed, without Enter. Commands taking arguments are typed after ":".
+type KeyFrontend struct {
+	in  *os.File
+	out io.Writer
+}
+
+// NewKeyFrontend returns a key prompt for the terminal in, writing to out.
+func NewKeyFrontend(in *os.File, out io.Writer) *KeyFrontend {
+	return &KeyFrontend{in: in, out: out}
+}
+
+func (f *KeyFrontend) PromptCommand(arg string) (string, bool) {
+	if arg != "" {
+		fmt.Fprintf(f.out, "  Enter %s: > ", arg)
+		return f.readLine()
+	}
+
+	prompt := "\n[c]ontinue [s]tep [b]reakpoint [q]uit [:]command > "
+	fmt.Fprint(f.out, prompt)
+	for {
+		key, err := readKey(int(f.in.Fd()))
+		if err != nil {
+			return "", false
+		}
+		fmt.Fprintf(f.out, "%c\n", key)
+
+		switch key {
+		case 'c', 's', 'n', 'f', 'b', 'r', 'q':
+			return string(key), true
+		case 'i':
+			return "si", true
+		case ':':
+			fmt.Fprint(f.out, ": ")
+			return f.readLine()
+		}
+		fmt.Fprintf(f.out, "Unknown key %q\n", key)
+		fmt.Fprint(f.out, prompt)
+	}
+}
+
+func (f *KeyFrontend) ShowStop(stop Event) {
+	fmt.Fprintf(f.out, "Stopped at %s\n", stop.where())
+}
+
+func (f *KeyFrontend) ShowOutput(text string) {
+	io.WriteString(f.out, text)
+}
+
+func (f *KeyFrontend) Confirm(question string) bool {
+	fmt.Fprintf(f.out, "%s (y or n) ", question)
+	for {
+		key, err := readKey(int(f.in.Fd()))
+		if err != nil {
+			return true
+		}
+		switch key {
+		case 'y', 'Y':
+			fmt.Fprintln(f.out, "y")
+			return true
+		case 'n', 'N':
+			fmt.Fprintln(f.out, "n")
+			return false
+		}
+	}
+}
+
+// readLine reads one line from the terminal. It reads byte by byte so that
+// no input is buffered away from later key presses.
+func (f *KeyFrontend) readLine() (string, bool) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := f.in.Read(buf)
+		if n == 0 || err != nil {
+			return string(line), len(line) > 0
+		}
+		if buf[0] == '\n' {
+			return string(line), true
+		}
+		line = append(line, buf[0])
+	}
+}
+
+// IsTerminal reports whether fd refers to a terminal.
+func IsTerminal(fd int) bool {
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+func getTermios(fd int) (*syscall.Termios, error) {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return &t, nil
+}
+
+func setTermios(fd int, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCSETS, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// readKey reads a single key press from the terminal fd without waiting for
+// Enter. The terminal is only in raw mode while reading, so the target and
+// line input see it unchanged. Signal keys such as ^C keep working.
+func readKey(fd int) (byte, error) {
+	old, err := getTermios(fd)
+	if err != nil {
+		return 0, err
+	}
+	raw := *old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN], raw.Cc[syscall.VTIME] = 1, 0
+	if err := setTermios(fd, &raw); err != nil {
+		return 0, err
+	}
+	defer setTermios(fd, old)
+
+	buf := make([]byte, 1)
+	if _, err := os.Stdin.Read(buf); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
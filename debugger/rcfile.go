@@ -0,0 +1,75 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rcPath returns the rc file to run: -rc's file, or for a relative one
+// missing from the current directory, the one at the root of the target's
+// module, so a project's file is found from any of its directories.
+func (d *Debugger) rcPath() string {
+	if _, err := os.Stat(d.rcFile); !os.IsNotExist(err) || filepath.IsAbs(d.rcFile) {
+		return d.rcFile
+	}
+	if m := d.module(); m != nil {
+		return filepath.Join(m.dir, d.rcFile)
+	}
+	return d.rcFile
+}
+
+// RunRC runs the prompt commands in the project file path, one per line,
+// before the first prompt. Blank lines and lines starting with # are
+// skipped. This is where a project keeps its breakpoint groups, e.g.
+//
+//	# break on the login path
+//	group auth add handlers.go:42
+//	group auth add session.go:17
+//
+//	define dumpreq
+//	print $1.Method
+//	print $1.URL
+//	end
+//
+// Commands that resume the target can't be used here.
+func (d *Debugger) RunRC(pid int, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := strings.Fields(line); strings.ToLower(fields[0]) == "define" && len(fields) > 1 {
+			err := d.defineMacro(fields[1:], func() (string, bool) {
+				if !scanner.Scan() {
+					return "", false
+				}
+				n++
+				return scanner.Text(), true
+			})
+			if err != nil {
+				d.printf("%s:%d: %v\n", path, n, err)
+			}
+			continue
+		}
+		found, cont := d.wordCommand(pid, line)
+		switch {
+		case !found:
+			d.printf("%s:%d: unknown command %q\n", path, n, line)
+		case cont:
+			return fmt.Errorf("%s:%d: %q resumes the target", path, n, line)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,169 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// WatchBuild implements "watch <package>": it builds the package, debugs the
+// binary and, whenever a source file changes, rebuilds it and restarts the
+// debugger with the breakpoints, groups, watches and catchpoints of the
+// previous run. Breakpoints that don't resolve in the new binary are
+// reported when they are re-applied.
+func WatchBuild(args []string) int {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := flags.Duration("interval", 500*time.Millisecond, "how often to check the sources for changes")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("usage: watch [-interval d] <package>")
+		flags.PrintDefaults()
+		return 2
+	}
+	pkg := flags.Arg(0)
+
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	dir, err := os.MkdirTemp("", "dedebugger-watch")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	name := pkg
+	if build.IsLocalImport(pkg) {
+		// Name the binary after the directory, also for ".".
+		if name, err = filepath.Abs(pkg); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	bin := filepath.Join(dir, filepath.Base(name))
+	state := filepath.Join(dir, "session.json")
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	var child *exec.Cmd
+	exited := make(chan struct{})
+	stop := func() {
+		if child == nil {
+			return
+		}
+		// The debugger saves its session and kills the target on SIGTERM.
+		child.Process.Signal(syscall.SIGTERM)
+		<-exited
+		child = nil
+	}
+
+	var sources map[string]time.Time
+	for {
+		if sources, err = goSources(pkg); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if err := goBuild(pkg, bin+".new"); err != nil {
+			fmt.Printf("Build failed, waiting for changes\n%v", err)
+		} else {
+			stop()
+			if err := os.Rename(bin+".new", bin); err != nil {
+				fmt.Println(err)
+				return 1
+			}
+			cmdArgs := []string{"-save-on-exit", state}
+			if _, err := os.Stat(state); err == nil {
+				fmt.Printf("Restarting %s\n", pkg)
+				cmdArgs = append(cmdArgs, "-session", state)
+			}
+			child = exec.Command(self, append(cmdArgs, bin)...)
+			child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+			if err := child.Start(); err != nil {
+				fmt.Println(err)
+				return 1
+			}
+			exited = make(chan struct{})
+			go func(c *exec.Cmd, done chan struct{}) {
+				c.Wait()
+				close(done)
+			}(child, exited)
+		}
+
+		// Wait for a change. A debugger that ended by itself is left
+		// ended until then.
+		for changed := false; !changed; {
+			select {
+			case <-sigs:
+				stop()
+				return 0
+			case <-exited:
+				child, exited = nil, nil
+				fmt.Printf("Waiting for changes to %s (Ctrl-C to stop)\n", pkg)
+			case <-time.After(*interval):
+				changed = sourcesChanged(sources)
+			}
+		}
+	}
+}
+
+// goBuild builds pkg to out without optimizations or inlining, so every
+// line keeps its code.
+func goBuild(pkg, out string) error {
+	b, err := exec.Command("go", "build", "-gcflags=all=-N -l", "-o", out, pkg).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s", b)
+	}
+	return nil
+}
+
+// goSources returns the modification times of the Go files pkg is built
+// from, outside the standard library, and of their directories, which
+// change when files are added.
+func goSources(pkg string) (map[string]time.Time, error) {
+	const format = `{{if not .Standard}}{{.Dir}}
+{{range .GoFiles}}{{$.Dir}}/{{.}}
+{{end}}{{end}}`
+	b, err := exec.Command("go", "list", "-deps", "-f", format, pkg).Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing sources of %s: %v", pkg, err)
+	}
+	sources := make(map[string]time.Time)
+	for _, path := range strings.Fields(string(b)) {
+		if fi, err := os.Stat(path); err == nil {
+			sources[path] = fi.ModTime()
+		}
+	}
+	return sources, nil
+}
+
+// sourcesChanged reports whether any of the sources was modified or removed
+// since they were listed.
+func sourcesChanged(sources map[string]time.Time) bool {
+	for path, mtime := range sources {
+		fi, err := os.Stat(path)
+		if err != nil || !fi.ModTime().Equal(mtime) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveAndKill saves the session to the -save-on-exit file and kills the
+// target pid, so a restarted debugger finds the setup and no old target.
+// Storing the file is left to the caller, once the target is gone.
+func (d *Debugger) saveAndKill(pid int) error {
+	err := d.SaveSession(d.saveOnExit)
+	syscall.Kill(pid, syscall.SIGKILL)
+	return err
+}
@@ -0,0 +1,226 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// OpenRecord records the run to path: the event stream, with the values of
+// the snapshot expressions attached to every stop and hit. Two recordings
+// can be compared with "compare".
+func (d *Debugger) OpenRecord(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if d.events != nil {
+		d.events = io.MultiWriter(d.events, f)
+	} else {
+		d.events = f
+	}
+	d.recordFile = f
+	return nil
+}
+
+// snapshot attaches the values of the snapshot expressions to ev, if it is
+// a stop or hit. The thread of ev is stopped, so it can be read.
+func (d *Debugger) snapshot(ev *Event) {
+	if len(d.snapshotExprs) == 0 || ev.Type != "stop" && ev.Type != "hit" {
+		return
+	}
+	if ev.Values == nil {
+		ev.Values = make(map[string]string, len(d.snapshotExprs))
+	}
+	for _, expr := range d.snapshotExprs {
+		v, err := d.Eval(ev.Pid, expr)
+		if err != nil {
+			ev.Values[expr] = "<" + err.Error() + ">"
+			continue
+		}
+		ev.Values[expr] = fmt.Sprint(v)
+	}
+}
+
+// recordedHit is one entry of the hit sequence of a recorded run.
+type recordedHit struct {
+	key    string // what is aligned: the function and kind of stop, or the exit
+	loc    string // file:line, which may differ between builds
+	values map[string]string
+}
+
+func (h recordedHit) String() string {
+	if h.loc == "" {
+		return h.key
+	}
+	return h.key + " " + h.loc
+}
+
+// loadRecord reads the hit sequence of a recording: breakpoint and catch
+// hits, other stops and the exit. The stop following a hit at the same
+// place is the same event and is dropped.
+func loadRecord(path string) ([]recordedHit, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hits []recordedHit
+	var last Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+
+		switch ev.Type {
+		case "hit", "stop":
+			if ev.Type == "stop" && last.Type == "hit" && last.PC == ev.PC {
+				break
+			}
+			key := ev.Func
+			if ev.Reason != "" && ev.Reason != "breakpoint" {
+				key += " (" + ev.Reason + ")"
+			}
+			loc := fmt.Sprintf("%s:%d", filepath.Base(ev.File), ev.Line)
+			hits = append(hits, recordedHit{key, loc, ev.Values})
+		case "exit":
+			key := "exit"
+			if ev.Status != nil {
+				key = fmt.Sprintf("exit status %d", *ev.Status)
+			}
+			if ev.Detail != "" {
+				key += " (" + ev.Detail + ")"
+			}
+			hits = append(hits, recordedHit{key: key})
+		default:
+			continue
+		}
+		last = ev
+	}
+	return hits, scanner.Err()
+}
+
+// Compare implements "compare <run1> <run2>". It aligns the hit sequences of
+// two recordings, prints them side by side and reports where they diverge.
+// The exit code is 0 when the runs match and 1 when they don't.
+func Compare(args []string) int {
+	if len(args) != 2 {
+		fmt.Println("usage: compare <run1.ddbrec> <run2.ddbrec>")
+		return 2
+	}
+	a, err := loadRecord(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	b, err := loadRecord(args[1])
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+
+	keys := func(hits []recordedHit) []string {
+		k := make([]string, len(hits))
+		for i, h := range hits {
+			k[i] = h.key
+		}
+		return k
+	}
+
+	// Walk the alignment of the functions, comparing the snapshots of the
+	// hits both runs share. Lines are not aligned on since they move between
+	// builds.
+	var first string
+	i, j := 0, 0
+	for _, line := range diffLines(keys(a), keys(b)) {
+		switch line[0] {
+		case ' ':
+			where := a[i].String()
+			if b[j].loc != a[i].loc {
+				where += " / " + b[j].loc
+			}
+			diff := diffValues(a[i].values, b[j].values)
+			if diff == "" {
+				fmt.Printf("  %s%s\n", where, formatValues(a[i].values))
+			} else {
+				fmt.Printf("! %s  %s\n", where, diff)
+				if first == "" {
+					first = fmt.Sprintf("hit %d of run 1 and %d of run 2 at %s: %s", i+1, j+1, where, diff)
+				}
+			}
+			i, j = i+1, j+1
+		case '-':
+			fmt.Printf("- %s%s\n", a[i], formatValues(a[i].values))
+			if first == "" {
+				first = fmt.Sprintf("hit %d of run 1 at %s: missing from run 2", i+1, a[i])
+			}
+			i++
+		case '+':
+			fmt.Printf("+ %s%s\n", b[j], formatValues(b[j].values))
+			if first == "" {
+				first = fmt.Sprintf("hit %d of run 2 at %s: missing from run 1", j+1, b[j])
+			}
+			j++
+		}
+	}
+
+	if first == "" {
+		fmt.Printf("\nRuns match (%d hits)\n", len(a))
+		return 0
+	}
+	fmt.Printf("\nRuns diverge first at %s\n", first)
+	return 1
+}
+
+// diffValues describes the snapshot values that differ between a and b, or
+// returns "" if they are the same.
+func diffValues(a, b map[string]string) string {
+	exprs := make(map[string]bool)
+	for e := range a {
+		exprs[e] = true
+	}
+	for e := range b {
+		exprs[e] = true
+	}
+
+	var diffs []string
+	for e := range exprs {
+		va, oka := a[e]
+		vb, okb := b[e]
+		if va != vb || oka != okb {
+			diffs = append(diffs, fmt.Sprintf("%s: %s -> %s", e, orMissing(va, oka), orMissing(vb, okb)))
+		}
+	}
+	sort.Strings(diffs)
+	return strings.Join(diffs, ", ")
+}
+
+func orMissing(v string, ok bool) string {
+	if !ok {
+		return "<missing>"
+	}
+	return v
+}
+
+func formatValues(values map[string]string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	var parts []string
+	for e, v := range values {
+		parts = append(parts, e+"="+v)
+	}
+	sort.Strings(parts)
+	return "  " + strings.Join(parts, " ")
+}
@@ -0,0 +1,264 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// registerNames are the registers regs and "set reg" know, in the order
+// regs prints them.
+var registerNames = []string{
+	"rax", "rbx", "rcx", "rdx", "rsi", "rdi", "rbp", "rsp",
+	"r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15",
+	"rip", "eflags", "cs", "ss", "ds", "es", "fs", "gs", "fs_base", "gs_base", "orig_rax",
+}
+
+// register returns the field of regs that holds the register name, or nil.
+func register(regs *syscall.PtraceRegs, name string) *uint64 {
+	switch strings.ToLower(name) {
+	case "rax":
+		return &regs.Rax
+	case "rbx":
+		return &regs.Rbx
+	case "rcx":
+		return &regs.Rcx
+	case "rdx":
+		return &regs.Rdx
+	case "rsi":
+		return &regs.Rsi
+	case "rdi":
+		return &regs.Rdi
+	case "rbp", "fp":
+		return &regs.Rbp
+	case "rsp", "sp":
+		return &regs.Rsp
+	case "rip", "pc":
+		return &regs.Rip
+	case "r8":
+		return &regs.R8
+	case "r9":
+		return &regs.R9
+	case "r10":
+		return &regs.R10
+	case "r11":
+		return &regs.R11
+	case "r12":
+		return &regs.R12
+	case "r13":
+		return &regs.R13
+	case "r14":
+		return &regs.R14
+	case "r15":
+		return &regs.R15
+	case "eflags":
+		return &regs.Eflags
+	case "cs":
+		return &regs.Cs
+	case "ss":
+		return &regs.Ss
+	case "ds":
+		return &regs.Ds
+	case "es":
+		return &regs.Es
+	case "fs":
+		return &regs.Fs
+	case "gs":
+		return &regs.Gs
+	case "fs_base":
+		return &regs.Fs_base
+	case "gs_base":
+		return &regs.Gs_base
+	case "orig_rax":
+		return &regs.Orig_rax
+	}
+	return nil
+}
+
+// registerValue returns the value of the register name, which may be
+// written as in GDB, as "$rsp".
+func registerValue(regs *syscall.PtraceRegs, name string) (uint64, bool) {
+	if r := register(regs, strings.TrimPrefix(name, "$")); r != nil {
+		return *r, true
+	}
+	return 0, false
+}
+
+// eflagsBits names the status and control flags of EFLAGS by bit.
+var eflagsBits = []struct {
+	bit  uint
+	name string
+}{
+	{0, "CF"}, {2, "PF"}, {4, "AF"}, {6, "ZF"}, {7, "SF"}, {8, "TF"}, {9, "IF"}, {10, "DF"}, {11, "OF"},
+}
+
+// DumpRegs prints the general purpose registers of the stopped thread pid,
+// in hex and decimal. The instruction pointer is shown with its function and
+// line, and the flags by name.
+func (d *Debugger) DumpRegs(pid int) error {
+	var regs syscall.PtraceRegs
+	if err := d.proc().GetRegs(pid, &regs); err != nil {
+		return wrapPtrace("reading registers of", pid, err)
+	}
+	var b strings.Builder
+	d.writeRegs(&b, &regs)
+	d.UI.ShowOutput(b.String())
+	return nil
+}
+
+// writeRegs writes regs as DumpRegs prints them.
+func (d *Debugger) writeRegs(b *strings.Builder, regs *syscall.PtraceRegs) {
+	for _, name := range registerNames {
+		v := *register(regs, name)
+		fmt.Fprintf(b, "%-9s %#-18x %d", name, v, int64(v))
+		switch name {
+		case "rip":
+			if file, line, fn := d.pcToLine(v); fn != nil {
+				fmt.Fprintf(b, "  %s at %d in %s", fn.Name, line, file)
+			}
+		case "eflags":
+			var set []string
+			for _, f := range eflagsBits {
+				if v&(1<<f.bit) != 0 {
+					set = append(set, f.name)
+				}
+			}
+			fmt.Fprintf(b, "  [%s]", strings.Join(set, " "))
+		}
+		b.WriteByte('\n')
+	}
+}
+
+// SetRegister sets the register name of the stopped thread pid, whose
+// registers are in d.Regs, to v.
+func (d *Debugger) SetRegister(pid int, name string, v uint64) error {
+	r := register(&d.Regs, name)
+	if r == nil {
+		return fmt.Errorf("unknown register %s", name)
+	}
+	if d.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := d.checkSafePoint(); err != nil {
+		return err
+	}
+	old := *r
+	*r = v
+	if err := d.proc().SetRegs(pid, &d.Regs); err != nil {
+		*r = old
+		return wrapPtrace("setting registers of", pid, err)
+	}
+	if d.rearm && d.Regs.Rip != d.rearmAt {
+		// The thread no longer sits on the rewound trap, so it isn't
+		// stepped over; re-arm it now.
+		d.rearmTrap(pid)
+	}
+	return nil
+}
+
+// regsCommand handles "regs", which prints the registers of the stopped
+// thread.
+func (d *Debugger) regsCommand(pid int, args []string) bool {
+	if len(args) != 0 {
+		d.println("usage: regs")
+		return false
+	}
+	if err := d.DumpRegs(pid); err != nil {
+		d.println(err)
+	}
+	return false
+}
+
+// setCommand handles "set reg <name> <value>", where values are decimal,
+// or hex with 0x, and may be negative, the settings of the source display,
+// "set context <lines>", "set blame on|off", "set inline-values on|off"
+// and "set source-root <dir>", "set history <values>", how many values
+// history keeps, "set log-output <dest>", where tracepoints log, "set
+// editor <editor>", what edit opens, "set safe-points on|off" and "set
+// wait-timeout <duration>|off".
+func (d *Debugger) setCommand(pid int, args []string) bool {
+	const usage = "usage: set reg <name> <value> | set context <lines> | set blame on|off | set inline-values on|off | set history <values> | set timeline <stops> | set log-output <dest> | set source-root <dir> | set editor <editor> | set safe-points on|off | set wait-timeout <duration>|off"
+	switch {
+	case len(args) == 2 && args[0] == "context":
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			d.printf("bad line count %q\n", args[1])
+			return false
+		}
+		d.ContextLines = n
+		return false
+	case len(args) == 2 && args[0] == "history":
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			d.printf("bad history size %q\n", args[1])
+			return false
+		}
+		d.HistorySize = n
+		return false
+	case len(args) == 2 && args[0] == "timeline":
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			d.printf("bad timeline size %q\n", args[1])
+			return false
+		}
+		d.TimelineSize = n
+		return false
+	case len(args) == 2 && args[0] == "log-output":
+		if err := d.OpenLogOutput(args[1]); err != nil {
+			d.println(err)
+		}
+		return false
+	case len(args) == 2 && args[0] == "source-root":
+		// Files not found before may be found now.
+		d.SourceRoot, d.sources, d.blames = args[1], nil, nil
+		return false
+	case len(args) >= 2 && args[0] == "editor":
+		d.Editor = strings.Join(args[1:], " ")
+		return false
+	case len(args) == 2 && args[0] == "safe-points":
+		d.setSafePoints(args[1])
+		return false
+	case len(args) == 2 && args[0] == "wait-timeout":
+		d.setWaitTimeout(args[1])
+		return false
+	case len(args) == 2 && args[0] == "inline-values":
+		switch args[1] {
+		case "on", "off":
+			d.InlineValues = args[1] == "on"
+		default:
+			d.println("usage: set inline-values on|off")
+		}
+		return false
+	case len(args) == 2 && args[0] == "blame":
+		switch args[1] {
+		case "on", "off":
+			d.Blame = args[1] == "on"
+		default:
+			d.println("usage: set blame on|off")
+		}
+		return false
+	case len(args) != 3 || args[0] != "reg":
+		d.println(usage)
+		return false
+	}
+	v, err := strconv.ParseUint(args[2], 0, 64)
+	if err != nil {
+		n, err2 := strconv.ParseInt(args[2], 0, 64)
+		if err2 != nil {
+			d.printf("bad value %q\n", args[2])
+			return false
+		}
+		v = uint64(n)
+	}
+	err = d.SetRegister(pid, args[1], v)
+	d.audit("", "write-register", pid, fmt.Sprintf("%s = %#x", strings.ToLower(args[1]), v), err)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	d.printf("%s = %#x\n", strings.ToLower(args[1]), v)
+	return false
+}
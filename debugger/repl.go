@@ -0,0 +1,349 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"sort"
+	"strings"
+)
+
+// resumeCommands are the prompt commands that resume the target, which
+// only the prompt at a stop runs. They report whether they resume it, and
+// whether by continuing rather than by a single step.
+var resumeCommands = map[string]func(d *Debugger, pid int, args []string) (resume, cont bool){
+	"continue": func(d *Debugger, pid int, args []string) (bool, bool) {
+		return true, true
+	},
+	"step": func(d *Debugger, pid int, args []string) (bool, bool) {
+		if err := d.StepLine(pid); err != nil {
+			d.println(err)
+			return false, false
+		}
+		return true, false
+	},
+	"stepi": func(d *Debugger, pid int, args []string) (bool, bool) {
+		return true, false
+	},
+	"next": func(d *Debugger, pid int, args []string) (bool, bool) {
+		if err := d.StepOver(pid); err != nil {
+			d.println(err)
+			return false, false
+		}
+		return true, true
+	},
+	"finish": func(d *Debugger, pid int, args []string) (bool, bool) {
+		if err := d.StepOut(pid); err != nil {
+			d.println(err)
+			return false, false
+		}
+		return true, true
+	},
+	"until": func(d *Debugger, pid int, args []string) (bool, bool) {
+		ok := d.untilCommand(pid, args)
+		return ok, ok
+	},
+	"quit": func(d *Debugger, pid int, args []string) (bool, bool) {
+		if d.UI.Confirm("The target is still running. Quit anyway?") {
+			d.quit(pid)
+		}
+		return false, false
+	},
+}
+
+// noRepeat are the commands an empty line doesn't repeat, since running them
+// again would change breakpoints, displays or settings twice, act on what
+// the first run renumbered, or end the session.
+var noRepeat = map[string]bool{
+	"alias": true, "break": true, "callgraph": true, "catch": true, "define": true,
+	"delete": true, "detach": true, "disable": true, "display": true, "edit": true,
+	"enable": true, "group": true, "hangs": true, "ignore": true, "import": true,
+	"iteration": true, "log": true, "mode": true, "notify": true, "on": true,
+	"poke": true, "printer": true, "quit": true, "restart": true, "sample": true,
+	"session": true, "set": true, "shell": true, "signal": true, "snap": true,
+	"tbreak": true, "trace": true, "undisplay": true, "unwatch": true, "watch": true,
+	"watch-global": true,
+}
+
+// repeatCommand returns the command to run for input typed at a stop. On a
+// terminal, an empty line repeats the last command, as in gdb, so stepping
+// through code takes one key per line. Commands in noRepeat aren't repeated.
+func (d *Debugger) repeatCommand(input string) string {
+	if fields := strings.Fields(d.expandAlias(input)); len(fields) > 0 {
+		if noRepeat[strings.ToLower(fields[0])] {
+			d.lastCommand = ""
+		} else {
+			d.lastCommand = input
+		}
+		return input
+	}
+	if ui, ok := d.UI.(*LineFrontend); ok && ui.editor != nil {
+		return d.lastCommand
+	}
+	return input
+}
+
+// commandAliases are the short names of commands. The single letters are
+// those of the prompt.
+var commandAliases = map[string]string{
+	"b":  "break",
+	"bt": "backtrace",
+	"c":  "continue",
+	"f":  "finish",
+	"h":  "help",
+	"n":  "next",
+	"p":  "print",
+	"q":  "quit",
+	"r":  "restart",
+	"s":  "step",
+	"si": "stepi",
+}
+
+// commandHelp describes the commands for "help", by their usage.
+var commandHelp = map[string]string{
+	"alias":        "alias [<name> <command>] - name a command, with arguments; alone, list the aliases",
+	"backtrace":    "backtrace [-folded] [n] - the call stack of the stopped goroutine",
+	"break":        "break [file:]line|function [count|-hitcount <cond>] [if <cond>] - set a breakpoint",
+	"breakpoints":  "breakpoints - list the breakpoints",
+	"callgraph":    "callgraph [on|off|<file>] - record the calls between traced functions, list them or write them as DOT",
+	"catch":        "catch alloc <type> [-log] | catch panic [-log] | catch mapaccess <expr> - stop at allocations of a type, panics, or concurrent writes of a map",
+	"continue":     "continue - resume the target",
+	"ctx":          "ctx <variable> - the chain of a context.Context",
+	"define":       "define <name> - define a macro, up to a line end",
+	"delete":       "delete <breakpoint>... - remove breakpoints",
+	"detach":       "detach - let the target run on without the debugger",
+	"diff":         "diff <expr> [<expr>] - compare two values, or one since its last diff",
+	"disable":      "disable <breakpoint>... - disable breakpoints",
+	"disasm":       "disasm [address|function] [count] - disassemble code",
+	"display":      "display [<expr>] - print a value at every stop, recording its history",
+	"edit":         "edit [[file:]line|function] - open the source of a location, or of the stop, in the editor",
+	"enable":       "enable <breakpoint>... - enable breakpoints",
+	"finish":       "finish - run until the current function returns",
+	"files":        "files [regexp] - list the source files of the target",
+	"funcs":        "funcs [regexp] - list the functions of the target, with their entry addresses",
+	"goroutine":    "goroutine <id> - the stack of a goroutine",
+	"goroutines":   "goroutines [-folded] - list the goroutines",
+	"group":        "group <name> add <location> | enable | disable | delete - breakpoint groups",
+	"hangs":        "hangs [duration|off] - goroutines that seem stuck",
+	"help":         "help [<command>] - list the commands, or describe one",
+	"history":      "history [<expr>] - the values of a displayed or watched expression at the last stops",
+	"hits":         "hits [<breakpoint>] ... - browse, query and export collected samples",
+	"ignore":       "ignore <breakpoint> <n> - pass over the next hits of a breakpoint",
+	"import":       "import <file> - set the breakpoints of a VS Code breakpoint export or a Delve init file",
+	"info":         "info line <function>|[file:]line - where a function or line is; info addr <address> - what an address is: mapping, function, global, heap object or stack frame",
+	"iteration":    "iteration <benchmark> <n> | iteration off - stop at the start of an iteration of a benchmark loop",
+	"list":         "list [[file:]line|function] - show source",
+	"locals":       "locals - the arguments and local variables",
+	"log":          "log [file:]line|function \"<message>\" [if <cond>] - log the hits of a location where cond holds",
+	"maps":         "maps - the memory mappings of the target",
+	"mode":         "mode <breakpoint> stop|count|collect [<expr>...] | trace [<message>] - what a breakpoint does",
+	"mutex":        "mutex <variable> - the state and waiters of a mutex",
+	"next":         "next - run to the next line, over calls",
+	"notify":       "notify [<url>... | off] - post the stops of breakpoints, catchpoints and watches to webhooks",
+	"on":           "on stop at <location>: <commands> - run commands at a breakpoint",
+	"poke":         "poke <address> <hex bytes>... - write memory",
+	"print":        "print [-json] [-o <file>] [-clip] <expr> - the value of an expression: a variable, field, index, *p, &x, len or cap, (*T)(addr), or a register",
+	"printer":      "printer <type> <template> | printer -d <type> - print a type through a template",
+	"quit":         "quit - remove the breakpoints, detach leaving the target running, and quit (with save-on-exit, save the session and kill it)",
+	"regs":         "regs - the registers",
+	"restart":      "restart - run the target again from the start",
+	"sample":       "sample <breakpoint> <n> - take only 1 hit in n of a busy breakpoint",
+	"scrollback":   "scrollback [-commands] [-n <lines>] [<regexp>] - the last lines of output, or of the commands typed, matching regexp",
+	"session":      "session save|load <file> - save or load the breakpoints and settings",
+	"set":          "set reg <name> <value> | set context <lines> | set blame on|off | set inline-values on|off | set history <values> | set timeline <stops> | set log-output <dest> | set source-root <dir> | set editor <editor> | set safe-points on|off | set wait-timeout <duration>|off",
+	"shell":        "shell [--target-env] [<command>] - run a command or a shell, with --target-env in the target's environment, directory and namespaces",
+	"signal":       "signal [<signal> stop|pass|ignore] - what to do with a signal",
+	"snap":         "snap [<name> [<expr>...] | diff <name> [<name>] | show <name> | delete <name>] - keep the values of the displays and watches to compare later",
+	"stackdump":    "stackdump - the stacks of all goroutines",
+	"step":         "step - run to the next line, into calls",
+	"stepi":        "stepi - run one instruction",
+	"tbreak":       "tbreak [file:]line|function - set a breakpoint deleted at its first stop",
+	"threads":      "threads - list the traced threads",
+	"trace":        "trace [file:]line|function [--args] [--ret] [<message>] - log the hits of a location, with the arguments and returns of a function, and go on",
+	"undisplay":    "undisplay <n>... - remove displays",
+	"until":        "until [file:]line|function - continue to a location",
+	"unwatch":      "unwatch <watchpoint> - remove a watchpoint",
+	"watch":        "watch [-rw] <expr> | watch <metric> <op> <value> - stop when memory or a metric changes",
+	"watch-global": "watch-global <pkg.Var> - stop when a global changes and show the goroutine that changed it",
+	"x":            "x[/<format>] <address> - examine memory",
+}
+
+// expandAlias replaces an alias at the start of input by its command. Only
+// the first word of input is looked at; the rest follows the expansion.
+func (d *Debugger) expandAlias(input string) string {
+	input = strings.TrimSpace(input)
+	name, rest, _ := strings.Cut(input, " ")
+	lower := strings.ToLower(name)
+	if _, ok := wordCommands[lower]; ok {
+		return input
+	}
+	if _, ok := resumeCommands[lower]; ok {
+		return input
+	}
+	cmd, ok := d.aliases[lower]
+	if !ok {
+		if cmd, ok = commandAliases[lower]; !ok {
+			return input
+		}
+	}
+	return strings.TrimSpace(cmd + " " + rest)
+}
+
+// aliasCommand handles "alias <name> <command>", which makes name run the
+// command, followed by the arguments name is given, and "alias", which
+// lists the aliases.
+func (d *Debugger) aliasCommand(pid int, args []string) bool {
+	if len(args) == 0 {
+		names := make([]string, 0, len(commandAliases)+len(d.aliases))
+		for name := range commandAliases {
+			names = append(names, name)
+		}
+		for name := range d.aliases {
+			if _, ok := commandAliases[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			cmd, ok := d.aliases[name]
+			if !ok {
+				cmd = commandAliases[name]
+			}
+			d.printf("  %-4s %s\n", name, cmd)
+		}
+		return false
+	}
+	if len(args) < 2 {
+		d.println("usage: alias [<name> <command>]")
+		return false
+	}
+	name := strings.ToLower(args[0])
+	if _, ok := wordCommands[name]; ok {
+		d.printf("%s is a command\n", name)
+		return false
+	}
+	if _, ok := resumeCommands[name]; ok {
+		d.printf("%s is a command\n", name)
+		return false
+	}
+	if d.aliases == nil {
+		d.aliases = make(map[string]string)
+	}
+	d.aliases[name] = strings.Join(args[1:], " ")
+	return false
+}
+
+// helpCommand handles "help", which lists the commands, and "help
+// <command>", which describes one.
+func (d *Debugger) helpCommand(pid int, args []string) bool {
+	if len(args) > 0 {
+		name := strings.Fields(d.expandAlias(args[0]))[0]
+		if m := d.macros[name]; m != nil {
+			d.printf("%s is a macro: %s\n", name, strings.Join(m.Body, "; "))
+			return false
+		}
+		help, ok := commandHelp[name]
+		if !ok {
+			d.printf("No command %s\n", args[0])
+			return false
+		}
+		d.println(help)
+		var short []string
+		for alias, cmd := range commandAliases {
+			if cmd == name {
+				short = append(short, alias)
+			}
+		}
+		if len(short) > 0 {
+			sort.Strings(short)
+			d.printf("Aliases: %s\n", strings.Join(short, ", "))
+		}
+		return false
+	}
+	names := make([]string, 0, len(commandHelp))
+	for name := range commandHelp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d.printf("  %s\n", commandHelp[name])
+	}
+	if len(d.macros) > 0 {
+		var macros []string
+		for name := range d.macros {
+			macros = append(macros, name)
+		}
+		sort.Strings(macros)
+		d.printf("Macros: %s\n", strings.Join(macros, ", "))
+	}
+	return false
+}
+
+// locationCommands are the commands whose first argument is a location,
+// which completes to function names.
+var locationCommands = map[string]bool{
+	"break":  true,
+	"disasm": true,
+	"edit":   true,
+	"list":   true,
+	"log":    true,
+	"tbreak": true,
+	"trace":  true,
+	"until":  true,
+}
+
+// maxCompletions bounds the function names completion offers.
+const maxCompletions = 1000
+
+// Complete returns the completions of the last word of line: command
+// names, aliases and macros for the first word, and function names for
+// the location of the commands that take one, and of "info line". Functions of package main
+// complete without "main." too.
+func (d *Debugger) Complete(line string) []string {
+	fields := strings.Fields(line)
+	word := ""
+	if len(fields) > 0 && !strings.HasSuffix(line, " ") {
+		word, fields = fields[len(fields)-1], fields[:len(fields)-1]
+	}
+	var names []string
+	switch {
+	case len(fields) == 0:
+		for name := range wordCommands {
+			names = append(names, name)
+		}
+		for name := range resumeCommands {
+			names = append(names, name)
+		}
+		for name := range d.aliases {
+			names = append(names, name)
+		}
+		for name := range d.macros {
+			names = append(names, name)
+		}
+	case len(fields) == 1 && locationCommands[strings.Fields(d.expandAlias(fields[0]))[0]],
+		len(fields) == 2 && fields[0] == "info" && fields[1] == "line":
+		if d.SymTable == nil {
+			return nil
+		}
+		for _, fn := range d.SymTable.Funcs {
+			names = append(names, fn.Name)
+			if short, ok := strings.CutPrefix(fn.Name, "main."); ok && !strings.HasPrefix(word, "main.") {
+				names = append(names, short)
+			}
+		}
+	default:
+		return nil
+	}
+	var matches []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if strings.HasPrefix(name, word) && !seen[name] {
+			seen[name] = true
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	if len(matches) > maxCompletions {
+		matches = matches[:maxCompletions]
+	}
+	return matches
+}
@@ -0,0 +1,212 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RESTServer exposes a Session as a small JSON API for curl and chat bots:
+//
+//	POST /breakpoints  {"file": "main.go", "line": 14}
+//	POST /continue     [?timeout=5s]
+//	GET  /stacktrace   [?depth=N]
+//
+// When Token or Tokens are set, requests must carry "Authorization: Bearer
+// <token>". Token allows everything; the role of each of Tokens limits what
+// its holder may call: stacktrace with RoleRead, and breakpoints and
+// continue too with RoleBreak.
+type RESTServer struct {
+	Session *Session
+	Token   string
+	Tokens  Tokens
+}
+
+// restFrame is the JSON form of a Frame. PCs are hex strings, as in events.
+type restFrame struct {
+	PC        string `json:"pc"`
+	Func      string `json:"func,omitempty"`
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	SP        string `json:"sp,omitempty"`
+	FP        string `json:"fp,omitempty"`
+	Goroutine uint64 `json:"goroutine,omitempty"`
+}
+
+// restError is an API error with the HTTP status it is reported with.
+type restError struct {
+	status int
+	msg    string
+}
+
+func (e *restError) Error() string { return e.msg }
+
+// ListenAndServe serves the session on addr until it fails.
+func (r *RESTServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return r.Serve(l)
+}
+
+// Serve accepts connections on l until it is closed.
+func (r *RESTServer) Serve(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /breakpoints", r.handle(RoleBreak, r.setBreakpoint))
+	mux.HandleFunc("GET /breakpoints", r.handle(RoleRead, r.listBreakpoints))
+	mux.HandleFunc("DELETE /breakpoints/{bp}", r.handle(RoleBreak, r.deleteBreakpoint))
+	mux.HandleFunc("POST /continue", r.handle(RoleBreak, r.cont))
+	mux.HandleFunc("GET /stacktrace", r.handle(RoleRead, r.stacktrace))
+	return (&http.Server{Handler: mux, ConnContext: connContext("rest")}).Serve(l)
+}
+
+// handle adapts an API function, which callers need role for, to an
+// http.HandlerFunc, taking care of authentication and of encoding the
+// result or error as JSON.
+func (r *RESTServer) handle(role Role, fn func(*http.Request) (int, any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var status int
+		var v any
+		var err error
+		switch got, ok := serverTokens(r.Token, r.Tokens).authorize(req.Header.Get("Authorization")); {
+		case !ok:
+			err = &restError{http.StatusUnauthorized, "missing or bad token"}
+		case got < role:
+			err = &restError{http.StatusForbidden, fmt.Sprintf("the token's role, %s, doesn't allow this; it needs %s", got, role)}
+		default:
+			status, v, err = fn(req)
+		}
+
+		if err != nil {
+			status = http.StatusConflict
+			var rerr *restError
+			if errors.As(err, &rerr) {
+				status = rerr.status
+			}
+			v = map[string]string{"error": err.Error()}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func (r *RESTServer) setBreakpoint(req *http.Request) (int, any, error) {
+	var body struct {
+		File string `json:"file"`
+		Line int    `json:"line"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return 0, nil, &restError{http.StatusBadRequest, "bad request body: " + err.Error()}
+	}
+	if body.File == "" || body.Line <= 0 {
+		return 0, nil, &restError{http.StatusBadRequest, "file and line are required"}
+	}
+	b, err := r.Session.breakFor(requestClient(req.Context()), body.File, body.Line)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return http.StatusCreated, newRESTBreakpoint(*b), nil
+}
+
+// restBreakpoint is the JSON form of a breakpoint.
+type restBreakpoint struct {
+	ID   int    `json:"id"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+	PC   string `json:"pc"`
+}
+
+func newRESTBreakpoint(b Breakpoint) restBreakpoint {
+	return restBreakpoint{ID: b.ID, File: b.File, Line: b.Line, PC: fmt.Sprintf("%#x", b.Addr)}
+}
+
+func (r *RESTServer) listBreakpoints(req *http.Request) (int, any, error) {
+	out := []restBreakpoint{}
+	for _, b := range r.Session.Breakpoints() {
+		out = append(out, newRESTBreakpoint(b))
+	}
+	return http.StatusOK, map[string]any{"breakpoints": out}, nil
+}
+
+// deleteBreakpoint removes the breakpoint of the path and answers with it.
+func (r *RESTServer) deleteBreakpoint(req *http.Request) (int, any, error) {
+	id, err := strconv.Atoi(req.PathValue("bp"))
+	if err == nil {
+		for _, b := range r.Session.Breakpoints() {
+			if b.ID != id {
+				continue
+			}
+			if err := r.Session.clearBreakFor(requestClient(req.Context()), id); err != nil {
+				return 0, nil, err
+			}
+			return http.StatusOK, newRESTBreakpoint(b), nil
+		}
+	}
+	return 0, nil, &restError{http.StatusNotFound, "no breakpoint " + strconv.Quote(req.PathValue("bp"))}
+}
+
+// cont resumes the target and answers with the event it stopped or exited
+// with. The target is interrupted if the timeout expires or the client
+// disconnects first.
+func (r *RESTServer) cont(req *http.Request) (int, any, error) {
+	ctx := req.Context()
+	if t := req.URL.Query().Get("timeout"); t != "" {
+		timeout, err := time.ParseDuration(t)
+		if err != nil {
+			return 0, nil, &restError{http.StatusBadRequest, "bad timeout: " + err.Error()}
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := r.Session.runContext(ctx, r.Session.Continue)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return 0, nil, &restError{http.StatusGatewayTimeout, "timeout, target interrupted"}
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, r.Session.LastEvent(), nil
+}
+
+func (r *RESTServer) stacktrace(req *http.Request) (int, any, error) {
+	depth := 50
+	if s := req.URL.Query().Get("depth"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return 0, nil, &restError{http.StatusBadRequest, "bad depth " + strconv.Quote(s)}
+		}
+		depth = n
+	}
+
+	st, err := r.Session.Stacktrace(depth)
+	if err != nil {
+		return 0, nil, err
+	}
+	out := make([]restFrame, len(st.Frames))
+	for i, f := range st.Frames {
+		out[i] = restFrame{PC: fmt.Sprintf("%#x", f.PC), Func: f.Func, File: f.File, Line: f.Line, Goroutine: f.Goroutine}
+		if f.SP != 0 {
+			out[i].SP = fmt.Sprintf("%#x", f.SP)
+		}
+		if f.FP != 0 {
+			out[i].FP = fmt.Sprintf("%#x", f.FP)
+		}
+	}
+	resp := map[string]any{"frames": out, "thread": st.Thread}
+	if st.Goroutine != 0 {
+		resp["goroutine"] = st.Goroutine
+	}
+	return http.StatusOK, resp, nil
+}
@@ -0,0 +1,169 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// errRestart ends the session of the current target, which Debug then
+// opens again.
+var errRestart = errors.New("restart")
+
+// restartCommand handles "restart", also R at the prompt: the target is
+// killed and launched again as before. The breakpoints, watches,
+// catchpoints and groups are set again from their source locations, read
+// from the binary anew, and the new process runs to the first breakpoint,
+// or to main.main when there is none.
+func (d *Debugger) restartCommand(pid int, args []string) bool {
+	if len(args) != 0 {
+		d.println("usage: restart")
+		return false
+	}
+	if _, ok := d.current.(RestartableTarget); !ok {
+		d.println("Only a target the debugger started can be restarted")
+		return false
+	}
+	if d.ReadOnly {
+		d.println(ErrReadOnly)
+		return false
+	}
+	d.restarting = true
+	return true
+}
+
+// restartAfterExit offers to restart the target that just exited, at a
+// prompt on a terminal, and reports whether to. Scripts and other frontends
+// end their session with the target, as before.
+func (d *Debugger) restartAfterExit() bool {
+	switch ui := d.UI.(type) {
+	case *LineFrontend:
+		if !ui.interactive {
+			return false
+		}
+	case *KeyFrontend:
+	default:
+		return false
+	}
+	if d.Ws.Signaled() {
+		d.printf("Process %d was killed by signal %d, %v\n", d.Pid, d.Ws.Signal(), d.Ws.Signal())
+	} else {
+		d.printf("Process %d exited with status %d\n", d.Pid, d.Ws.ExitStatus())
+	}
+	const question = "restart, or q to quit"
+	for {
+		var input string
+		var ok bool
+		if d.reading {
+			// The line typed while the target ran answers.
+			d.printf("  Enter %s: > ", question)
+			in := <-d.input
+			input, ok, d.reading = in.text, in.ok, false
+		} else {
+			input, ok = d.UI.PromptCommand(question)
+		}
+		if !ok {
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(input)) {
+		case "r", "restart":
+			return true
+		case "q", "quit":
+			return false
+		case "":
+		default:
+			d.println("The target has exited; restart it or quit")
+		}
+	}
+}
+
+// killTarget kills the target and the processes it forked that are traced,
+// and waits for them to exit. A target that already exited is left alone.
+func (d *Debugger) killTarget(pgid int, exited bool) {
+	procs := make(map[int]bool)
+	for _, t := range d.tracees {
+		procs[t.proc] = true
+	}
+	if !exited {
+		procs[d.Pid] = true
+	}
+	delete(procs, 0)
+	if exited {
+		delete(procs, d.Pid)
+	}
+	for p := range procs {
+		syscall.Kill(p, syscall.SIGKILL)
+	}
+	for len(procs) > 0 {
+		var ws syscall.WaitStatus
+		wpid, err := syscall.Wait4(-pgid, &ws, syscall.WALL, nil)
+		if err != nil {
+			break
+		}
+		if ws.Exited() || ws.Signaled() {
+			delete(procs, wpid)
+		}
+	}
+}
+
+// prepareRestart keeps the setup of the session to apply to the new
+// process and forgets the old one. Symbols are read again, in case the
+// binary was rebuilt. Data watches follow addresses of the old process and
+// are dropped.
+func (d *Debugger) prepareRestart() {
+	saved := d.currentSession()
+	for _, w := range d.DataWatches {
+		d.printf("Dropping watchpoint %d on %s\n", w.ID, w.Expr)
+	}
+	d.saved, d.restarting, d.restarted = &saved, false, true
+	d.traps, d.dirtyTraps, d.rearm = nil, nil, false
+	d.Breakpoints, d.step, d.lineStep, d.until, d.nextBreakID = nil, nil, nil, 0, 0
+	d.Watches, d.DataWatches, d.Catches, d.Groups = nil, nil, nil, nil
+	d.pending, d.interrupts, d.stopRequested, d.stopSig = nil, 0, false, 0
+	d.debugRegsGen, d.debugRegsSynced, d.stackFree = 0, nil, 0
+	d.mainTrap, d.pluginTrap, d.fatalTraps, d.raceTrap, d.pendingBreaks, d.runtimeUp = 0, 0, nil, 0, nil, false
+	d.heldEvents, d.benchIter, d.returns = nil, nil, nil
+	d.SymTable = nil
+	for len(d.watchHits) > 0 {
+		<-d.watchHits
+	}
+}
+
+// runToMain sets a trap at main.main for a restarted target without
+// breakpoints to stop at, which mainStop picks up.
+func (d *Debugger) runToMain() {
+	for _, b := range d.Breakpoints {
+		if b.Enabled {
+			return
+		}
+	}
+	for _, g := range d.Groups {
+		if g.Enabled {
+			return
+		}
+	}
+	fn := d.SymTable.LookupFunc("main.main")
+	if fn == nil {
+		return
+	}
+	if err := d.addTrap(fn.Entry); err != nil {
+		d.println(err)
+		return
+	}
+	d.mainTrap = fn.Entry
+}
+
+// mainStop handles the hit of the trap runToMain set at addr.
+func (d *Debugger) mainStop(pid int, addr uint64) (string, bool, error) {
+	if d.mainTrap == 0 || addr != d.mainTrap {
+		return "", false, nil
+	}
+	if err := d.rewindTrap(pid, addr); err != nil {
+		return "", true, err
+	}
+	d.removeTrap(addr)
+	d.mainTrap = 0
+	return "main", true, nil
+}
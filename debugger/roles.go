@@ -0,0 +1,105 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Role is what the holder of a server token may do. Each role allows what
+// the ones before it do.
+type Role int
+
+const (
+	// RoleRead observes the session: stacks, evaluation and events.
+	RoleRead Role = iota + 1
+	// RoleBreak also sets breakpoints and resumes the target.
+	RoleBreak
+	// RoleFull may do anything, such as creating and deleting the
+	// sessions of a daemon.
+	RoleFull
+)
+
+var roleNames = map[Role]string{RoleRead: "read", RoleBreak: "break", RoleFull: "full"}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("role %d", int(r))
+}
+
+func parseRole(s string) (Role, bool) {
+	for r, name := range roleNames {
+		if name == s {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// tokenUsage describes the -token flag of the servers.
+const tokenUsage = "require clients to authenticate with the bearer token `[role:]t`, the role, read, break or full (the default), limiting what they may do (repeatable)"
+
+// Tokens maps the bearer tokens of a server to the roles of their holders.
+// As a flag.Value, it takes "[role:]token", full control without a role,
+// and can be repeated. The role is split off at a colon, which base64 and
+// hex tokens don't hold, unlike the "=" of base64 padding.
+type Tokens map[string]Role
+
+func (t Tokens) String() string {
+	var out []string
+	for _, r := range t {
+		out = append(out, r.String()+"=...")
+	}
+	sort.Strings(out)
+	return strings.Join(out, " ")
+}
+
+func (t Tokens) Set(s string) error {
+	role, token := RoleFull, s
+	if name, rest, ok := strings.Cut(s, ":"); ok {
+		r, known := parseRole(name)
+		if !known {
+			return fmt.Errorf("unknown role %q: read, break or full", name)
+		}
+		role, token = r, rest
+	}
+	if token == "" {
+		return fmt.Errorf("empty token")
+	}
+	t[token] = role
+	return nil
+}
+
+// authorize returns the role of the token in the Authorization header auth,
+// "Bearer <token>". Without tokens, everyone has full control. Every token
+// is compared in constant time, so the time taken gives none away.
+func (t Tokens) authorize(auth string) (Role, bool) {
+	if len(t) == 0 {
+		return RoleFull, true
+	}
+	var role Role
+	for token, r := range t {
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) == 1 {
+			role = r
+		}
+	}
+	return role, role != 0
+}
+
+// serverTokens returns the tokens of a server configured with a single
+// full-control token and a role map, either of which may be empty.
+func serverTokens(token string, tokens Tokens) Tokens {
+	if token == "" {
+		return tokens
+	}
+	all := Tokens{token: RoleFull}
+	for t, r := range tokens {
+		all[t] = r
+	}
+	return all
+}
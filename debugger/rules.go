@@ -0,0 +1,122 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"strings"
+)
+
+// onCommand handles "on stop at <location>: <command>; ...", which attaches
+// commands to the breakpoint at location, setting one if needed. They run
+// at every hit that stops; ending them with "continue" resumes the target
+// afterwards, so loops can be instrumented without sitting at the prompt:
+//
+//	on stop at main.retryLoop: print attempt; continue
+//
+// "on" alone lists the rules and "on delete <breakpoint>" removes one,
+// keeping the breakpoint.
+func (d *Debugger) onCommand(pid int, args []string) bool {
+	const usage = "usage: on stop at <location>: <command>[; <command>...][; continue] | on delete <breakpoint>"
+	switch {
+	case len(args) == 0:
+		found := false
+		for _, b := range d.ListBreakpoints() {
+			if len(b.Commands) > 0 {
+				d.printf("  %d: on stop at %s: %s\n", b.ID, b, strings.Join(b.Commands, "; "))
+				found = true
+			}
+		}
+		if !found {
+			d.println("No stop rules")
+		}
+		return false
+	case args[0] == "delete" && len(args) == 2:
+		return breakpointIDsCommand("on delete", func(d *Debugger, pid, id int) error {
+			b, err := d.breakpointByID(id)
+			if err == nil {
+				b.Commands = nil
+			}
+			return err
+		})(d, pid, args[1:])
+	case len(args) < 3 || args[0] != "stop" || args[1] != "at":
+		d.println(usage)
+		return false
+	}
+
+	rule := strings.Join(args[2:], " ")
+	i := strings.Index(rule, ": ")
+	if i < 0 {
+		d.println(usage)
+		return false
+	}
+	location := rule[:i]
+	commands, err := d.parseRuleCommands(rule[i+2:])
+	if err != nil {
+		d.println(err)
+		return false
+	}
+
+	file, line, err := d.parseLocation(location)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	b := d.breakpointAt(file, line)
+	if b == nil {
+		b, err = d.AddBreakpoint(pid, file, line, nil)
+		d.audit("", "breakpoint", pid, fmt.Sprintf("%s:%d (rule)", file, line), err)
+		if err != nil {
+			d.printf("Can't set breakpoint at %s:%d: %v\n", file, line, err)
+			return false
+		}
+	}
+	b.Commands = commands
+	d.printf("Breakpoint %d at %s (%#x) runs: %s\n", b.ID, b, b.Addr, strings.Join(commands, "; "))
+	return false
+}
+
+// parseRuleCommands splits the commands of a stop rule at semicolons. They
+// have to be word commands or macros, but for a final "continue".
+func (d *Debugger) parseRuleCommands(s string) ([]string, error) {
+	var commands []string
+	for _, c := range strings.Split(s, ";") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if len(commands) > 0 && isContinue(commands[len(commands)-1]) {
+			return nil, fmt.Errorf("continue has to be the last command of a rule")
+		}
+		if !isContinue(c) {
+			if !d.isCommand(strings.ToLower(strings.Fields(c)[0])) {
+				return nil, fmt.Errorf("unknown command %q in rule", c)
+			}
+		}
+		commands = append(commands, c)
+	}
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("the rule has no commands")
+	}
+	return commands, nil
+}
+
+func isContinue(c string) bool {
+	c = strings.ToLower(c)
+	return c == "continue" || c == "c"
+}
+
+// runRule runs the commands of breakpoint b, which thread pid just hit. It
+// reports whether the target should be resumed rather than stop at the
+// prompt.
+func (d *Debugger) runRule(pid int, b *Breakpoint) bool {
+	for _, c := range b.Commands {
+		if isContinue(c) {
+			return true
+		}
+		if _, cont := d.wordCommand(pid, c); cont {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// maxSafePointSteps bounds the instructions stepped to reach a safe point
+// after an interrupt.
+const maxSafePointSteps = 10000
+
+// unsafePoint describes why pc, where a thread stopped, isn't a safe point,
+// or returns "" if it is. A safe point is where the runtime itself would
+// preempt a goroutine asynchronously: in a Go function outside the runtime
+// with the pointer maps of its stack, and not in one of the sequences the
+// compiler marks unsafe, such as write barriers and function prologues.
+// There the runtime's view of the goroutine is consistent, and writing
+// memory or registers can't catch it halfway through its own changes.
+func (d *Debugger) unsafePoint(pc uint64) string {
+	fn := d.pcToFunc(pc)
+	if fn == nil {
+		return fmt.Sprintf("at %#x, outside Go code", pc)
+	}
+	file, _, _ := d.pcToLine(pc)
+	if ownsRuntimeState(fn.Name) || strings.Contains(file, "/src/runtime/") || strings.Contains(file, "/src/internal/runtime/") {
+		return "in the runtime, " + fn.Name
+	}
+	if d.pcln == nil {
+		if d.pcln = newPclnFuncs(d.load.lines, d.load.text); d.pcln == nil {
+			// Without the tables, being out of the runtime is all that
+			// can be told.
+			return ""
+		}
+	}
+	f, ok := d.pcln.find(d.linkPC(pc))
+	switch {
+	case !ok:
+		return ""
+	case f.flag&funcFlagAsm != 0 || !d.pcln.hasFuncdata(f, funcdataLocalsPointers):
+		return "in assembly, " + fn.Name
+	case d.pcln.pcdata(f, pcdataUnsafePoint, d.linkPC(pc)) != unsafePointSafe:
+		return fmt.Sprintf("at an unsafe point of %s", fn.Name)
+	}
+	return ""
+}
+
+// ownsRuntimeState reports whether name is a function of the runtime, or
+// of reflect, which handles the runtime's structures the same way. Other
+// internal packages, unlike for line steps, have safe points.
+func ownsRuntimeState(name string) bool {
+	for _, prefix := range []string{"runtime.", "runtime/internal/", "internal/runtime/", "reflect."} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// inSyscall reports whether the thread pid, stopped with d.Regs, is in or
+// at a system call, which stepping could block in.
+func (d *Debugger) inSyscall(pid int) bool {
+	// A call interrupted by the stop returns -ERESTARTSYS and the like,
+	// and is restarted when the thread resumes.
+	if int64(d.Regs.Orig_rax) >= 0 && int64(d.Regs.Rax) >= -516 && int64(d.Regs.Rax) <= -512 {
+		return true
+	}
+	code := make([]byte, 2)
+	return d.ReadMemory(pid, d.Regs.Rip, code) == nil && code[0] == 0x0f && code[1] == 0x05
+}
+
+// rollToSafePoint steps the thread pid, stopped by an interrupt, until it
+// is at a safe point, as SafePoints asks. A thread that can't get to one
+// without running on, such as one blocked in a system call, stays where it
+// is, and memory and register writes are refused until the target moves.
+// Signals arriving meanwhile are delivered when it resumes.
+func (d *Debugger) rollToSafePoint(pid int) error {
+	why := d.unsafePoint(d.Regs.Rip)
+	steps := 0
+	for ; why != "" && steps < maxSafePointSteps; steps++ {
+		if d.inSyscall(pid) || d.armedTrap(d.Regs.Rip) != nil {
+			break
+		}
+		if err := d.proc().Step(pid); err != nil {
+			return wrapPtrace("single-stepping", pid, err)
+		}
+		if _, err := d.proc().Wait(pid, &d.Ws, nil); err != nil {
+			return fmt.Errorf("waiting for thread %d: %w", pid, err)
+		}
+		if !d.Ws.Stopped() {
+			return nil
+		}
+		if s := d.Ws.StopSignal(); s != syscall.SIGTRAP {
+			d.stopSig = s
+		}
+		if err := d.proc().GetRegs(pid, &d.Regs); err != nil {
+			return wrapPtrace("reading registers of", pid, err)
+		}
+		why = d.unsafePoint(d.Regs.Rip)
+	}
+	if why != "" {
+		d.unsafeStop = why
+		d.printf("Stopped %s, not at a safe point: writes to the target are refused until it moves on\n", why)
+	} else if steps > 0 {
+		d.printf("Stepped %d instructions to a safe point\n", steps)
+	}
+	return nil
+}
+
+// checkSafePoint returns ErrUnsafePoint for a change to the target while
+// it is stopped where rollToSafePoint couldn't get it to a safe point.
+func (d *Debugger) checkSafePoint() error {
+	if d.unsafeStop != "" {
+		return fmt.Errorf("%w: stopped %s", ErrUnsafePoint, d.unsafeStop)
+	}
+	return nil
+}
+
+// setSafePoints handles "set safe-points on|off".
+func (d *Debugger) setSafePoints(arg string) {
+	switch arg {
+	case "on", "off":
+		d.SafePoints = arg == "on"
+		if !d.SafePoints {
+			d.unsafeStop = ""
+		}
+	default:
+		d.println("usage: set safe-points on|off")
+	}
+}
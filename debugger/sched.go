@@ -0,0 +1,83 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// SchedConfig holds the knobs used to make the tracee's scheduling more
+// repeatable while chasing race-dependent bugs.
+type SchedConfig struct {
+	// CPU pins the tracee to a single CPU. A negative value leaves the
+	// affinity untouched.
+	CPU int `json:"cpu"`
+	// GoMaxProcs is exported to the tracee as GOMAXPROCS when non-zero.
+	GoMaxProcs int `json:"gomaxprocs,omitempty"`
+	// Serialize disables asynchronous preemption in the tracee so that
+	// goroutines only switch at cooperative points, and forces
+	// GOMAXPROCS=1 unless it was set explicitly.
+	Serialize bool `json:"serialize,omitempty"`
+}
+
+// ApplySchedEnv adds the scheduling related environment variables to cmd.
+func (d *Debugger) ApplySchedEnv(cmd *exec.Cmd) {
+	if cmd.Env == nil {
+		cmd.Env = cmd.Environ()
+	}
+
+	procs := d.Sched.GoMaxProcs
+	if procs == 0 && d.Sched.Serialize {
+		procs = 1
+	}
+	if procs > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GOMAXPROCS=%d", procs))
+	}
+	if d.Sched.Serialize {
+		cmd.Env = addGODEBUG(cmd.Env, "asyncpreemptoff=1")
+	}
+}
+
+// addGODEBUG adds setting to the GODEBUG of env, after those already
+// there, which a second GODEBUG entry would lose: the last one set wins.
+func addGODEBUG(env []string, setting string) []string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if v, ok := strings.CutPrefix(env[i], "GODEBUG="); ok {
+			if v != "" {
+				setting = v + "," + setting
+			}
+			env[i] = "GODEBUG=" + setting
+			return env
+		}
+	}
+	return append(env, "GODEBUG="+setting)
+}
+
+// PinToCPU restricts pid to the configured CPU. Threads created by the
+// tracee afterwards inherit the affinity, so this should be called while
+// the tracee is still stopped at its first instruction.
+func (d *Debugger) PinToCPU(pid int) error {
+	if d.Sched.CPU < 0 {
+		return nil
+	}
+	if d.ReadOnly {
+		return ErrReadOnly
+	}
+
+	var mask [1024 / 64]uint64
+	if d.Sched.CPU >= len(mask)*64 {
+		return fmt.Errorf("cpu %d out of range", d.Sched.CPU)
+	}
+	mask[d.Sched.CPU/64] |= 1 << (uint(d.Sched.CPU) % 64)
+
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY,
+		uintptr(pid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxScrollback bounds the lines of output the line prompt keeps.
+const maxScrollback = 10000
+
+// defaultScrollbackLines is how many lines scrollback shows unless -n says.
+const defaultScrollbackLines = 20
+
+// ScrollbackFrontend is a Frontend that keeps what it has shown and the
+// commands typed, for the scrollback command to search long after the
+// terminal has let them go.
+type ScrollbackFrontend interface {
+	Frontend
+	// Scrollback returns the lines of output shown, oldest first.
+	Scrollback() []string
+	// CommandHistory returns the commands typed, oldest first.
+	CommandHistory() []string
+}
+
+// record adds the output text to the scrollback. A line not yet ended,
+// such as a prompt, is completed by the output after it.
+func (f *LineFrontend) record(text string) {
+	lines := strings.Split(f.partial+text, "\n")
+	f.partial = lines[len(lines)-1]
+	f.scrollback = append(f.scrollback, lines[:len(lines)-1]...)
+	if over := len(f.scrollback) - maxScrollback; over > 0 {
+		f.scrollback = append(f.scrollback[:0], f.scrollback[over:]...)
+	}
+}
+
+func (f *LineFrontend) Scrollback() []string {
+	return f.scrollback
+}
+
+func (f *LineFrontend) CommandHistory() []string {
+	if f.editor == nil {
+		return nil
+	}
+	f.editor.loadHistory()
+	return f.editor.history
+}
+
+// scrollbackCommand handles "scrollback [-commands] [-n <lines>]
+// [<regexp>]", which shows the last lines of output, or with regexp the
+// last of those that match it, numbered by their place in the scrollback.
+// With -commands it searches the command history instead.
+func (d *Debugger) scrollbackCommand(pid int, args []string) bool {
+	const usage = "usage: scrollback [-commands] [-n <lines>] [<regexp>]"
+	ui, ok := d.frontend().(ScrollbackFrontend)
+	if !ok {
+		d.println("This frontend keeps no scrollback")
+		return false
+	}
+	commands, n := false, defaultScrollbackLines
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch {
+		case args[0] == "-commands":
+			commands = true
+			args = args[1:]
+		case args[0] == "-n" && len(args) > 1:
+			v, err := strconv.Atoi(args[1])
+			if err != nil || v <= 0 {
+				d.println(usage)
+				return false
+			}
+			n = v
+			args = args[2:]
+		default:
+			d.println(usage)
+			return false
+		}
+	}
+	var re *regexp.Regexp
+	if len(args) > 0 {
+		var err error
+		if re, err = regexp.Compile(strings.Join(args, " ")); err != nil {
+			d.println(err)
+			return false
+		}
+	}
+	lines := ui.Scrollback()
+	if commands {
+		lines = ui.CommandHistory()
+	}
+	// The matches are taken before anything is printed, which goes into the
+	// scrollback too.
+	var found []int
+	for i := len(lines) - 1; i >= 0 && len(found) < n; i-- {
+		if re == nil || re.MatchString(lines[i]) {
+			found = append(found, i)
+		}
+	}
+	if len(found) == 0 {
+		if re != nil {
+			d.printf("Nothing matches %s\n", re)
+		}
+		return false
+	}
+	var b strings.Builder
+	for i := len(found) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%6d  %s\n", found[i]+1, lines[found[i]])
+	}
+	d.UI.ShowOutput(b.String())
+	return false
+}
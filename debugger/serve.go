@@ -0,0 +1,122 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// Serve implements "serve [-grpc addr] [-rest addr] [-nvim addr] [-token
+// [role:]t]... [-audit file] <target> [args...]" and "serve -dap addr
+// [-reconnect policy] [-token [role:]t]... [-audit file] [target
+// [args...]]". It launches the target stopped at its
+// first instruction and serves it to remote clients, returning the exit
+// code. Without tokens, it only listens where clients on this host can
+// connect.
+func Serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	grpcAddr := flags.String("grpc", "", "serve the gRPC API on `addr`")
+	restAddr := flags.String("rest", "", "serve the REST API on `addr`")
+	nvimAddr := flags.String("nvim", "", "serve msgpack-RPC for the Neovim plugin on `addr` (a socket path if it contains a slash)")
+	tokens := Tokens{}
+	flags.Var(tokens, "token", tokenUsage)
+	dapAddr := flags.String("dap", "", "serve the Debug Adapter Protocol on `addr` to one client, which may name the target")
+	reconnect := flags.String("reconnect", "", "keep the DAP session for another client when the connection drops, the target stopped or let run as `policy`, stop or continue, says")
+	eventFD := flags.Int("event-fd", -1, "write NDJSON events, including symbol loading progress, to file descriptor `N`")
+	audit := flags.String("audit", "", "append a record of every change the clients make to the target, and who made it, to `file`")
+	var notify []string
+	flags.Func("notify", "post the stops of breakpoints to the webhook at `url` (repeatable)", func(url string) error {
+		notify = append(notify, url)
+		return nil
+	})
+	flags.Parse(args)
+	if *dapAddr != "" && *grpcAddr == "" && *restAddr == "" && *nvimAddr == "" {
+		return ServeDAP(*dapAddr, *reconnect, *audit, tokens, flags.Args())
+	}
+	if *grpcAddr == "" && *restAddr == "" && *nvimAddr == "" || flags.NArg() < 1 {
+		fmt.Println("usage: serve [-grpc addr] [-rest addr] [-nvim addr] [-token [role:]t]... [-audit file] <target> [args...] | serve -dap addr [-reconnect stop|continue] [-token [role:]t]... [-audit file] [target [args...]]")
+		flags.PrintDefaults()
+		return 2
+	}
+
+	d := NewDebugger()
+	d.HandleSignals = true
+	d.Notify = notify
+	if *eventFD >= 0 {
+		if err := d.OpenEventFD(*eventFD); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	if *audit != "" {
+		if err := d.OpenAudit(*audit); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	sess, err := LaunchDebugger(d, flags.Arg(0), flags.Args()[1:]...)
+	if err != nil {
+		fmt.Printf("Can't launch %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	defer sess.Close()
+
+	servers := []struct {
+		name, addr string
+		serve      func(net.Listener) error
+	}{
+		{"gRPC", *grpcAddr, (&GRPCServer{Session: sess, Tokens: tokens}).Serve},
+		{"REST", *restAddr, (&RESTServer{Session: sess, Tokens: tokens}).Serve},
+		{"Neovim RPC", *nvimAddr, (&NvimServer{Session: sess, Tokens: tokens}).Serve},
+	}
+	var listeners []net.Listener
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		if srv.addr == "" {
+			continue
+		}
+		network := "tcp"
+		if strings.Contains(srv.addr, "/") {
+			network = "unix"
+		}
+		l, err := net.Listen(network, srv.addr)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if err := checkExposure(l.Addr(), tokens); err != nil {
+			l.Close()
+			fmt.Println(err)
+			return 2
+		}
+		listeners = append(listeners, l)
+		fmt.Printf("Serving %s for %s on %s\n", srv.name, flags.Arg(0), l.Addr())
+		go func() { errs <- srv.serve(l) }()
+	}
+
+	// Shut down on SIGINT or SIGTERM so the target is killed rather than
+	// left behind with breakpoints in its code.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	var stopped atomic.Bool
+	go func() {
+		<-sigs
+		stopped.Store(true)
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+
+	if err := <-errs; err != nil && !stopped.Load() {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,792 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"context"
+	"debug/gosym"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// TB is the part of testing.TB used by Session.
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...any)
+	Cleanup(func())
+}
+
+// Session drives a target under the debugger from Go code, so that tests can
+// launch a binary, run it to a location and assert on its internal state:
+//
+//	sess := debugger.NewSession(t, "./server")
+//	sess.ExpectStopAt("handler.go", 42)
+//	sess.EvalEquals("main.requests", 1)
+type Session struct {
+	t   TB
+	d   *Debugger
+	cmd *exec.Cmd
+	pid int
+	tid int
+
+	exited      bool
+	status      syscall.WaitStatus
+	interrupted atomic.Bool
+	last        Event
+
+	ops chan func()
+
+	// queued are operations submitted with doStopped while the target may
+	// be running. They are applied at a SIGSTOP forced for them.
+	mu      sync.Mutex
+	running bool
+	queued  []func(tid int)
+	// bps are copies of the session's breakpoints, by ID, for listing
+	// them while the target runs.
+	bps []Breakpoint
+}
+
+// NewSession launches path with args stopped at its first instruction. The
+// target is killed when the test finishes.
+func NewSession(t TB, path string, args ...string) *Session {
+	t.Helper()
+
+	s, err := Launch(path, args...)
+	if err != nil {
+		t.Fatalf("launching %s: %v", path, err)
+	}
+	s.t = t
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Launch starts path with args under the debugger, stopped at its first
+// instruction. It is the headless core used by NewSession and the servers;
+// the caller has to Close the session.
+func Launch(path string, args ...string) (*Session, error) {
+	return LaunchDebugger(NewDebugger(), path, args...)
+}
+
+// LaunchDebugger is Launch with a debugger configured by the caller, e.g.
+// with an event stream that should also see symbol loading.
+func LaunchDebugger(d *Debugger, path string, args ...string) (*Session, error) {
+	table, err := d.GetSymbolTable(path)
+	if err != nil {
+		return nil, err
+	}
+	d.SymTable = table
+	s := &Session{
+		d:   d,
+		ops: make(chan func()),
+	}
+
+	// All ptrace requests have to come from the thread that started the
+	// target, so they are funneled through one locked goroutine.
+	go func() {
+		runtime.LockOSThread()
+		for op := range s.ops {
+			op()
+		}
+	}()
+
+	s.do(func() { err = s.launch(path, args) })
+	if err != nil {
+		close(s.ops)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Debugger returns the debugger state behind the session.
+func (s *Session) Debugger() *Debugger {
+	return s.d
+}
+
+func (s *Session) do(op func()) {
+	done := make(chan struct{})
+	s.ops <- func() {
+		op()
+		close(done)
+	}
+	<-done
+}
+
+// doStopped runs op on the tracer thread with a thread of the target
+// stopped, passing that thread. If the target is running, it is stopped for
+// op with SIGSTOP and resumed afterwards, so changes such as breakpoints
+// take effect without waiting for the next stop.
+func (s *Session) doStopped(op func(tid int)) {
+	done := make(chan struct{})
+	s.mu.Lock()
+	s.queued = append(s.queued, func(tid int) {
+		op(tid)
+		close(done)
+	})
+	running := s.running
+	s.mu.Unlock()
+	if running {
+		syscall.Kill(s.pid, syscall.SIGSTOP)
+	}
+
+	// The tracer thread applies the queue between operations, or wait does
+	// when it picks up the SIGSTOP.
+	select {
+	case s.ops <- func() { s.applyQueued(s.tid) }:
+	case <-done:
+	}
+	<-done
+}
+
+// applyQueued runs the operations queued by doStopped on the stopped
+// thread tid.
+func (s *Session) applyQueued(tid int) {
+	s.mu.Lock()
+	queued := s.queued
+	s.queued = nil
+	s.mu.Unlock()
+	for _, op := range queued {
+		op(tid)
+	}
+}
+
+// setRunning records whether wait is running the target. Operations queued
+// just before it started are applied at a forced stop; those queued as it
+// returns are applied right away.
+func (s *Session) setRunning(running bool) {
+	s.mu.Lock()
+	s.running = running
+	pending := len(s.queued) > 0
+	s.mu.Unlock()
+	switch {
+	case running && pending:
+		syscall.Kill(s.pid, syscall.SIGSTOP)
+	case !running && pending:
+		s.applyQueued(s.tid)
+	}
+}
+
+func (s *Session) launch(path string, args []string) error {
+	s.cmd = exec.Command(path, args...)
+	s.cmd.Stdout = os.Stdout
+	s.cmd.Stderr = os.Stderr
+	s.cmd.SysProcAttr = &syscall.SysProcAttr{
+		Ptrace:  true,
+		Setpgid: true,
+	}
+	if err := s.cmd.Start(); err != nil {
+		return err
+	}
+	s.d.stats.start = time.Now()
+
+	s.pid = s.cmd.Process.Pid
+	s.tid = s.pid
+	s.d.Pid, s.d.target = s.pid, path
+	if _, err := s.d.proc().Wait(s.pid, &s.d.Ws, nil); err != nil {
+		return err
+	}
+	if err := s.d.relocate(s.pid); err != nil {
+		return err
+	}
+	return syscall.PtraceSetOptions(s.pid, syscall.PTRACE_O_TRACECLONE)
+}
+
+// wait runs until the breakpoint is hit or the target exits.
+func (s *Session) wait() error {
+	d := s.d
+	s.setRunning(true)
+	defer s.setRunning(false)
+	for {
+		wpid, err := d.proc().Wait(-s.pid, &d.Ws, nil)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.Ws.Exited() || d.Ws.Signaled():
+			if wpid == s.pid {
+				s.exited, s.status = true, d.Ws
+				s.report(exitEvent(wpid, d.Ws))
+				return nil
+			}
+		case d.Ws.StopSignal() == syscall.SIGTRAP && d.Ws.TrapCause() != syscall.PTRACE_EVENT_CLONE:
+			// Traps are handled as the event loop does: temporary
+			// breakpoints go at their first stop and the commands of a
+			// breakpoint run, resuming the target if they end in continue.
+			n := len(d.Breakpoints)
+			reason, ok, err := d.trapStop(wpid)
+			if len(d.Breakpoints) != n {
+				s.listBreakpoints()
+			}
+			switch {
+			case err != nil:
+				return err
+			case !ok:
+				if err := d.passSignal(wpid); err != nil {
+					return err
+				}
+				continue
+			case reason == "":
+				continue
+			}
+			// Any stop ends a next or finish, as at the prompt.
+			s.tid = wpid
+			d.endStep()
+			stop := d.locationEvent("stop", wpid, d.Regs.Rip)
+			stop.Reason = reason
+			s.report(stop)
+			return nil
+		case d.Ws.StopSignal() == syscall.SIGSTOP:
+			// Forced by Interrupt or doStopped, or sent to the target by
+			// someone else; only Interrupt ends the wait.
+			if err := d.proc().GetRegs(wpid, &d.Regs); err != nil {
+				return err
+			}
+			s.applyQueued(wpid)
+			if !s.interrupted.Swap(false) {
+				// The thread of a line step goes on stepping.
+				stepping := d.lineStep != nil && d.lineStep.tid == wpid
+				if err := d.resume(wpid, !stepping); err != nil {
+					return err
+				}
+				continue
+			}
+			s.tid = wpid
+			d.endStep()
+			stop := d.locationEvent("stop", wpid, d.Regs.Rip)
+			stop.Reason = "interrupt"
+			s.report(stop)
+			return nil
+		default:
+			// Signals between the single steps of a line step are
+			// dropped, as the event loop does.
+			if _, ok, err := d.lineStepSignal(wpid); ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			// A signal of the target's own, such as the SIGSEGV of a nil
+			// dereference it recovers from, goes on to it.
+			if err := d.passSignal(wpid); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// report records ev as the latest event of the session, emits it and
+// notifies the webhooks of a breakpoint's stop.
+func (s *Session) report(ev Event) {
+	ev.Time = time.Now()
+	s.last = ev
+	s.d.Emit(ev)
+	if ev.Type == "stop" {
+		s.d.notifyStop(s.tid, ev)
+	}
+}
+
+// LastEvent returns the most recent stop or exit event.
+func (s *Session) LastEvent() Event {
+	return s.last
+}
+
+// Interrupt stops the running target. A pending Continue returns with an
+// "interrupt" stop.
+func (s *Session) Interrupt() error {
+	s.interrupted.Store(true)
+	return syscall.Kill(s.pid, syscall.SIGSTOP)
+}
+
+// runContext runs a resuming operation such as Continue, interrupting the
+// target when ctx ends first. It then returns ctx's error.
+func (s *Session) runContext(ctx context.Context, op func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- op() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		s.Interrupt()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// setBreak plants a breakpoint at file:line through the stopped thread tid
+// and returns it, or the one already there.
+func (s *Session) setBreak(tid int, file string, line int) (*Breakpoint, error) {
+	d := s.d
+	path, err := d.ResolveFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if addr, _, err := d.lineToPC(path, line); err == nil && d.Breakpoints[addr] != nil {
+		return d.Breakpoints[addr], nil
+	}
+	b, err := d.AddBreakpoint(tid, path, line, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't set breakpoint at %s:%d: %v", file, line, err)
+	}
+	s.listBreakpoints()
+	return b, nil
+}
+
+// clearBreak removes the breakpoint id.
+func (s *Session) clearBreak(id int) error {
+	if err := s.d.RemoveBreakpoint(id); err != nil {
+		return err
+	}
+	s.listBreakpoints()
+	return nil
+}
+
+// listBreakpoints copies the breakpoints to s.bps after a change.
+func (s *Session) listBreakpoints() {
+	bps := make([]Breakpoint, 0, len(s.d.Breakpoints))
+	for _, b := range s.d.Breakpoints {
+		bps = append(bps, *b)
+	}
+	sort.Slice(bps, func(i, j int) bool { return bps[i].ID < bps[j].ID })
+	s.mu.Lock()
+	s.bps = bps
+	s.mu.Unlock()
+}
+
+// step executes one instruction of the current thread.
+func (s *Session) step() error {
+	d := s.d
+	if err := d.flushTraps(s.tid); err != nil {
+		return err
+	}
+	if err := d.liftTrapAtPC(s.tid); err != nil {
+		return err
+	}
+	for {
+		if err := d.proc().Step(s.tid); err != nil {
+			return err
+		}
+		if _, err := d.proc().Wait(s.tid, &d.Ws, nil); err != nil {
+			return err
+		}
+		if d.Ws.Exited() || d.Ws.Signaled() {
+			s.exited, s.status = true, d.Ws
+			s.report(exitEvent(s.tid, d.Ws))
+			return nil
+		}
+		if d.Ws.StopSignal() == syscall.SIGTRAP {
+			break
+		}
+	}
+
+	if err := d.proc().GetRegs(s.tid, &d.Regs); err != nil {
+		return err
+	}
+	if d.rearm {
+		d.rearmTrap(s.tid)
+	}
+	stop := d.locationEvent("stop", s.tid, d.Regs.Rip)
+	stop.Reason = "step"
+	s.report(stop)
+	return nil
+}
+
+// runTo continues until the target stops or exits, with a breakpoint at
+// file:line for the run unless one is there already.
+func (s *Session) runTo(file string, line int) error {
+	d := s.d
+	n := len(d.Breakpoints)
+	b, err := s.setBreak(s.tid, file, line)
+	if err != nil {
+		return err
+	}
+	if err := d.resume(s.tid, true); err != nil {
+		return err
+	}
+	err = s.wait()
+	// Only a breakpoint the run added goes.
+	if len(d.Breakpoints) > n && !s.exited {
+		s.clearBreak(b.ID)
+	}
+	return err
+}
+
+// Break sets a breakpoint at file:line, besides those set before, and
+// returns it. A breakpoint already at the line is returned as it is.
+func (s *Session) Break(file string, line int) (*Breakpoint, error) {
+	return s.breakFor("", file, line)
+}
+
+// breakFor is Break on behalf of client, as the audit log records it.
+func (s *Session) breakFor(client, file string, line int) (b *Breakpoint, err error) {
+	s.doStopped(func(tid int) { b, err = s.setBreak(tid, file, line) })
+	s.d.audit(client, "breakpoint", s.pid, fmt.Sprintf("%s:%d", file, line), err)
+	return b, err
+}
+
+// BreakSpec is a breakpoint for BreakAt to set.
+type BreakSpec struct {
+	// Location is "file:line" or a function name, which stands for the end
+	// of the function's prologue.
+	Location string
+	// Temporary deletes the breakpoint at its first stop.
+	Temporary bool
+	// Cond is a condition on values the hits have to meet to stop, as
+	// SetBreakCondition takes it.
+	Cond string
+}
+
+// BreakAt sets a new breakpoint as spec says and returns it. Unlike Break,
+// it fails if the location has a breakpoint already.
+func (s *Session) BreakAt(spec BreakSpec) (b *Breakpoint, err error) {
+	s.doStopped(func(tid int) { b, err = s.setBreakSpec(tid, spec) })
+	s.d.audit("", "breakpoint", s.pid, spec.Location, err)
+	return b, err
+}
+
+// setBreakSpec plants the breakpoint of spec through the stopped thread tid.
+func (s *Session) setBreakSpec(tid int, spec BreakSpec) (*Breakpoint, error) {
+	d := s.d
+	file, line, err := d.parseLocation(spec.Location)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.AddBreakpoint(tid, file, line, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't set breakpoint at %s: %v", spec.Location, err)
+	}
+	if spec.Cond != "" {
+		if err := d.SetBreakCondition(b.ID, spec.Cond); err != nil {
+			d.RemoveBreakpoint(b.ID)
+			return nil, err
+		}
+	}
+	b.Temporary = spec.Temporary
+	s.listBreakpoints()
+	return b, nil
+}
+
+// Breakpoints returns copies of the session's breakpoints, by ID, as they
+// were when last set or cleared.
+func (s *Session) Breakpoints() []Breakpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.bps)
+}
+
+// ClearBreak removes the breakpoint id.
+func (s *Session) ClearBreak(id int) error {
+	return s.clearBreakFor("", id)
+}
+
+// clearBreakFor is ClearBreak on behalf of client.
+func (s *Session) clearBreakFor(client string, id int) (err error) {
+	s.doStopped(func(int) { err = s.clearBreak(id) })
+	s.d.audit(client, "delete-breakpoint", s.pid, strconv.Itoa(id), err)
+	return err
+}
+
+// Continue runs the target until it hits a breakpoint or exits.
+func (s *Session) Continue() (err error) {
+	if s.exited {
+		return fmt.Errorf("target has exited")
+	}
+	s.do(func() {
+		if err = s.d.resume(s.tid, true); err == nil {
+			err = s.wait()
+		}
+	})
+	return err
+}
+
+// Step executes a single instruction.
+func (s *Session) Step() (err error) {
+	if s.exited {
+		return fmt.Errorf("target has exited")
+	}
+	s.do(func() { err = s.step() })
+	return err
+}
+
+// Next runs the current line of the stopped thread, running its calls to
+// completion, until another line of the function or its return, as "next"
+// does at the prompt. A breakpoint hit first stops the target there.
+func (s *Session) Next() error {
+	return s.stepWith((*Debugger).StepOver, true)
+}
+
+// StepLine runs the current line of the stopped thread until another line,
+// stopping at the entry of the functions it calls outside the runtime, as
+// "step" does at the prompt.
+func (s *Session) StepLine() error {
+	return s.stepWith((*Debugger).StepLine, false)
+}
+
+// StepOut runs the target until the current function of the stopped
+// thread returns, as "finish" does at the prompt.
+func (s *Session) StepOut() error {
+	return s.stepWith((*Debugger).StepOut, true)
+}
+
+// stepWith sets up a step of the stopped thread with plan, then continues
+// the target, or single-steps the thread unless cont, until it stops.
+func (s *Session) stepWith(plan func(d *Debugger, pid int) error, cont bool) (err error) {
+	if s.exited {
+		return fmt.Errorf("target has exited")
+	}
+	s.do(func() {
+		d := s.d
+		if err = d.proc().GetRegs(s.tid, &d.Regs); err != nil {
+			return
+		}
+		if err = plan(d, s.tid); err != nil {
+			return
+		}
+		if err = d.resume(s.tid, cont); err == nil {
+			err = s.wait()
+		}
+	})
+	return err
+}
+
+// Stacktrace returns up to depth frames of the stopped thread, with the
+// goroutine it runs.
+func (s *Session) Stacktrace(depth int) (st Stacktrace, err error) {
+	if s.exited {
+		return Stacktrace{}, fmt.Errorf("target has exited")
+	}
+	s.do(func() { st, err = s.d.ThreadStack(s.tid, depth) })
+	return st, err
+}
+
+// Exited reports whether the target has exited, and how.
+func (s *Session) Exited() (bool, syscall.WaitStatus) {
+	return s.exited, s.status
+}
+
+// ExpectStopAt runs the target until it reaches file:line and fails the test
+// if it stops anywhere else or exits first. file may be any suffix of the
+// compile-time path, such as "handler.go".
+func (s *Session) ExpectStopAt(file string, line int) {
+	s.t.Helper()
+	if s.exited {
+		s.t.Fatalf("target already exited, can't stop at %s:%d", file, line)
+	}
+
+	var err error
+	s.do(func() { err = s.runTo(file, line) })
+	if err != nil {
+		s.t.Fatalf("running to %s:%d: %v", file, line, err)
+	}
+	if s.exited {
+		s.t.Fatalf("target exited (%v) before reaching %s:%d", s.status, file, line)
+	}
+
+	got := s.Location()
+	if !matchFile(got.File, file) || got.Line != line {
+		s.t.Fatalf("stopped at %s:%d, want %s:%d", got.File, got.Line, file, line)
+	}
+}
+
+// Location returns where the target is stopped.
+func (s *Session) Location() Location {
+	return s.d.LookupPC(s.d.Regs.Rip)
+}
+
+// Eval evaluates expr at the current stop.
+func (s *Session) Eval(expr string) (v any, err error) {
+	s.do(func() { v, err = s.d.Eval(s.tid, expr) })
+	return v, err
+}
+
+// EvalEquals fails the test unless expr evaluates to want at the current
+// stop. Values are compared by their printed form, so an int64 read from
+// the target equals an untyped constant in the test.
+func (s *Session) EvalEquals(expr string, want any) {
+	s.t.Helper()
+	got, err := s.Eval(expr)
+	if err != nil {
+		s.t.Fatalf("evaluating %s: %v", expr, err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		s.t.Fatalf("%s = %v, want %v", expr, got, want)
+	}
+}
+
+// ExpectExit continues the target and fails the test unless it exits with
+// code.
+func (s *Session) ExpectExit(code int) {
+	s.t.Helper()
+	if !s.exited {
+		var err error
+		s.do(func() {
+			for _, b := range s.d.Breakpoints {
+				s.clearBreak(b.ID)
+			}
+			if err = s.d.resume(s.tid, true); err == nil {
+				err = s.wait()
+			}
+		})
+		if err != nil {
+			s.t.Fatalf("waiting for exit: %v", err)
+		}
+	}
+	if !s.status.Exited() || s.status.ExitStatus() != code {
+		s.t.Fatalf("target finished with %v, want exit code %d", s.status, code)
+	}
+}
+
+// Close kills the target if it is still running.
+func (s *Session) Close() {
+	if s.ops == nil {
+		return
+	}
+	if !s.exited {
+		s.do(func() {
+			syscall.Kill(-s.pid, syscall.SIGKILL)
+			for {
+				wpid, err := s.d.proc().Wait(-s.pid, &s.status, nil)
+				if err != nil || wpid == s.pid && (s.status.Exited() || s.status.Signaled()) {
+					break
+				}
+			}
+			s.exited = true
+		})
+	}
+	close(s.ops)
+	s.ops = nil
+}
+
+// ResolveFile returns the compile-time path of the source file name, which
+// may be given as any path suffix. Failing that, the files whose paths end
+// in the most of name's trailing elements match, so a path from another
+// checkout of the sources still finds its file. A file of the target's
+// module may also be named by its path here, such as "../server/api.go"
+// from a directory of the module. The files of loaded plugins are searched
+// after the executable's.
+func (d *Debugger) ResolveFile(name string) (string, error) {
+	name = d.moduleFile(name)
+	var first error
+	for _, t := range d.symTables() {
+		path, err := resolveFile(t.Files, name)
+		if err == nil {
+			return path, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return "", first
+}
+
+func resolveFile(files map[string]*gosym.Obj, name string) (string, error) {
+	if _, ok := files[name]; ok {
+		return name, nil
+	}
+
+	var found []string
+	for path := range files {
+		if matchFile(path, name) {
+			found = append(found, path)
+		}
+	}
+	if len(found) == 0 {
+		found = closestFiles(files, name)
+	}
+	sort.Strings(found)
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no source file matches %s", name)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("%s is ambiguous: %s", name, strings.Join(found, ", "))
+}
+
+// closestFiles returns the paths that share the longest run of trailing
+// elements with name, at least the file name.
+func closestFiles(files map[string]*gosym.Obj, name string) []string {
+	want := strings.Split(filepath.ToSlash(filepath.Clean(name)), "/")
+	best, found := 1, []string(nil)
+	for path := range files {
+		elems := strings.Split(path, "/")
+		n := 0
+		for n < len(want) && n < len(elems) && want[len(want)-1-n] == elems[len(elems)-1-n] {
+			n++
+		}
+		switch {
+		case n > best:
+			best, found = n, []string{path}
+		case n == best:
+			found = append(found, path)
+		}
+	}
+	return found
+}
+
+// ResolveFunc finds the function name, which may leave out the import path
+// of its package, as in "server.Handle" for
+// "github.com/user/app/server.Handle", or the package altogether, as in
+// "work" for "main.work". The functions of loaded plugins are searched
+// after the executable's.
+func (d *Debugger) ResolveFunc(name string) (*gosym.Func, error) {
+	var first error
+	for _, t := range d.symTables() {
+		fn, err := resolveFunc(t, name)
+		if err == nil {
+			return fn, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return nil, first
+}
+
+func resolveFunc(t *gosym.Table, name string) (*gosym.Func, error) {
+	if fn := t.LookupFunc(name); fn != nil {
+		return fn, nil
+	}
+	suffix := "/" + name
+	if !strings.Contains(name, ".") {
+		if fn := t.LookupFunc("main." + name); fn != nil {
+			return fn, nil
+		}
+		suffix = "." + name
+	}
+
+	var found []*gosym.Func
+	for i := range t.Funcs {
+		if fn := &t.Funcs[i]; strings.HasSuffix(fn.Name, suffix) {
+			found = append(found, fn)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no function %s", name)
+	case 1:
+		return found[0], nil
+	}
+	names := make([]string, len(found))
+	for i, fn := range found {
+		names[i] = fn.Name
+	}
+	return nil, fmt.Errorf("%s is ambiguous: %s", name, strings.Join(names, ", "))
+}
+
+// matchFile reports whether name is a path suffix of path.
+func matchFile(path, name string) bool {
+	name = filepath.ToSlash(filepath.Clean(name))
+	return path == name || strings.HasSuffix(path, "/"+name)
+}
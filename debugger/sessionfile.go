@@ -0,0 +1,245 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SavedSession is the setup of a debugging session as written by "session
+// save": how the target is launched and the breakpoints, watches,
+// catchpoints and breakpoint groups set on it.
+type SavedSession struct {
+	Target     string           `json:"target"`
+	Args       []string         `json:"args,omitempty"`
+	Env        []string         `json:"env,omitempty"`
+	Dir        string           `json:"dir,omitempty"`
+	Sched      SchedConfig      `json:"sched"`
+	SchedTrace SchedTraceConfig `json:"sched_trace"`
+	// Breakpoint is the single breakpoint of sessions saved before there
+	// could be several. It is still read, but no longer written.
+	Breakpoint  *SavedBreakpoint  `json:"breakpoint,omitempty"`
+	Breakpoints []SavedBreakpoint `json:"breakpoints,omitempty"`
+	Watches     []string          `json:"watches,omitempty"`
+	Catches     []SavedCatch      `json:"catches,omitempty"`
+	Groups      []SavedGroup      `json:"groups,omitempty"`
+}
+
+// SavedBreakpoint is a breakpoint of a saved session.
+type SavedBreakpoint struct {
+	File      string   `json:"file"`
+	Line      int      `json:"line"`
+	HitCount  string   `json:"hitcount,omitempty"`
+	Cond      string   `json:"cond,omitempty"`
+	Ignore    int      `json:"ignore,omitempty"`
+	Sample    int      `json:"sample,omitempty"`
+	Mode      string   `json:"mode,omitempty"`
+	Collect   []string `json:"collect,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	Disabled  bool     `json:"disabled,omitempty"`
+	Commands  []string `json:"commands,omitempty"`
+	Temporary bool     `json:"temporary,omitempty"`
+	Returns   bool     `json:"returns,omitempty"`
+}
+
+// SavedGroup is a breakpoint group of a saved session.
+type SavedGroup struct {
+	Name      string   `json:"name"`
+	Locations []string `json:"locations"`
+	Enabled   bool     `json:"enabled,omitempty"`
+}
+
+// SavedCatch is a catchpoint of a saved session.
+type SavedCatch struct {
+	Kind string `json:"kind"`
+	Type string `json:"type"`
+	Log  bool   `json:"log,omitempty"`
+}
+
+// sessionCommand handles "session save <file>" and "session load <file>".
+func (d *Debugger) sessionCommand(pid int, args []string) bool {
+	if len(args) != 2 || args[0] != "save" && args[0] != "load" {
+		d.println("usage: session save|load <file>")
+		return false
+	}
+
+	if args[0] == "save" {
+		if err := d.SaveSession(args[1]); err != nil {
+			d.println(err)
+			return false
+		}
+		d.printf("Session saved to %s\n", args[1])
+		d.storeArtifact(args[1])
+		return false
+	}
+
+	s, err := LoadSession(args[1])
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	d.ApplySession(pid, s)
+	d.printf("Session loaded from %s (launch settings apply with -session)\n", args[1])
+	return false
+}
+
+// SaveSession writes the current setup to path as JSON.
+func (d *Debugger) SaveSession(path string) error {
+	s := d.currentSession()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// currentSession returns the current setup as SaveSession writes it.
+func (d *Debugger) currentSession() SavedSession {
+	s := SavedSession{
+		Target:     d.target,
+		Args:       d.launch.Args,
+		Env:        d.launch.Env,
+		Dir:        d.launch.Dir,
+		Sched:      d.Sched,
+		SchedTrace: d.SchedTrace,
+	}
+	for _, b := range d.ListBreakpoints() {
+		sb := SavedBreakpoint{File: b.File, Line: b.Line, Cond: b.Cond, Ignore: b.Ignore, Sample: b.Sample, Mode: string(b.Mode), Collect: b.Collect, Message: b.Message, Disabled: !b.Enabled, Commands: b.Commands, Temporary: b.Temporary, Returns: b.Returns}
+		if b.HitCond != nil {
+			sb.HitCount = b.HitCond.Text
+		}
+		s.Breakpoints = append(s.Breakpoints, sb)
+	}
+	for _, w := range d.Watches {
+		s.Watches = append(s.Watches, fmt.Sprintf("%s %s %d", w.Metric, w.Op, w.Limit))
+	}
+	for _, c := range d.Catches {
+		s.Catches = append(s.Catches, SavedCatch{Kind: c.Kind, Type: c.TypeName, Log: c.Log})
+	}
+	for _, g := range d.Groups {
+		s.Groups = append(s.Groups, SavedGroup{Name: g.Name, Locations: g.Locations, Enabled: g.Enabled})
+	}
+	return s
+}
+
+// LoadSession reads a session written by SaveSession.
+func LoadSession(path string) (*SavedSession, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s SavedSession
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// ApplySession sets the breakpoints, watches, catchpoints and groups of s on
+// the running target pid. Problems are reported and skipped, so one stale
+// entry doesn't lose the rest of the session.
+func (d *Debugger) ApplySession(pid int, s *SavedSession) {
+	breakpoints := s.Breakpoints
+	if s.Breakpoint != nil {
+		breakpoints = append([]SavedBreakpoint{*s.Breakpoint}, breakpoints...)
+	}
+	for _, sb := range breakpoints {
+		if err := d.applyBreakpoint(pid, sb); err != nil {
+			d.printf("Skipping breakpoint at %s:%d: %v\n", sb.File, sb.Line, err)
+		}
+	}
+
+	for _, text := range s.Watches {
+		w, err := ParseWatch(text)
+		if err != nil {
+			d.printf("Skipping watch %q: %v\n", text, err)
+			continue
+		}
+		d.AddWatch(pid, w)
+	}
+
+	for _, c := range s.Catches {
+		var err error
+		switch c.Kind {
+		case "alloc":
+			_, err = d.CatchAlloc(pid, c.Type, c.Log)
+		case "panic":
+			_, err = d.CatchPanic(pid, c.Log)
+		default:
+			err = fmt.Errorf("unknown kind")
+		}
+		d.audit("", "catchpoint", pid, strings.TrimSpace(c.Kind+" "+c.Type)+" (session)", err)
+		if err != nil {
+			d.printf("Skipping catch %s %s: %v\n", c.Kind, c.Type, err)
+		}
+	}
+
+	for _, sg := range s.Groups {
+		g := d.findGroup(sg.Name)
+		if g == nil {
+			g = &BreakGroup{Name: sg.Name}
+			d.Groups = append(d.Groups, g)
+		}
+		d.DisableGroup(pid, g)
+		g.Locations = append([]string(nil), sg.Locations...)
+		if sg.Enabled {
+			d.EnableGroup(pid, g)
+			d.audit("", "group", pid, g.Name+" enable (session)", nil)
+		}
+	}
+}
+
+// applyBreakpoint sets the saved breakpoint sb on pid. A breakpoint already
+// at the location, e.g. restored by -session and set again by the rc file,
+// is left alone.
+func (d *Debugger) applyBreakpoint(pid int, sb SavedBreakpoint) error {
+	var cond *HitCondition
+	if sb.HitCount != "" {
+		var err error
+		if cond, err = ParseHitCondition(sb.HitCount); err != nil {
+			return err
+		}
+	}
+	if d.breakpointAt(sb.File, sb.Line) != nil {
+		return nil
+	}
+	b, err := d.AddBreakpoint(pid, sb.File, sb.Line, cond)
+	d.audit("", "breakpoint", pid, fmt.Sprintf("%s:%d (session)", sb.File, sb.Line), err)
+	if err != nil {
+		return err
+	}
+	b.Commands = sb.Commands
+	b.Ignore = sb.Ignore
+	b.Sample = sb.Sample
+	b.Temporary = sb.Temporary
+	exprs := sb.Collect
+	if sb.Message != "" {
+		exprs = []string{sb.Message}
+	}
+	if err := d.SetBreakMode(b.ID, BreakMode(sb.Mode), exprs); err != nil {
+		return err
+	}
+	if err := d.SetBreakCondition(b.ID, sb.Cond); err != nil {
+		return err
+	}
+	if err := d.TraceReturns(b.ID, sb.Returns); err != nil {
+		return err
+	}
+	if sb.Disabled {
+		err := d.DisableBreakpoint(b.ID)
+		d.audit("", "disable-breakpoint", pid, strconv.Itoa(b.ID), err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// namespaces are the kinds of namespace in /proc/<pid>/ns, with the
+// nsenter options that enter them.
+var namespaces = []struct{ name, flag string }{
+	{"user", "--user"},
+	{"mnt", "--mount"},
+	{"uts", "--uts"},
+	{"ipc", "--ipc"},
+	{"net", "--net"},
+	{"pid", "--pid"},
+	{"cgroup", "--cgroup"},
+}
+
+// shellCommand handles "shell [--target-env] [<command>]", which runs
+// command, or else an interactive shell, in the terminal until it exits.
+// With --target-env it runs where the target does: with its environment,
+// working directory and namespaces, so that contacting its server or
+// reading its files goes where the target's own would.
+func (d *Debugger) shellCommand(pid int, args []string) bool {
+	targetEnv := len(args) > 0 && args[0] == "--target-env"
+	if targetEnv {
+		args = args[1:]
+	}
+	var cmd *exec.Cmd
+	if targetEnv {
+		var err error
+		if cmd, err = d.targetShell(pid, args); err != nil {
+			d.println(err)
+			return false
+		}
+	} else {
+		cmd = exec.Command(cmp.Or(os.Getenv("SHELL"), "/bin/sh"))
+		if len(args) > 0 {
+			cmd.Args = append(cmd.Args, "-c", strings.Join(args, " "))
+		}
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := runInForeground(cmd); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			d.println(err)
+		}
+	}
+	return false
+}
+
+// targetShell returns the command that runs args, or an interactive shell,
+// in the environment of the process of the thread pid, from /proc: the
+// environment it started with, its working directory and, through
+// nsenter, the namespaces where they differ from the debugger's, such as
+// those of its container. Its shell is the target's $SHELL, or /bin/sh,
+// which the container has rather than the debugger's.
+func (d *Debugger) targetShell(pid int, args []string) (*exec.Cmd, error) {
+	proc := d.Pid
+	if t := d.tracees[pid]; t != nil {
+		proc = t.proc
+	}
+	if proc == 0 {
+		return nil, fmt.Errorf("no live target")
+	}
+	environ, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", proc))
+	if err != nil {
+		return nil, fmt.Errorf("can't read the environment of %d: %v", proc, err)
+	}
+	var env []string
+	for _, kv := range bytes.Split(environ, []byte{0}) {
+		if len(kv) > 0 {
+			env = append(env, string(kv))
+		}
+	}
+	cwd, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", proc))
+	if err != nil {
+		return nil, fmt.Errorf("can't find the working directory of %d: %v", proc, err)
+	}
+
+	shell := "/bin/sh"
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "SHELL="); ok && v != "" {
+			shell = v
+		}
+	}
+	argv := []string{shell}
+	if len(args) > 0 {
+		argv = append(argv, "-c", strings.Join(args, " "))
+	}
+	var enter []string
+	for _, ns := range namespaces {
+		theirs, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/%s", proc, ns.name))
+		if err != nil {
+			continue
+		}
+		if ours, err := os.Readlink("/proc/self/ns/" + ns.name); err != nil || ours != theirs {
+			enter = append(enter, ns.flag)
+		}
+	}
+	var cmd *exec.Cmd
+	if len(enter) > 0 {
+		// The working directory and root are those of the target in its
+		// mount namespace, which nsenter takes from it.
+		nsenter := append([]string{"--target", strconv.Itoa(proc), "--root", "--wd"}, enter...)
+		cmd = exec.Command("nsenter", append(append(nsenter, "--"), argv...)...)
+	} else {
+		cmd = exec.Command(argv[0], argv[1:]...)
+		cmd.Dir = cwd
+	}
+	cmd.Env = env
+	return cmd, nil
+}
+
+// runInForeground runs cmd on the terminal as its foreground process
+// group, so that ^C and ^Z go to it rather than to the debugger, and takes
+// the terminal back after. A cmd reading something else than the terminal,
+// as a pager does, finds it on its output.
+func runInForeground(cmd *exec.Cmd) error {
+	fd := int(os.Stdin.Fd())
+	if !IsTerminal(fd) {
+		return cmd.Run()
+	}
+	ctty := 0
+	if cmd.Stdin != os.Stdin {
+		ctty = 1
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Foreground: true, Ctty: ctty}
+	err := cmd.Run()
+	// Taking the terminal back from the background raises SIGTTOU, which
+	// would stop the debugger.
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	pgrp := syscall.Getpgrp()
+	syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	return err
+}
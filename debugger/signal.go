@@ -0,0 +1,147 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// SignalAction is what the debugger does when a thread of the target
+// receives a signal.
+type SignalAction string
+
+const (
+	// SignalStop stops at the prompt. Continuing delivers the signal,
+	// unless the signal is ignored by then.
+	SignalStop SignalAction = "stop"
+	// SignalPass delivers the signal without stopping.
+	SignalPass SignalAction = "pass"
+	// SignalIgnore discards the signal; the target never sees it.
+	SignalIgnore SignalAction = "ignore"
+)
+
+// SignalPolicy holds the actions of the signals set with "signal" or
+// -signal. The others stop if they are fatal and are passed otherwise.
+// SIGTRAP and SIGSTOP are the debugger's own and aren't subject to it.
+type SignalPolicy map[syscall.Signal]SignalAction
+
+// fatalSignals are the signals raised by faults of the target, which stop
+// it by default.
+var fatalSignals = []syscall.Signal{
+	syscall.SIGSEGV, syscall.SIGBUS, syscall.SIGFPE, syscall.SIGILL, syscall.SIGABRT, syscall.SIGSYS,
+}
+
+// signalNames are the names of the standard signals, indexed by number.
+var signalNames = [...]string{
+	1: "SIGHUP", "SIGINT", "SIGQUIT", "SIGILL", "SIGTRAP", "SIGABRT", "SIGBUS", "SIGFPE",
+	"SIGKILL", "SIGUSR1", "SIGSEGV", "SIGUSR2", "SIGPIPE", "SIGALRM", "SIGTERM", "SIGSTKFLT",
+	"SIGCHLD", "SIGCONT", "SIGSTOP", "SIGTSTP", "SIGTTIN", "SIGTTOU", "SIGURG", "SIGXCPU",
+	"SIGXFSZ", "SIGVTALRM", "SIGPROF", "SIGWINCH", "SIGIO", "SIGPWR", "SIGSYS",
+}
+
+// Action returns what is done when sig is received.
+func (p SignalPolicy) Action(sig syscall.Signal) SignalAction {
+	if a, ok := p[sig]; ok {
+		return a
+	}
+	for _, s := range fatalSignals {
+		if s == sig {
+			return SignalStop
+		}
+	}
+	return SignalPass
+}
+
+// Set sets the action of the signal named sig, such as SIGSEGV, segv or 11.
+func (p SignalPolicy) Set(sig string, action SignalAction) error {
+	s, err := parseSignal(sig)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case SignalStop, SignalPass, SignalIgnore:
+	default:
+		return fmt.Errorf("unknown signal action %q: stop, pass or ignore", action)
+	}
+	if s == syscall.SIGTRAP || s == syscall.SIGSTOP || s == syscall.SIGKILL {
+		return fmt.Errorf("%s can't be handled", signalName(s))
+	}
+	p[s] = action
+	return nil
+}
+
+func parseSignal(name string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil {
+		if n <= 0 || n >= len(signalNames) {
+			return 0, fmt.Errorf("no signal %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	for i, s := range signalNames {
+		if s == name && i > 0 {
+			return syscall.Signal(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown signal %s", name)
+}
+
+func signalName(sig syscall.Signal) string {
+	if sig > 0 && int(sig) < len(signalNames) {
+		return signalNames[sig]
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
+
+// signalCommand handles "signal <signal> stop|pass|ignore", which sets
+// what the debugger does when the target receives the signal, and "signal"
+// alone, which lists the actions of the signals.
+func (d *Debugger) signalCommand(pid int, args []string) bool {
+	switch len(args) {
+	case 0:
+		for sig := syscall.Signal(1); int(sig) < len(signalNames); sig++ {
+			if sig == syscall.SIGTRAP || sig == syscall.SIGSTOP || sig == syscall.SIGKILL {
+				continue
+			}
+			d.printf("  %-10s %-7s %s\n", signalName(sig), d.Signals.Action(sig), sig)
+		}
+	case 2:
+		if d.Signals == nil {
+			d.Signals = make(SignalPolicy)
+		}
+		action := SignalAction(strings.ToLower(args[1]))
+		err := d.Signals.Set(args[0], action)
+		name := args[0]
+		if sig, err := parseSignal(name); err == nil {
+			name = signalName(sig)
+		}
+		d.audit("", "signal", pid, fmt.Sprintf("%s %s", name, action), err)
+		if err != nil {
+			d.println(err)
+		}
+	default:
+		d.println("usage: signal [<signal> stop|pass|ignore]")
+	}
+	return false
+}
+
+// signalStop handles the signals the policy stops at. The signal is held
+// back, and delivered when the thread continues.
+func (d *Debugger) signalStop(pid int) (string, bool, error) {
+	sig := d.Ws.StopSignal()
+	if sig == syscall.SIGTRAP || sig == syscall.SIGSTOP || d.Signals.Action(sig) != SignalStop {
+		return "", false, nil
+	}
+	if err := d.proc().GetRegs(pid, &d.Regs); err != nil {
+		return "", true, wrapPtrace("reading registers of", pid, err)
+	}
+	d.printf("Thread %d received %s (%v)\n", pid, signalName(sig), sig)
+	d.stopSig = sig
+	return "signal", true, nil
+}
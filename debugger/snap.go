@@ -0,0 +1,147 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/gosym"
+	"slices"
+	"sort"
+)
+
+// valueSnap is the values of expressions at a stop, kept under a name by
+// "snap" to be compared with those at another.
+type valueSnap struct {
+	stop   int // the number of the stop in the session, from 1
+	fn     string
+	file   string
+	line   int
+	exprs  []string
+	leaves []valueLeaf // those of each expression, at paths from it
+}
+
+// snapExprs returns the expressions a snap takes: the displays, the
+// expressions of data watches and extra, each once.
+func (d *Debugger) snapExprs(extra []string) []string {
+	exprs := slices.Clone(d.displays)
+	for _, w := range d.DataWatches {
+		if w.typ != nil {
+			exprs = append(exprs, w.Expr)
+		}
+	}
+	exprs = append(exprs, extra...)
+	var once []string
+	for _, expr := range exprs {
+		if !slices.Contains(once, expr) {
+			once = append(once, expr)
+		}
+	}
+	return once
+}
+
+// takeSnap evaluates exprs now. Values that can't be read, such as locals
+// out of scope, are left out.
+func (d *Debugger) takeSnap(pid int, exprs []string) *valueSnap {
+	s := &valueSnap{stop: d.historyStops, exprs: exprs}
+	if d.SymTable != nil {
+		var fn *gosym.Func
+		s.file, s.line, fn = d.SymTable.PCToLine(d.Regs.Rip)
+		if fn != nil {
+			s.fn = fn.Name
+		}
+	}
+	for _, expr := range exprs {
+		leaves, err := d.valueLeaves(pid, expr)
+		if err != nil {
+			d.printf("  %s: %v\n", expr, err)
+			continue
+		}
+		for _, l := range leaves {
+			s.leaves = append(s.leaves, valueLeaf{expr + l.path, l.value})
+		}
+	}
+	return s
+}
+
+// snapCommand handles "snap <name> [<expr>...]", which keeps the values of
+// the displays, the watched expressions and exprs under name, "snap diff
+// <name1> [<name2>]", which shows how the values of the second snap, or
+// those now, differ from the first, "snap show <name>", "snap delete
+// <name>" and "snap", which lists the snaps.
+func (d *Debugger) snapCommand(pid int, args []string) bool {
+	const usage = "usage: snap [<name> [<expr>...] | diff <name> [<name>] | show <name> | delete <name>]"
+	if len(args) == 0 {
+		if len(d.snaps) == 0 {
+			d.println("No snaps")
+			return false
+		}
+		names := make([]string, 0, len(d.snaps))
+		for name := range d.snaps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			s := d.snaps[name]
+			d.printf("  %s: %d expressions at %s:%d in %s, stop %d\n", name, len(s.exprs), s.file, s.line, s.fn, s.stop)
+		}
+		return false
+	}
+
+	switch args[0] {
+	case "diff":
+		if len(args) != 2 && len(args) != 3 {
+			d.println(usage)
+			return false
+		}
+		a := d.snaps[args[1]]
+		if a == nil {
+			d.printf("No snap %s\n", args[1])
+			return false
+		}
+		var b *valueSnap
+		if len(args) == 3 {
+			if b = d.snaps[args[2]]; b == nil {
+				d.printf("No snap %s\n", args[2])
+				return false
+			}
+		} else {
+			b = d.takeSnap(pid, a.exprs)
+		}
+		d.showDiff(a.leaves, b.leaves)
+	case "show":
+		if len(args) != 2 {
+			d.println(usage)
+			return false
+		}
+		s := d.snaps[args[1]]
+		if s == nil {
+			d.printf("No snap %s\n", args[1])
+			return false
+		}
+		d.printf("%s at %s:%d in %s, stop %d\n", args[1], s.file, s.line, s.fn, s.stop)
+		for _, l := range s.leaves {
+			d.printf("  %s: %s\n", l.path, l.value)
+		}
+	case "delete":
+		if len(args) != 2 {
+			d.println(usage)
+			return false
+		}
+		if d.snaps[args[1]] == nil {
+			d.printf("No snap %s\n", args[1])
+			return false
+		}
+		delete(d.snaps, args[1])
+	default:
+		exprs := d.snapExprs(args[1:])
+		if len(exprs) == 0 {
+			d.println("Nothing to snap; display or watch expressions, or name them after the snap")
+			return false
+		}
+		if d.snaps == nil {
+			d.snaps = make(map[string]*valueSnap)
+		}
+		d.snaps[args[0]] = d.takeSnap(pid, exprs)
+		d.printf("Snap %s of %d expressions\n", args[0], len(exprs))
+	}
+	return false
+}
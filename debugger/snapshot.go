@@ -0,0 +1,496 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ProcessSnapshot is the state of a running process captured by
+// TakeSnapshot: the stacks of its goroutines and the values of some globals.
+type ProcessSnapshot struct {
+	Pid        int                 `json:"pid"`
+	Exe        string              `json:"exe"`
+	Time       time.Time           `json:"time"`
+	Pause      time.Duration       `json:"pause"`
+	Threads    int                 `json:"threads"`
+	Goroutines []SnapshotGoroutine `json:"goroutines"`
+	Globals    []SnapshotGlobal    `json:"globals,omitempty"`
+}
+
+// SnapshotGoroutine is a goroutine of a snapshot. Thread is the thread it
+// was running on, if any.
+type SnapshotGoroutine struct {
+	ID         uint64  `json:"id"`
+	Status     string  `json:"status"`
+	WaitReason string  `json:"wait_reason,omitempty"`
+	Thread     int     `json:"thread,omitempty"`
+	Frames     []Frame `json:"frames"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// SnapshotGlobal is the value of an expression in a snapshot, or why it
+// couldn't be read.
+type SnapshotGlobal struct {
+	Expr  string `json:"expr"`
+	Value string `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// TakeSnapshot stops every thread of the running process pid, captures the
+// stacks of all goroutines and the values of exprs, and detaches, letting the
+// process run on. The symbols are loaded before the process is stopped and
+// nothing is written to it, so the pause lasts only as long as the reads.
+// Further snapshots of the same process reuse the symbols.
+func (d *Debugger) TakeSnapshot(pid int, exprs []string) (*ProcessSnapshot, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	exe := fmt.Sprintf("/proc/%d/exe", pid)
+	target, err := os.Readlink(exe)
+	if err != nil {
+		return nil, fmt.Errorf("can't find the executable of %d: %v", pid, err)
+	}
+	if d.SymTable == nil || d.target != target || d.Pid != pid {
+		d.target = target
+		if d.SymTable, err = d.GetSymbolTable(exe); err != nil {
+			return nil, err
+		}
+		if err := d.relocate(pid); err != nil {
+			return nil, err
+		}
+	}
+	// Look up the variables while the process still runs; the lookups are
+	// remembered.
+	d.LookupGlobal("runtime.allgs")
+	for _, expr := range exprs {
+		d.evalValue(pid, expr)
+	}
+
+	start := time.Now()
+	tids, err := d.attachThreads(pid)
+	if err != nil {
+		return nil, err
+	}
+	d.Pid = pid
+	s := &ProcessSnapshot{Pid: pid, Exe: target, Time: start, Threads: len(tids)}
+	err = d.capture(s, tids, exprs)
+	for _, tid := range tids {
+		syscall.PtraceDetach(tid)
+	}
+	s.Pause = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// capture fills in s from the process d.Pid, whose threads tids are stopped.
+func (d *Debugger) capture(s *ProcessSnapshot, tids []int, exprs []string) error {
+	pid := d.Pid
+	// Goroutines running on a thread are located by its registers.
+	stopped := make(map[uint64]*syscall.PtraceRegs)
+	threadOf := make(map[*syscall.PtraceRegs]int)
+	for _, tid := range tids {
+		regs := new(syscall.PtraceRegs)
+		if tid == pid {
+			regs = &d.Regs
+		}
+		if err := syscall.PtraceGetRegs(tid, regs); err != nil {
+			return wrapPtrace("reading registers of", tid, err)
+		}
+		stopped[d.currentG(tid, regs)], threadOf[regs] = regs, tid
+	}
+	gs, err := d.goroutines(pid, stopped)
+	if err != nil {
+		return err
+	}
+	stacks, errs := d.GoroutineStacks(pid, gs, maxGoroutineFrames)
+	for i, g := range gs {
+		sg := SnapshotGoroutine{ID: g.ID, Status: g.Status, WaitReason: g.WaitReason, Frames: stacks[i]}
+		for regs, tid := range threadOf {
+			if g.PC == regs.Rip && g.SP == regs.Rsp {
+				sg.Thread = tid
+			}
+		}
+		if errs[i] != nil {
+			sg.Error = errs[i].Error()
+		}
+		s.Goroutines = append(s.Goroutines, sg)
+	}
+	for _, expr := range exprs {
+		sg := SnapshotGlobal{Expr: expr}
+		if val, err := d.evalValue(pid, expr); err != nil {
+			sg.Error = err.Error()
+		} else {
+			sg.Value = d.formatValue(pid, val.typ, val.buf, 0)
+		}
+		s.Globals = append(s.Globals, sg)
+	}
+	return nil
+}
+
+// Save writes s to path as JSON.
+func (s *ProcessSnapshot) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadSnapshot reads a snapshot written by Save.
+func LoadSnapshot(path string) (*ProcessSnapshot, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s ProcessSnapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// Snapshot implements "snapshot [-o file] [-global expr]... [-every d
+// [-count n]] [-force] [-artifacts store] <pid>", which captures a
+// snapshot of the running process pid, or a series of them and the
+// goroutines stuck across it, and "snapshot <file>...", which browses one
+// or compares a series. It returns the exit code.
+func Snapshot(args []string) int {
+	const usage = "usage: snapshot [-o file] [-global expr]... [-every d [-count n]] [-force] [-artifacts store] <pid> | snapshot <file>..."
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	out := flags.String("o", "", "write the snapshot to `file` (default <pid>.ddbsnap)")
+	var globals []string
+	flags.Func("global", "capture the value of `expr`, a package-level variable (repeatable)", func(expr string) error {
+		globals = append(globals, expr)
+		return nil
+	})
+	every := flags.Duration("every", 0, "take a series of snapshots `interval` apart and report the goroutines stuck across them")
+	count := flags.Int("count", 10, "the number of snapshots of a series")
+	force := flags.Bool("force", false, "take the snapshot even if the process fails the safety checks")
+	artifacts := flags.String("artifacts", "", "keep a copy of the snapshots in `store`: a directory, s3://bucket/prefix or gs://bucket/prefix")
+	flags.Parse(args)
+	if flags.NArg() < 1 || *every < 0 || *count < 1 {
+		fmt.Println(usage)
+		flags.PrintDefaults()
+		return 2
+	}
+
+	pid, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		var snaps []*ProcessSnapshot
+		for _, path := range flags.Args() {
+			s, err := LoadSnapshot(path)
+			if err != nil {
+				fmt.Println(err)
+				return 1
+			}
+			snaps = append(snaps, s)
+		}
+		if len(snaps) == 1 {
+			snaps[0].Browse(os.Stdin, os.Stdout)
+		} else {
+			WriteSnapshotDiff(os.Stdout, snaps)
+		}
+		return 0
+	}
+	if flags.NArg() != 1 {
+		fmt.Println(usage)
+		return 2
+	}
+
+	report, err := CheckAttach(pid)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if len(report.Problems) > 0 {
+		fmt.Print(report)
+		if !*force {
+			fmt.Println("Not attaching; -force attaches anyway")
+			return 1
+		}
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("%d.ddbsnap", pid)
+	}
+	if *every == 0 {
+		*count = 1
+	}
+	d := NewDebugger()
+	if *artifacts != "" {
+		if d.Artifacts, err = OpenArtifactStore(*artifacts); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+	}
+	var snaps []*ProcessSnapshot
+	for i := 1; i <= *count; i++ {
+		if i > 1 {
+			time.Sleep(*every)
+		}
+		s, err := d.TakeSnapshot(pid, globals)
+		if err != nil {
+			fmt.Println(err)
+			if len(snaps) == 0 {
+				return 1
+			}
+			break // the process exited, say
+		}
+		path := *out
+		if *every > 0 {
+			path = seriesPath(*out, i)
+		}
+		if err := s.Save(path); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		fmt.Printf("Stopped %d threads of %d for %v; wrote %d goroutines to %s\n", s.Threads, pid, s.Pause.Round(time.Microsecond), len(s.Goroutines), path)
+		d.storeArtifact(path)
+		snaps = append(snaps, s)
+	}
+	if len(snaps) > 1 {
+		fmt.Println()
+		WriteSnapshotDiff(os.Stdout, snaps)
+	}
+	return 0
+}
+
+// seriesPath names snapshot i of a series written to path: "1234.ddbsnap"
+// becomes "1234-3.ddbsnap".
+func seriesPath(path string, i int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), i, ext)
+}
+
+// StuckGoroutines returns the goroutines of the last of snaps, taken in
+// order of time, that were in every one of them with the same status, wait
+// reason and stack: goroutines making no progress, such as ones blocked on a
+// channel or a lock nobody releases. The runtime's own goroutines, idle
+// most of the time, are left out.
+func StuckGoroutines(snaps []*ProcessSnapshot) []SnapshotGoroutine {
+	if len(snaps) < 2 {
+		return nil
+	}
+	var stuck []SnapshotGoroutine
+	for _, g := range snaps[len(snaps)-1].Goroutines {
+		if f, ok := g.userFrame(); !ok || isRuntimeFunc(f.Func) {
+			continue
+		}
+		key := g.stackKey()
+		same := true
+		for _, s := range snaps[:len(snaps)-1] {
+			if !same {
+				break
+			}
+			prev := s.goroutine(g.ID)
+			same = prev != nil && prev.Status == g.Status && prev.WaitReason == g.WaitReason && prev.stackKey() == key
+		}
+		if same {
+			stuck = append(stuck, g)
+		}
+	}
+	return stuck
+}
+
+// WriteSnapshotDiff compares a series of snapshots of a process: how many
+// goroutines started and exited along it, and which stayed stuck in the
+// same place throughout, grouped by where.
+func WriteSnapshotDiff(out io.Writer, snaps []*ProcessSnapshot) {
+	first, last := snaps[0], snaps[len(snaps)-1]
+	span := last.Time.Sub(first.Time).Round(time.Millisecond)
+	seen := make(map[uint64]bool)
+	for _, s := range snaps {
+		for _, g := range s.Goroutines {
+			seen[g.ID] = true
+		}
+	}
+	started, exited := 0, 0
+	for id := range seen {
+		if first.goroutine(id) == nil {
+			started++
+		}
+		if last.goroutine(id) == nil {
+			exited++
+		}
+	}
+	fmt.Fprintf(out, "%d snapshots of %d over %v: %d goroutines, %d started and %d exited meanwhile\n",
+		len(snaps), first.Pid, span, len(seen), started, exited)
+
+	stuck := StuckGoroutines(snaps)
+	if len(stuck) == 0 {
+		fmt.Fprintln(out, "No goroutine stayed in the same place in every snapshot")
+		return
+	}
+	fmt.Fprintf(out, "%d goroutines stayed in the same place for %v:\n", len(stuck), span)
+	(&ProcessSnapshot{Goroutines: stuck}).writeStacks(out)
+}
+
+// stackKey identifies the stack of g by its functions and lines.
+func (g *SnapshotGoroutine) stackKey() string {
+	var key strings.Builder
+	for _, f := range g.Frames {
+		fmt.Fprintf(&key, "%s:%d\n", f.Func, f.Line)
+	}
+	return key.String()
+}
+
+// Browse reads commands from in and answers them from the snapshot:
+//
+//	goroutines        list the goroutines and where they are
+//	goroutine <id>    show the stack of a goroutine
+//	stacks            group the goroutines by stack, most common first
+//	globals           show the captured values
+//	print <expr>      show a captured value
+//	q                 quit
+func (s *ProcessSnapshot) Browse(in io.Reader, out io.Writer) {
+	fmt.Fprintf(out, "Snapshot of %d (%s) at %s: %d goroutines, %d threads stopped for %v\n",
+		s.Pid, s.Exe, s.Time.Format(time.DateTime), len(s.Goroutines), s.Threads, s.Pause.Round(time.Microsecond))
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "\n(snapshot) > ")
+		if !scanner.Scan() {
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch cmd, args := strings.ToLower(fields[0]), fields[1:]; {
+		case cmd == "q" || cmd == "quit":
+			return
+		case cmd == "goroutines":
+			for _, g := range s.Goroutines {
+				fmt.Fprintf(out, "  Goroutine %d %s%s", g.ID, g.state(), g.thread())
+				if f, ok := g.userFrame(); ok {
+					fmt.Fprintf(out, " at %s", f)
+				}
+				fmt.Fprintln(out)
+			}
+		case cmd == "goroutine" && len(args) == 1:
+			id, _ := strconv.ParseUint(args[0], 10, 64)
+			g := s.goroutine(id)
+			if g == nil {
+				fmt.Fprintf(out, "No goroutine %s\n", args[0])
+				break
+			}
+			fmt.Fprintf(out, "Goroutine %d %s%s:\n", g.ID, g.state(), g.thread())
+			writeFrames(out, g.Frames)
+			if g.Error != "" {
+				fmt.Fprintln(out, " ", g.Error)
+			}
+		case cmd == "stacks":
+			s.writeStacks(out)
+		case cmd == "globals":
+			for _, g := range s.Globals {
+				fmt.Fprintln(out, g)
+			}
+		case cmd == "print" && len(args) == 1:
+			found := false
+			for _, g := range s.Globals {
+				if g.Expr == args[0] || g.Expr == "main."+args[0] {
+					fmt.Fprintln(out, g)
+					found = true
+				}
+			}
+			if !found {
+				fmt.Fprintf(out, "%s wasn't captured; take the snapshot with -global %s\n", args[0], args[0])
+			}
+		default:
+			fmt.Fprintln(out, "commands: goroutines, goroutine <id>, stacks, globals, print <expr>, q")
+		}
+	}
+}
+
+func (g SnapshotGlobal) String() string {
+	if g.Error != "" {
+		return fmt.Sprintf("%s: %s", g.Expr, g.Error)
+	}
+	return fmt.Sprintf("%s = %s", g.Expr, g.Value)
+}
+
+func (s *ProcessSnapshot) goroutine(id uint64) *SnapshotGoroutine {
+	for i := range s.Goroutines {
+		if s.Goroutines[i].ID == id {
+			return &s.Goroutines[i]
+		}
+	}
+	return nil
+}
+
+func (g *SnapshotGoroutine) state() string {
+	return Goroutine{Status: g.Status, WaitReason: g.WaitReason}.state()
+}
+
+func (g *SnapshotGoroutine) thread() string {
+	if g.Thread == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" on thread %d", g.Thread)
+}
+
+// userFrame returns the innermost frame of g outside the runtime, or its
+// innermost frame if all are in the runtime.
+func (g *SnapshotGoroutine) userFrame() (Frame, bool) {
+	for _, f := range g.Frames {
+		if !isRuntimeFunc(f.Func) {
+			return f, true
+		}
+	}
+	if len(g.Frames) == 0 {
+		return Frame{}, false
+	}
+	return g.Frames[0], true
+}
+
+func writeFrames(out io.Writer, frames []Frame) {
+	for i, f := range frames {
+		fmt.Fprintf(out, "  #%d %s\n", i, f)
+	}
+}
+
+// writeStacks writes the distinct stacks of the goroutines, each with the
+// goroutines having it, the most common first.
+func (s *ProcessSnapshot) writeStacks(out io.Writer) {
+	type stack struct {
+		frames []Frame
+		ids    []string
+	}
+	var stacks []*stack
+	byKey := make(map[string]*stack)
+	for _, g := range s.Goroutines {
+		key := g.stackKey()
+		st := byKey[key]
+		if st == nil {
+			st = &stack{frames: g.Frames}
+			byKey[key] = st
+			stacks = append(stacks, st)
+		}
+		st.ids = append(st.ids, strconv.FormatUint(g.ID, 10))
+	}
+	sort.SliceStable(stacks, func(i, j int) bool { return len(stacks[i].ids) > len(stacks[j].ids) })
+	for _, st := range stacks {
+		fmt.Fprintf(out, "%d goroutines: %s\n", len(st.ids), strings.Join(st.ids, " "))
+		writeFrames(out, st.frames)
+	}
+}
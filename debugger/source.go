@@ -0,0 +1,161 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sourceFile is a source file read for display, or why it couldn't be.
+type sourceFile struct {
+	lines []string
+	err   error
+}
+
+// sourcePath returns where the source file compiled at path is now. The
+// path itself is tried first, then the file of the target's module in the
+// checkout of it found here, then its trailing elements under SourceRoot,
+// longest first, so a root holding a checkout of the module, of its parent
+// or of the file's package finds it.
+func (d *Debugger) sourcePath(path string) (string, error) {
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if p := d.moduleSource(path); p != "" {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	if d.SourceRoot == "" {
+		return "", fmt.Errorf("%s not found, set a source root to find it elsewhere", path)
+	}
+	elems := strings.Split(strings.TrimPrefix(filepath.ToSlash(path), "/"), "/")
+	for i := range elems {
+		p := filepath.Join(d.SourceRoot, filepath.Join(elems[i:]...))
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found under %s", path, d.SourceRoot)
+}
+
+// sourceLines returns the lines of the source file compiled at path. Files
+// are read once a session, failures included.
+func (d *Debugger) sourceLines(path string) ([]string, error) {
+	if f, ok := d.sources[path]; ok {
+		return f.lines, f.err
+	}
+	var f sourceFile
+	p, err := d.sourcePath(path)
+	if err == nil {
+		var b []byte
+		if b, err = os.ReadFile(p); err == nil {
+			f.lines = strings.Split(string(bytes.TrimSuffix(b, []byte("\n"))), "\n")
+		}
+	}
+	f.err = err
+	if d.sources == nil {
+		d.sources = make(map[string]sourceFile)
+	}
+	d.sources[path] = f
+	return f.lines, f.err
+}
+
+// writeSource writes lines from to to of the source file compiled at path,
+// marking line mark and ending lines with their notes, if any. It returns
+// the last line written.
+func (d *Debugger) writeSource(b *strings.Builder, path string, from, to, mark int, notes map[int]string) (int, error) {
+	lines, err := d.sourceLines(path)
+	if err != nil {
+		return 0, err
+	}
+	from, to = max(from, 1), min(to, len(lines))
+	if from > to {
+		return 0, fmt.Errorf("%s has only %d lines", path, len(lines))
+	}
+	for n := from; n <= to; n++ {
+		prefix := "  "
+		if n == mark {
+			prefix = "=>"
+		}
+		fmt.Fprintf(b, "%s %5d\t%s", prefix, n, lines[n-1])
+		if note := notes[n]; note != "" {
+			fmt.Fprintf(b, "  // %s", note)
+		}
+		b.WriteByte('\n')
+	}
+	return to, nil
+}
+
+// showContext shows the ContextLines lines around the stop of the thread
+// pid at d.Regs, if its source can be read, with InlineValues the values
+// of the variables they name, and with Blame who last changed the stop
+// line.
+func (d *Debugger) showContext(pid int) {
+	d.listFile = ""
+	file, line, _ := d.pcToLine(d.Regs.Rip)
+	if file == "" {
+		return
+	}
+	var b strings.Builder
+	if d.ContextLines > 0 {
+		if last, err := d.writeSource(&b, file, line-d.ContextLines, line+d.ContextLines, line, d.inlineValues(pid, file)); err == nil {
+			d.listFile, d.listLine = file, last
+		}
+	}
+	if d.Blame {
+		if bl, err := d.blame(file, line); err == nil {
+			fmt.Fprintf(&b, "   blame: %s\n", bl)
+		}
+	}
+	if b.Len() > 0 {
+		d.UI.ShowOutput(b.String())
+	}
+}
+
+// listLines is how many lines "list" shows.
+const listLines = 10
+
+// listCommand handles "list [<location>]", which shows source lines around
+// the location, given as to break, or around the stop. A list without
+// location after another, or after the context of a stop, shows the lines
+// that follow.
+func (d *Debugger) listCommand(pid int, args []string) bool {
+	if len(args) > 1 {
+		d.println("usage: list [[file:]line|function]")
+		return false
+	}
+	file, from := d.listFile, d.listLine+1
+	stopFile, stopLine, _ := d.pcToLine(d.Regs.Rip)
+	if len(args) == 1 {
+		f, line, err := d.parseLocation(args[0])
+		if err != nil {
+			d.println(err)
+			return false
+		}
+		file, from = f, line-listLines/2
+	} else if file == "" {
+		file, from = stopFile, stopLine-listLines/2
+	}
+	if file == "" {
+		d.println("No source to list")
+		return false
+	}
+	mark := 0
+	if file == stopFile {
+		mark = stopLine
+	}
+	var b strings.Builder
+	last, err := d.writeSource(&b, file, from, from+listLines-1, mark, d.inlineValues(pid, file))
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	d.UI.ShowOutput(b.String())
+	d.listFile, d.listLine = file, last
+	return false
+}
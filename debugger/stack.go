@@ -0,0 +1,213 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Frame is one frame of a call stack: where it runs, with the PC of a
+// caller being its return address, its stack pointer and its frame
+// pointer, 0 where the frame has none or the unwinding couldn't tell, and
+// the goroutine whose stack it is, 0 if unknown.
+type Frame struct {
+	Location
+	SP        uint64 `json:",omitempty"`
+	FP        uint64 `json:",omitempty"`
+	Goroutine uint64 `json:",omitempty"`
+}
+
+// Stacktrace is a call stack: its frames, innermost first, the goroutine
+// whose stack it is, 0 if unknown, and the thread running it, 0 for a
+// goroutine that isn't running.
+type Stacktrace struct {
+	Goroutine uint64
+	Thread    int
+	Frames    []Frame
+}
+
+// ThreadStack returns at most depth frames of the stack of the thread pid,
+// stopped at d.Regs, with the goroutine it runs.
+func (d *Debugger) ThreadStack(pid, depth int) (Stacktrace, error) {
+	st := Stacktrace{Goroutine: d.currentGoid(pid), Thread: pid}
+	var err error
+	st.Frames, err = d.Stacktrace(pid, &d.Regs, depth)
+	for i := range st.Frames {
+		st.Frames[i].Goroutine = st.Goroutine
+	}
+	return st, err
+}
+
+// frameAt describes the frame executing at pc. For return addresses the
+// line of the call instruction is reported rather than the next one.
+func (d *Debugger) frameAt(pc uint64, ret bool) Frame {
+	lookup := pc
+	if ret {
+		lookup--
+	}
+	l := d.LookupPC(lookup)
+	l.PC = pc
+	return Frame{Location: l}
+}
+
+// Stacktrace walks the frame pointer chain of the stopped thread pid from
+// regs and returns at most depth frames, innermost first.
+func (d *Debugger) Stacktrace(pid int, regs *syscall.PtraceRegs, depth int) ([]Frame, error) {
+	frames, frameRegs, err := d.walkFrames(pid, regs, depth)
+	for i, r := range frameRegs {
+		frames[i].SP, frames[i].FP = r.Rsp, r.Rbp
+	}
+	return frames, err
+}
+
+// walkFrames is Stacktrace with the registers of each frame: all of regs
+// for the innermost, the PC, stack and frame pointers for its callers,
+// which is enough to locate their variables on the stack.
+func (d *Debugger) walkFrames(pid int, regs *syscall.PtraceRegs, depth int) ([]Frame, []syscall.PtraceRegs, error) {
+	frames := []Frame{d.frameAt(regs.Rip, false)}
+	frameRegs := []syscall.PtraceRegs{*regs}
+	buf := make([]byte, 16)
+
+	// On a function's first instruction the frame pointer hasn't been
+	// pushed yet and the return address is on top of the stack.
+	if fn := d.pcToFunc(regs.Rip); fn != nil && fn.Entry == regs.Rip {
+		if err := d.ReadMemory(pid, regs.Rsp, buf[:8]); err != nil {
+			return frames, frameRegs, err
+		}
+		// At the entry point of the program the top of the stack is argc,
+		// not a return address.
+		if ret := binary.LittleEndian.Uint64(buf); d.pcToFunc(ret) != nil {
+			frames = append(frames, d.frameAt(ret, true))
+			frameRegs = append(frameRegs, syscall.PtraceRegs{Rip: ret, Rsp: regs.Rsp + 8, Rbp: regs.Rbp})
+		}
+	}
+
+	bp := regs.Rbp
+	for len(frames) < depth && bp != 0 {
+		if err := d.ReadMemory(pid, bp, buf); err != nil {
+			return frames, frameRegs, err
+		}
+		next := binary.LittleEndian.Uint64(buf[:8])
+		ret := binary.LittleEndian.Uint64(buf[8:])
+		if ret == 0 {
+			break
+		}
+		frames = append(frames, d.frameAt(ret, true))
+		frameRegs = append(frameRegs, syscall.PtraceRegs{Rip: ret, Rsp: bp + 16, Rbp: next})
+
+		// The stack grows down, so callers' frames are at higher addresses.
+		if next <= bp {
+			break
+		}
+		bp = next
+	}
+	return frames, frameRegs, nil
+}
+
+// maxStackDepth bounds the frames stackDepth counts, which is enough to
+// tell runaway recursion from the rest.
+const maxStackDepth = 1 << 20
+
+// stackDepth counts the frames on the stack of the thread pid, stopped at
+// regs, as walkFrames finds them but without looking up their functions,
+// which is cheap enough for a condition checked at every hit.
+func (d *Debugger) stackDepth(pid int, regs *syscall.PtraceRegs) int {
+	depth := 1
+	buf := make([]byte, 16)
+	if fn := d.pcToFunc(regs.Rip); fn != nil && fn.Entry == regs.Rip {
+		if d.ReadMemory(pid, regs.Rsp, buf[:8]) == nil && d.pcToFunc(binary.LittleEndian.Uint64(buf)) != nil {
+			depth++
+		}
+	}
+	for bp := regs.Rbp; bp != 0 && depth < maxStackDepth; {
+		if d.ReadMemory(pid, bp, buf) != nil {
+			break
+		}
+		next := binary.LittleEndian.Uint64(buf[:8])
+		if binary.LittleEndian.Uint64(buf[8:]) == 0 {
+			break
+		}
+		depth++
+		if next <= bp {
+			break
+		}
+		bp = next
+	}
+	return depth
+}
+
+// calledFrom reports whether the function the thread pid, stopped at
+// d.Regs, is in was called by fn, or by a closure of fn. A caller that is
+// a compiler-generated wrapper, such as a method value's, is passed over
+// for its own caller. Functions of package main can be named without
+// "main.".
+func (d *Debugger) calledFrom(pid int, fn string) bool {
+	if !strings.Contains(fn, ".") {
+		fn = "main." + fn
+	}
+	frames, _, _ := d.walkFrames(pid, &d.Regs, 3)
+	for _, f := range frames[1:] {
+		if f.Func == fn || strings.HasPrefix(f.Func, fn+".func") {
+			return true
+		}
+		if !strings.HasSuffix(f.Func, "-fm") && !strings.HasPrefix(f.File, "<autogenerated>") {
+			break
+		}
+	}
+	return false
+}
+
+// backtraceCommand handles "backtrace [-folded] [n]", or "bt", which prints
+// the call stack of the stopped thread, at most n frames of it. With
+// -folded, the stack is a line of folded stacks, as flame graph tools read.
+func (d *Debugger) backtraceCommand(pid int, args []string) bool {
+	depth := maxGoroutineFrames
+	folded := len(args) > 0 && args[0] == "-folded"
+	if folded {
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		d.println("usage: backtrace [-folded] [n]")
+		return false
+	}
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			d.printf("bad frame count %q\n", args[0])
+			return false
+		}
+		depth = n
+	}
+	frames, err := d.Stacktrace(pid, &d.Regs, depth)
+	var b strings.Builder
+	if folded {
+		fmt.Fprintf(&b, "%s 1\n", foldedStack(frames))
+	} else {
+		for i, f := range frames {
+			fmt.Fprintf(&b, "  #%d %s\n", i, f)
+		}
+	}
+	d.UI.ShowOutput(b.String())
+	if err != nil {
+		d.println(" ", err)
+	}
+	return false
+}
+
+// foldedStack renders frames, innermost first, as a folded stack: the
+// functions from the outermost, separated by semicolons.
+func foldedStack(frames []Frame) string {
+	names := make([]string, len(frames))
+	for i, f := range frames {
+		name := f.Func
+		if name == "" {
+			name = fmt.Sprintf("%#x", f.PC)
+		}
+		names[len(frames)-1-i] = name
+	}
+	return strings.Join(names, ";")
+}
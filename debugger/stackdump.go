@@ -0,0 +1,128 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"cmp"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// stackdumpCommand handles "stackdump", which prints the current frame slot
+// by slot, from the stack pointer up to the canonical frame address, with
+// what each 8-byte slot holds: the return address, the caller's saved frame
+// pointer or the variables stored there. Arguments spilled to the caller's
+// frame, just above the CFA, are shown after it.
+func (d *Debugger) stackdumpCommand(pid int, args []string) bool {
+	if len(args) != 0 {
+		d.println("usage: stackdump")
+		return false
+	}
+	pc, sp := d.Regs.Rip, d.Regs.Rsp
+	cfa, err := d.cfa(pc, &d.Regs)
+	if err != nil {
+		d.printf("Can't find the current frame: %v\n", err)
+		return false
+	}
+	if cfa <= sp || cfa-sp > maxValueSize {
+		d.printf("Strange frame: SP %#x, CFA %#x\n", sp, cfa)
+		return false
+	}
+	labels := d.stackSlotLabels(pid, pc, sp, cfa+maxSpillArea)
+	top := cfa
+	for addr := range labels {
+		top = max(top, addr+8)
+	}
+	buf := make([]byte, top-sp)
+	if err := d.ReadMemory(pid, sp, buf); err != nil {
+		d.println(err)
+		return false
+	}
+
+	// Go functions with a frame push the caller's frame pointer under the
+	// return address and point RBP at it.
+	if d.Regs.Rbp == cfa-16 && cfa-16 >= sp {
+		labels[cfa-16] = append(labels[cfa-16], "saved BP")
+	}
+	ret := bufUint(buf[cfa-8-sp:], 8)
+	to := unknownPC(ret)
+	if f := d.frameAt(ret, true); f.Func != "" {
+		to = fmt.Sprintf("%s line %d", f.Func, f.Line)
+	}
+	labels[cfa-8] = append(labels[cfa-8], "return to "+to)
+
+	var b strings.Builder
+	where := unknownPC(pc)
+	if l := d.LookupPC(pc); l.Known() {
+		where = fmt.Sprintf("%s at %d in %s", l.Func, l.Line, l.File)
+	}
+	fmt.Fprintf(&b, "Frame of %s, SP %#x, CFA %#x\n", where, sp, cfa)
+	for addr := sp; addr+8 <= top; addr += 8 {
+		if addr == cfa {
+			b.WriteString("  -- caller's frame --\n")
+		}
+		fmt.Fprintf(&b, "  %#016x: %#016x", addr, bufUint(buf[addr-sp:], 8))
+		if l := labels[addr]; len(l) > 0 {
+			fmt.Fprintf(&b, "  %s", strings.Join(l, ", "))
+		}
+		b.WriteByte('\n')
+	}
+	d.UI.ShowOutput(b.String())
+	return false
+}
+
+// maxSpillArea bounds how far above the CFA argument slots are looked for.
+const maxSpillArea = 256
+
+// stackSlotLabels names the slots of the stack in [lo, hi) that hold the
+// arguments and locals in scope at pc. A variable spanning several slots
+// labels the later ones with its name and their offset in it.
+func (d *Debugger) stackSlotLabels(pid int, pc, lo, hi uint64) map[uint64][]string {
+	labels := make(map[uint64][]string)
+	vars, err := d.Variables(pc)
+	if err != nil {
+		return labels
+	}
+	sort.SliceStable(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	for _, v := range vars {
+		if strings.HasPrefix(v.Name, "~") {
+			continue
+		}
+		expr, err := d.locationExpr(v, pc)
+		if err != nil {
+			continue
+		}
+		frame := &exprFrame{d: d, pid: pid, regs: &d.Regs, fn: v.fn}
+		pieces, err := frame.eval(expr)
+		if err != nil {
+			continue
+		}
+		// The slot of a variable on the heap holds the pointer to it.
+		name, size := v.Name, v.Type.Size()
+		if v.heap {
+			name, size = "&"+v.Name, 8
+		}
+		var off uint64 // of the piece in the variable
+		for _, p := range pieces {
+			size := cmp.Or(p.size, size)
+			if size <= 0 {
+				continue
+			}
+			if p.kind == pieceMemory {
+				for addr := p.addr; addr < p.addr+uint64(size); addr = addr&^7 + 8 {
+					if addr < lo || addr >= hi {
+						continue
+					}
+					label := name
+					if n := off + addr - p.addr; n > 0 {
+						label = fmt.Sprintf("%s+%d", name, n)
+					}
+					labels[addr&^7] = append(labels[addr&^7], label)
+				}
+			}
+			off += uint64(size)
+		}
+	}
+	return labels
+}
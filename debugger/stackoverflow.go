@@ -0,0 +1,245 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// stackOverflowMsg is the message runtime.newstack throws with when a
+// goroutine's stack would grow past its limit.
+const stackOverflowMsg = "stack overflow"
+
+// maxCyclePeriod bounds the frames of a cycle of recursive calls, and
+// maxCycleStart the frames above the cycle, which diagnoseOverflow looks
+// for among the innermost maxCycleHead frames.
+const (
+	maxCyclePeriod = 16
+	maxCycleStart  = 64
+	maxCycleHead   = 1024
+)
+
+// maxOverflowTail bounds the frames printed below a cycle.
+const maxOverflowTail = 32
+
+// diagnoseOverflow prints the stack of the goroutine whose stack overflowed,
+// the one the thread pid, stopped in runtime.throw on its system stack,
+// runs. The calls that repeat are printed once, with their count.
+func (d *Debugger) diagnoseOverflow(pid int) error {
+	g, err := d.overflowedG(pid, d.currentG(pid, &d.Regs))
+	if err != nil {
+		return err
+	}
+	w := &fpWalker{d: d, pid: pid, pc: g.pc, sp: g.sp, bp: g.bp, hi: g.hi}
+
+	var head []uint64
+	for len(head) < maxCycleHead {
+		pc, ok := w.next()
+		if !ok {
+			break
+		}
+		head = append(head, pc)
+	}
+	start, period, n := findCycle(head)
+
+	// The cycle goes on past the head until the first frame that breaks
+	// it; the frames after it are the calls that started the recursion.
+	var tail []uint64
+	total := len(head)
+	if period > 0 {
+		tail = head[start+n:]
+		for len(tail) == 0 {
+			pc, ok := w.next()
+			if !ok {
+				break
+			}
+			total++
+			if pc != head[start+n%period] {
+				tail = append(tail, pc)
+				break
+			}
+			n++
+		}
+	} else {
+		start, tail = len(head), nil
+	}
+	for len(tail) < maxOverflowTail {
+		pc, ok := w.next()
+		if !ok {
+			break
+		}
+		total++
+		tail = append(tail, pc)
+	}
+	for _, ok := w.next(); ok; _, ok = w.next() {
+		total++
+	}
+	// A partial turn of the cycle belongs with the frames below it.
+	if period > 0 {
+		reps := n / period
+		part := start + n%period
+		tail = append(head[start:part:part], tail...)
+		n = reps * period
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Stack overflow in goroutine %d, %d frames deep", g.id, total)
+	if w.err != nil {
+		fmt.Fprintf(&b, " or more (%v)", w.err)
+	}
+	b.WriteString(":\n")
+	i := 0
+	frame := func(indent string, pc uint64) {
+		fmt.Fprintf(&b, "%s#%d %s\n", indent, i, d.frameAt(pc, i > 0))
+		i++
+	}
+	for _, pc := range head[:start] {
+		frame("  ", pc)
+	}
+	if period > 0 {
+		fmt.Fprintf(&b, "  repeated %d times:\n", n/period)
+		for _, pc := range head[start : start+period] {
+			frame("    ", pc)
+		}
+		i += n - period
+	}
+	for _, pc := range tail {
+		frame("  ", pc)
+	}
+	if i < total {
+		fmt.Fprintf(&b, "  ... %d more frames\n", total-i)
+	}
+	d.UI.ShowOutput(b.String())
+	return nil
+}
+
+// findCycle finds the calls that repeat in frames, the innermost of a
+// stack: the frames from start on repeat every period frames for n frames.
+// The earliest start is taken, with the shortest period; period is 0 if no
+// frames repeat at least twice.
+func findCycle(frames []uint64) (start, period, n int) {
+	for s := 0; s < min(len(frames), maxCycleStart); s++ {
+		for p := 1; p <= maxCyclePeriod && s+2*p <= len(frames); p++ {
+			k := s + p
+			for k < len(frames) && frames[k] == frames[k-p] {
+				k++
+			}
+			if k-s >= 2*p {
+				return s, p, k - s
+			}
+		}
+	}
+	return 0, 0, 0
+}
+
+// overflowG is what diagnoseOverflow reads of the goroutine whose stack
+// overflowed: its ID, where it was stopped to grow its stack, and the top
+// of its stack.
+type overflowG struct {
+	id, pc, sp, bp, hi uint64
+}
+
+// overflowedG reads the goroutine whose stack overflowed from g0, the
+// system goroutine runtime.newstack runs on: it is the current one of g0's
+// thread, m.curg, stopped by runtime.morestack.
+func (d *Debugger) overflowedG(pid int, g0 uint64) (overflowG, error) {
+	var g overflowG
+	field := func(typ, name string) uint64 {
+		off, err := d.FieldOffset(typ, name)
+		if err != nil {
+			return 0
+		}
+		return uint64(off)
+	}
+	m, err := d.readUint(pid, g0+field("runtime.g", "m"), 8)
+	if err != nil {
+		return g, err
+	}
+	curg, err := d.readUint(pid, m+field("runtime.m", "curg"), 8)
+	if err != nil {
+		return g, err
+	}
+	if curg == 0 || curg == g0 {
+		return g, fmt.Errorf("the system stack overflowed")
+	}
+	sched := curg + field("runtime.g", "sched")
+	for _, f := range []struct {
+		v    *uint64
+		addr uint64
+	}{
+		{&g.id, curg + field("runtime.g", "goid")},
+		{&g.pc, sched + field("runtime.gobuf", "pc")},
+		{&g.sp, sched + field("runtime.gobuf", "sp")},
+		{&g.bp, sched + field("runtime.gobuf", "bp")},
+		{&g.hi, curg + field("runtime.g", "stack") + field("runtime.stack", "hi")},
+	} {
+		if *f.v, err = d.readUint(pid, f.addr, 8); err != nil {
+			return g, err
+		}
+	}
+	return g, nil
+}
+
+// fpWalkChunk is how much of the stack fpWalker reads at once.
+const fpWalkChunk = 64 << 10
+
+// fpWalker walks the frame pointer chain of a goroutine stopped by
+// runtime.morestack, in the prologue of a function that hasn't pushed its
+// frame pointer yet. An overflowed stack has millions of frames, so the
+// stack is read in chunks rather than a frame at a time.
+type fpWalker struct {
+	d       *Debugger
+	pid     int
+	pc, sp  uint64
+	bp, hi  uint64
+	started bool
+	base    uint64
+	chunk   []byte
+	err     error
+}
+
+// next returns the PC of the next frame, innermost first: the one stopped
+// in its prologue, its caller, whose return address is on top of the stack,
+// and the callers along the frame pointers.
+func (w *fpWalker) next() (uint64, bool) {
+	if !w.started {
+		w.started = true
+		return w.pc, true
+	}
+	if w.sp != 0 {
+		ret, ok := w.read(w.sp)
+		w.sp = 0
+		return ret, ok && ret != 0
+	}
+	if w.bp == 0 {
+		return 0, false
+	}
+	next, ok := w.read(w.bp)
+	ret, ok2 := w.read(w.bp + 8)
+	if !ok || !ok2 || ret == 0 {
+		return 0, false
+	}
+	// The stack grows down, so callers' frames are at higher addresses.
+	if next <= w.bp {
+		next = 0
+	}
+	w.bp = next
+	return ret, true
+}
+
+// read reads the word at addr of the stack, up to w.hi.
+func (w *fpWalker) read(addr uint64) (uint64, bool) {
+	if w.err != nil || addr+8 > w.hi {
+		return 0, false
+	}
+	if addr < w.base || addr+8 > w.base+uint64(len(w.chunk)) {
+		w.base = addr
+		w.chunk = make([]byte, min(fpWalkChunk, w.hi-addr))
+		if w.err = w.d.ReadMemory(w.pid, addr, w.chunk); w.err != nil {
+			return 0, false
+		}
+	}
+	return binary.LittleEndian.Uint64(w.chunk[addr-w.base:]), true
+}
@@ -0,0 +1,108 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+// maxStackRead bounds the bytes of a goroutine stack GoroutineStacks reads
+// in one go. Frames beyond are read as GoroutineStack does.
+const maxStackRead = 1 << 20
+
+// errBeyondRead ends the unwinding of a stack whose frames go past the
+// bytes read of it.
+var errBeyondRead = errors.New("frame beyond the stack read")
+
+// GoroutineStacks returns at most depth frames of each of gs, with the
+// error of each, as GoroutineStack would. It is made for the thousands of
+// goroutines of a server: the used part of each stack is read at once,
+// rather than a word per frame, then the stacks are unwound and their PCs
+// looked up on as many goroutines as there are CPUs, each PC once.
+func (d *Debugger) GoroutineStacks(pid int, gs []Goroutine, depth int) ([][]Frame, []error) {
+	stacks := make([][]Frame, len(gs))
+	errs := make([]error, len(gs))
+	// The frame descriptions are parsed on first use; do it before the
+	// workers share them.
+	if _, err := d.frameDescs(); err != nil {
+		for i := range gs {
+			errs[i] = err
+		}
+		return stacks, errs
+	}
+
+	// Reads go through the tracer's thread and the page cache, so they
+	// are done here, one per stack.
+	mem := make([][]byte, len(gs))
+	for i, g := range gs {
+		if g.Running {
+			stacks[i], errs[i] = d.GoroutineStack(pid, g, depth)
+			continue
+		}
+		if g.stackHi <= g.SP {
+			continue
+		}
+		buf := make([]byte, min(g.stackHi-g.SP, maxStackRead))
+		if err := d.ReadMemory(pid, g.SP, buf); err != nil {
+			if errors.Is(err, ErrInterrupted) {
+				errs[i] = err
+				continue
+			}
+			buf = nil
+		}
+		mem[i] = buf
+	}
+
+	var frames sync.Map // lookups by PC and whether it is a return address
+	type key struct {
+		pc  uint64
+		ret bool
+	}
+	frame := func(pc uint64, ret bool) Frame {
+		if f, ok := frames.Load(key{pc, ret}); ok {
+			return f.(Frame)
+		}
+		f := d.frameAt(pc, ret)
+		frames.Store(key{pc, ret}, f)
+		return f
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				g, buf := gs[i], mem[i]
+				read := func(addr uint64) (uint64, error) {
+					if addr < g.SP || addr+8 > g.SP+uint64(len(buf)) {
+						return 0, errBeyondRead
+					}
+					return binary.LittleEndian.Uint64(buf[addr-g.SP:]), nil
+				}
+				stacks[i], _, errs[i] = d.unwindWith(syscall.PtraceRegs{Rip: g.PC, Rsp: g.SP}, depth, read, frame)
+			}
+		}()
+	}
+	for i, g := range gs {
+		if !g.Running && errs[i] == nil {
+			next <- i
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	// Stacks deeper than what was read, or that couldn't be read at
+	// once, are unwound again a frame at a time.
+	for i, g := range gs {
+		if errors.Is(errs[i], errBeyondRead) {
+			stacks[i], errs[i] = d.GoroutineStack(pid, g, depth)
+		}
+	}
+	return stacks, errs
+}
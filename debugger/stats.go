@@ -0,0 +1,183 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// SessionStats summarizes a debugging session: how long it ran, how much
+// the target ran, where it stopped and what the debugger itself cost.
+type SessionStats struct {
+	WallTime    time.Duration    `json:"wall_time_ns"`
+	TargetCPU   time.Duration    `json:"target_cpu_ns"`
+	Stops       map[string]int   `json:"stops"`
+	Hits        []BreakpointHits `json:"breakpoint_hits"`
+	MemoryRead  uint64           `json:"memory_read_bytes"`
+	CachedReads int64            `json:"cached_reads"` // answered from the pages cached at stops
+}
+
+// BreakpointHits is a row of the breakpoint hit table. Hits count every
+// trap, including those a hit count condition stepped over.
+type BreakpointHits struct {
+	Location string `json:"location"`
+	Hits     int    `json:"hits"`
+}
+
+// sessionCounters collect the statistics while the session runs.
+type sessionCounters struct {
+	start   time.Time
+	stops   map[string]int
+	hits    map[string]int
+	memRead atomic.Uint64 // also added to by watch pollers
+	exitCPU time.Duration // CPU time of the exited target
+}
+
+func (d *Debugger) countStop(reason string) {
+	if d.stats.stops == nil {
+		d.stats.stops = make(map[string]int)
+	}
+	d.stats.stops[reason]++
+}
+
+func (d *Debugger) countHit(loc string) {
+	if d.stats.hits == nil {
+		d.stats.hits = make(map[string]int)
+	}
+	d.stats.hits[loc]++
+}
+
+func (d *Debugger) countRead(n int) {
+	if n > 0 {
+		d.stats.memRead.Add(uint64(n))
+	}
+}
+
+// Stats returns the statistics of the session so far, with cpu as the CPU
+// time of the target.
+func (d *Debugger) Stats(cpu time.Duration) SessionStats {
+	s := SessionStats{
+		WallTime:    time.Since(d.stats.start),
+		TargetCPU:   cpu,
+		Stops:       make(map[string]int),
+		MemoryRead:  d.stats.memRead.Load(),
+		CachedReads: d.pages.hits,
+	}
+	for reason, n := range d.stats.stops {
+		s.Stops[reason] = n
+	}
+	for loc, n := range d.stats.hits {
+		s.Hits = append(s.Hits, BreakpointHits{loc, n})
+	}
+	sort.Slice(s.Hits, func(i, j int) bool {
+		if s.Hits[i].Hits != s.Hits[j].Hits {
+			return s.Hits[i].Hits > s.Hits[j].Hits
+		}
+		return s.Hits[i].Location < s.Hits[j].Location
+	})
+	return s
+}
+
+// reportStats prints the session summary and writes it to the -stats-json
+// file, if one was given, along with the -call-graph file.
+func (d *Debugger) reportStats(cpu time.Duration) {
+	s := d.Stats(cpu)
+	defer d.storeRecording()
+	defer d.saveCallGraph()
+
+	var stops []string
+	for reason, n := range s.Stops {
+		stops = append(stops, fmt.Sprintf("%s %d", reason, n))
+	}
+	sort.Strings(stops)
+	if len(stops) == 0 {
+		stops = []string{"none"}
+	}
+
+	d.printf("\nSession summary\n")
+	d.printf("  wall time        %v\n", s.WallTime.Round(time.Millisecond))
+	d.printf("  target CPU time  %v\n", s.TargetCPU.Round(time.Millisecond))
+	d.printf("  stops            %s\n", strings.Join(stops, ", "))
+	d.printf("  memory read      %d bytes\n", s.MemoryRead)
+	d.printf("  cached reads     %d\n", s.CachedReads)
+	if len(s.Hits) > 0 {
+		d.printf("  breakpoint hits\n")
+		for _, h := range s.Hits {
+			d.printf("    %6d  %s\n", h.Hits, h.Location)
+		}
+	}
+
+	if d.statsFile == "" {
+		return
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err == nil {
+		err = os.WriteFile(d.statsFile, append(b, '\n'), 0o644)
+	}
+	if err != nil {
+		d.printf("Can't write statistics: %v\n", err)
+		return
+	}
+	d.storeArtifact(d.statsFile)
+}
+
+// quit ends the session while the target pid is still running. Unless the
+// session is saved and the target killed, the target is left to run on
+// without the breakpoints. The session then unwinds with ErrQuit.
+func (d *Debugger) quit(pid int) {
+	if d.quitting {
+		return
+	}
+	d.quitting = true
+	cpu, err := processCPUTime(pid)
+	if err != nil {
+		d.printf("Can't read the target's CPU time: %v\n", err)
+	}
+	d.reportStats(cpu)
+	if d.saveOnExit != "" {
+		if err := d.saveAndKill(pid); err != nil {
+			d.println(err)
+		} else {
+			d.storeArtifact(d.saveOnExit)
+		}
+	} else if err := d.release(pid); err != nil {
+		d.printf("Can't remove the breakpoints: %v\n", err)
+	}
+}
+
+// processCPUTime reads the user and system time of the running process pid
+// from /proc. The kernel reports it in clock ticks, which are 1/100 s on
+// Linux.
+func processCPUTime(pid int) (time.Duration, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name may contain spaces; the fields after it don't.
+	fields := strings.Fields(string(b[strings.LastIndexByte(string(b), ')')+1:]))
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("short /proc/%d/stat", pid)
+	}
+	var ticks int64
+	for _, f := range fields[11:13] { // utime and stime
+		n, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		ticks += n
+	}
+	return time.Duration(ticks) * 10 * time.Millisecond, nil
+}
+
+// rusageCPUTime is the user and system time in ru.
+func rusageCPUTime(ru *syscall.Rusage) time.Duration {
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
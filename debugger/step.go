@@ -0,0 +1,265 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/gosym"
+	"fmt"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// stepPlan is a "next" or "finish" in progress: temporary traps the target
+// continues to, which only stop it in the frame being stepped. Other
+// goroutines and recursive calls run through them.
+type stepPlan struct {
+	kind  string   // "next", "finish" or "step", also the reason of the stop
+	frame frameID  // the frame being stepped
+	ret   uint64   // its return address
+	lines []uint64 // the starts of its other lines, for next
+	// then is the line step to carry on with once the frame returns, for
+	// calls into the runtime made on a line being stepped.
+	then *lineStep
+}
+
+// StepOver sets up a "next" on the thread pid, stopped at d.Regs: the
+// target is to be continued until it reaches another line of the current
+// function, running calls to completion, or returns from it.
+func (d *Debugger) StepOver(pid int) error {
+	p, err := d.overPlan(pid, "next")
+	if err != nil {
+		return err
+	}
+	return d.startStep(p)
+}
+
+// overPlan returns the plan of a next from the current line of pid.
+func (d *Debugger) overPlan(pid int, kind string) (*stepPlan, error) {
+	pc := d.Regs.Rip
+	file, line, fn := d.pcToLine(pc)
+	if fn == nil {
+		return nil, fmt.Errorf("no function at %#x", pc)
+	}
+	p, err := d.newStepPlan(pid, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	// A line starts wherever the line table changes. Inlined code reports
+	// the lines it came from, so stepping over a line still stops in the
+	// inlined bodies of other lines.
+	prevFile, prevLine := "", 0
+	for addr := fn.Entry; addr < fn.End; addr++ {
+		f, l, _ := d.pcToLine(addr)
+		if (f != prevFile || l != prevLine) && addr != fn.Entry && addr != pc && (f != file || l != line) {
+			p.lines = append(p.lines, addr)
+		}
+		prevFile, prevLine = f, l
+	}
+	return p, nil
+}
+
+// StepOut sets up a "finish" on the thread pid, stopped at d.Regs: the
+// target is to be continued until the current function returns.
+func (d *Debugger) StepOut(pid int) error {
+	p, err := d.newStepPlan(pid, "finish")
+	if err != nil {
+		return err
+	}
+	return d.startStep(p)
+}
+
+// frameID identifies a frame even when stack growth has moved it: by its
+// goroutine and the distance of its CFA from the top of the goroutine's
+// stack, which copying the stack keeps.
+type frameID struct {
+	g     uint64
+	depth uint64
+}
+
+// frameIDAt returns the identity of the frame whose CFA is cfa, in the
+// goroutine of the thread pid with registers regs. Go code keeps the
+// goroutine in its TLS, and the goroutine starts with its stack bounds.
+// Without one the depth is measured from the top of the address space.
+func (d *Debugger) frameIDAt(pid int, regs *syscall.PtraceRegs, cfa uint64) frameID {
+	g := d.currentG(pid, regs)
+	hi, err := d.readUint(pid, g+8, 8)
+	if err != nil || hi < cfa {
+		g, hi = 0, ^uint64(0)
+	}
+	return frameID{g, hi - cfa}
+}
+
+// newStepPlan finds the frame and return address of the current function
+// of pid. The return address is stored just below the CFA.
+func (d *Debugger) newStepPlan(pid int, kind string) (*stepPlan, error) {
+	cfa, err := d.cfa(d.Regs.Rip, &d.Regs)
+	if err != nil {
+		return nil, fmt.Errorf("can't find the current frame: %v", err)
+	}
+	ret, err := d.readUint(pid, cfa-8, 8)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the return address: %v", err)
+	}
+	if _, _, fn := d.pcToLine(ret); fn == nil {
+		return nil, fmt.Errorf("%s: the current function doesn't return to Go code", kind)
+	}
+	return &stepPlan{kind: kind, frame: d.frameIDAt(pid, &d.Regs, cfa), ret: ret}, nil
+}
+
+// startStep arms the traps of p, replacing any plan in progress. They are
+// written when the target resumes.
+func (d *Debugger) startStep(p *stepPlan) error {
+	d.endStep()
+	for i, addr := range append([]uint64{p.ret}, p.lines...) {
+		add := d.addTrap
+		if i == 0 {
+			add = d.addReturnTrap
+		}
+		if err := add(addr); err != nil {
+			d.step = p
+			d.endStep()
+			return err
+		}
+	}
+	d.step = p
+	return nil
+}
+
+// endStep removes the traps of the plan in progress, if any, and ends a
+// line step. Every stop at the prompt ends them.
+func (d *Debugger) endStep() {
+	d.lineStep = nil
+	if d.step == nil {
+		return
+	}
+	d.removeTrap(d.step.ret)
+	for _, addr := range d.step.lines {
+		d.removeTrap(addr)
+	}
+	d.step = nil
+}
+
+// stepStop handles a hit of a trap of the plan in progress at addr. A hit in
+// another frame is stepped over and the target resumed.
+func (d *Debugger) stepStop(pid int, addr uint64) (string, bool, error) {
+	p := d.step
+	if p == nil || addr != p.ret && !slices.Contains(p.lines, addr) {
+		return "", false, nil
+	}
+	if err := d.rewindTrap(pid, addr); err != nil {
+		return "", true, err
+	}
+
+	var inFrame bool
+	if addr == p.ret {
+		// Returned once the stack pointer is back above the frame; a
+		// recursive call returning to the same address is still below it.
+		f := d.frameIDAt(pid, &d.Regs, d.Regs.Rsp)
+		inFrame = f.g == p.frame.g && f.depth <= p.frame.depth
+	} else {
+		cfa, err := d.cfa(addr, &d.Regs)
+		inFrame = err == nil && d.frameIDAt(pid, &d.Regs, cfa) == p.frame
+	}
+	if !inFrame {
+		return "", true, d.resume(pid, true)
+	}
+	d.endStep()
+	if p.then != nil {
+		// Back from the runtime; the goroutine may have moved threads.
+		d.lineStep, p.then.tid = p.then, pid
+		return d.lineStepStop(pid)
+	}
+	return p.kind, true, nil
+}
+
+// lineStep is a source line step in progress: the thread is single-stepped
+// for as long as it stays on the line it started from.
+type lineStep struct {
+	tid   int
+	fn    *gosym.Func
+	file  string
+	line  int
+	frame frameID
+}
+
+// StepLine sets up a source line step of the thread pid, stopped at d.Regs:
+// the thread is to be single-stepped until it reaches another line of the
+// current function. Calls into functions outside the runtime stop at their
+// entry; calls into the runtime are continued to their return rather than
+// stepped through, then stepping carries on. Returning from the function
+// stops in the caller.
+func (d *Debugger) StepLine(pid int) error {
+	pc := d.Regs.Rip
+	file, line, fn := d.pcToLine(pc)
+	if fn == nil {
+		return fmt.Errorf("no function at %#x", pc)
+	}
+	cfa, err := d.cfa(pc, &d.Regs)
+	if err != nil {
+		return fmt.Errorf("can't find the current frame: %v", err)
+	}
+	d.endStep()
+	d.lineStep = &lineStep{tid: pid, fn: fn, file: file, line: line, frame: d.frameIDAt(pid, &d.Regs, cfa)}
+	return nil
+}
+
+// lineStepStop handles a single step of a line step in progress, stepping
+// again while the thread is on the same line.
+func (d *Debugger) lineStepStop(pid int) (string, bool, error) {
+	ls := d.lineStep
+	if ls == nil || pid != ls.tid {
+		return "", false, nil
+	}
+	pc := d.Regs.Rip
+	file, line, fn := d.pcToLine(pc)
+	cfa, err := d.cfa(pc, &d.Regs)
+	var f frameID
+	if err == nil {
+		f = d.frameIDAt(pid, &d.Regs, cfa)
+	}
+
+	switch {
+	case err == nil && f == ls.frame && fn != nil && fn.Entry == ls.fn.Entry:
+		if file == ls.file && line == ls.line {
+			return "", true, d.resume(pid, false)
+		}
+	case err != nil || f.g == ls.frame.g && f.depth > ls.frame.depth:
+		// In a callee. Code without frame information is runtime assembly.
+		if err == nil && fn != nil && !isRuntimeFunc(fn.Name) {
+			break
+		}
+		// The step into the call left the thread on the callee's first
+		// instruction, with the return address on top of the stack.
+		ret, err := d.readUint(pid, d.Regs.Rsp, 8)
+		if err != nil {
+			d.println(err)
+			break
+		}
+		p := &stepPlan{kind: "step", frame: d.frameIDAt(pid, &d.Regs, d.Regs.Rsp+8), ret: ret, then: ls}
+		if err := d.startStep(p); err != nil {
+			d.println(err)
+			break
+		}
+		return "", true, d.resume(pid, true)
+	}
+	d.lineStep = nil
+	return "step", true, nil
+}
+
+// lineStepSignal handles signals, such as the runtime's preemption
+// requests, that stop the thread of a line step between single steps. They
+// are dropped, as the event loop does, and the thread stepped on.
+func (d *Debugger) lineStepSignal(pid int) (string, bool, error) {
+	if d.lineStep == nil || pid != d.lineStep.tid || d.Ws.StopSignal() == syscall.SIGTRAP {
+		return "", false, nil
+	}
+	return "", true, wrapPtrace("single-stepping", pid, d.proc().Step(pid))
+}
+
+// isRuntimeFunc reports whether the function name belongs to the runtime,
+// which line steps don't enter.
+func isRuntimeFunc(name string) bool {
+	return strings.HasPrefix(name, "runtime.") || strings.HasPrefix(name, "runtime/") || strings.HasPrefix(name, "internal/")
+}
@@ -0,0 +1,136 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxListed bounds the functions and files funcs and files list.
+const maxListed = 500
+
+// funcsCommand handles "funcs [regexp]", which lists the functions of the
+// target whose names match, with their entry addresses.
+func (d *Debugger) funcsCommand(pid int, args []string) bool {
+	re, ok := d.listPattern("funcs", args)
+	if !ok {
+		return false
+	}
+	var names []string
+	entries := make(map[string]uint64)
+	for _, t := range d.symTables() {
+		for _, fn := range t.Funcs {
+			if re == nil || re.MatchString(fn.Name) {
+				if _, dup := entries[fn.Name]; !dup {
+					names = append(names, fn.Name)
+				}
+				entries[fn.Name] = fn.Entry
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names[:min(len(names), maxListed)] {
+		d.printf("  %#x  %s\n", entries[name], name)
+	}
+	d.showListed(len(names), "functions")
+	return false
+}
+
+// filesCommand handles "files [regexp]", which lists the source files
+// compiled into the target whose paths match.
+func (d *Debugger) filesCommand(pid int, args []string) bool {
+	re, ok := d.listPattern("files", args)
+	if !ok {
+		return false
+	}
+	var files []string
+	seen := make(map[string]bool)
+	for _, t := range d.symTables() {
+		for path := range t.Files {
+			if !seen[path] && (re == nil || re.MatchString(path)) {
+				files = append(files, path)
+			}
+			seen[path] = true
+		}
+	}
+	sort.Strings(files)
+	for _, path := range files[:min(len(files), maxListed)] {
+		d.printf("  %s\n", path)
+	}
+	d.showListed(len(files), "files")
+	return false
+}
+
+// listPattern parses the optional pattern of funcs and files, nil for all.
+func (d *Debugger) listPattern(name string, args []string) (*regexp.Regexp, bool) {
+	switch len(args) {
+	case 0:
+		return nil, true
+	case 1:
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			d.printf("bad pattern %q: %v\n", args[0], err)
+			return nil, false
+		}
+		return re, true
+	}
+	d.printf("usage: %s [regexp]\n", name)
+	return nil, false
+}
+
+func (d *Debugger) showListed(n int, what string) {
+	switch {
+	case n == 0:
+		d.printf("No %s match\n", what)
+	case n > maxListed:
+		d.printf("... %d more; narrow the pattern to see them\n", n-maxListed)
+	}
+}
+
+// infoCommand handles "info line <function>", which shows the lines and
+// addresses a function spans, and "info line [file:]line", which shows the
+// address and function of a line, and "info addr <address>".
+func (d *Debugger) infoCommand(pid int, args []string) bool {
+	if len(args) >= 2 && args[0] == "addr" {
+		d.infoAddrCommand(pid, strings.Join(args[1:], " "))
+		return false
+	}
+	if len(args) != 2 || args[0] != "line" {
+		d.println("usage: info line <function> | info line [file:]line | info addr <address>")
+		return false
+	}
+	loc := args[1]
+	if _, err := strconv.Atoi(loc); err == nil || strings.Contains(loc, ":") {
+		file, line, err := d.parseLocation(loc)
+		if err != nil {
+			d.println(err)
+			return false
+		}
+		pc, fn, err := d.lineToPC(file, line)
+		if err != nil || fn == nil {
+			d.printf("No code at %s:%d\n", file, line)
+			return false
+		}
+		d.printf("Line %d of %s is at %#x in %s\n", line, file, pc, fn.Name)
+		return false
+	}
+	fn, err := d.ResolveFunc(loc)
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	file, first, _ := d.pcToLine(fn.Entry)
+	last := first
+	// The lines of the function in its own file; inlined calls bring
+	// others.
+	for pc := fn.Entry; pc < fn.End; pc++ {
+		if f, line, _ := d.pcToLine(pc); f == file {
+			first, last = min(first, line), max(last, line)
+		}
+	}
+	d.printf("%s is at %s:%d-%d, %#x-%#x (%d bytes)\n", fn.Name, file, first, last, fn.Entry, fn.End, fn.End-fn.Entry)
+	return false
+}
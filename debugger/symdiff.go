@@ -0,0 +1,196 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// symdiffFunc is where a function of a build starts, and how long its code
+// is.
+type symdiffFunc struct {
+	file string
+	line int
+	size uint64
+}
+
+// SymDiff implements "symdiff [-session file] <old> <new>". It compares the
+// functions of two builds of a program by name: those removed, those whose
+// first line moved, and those whose code changed size. With a session
+// saved by "session save" against the old build, it tells which of its
+// breakpoints and group locations still stand for the same line of the
+// same function in the new one, and where those whose function moved
+// belong now. The exit code is 1 when a saved location would break.
+func SymDiff(args []string) int {
+	const usage = "usage: symdiff [-session file] <old binary> <new binary>"
+	flags := flag.NewFlagSet("symdiff", flag.ExitOnError)
+	sessionFile := flags.String("session", "", "check the breakpoints and groups saved in `file` against the new build")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fmt.Println(usage)
+		return 2
+	}
+	var builds [2]*Debugger
+	for i, path := range flags.Args() {
+		d := NewDebugger()
+		var err error
+		if d.SymTable, err = d.GetSymbolTable(path); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		if fn := d.SymTable.LookupFunc("main.main"); fn != nil {
+			d.TargetFile, _, d.Fn = d.SymTable.PCToLine(fn.Entry)
+		}
+		builds[i] = d
+	}
+	old, cur := builds[0], builds[1]
+	var session *SavedSession
+	if *sessionFile != "" {
+		var err error
+		if session, err = LoadSession(*sessionFile); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+	}
+
+	funcs := func(d *Debugger) map[string]symdiffFunc {
+		m := make(map[string]symdiffFunc)
+		for _, fn := range d.SymTable.Funcs {
+			file, line, _ := d.SymTable.PCToLine(fn.Entry)
+			m[fn.Name] = symdiffFunc{file, line, fn.End - fn.Entry}
+		}
+		return m
+	}
+	before, after := funcs(old), funcs(cur)
+	var removed, moved []string
+	added, resized := 0, 0
+	for name, f := range before {
+		g, ok := after[name]
+		switch {
+		case !ok:
+			removed = append(removed, name)
+		case g.file != f.file || g.line != f.line:
+			moved = append(moved, fmt.Sprintf("%s  %s:%d -> %s:%d", name, f.file, f.line, g.file, g.line))
+		case g.size != f.size:
+			resized++
+		}
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			added++
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(moved)
+
+	if session == nil {
+		if len(removed) > 0 {
+			fmt.Println("Removed functions:")
+			for _, name := range removed {
+				fmt.Printf("  %s\n", name)
+			}
+		}
+		if len(moved) > 0 {
+			fmt.Println("Moved functions:")
+			for _, m := range moved {
+				fmt.Printf("  %s\n", m)
+			}
+		}
+		fmt.Printf("Functions: %d removed, %d moved, %d changed in size, %d added\n", len(removed), len(moved), resized, added)
+		return 0
+	}
+
+	broken := 0
+	check := func(what, loc string) {
+		status, detail := old.followLocation(cur, loc)
+		if status != "ok" {
+			broken++
+		}
+		fmt.Printf("  %-8s %s%s\n", status, what, detail)
+	}
+	breakpoints := session.Breakpoints
+	if session.Breakpoint != nil {
+		breakpoints = append([]SavedBreakpoint{*session.Breakpoint}, breakpoints...)
+	}
+	fmt.Println("Saved breakpoints:")
+	for _, sb := range breakpoints {
+		loc := fmt.Sprintf("%s:%d", sb.File, sb.Line)
+		check(loc, loc)
+	}
+	for _, g := range session.Groups {
+		for _, loc := range g.Locations {
+			check(fmt.Sprintf("group %s: %s", g.Name, loc), loc)
+		}
+	}
+	fmt.Printf("%d of %d saved locations would break. Functions: %d removed, %d moved, %d changed in size, %d added\n",
+		broken, len(breakpoints)+groupLocations(session.Groups), len(removed), len(moved), resized, added)
+	if broken > 0 {
+		return 1
+	}
+	return 0
+}
+
+// groupLocations counts the locations of the groups.
+func groupLocations(groups []SavedGroup) int {
+	n := 0
+	for _, g := range groups {
+		n += len(g.Locations)
+	}
+	return n
+}
+
+// followLocation finds the location loc, a function or "[file:]line" as
+// "break" takes it, of the build d in the build cur. A line is followed by
+// its distance from the first line of its function, which stays with the
+// function as it moves, and is ok when it names the same line of the same
+// function in both. It returns "ok", "moved", "removed", "changed" or
+// "stale", a location without code in d, with what was found.
+func (d *Debugger) followLocation(cur *Debugger, loc string) (string, string) {
+	if _, err := strconv.Atoi(loc); err != nil && !strings.Contains(loc, ":") {
+		fn, err := d.ResolveFunc(loc)
+		if err != nil {
+			return "stale", fmt.Sprintf(": %v", err)
+		}
+		if cur.SymTable.LookupFunc(fn.Name) == nil {
+			return "removed", fmt.Sprintf(": no %s in the new build", fn.Name)
+		}
+		return "ok", ""
+	}
+
+	file, line, err := d.parseLocation(loc)
+	if err != nil {
+		return "stale", fmt.Sprintf(": %v", err)
+	}
+	_, fn, err := d.lineToPC(file, line)
+	if err != nil || fn == nil {
+		return "stale", ": no code in the old build"
+	}
+	_, start, _ := d.pcToLine(fn.Entry)
+	newFn := cur.SymTable.LookupFunc(fn.Name)
+	if newFn == nil {
+		return "removed", fmt.Sprintf(" (%s): no such function in the new build", fn.Name)
+	}
+	newFile, newStart, _ := cur.pcToLine(newFn.Entry)
+	want := newStart + line - start
+	_, at, err := cur.lineToPC(newFile, want)
+	switch {
+	case err != nil || at == nil:
+		return "changed", fmt.Sprintf(" (%s): %s:%d, where the line is now, has no code", fn.Name, newFile, want)
+	case at.Name != fn.Name:
+		return "changed", fmt.Sprintf(" (%s): %s:%d, where the line is now, is in %s", fn.Name, newFile, want, at.Name)
+	}
+	// Lines added or removed within the function above loc shift it
+	// without the function moving; only its size tells.
+	note := ""
+	if newFn.End-newFn.Entry != fn.End-fn.Entry {
+		note = "; the function's code changed, check the line"
+	}
+	if newFile != file || want != line {
+		return "moved", fmt.Sprintf(" (%s) -> %s:%d%s", fn.Name, newFile, want, note)
+	}
+	return "ok", fmt.Sprintf(" (%s)%s", fn.Name, note)
+}
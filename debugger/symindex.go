@@ -0,0 +1,201 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// symbolIndexVersion is bumped whenever symbolIndex changes, so indexes of
+// older builds of the debugger are rebuilt rather than misread.
+const symbolIndexVersion = 1
+
+// symbolIndex is what loading the symbols of a binary leaves on disk, under
+// its build ID, for the next session against it: the ELF symbols, the DWARF
+// sections decompressed, and the line table when it had to be found or
+// built rather than read from .gopclntab.
+type symbolIndex struct {
+	Version   int
+	BuildID   string
+	Symbols   map[string]uint64
+	Dwarf     map[string][]byte // by section name, without .debug_ or .zdebug_
+	LineTable []byte
+	FromDWARF bool
+}
+
+// defaultSymbolIndexDir is where symbol indexes are kept unless SymbolIndex
+// says otherwise: the user's cache directory, or none without one.
+func defaultSymbolIndexDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dedebugger", "symbols")
+}
+
+// buildID returns the build ID of exe, from the GNU note or else Go's own,
+// or "" when it has neither.
+func buildID(exe *elf.File) string {
+	for _, name := range []string{".note.gnu.build-id", ".note.go.buildid"} {
+		s := exe.Section(name)
+		if s == nil {
+			continue
+		}
+		note, err := s.Data()
+		if err != nil || len(note) < 12 {
+			continue
+		}
+		namesz := binary.LittleEndian.Uint32(note[0:])
+		descsz := binary.LittleEndian.Uint32(note[4:])
+		desc := 12 + (uint64(namesz)+3)&^3
+		if desc+uint64(descsz) > uint64(len(note)) || descsz == 0 {
+			continue
+		}
+		id := note[desc : desc+uint64(descsz)]
+		if name == ".note.gnu.build-id" {
+			return hex.EncodeToString(id)
+		}
+		return string(id)
+	}
+	return ""
+}
+
+// symbolIndexPath returns the file of the index of the binary with build ID
+// id, or "" when there is no directory to keep it in or no ID to key it by.
+func (d *Debugger) symbolIndexPath(id string) string {
+	if d.SymbolIndex == "" || id == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(d.SymbolIndex, hex.EncodeToString(sum[:]))
+}
+
+// loadSymbolIndex reads the index at path. A missing, damaged or outdated
+// index is as good as none, and reported so.
+func loadSymbolIndex(path, id string) (*symbolIndex, bool) {
+	if path == "" {
+		return nil, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	var idx symbolIndex
+	if err := gob.NewDecoder(f).Decode(&idx); err != nil || idx.Version != symbolIndexVersion || idx.BuildID != id {
+		return nil, false
+	}
+	return &idx, true
+}
+
+// save writes idx to path, by way of a temporary file so that another
+// session never reads half of it. Failing to is no reason to fail loading
+// the symbols, so errors are left for the next session to try again.
+func (idx *symbolIndex) save(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return
+	}
+	err = gob.NewEncoder(f).Encode(idx)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// dwarfSectionData returns the DWARF sections of exe decompressed, by name
+// without .debug_ or .zdebug_.
+func dwarfSectionData(exe *elf.File) (map[string][]byte, error) {
+	secs := make(map[string][]byte)
+	for _, s := range exe.Sections {
+		name, zdebug := strings.CutPrefix(s.Name, ".zdebug_")
+		if !zdebug {
+			var ok bool
+			if name, ok = strings.CutPrefix(s.Name, ".debug_"); !ok {
+				continue
+			}
+		}
+		// Data decompresses SHF_COMPRESSED sections, but not the older
+		// .zdebug ones.
+		b, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", s.Name, err)
+		}
+		if zdebug {
+			if b, err = zdebugData(b); err != nil {
+				return nil, fmt.Errorf("%s: %v", s.Name, err)
+			}
+		}
+		secs[name] = b
+	}
+	return secs, nil
+}
+
+// zdebugData decompresses a .zdebug section: "ZLIB", the size
+// decompressed as a big-endian uint64, then the zlib stream.
+func zdebugData(b []byte) ([]byte, error) {
+	if len(b) < 12 || string(b[:4]) != "ZLIB" {
+		return b, nil
+	}
+	size := binary.BigEndian.Uint64(b[4:12])
+	r, err := zlib.NewReader(bytes.NewReader(b[12:]))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	out.Grow(int(min(size, uint64(len(b))*64)))
+	if _, err := io.Copy(&out, r); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// dwarfFromSections builds the DWARF data of the sections secs, as
+// elf.File.DWARF does from those of the binary. It returns nil without
+// .debug_info.
+func dwarfFromSections(secs map[string][]byte) (*dwarf.Data, error) {
+	if secs["info"] == nil {
+		return nil, nil
+	}
+	data, err := dwarf.New(secs["abbrev"], secs["aranges"], secs["frame"], secs["info"],
+		secs["line"], secs["pubnames"], secs["ranges"], secs["str"])
+	if err != nil {
+		return nil, err
+	}
+	if b := secs["types"]; b != nil {
+		if err := data.AddTypes("types", b); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range []string{"addr", "line_str", "loclists", "rnglists", "str_offsets"} {
+		if b := secs[name]; b != nil {
+			if err := data.AddSection(".debug_"+name, b); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return data, nil
+}
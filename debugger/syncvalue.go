@@ -0,0 +1,145 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+)
+
+// maxSyncMapNodes bounds the walk of a sync.Map's trie.
+const maxSyncMapNodes = 1 << 16
+
+// formatSync formats the values of the sync package whose fields say
+// little by themselves: the counters packed in a WaitGroup's state word, a
+// Once's done flag, the number of entries of a Map and the state of a
+// Mutex or RWMutex. ok is false for other types and for layouts of the
+// sync package it doesn't know, which are formatted field by field.
+func (d *Debugger) formatSync(pid int, t *dwarf.StructType, buf []byte) (s string, ok bool) {
+	switch t.StructName {
+	case "sync.WaitGroup":
+		// The counter is in the high half of the state word, the number
+		// of waiters in the low bits, under a flag in bit 31.
+		off, err := fieldOffset(t, []string{"state", "v"})
+		if err != nil {
+			return "", false
+		}
+		state := bufUint(buf[off:], 8)
+		return fmt.Sprintf("sync.WaitGroup {counter: %d, waiters: %d}", int32(state>>32), state&0x7fffffff), true
+	case "sync.Once":
+		off, err := fieldOffset(t, []string{"done", "v"})
+		if err != nil {
+			if off, err = fieldOffset(t, []string{"done"}); err != nil {
+				return "", false
+			}
+		}
+		return fmt.Sprintf("sync.Once {done: %t}", buf[off] != 0), true
+	case "sync.Map":
+		n, err := d.syncMapLen(pid, t, buf)
+		if err != nil {
+			return "", false
+		}
+		return fmt.Sprintf("sync.Map {len: %d}", n), true
+	case "sync.Mutex":
+		m, err := decodeMutex(t, buf, 0)
+		if err != nil {
+			return "", false
+		}
+		return fmt.Sprintf("sync.Mutex {%v}", m), true
+	case "sync.RWMutex":
+		off, err := fieldOffset(t, []string{"readerCount", "v"})
+		if err != nil {
+			return "", false
+		}
+		// A writer takes rwmutexMaxReaders off the count when it locks.
+		readers := int32(bufUint(buf[off:], 4))
+		writer := readers < 0
+		if writer {
+			readers += rwmutexMaxReaders
+		}
+		return fmt.Sprintf("sync.RWMutex {readers: %d, writer: %t}", readers, writer), true
+	}
+	return "", false
+}
+
+// syncMapLen counts the entries of the sync.Map of type t in buf. Since
+// Go 1.24 a sync.Map is an internal/sync.HashTrieMap: a trie of indirect
+// nodes with 16 children each, whose leaves are entries chained on hash
+// collisions. Both kinds of node start with the isEntry flag, and the
+// atomic pointers to children hold just the pointer.
+func (d *Debugger) syncMapLen(pid int, t *dwarf.StructType, buf []byte) (int, error) {
+	ht, err := fieldType(t, "m")
+	if err != nil {
+		return 0, err
+	}
+	if td, ok := ht.(*dwarf.TypedefType); ok {
+		ht = td.Type
+	}
+	st, _ := ht.(*dwarf.StructType)
+	if st == nil {
+		return 0, fmt.Errorf("unknown sync.Map layout %s", ht)
+	}
+	args, ok := strings.CutPrefix(st.StructName, "internal/sync.HashTrieMap")
+	if !ok {
+		return 0, fmt.Errorf("unknown sync.Map layout %s", ht)
+	}
+	root, err := fieldOffset(t, []string{"m", "root", "v"})
+	if err != nil {
+		return 0, err
+	}
+	children, err := d.FieldOffset("internal/sync.indirect"+args, "children")
+	if err != nil {
+		return 0, err
+	}
+	// The compiler may describe entries only by the shape of the type
+	// arguments that the code for them is shared by.
+	overflow, err := d.FieldOffset("internal/sync.entry"+args, "overflow")
+	if err != nil {
+		overflow, err = d.FieldOffset("internal/sync.entry[go.shape.interface {},go.shape.interface {}]", "overflow")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n, visited := 0, 0
+	var walk func(addr uint64) error
+	walk = func(addr uint64) error {
+		kids := make([]byte, 16*8)
+		if err := d.ReadMemory(pid, addr+uint64(children), kids); err != nil {
+			return err
+		}
+		for i := 0; i < len(kids); i += 8 {
+			node := bufUint(kids[i:], 8)
+			if node == 0 {
+				continue
+			}
+			if visited++; visited > maxSyncMapNodes {
+				return fmt.Errorf("sync.Map too large")
+			}
+			isEntry, err := d.readUint(pid, node, 1)
+			if err != nil {
+				return err
+			}
+			if isEntry == 0 {
+				if err := walk(node); err != nil {
+					return err
+				}
+				continue
+			}
+			for e := node; e != 0 && n < maxSyncMapNodes; n++ {
+				if e, err = d.readUint(pid, e+uint64(overflow), 8); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	// A map never stored to has no root yet.
+	if addr := bufUint(buf[root:], 8); addr != 0 {
+		if err := walk(addr); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,155 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Target is what a session debugs: a program the debugger launches, a
+// process it attaches to, a core file or a replay. Debug runs the same
+// session, with the same commands, on any of them. A new backend, such as
+// a remote stub, implements Target, setting Debugger.Proc to the
+// ProcessController of its threads when they aren't driven with ptrace.
+type Target interface {
+	// Open sets the debugger up on the target: its symbols, its threads
+	// and their controller. It returns the thread stopped for the first
+	// prompt and the process group of the target.
+	Open(d *Debugger) (tid, pgid int, err error)
+	// Live reports whether the target runs, so that it can be resumed and
+	// changed. Other targets, like a core file, can only be looked at.
+	Live() bool
+}
+
+// RestartableTarget is a Target that restart can start again.
+type RestartableTarget interface {
+	Target
+	// Reopen readies the target to be opened again after a restart ended
+	// the session of the last one.
+	Reopen(d *Debugger)
+}
+
+// LaunchedTarget is a program the debugger starts as Config says.
+type LaunchedTarget struct {
+	Config LaunchConfig
+}
+
+func (t *LaunchedTarget) Live() bool { return true }
+
+func (t *LaunchedTarget) Reopen(d *Debugger) {
+	// Input from a file is read again from its start.
+	if s, ok := t.Config.Stdin.(io.Seeker); ok {
+		s.Seek(0, io.SeekStart)
+	}
+	d.printf("Restarting %s\n", strings.Join(append([]string{t.Config.Path}, t.Config.Args...), " "))
+}
+
+// AttachedTarget is the running process Pid, which the debugger attaches
+// to and leaves running when it detaches.
+type AttachedTarget struct {
+	Pid int
+}
+
+func (t *AttachedTarget) Live() bool { return true }
+
+// CoreTarget is the process the core file Core was dumped from, with the
+// symbols of the program Binary.
+type CoreTarget struct {
+	Binary, Core string
+}
+
+// Open reads the core file, which stands in for the process.
+func (t *CoreTarget) Open(d *Debugger) (int, int, error) {
+	tid, err := d.OpenCoreFile(t.Binary, t.Core)
+	if err != nil {
+		return 0, 0, err
+	}
+	// The memory of a core file never changes.
+	d.cachePages()
+	return tid, d.Pid, nil
+}
+
+func (t *CoreTarget) Live() bool { return false }
+
+// ReplayTarget replays a run of the program Binary from Proc, a
+// ProcessController that stands in for the process, such as a MockProcess
+// with scripted events. Its thread Pid stops first; Threads are the
+// others. Resuming it plays Proc's next events.
+type ReplayTarget struct {
+	Binary  string
+	Proc    ProcessController
+	Pid     int
+	Threads []int
+}
+
+// Open loads the symbols of the binary and takes the threads from Proc.
+func (t *ReplayTarget) Open(d *Debugger) (int, int, error) {
+	if t.Proc == nil {
+		return 0, 0, fmt.Errorf("nothing to replay %s from", t.Binary)
+	}
+	if d.SymTable == nil {
+		table, err := d.GetSymbolTable(t.Binary)
+		if err != nil {
+			return 0, 0, err
+		}
+		d.SymTable = table
+	}
+	if fn := d.SymTable.LookupFunc("main.main"); fn != nil {
+		d.TargetFile, _, d.Fn = d.SymTable.PCToLine(fn.Entry)
+	}
+	d.Proc, d.Pid, d.target = t.Proc, t.Pid, t.Binary
+	d.stats.start = time.Now()
+	d.tracees = nil
+	for _, tid := range append([]int{t.Pid}, t.Threads...) {
+		d.addTracee(tid, t.Pid, t.Pid)
+	}
+	return t.Pid, t.Pid, nil
+}
+
+func (t *ReplayTarget) Live() bool { return true }
+
+// Debug opens t and runs its session until the target exits or the session
+// ends: at the prompt of a live target, or post mortem for one that isn't.
+// A restart of a RestartableTarget opens it again.
+func (d *Debugger) Debug(t Target) error {
+	// ptrace requests are only accepted from the thread that started or
+	// attached to the tracee, so keep this goroutine on one OS thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	d.current = t
+	for {
+		tid, pgid, err := t.Open(d)
+		if err != nil {
+			return err
+		}
+		if !t.Live() {
+			d.postMortem(tid)
+			return nil
+		}
+		err = d.debugOpened(tid, pgid)
+		r, ok := t.(RestartableTarget)
+		if !ok || !errors.Is(err, errRestart) {
+			return err
+		}
+		d.prepareRestart()
+		r.Reopen(d)
+	}
+}
+
+// debugOpened runs the session of a live target that was just opened,
+// restoring its code should the debugger crash.
+func (d *Debugger) debugOpened(tid, pgid int) error {
+	defer func() {
+		if r := recover(); r != nil {
+			d.abort(fmt.Sprint("crashed: ", r))
+			panic(r)
+		}
+	}()
+	return d.debugTarget(tid, pgid)
+}
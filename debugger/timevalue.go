@@ -0,0 +1,90 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"time"
+)
+
+// Constants of time.Time's encoding: with the monotonic bit set in wall,
+// its bits 30 to 62 hold the seconds since 1885, else ext holds the seconds
+// since year 1. The low 30 bits of wall hold the nanoseconds.
+const (
+	timeHasMonotonic = 1 << 63
+	timeWallToUnix   = 59453308800 - 62135596800
+	timeYear1ToUnix  = -62135596800
+)
+
+// decodeTime decodes the time.Time of type typ in buf.
+func decodeTime(typ dwarf.Type, buf []byte) (time.Time, bool) {
+	wallOff, err1 := fieldOffset(typ, []string{"wall"})
+	extOff, err2 := fieldOffset(typ, []string{"ext"})
+	if err1 != nil || err2 != nil {
+		return time.Time{}, false
+	}
+	wall, ext := bufUint(buf[wallOff:], 8), int64(bufUint(buf[extOff:], 8))
+	nsec := int64(wall & (1<<30 - 1))
+	sec := ext + timeYear1ToUnix
+	if wall&timeHasMonotonic != 0 {
+		sec = int64(wall<<1>>31) + timeWallToUnix
+	}
+	return time.Unix(sec, nsec), true
+}
+
+// formatTime formats the time.Time of type t in buf in RFC 3339, in its
+// location: UTC for a nil one, the debugger's for time.Local, which runs
+// on the same machine, and a named one as loaded from the zone database.
+// ok is false for a layout it doesn't know.
+func (d *Debugger) formatTime(pid int, t *dwarf.StructType, buf []byte) (string, bool) {
+	tm, ok := decodeTime(t, buf)
+	if !ok {
+		return "", false
+	}
+	tm = tm.UTC()
+	off, err := fieldOffset(t, []string{"loc"})
+	if err != nil {
+		return tm.Format(time.RFC3339Nano), true
+	}
+	loc := bufUint(buf[off:], 8)
+	if loc == 0 {
+		return tm.Format(time.RFC3339Nano), true
+	}
+	// A Location starts with its name.
+	name, err := d.readString(pid, loc)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("%s <location: %v>", tm.Format(time.RFC3339Nano), err), true
+	case name == "Local":
+		return tm.Local().Format(time.RFC3339Nano), true
+	case name == "UTC":
+		return tm.Format(time.RFC3339Nano), true
+	}
+	if l, err := time.LoadLocation(name); err == nil {
+		return tm.In(l).Format(time.RFC3339Nano), true
+	}
+	if offset, ok := d.fixedZoneOffset(pid, loc); ok {
+		return tm.In(time.FixedZone(name, offset)).Format(time.RFC3339Nano), true
+	}
+	return fmt.Sprintf("%s (%s)", tm.Format(time.RFC3339Nano), name), true
+}
+
+// fixedZoneOffset returns the offset from UTC of the time.Location at loc
+// if it has a single zone, as those of time.FixedZone do.
+func (d *Debugger) fixedZoneOffset(pid int, loc uint64) (int, bool) {
+	zones, err1 := d.FieldOffset("time.Location", "zone")
+	offset, err2 := d.FieldOffset("time.zone", "offset")
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	hdr := make([]byte, 16)
+	if err := d.ReadMemory(pid, loc+uint64(zones), hdr); err != nil || bufUint(hdr[8:], 8) != 1 {
+		return 0, false
+	}
+	v, err := d.readUint(pid, bufUint(hdr, 8)+uint64(offset), 8)
+	if err != nil {
+		return 0, false
+	}
+	return int(int64(v)), true
+}
@@ -0,0 +1,44 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/elf"
+	"syscall"
+)
+
+// tlsGOffset returns where the g of a thread is, relative to its thread
+// pointer, in exe. The runtime keeps it in the TLS block, which ends at the
+// thread pointer: at -8 when the Go linker lays the block out, and at the
+// offset of runtime.tlsg, tlsg, from the start of the block the external
+// linker made, as with cgo.
+func tlsGOffset(exe *elf.File, tlsg uint64) int64 {
+	for _, p := range exe.Progs {
+		if p.Type != elf.PT_TLS {
+			continue
+		}
+		align := max(p.Align, 1)
+		size := p.Memsz + (-p.Vaddr-p.Memsz)&(align-1)
+		return int64(tlsg) - int64(size)
+	}
+	return -8
+}
+
+// currentG returns the address of the g, the running goroutine, of the
+// thread pid stopped with regs, or 0 on a thread without one, such as one
+// of the runtime's own or the first before the runtime has started. It is
+// read from the thread's TLS, which is right wherever the thread stopped.
+// The register Go code keeps g in is only right in Go code with the
+// register ABI, not in assembly, C code or the runtime's system stack
+// switches, and is the fallback when the TLS can't be read.
+func (d *Debugger) currentG(pid int, regs *syscall.PtraceRegs) uint64 {
+	if tp := threadPointer(regs); tp != 0 {
+		if g, err := d.readUint(pid, uint64(int64(tp)+d.gOffset), 8); err == nil {
+			return g
+		}
+	}
+	if d.runtimeReady(pid) != nil {
+		return 0
+	}
+	return gRegister(regs)
+}
@@ -0,0 +1,183 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// TimelineEvent records a single stop of the target.
+type TimelineEvent struct {
+	Time time.Time
+	Pid  int
+	PC   uint64
+	Func string
+	File string
+	Line int
+}
+
+// SchedTraceConfig configures the capture of the target's runtime
+// execution trace. The target has to serve net/http/pprof on Addr.
+type SchedTraceConfig struct {
+	Addr   string        `json:"addr,omitempty"`
+	Window time.Duration `json:"window,omitempty"`
+	Out    string        `json:"out,omitempty"`
+}
+
+// schedTrace is the result of a finished trace capture.
+type schedTrace struct {
+	start, end time.Time
+	file       string
+	err        error
+}
+
+// defaultTimelineSize is how many stops the timeline keeps, unless set
+// otherwise with "set timeline".
+const defaultTimelineSize = 10000
+
+// RecordStop adds a stop to the session timeline, which keeps the last
+// TimelineSize, overwriting the oldest.
+func (d *Debugger) RecordStop(pid int, pc uint64) {
+	if d.TimelineSize <= 0 {
+		d.timeline, d.timelineNext = nil, 0
+		return
+	}
+	l := d.LookupPC(pc)
+	ev := TimelineEvent{Time: time.Now(), Pid: pid, PC: pc, Func: l.Func, File: l.File, Line: l.Line}
+	// A size changed since wrapping around: lay the stops out oldest first
+	// again, keeping the last.
+	if n := len(d.timeline); d.timelineNext != 0 && n != d.TimelineSize || n > d.TimelineSize {
+		tl := d.Timeline()
+		d.timeline, d.timelineNext = slices.Clone(tl[max(0, len(tl)-d.TimelineSize):]), 0
+	}
+	if len(d.timeline) < d.TimelineSize {
+		d.timeline = append(d.timeline, ev)
+		return
+	}
+	d.timeline[d.timelineNext] = ev
+	d.timelineNext = (d.timelineNext + 1) % len(d.timeline)
+}
+
+// Timeline returns the stops the session timeline keeps, oldest first.
+func (d *Debugger) Timeline() []TimelineEvent {
+	return append(slices.Clone(d.timeline[d.timelineNext:]), d.timeline[:d.timelineNext]...)
+}
+
+// StartSchedTrace fetches an execution trace of the configured window from
+// the target in the background. The target is usually not serving yet when
+// this is called, so the request is retried until it connects.
+func (d *Debugger) StartSchedTrace() {
+	if d.SchedTrace.Addr == "" || d.schedTraceDone != nil {
+		return
+	}
+
+	done := make(chan schedTrace, 1)
+	d.schedTraceDone = done
+	cfg := d.SchedTrace
+
+	go func() {
+		url := fmt.Sprintf("http://%s/debug/pprof/trace?seconds=%d", cfg.Addr, int(cfg.Window.Seconds()))
+		var res schedTrace
+		for tries := 0; tries < 50; tries++ {
+			res.start = time.Now()
+			var resp *http.Response
+			resp, res.err = http.Get(url)
+			if res.err != nil {
+				time.Sleep(200 * time.Millisecond)
+				continue
+			}
+			res.file, res.err = saveTrace(resp.Body, cfg.Out)
+			resp.Body.Close()
+			break
+		}
+		res.end = time.Now()
+		done <- res
+	}()
+}
+
+func saveTrace(r io.Reader, out string) (string, error) {
+	if out == "" {
+		out = filepath.Join(os.TempDir(), fmt.Sprintf("dedebugger-%d.trace", os.Getpid()))
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return out, err
+}
+
+// CheckSchedTrace prints the summary of a finished trace capture. When wait
+// is set it blocks until the capture completes.
+func (d *Debugger) CheckSchedTrace(wait bool) {
+	if d.schedTraceDone == nil {
+		return
+	}
+
+	var res schedTrace
+	if wait {
+		res = <-d.schedTraceDone
+	} else {
+		select {
+		case res = <-d.schedTraceDone:
+		default:
+			return
+		}
+	}
+	d.schedTraceDone = nil
+
+	if res.err != nil {
+		d.printf("Scheduling trace failed: %v\n", res.err)
+		return
+	}
+	d.printf("Scheduling trace written to %s\n", res.file)
+	d.summarizeSchedTrace(res)
+}
+
+// summarizeSchedTrace prints the scheduler latency profile of the trace and
+// the debugger stops that happened within the traced window.
+func (d *Debugger) summarizeSchedTrace(res schedTrace) {
+	profile := res.file + ".sched.pprof"
+	out, err := os.Create(profile)
+	if err != nil {
+		d.printf("Can't write scheduler profile: %v\n", err)
+		return
+	}
+	conv := exec.Command("go", "tool", "trace", "-pprof=sched", res.file)
+	conv.Stdout = out
+	conv.Stderr = os.Stderr
+	err = conv.Run()
+	out.Close()
+	if err != nil {
+		d.printf("go tool trace failed: %v\n", err)
+		return
+	}
+
+	d.println("Scheduling latency (time goroutines spent runnable before running):")
+	top := exec.Command("go", "tool", "pprof", "-top", "-nodecount=15", profile)
+	top.Stdout = os.Stdout
+	top.Stderr = os.Stderr
+	if err := top.Run(); err != nil {
+		d.printf("go tool pprof failed: %v\n", err)
+	}
+
+	d.println("Debugger stops during the traced window:")
+	for _, ev := range d.Timeline() {
+		if ev.Time.Before(res.start) || ev.Time.After(res.end) {
+			continue
+		}
+		where := unknownPC(ev.PC)
+		if ev.Func != "" {
+			where = fmt.Sprintf("%s line %d", ev.Func, ev.Line)
+		}
+		d.printf("  +%-10v %s (pid %d)\n", ev.Time.Sub(res.start).Round(time.Microsecond), where, ev.Pid)
+	}
+}
@@ -0,0 +1,222 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// traceOptions are the ptrace options of every tracee: threads it starts
+// and processes it forks are traced as well, and an exec is reported.
+const traceOptions = syscall.PTRACE_O_TRACECLONE | syscall.PTRACE_O_TRACEFORK | syscall.PTRACE_O_TRACEVFORK | syscall.PTRACE_O_TRACEEXEC
+
+// tracee is a thread the debugger traces: one of the target or of a
+// process it forked.
+type tracee struct {
+	tid int
+	// proc is the process the thread belongs to. mem is the process whose
+	// memory it runs in, its parent's for a vfork child until it execs.
+	proc, mem int
+	// starting is set until the SIGSTOP a new tracee starts with arrives.
+	starting bool
+	// held is set while stopThreads keeps it stopped. stopPending is set
+	// while the SIGSTOP it was sent is still to arrive.
+	held, stopPending bool
+	// signals counts the signals passed on to it.
+	signals int
+}
+
+// addTracee registers the thread tid of process proc, running in the
+// memory of mem.
+func (d *Debugger) addTracee(tid, proc, mem int) *tracee {
+	if d.tracees == nil {
+		d.tracees = make(map[int]*tracee)
+	}
+	t := &tracee{tid: tid, proc: proc, mem: mem}
+	d.tracees[tid] = t
+	return t
+}
+
+// traceeStop handles the stops that concern the tracees themselves rather
+// than the user: the first stop of a new thread or process, the clone,
+// fork and vfork events that announce them, and execs. Each thread is
+// continued on its own; the others run on meanwhile.
+func (d *Debugger) traceeStop(pid int) (string, bool, error) {
+	t := d.tracees[pid]
+	if t == nil {
+		// A new thread can report its first stop before its parent
+		// reports the clone. One already gone is taken for the target's.
+		proc := d.Pid
+		if tgid, err := strconv.Atoi(procStatusField(pid, "Tgid")); err == nil {
+			proc = tgid
+		}
+		t = d.addTracee(pid, proc, proc)
+		t.starting = true
+	}
+	sig := d.Ws.StopSignal()
+	if (t.starting || t.stopPending) && sig == syscall.SIGSTOP {
+		t.starting, t.stopPending = false, false
+		return "", true, wrapPtrace("continuing", pid, d.proc().Cont(pid, 0))
+	}
+	if sig != syscall.SIGTRAP {
+		return "", false, nil
+	}
+
+	switch cause := d.Ws.TrapCause(); cause {
+	case syscall.PTRACE_EVENT_CLONE, syscall.PTRACE_EVENT_FORK, syscall.PTRACE_EVENT_VFORK:
+		msg, err := syscall.PtraceGetEventMsg(pid)
+		if err != nil {
+			return "", true, wrapPtrace("reading the new thread of", pid, err)
+		}
+		child := int(msg)
+		c := d.tracees[child]
+		if c == nil {
+			c = d.addTracee(child, 0, 0)
+			c.starting = true
+		}
+		switch cause {
+		case syscall.PTRACE_EVENT_CLONE:
+			c.proc, c.mem = t.proc, t.mem
+		case syscall.PTRACE_EVENT_FORK:
+			c.proc, c.mem = child, child
+			d.printf("Process %d forked %d, followed with the same breakpoints\n", t.proc, child)
+		case syscall.PTRACE_EVENT_VFORK:
+			c.proc, c.mem = child, t.mem
+		}
+	case syscall.PTRACE_EVENT_EXEC:
+		return d.execStop(pid, t)
+	default:
+		return "", false, nil
+	}
+	return "", true, wrapPtrace("continuing", pid, d.proc().Cont(pid, 0))
+}
+
+// execStop handles the exec of the process of t, whose other threads are
+// gone. A child running another program is detached from; the target
+// running itself anew gets its breakpoints back, and running another
+// program loses them.
+func (d *Debugger) execStop(pid int, t *tracee) (string, bool, error) {
+	exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	for tid, o := range d.tracees {
+		if o.proc == t.proc {
+			delete(d.tracees, tid)
+		}
+	}
+	if t.proc != d.Pid {
+		d.printf("Process %d executed %s, detached\n", t.proc, exe)
+		return "", true, wrapPtrace("detaching", pid, syscall.PtraceDetach(pid))
+	}
+
+	d.addTracee(pid, pid, pid)
+	d.rearm, d.runtimeUp = false, false
+	if exe == d.target {
+		for addr, site := range d.traps {
+			site.armed = false
+			d.dirtyTraps[addr] = true
+		}
+		d.printf("The target executed itself again; its breakpoints are set again\n")
+	} else {
+		d.traps, d.dirtyTraps = nil, nil
+		d.printf("The target executed %s; its breakpoints no longer apply\n", exe)
+	}
+	return "", true, d.resume(pid, true)
+}
+
+// exitTracee forgets the thread pid, which exited. The exit of a forked
+// child is reported; that of a vfork child that didn't exec, such as the
+// runtime's probes, isn't.
+func (d *Debugger) exitTracee(pid int) {
+	t := d.tracees[pid]
+	delete(d.tracees, pid)
+	if t != nil && t.mem == pid && pid != d.Pid {
+		if d.Ws.Signaled() {
+			d.printf("Process %d killed by %v\n", pid, d.Ws.Signal())
+		} else {
+			d.printf("Process %d exited with status %d\n", pid, d.Ws.ExitStatus())
+		}
+	}
+}
+
+// passSignal continues the thread pid, stopped by a signal none of the
+// handlers wanted, with the signal. SIGSTOPs, which the debugger sends,
+// SIGTRAPs and the signals the policy ignores aren't passed on.
+func (d *Debugger) passSignal(pid int) error {
+	sig := d.Ws.StopSignal()
+	if sig == syscall.SIGSTOP || sig == syscall.SIGTRAP || d.Signals.Action(sig) == SignalIgnore {
+		sig = 0
+	}
+	if t := d.tracees[pid]; t != nil && sig != 0 {
+		t.signals++
+	}
+	return wrapPtrace("continuing", pid, d.proc().Cont(pid, int(sig)))
+}
+
+// otherMemories returns a process for each copy of the target's memory
+// other than the one pid runs in: the processes it forked, which have the
+// traps of the fork in theirs.
+func (d *Debugger) otherMemories(pid int) []int {
+	own := pid
+	if t := d.tracees[pid]; t != nil {
+		own = t.mem
+	}
+	seen := map[int]bool{own: true}
+	var mems []int
+	for _, t := range d.tracees {
+		if !seen[t.mem] {
+			seen[t.mem] = true
+			mems = append(mems, t.mem)
+		}
+	}
+	sort.Ints(mems)
+	return mems
+}
+
+// mirrorCode writes code at addr in the other copies of the target's
+// memory, which run on meanwhile, so the traps are the same in all.
+func (d *Debugger) mirrorCode(pid int, addr uint64, code []byte) error {
+	for _, mem := range d.otherMemories(pid) {
+		if _, err := (procMemory{mem}).WriteMemory(addr, code); err != nil {
+			return fmt.Errorf("process %d: %v", mem, err)
+		}
+	}
+	return nil
+}
+
+// threadsCommand handles "threads", which lists the traced threads by
+// process, marking the stopped one and noting those held stopped with it.
+func (d *Debugger) threadsCommand(pid int, args []string) bool {
+	tids := make([]int, 0, len(d.tracees))
+	for tid := range d.tracees {
+		tids = append(tids, tid)
+	}
+	sort.Slice(tids, func(i, j int) bool {
+		a, b := d.tracees[tids[i]], d.tracees[tids[j]]
+		return a.proc < b.proc || a.proc == b.proc && a.tid < b.tid
+	})
+	var b strings.Builder
+	for _, tid := range tids {
+		t := d.tracees[tid]
+		mark := " "
+		if tid == pid {
+			mark = "*"
+		}
+		fmt.Fprintf(&b, "%s Thread %d of process %d", mark, tid, t.proc)
+		if t.mem != t.proc {
+			fmt.Fprintf(&b, ", in the memory of %d", t.mem)
+		}
+		if t.signals > 0 {
+			fmt.Fprintf(&b, ", %d signals passed on", t.signals)
+		}
+		if t.held {
+			b.WriteString(", held stopped")
+		}
+		b.WriteByte('\n')
+	}
+	d.UI.ShowOutput(b.String())
+	return false
+}
@@ -0,0 +1,172 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// traceCommand handles "trace <location> [<message>]", which sets a
+// tracepoint: a breakpoint that logs a line at every hit and resumes the
+// target. The line has the time, the function and the line, then the
+// message, in which {expr} stands for the value of a variable, field or
+// register as print evaluates it, e.g. "trace work.go:12 i={i} rax={rax}".
+// A breakpoint already at the location becomes a tracepoint.
+// With --args the message goes on with the arguments of the function, and
+// with --ret the returns of the function at the location are logged too,
+// with the results and how long the call took.
+func (d *Debugger) traceCommand(pid int, args []string) bool {
+	if len(args) == 0 {
+		d.println("usage: trace [file:]line|function [--args] [--ret] [<message>]")
+		return false
+	}
+	withArgs, withRet := false, false
+	message := []string{}
+	for _, a := range args[1:] {
+		switch a {
+		case "--args", "-args":
+			withArgs = true
+		case "--ret", "-ret":
+			withRet = true
+		default:
+			message = append(message, a)
+		}
+	}
+	if _, err := parseTemplate(strings.Join(message, " ")); err != nil {
+		d.println(err)
+		return false
+	}
+	file, line, err := d.parseLocation(args[0])
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	b := d.breakpointAt(file, line)
+	if b == nil {
+		b, err = d.AddBreakpoint(pid, file, line, nil)
+		d.audit("", "breakpoint", pid, fmt.Sprintf("%s:%d (tracepoint)", file, line), err)
+		if err != nil {
+			d.printf("Can't set tracepoint at %s:%d: %v\n", file, line, err)
+			return false
+		}
+	}
+	if withArgs {
+		message = append(message, d.argsTemplate(b.Addr)...)
+	}
+	if err := d.SetBreakMode(b.ID, ModeTrace, message); err != nil {
+		d.println(err)
+		return false
+	}
+	if withRet {
+		if err := d.TraceReturns(b.ID, true); err != nil {
+			d.println(err)
+		}
+	}
+	d.printf("Tracepoint %d at %s (%#x)\n", b.ID, b, b.Addr)
+	return false
+}
+
+// argsTemplate returns the parts of a tracepoint message that show the
+// arguments of the function at pc, as name={name}.
+func (d *Debugger) argsTemplate(pc uint64) []string {
+	vars, err := d.Variables(pc)
+	if err != nil {
+		return nil
+	}
+	var parts []string
+	for _, v := range vars {
+		if v.Arg && !v.Result && v.Name != "_" && !strings.HasPrefix(v.Name, "~") {
+			parts = append(parts, fmt.Sprintf("%s={%s}", v.Name, v.Name))
+		}
+	}
+	return parts
+}
+
+// traceMessage returns the message of the tracepoint b for the hit the
+// thread pid, stopped at d.Regs, just made. Expressions that can't be
+// evaluated are shown as the error.
+func (d *Debugger) traceMessage(pid int, b *Breakpoint) string {
+	var s strings.Builder
+	for _, part := range b.message {
+		if part.path == nil {
+			s.WriteString(part.text)
+			continue
+		}
+		v, err := d.Eval(pid, strings.Join(part.path, "."))
+		if err != nil {
+			fmt.Fprintf(&s, "<%v>", err)
+			continue
+		}
+		fmt.Fprint(&s, v)
+	}
+	return s.String()
+}
+
+// logCommand handles `log <location> "<message>" [if <cond>]`, which sets
+// a tracepoint, as trace does, that logs only the hits where cond holds,
+// e.g. `log handler.go:40 "user={user.ID} took={elapsed}ms" if elapsed > 100`.
+// The message may be left unquoted when it has no " if ". Conditions are
+// those of "hits where".
+func (d *Debugger) logCommand(pid int, args []string) bool {
+	const usage = `usage: log [file:]line|function "<message>" [if <cond>]`
+	if len(args) < 2 {
+		d.println(usage)
+		return false
+	}
+	rest := strings.Join(args[1:], " ")
+	var message, cond string
+	if strings.HasPrefix(rest, `"`) {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			d.println(usage)
+			return false
+		}
+		message, _ = strconv.Unquote(quoted)
+		rest = strings.TrimSpace(rest[len(quoted):])
+		if rest != "" {
+			var ok bool
+			if cond, ok = strings.CutPrefix(rest, "if "); !ok {
+				d.println(usage)
+				return false
+			}
+		}
+	} else {
+		message, cond, _ = strings.Cut(rest, " if ")
+	}
+	if _, err := parseTemplate(message); err != nil {
+		d.println(err)
+		return false
+	}
+	if cond != "" {
+		if _, err := parseSampleConds(cond); err != nil {
+			d.println(err)
+			return false
+		}
+	}
+	file, line, err := d.parseLocation(args[0])
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	b := d.breakpointAt(file, line)
+	if b == nil {
+		b, err = d.AddBreakpoint(pid, file, line, nil)
+		d.audit("", "breakpoint", pid, fmt.Sprintf("%s:%d (logpoint)", file, line), err)
+		if err != nil {
+			d.printf("Can't set logpoint at %s:%d: %v\n", file, line, err)
+			return false
+		}
+	}
+	if err := d.SetBreakMode(b.ID, ModeTrace, []string{message}); err != nil {
+		d.println(err)
+		return false
+	}
+	if err := d.SetBreakCondition(b.ID, cond); err != nil {
+		d.println(err)
+		return false
+	}
+	d.printf("Logpoint %d at %s (%#x)\n", b.ID, b, b.Addr)
+	return false
+}
@@ -0,0 +1,270 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// pendingReturn is a call of a function whose returns are traced that
+// hasn't returned yet. The trap at its return address tells it apart from
+// the other calls returning there by its goroutine and its depth below the
+// top of the goroutine's stack, which stays the same when the runtime moves
+// a stack to grow it.
+type pendingReturn struct {
+	b     *Breakpoint // whose returns are logged, if any
+	edge  *callEdge   // of the call graph, if it is recorded
+	goid  uint64
+	depth uint64 // of the SP once returned
+	start time.Time
+}
+
+// TraceReturns makes breakpoint id, at the start of a function, log the
+// returns of the calls whose hits it takes: the results and how long the
+// call took. on == false stops it.
+func (d *Debugger) TraceReturns(id int, on bool) error {
+	b, err := d.breakpointByID(id)
+	if err != nil {
+		return err
+	}
+	if fn := d.SymTable.PCToFunc(b.Addr); on && (fn == nil || d.afterPrologue(fn) != b.Addr && fn.Entry != b.Addr) {
+		return fmt.Errorf("%s isn't the start of a function, whose returns could be traced", b)
+	}
+	b.Returns, b.results = on, nil
+	if vars, err := d.Variables(b.Addr); on && err == nil {
+		for _, v := range vars {
+			if v.Result {
+				b.results = append(b.results, v)
+			}
+		}
+	}
+	return nil
+}
+
+// goroutineDepth returns the goroutine the thread pid, stopped at d.Regs,
+// runs and how deep sp is below the top of its stack. When the goroutine
+// can't be read, the depth is sp negated, which grows as deeply as well.
+func (d *Debugger) goroutineDepth(pid int, sp uint64) (goid, depth uint64) {
+	g := d.currentG(pid, &d.Regs)
+	goidOff, err1 := d.FieldOffset("runtime.g", "goid")
+	stackOff, err2 := d.FieldOffset("runtime.g", "stack")
+	hiOff, err3 := d.FieldOffset("runtime.stack", "hi")
+	if g == 0 || err1 != nil || err2 != nil || err3 != nil {
+		return 0, -sp
+	}
+	goid, _ = d.readUint(pid, g+uint64(goidOff), 8)
+	hi, err := d.readUint(pid, g+uint64(stackOff+hiOff), 8)
+	if err != nil || hi < sp {
+		return goid, -sp
+	}
+	return goid, hi - sp
+}
+
+// callReturn returns the return address of the call the thread pid,
+// stopped at d.Regs at the entry of a function or at the end of its
+// prologue, just made, and the SP once it returns: the canonical frame
+// address, which frame information gives after the prologue and the entry
+// doesn't need.
+func (d *Debugger) callReturn(pid int) (ret, sp uint64, err error) {
+	sp = d.Regs.Rsp + 8
+	if fn := d.pcToFunc(d.Regs.Rip); fn == nil || fn.Entry != d.Regs.Rip {
+		if sp, err = d.cfa(d.Regs.Rip, &d.Regs); err != nil {
+			return 0, 0, err
+		}
+	}
+	ret, err = d.readUint(pid, sp-8, 8)
+	return ret, sp, err
+}
+
+// planReturn sets a trap at the return address of the call that the
+// thread pid, stopped at d.Regs on the entry of a function or the end of
+// its prologue, just made: to log it for b, if not nil, and to time the
+// edge of the call graph, if not nil.
+func (d *Debugger) planReturn(pid int, b *Breakpoint, edge *callEdge) {
+	if b == nil && edge == nil {
+		return
+	}
+	ret, sp, err := d.callReturn(pid)
+	if err != nil {
+		d.printf("Can't trace the return of the call at %#x: %v\n", d.Regs.Rip, err)
+		return
+	}
+	goid, depth := d.goroutineDepth(pid, sp)
+	// The runtime starts a function trapped at its entry again once it has
+	// grown its stack in the prologue, which is the same call.
+	if slices.ContainsFunc(d.returns[ret], func(r pendingReturn) bool {
+		return r.goid == goid && r.depth == depth
+	}) {
+		return
+	}
+	// Calls of the goroutine as deep as this one or deeper ended without
+	// returning, as a panic ends them.
+	d.dropReturns(goid, depth)
+	if err := d.addReturnTrap(ret); err != nil {
+		d.printf("Can't trace the return of the call at %#x: %v\n", d.Regs.Rip, err)
+		return
+	}
+	r := pendingReturn{b: b, edge: edge, goid: goid, depth: depth, start: time.Now()}
+	if edge != nil {
+		edge.calls++
+	}
+	if d.returns == nil {
+		d.returns = make(map[uint64][]pendingReturn)
+	}
+	d.returns[ret] = append(d.returns[ret], r)
+}
+
+// dropReturns forgets the calls of goroutine goid pending at depth or
+// deeper.
+func (d *Debugger) dropReturns(goid, depth uint64) {
+	for addr, pending := range d.returns {
+		kept := slices.DeleteFunc(pending, func(r pendingReturn) bool {
+			gone := r.goid == goid && r.depth >= depth
+			if gone {
+				d.removeTrap(addr)
+			}
+			return gone
+		})
+		if len(kept) == 0 {
+			delete(d.returns, addr)
+		} else {
+			d.returns[addr] = kept
+		}
+	}
+}
+
+// traceReturn logs the return of a traced call that the thread pid,
+// stopped at d.Regs, makes if addr is the return address of one. It reports
+// whether addr is, even for another call returning there.
+func (d *Debugger) traceReturn(pid int, addr uint64) bool {
+	pending := d.returns[addr]
+	if len(pending) == 0 {
+		return false
+	}
+	goid, depth := d.goroutineDepth(pid, d.Regs.Rsp)
+	i := slices.IndexFunc(pending, func(r pendingReturn) bool {
+		return r.goid == goid && r.depth == depth
+	})
+	if i < 0 {
+		return true
+	}
+	r := pending[i]
+	if pending = slices.Delete(pending, i, i+1); len(pending) == 0 {
+		delete(d.returns, addr)
+	} else {
+		d.returns[addr] = pending
+	}
+	d.removeTrap(addr)
+	took := time.Since(r.start).Round(time.Microsecond)
+	if r.edge != nil {
+		r.edge.time += took
+		r.edge.timed++
+	}
+	if r.b == nil || d.Breakpoints[r.b.Addr] != r.b || !r.b.Returns {
+		return true
+	}
+
+	ev := d.locationEvent("return", pid, r.b.Addr)
+	ev.Hits = r.b.Hits
+	if results := d.resultsText(pid, r.b.results); results != "" {
+		ev.Detail = fmt.Sprintf("returned %s after %v", results, took)
+	} else {
+		ev.Detail = fmt.Sprintf("returned after %v", took)
+	}
+	d.logHit(r.b, ev)
+	d.Emit(ev)
+	return true
+}
+
+// abiIntRegs are the integer registers of the Go register ABI on amd64, in
+// the order results are assigned to them.
+func abiIntRegs(regs *syscall.PtraceRegs) []uint64 {
+	return []uint64{regs.Rax, regs.Rbx, regs.Rcx, regs.Rdi, regs.Rsi, regs.R8, regs.R9, regs.R10, regs.R11}
+}
+
+// abiFloatRegs is the number of floating-point registers of the Go
+// register ABI on amd64.
+const abiFloatRegs = 15
+
+// abiAssigner lays results out of their registers as the Go register ABI
+// assigns them.
+type abiAssigner struct {
+	ints         []uint64
+	nint, nfloat int
+	// floats is set once a value took a floating-point register, which
+	// isn't read.
+	floats bool
+}
+
+// assign lays a value of type typ out of the next registers into buf at
+// off. It reports false if the value doesn't fit in the registers left, so
+// that the ABI passes it on the stack.
+func (a *abiAssigner) assign(typ dwarf.Type, buf []byte, off int64) bool {
+	switch t := stripTypedefs(typ).(type) {
+	case *dwarf.StructType:
+		for _, f := range t.Field {
+			if !a.assign(f.Type, buf, off+f.ByteOffset) {
+				return false
+			}
+		}
+		return true
+	case *dwarf.ArrayType:
+		switch t.Count {
+		case 0:
+			return true
+		case 1:
+			return a.assign(t.Type, buf, off)
+		}
+		return false
+	case *dwarf.FloatType:
+		a.nfloat, a.floats = a.nfloat+1, true
+		return a.nfloat <= abiFloatRegs
+	case *dwarf.ComplexType:
+		a.nfloat, a.floats = a.nfloat+2, true
+		return a.nfloat <= abiFloatRegs
+	}
+	size := typ.Size()
+	if size <= 0 || size > 8 || off+size > int64(len(buf)) || a.nint == len(a.ints) {
+		return false
+	}
+	var word [8]byte
+	binary.LittleEndian.PutUint64(word[:], a.ints[a.nint])
+	copy(buf[off:off+size], word[:size])
+	a.nint++
+	return true
+}
+
+// resultsText returns the results of a function the thread pid, stopped at
+// d.Regs, just returned from, as name=value for named ones. Only results in
+// the integer registers are read.
+func (d *Debugger) resultsText(pid int, results []*Variable) string {
+	a := abiAssigner{ints: abiIntRegs(&d.Regs)}
+	var parts []string
+	for _, v := range results {
+		size := v.Type.Size()
+		nint, nfloat := a.nint, a.nfloat
+		a.floats = false
+		var text string
+		if size < 0 || size > maxValueSize {
+			text = "<unknown size>"
+		} else if buf := make([]byte, size); !a.assign(v.Type, buf, 0) {
+			a.nint, a.nfloat = nint, nfloat
+			text = "<on the stack>"
+		} else if a.floats {
+			text = "<in a floating-point register>"
+		} else {
+			text = d.formatValue(pid, v.Type, buf, 0)
+		}
+		if !strings.HasPrefix(v.Name, "~") {
+			text = v.Name + "=" + text
+		}
+		parts = append(parts, text)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,129 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// trapSite is an address where the breakpoint, catchpoints or group
+// breakpoints want a trap. Users share one trap, so they can't save each
+// other's trap as the original code.
+type trapSite struct {
+	addr  uint64
+	orig  []byte // the code under the trap, once armed
+	users int
+	armed bool // whether the trap is in memory, or rewound awaiting re-arming
+}
+
+// addTrap asks for a trap at addr. Traps are written by flushTraps, in one
+// pass for everything set up at a prompt.
+func (d *Debugger) addTrap(addr uint64) error {
+	return d.planTrap(addr, false)
+}
+
+// addReturnTrap asks for a trap at addr, the return address of a call. A
+// call returns to the start of an instruction, so addr needs no decoding to
+// tell.
+func (d *Debugger) addReturnTrap(addr uint64) error {
+	return d.planTrap(addr, true)
+}
+
+func (d *Debugger) planTrap(addr uint64, afterCall bool) error {
+	if err := d.Arch.CheckTrapAddr(addr); err != nil {
+		return err
+	}
+	if !afterCall {
+		if err := d.checkInstruction(addr); err != nil {
+			return err
+		}
+	}
+	if site := d.trapOverlap(addr); site != nil {
+		return fmt.Errorf("a trap at %#x would overlap the one at %#x", addr, site.addr)
+	}
+	if d.traps == nil {
+		d.traps = make(map[uint64]*trapSite)
+		d.dirtyTraps = make(map[uint64]bool)
+	}
+	site, ok := d.traps[addr]
+	if !ok {
+		site = &trapSite{addr: addr}
+		d.traps[addr] = site
+	}
+	site.users++
+	d.dirtyTraps[addr] = true
+	return nil
+}
+
+// removeTrap drops a user of the trap at addr. The last one removes it at
+// the next flushTraps.
+func (d *Debugger) removeTrap(addr uint64) {
+	if site, ok := d.traps[addr]; ok && site.users > 0 {
+		site.users--
+		d.dirtyTraps[addr] = true
+	}
+}
+
+// flushTraps brings the traps of pid in line with what was asked for since
+// the last flush: new ones are written and verified, unused ones restored.
+// Untouched traps aren't written, so this is cheap with hundreds of them.
+// Traps that can't be armed are dropped and reported in the error.
+func (d *Debugger) flushTraps(pid int) error {
+	if len(d.dirtyTraps) == 0 {
+		return nil
+	}
+	addrs := make([]uint64, 0, len(d.dirtyTraps))
+	for addr := range d.dirtyTraps {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	var errs []error
+	for _, addr := range addrs {
+		site := d.traps[addr]
+		rewound := d.rearm && d.rearmAt == addr
+		switch {
+		case site.users > 0 && !site.armed:
+			orig := make([]byte, len(d.Arch.TrapCode))
+			err := d.ReadMemory(pid, addr, orig)
+			if err == nil {
+				err = d.writeTrap(pid, addr, orig)
+			}
+			if err == nil {
+				err = d.mirrorCode(pid, addr, d.Arch.TrapCode)
+			}
+			if err != nil {
+				delete(d.traps, addr)
+				file, line, _ := d.pcToLine(addr)
+				errs = append(errs, fmt.Errorf("can't arm the breakpoint at %s:%d (%#x): %v", file, line, addr, err))
+				continue
+			}
+			site.orig, site.armed = orig, true
+		case site.users == 0:
+			if site.armed && !rewound {
+				if _, err := d.ReplaceCode(pid, addr, site.orig); err != nil {
+					errs = append(errs, fmt.Errorf("can't remove the breakpoint at %#x: %v", addr, err))
+				}
+				if err := d.mirrorCode(pid, addr, site.orig); err != nil {
+					errs = append(errs, fmt.Errorf("can't remove the breakpoint at %#x: %v", addr, err))
+				}
+			}
+			if rewound {
+				d.rearm = false
+			}
+			delete(d.traps, addr)
+		}
+	}
+	clear(d.dirtyTraps)
+	return errors.Join(errs...)
+}
+
+// armedTrap returns the trap at addr if it is armed.
+func (d *Debugger) armedTrap(addr uint64) *trapSite {
+	if site, ok := d.traps[addr]; ok && site.armed {
+		return site
+	}
+	return nil
+}
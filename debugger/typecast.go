@@ -0,0 +1,123 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// typeTags are the DWARF entries that define a type that can be named in a
+// conversion.
+var typeTags = map[dwarf.Tag]bool{
+	dwarf.TagBaseType:    true,
+	dwarf.TagStructType:  true,
+	dwarf.TagTypedef:     true,
+	dwarf.TagPointerType: true,
+	dwarf.TagArrayType:   true,
+}
+
+// LookupType returns the DWARF type named name, such as "main.Header" or
+// "example.com/app/wire.Header". A name whose package isn't a full import
+// path, as "wire.Header", matches the one type of that name under any
+// path, and a name without a package is looked up in main. The names are
+// indexed once, on the first lookup.
+func (d *Debugger) LookupType(name string) (dwarf.Type, error) {
+	if d.Dwarf == nil {
+		return nil, ErrNoDWARF
+	}
+	d.cacheMu.Lock()
+	if d.typeNames == nil {
+		d.typeNames = make(map[string]dwarf.Offset)
+		r := d.Dwarf.Reader()
+		for {
+			entry, err := r.Next()
+			if err != nil || entry == nil {
+				break
+			}
+			if entry.Tag == dwarf.TagCompileUnit {
+				continue
+			}
+			if entry.Children {
+				r.SkipChildren()
+			}
+			// Pointer types are made from the names they point to.
+			if n, _ := entry.Val(dwarf.AttrName).(string); n != "" && n[0] != '*' && typeTags[entry.Tag] {
+				if _, ok := d.typeNames[n]; !ok {
+					d.typeNames[n] = entry.Offset
+				}
+			}
+		}
+	}
+	off, ok := d.typeNames[name]
+	if !ok && !strings.Contains(name, ".") {
+		off, ok = d.typeNames["main."+name]
+	}
+	var matches []string
+	if !ok {
+		for n := range d.typeNames {
+			if strings.HasSuffix(n, "/"+name) {
+				matches = append(matches, n)
+			}
+		}
+		if len(matches) == 1 {
+			off, ok = d.typeNames[matches[0]], true
+		}
+	}
+	d.cacheMu.Unlock()
+	switch {
+	case len(matches) > 1:
+		return nil, fmt.Errorf("%s is ambiguous: %s", name, strings.Join(matches, ", "))
+	case !ok:
+		return nil, fmt.Errorf("type %s not found", name)
+	}
+	return d.Dwarf.Type(off)
+}
+
+// castType parses the pointer type of a conversion, as "(*wire.Header)" in
+// "(*wire.Header)(0xc000123456)", after its opening parenthesis, and
+// returns it with ok set. Otherwise, when what follows isn't a pointer
+// type and a parenthesized operand, it leaves the parser where it was.
+func (p *exprParser) castType() (typ dwarf.Type, ok bool, err error) {
+	i, stars := p.pos, 0
+	for i < len(p.toks) && p.toks[i] == "*" {
+		i, stars = i+1, stars+1
+	}
+	if stars == 0 || i >= len(p.toks) || !isExprName(p.toks[i]) {
+		return nil, false, nil
+	}
+	parts := []string{p.toks[i]}
+	for i += 1; i+1 < len(p.toks) && p.toks[i] == "." && isExprName(p.toks[i+1]); i += 2 {
+		parts = append(parts, p.toks[i+1])
+	}
+	if i+1 >= len(p.toks) || p.toks[i] != ")" || p.toks[i+1] != "(" {
+		return nil, false, nil
+	}
+	name := strings.Join(parts, ".")
+	if typ, err = p.d.LookupType(name); err != nil {
+		return nil, false, err
+	}
+	for range stars {
+		name = "*" + name
+		typ = &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: 8, Name: name}, Type: typ}
+	}
+	p.pos = i + 1
+	return typ, true, nil
+}
+
+// convert returns the pointer of type typ holding the address v is, a
+// pointer or an integer.
+func (d *Debugger) convert(v value, typ dwarf.Type) (value, error) {
+	var addr uint64
+	switch t := stripTypedefs(v.typ).(type) {
+	case *dwarf.PtrType:
+		addr = bufUint(v.buf, 8)
+	case *dwarf.IntType, *dwarf.UintType:
+		addr = bufUint(v.buf, t.Size())
+	default:
+		return value{}, fmt.Errorf("can't convert %s to %s", typeName(v.typ), typeName(typ))
+	}
+	return value{typ: typ, buf: binary.LittleEndian.AppendUint64(nil, addr)}, nil
+}
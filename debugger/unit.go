@@ -0,0 +1,72 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// UnitProcess returns the main process of the systemd unit and the binary
+// its ExecStart runs, as systemctl shows them. A unit that isn't running has
+// no main process.
+func UnitProcess(unit string) (int, string, error) {
+	if !strings.Contains(unit, ".") {
+		unit += ".service"
+	}
+	show := func(prop string) (string, error) {
+		out, err := exec.Command("systemctl", "show", "--property="+prop, "--value", unit).Output()
+		if err != nil {
+			return "", fmt.Errorf("systemctl show %s: %v", unit, commandError(err))
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	mainPID, err := show("MainPID")
+	if err != nil {
+		return 0, "", err
+	}
+	pid, err := strconv.Atoi(mainPID)
+	if err != nil || pid == 0 {
+		return 0, "", fmt.Errorf("%s has no main process; is it running?", unit)
+	}
+	execStart, err := show("ExecStart")
+	if err != nil {
+		return 0, "", err
+	}
+	return pid, execStartPath(execStart), nil
+}
+
+// execStartPath returns the binary of the first command of an ExecStart
+// property as systemctl shows it, "{ path=/usr/bin/app ; argv[]=... }", or
+// "" if there is none.
+func execStartPath(prop string) string {
+	_, rest, ok := strings.Cut(prop, "path=")
+	if !ok {
+		return ""
+	}
+	path, _, _ := strings.Cut(rest, " ;")
+	return strings.TrimSpace(path)
+}
+
+// attachExe returns the executable whose symbols Attach reads for pid: the
+// unit's binary, set with -unit, if the process runs it, /proc/<pid>/exe
+// otherwise. The binary may have been replaced since the service started,
+// by an upgrade; the one the process runs is what matters.
+func (d *Debugger) attachExe(pid int) string {
+	exe := fmt.Sprintf("/proc/%d/exe", pid)
+	if d.unitExe == "" {
+		return exe
+	}
+	running, err := os.Stat(exe)
+	if err != nil {
+		return exe
+	}
+	if bin, err := os.Stat(d.unitExe); err != nil || !os.SameFile(bin, running) {
+		d.printf("Process %d doesn't run %s as it is now, reading the symbols of the binary it runs\n", pid, d.unitExe)
+		return exe
+	}
+	return d.unitExe
+}
@@ -0,0 +1,22 @@
+//go:build !(linux && amd64)
+
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Debugger is a placeholder on hosts the ptrace backend doesn't support, so
+// that the command still builds there and says why it can't run.
+type Debugger struct{}
+
+func NewDebugger() *Debugger {
+	return &Debugger{}
+}
+
+func (d *Debugger) Run() {
+	fmt.Fprintf(os.Stderr, "dedebugger runs on linux/amd64 only, not %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	os.Exit(1)
+}
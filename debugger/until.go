@@ -0,0 +1,79 @@
+//go:build linux && amd64
+
+package debugger
+
+import "fmt"
+
+// tbreakCommand handles "tbreak <location>", which sets a temporary
+// breakpoint: one deleted at its first hit that stops. Without a location,
+// it is asked for.
+func (d *Debugger) tbreakCommand(pid int, args []string) bool {
+	if len(args) == 0 {
+		loc, ok := d.promptLocation(pid)
+		if !ok {
+			return false
+		}
+		args = []string{loc}
+	}
+	if len(args) != 1 {
+		d.println("usage: tbreak [file:]line|function")
+		return false
+	}
+	file, line, err := d.parseLocation(args[0])
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	b, err := d.AddBreakpoint(pid, file, line, nil)
+	d.audit("", "breakpoint", pid, fmt.Sprintf("%s:%d (temporary)", file, line), err)
+	if err != nil {
+		d.printf("Can't set breakpoint at %s:%d: %v\n", file, line, err)
+		return false
+	}
+	b.Temporary = true
+	d.printf("Temporary breakpoint %d at %s (%#x)\n", b.ID, b, b.Addr)
+	return false
+}
+
+// untilCommand handles "until <location>", which continues the target
+// until it reaches the location, through a temporary breakpoint there.
+// The breakpoint is deleted at the next stop, wherever it is, or when the
+// target exits. A breakpoint already at the location does the job itself.
+// It reports whether the target continues.
+func (d *Debugger) untilCommand(pid int, args []string) bool {
+	if len(args) != 1 {
+		d.println("usage: until [file:]line|function")
+		return false
+	}
+	file, line, err := d.parseLocation(args[0])
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	if b := d.breakpointAt(file, line); b != nil {
+		if !b.Enabled {
+			d.printf("Breakpoint %d at %s:%d is disabled\n", b.ID, file, line)
+			return false
+		}
+		return true
+	}
+	b, err := d.AddBreakpoint(pid, file, line, nil)
+	d.audit("", "breakpoint", pid, fmt.Sprintf("%s:%d (until)", file, line), err)
+	if err != nil {
+		d.printf("Can't run until %s:%d: %v\n", file, line, err)
+		return false
+	}
+	b.Temporary = true
+	d.until = b.ID
+	return true
+}
+
+// endUntil deletes the breakpoint of an until in progress, if any.
+func (d *Debugger) endUntil() {
+	if d.until == 0 {
+		return
+	}
+	// Gone already if the stop was its hit.
+	d.RemoveBreakpoint(d.until)
+	d.until = 0
+}
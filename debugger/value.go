@@ -0,0 +1,450 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// maxStringLen bounds how much of a string is read from the target.
+const maxStringLen = 1 << 16
+
+// ReadValue decodes the value of type typ stored at addr. Integers, floats,
+// bools and strings are returned as Go values, pointers as their address.
+func (d *Debugger) ReadValue(pid int, addr uint64, typ dwarf.Type) (any, error) {
+	switch t := typ.(type) {
+	case *dwarf.TypedefType:
+		return d.ReadValue(pid, addr, t.Type)
+	case *dwarf.IntType:
+		v, err := d.readUint(pid, addr, t.ByteSize)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*uint(t.ByteSize)
+		return int64(v<<shift) >> shift, nil
+	case *dwarf.UintType:
+		return d.readUint(pid, addr, t.ByteSize)
+	case *dwarf.CharType:
+		return d.readUint(pid, addr, t.ByteSize)
+	case *dwarf.UcharType:
+		return d.readUint(pid, addr, t.ByteSize)
+	case *dwarf.BoolType:
+		v, err := d.readUint(pid, addr, 1)
+		return v != 0, err
+	case *dwarf.FloatType:
+		v, err := d.readUint(pid, addr, t.ByteSize)
+		if t.ByteSize == 4 {
+			return float64(math.Float32frombits(uint32(v))), err
+		}
+		return math.Float64frombits(v), err
+	case *dwarf.PtrType:
+		return d.readUint(pid, addr, 8)
+	case *dwarf.StructType:
+		if t.StructName == "string" {
+			return d.readString(pid, addr)
+		}
+		return nil, fmt.Errorf("can't print struct %s as a value", t.StructName)
+	}
+	return nil, fmt.Errorf("unsupported type %s", typ)
+}
+
+func (d *Debugger) readUint(pid int, addr uint64, size int64) (uint64, error) {
+	if size <= 0 || size > 8 {
+		return 0, fmt.Errorf("bad integer size %d", size)
+	}
+	buf := make([]byte, 8)
+	if err := d.ReadMemory(pid, addr, buf[:size]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+func (d *Debugger) readString(pid int, addr uint64) (string, error) {
+	hdr := make([]byte, 16)
+	if err := d.ReadMemory(pid, addr, hdr); err != nil {
+		return "", err
+	}
+	data := binary.LittleEndian.Uint64(hdr[:8])
+	n := binary.LittleEndian.Uint64(hdr[8:])
+	if n == 0 {
+		return "", nil
+	}
+	if n > maxStringLen {
+		n = maxStringLen
+	}
+	buf := make([]byte, n)
+	if err := d.ReadMemory(pid, data, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// LookupGlobal returns the address and type of the package level variable
+// name, e.g. "main.counter". Lookups are remembered, those that failed
+// too, since each walks the whole of .debug_info.
+func (d *Debugger) LookupGlobal(name string) (uint64, dwarf.Type, error) {
+	d.cacheMu.Lock()
+	g, ok := d.globals[name]
+	d.cacheMu.Unlock()
+	if !ok {
+		g.addr, g.typ, g.err = d.lookupGlobal(name)
+		d.cacheMu.Lock()
+		if d.globals == nil {
+			d.globals = make(map[string]globalVar)
+		}
+		d.globals[name] = g
+		d.cacheMu.Unlock()
+	}
+	if g.err != nil {
+		return 0, nil, g.err
+	}
+	return d.loadAddr(g.addr), g.typ, nil
+}
+
+// globalVar is the result of looking up a package level variable, with the
+// variable at its link address.
+type globalVar struct {
+	addr uint64
+	typ  dwarf.Type
+	err  error
+}
+
+func (d *Debugger) lookupGlobal(name string) (uint64, dwarf.Type, error) {
+	if d.Dwarf == nil {
+		return 0, nil, ErrNoDWARF
+	}
+
+	r := d.Dwarf.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return 0, nil, err
+		}
+		if entry == nil {
+			break
+		}
+		switch entry.Tag {
+		case dwarf.TagCompileUnit:
+			continue
+		case dwarf.TagVariable:
+		default:
+			if entry.Children {
+				r.SkipChildren()
+			}
+			continue
+		}
+		if n, _ := entry.Val(dwarf.AttrName).(string); n != name {
+			continue
+		}
+
+		// Globals are located with a single DW_OP_addr.
+		loc, _ := entry.Val(dwarf.AttrLocation).([]byte)
+		if len(loc) != 9 || loc[0] != 0x03 {
+			return 0, nil, fmt.Errorf("%s has no static address", name)
+		}
+		off, _ := entry.Val(dwarf.AttrType).(dwarf.Offset)
+		typ, err := d.Dwarf.Type(off)
+		if err != nil {
+			return 0, nil, err
+		}
+		return binary.LittleEndian.Uint64(loc[1:]), typ, nil
+	}
+	return 0, nil, fmt.Errorf("variable %s not found", name)
+}
+
+// Eval evaluates expr at the current stop of pid. An expression is a
+// register name, or one of variables as print takes them: fields
+// ("main.cfg.Timeout"), indexing ("buf[3]", `m["k"]`), dereference ("*p"),
+// address ("&x"), len, cap and conversions to pointer types
+// ("(*wire.Header)(addr)"); pointers are followed implicitly. Values
+// without a Go scalar form, such as structs and slices, are returned
+// formatted.
+func (d *Debugger) Eval(pid int, expr string) (any, error) {
+	if v, ok := registerValue(&d.Regs, expr); ok {
+		return v, nil
+	}
+	val, err := d.evalValue(pid, expr)
+	if err != nil {
+		return nil, err
+	}
+	return d.goValue(pid, val)
+}
+
+// Limits on how much of a composite value is printed.
+const (
+	maxPrintElems = 64
+	maxPrintDepth = 3
+)
+
+// selectPath selects the struct fields path from val, following pointers.
+// Values held in registers have their fields taken from the bytes read.
+func (d *Debugger) selectPath(pid int, val value, path []string) (value, error) {
+	for _, field := range path {
+		typ := val.typ
+		for {
+			if t, ok := typ.(*dwarf.TypedefType); ok {
+				typ = t.Type
+				continue
+			}
+			p, ok := typ.(*dwarf.PtrType)
+			if !ok {
+				break
+			}
+			if len(val.buf) < 8 {
+				return value{}, fmt.Errorf("short pointer selecting %s", field)
+			}
+			addr := binary.LittleEndian.Uint64(val.buf)
+			if addr == 0 {
+				return value{}, fmt.Errorf("nil pointer dereference selecting %s", field)
+			}
+			var err error
+			if val, err = d.readValueAt(pid, addr, p.Type); err != nil {
+				return value{}, err
+			}
+			typ = p.Type
+		}
+
+		t, ok := typ.(*dwarf.StructType)
+		if !ok {
+			return value{}, fmt.Errorf("can't select %s from %s", field, typ)
+		}
+		var found *dwarf.StructField
+		for _, f := range t.Field {
+			if f.Name == field {
+				found = f
+				break
+			}
+		}
+		if found == nil {
+			return value{}, fmt.Errorf("%s has no field %s", t.StructName, field)
+		}
+		start := found.ByteOffset
+		end := start + found.Type.Size()
+		if start < 0 || end > int64(len(val.buf)) {
+			return value{}, fmt.Errorf("field %s is outside the value read", field)
+		}
+		sel := value{typ: found.Type, buf: val.buf[start:end]}
+		if val.addr != 0 {
+			sel.addr = val.addr + uint64(start)
+		}
+		val = sel
+	}
+	return val, nil
+}
+
+// goValue returns val as the Go value ReadValue would: integers, floats,
+// bools and strings as themselves, pointers as their address. Other values
+// are returned formatted.
+func (d *Debugger) goValue(pid int, val value) (any, error) {
+	typ := val.typ
+	for {
+		t, ok := typ.(*dwarf.TypedefType)
+		if !ok {
+			break
+		}
+		typ = t.Type
+	}
+	switch t := typ.(type) {
+	case *dwarf.IntType:
+		return bufInt(val.buf, t.ByteSize), nil
+	case *dwarf.UintType, *dwarf.CharType, *dwarf.UcharType:
+		return bufUint(val.buf, typ.Size()), nil
+	case *dwarf.BoolType:
+		return bufUint(val.buf, 1) != 0, nil
+	case *dwarf.FloatType:
+		return bufFloat(val.buf, t.ByteSize), nil
+	case *dwarf.PtrType:
+		return bufUint(val.buf, 8), nil
+	case *dwarf.StructType:
+		if t.StructName == "string" {
+			return d.stringFromHeader(pid, val.buf)
+		}
+	}
+	return d.formatValue(pid, val.typ, val.buf, 0), nil
+}
+
+// formatValue formats a value of type typ held in buf the way Go would
+// print it with %+v, with pointers shown as addresses. Elements of slices
+// and arrays past maxPrintElems and values nested deeper than
+// maxPrintDepth are elided.
+func (d *Debugger) formatValue(pid int, typ dwarf.Type, buf []byte, depth int) string {
+	if typ.Size() > int64(len(buf)) {
+		return fmt.Sprintf("<%s: only %d of %d bytes read>", typ, len(buf), typ.Size())
+	}
+	switch t := typ.(type) {
+	case *dwarf.TypedefType:
+		return d.formatValue(pid, t.Type, buf, depth)
+	case *dwarf.IntType:
+		if t.Name == "time.Duration" {
+			return time.Duration(bufInt(buf, t.ByteSize)).String()
+		}
+		return fmt.Sprint(bufInt(buf, t.ByteSize))
+	case *dwarf.UintType, *dwarf.CharType, *dwarf.UcharType:
+		return fmt.Sprint(bufUint(buf, typ.Size()))
+	case *dwarf.BoolType:
+		return fmt.Sprint(bufUint(buf, 1) != 0)
+	case *dwarf.FloatType:
+		return fmt.Sprint(bufFloat(buf, t.ByteSize))
+	case *dwarf.PtrType:
+		p := bufUint(buf, 8)
+		if p == 0 {
+			return "nil"
+		}
+		if s, ok := d.formatHTTPPointer(pid, t, p, depth); ok {
+			return s
+		}
+		return fmt.Sprintf("(%s)(%#x)", t, p)
+	case *dwarf.ArrayType:
+		if t.Count < 0 {
+			break
+		}
+		return d.formatElems(pid, t.Type, buf, t.Count, t.Count, depth)
+	case *dwarf.StructType:
+		if p := d.printers[t.StructName]; p != nil {
+			return d.formatPrinter(pid, p, t, buf, depth)
+		}
+		switch {
+		case t.StructName == "string":
+			s, err := d.stringFromHeader(pid, buf)
+			if err != nil {
+				return fmt.Sprintf("<string: %v>", err)
+			}
+			return fmt.Sprintf("%q", s)
+		case strings.HasPrefix(t.StructName, "[]"):
+			return d.formatSlice(pid, t, buf, depth)
+		case t.StructName == "time.Time":
+			if s, ok := d.formatTime(pid, t, buf); ok {
+				return s
+			}
+		case strings.HasPrefix(t.StructName, "sync."):
+			if s, ok := d.formatSync(pid, t, buf); ok {
+				return s
+			}
+		case strings.HasPrefix(t.StructName, "net/http."):
+			if s, ok := d.formatHTTP(pid, t, buf, depth); ok {
+				return s
+			}
+		}
+		return d.formatStruct(pid, t, buf, depth)
+	}
+	return fmt.Sprintf("<%s % x>", typ, buf[:typ.Size()])
+}
+
+func (d *Debugger) formatStruct(pid int, t *dwarf.StructType, buf []byte, depth int) string {
+	if depth >= maxPrintDepth {
+		return t.StructName + " {...}"
+	}
+	var b strings.Builder
+	b.WriteString(t.StructName + " {")
+	for i, f := range t.Field {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		end := f.ByteOffset + f.Type.Size()
+		if f.ByteOffset < 0 || end > int64(len(buf)) {
+			fmt.Fprintf(&b, "%s: <outside the struct>", f.Name)
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s", f.Name, d.formatValue(pid, f.Type, buf[f.ByteOffset:end], depth+1))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// formatSlice formats a slice header, whose fields are array, len and cap,
+// with the elements it points to.
+func (d *Debugger) formatSlice(pid int, t *dwarf.StructType, buf []byte, depth int) string {
+	var array, n, capacity uint64
+	var elem dwarf.Type
+	for _, f := range t.Field {
+		v := bufUint(buf[f.ByteOffset:], 8)
+		switch f.Name {
+		case "array":
+			array = v
+			if p, ok := f.Type.(*dwarf.PtrType); ok {
+				elem = p.Type
+			}
+		case "len":
+			n = v
+		case "cap":
+			capacity = v
+		}
+	}
+	if elem == nil {
+		return fmt.Sprintf("<%s % x>", t, buf)
+	}
+	prefix := fmt.Sprintf("%s len: %d, cap: %d, ", t.StructName, n, capacity)
+	if array == 0 {
+		return prefix + "nil"
+	}
+	if depth >= maxPrintDepth {
+		return prefix + "[...]"
+	}
+
+	shown := min(n, maxPrintElems)
+	size := max(elem.Size(), 0)
+	data := make([]byte, min(int64(shown)*size, maxValueSize))
+	if err := d.ReadMemory(pid, array, data); err != nil {
+		return prefix + fmt.Sprintf("<%v>", err)
+	}
+	return prefix + d.formatElems(pid, elem, data, int64(n), int64(len(data))/max(size, 1), depth)
+}
+
+// formatElems formats the first shown of n elements of type elem in buf.
+func (d *Debugger) formatElems(pid int, elem dwarf.Type, buf []byte, n, shown int64, depth int) string {
+	if depth >= maxPrintDepth && n > 0 {
+		return "[...]"
+	}
+	size := elem.Size()
+	shown = min(shown, maxPrintElems)
+	var b strings.Builder
+	b.WriteString("[")
+	for i := int64(0); i < shown; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(d.formatValue(pid, elem, buf[i*size:(i+1)*size], depth+1))
+	}
+	if n > shown {
+		fmt.Fprintf(&b, ", ...+%d more", n-shown)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// stringFromHeader reads the string whose header, data pointer and length,
+// is in buf.
+func (d *Debugger) stringFromHeader(pid int, buf []byte) (string, error) {
+	data, n := bufUint(buf, 8), bufUint(buf[8:], 8)
+	if n == 0 {
+		return "", nil
+	}
+	s := make([]byte, min(n, maxStringLen))
+	if err := d.ReadMemory(pid, data, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+func bufUint(buf []byte, size int64) uint64 {
+	var b [8]byte
+	copy(b[:], buf[:min(size, 8)])
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func bufInt(buf []byte, size int64) int64 {
+	shift := 64 - 8*uint(size)
+	return int64(bufUint(buf, size)<<shift) >> shift
+}
+
+func bufFloat(buf []byte, size int64) float64 {
+	if size == 4 {
+		return float64(math.Float32frombits(uint32(bufUint(buf, 4))))
+	}
+	return math.Float64frombits(bufUint(buf, 8))
+}
@@ -0,0 +1,141 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// normalizers rewrite the parts of a session transcript that change from
+// run to run, such as addresses and process IDs.
+var normalizers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`0x[0-9a-fA-F]+`), "0xADDR"},
+	{regexp.MustCompile(`\b[0-9A-F]{8,16}\b`), "ADDR"},
+	{regexp.MustCompile(`\bpid \d+`), "pid N"},
+	{regexp.MustCompile(`\b\d+(\.\d+)?(ns|µs|ms|s)\b`), "DURATION"},
+}
+
+// Normalize makes a session transcript comparable across runs.
+func Normalize(out string) string {
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		for _, n := range normalizers {
+			line = n.re.ReplaceAllString(line, n.repl)
+		}
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n"
+}
+
+// Verify implements "verify <script> [-golden file] [-update] <target>". It
+// runs the debugger on target with the script as its input and compares the
+// normalized transcript with the golden file, returning the exit code.
+func Verify(args []string) int {
+	if len(args) < 1 {
+		fmt.Println("usage: verify <script> [-golden file] [-update] <target>")
+		return 2
+	}
+	script := args[0]
+
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	golden := flags.String("golden", strings.TrimSuffix(script, ".ddb")+".golden", "expected transcript")
+	update := flags.Bool("update", false, "rewrite the golden file with the current output")
+	flags.Parse(args[1:])
+	if flags.NArg() < 1 {
+		fmt.Println("usage: verify <script> [-golden file] [-update] <target>")
+		return 2
+	}
+
+	input, err := os.ReadFile(script)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command(self, flags.Args()...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(&out, "debugger exited: %v\n", err)
+	}
+	got := Normalize(out.String())
+
+	if *update {
+		if err := os.WriteFile(*golden, []byte(got), 0o644); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+		fmt.Printf("Updated %s\n", *golden)
+		return 0
+	}
+
+	want, err := os.ReadFile(*golden)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	if got == Normalize(string(want)) {
+		fmt.Printf("ok %s\n", script)
+		return 0
+	}
+
+	fmt.Printf("FAIL %s: output differs from %s\n", script, *golden)
+	for _, line := range diffLines(strings.Split(Normalize(string(want)), "\n"), strings.Split(got, "\n")) {
+		fmt.Println(line)
+	}
+	return 1
+}
+
+// diffLines returns a line diff of a and b, with removed lines prefixed by
+// "-", added lines by "+" and common lines by " ".
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	return out
+}
@@ -0,0 +1,212 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Watch stops the target when a sampled metric of the running process
+// crosses a threshold.
+type Watch struct {
+	Metric   string
+	Op       string
+	Limit    uint64
+	Interval time.Duration
+
+	read metricFunc
+}
+
+// watchHit is sent by a watch poller when its threshold was crossed.
+type watchHit struct {
+	w     *Watch
+	value uint64
+}
+
+type metricFunc func(d *Debugger, pid int) (uint64, error)
+
+// watchMetrics are the metrics that can be used with "watch".
+var watchMetrics = map[string]metricFunc{
+	"goroutines": goroutineCount,
+}
+
+// watchCommand handles "watch <metric> <op> <value>" and "watch [-rw]
+// <expr>" or "watch [-rw] <address> [size]", which watch memory for writes
+// or any access, or lists the active watches when called without
+// arguments.
+func (d *Debugger) watchCommand(pid int, args []string) bool {
+	if len(args) == 0 {
+		for i, w := range d.Watches {
+			d.printf("  %d: %s %s %d (every %v)\n", i, w.Metric, w.Op, w.Limit, w.Interval)
+		}
+		d.listDataWatches()
+		return false
+	}
+	if _, ok := watchMetrics[args[0]]; !ok && len(args) <= 2 || args[0] == "-rw" {
+		d.dataWatchCommand(pid, args)
+		return false
+	}
+
+	w, err := ParseWatch(strings.Join(args, " "))
+	if err != nil {
+		d.println(err)
+		return false
+	}
+	d.AddWatch(pid, w)
+	d.printf("Watching %s %s %d\n", w.Metric, w.Op, w.Limit)
+	return false
+}
+
+// ParseWatch parses a watch expression such as "goroutines > 10000".
+func ParseWatch(s string) (*Watch, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("usage: watch <metric> <op> <value>")
+	}
+
+	read, ok := watchMetrics[fields[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric %q", fields[0])
+	}
+	switch fields[1] {
+	case ">", ">=":
+	default:
+		return nil, fmt.Errorf("unsupported operator %q", fields[1])
+	}
+	limit, err := parseQuantity(fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Watch{
+		Metric:   fields[0],
+		Op:       fields[1],
+		Limit:    limit,
+		Interval: 100 * time.Millisecond,
+		read:     read,
+	}, nil
+}
+
+// parseQuantity parses a number with an optional K/M/G(B) suffix.
+func parseQuantity(s string) (uint64, error) {
+	mult := uint64(1)
+	upper := strings.TrimSuffix(strings.ToUpper(s), "B")
+	switch {
+	case strings.HasSuffix(upper, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(upper, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(upper, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		upper = upper[:len(upper)-1]
+	}
+
+	n, err := strconv.ParseUint(upper, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	return n * mult, nil
+}
+
+func (w *Watch) exceeded(v uint64) bool {
+	if w.Op == ">=" {
+		return v >= w.Limit
+	}
+	return v > w.Limit
+}
+
+// AddWatch registers w and starts polling it. When the threshold is crossed
+// the target is stopped with SIGSTOP and the hit is reported at the stop.
+// The watch fires again only after the value dropped below the limit.
+func (d *Debugger) AddWatch(pid int, w *Watch) {
+	if d.watchHits == nil {
+		d.watchHits = make(chan watchHit, 16)
+	}
+	d.Watches = append(d.Watches, w)
+
+	go func() {
+		ticker := time.NewTicker(w.Interval)
+		defer ticker.Stop()
+
+		armed := true
+		for range ticker.C {
+			v, err := w.read(d, pid)
+			if os.IsNotExist(err) {
+				return
+			}
+			if err != nil {
+				continue
+			}
+
+			if !w.exceeded(v) {
+				armed = true
+				continue
+			}
+			if armed {
+				armed = false
+				d.watchHits <- watchHit{w, v}
+				syscall.Kill(pid, syscall.SIGSTOP)
+			}
+		}
+	}()
+}
+
+// checkWatches reports the watches that fired since the last stop. It
+// reports whether any did.
+func (d *Debugger) checkWatches() bool {
+	fired := false
+	for {
+		select {
+		case hit := <-d.watchHits:
+			d.printf("Watch triggered: %s = %d (%s %d)\n", hit.w.Metric, hit.value, hit.w.Op, hit.w.Limit)
+			fired = true
+		default:
+			return fired
+		}
+	}
+}
+
+// goroutineCount counts the goroutines that haven't exited: the entries of
+// runtime.allgs whose status isn't _Gdead. Exited goroutines stay there to
+// be reused, so its length, runtime.allglen, is the peak of live goroutines
+// rather than the current number, which is what matters for catching leaks.
+// The target runs on while they are read.
+func goroutineCount(d *Debugger, pid int) (uint64, error) {
+	addr, ok := d.Symbols["runtime.allgs"]
+	if !ok {
+		return 0, fmt.Errorf("runtime.allgs not found")
+	}
+	status, err := d.FieldOffset("runtime.g", "atomicstatus")
+	if err != nil {
+		return 0, err
+	}
+	header := make([]byte, 16)
+	if err := d.readRunning(pid, addr, header); err != nil {
+		return 0, err
+	}
+	array, n := binary.LittleEndian.Uint64(header), binary.LittleEndian.Uint64(header[8:])
+	ptrs := make([]byte, min(n, maxGoroutines)*8)
+	if err := d.readRunning(pid, array, ptrs); err != nil {
+		return 0, err
+	}
+	var live uint64
+	buf := make([]byte, 4)
+	for i := 0; i < len(ptrs); i += 8 {
+		g := binary.LittleEndian.Uint64(ptrs[i:])
+		if err := d.readRunning(pid, g+uint64(status), buf); err != nil {
+			return 0, err
+		}
+		if uint64(binary.LittleEndian.Uint32(buf))&^gScan != gDead {
+			live++
+		}
+	}
+	return live, nil
+}
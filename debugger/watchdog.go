@@ -0,0 +1,154 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultWaitTimeout is WaitTimeout unless -wait-timeout says otherwise.
+const defaultWaitTimeout = 10 * time.Second
+
+// threadStates names the states of /proc/<pid>/stat.
+var threadStates = map[string]string{
+	"R": "running",
+	"S": "sleeping",
+	"D": "in uninterruptible sleep",
+	"T": "stopped",
+	"t": "stopped by the debugger",
+	"Z": "a zombie",
+	"X": "dead",
+	"I": "idle",
+}
+
+// awaitStop notes that the thread tid, or any thread for 0, was asked to
+// stop, from when the watchdog counts. A stop already awaited keeps its
+// start.
+func (d *Debugger) awaitStop(tid int) {
+	if d.stallSince.IsZero() {
+		d.stallSince, d.stallTid = time.Now(), tid
+	}
+}
+
+// stopArrived stops the watchdog counting if the event ws of wpid is the
+// stop awaited, or the end of the target.
+func (d *Debugger) stopArrived(wpid int, ws syscall.WaitStatus) {
+	if d.stallSince.IsZero() {
+		return
+	}
+	ended := (ws.Exited() || ws.Signaled()) && wpid == d.Pid
+	stopped := ws.Stopped() && (wpid == d.stallTid || d.stallTid == 0 && ws.StopSignal() == syscall.SIGSTOP)
+	if ended || stopped {
+		d.stallSince, d.stallReport, d.stalled = time.Time{}, time.Time{}, false
+	}
+}
+
+// checkStall reports the target unresponsive if a stop has been awaited
+// for longer than WaitTimeout, as when a thread is wedged in the kernel in
+// uninterruptible sleep, where no signal reaches it, and again every
+// WaitTimeout after. The report shows the state of each thread and where
+// in the kernel it waits, and offers to kill the target or detach from it.
+// With an asynchronous frontend, whose prompt is reading, the choices are
+// typed as the commands kill and detach; other frontends on a terminal are
+// asked.
+func (d *Debugger) checkStall(pid int, async bool) {
+	if d.WaitTimeout <= 0 || d.stallSince.IsZero() {
+		return
+	}
+	if time.Since(d.stallSince) < d.WaitTimeout || time.Since(d.stallReport) < d.WaitTimeout {
+		return
+	}
+	d.stallReport = time.Now()
+	d.printf("Target has been unresponsive for %d seconds\n", int(time.Since(d.stallSince).Seconds()))
+	d.UI.ShowOutput(describeThreads(d.Pid))
+	if async {
+		if !d.stalled {
+			d.println("Type kill to kill it, detach to leave it running without the debugger, or keep waiting")
+		}
+		d.stalled = true
+		return
+	}
+	d.stalled = true
+	// Scripts, which answer yes, and other frontends only get the report.
+	switch ui := d.UI.(type) {
+	case *LineFrontend:
+		if !ui.interactive {
+			return
+		}
+	case *KeyFrontend:
+	default:
+		return
+	}
+	switch {
+	case d.UI.Confirm("Kill the target?"):
+		d.killStalled()
+	case d.UI.Confirm("Detach from the target?"):
+		d.detachCommand(pid, nil)
+	}
+}
+
+// killStalled kills the target and the processes it forked that are
+// traced. Their exits are waited for by the wait loop as usual; a thread
+// in uninterruptible sleep only dies once it wakes.
+func (d *Debugger) killStalled() {
+	procs := map[int]bool{d.Pid: true}
+	for _, t := range d.tracees {
+		procs[t.proc] = true
+	}
+	delete(procs, 0)
+	for p := range procs {
+		syscall.Kill(p, syscall.SIGKILL)
+	}
+	d.println("Killed the target; waiting for it to exit")
+}
+
+// describeThreads lists the threads of pid with their state and the kernel
+// function they wait in, from /proc, such as
+// "  thread 1234: in uninterruptible sleep, in nfs_wait_bit_killable".
+func describeThreads(pid int) string {
+	tids, err := threads(pid)
+	if err != nil {
+		return fmt.Sprintf("  can't read the threads of %d: %v\n", pid, err)
+	}
+	var b strings.Builder
+	for _, tid := range tids {
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/task/%d/stat", pid, tid))
+		if err != nil {
+			continue
+		}
+		// The command name may contain spaces; the state follows it.
+		fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+		if len(fields) == 0 {
+			continue
+		}
+		state, ok := threadStates[fields[0]]
+		if !ok {
+			state = "in state " + fields[0]
+		}
+		fmt.Fprintf(&b, "  thread %d: %s", tid, state)
+		wchan, _ := os.ReadFile(fmt.Sprintf("/proc/%d/task/%d/wchan", pid, tid))
+		if w := strings.TrimSpace(string(wchan)); w != "" && w != "0" {
+			fmt.Fprintf(&b, ", in %s", w)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// setWaitTimeout handles "set wait-timeout <duration>|off".
+func (d *Debugger) setWaitTimeout(arg string) {
+	if arg == "off" {
+		d.WaitTimeout = 0
+		return
+	}
+	t, err := time.ParseDuration(arg)
+	if err != nil || t <= 0 {
+		d.println("usage: set wait-timeout <duration>|off")
+		return
+	}
+	d.WaitTimeout = t
+}
@@ -0,0 +1,181 @@
+//go:build linux && amd64
+
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// syscallFuncs are functions of the Go runtime and syscall package that
+// make a system call, where injectSyscall finds an instruction to make its
+// own from.
+var syscallFuncs = []string{
+	"internal/runtime/syscall.Syscall6",
+	"runtime/internal/syscall.Syscall6",
+	"runtime.usleep",
+	"runtime.futex",
+	"runtime.madvise",
+	"syscall.RawSyscall6",
+}
+
+// writeCode writes code at addr of the stopped thread pid and reads it back.
+// Ptrace writes go through the protection of text pages, except on kernels
+// hardened not to let them, where they fail or don't take. A page mapped
+// without write is then made writable for the write, by an mprotect the
+// target is made to call, and protected again after. Kernels that also
+// forbid writable code, such as with PaX MPROTECT or SELinux's execmod,
+// refuse that too, which is reported rather than the bare failure.
+func (d *Debugger) writeCode(pid int, addr uint64, code []byte) error {
+	err := d.writeChecked(pid, addr, code)
+	if err == nil || errors.Is(err, ErrReadOnly) {
+		return err
+	}
+	start, end, perms, merr := mappingAt(pid, addr)
+	if merr != nil || strings.Contains(perms, "w") {
+		return err
+	}
+	// Only the pages written are unprotected, not the whole mapping.
+	lo := max(start, addr&^(pageSize-1))
+	hi := min(end, (addr+uint64(len(code))+pageSize-1)&^(pageSize-1))
+	prot := protOf(perms)
+	if _, perr := d.injectSyscall(pid, syscall.SYS_MPROTECT, lo, hi-lo, prot|syscall.PROT_WRITE); perr != nil {
+		return fmt.Errorf("%v; the code at %#x is write-protected, and making it writable failed: %v", err, addr, perr)
+	}
+	err = d.writeChecked(pid, addr, code)
+	if _, perr := d.injectSyscall(pid, syscall.SYS_MPROTECT, lo, hi-lo, prot); perr != nil && err == nil {
+		err = fmt.Errorf("restoring the protection of the code at %#x: %v", addr, perr)
+	}
+	return err
+}
+
+// writeChecked writes data at addr of pid and reads it back: writes to
+// shared or read-only text can fail without an error.
+func (d *Debugger) writeChecked(pid int, addr uint64, data []byte) error {
+	if err := d.WriteMemory(pid, addr, data); err != nil {
+		return err
+	}
+	check := make([]byte, len(data))
+	if err := d.ReadMemory(pid, addr, check); err != nil {
+		return err
+	}
+	if !bytes.Equal(check, data) {
+		return fmt.Errorf("write at %#x didn't take, read back % x", addr, check)
+	}
+	return nil
+}
+
+// mappingAt returns the bounds and permissions, as "r-xp", of the mapping
+// of pid holding addr.
+func mappingAt(pid int, addr uint64) (start, end uint64, perms string, err error) {
+	maps, err := os.ReadFile(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return 0, 0, "", err
+	}
+	// start-end perms offset dev inode path
+	s := bufio.NewScanner(bytes.NewReader(maps))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		lo, hi, _ := strings.Cut(fields[0], "-")
+		start, err1 := strconv.ParseUint(lo, 16, 64)
+		end, err2 := strconv.ParseUint(hi, 16, 64)
+		if err1 == nil && err2 == nil && start <= addr && addr < end {
+			return start, end, fields[1], nil
+		}
+	}
+	return 0, 0, "", fmt.Errorf("%#x: %w", addr, ErrUnmapped)
+}
+
+// protOf returns the PROT_ flags of the permissions of a mapping.
+func protOf(perms string) uint64 {
+	var prot uint64
+	for i, p := range []uint64{syscall.PROT_READ, syscall.PROT_WRITE, syscall.PROT_EXEC} {
+		if i < len(perms) && perms[i] != '-' {
+			prot |= p
+		}
+	}
+	return prot
+}
+
+// syscallSite returns the address of a system call instruction in the code
+// of the target, which isn't changed to make a call: the code may be what
+// can't be written.
+func (d *Debugger) syscallSite(pid int) (uint64, error) {
+	for _, name := range syscallFuncs {
+		fn := d.SymTable.LookupFunc(name)
+		if fn == nil || fn.End <= fn.Entry {
+			continue
+		}
+		code := make([]byte, min(fn.End-fn.Entry, 4096))
+		if err := d.ReadMemory(pid, fn.Entry, code); err != nil {
+			continue
+		}
+		d.originalCode(fn.Entry, code)
+		for off := 0; ; off++ {
+			i := bytes.Index(code[off:], syscallInsn)
+			if i < 0 {
+				break
+			}
+			off += i
+			if addr := fn.Entry + uint64(off); d.checkInstruction(addr) == nil && d.armedTrap(addr) == nil {
+				return addr, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no system call instruction in the target to make the call from")
+}
+
+// injectSyscall makes the stopped thread pid call the system call nr with
+// args, from an instruction of its own, and puts its registers back after.
+// Signals arriving meanwhile are delivered when it resumes.
+func (d *Debugger) injectSyscall(pid int, nr uint64, args ...uint64) (uint64, error) {
+	if d.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	site, err := d.syscallSite(pid)
+	if err != nil {
+		return 0, err
+	}
+	var saved syscall.PtraceRegs
+	if err := d.proc().GetRegs(pid, &saved); err != nil {
+		return 0, wrapPtrace("reading registers of", pid, err)
+	}
+	regs := saved
+	setSyscallRegs(&regs, site, nr, args)
+	if err := d.proc().SetRegs(pid, &regs); err != nil {
+		return 0, wrapPtrace("setting registers of", pid, err)
+	}
+	defer d.proc().SetRegs(pid, &saved)
+	for {
+		if err := d.proc().Step(pid); err != nil {
+			return 0, wrapPtrace("single-stepping", pid, err)
+		}
+		var ws syscall.WaitStatus
+		if _, err := d.proc().Wait(pid, &ws, nil); err != nil {
+			return 0, fmt.Errorf("waiting for thread %d: %w", pid, err)
+		}
+		if !ws.Stopped() {
+			return 0, fmt.Errorf("thread %d ended making system call %d", pid, nr)
+		}
+		if s := ws.StopSignal(); s == syscall.SIGTRAP {
+			break
+		} else if !d.droppedInterrupt(s) {
+			// The step is stopped short by the signal; it is held for
+			// the thread's resume.
+			d.stopSig = s
+		}
+	}
+	if err := d.proc().GetRegs(pid, &regs); err != nil {
+		return 0, wrapPtrace("reading registers of", pid, err)
+	}
+	return syscallResult(&regs)
+}
@@ -1,3 +1,5 @@
 module github.com/abhishekshree/dedebugger
 
-go 1.21.5
+go 1.25.0
+
+require golang.org/x/arch v0.27.1-0.20260521044007-9c1a596a2c97